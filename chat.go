@@ -0,0 +1,148 @@
+// Package llm provides a fluent builder for chat requests on top of the
+// client package.
+//
+//	res, err := llm.NewChat().
+//		System("You are a terse assistant.").
+//		User("What is the capital of France?").
+//		Temperature(0.3).
+//		Send(ctx, c)
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+var ErrNoResponse = errors.New("no response content to decode")
+
+// Chatter sends a chat request. *client.Client satisfies this interface.
+type Chatter interface {
+	Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error)
+}
+
+// ChatBuilder builds a chat request step by step. Messages are sent in the
+// order they are added.
+type ChatBuilder struct {
+	messages []types.Message
+	opts     []types.RequestOption
+}
+
+// NewChat starts a new chat request
+func NewChat() *ChatBuilder {
+	return &ChatBuilder{}
+}
+
+// System adds a system message
+func (b *ChatBuilder) System(content string) *ChatBuilder {
+	return b.Message(types.SystemMessage(content))
+}
+
+// User adds a user message
+func (b *ChatBuilder) User(content string) *ChatBuilder {
+	return b.Message(types.UserMessage(content))
+}
+
+// Assistant adds an assistant message, for example a few-shot example reply
+func (b *ChatBuilder) Assistant(content string) *ChatBuilder {
+	return b.Message(types.AssistantMessage(content))
+}
+
+// Message adds arbitrary messages
+func (b *ChatBuilder) Message(msgs ...types.Message) *ChatBuilder {
+	b.messages = append(b.messages, msgs...)
+	return b
+}
+
+// Tool makes tools available to the model
+func (b *ChatBuilder) Tool(tools ...types.Tool) *ChatBuilder {
+	return b.With(types.WithTools(tools...))
+}
+
+// Temperature sets the sampling temperature
+func (b *ChatBuilder) Temperature(t float32) *ChatBuilder {
+	return b.With(types.WithTemperature(t))
+}
+
+// MaxTokens sets the maximum number of tokens to generate
+func (b *ChatBuilder) MaxTokens(n int) *ChatBuilder {
+	return b.With(types.WithMaxTokens(n))
+}
+
+// TopP sets nucleus sampling
+func (b *ChatBuilder) TopP(p float32) *ChatBuilder {
+	return b.With(types.WithTopP(p))
+}
+
+// Stop sets the stop sequences
+func (b *ChatBuilder) Stop(stop ...string) *ChatBuilder {
+	return b.With(types.WithStop(stop...))
+}
+
+// Timeout overrides the client timeout for the request
+func (b *ChatBuilder) Timeout(d time.Duration) *ChatBuilder {
+	return b.With(types.WithRequestTimeout(d))
+}
+
+// With applies request options not covered by the builder methods
+func (b *ChatBuilder) With(opts ...types.RequestOption) *ChatBuilder {
+	b.opts = append(b.opts, opts...)
+	return b
+}
+
+// Request returns a new request built from the current state. The builder
+// can be reused afterwards.
+func (b *ChatBuilder) Request() *types.ChatRequest {
+	msgs := append([]types.Message(nil), b.messages...)
+	return types.NewChatRequest(msgs, b.opts...)
+}
+
+// Send builds the request and sends it with c
+func (b *ChatBuilder) Send(ctx context.Context, c Chatter) (*Result, error) {
+	resp, err := c.Chat(ctx, b.Request())
+	if err != nil {
+		return nil, err
+	}
+	return &Result{ChatResponse: resp}, nil
+}
+
+// Result is the response to a built chat request
+type Result struct {
+	*types.ChatResponse
+}
+
+// Text returns the reply content
+func (r *Result) Text() string {
+	return r.Message.Content
+}
+
+// ToolCalls returns the tools the model asked to run, if any
+func (r *Result) ToolCalls() []types.ToolCall {
+	return r.Message.ToolCalls
+}
+
+// Decode unmarshals a JSON reply into v. A surrounding Markdown code fence,
+// which models often add, is ignored.
+func (r *Result) Decode(v any) error {
+	text := strings.TrimSpace(r.Text())
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```")
+		if i := strings.IndexByte(text, '\n'); i >= 0 {
+			text = text[i+1:]
+		}
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+	}
+	if text == "" {
+		return ErrNoResponse
+	}
+
+	if err := json.Unmarshal([]byte(text), v); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+type stubChatter struct {
+	req   *types.ChatRequest
+	reply string
+}
+
+func (s *stubChatter) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	s.req = req
+	return &types.ChatResponse{
+		Response: types.Response{
+			Message: types.Message{Role: types.RoleAssistant, Content: s.reply},
+		},
+	}, nil
+}
+
+func TestChatBuilder_Send(t *testing.T) {
+	tool := types.Tool{Name: "get_weather"}
+	chatter := &stubChatter{reply: "Paris"}
+
+	res, err := NewChat().
+		System("Be brief.").
+		User("Capital of France?").
+		Tool(tool).
+		Temperature(0.3).
+		MaxTokens(10).
+		Send(context.Background(), chatter)
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	if res.Text() != "Paris" {
+		t.Errorf("Text() = %q, want %q", res.Text(), "Paris")
+	}
+
+	want := &types.ChatRequest{
+		Messages: []types.Message{
+			{Role: types.RoleSystem, Content: "Be brief."},
+			{Role: types.RoleUser, Content: "Capital of France?"},
+		},
+		Tools:       []types.Tool{tool},
+		Temperature: types.Float32(0.3),
+		MaxTokens:   10,
+	}
+	if !reflect.DeepEqual(chatter.req, want) {
+		t.Errorf("sent request = %+v, want %+v", chatter.req, want)
+	}
+}
+
+func TestChatBuilder_RequestIsCopy(t *testing.T) {
+	b := NewChat().User("Hello")
+	first := b.Request()
+	first.Messages[0].Content = "changed"
+
+	if got := b.Request().Messages[0].Content; got != "Hello" {
+		t.Errorf("second Request() content = %q, want %q", got, "Hello")
+	}
+}
+
+func TestResult_Decode(t *testing.T) {
+	type answer struct {
+		City string `json:"city"`
+	}
+
+	tests := []struct {
+		name    string
+		reply   string
+		want    answer
+		wantErr bool
+	}{
+		{"plain json", `{"city":"Paris"}`, answer{City: "Paris"}, false},
+		{"fenced json", "```json\n{\"city\":\"Paris\"}\n```", answer{City: "Paris"}, false},
+		{"invalid", "Paris", answer{}, true},
+		{"empty", "", answer{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &Result{ChatResponse: &types.ChatResponse{
+				Response: types.Response{Message: types.Message{Content: tt.reply}},
+			}}
+
+			var got answer
+			err := res.Decode(&got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.want)
+			}
+			if tt.reply == "" && !errors.Is(err, ErrNoResponse) {
+				t.Errorf("Decode() error = %v, want %v", err, ErrNoResponse)
+			}
+		})
+	}
+}
@@ -2,11 +2,22 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
 
 	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/internal/keyring"
+	"github.com/ksred/llm/internal/ratelimit"
+	"github.com/ksred/llm/internal/redact"
+	"github.com/ksred/llm/internal/tokenizer"
 	"github.com/ksred/llm/models/anthropic"
 	"github.com/ksred/llm/models/openai"
+	"github.com/ksred/llm/pkg/cache"
+	"github.com/ksred/llm/pkg/cost"
 	"github.com/ksred/llm/pkg/types"
 )
 
@@ -29,6 +40,35 @@ type Provider interface {
 type Client struct {
 	config   *config.Config
 	provider Provider
+	limiter  *ratelimit.Limiter
+	budget   *cost.BudgetGuard
+	idem     *idempotencyStore
+	logger   *slog.Logger
+	sem      *slots              // nil when concurrency is unlimited
+	adaptive *ratelimit.Adaptive // nil unless adaptive rate limiting is on
+	keys     *keyring.Ring       // nil unless several API keys are configured
+	secrets  *redact.Redactor    // removes API keys from returned errors
+	health   *healthTracker
+
+	// reloaded holds the settings of the last Reload, nil until then
+	reloaded atomic.Pointer[settings]
+	reloadMu sync.Mutex
+
+	mu       sync.RWMutex
+	closed   bool
+	inflight sync.WaitGroup
+
+	closeOnce sync.Once
+
+	// halt is cancelled when Shutdown gives up waiting for requests
+	haltOnce sync.Once
+	halt     context.Context
+	abort    context.CancelFunc
+
+	active     atomic.Int64  // requests and streams in flight
+	queued     atomic.Int64  // requests waiting for the rate limiter or a slot
+	stopGauges chan struct{} // closed by Close to stop periodic gauges
+	gaugesDone chan struct{} // closed when periodic gauges have stopped
 }
 
 // NewClient creates a new LLM client with the given configuration
@@ -37,33 +77,114 @@ func NewClient(cfg *config.Config) (*Client, error) {
 		return nil, fmt.Errorf("configuration is required")
 	}
 
+	keyList := append([]string{cfg.APIKey}, cfg.APIKeys...)
+	secrets := redact.New(keyList...)
+	logger := newLogger(cfg.Logger, keyList...)
+	cfg = upgradeModel(cfg, logger)
+
+	// Providers report retries and errors through their metrics callbacks,
+	// so those are wrapped to redact the errors and log retries
+	providerCfg := cfg
+	if cfg.Metrics != nil || logger != nil {
+		copied := *providerCfg
+		copied.Metrics = redactingMetrics(secrets, cfg.Metrics)
+		if logger != nil {
+			copied.Metrics = loggingMetrics(logger, copied.Metrics)
+		}
+		providerCfg = &copied
+	}
+
+	// Several API keys are rotated in the providers' transport, so each
+	// retry can fail over to another key
+	var keys *keyring.Ring
+	if len(cfg.APIKeys) > 1 {
+		var onChange func(types.KeyHealth)
+		if cfg.Metrics != nil && cfg.Metrics.OnKeyHealth != nil {
+			onChange = func(h types.KeyHealth) { cfg.Metrics.OnKeyHealth(cfg.Provider, h) }
+		}
+		keys = keyring.New(cfg.APIKeys, onChange)
+		providerCfg = withTransport(providerCfg, keys.Transport)
+	}
+
+	// Adaptive rate limiting paces every HTTP attempt, including retries, so
+	// it is installed in the providers' transport
+	var adaptive *ratelimit.Adaptive
+	if cfg.RateLimit != nil && cfg.RateLimit.Adaptive {
+		adaptiveCfg := ratelimit.AdaptiveConfig{
+			InitialRate: float64(cfg.RateLimit.RequestsPerMinute) / 60,
+		}
+		if cfg.RateLimit.Shared {
+			adaptive = ratelimit.SharedAdaptive(ratelimit.SharedKey(cfg.Provider, cfg.APIKey), adaptiveCfg)
+		} else {
+			adaptive = ratelimit.NewAdaptive(adaptiveCfg)
+		}
+		providerCfg = withTransport(providerCfg, adaptive.Transport)
+	}
+
 	// Create provider based on configuration
 	var provider Provider
 	switch cfg.Provider {
 	case "openai":
-		p, err := openai.NewProvider(cfg)
+		p, err := openai.NewProvider(providerCfg)
 		if err != nil {
 			return nil, fmt.Errorf("creating OpenAI provider: %w", err)
 		}
 		provider = p
 	case "anthropic":
-		p, err := anthropic.NewProvider(cfg)
+		p, err := anthropic.NewProvider(providerCfg)
 		if err != nil {
 			return nil, fmt.Errorf("creating Anthropic provider: %w", err)
 		}
 		provider = p
 	case "mock":
 		return &Client{
-			config: cfg,
+			config:  cfg,
+			logger:  logger,
+			secrets: secrets,
+			health:  newHealthTracker(cfg.Health),
 		}, nil
 	default:
 		return nil, fmt.Errorf("unsupported provider: %s", cfg.Provider)
 	}
 
-	return &Client{
+	c := &Client{
 		config:   cfg,
 		provider: provider,
-	}, nil
+		logger:   logger,
+		secrets:  secrets,
+		health:   newHealthTracker(cfg.Health),
+	}
+	c.limiter = newLimiter(cfg.Provider, cfg.APIKey, cfg.RateLimit)
+	if cfg.CostControl != nil {
+		c.budget = cost.NewBudgetGuard(cfg.CostControl.MaxCostPerRequest, cfg.CostControl.MaxCostPerDay)
+		c.budget.SetCurrency(cfg.CostControl.Currency)
+	}
+	if cfg.IdempotencyTTL > 0 {
+		c.idem = newIdempotencyStore(cfg.IdempotencyTTL)
+	}
+	if cfg.MaxConcurrentRequests > 0 {
+		c.sem = newSlots(cfg.MaxConcurrentRequests)
+	}
+	c.adaptive = adaptive
+	c.keys = keys
+
+	if cfg.Warmup != nil {
+		ctx, cancel, _ := c.withTimeout(context.Background(), 0)
+		err := c.Warmup(ctx)
+		cancel()
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("warming up %s provider: %w", cfg.Provider, secrets.Error(err))
+		}
+	}
+
+	if cfg.GaugeInterval > 0 && cfg.Metrics != nil && cfg.Metrics.OnGauges != nil {
+		c.stopGauges = make(chan struct{})
+		c.gaugesDone = make(chan struct{})
+		go c.reportGauges(cfg.GaugeInterval)
+	}
+
+	return c, nil
 }
 
 // Complete generates a completion for the given prompt
@@ -72,7 +193,77 @@ func (c *Client) Complete(ctx context.Context, req *types.CompletionRequest) (*t
 		return nil, err
 	}
 
-	return c.provider.Complete(ctx, req)
+	if err := c.begin(); err != nil {
+		return nil, err
+	}
+	defer c.end()
+
+	ctx, cancel, timeout := c.withTimeout(ctx, req.Timeout)
+	ctx = withPriority(ctx, req.Priority)
+	defer cancel()
+
+	ctx, obs := c.observe(ctx, "complete", req)
+
+	var resp *types.CompletionResponse
+	var err error
+	if c.idem != nil {
+		req = completionWithIdempotencyKey(req)
+		resp, err = doIdempotent(ctx, c.idem, "complete:"+req.IdempotencyKey, func() (*types.CompletionResponse, error) {
+			return c.complete(ctx, req)
+		})
+	} else {
+		resp, err = c.complete(ctx, req)
+	}
+
+	if err != nil {
+		err = c.secrets.Error(timeoutError(ctx, err, timeout))
+		obs.failed(ctx, err)
+		return nil, err
+	}
+	obs.succeeded(ctx, &resp.Response)
+	return resp, nil
+}
+
+func (c *Client) complete(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+	model, res, err := c.checkBudget(ctx, tokenizer.Count(req.Prompt), req.MaxTokens)
+	if err != nil {
+		return nil, err
+	}
+	// Released unless the request completes
+	defer res.Release()
+	if model != c.config.Model {
+		r := *req
+		r.ProviderParams = withModel(req.ProviderParams, model)
+		req = &r
+	}
+	if err := c.checkParams(completionParams(req), model); err != nil {
+		return nil, err
+	}
+	if err := c.checkPromptContext(req, model); err != nil {
+		return nil, err
+	}
+
+	tokens := tokenizer.EstimateCompletion(req)
+	limiter, err := c.waitRateLimit(ctx, tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, model, err := c.sendComplete(ctx, req, model)
+	release()
+	if err != nil {
+		return nil, err
+	}
+
+	settleRateLimit(limiter, tokens, resp.Usage)
+	c.settleCost(res, model, resp.Usage)
+
+	resp.Metadata = req.RequestMetadata
+	return resp, nil
 }
 
 // StreamComplete streams a completion for the given prompt
@@ -81,7 +272,76 @@ func (c *Client) StreamComplete(ctx context.Context, req *types.CompletionReques
 		return nil, err
 	}
 
-	return c.provider.StreamComplete(ctx, req)
+	if err := c.begin(); err != nil {
+		return nil, err
+	}
+
+	if c.idem != nil {
+		req = completionWithIdempotencyKey(req)
+	}
+
+	ctx, cancel, timeout := c.withTimeout(ctx, req.Timeout)
+	ctx = withPriority(ctx, req.Priority)
+	ctx, obs := c.observe(ctx, "stream_complete", req)
+
+	stream, err := c.streamComplete(ctx, req)
+	if err != nil {
+		err = c.secrets.Error(timeoutError(ctx, err, timeout))
+		obs.failed(ctx, err)
+		cancel()
+		c.end()
+		return nil, err
+	}
+
+	return forwardStream(ctx, stream, func(resp *types.CompletionResponse) {
+		resp.Error = c.secrets.Error(resp.Error)
+		resp.Metadata = req.RequestMetadata
+		obs.chunk(&resp.Response)
+	}, func() {
+		obs.streamEnded(ctx)
+		cancel()
+		c.end()
+	}), nil
+}
+
+func (c *Client) streamComplete(ctx context.Context, req *types.CompletionRequest) (<-chan *types.CompletionResponse, error) {
+	model, res, err := c.checkBudget(ctx, tokenizer.Count(req.Prompt), req.MaxTokens)
+	if err != nil {
+		return nil, err
+	}
+	// Released unless the request completes
+	defer res.Release()
+	if model != c.config.Model {
+		r := *req
+		r.ProviderParams = withModel(req.ProviderParams, model)
+		req = &r
+	}
+	if err := c.checkParams(completionParams(req), model); err != nil {
+		return nil, err
+	}
+	if err := c.checkPromptContext(req, model); err != nil {
+		return nil, err
+	}
+
+	if _, err := c.waitRateLimit(ctx, tokenizer.EstimateCompletion(req)); err != nil {
+		return nil, err
+	}
+
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := c.openCompleteStream(ctx, req, model)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	// Providers do not reliably report usage on streams, so the
+	// estimate is charged
+	res.Keep()
+
+	return releaseAfter(c, ctx, stream, release), nil
 }
 
 // Chat generates a chat completion for the given messages
@@ -90,7 +350,114 @@ func (c *Client) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatR
 		return nil, err
 	}
 
-	return c.provider.Chat(ctx, req)
+	if err := c.begin(); err != nil {
+		return nil, err
+	}
+	defer c.end()
+
+	ctx, cancel, timeout := c.withTimeout(ctx, req.Timeout)
+	ctx = withPriority(ctx, req.Priority)
+	defer cancel()
+
+	ctx, obs := c.observe(ctx, "chat", req)
+
+	var resp *types.ChatResponse
+	var err error
+	if c.idem != nil {
+		req = chatWithIdempotencyKey(req)
+		resp, err = doIdempotent(ctx, c.idem, "chat:"+req.IdempotencyKey, func() (*types.ChatResponse, error) {
+			return c.chatValidated(ctx, req)
+		})
+	} else {
+		resp, err = c.chatValidated(ctx, req)
+	}
+
+	if err != nil {
+		err = c.secrets.Error(timeoutError(ctx, err, timeout))
+		obs.failed(ctx, err)
+		return nil, err
+	}
+	obs.succeeded(ctx, &resp.Response)
+	return resp, nil
+}
+
+// ChatText sends a single user message and returns the reply text
+func (c *Client) ChatText(ctx context.Context, prompt string, opts ...types.RequestOption) (string, error) {
+	req := types.NewChatRequest(types.Messages(types.UserMessage(prompt)), opts...)
+
+	resp, err := c.Chat(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return resp.Message.Content, nil
+}
+
+func (c *Client) chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	req, err := c.runRequestHooks(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey, cached := c.cachedResponse(ctx, req)
+	if cached != nil {
+		cached.Metadata = req.RequestMetadata
+		return cached, nil
+	}
+
+	model, res, err := c.checkBudget(ctx, tokenizer.CountChat(req), req.MaxTokens)
+	if err != nil {
+		return nil, err
+	}
+	// Released unless the request completes
+	defer res.Release()
+	if model != c.config.Model {
+		// Degraded responses are not cached under the configured model
+		r := *req
+		r.ProviderParams = withModel(req.ProviderParams, model)
+		req, cacheKey = &r, ""
+	}
+	if err := c.checkParams(chatParams(req), model); err != nil {
+		return nil, err
+	}
+	if req, err = c.fitContext(req, model); err != nil {
+		return nil, err
+	}
+
+	tokens := tokenizer.EstimateChat(req)
+	limiter, err := c.waitRateLimit(ctx, tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, model, err := c.sendChat(ctx, req, model)
+	release()
+	if err != nil {
+		return nil, err
+	}
+	if resp.DowngradedFrom != "" {
+		cacheKey = ""
+	}
+
+	settleRateLimit(limiter, tokens, resp.Usage)
+	c.settleCost(res, model, resp.Usage)
+
+	if err := c.runResponseHooks(ctx, req, resp); err != nil {
+		return nil, err
+	}
+
+	// A response the validators reject is not cached, or a re-ask of the
+	// same request would be answered with it
+	if c.validate(resp) == nil {
+		c.storeResponse(ctx, cacheKey, req, resp)
+	}
+
+	// Set after storing so metadata is not cached with the response
+	resp.Metadata = req.RequestMetadata
+	return resp, nil
 }
 
 // StreamChat streams a chat completion for the given messages
@@ -99,7 +466,276 @@ func (c *Client) StreamChat(ctx context.Context, req *types.ChatRequest) (<-chan
 		return nil, err
 	}
 
-	return c.provider.StreamChat(ctx, req)
+	if err := c.begin(); err != nil {
+		return nil, err
+	}
+
+	if c.idem != nil {
+		req = chatWithIdempotencyKey(req)
+	}
+
+	ctx, cancel, timeout := c.withTimeout(ctx, req.Timeout)
+	ctx = withPriority(ctx, req.Priority)
+	ctx, obs := c.observe(ctx, "stream_chat", req)
+
+	stream, err := c.streamChat(ctx, req)
+	if err != nil {
+		err = c.secrets.Error(timeoutError(ctx, err, timeout))
+		obs.failed(ctx, err)
+		cancel()
+		c.end()
+		return nil, err
+	}
+
+	return forwardStream(ctx, stream, func(resp *types.ChatResponse) {
+		resp.Error = c.secrets.Error(resp.Error)
+		resp.Metadata = req.RequestMetadata
+		obs.chunk(&resp.Response)
+	}, func() {
+		obs.streamEnded(ctx)
+		cancel()
+		c.end()
+	}), nil
+}
+
+func (c *Client) streamChat(ctx context.Context, req *types.ChatRequest) (<-chan *types.ChatResponse, error) {
+	req, err := c.runRequestHooks(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	model, res, err := c.checkBudget(ctx, tokenizer.CountChat(req), req.MaxTokens)
+	if err != nil {
+		return nil, err
+	}
+	// Released unless the request completes
+	defer res.Release()
+	if model != c.config.Model {
+		r := *req
+		r.ProviderParams = withModel(req.ProviderParams, model)
+		req = &r
+	}
+	if err := c.checkParams(chatParams(req), model); err != nil {
+		return nil, err
+	}
+	if req, err = c.fitContext(req, model); err != nil {
+		return nil, err
+	}
+
+	if _, err := c.waitRateLimit(ctx, tokenizer.EstimateChat(req)); err != nil {
+		return nil, err
+	}
+
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.hasStreamHooks() {
+		stream, err := c.openChatStream(ctx, req, model)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		res.Keep()
+		return releaseAfter(c, ctx, stream, release), nil
+	}
+
+	c.runStreamStartHooks(ctx, req)
+	stream, err := c.openChatStream(ctx, req, model)
+	if err != nil {
+		release()
+		c.runStreamEndHooks(ctx, req, err)
+		return nil, err
+	}
+	res.Keep()
+
+	return releaseAfter(c, ctx, c.wrapStream(ctx, req, stream), release), nil
+}
+
+// cachedResponse looks up req in the configured cache. It returns the cache
+// key to store the eventual response under, and the cached response on a hit.
+// Cache failures are treated as misses so they never fail a request.
+func (c *Client) cachedResponse(ctx context.Context, req *types.ChatRequest) (string, *types.ChatResponse) {
+	if c.config.Cache == nil {
+		return "", nil
+	}
+
+	key, err := cache.Key(c.config.Provider, c.config.Model, req)
+	if err != nil {
+		return "", nil
+	}
+
+	var resp *types.ChatResponse
+	if rc, ok := c.config.Cache.(cache.RequestCache); ok {
+		resp, err = rc.Lookup(ctx, c.config.Provider, c.config.Model, req)
+	} else {
+		resp, err = c.config.Cache.Get(ctx, key)
+	}
+	if err != nil {
+		return key, nil
+	}
+
+	// A cached response reports no rate limits, having used none
+	resp.Cached = true
+	resp.RateLimits = nil
+	return key, resp
+}
+
+// storeResponse saves resp in the configured cache under key
+func (c *Client) storeResponse(ctx context.Context, key string, req *types.ChatRequest, resp *types.ChatResponse) {
+	if c.config.Cache == nil || key == "" {
+		return
+	}
+	if rc, ok := c.config.Cache.(cache.RequestCache); ok {
+		_ = rc.Store(ctx, c.config.Provider, c.config.Model, req, resp)
+		return
+	}
+	_ = c.config.Cache.Set(ctx, key, resp)
+}
+
+// pricing returns the catalog requests are priced from
+func (c *Client) pricing() *cost.PricingCatalog {
+	if p := c.settings().pricing; p != nil {
+		return p
+	}
+	return cost.DefaultCatalog()
+}
+
+// checkBudget applies the configured cost controls to a request with the
+// given prompt size and completion budget. It returns the model to send the
+// request to, which is the fallback model when a degraded budget switched
+// it, and the reservation of its estimated cost, which the caller settles
+// or releases.
+func (c *Client) checkBudget(ctx context.Context, promptTokens, maxTokens int) (string, *cost.Reservation, error) {
+	model := c.config.Model
+	if c.budget == nil {
+		return model, nil, nil
+	}
+
+	estimate := c.pricing().Estimate(c.config.Provider, model, promptTokens, maxTokens)
+	res, err := c.budget.Reserve(estimate)
+	if err == nil {
+		return model, res, nil
+	}
+
+	// Only an exceeded budget is subject to the budget mode. Any other
+	// error, such as missing exchange rates, fails the request.
+	cc := c.config.CostControl
+	if cc == nil || !errors.Is(err, types.ErrBudgetExceeded) {
+		return "", nil, err
+	}
+	if cc.OnExceeded != nil {
+		cc.OnExceeded(c.config.Provider, model, err)
+	}
+
+	switch cc.Mode {
+	case config.BudgetSoft:
+		if c.logger != nil {
+			c.logger.WarnContext(ctx, "llm budget exceeded, sending anyway", "error", err)
+		}
+		return model, c.budget.Hold(estimate), nil
+	case config.BudgetDegrade:
+		fallback := c.pricing().Estimate(c.config.Provider, cc.FallbackModel, promptTokens, maxTokens)
+		if res, fallbackErr := c.budget.Reserve(fallback); fallbackErr == nil {
+			if c.logger != nil {
+				c.logger.WarnContext(ctx, "llm budget exceeded, degrading model", "fallback_model", cc.FallbackModel, "error", err)
+			}
+			if obs := observationFrom(ctx); obs != nil {
+				obs.degrade(cc.FallbackModel)
+			}
+			return cc.FallbackModel, res, nil
+		}
+	}
+	return "", nil, err
+}
+
+// withModel returns params with the model overridden, leaving the caller's
+// map unchanged
+func withModel(params map[string]any, model string) map[string]any {
+	out := make(map[string]any, len(params)+1)
+	for k, v := range params {
+		out[k] = v
+	}
+	out["model"] = model
+	return out
+}
+
+// settleCost settles a completed request's budget reservation to its
+// actual cost
+func (c *Client) settleCost(res *cost.Reservation, model string, usage types.Usage) {
+	res.Settle(c.pricing().Cost(c.config.Provider, model, usage))
+}
+
+// waitRateLimit applies the current rate limit to a request expected to
+// use the given number of tokens. It returns the limiter that admitted the
+// request, nil if there is none, for settling its usage.
+func (c *Client) waitRateLimit(ctx context.Context, tokens int) (*ratelimit.Limiter, error) {
+	s := c.settings()
+	if s.limiter == nil {
+		return nil, nil
+	}
+
+	if s.rateLimit != nil && s.rateLimit.Mode == config.RateLimitFailFast {
+		if err := s.limiter.Allow(tokens); err != nil {
+			return nil, fmt.Errorf("%w: %s", types.ErrRateLimitExceeded, c.config.Provider)
+		}
+		return s.limiter, nil
+	}
+
+	c.queued.Add(1)
+	defer c.queued.Add(-1)
+	return s.limiter, s.limiter.Wait(ctx, tokens)
+}
+
+// settleRateLimit corrects the tokens limiter reserved for a request to the
+// usage the provider reported. Responses without usage keep the reservation.
+func settleRateLimit(limiter *ratelimit.Limiter, reserved int, usage types.Usage) {
+	if limiter == nil || usage.Total() == 0 {
+		return
+	}
+	limiter.Settle(reserved, usage.Total())
+}
+
+// newLimiter creates the limiter for a rate limit, or returns nil if there
+// is none. Under adaptive rate limiting it enforces the token limit only.
+func newLimiter(provider, apiKey string, rl *config.RateLimit) *ratelimit.Limiter {
+	if rl == nil {
+		return nil
+	}
+	requestsPerMinute := rl.RequestsPerMinute
+	if rl.Adaptive {
+		requestsPerMinute = 0
+	}
+	algorithm := ratelimit.AlgorithmTokenBucket
+	if rl.SlidingWindow {
+		algorithm = ratelimit.AlgorithmSlidingWindow
+	}
+	if rl.Shared {
+		return ratelimit.Shared(ratelimit.SharedKey(provider, apiKey), algorithm, requestsPerMinute, rl.TokensPerMinute)
+	}
+	return ratelimit.NewWithAlgorithm(algorithm, requestsPerMinute, rl.TokensPerMinute)
+}
+
+// withTransport returns a copy of cfg whose provider requests go through
+// wrap. Providers send requests with the pool's transport when one is set
+// and with the HTTP client's otherwise, so that is the one wrapped.
+func withTransport(cfg *config.Config, wrap func(http.RoundTripper) http.RoundTripper) *config.Config {
+	copied := *cfg
+	if cfg.PoolConfig != nil && cfg.PoolConfig.Transport != nil {
+		pool := *cfg.PoolConfig
+		pool.Transport = wrap(pool.Transport)
+		copied.PoolConfig = &pool
+		return &copied
+	}
+
+	client := &http.Client{}
+	if cfg.HTTPClient != nil {
+		*client = *cfg.HTTPClient
+	}
+	client.Transport = wrap(client.Transport)
+	copied.HTTPClient = client
+	return &copied
 }
 
 // validateRequest performs common validation for all requests
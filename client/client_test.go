@@ -2,10 +2,14 @@ package client
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/internal/ratelimit"
+	"github.com/ksred/llm/pkg/cache"
+	"github.com/ksred/llm/pkg/cost"
 	"github.com/ksred/llm/pkg/types"
 )
 
@@ -238,3 +242,383 @@ func TestClient_StreamChat(t *testing.T) {
 		t.Errorf("StreamChat() received %d responses, want %d", i, len(expected))
 	}
 }
+
+// countingProvider wraps mockProvider and counts Chat calls
+type countingProvider struct {
+	mockProvider
+	chatCalls int
+}
+
+func (c *countingProvider) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	c.chatCalls++
+	return c.mockProvider.Chat(ctx, req)
+}
+
+func TestClient_ChatCache(t *testing.T) {
+	provider := &countingProvider{}
+	client := &Client{
+		config: &config.Config{
+			Provider: "mock",
+			Model:    "test-model",
+			Cache:    cache.NewMemoryCache(time.Minute),
+		},
+		provider: provider,
+	}
+
+	req := &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	}
+
+	first, err := client.Chat(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if first.Cached {
+		t.Error("first Chat() response marked as cached")
+	}
+
+	second, err := client.Chat(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if !second.Cached {
+		t.Error("second Chat() response not marked as cached")
+	}
+	if second.Message.Content != first.Message.Content {
+		t.Errorf("cached content = %q, want %q", second.Message.Content, first.Message.Content)
+	}
+	if provider.chatCalls != 1 {
+		t.Errorf("provider called %d times, want 1", provider.chatCalls)
+	}
+}
+
+func TestClient_ChatText(t *testing.T) {
+	var sent *types.ChatRequest
+	client := &Client{
+		config: &config.Config{
+			Provider: "mock",
+			Hooks: []*types.Hooks{{
+				OnRequest: func(ctx context.Context, req *types.ChatRequest) error {
+					sent = req
+					return nil
+				},
+			}},
+		},
+		provider: &mockProvider{},
+	}
+
+	reply, err := client.ChatText(context.Background(), "Hello", types.WithTemperature(0.2), types.WithMaxTokens(500))
+	if err != nil {
+		t.Fatalf("ChatText() error = %v", err)
+	}
+	if reply != "Test response" {
+		t.Errorf("ChatText() = %q, want %q", reply, "Test response")
+	}
+	if len(sent.Messages) != 1 || sent.Messages[0].Content != "Hello" {
+		t.Errorf("sent messages = %+v", sent.Messages)
+	}
+	if sent.Temperature == nil || *sent.Temperature != 0.2 || sent.MaxTokens != 500 {
+		t.Errorf("sent temperature = %v max tokens = %d", sent.Temperature, sent.MaxTokens)
+	}
+}
+
+func TestClient_RateLimitFailFast(t *testing.T) {
+	client := &Client{
+		config: &config.Config{
+			Provider: "mock",
+			RateLimit: &config.RateLimit{
+				RequestsPerMinute: 1,
+				Mode:              config.RateLimitFailFast,
+			},
+		},
+		provider: &mockProvider{},
+		limiter:  ratelimit.New(1, 0),
+	}
+
+	req := &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	}
+
+	if _, err := client.Chat(context.Background(), req); err != nil {
+		t.Fatalf("first Chat() error = %v", err)
+	}
+	if _, err := client.Chat(context.Background(), req); !errors.Is(err, types.ErrRateLimitExceeded) {
+		t.Errorf("second Chat() error = %v, want %v", err, types.ErrRateLimitExceeded)
+	}
+}
+
+// splitUsageProvider reports usage without a total, as Anthropic does
+type splitUsageProvider struct {
+	mockProvider
+}
+
+func (p *splitUsageProvider) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	return &types.ChatResponse{Response: types.Response{
+		Message: types.Message{Role: types.RoleAssistant, Content: "Hi"},
+		Usage:   types.Usage{PromptTokens: 5, CompletionTokens: 5},
+	}}, nil
+}
+
+func TestClient_RateLimitSettlesTokens(t *testing.T) {
+	// Each request reserves its prompt plus MaxTokens, which fills the
+	// budget, but only the 10 tokens actually used stay charged
+	tests := []struct {
+		name     string
+		provider Provider
+	}{
+		{"total reported", &replyProvider{replies: []string{"Hi"}}},
+		{"no total reported", &splitUsageProvider{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{
+				config: &config.Config{
+					Provider: "mock",
+					RateLimit: &config.RateLimit{
+						TokensPerMinute: 600,
+						Mode:            config.RateLimitFailFast,
+					},
+				},
+				provider: tt.provider,
+				limiter:  ratelimit.New(0, 600),
+			}
+
+			req := &types.ChatRequest{
+				Messages:  []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+				MaxTokens: 500,
+			}
+
+			for i := 0; i < 3; i++ {
+				if _, err := client.Chat(context.Background(), req); err != nil {
+					t.Fatalf("Chat() #%d error = %v", i+1, err)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_RateLimitBlock(t *testing.T) {
+	client := &Client{
+		config: &config.Config{
+			Provider:  "mock",
+			RateLimit: &config.RateLimit{RequestsPerMinute: 1},
+		},
+		provider: &mockProvider{},
+		limiter:  ratelimit.New(1, 0),
+	}
+
+	req := &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	}
+
+	if _, err := client.Chat(context.Background(), req); err != nil {
+		t.Fatalf("first Chat() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.Chat(ctx, req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("blocked Chat() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestClient_SharedRateLimit(t *testing.T) {
+	newClient := func(apiKey string, shared bool) *Client {
+		t.Helper()
+		c, err := NewClient(&config.Config{
+			Provider:  "openai",
+			Model:     "gpt-4",
+			APIKey:    apiKey,
+			RateLimit: &config.RateLimit{RequestsPerMinute: 60, Shared: shared},
+		})
+		if err != nil {
+			t.Fatalf("NewClient() error = %v", err)
+		}
+		return c
+	}
+
+	tenantA := newClient("sk-account", true)
+	tenantB := newClient("sk-account", true)
+	if tenantA.limiter != tenantB.limiter {
+		t.Error("clients sharing an API key have separate limiters")
+	}
+	if other := newClient("sk-other-account", true); other.limiter == tenantA.limiter {
+		t.Error("clients with different API keys share a limiter")
+	}
+	if private := newClient("sk-account", false); private.limiter == tenantA.limiter {
+		t.Error("unshared client uses the shared limiter")
+	}
+}
+
+func TestClient_CostControl(t *testing.T) {
+	newClient := func() *Client {
+		return &Client{
+			config: &config.Config{
+				Provider: "openai",
+				Model:    "gpt-4",
+				CostControl: &config.CostControl{
+					MaxCostPerRequest: 0.10,
+					MaxCostPerDay:     1.00,
+				},
+			},
+			provider: &mockProvider{},
+			budget:   cost.NewBudgetGuard(0.10, 1.00),
+		}
+	}
+
+	msgs := []types.Message{{Role: types.RoleUser, Content: "Hello"}}
+
+	t.Run("within budget", func(t *testing.T) {
+		client := newClient()
+		_, err := client.Chat(context.Background(), &types.ChatRequest{Messages: msgs, MaxTokens: 100})
+		if err != nil {
+			t.Errorf("Chat() error = %v", err)
+		}
+	})
+
+	t.Run("over per-request limit", func(t *testing.T) {
+		client := newClient()
+		// 4000 completion tokens at $0.06/1K is $0.24
+		_, err := client.Chat(context.Background(), &types.ChatRequest{Messages: msgs, MaxTokens: 4000})
+		if !errors.Is(err, types.ErrBudgetExceeded) {
+			t.Errorf("Chat() error = %v, want %v", err, types.ErrBudgetExceeded)
+		}
+	})
+
+	t.Run("negotiated price", func(t *testing.T) {
+		client := newClient()
+		client.config.Pricing = cost.NewPricingCatalog()
+		client.config.Pricing.Set("openai", "gpt-4", cost.TokenRates{PromptTokenRate: 0.01, CompletionTokenRate: 0.02})
+		// 4000 completion tokens at the negotiated $0.02/1K is $0.08
+		_, err := client.Chat(context.Background(), &types.ChatRequest{Messages: msgs, MaxTokens: 4000})
+		if err != nil {
+			t.Errorf("Chat() error = %v", err)
+		}
+	})
+
+	t.Run("over daily limit", func(t *testing.T) {
+		client := newClient()
+		client.budget.Record(0.99)
+		_, err := client.Chat(context.Background(), &types.ChatRequest{Messages: msgs, MaxTokens: 1000})
+		if !errors.Is(err, types.ErrBudgetExceeded) {
+			t.Errorf("Chat() error = %v, want %v", err, types.ErrBudgetExceeded)
+		}
+	})
+}
+
+func TestClient_BudgetConversionError(t *testing.T) {
+	errRates := errors.New("rates unavailable")
+	budget := cost.NewBudgetGuard(0.10, 0)
+	budget.SetCurrency(cost.Currency{
+		Code: "EUR",
+		Rates: cost.ExchangeRatesFunc(func(ctx context.Context, currency string) (float64, error) {
+			return 0, errRates
+		}),
+	})
+
+	modes := map[string]config.BudgetMode{"soft": config.BudgetSoft, "degrade": config.BudgetDegrade}
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			var alerts int
+			provider := &replyProvider{replies: []string{"Hi"}}
+			client := &Client{
+				config: &config.Config{
+					Provider: "openai",
+					Model:    "gpt-4",
+					CostControl: &config.CostControl{
+						MaxCostPerRequest: 0.10,
+						Mode:              mode,
+						FallbackModel:     "gpt-4o-mini",
+						OnExceeded: func(provider, model string, err error) {
+							alerts++
+						},
+					},
+				},
+				provider: provider,
+				budget:   budget,
+			}
+
+			_, err := client.Chat(context.Background(), &types.ChatRequest{
+				Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+			})
+			if !errors.Is(err, errRates) {
+				t.Errorf("Chat() error = %v, want %v", err, errRates)
+			}
+			if alerts != 0 {
+				t.Errorf("OnExceeded called %d times, want 0", alerts)
+			}
+			if len(provider.requests) != 0 {
+				t.Error("request was sent despite the budget check failing")
+			}
+		})
+	}
+}
+
+func TestClient_BudgetModes(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      config.BudgetMode
+		fallback  string
+		wantErr   bool
+		wantModel any // model sent in ProviderParams, nil for the configured one
+	}{
+		{"hard", config.BudgetHard, "", true, nil},
+		{"soft", config.BudgetSoft, "", false, nil},
+		{"degrade", config.BudgetDegrade, "gpt-4o-mini", false, "gpt-4o-mini"},
+		{"degrade still over", config.BudgetDegrade, "gpt-4-32k", true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var alerts int
+			provider := &replyProvider{replies: []string{"Hi"}}
+			client := &Client{
+				config: &config.Config{
+					Provider: "openai",
+					Model:    "gpt-4",
+					CostControl: &config.CostControl{
+						MaxCostPerRequest: 0.10,
+						Mode:              tt.mode,
+						FallbackModel:     tt.fallback,
+						OnExceeded: func(provider, model string, err error) {
+							alerts++
+						},
+					},
+				},
+				provider: provider,
+				budget:   cost.NewBudgetGuard(0.10, 0),
+			}
+
+			// 4000 completion tokens cost $0.24 on gpt-4 and $0.0024 on gpt-4o-mini
+			req := &types.ChatRequest{
+				Messages:  []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+				MaxTokens: 4000,
+			}
+			_, err := client.Chat(context.Background(), req)
+			if tt.wantErr {
+				if !errors.Is(err, types.ErrBudgetExceeded) {
+					t.Errorf("Chat() error = %v, want %v", err, types.ErrBudgetExceeded)
+				}
+			} else if err != nil {
+				t.Fatalf("Chat() error = %v", err)
+			}
+			if alerts != 1 {
+				t.Errorf("OnExceeded called %d times, want 1", alerts)
+			}
+			if req.ProviderParams != nil {
+				t.Errorf("caller's request was modified: %v", req.ProviderParams)
+			}
+
+			if tt.wantErr {
+				if len(provider.requests) != 0 {
+					t.Error("request was sent despite exceeding the budget")
+				}
+				return
+			}
+			if got := provider.requests[0].ProviderParams["model"]; got != tt.wantModel {
+				t.Errorf("model sent = %v, want %v", got, tt.wantModel)
+			}
+		})
+	}
+}
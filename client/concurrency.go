@@ -0,0 +1,166 @@
+package client
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// acquire waits for an in-flight request slot when MaxConcurrentRequests is
+// set. The returned function releases the slot and is safe to call more
+// than once.
+func (c *Client) acquire(ctx context.Context) (func(), error) {
+	if c.sem == nil {
+		return func() {}, nil
+	}
+	if !c.sem.tryAcquire() {
+		c.queued.Add(1)
+		err := c.sem.acquire(ctx)
+		c.queued.Add(-1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(c.sem.release)
+	}, nil
+}
+
+// releaseAfter returns a stream that releases the slot acquired for it once
+// it has been drained. Without a concurrency limit in is returned unchanged.
+func releaseAfter[T any](c *Client, ctx context.Context, in <-chan T, release func()) <-chan T {
+	if c.sem == nil {
+		return in
+	}
+	return forwardStream(ctx, in, func(T) {}, release)
+}
+
+// withPriority applies a request's priority to ctx, so both the concurrency
+// limit and the provider's connection pool see it
+func withPriority(ctx context.Context, p types.Priority) context.Context {
+	if p == types.PriorityNormal {
+		return ctx
+	}
+	return types.WithPriority(ctx, p)
+}
+
+// slots is a counting semaphore that hands freed slots to the waiter with
+// the highest priority, and among equal priorities to the longest waiting
+type slots struct {
+	mu      sync.Mutex
+	free    int
+	seq     uint64
+	waiters waitQueue
+}
+
+func newSlots(n int) *slots {
+	return &slots{free: n}
+}
+
+// tryAcquire takes a slot if one is free. Freed slots go straight to
+// waiters, so a free slot means nobody is waiting.
+func (s *slots) tryAcquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.free > 0 {
+		s.free--
+		return true
+	}
+	return false
+}
+
+// acquire waits for a slot at the priority carried by ctx, or until ctx is
+// done
+func (s *slots) acquire(ctx context.Context) error {
+	s.mu.Lock()
+	if s.free > 0 {
+		s.free--
+		s.mu.Unlock()
+		return nil
+	}
+	w := &slotWaiter{
+		priority: types.PriorityFromContext(ctx),
+		seq:      s.seq,
+		ready:    make(chan struct{}),
+	}
+	s.seq++
+	heap.Push(&s.waiters, w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-w.ready:
+			// The slot was handed over as ctx ended; pass it on
+			s.releaseLocked()
+		default:
+			heap.Remove(&s.waiters, w.index)
+		}
+		return ctx.Err()
+	}
+}
+
+// release frees a slot, handing it straight to the next waiter if any
+func (s *slots) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked()
+}
+
+func (s *slots) releaseLocked() {
+	if len(s.waiters) == 0 {
+		s.free++
+		return
+	}
+	w := heap.Pop(&s.waiters).(*slotWaiter)
+	close(w.ready)
+}
+
+// slotWaiter is a caller blocked in slots.acquire
+type slotWaiter struct {
+	priority types.Priority
+	seq      uint64
+	ready    chan struct{} // closed when the slot is handed over
+	index    int
+}
+
+// waitQueue is a heap of waiters ordered by priority, then arrival
+type waitQueue []*slotWaiter
+
+func (q waitQueue) Len() int { return len(q) }
+
+func (q waitQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waitQueue) Push(x any) {
+	w := x.(*slotWaiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waitQueue) Pop() any {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return w
+}
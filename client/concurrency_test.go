@@ -0,0 +1,238 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// peakProvider holds each request open for delay, recording the peak number
+// of requests in flight
+type peakProvider struct {
+	mockProvider
+	delay    time.Duration
+	inflight atomic.Int32
+	peak     atomic.Int32
+}
+
+func (p *peakProvider) enter() {
+	n := p.inflight.Add(1)
+	for {
+		peak := p.peak.Load()
+		if n <= peak || p.peak.CompareAndSwap(peak, n) {
+			return
+		}
+	}
+}
+
+func (p *peakProvider) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	p.enter()
+	defer p.inflight.Add(-1)
+	time.Sleep(p.delay)
+	return p.mockProvider.Chat(ctx, req)
+}
+
+func (p *peakProvider) StreamChat(ctx context.Context, req *types.ChatRequest) (<-chan *types.ChatResponse, error) {
+	p.enter()
+	ch := make(chan *types.ChatResponse)
+	go func() {
+		defer close(ch)
+		defer p.inflight.Add(-1)
+		time.Sleep(p.delay)
+		ch <- &types.ChatResponse{Response: types.Response{Message: types.Message{Role: types.RoleAssistant, Content: "Hi"}}}
+	}()
+	return ch, nil
+}
+
+func TestClient_MaxConcurrentRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream bool
+	}{
+		{"chat", false},
+		{"stream", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &peakProvider{delay: 20 * time.Millisecond}
+			c := &Client{
+				config:   &config.Config{Provider: "mock", Model: "test-model", MaxConcurrentRequests: 2},
+				provider: provider,
+				sem:      newSlots(2),
+			}
+			req := &types.ChatRequest{
+				Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < 6; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if !tt.stream {
+						if _, err := c.Chat(context.Background(), req); err != nil {
+							t.Errorf("Chat() error = %v", err)
+						}
+						return
+					}
+					stream, err := c.StreamChat(context.Background(), req)
+					if err != nil {
+						t.Errorf("StreamChat() error = %v", err)
+						return
+					}
+					for range stream {
+					}
+				}()
+			}
+			wg.Wait()
+
+			if peak := provider.peak.Load(); peak != 2 {
+				t.Errorf("peak in-flight requests = %d, want 2", peak)
+			}
+		})
+	}
+}
+
+func TestClient_MaxConcurrentRequests_Wait(t *testing.T) {
+	provider := &peakProvider{delay: 200 * time.Millisecond}
+	c := &Client{
+		config:   &config.Config{Provider: "mock", Model: "test-model", MaxConcurrentRequests: 1},
+		provider: provider,
+		sem:      newSlots(1),
+	}
+	req := &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Chat(context.Background(), req)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Chat(ctx, req); !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, types.ErrTimeout) {
+		t.Errorf("Chat() waiting for a slot error = %v, want deadline exceeded", err)
+	}
+	<-done
+}
+
+func TestSlots_Priority(t *testing.T) {
+	s := newSlots(1)
+	if !s.tryAcquire() {
+		t.Fatal("tryAcquire() on free slots = false")
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	queue := func(name string, p types.Priority) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.acquire(types.WithPriority(context.Background(), p)); err != nil {
+				t.Errorf("acquire(%s) error = %v", name, err)
+				return
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			s.release()
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	queue("batch-1", types.PriorityBackground)
+	queue("normal", types.PriorityNormal)
+	queue("batch-2", types.PriorityBackground)
+	queue("interactive", types.PriorityInteractive)
+
+	s.release()
+	wg.Wait()
+
+	want := []string{"interactive", "normal", "batch-1", "batch-2"}
+	if strings.Join(order, ",") != strings.Join(want, ",") {
+		t.Errorf("served %v, want %v", order, want)
+	}
+}
+
+func TestSlots_Cancel(t *testing.T) {
+	s := newSlots(1)
+	s.tryAcquire()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The abandoned waiter must not swallow the released slot
+	s.release()
+	if !s.tryAcquire() {
+		t.Error("tryAcquire() after release = false")
+	}
+}
+
+// orderProvider records the order Chat requests reach it, holding each
+// until release is closed
+type orderProvider struct {
+	mockProvider
+	release chan struct{}
+	mu      sync.Mutex
+	order   []string
+}
+
+func (p *orderProvider) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	p.mu.Lock()
+	p.order = append(p.order, req.Messages[0].Content)
+	p.mu.Unlock()
+	<-p.release
+	return p.mockProvider.Chat(ctx, req)
+}
+
+func TestClient_RequestPriority(t *testing.T) {
+	provider := &orderProvider{release: make(chan struct{})}
+	c := &Client{
+		config:   &config.Config{Provider: "mock", Model: "test-model", MaxConcurrentRequests: 1},
+		provider: provider,
+		sem:      newSlots(1),
+	}
+
+	var wg sync.WaitGroup
+	chat := func(ctx context.Context, name string, p types.Priority) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Chat(ctx, &types.ChatRequest{
+				Messages: []types.Message{{Role: types.RoleUser, Content: name}},
+				Priority: p,
+			})
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Priority can come from the context or the request
+	background := types.WithPriority(context.Background(), types.PriorityBackground)
+	chat(context.Background(), "first", types.PriorityNormal)
+	chat(background, "batch", types.PriorityNormal)
+	chat(context.Background(), "normal", types.PriorityNormal)
+	chat(context.Background(), "interactive", types.PriorityInteractive)
+
+	close(provider.release)
+	wg.Wait()
+
+	want := []string{"first", "interactive", "normal", "batch"}
+	if got := strings.Join(provider.order, ","); got != strings.Join(want, ",") {
+		t.Errorf("provider served %v, want %v", provider.order, want)
+	}
+}
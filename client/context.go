@@ -0,0 +1,77 @@
+package client
+
+import (
+	"github.com/ksred/llm/internal/tokenizer"
+	"github.com/ksred/llm/pkg/models"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// fitContext checks that req's estimated prompt and MaxTokens fit model's
+// context window. A request that does not fit is trimmed with the
+// configured truncation strategy. It fails with a *types.ContextLengthError
+// when there is no strategy, or when the trimmed messages still do not fit
+// or have lost the latest message. The caller's request is never modified.
+func (c *Client) fitContext(req *types.ChatRequest, model string) (*types.ChatRequest, error) {
+	check := c.config.ContextCheck
+	if check == nil {
+		return req, nil
+	}
+	m, ok := models.Lookup(model)
+	if !ok {
+		return req, nil
+	}
+
+	prompt := tokenizer.CountChat(req)
+	if prompt+req.MaxTokens <= m.ContextWindow {
+		return req, nil
+	}
+
+	// System prompts are never trimmed, so they come out of the budget
+	system := tokenizer.CountMessages(req.SystemMessages())
+	if budget := m.ContextWindow - req.MaxTokens - system; check.Truncation != nil && budget > 0 {
+		msgs := check.Truncation.Truncate(req.Messages, budget)
+		if keepsLatest(msgs, req.Messages) && tokenizer.CountMessages(msgs) <= budget {
+			r := *req
+			r.Messages = msgs
+			return &r, nil
+		}
+	}
+
+	return nil, &types.ContextLengthError{
+		Model:         model,
+		PromptTokens:  prompt,
+		MaxTokens:     req.MaxTokens,
+		ContextWindow: m.ContextWindow,
+	}
+}
+
+// keepsLatest reports whether trimmed still ends with the last message of
+// msgs, without which the request would not ask what the caller asked
+func keepsLatest(trimmed, msgs []types.Message) bool {
+	if len(trimmed) == 0 || len(msgs) == 0 {
+		return false
+	}
+	a, b := trimmed[len(trimmed)-1], msgs[len(msgs)-1]
+	return a.Role == b.Role && a.Content == b.Content && a.ToolCallID == b.ToolCallID
+}
+
+// checkPromptContext checks that a completion prompt and MaxTokens fit
+// model's context window. Prompts are never trimmed.
+func (c *Client) checkPromptContext(req *types.CompletionRequest, model string) error {
+	if c.config.ContextCheck == nil {
+		return nil
+	}
+	m, ok := models.Lookup(model)
+	if !ok {
+		return nil
+	}
+	if prompt := tokenizer.Count(req.Prompt); prompt+req.MaxTokens > m.ContextWindow {
+		return &types.ContextLengthError{
+			Model:         model,
+			PromptTokens:  prompt,
+			MaxTokens:     req.MaxTokens,
+			ContextWindow: m.ContextWindow,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/conversation"
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestClient_ContextCheck(t *testing.T) {
+	// Each turn is about 3125 tokens, so three overflow gpt-4's 8192 window
+	turn := strings.Repeat("word ", 2500)
+	long := []types.Message{
+		{Role: types.RoleSystem, Content: "Be brief."},
+		{Role: types.RoleUser, Content: turn},
+		{Role: types.RoleAssistant, Content: turn},
+		{Role: types.RoleUser, Content: turn + "latest"},
+	}
+
+	tests := []struct {
+		name         string
+		check        *config.ContextCheck
+		model        string
+		messages     []types.Message
+		maxTokens    int
+		wantErr      error
+		wantMessages int
+	}{
+		{name: "disabled", model: "gpt-4", messages: long, wantMessages: 4},
+		{name: "fits", check: &config.ContextCheck{}, model: "gpt-4", messages: long[:2], maxTokens: 1000, wantMessages: 2},
+		{name: "too long", check: &config.ContextCheck{}, model: "gpt-4", messages: long, wantErr: types.ErrContextTooLong},
+		{name: "max tokens count", check: &config.ContextCheck{}, model: "gpt-4", messages: long[:2], maxTokens: 6000, wantErr: types.ErrContextTooLong},
+		{name: "unknown model", check: &config.ContextCheck{}, model: "my-fine-tune", messages: long, wantMessages: 4},
+		{
+			name:         "trimmed",
+			check:        &config.ContextCheck{Truncation: conversation.SlidingWindow{}},
+			model:        "gpt-4",
+			messages:     long,
+			maxTokens:    1000,
+			wantMessages: 3,
+		},
+		{
+			name:      "trimming cannot fit",
+			check:     &config.ContextCheck{Truncation: conversation.SlidingWindow{}},
+			model:     "gpt-4",
+			messages:  long,
+			maxTokens: 8000,
+			wantErr:   types.ErrContextTooLong,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &replyProvider{replies: []string{"ok"}}
+			client := &Client{
+				config:   &config.Config{Provider: "mock", Model: tt.model, ContextCheck: tt.check},
+				provider: provider,
+			}
+
+			req := &types.ChatRequest{Messages: tt.messages, MaxTokens: tt.maxTokens}
+			_, err := client.Chat(context.Background(), req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Chat() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(req.Messages) != len(tt.messages) {
+				t.Errorf("caller's request changed to %d messages", len(req.Messages))
+			}
+			if err != nil {
+				var lengthErr *types.ContextLengthError
+				if !errors.As(err, &lengthErr) || lengthErr.ContextWindow != 8192 || lengthErr.PromptTokens == 0 {
+					t.Errorf("Chat() error = %#v, want a *types.ContextLengthError with details", err)
+				}
+				if len(provider.requests) != 0 {
+					t.Error("request sent despite failing the context check")
+				}
+				return
+			}
+
+			sent := provider.requests[0].Messages
+			if len(sent) != tt.wantMessages {
+				t.Fatalf("sent %d messages, want %d", len(sent), tt.wantMessages)
+			}
+			if sent[0].Role != types.RoleSystem || sent[len(sent)-1].Content != tt.messages[len(tt.messages)-1].Content {
+				t.Errorf("trimming dropped the system prompt or the latest message")
+			}
+		})
+	}
+}
+
+func TestClient_ContextCheckComplete(t *testing.T) {
+	client := &Client{
+		config:   &config.Config{Provider: "mock", Model: "gpt-4", ContextCheck: &config.ContextCheck{Truncation: conversation.SlidingWindow{}}},
+		provider: &mockProvider{},
+	}
+
+	_, err := client.Complete(context.Background(), &types.CompletionRequest{Prompt: strings.Repeat("word ", 10000)})
+	if !errors.Is(err, types.ErrContextTooLong) {
+		t.Errorf("Complete() error = %v, want %v", err, types.ErrContextTooLong)
+	}
+	if _, err := client.Complete(context.Background(), &types.CompletionRequest{Prompt: "Hi"}); err != nil {
+		t.Errorf("Complete() error = %v", err)
+	}
+}
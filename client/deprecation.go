@@ -0,0 +1,53 @@
+package client
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/models"
+)
+
+// upgradeModel warns when cfg's model is deprecated or retired. When model
+// upgrades are enabled and the model is retired or has a configured
+// successor, it returns a copy of cfg using the successor.
+func upgradeModel(cfg *config.Config, logger *slog.Logger) *config.Config {
+	successor, explicit := cfg.ModelSuccessors[cfg.Model]
+
+	m, known := models.Lookup(cfg.Model)
+	status := models.StatusActive
+	if known {
+		status = m.Status()
+		if !explicit {
+			successor = m.Successor
+		}
+	}
+
+	if status != models.StatusActive {
+		if logger != nil {
+			logger.Warn("model is "+status.String(),
+				slog.String("provider", cfg.Provider),
+				slog.String("model", cfg.Model),
+				slog.String("retires_at", m.RetiresAt.Format(time.DateOnly)),
+				slog.String("successor", successor),
+			)
+		}
+		if cfg.Metrics != nil && cfg.Metrics.OnModelDeprecated != nil {
+			cfg.Metrics.OnModelDeprecated(cfg.Provider, cfg.Model, successor, m.RetiresAt)
+		}
+	}
+
+	if !cfg.UpgradeModels || successor == "" || !(explicit || status == models.StatusRetired) {
+		return cfg
+	}
+	if logger != nil {
+		logger.Warn("upgrading model",
+			slog.String("provider", cfg.Provider),
+			slog.String("from", cfg.Model),
+			slog.String("to", successor),
+		)
+	}
+	upgraded := *cfg
+	upgraded.Model = successor
+	return &upgraded
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// downgradable reports whether a request to model that failed with err
+// may be retried on the configured downgrade model
+func (c *Client) downgradable(model string, err error) bool {
+	to := c.config.DowngradeModel
+	return to != "" && to != model &&
+		(errors.Is(err, types.ErrRateLimitExceeded) || errors.Is(err, types.ErrOverloaded))
+}
+
+// downgrade records that a request to model is being retried on the
+// downgrade model after err, and returns that model
+func (c *Client) downgrade(ctx context.Context, model string, err error) string {
+	to := c.config.DowngradeModel
+	if c.logger != nil {
+		c.logger.WarnContext(ctx, "llm provider rate limited, downgrading model", "model", model, "downgrade_model", to, "error", err)
+	}
+	if obs := observationFrom(ctx); obs != nil {
+		obs.downgrade(to)
+	}
+	return to
+}
+
+// sendChat sends req, which is for model, to the provider, retrying it on
+// the downgrade model if the provider rate limits. It returns the model
+// that answered.
+func (c *Client) sendChat(ctx context.Context, req *types.ChatRequest, model string) (*types.ChatResponse, string, error) {
+	resp, err := c.provider.Chat(ctx, req)
+	if !c.downgradable(model, err) {
+		return resp, model, err
+	}
+
+	to := c.downgrade(ctx, model, err)
+	r := *req
+	r.ProviderParams = withModel(req.ProviderParams, to)
+	resp, err = c.provider.Chat(ctx, &r)
+	if err != nil {
+		return nil, to, err
+	}
+	resp.DowngradedFrom = model
+	return resp, to, nil
+}
+
+// sendComplete is sendChat for completions
+func (c *Client) sendComplete(ctx context.Context, req *types.CompletionRequest, model string) (*types.CompletionResponse, string, error) {
+	resp, err := c.provider.Complete(ctx, req)
+	if !c.downgradable(model, err) {
+		return resp, model, err
+	}
+
+	to := c.downgrade(ctx, model, err)
+	r := *req
+	r.ProviderParams = withModel(req.ProviderParams, to)
+	resp, err = c.provider.Complete(ctx, &r)
+	if err != nil {
+		return nil, to, err
+	}
+	resp.DowngradedFrom = model
+	return resp, to, nil
+}
+
+// openChatStream is sendChat for streams. Every chunk of a downgraded
+// stream has DowngradedFrom set.
+func (c *Client) openChatStream(ctx context.Context, req *types.ChatRequest, model string) (<-chan *types.ChatResponse, error) {
+	stream, err := c.provider.StreamChat(ctx, req)
+	if !c.downgradable(model, err) {
+		return stream, err
+	}
+
+	to := c.downgrade(ctx, model, err)
+	r := *req
+	r.ProviderParams = withModel(req.ProviderParams, to)
+	stream, err = c.provider.StreamChat(ctx, &r)
+	if err != nil {
+		return nil, err
+	}
+	return forwardStream(ctx, stream, func(resp *types.ChatResponse) {
+		resp.DowngradedFrom = model
+	}, func() {}), nil
+}
+
+// openCompleteStream is openChatStream for completions
+func (c *Client) openCompleteStream(ctx context.Context, req *types.CompletionRequest, model string) (<-chan *types.CompletionResponse, error) {
+	stream, err := c.provider.StreamComplete(ctx, req)
+	if !c.downgradable(model, err) {
+		return stream, err
+	}
+
+	to := c.downgrade(ctx, model, err)
+	r := *req
+	r.ProviderParams = withModel(req.ProviderParams, to)
+	stream, err = c.provider.StreamComplete(ctx, &r)
+	if err != nil {
+		return nil, err
+	}
+	return forwardStream(ctx, stream, func(resp *types.CompletionResponse) {
+		resp.DowngradedFrom = model
+	}, func() {}), nil
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// limitedProvider fails requests for the configured model with err and
+// answers those sent to another model with that model's name
+type limitedProvider struct {
+	mockProvider
+	err    error
+	models []any
+}
+
+func (p *limitedProvider) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	model := req.ProviderParams["model"]
+	p.models = append(p.models, model)
+	if model == nil {
+		return nil, p.err
+	}
+	return &types.ChatResponse{Response: types.Response{Model: model.(string)}}, nil
+}
+
+func (p *limitedProvider) StreamChat(ctx context.Context, req *types.ChatRequest) (<-chan *types.ChatResponse, error) {
+	resp, err := p.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan *types.ChatResponse, 1)
+	ch <- resp
+	close(ch)
+	return ch, nil
+}
+
+func TestClient_DowngradeOnRateLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		downgrade string
+		wantErr   error
+		wantModel string
+		wantCalls int
+	}{
+		{"rate limited", types.ErrRateLimitExceeded, "gpt-4o-mini", nil, "gpt-4o-mini", 2},
+		{"overloaded", types.ErrOverloaded, "gpt-4o-mini", nil, "gpt-4o-mini", 2},
+		{"other errors not downgraded", types.ErrInvalidRequest, "gpt-4o-mini", types.ErrInvalidRequest, "", 1},
+		{"no downgrade model", types.ErrRateLimitExceeded, "", types.ErrRateLimitExceeded, "", 1},
+	}
+
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			name := tt.name
+			if stream {
+				name += " stream"
+			}
+			t.Run(name, func(t *testing.T) {
+				provider := &limitedProvider{err: tt.err}
+				client := &Client{
+					config:   &config.Config{Provider: "openai", Model: "gpt-4o", DowngradeModel: tt.downgrade},
+					provider: provider,
+				}
+				req := types.NewChatRequest([]types.Message{{Role: types.RoleUser, Content: "Hello"}})
+
+				var resp *types.ChatResponse
+				var err error
+				if stream {
+					var ch <-chan *types.ChatResponse
+					if ch, err = client.StreamChat(context.Background(), req); err == nil {
+						resp = <-ch
+					}
+				} else {
+					resp, err = client.Chat(context.Background(), req)
+				}
+
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				if len(provider.models) != tt.wantCalls {
+					t.Errorf("provider called %d times, want %d", len(provider.models), tt.wantCalls)
+				}
+				if tt.wantErr != nil {
+					return
+				}
+				if resp.Model != tt.wantModel || resp.DowngradedFrom != "gpt-4o" {
+					t.Errorf("response model = %q, downgraded from %q, want %q from gpt-4o", resp.Model, resp.DowngradedFrom, tt.wantModel)
+				}
+				if req.ProviderParams != nil {
+					t.Errorf("caller's request was modified: %v", req.ProviderParams)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// ErrNoClients is returned by FanOut and FanOutFirst when given no clients
+var ErrNoClients = errors.New("no clients to fan out to")
+
+// FanOutResult is one client's outcome of a fan-out request
+type FanOutResult struct {
+	Provider string
+	Model    string
+	Response *types.ChatResponse
+	Err      error
+	// Latency is the time the client took to respond or fail
+	Latency time.Duration
+}
+
+// FanOutError is returned when every client of a fan-out request failed. It
+// matches each client's error with errors.Is and errors.As.
+type FanOutError struct {
+	Results []FanOutResult
+}
+
+func (e *FanOutError) Error() string {
+	msgs := make([]string, len(e.Results))
+	for i, r := range e.Results {
+		msgs[i] = fmt.Sprintf("%s/%s: %v", r.Provider, r.Model, r.Err)
+	}
+	return "all clients failed: " + strings.Join(msgs, "; ")
+}
+
+func (e *FanOutError) Unwrap() []error {
+	errs := make([]error, len(e.Results))
+	for i, r := range e.Results {
+		errs[i] = r.Err
+	}
+	return errs
+}
+
+// FanOut sends the same chat request to every client at once, for example
+// to compare providers, and waits for all of them. The results are in the
+// order of clients, each with its response or error. The error is a
+// *FanOutError only if every client failed.
+func FanOut(ctx context.Context, req *types.ChatRequest, clients ...*Client) ([]FanOutResult, error) {
+	if len(clients) == 0 {
+		return nil, ErrNoClients
+	}
+
+	results := make([]FanOutResult, len(clients))
+	done := make(chan int)
+	for i, c := range clients {
+		go func(i int, c *Client) {
+			results[i] = c.fanOutChat(ctx, req)
+			done <- i
+		}(i, c)
+	}
+
+	failed := 0
+	for range clients {
+		if results[<-done].Err != nil {
+			failed++
+		}
+	}
+	if failed == len(clients) {
+		return results, &FanOutError{Results: results}
+	}
+	return results, nil
+}
+
+// FanOutFirst sends the same chat request to every client at once and
+// returns the first successful result, cancelling the other requests. If
+// every client fails, the error is a *FanOutError.
+func FanOutFirst(ctx context.Context, req *types.ChatRequest, clients ...*Client) (FanOutResult, error) {
+	if len(clients) == 0 {
+		return FanOutResult{}, ErrNoClients
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type outcome struct {
+		index  int
+		result FanOutResult
+	}
+	// Buffered so the requests still running after a success do not block
+	outcomes := make(chan outcome, len(clients))
+	for i, c := range clients {
+		go func(i int, c *Client) {
+			outcomes <- outcome{i, c.fanOutChat(ctx, req)}
+		}(i, c)
+	}
+
+	results := make([]FanOutResult, len(clients))
+	for range clients {
+		o := <-outcomes
+		if o.result.Err == nil {
+			return o.result, nil
+		}
+		results[o.index] = o.result
+	}
+	return FanOutResult{}, &FanOutError{Results: results}
+}
+
+// fanOutChat sends a copy of req, as hooks may modify the request each
+// client is given
+func (c *Client) fanOutChat(ctx context.Context, req *types.ChatRequest) FanOutResult {
+	start := time.Now()
+	resp, err := c.Chat(ctx, req.Clone())
+	return FanOutResult{
+		Provider: c.config.Provider,
+		Model:    c.config.Model,
+		Response: resp,
+		Err:      err,
+		Latency:  time.Since(start),
+	}
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// fanOutProvider replies after a delay, or fails
+type fanOutProvider struct {
+	mockProvider
+	reply string
+	delay time.Duration
+	err   error
+}
+
+func (p *fanOutProvider) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(p.delay):
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &types.ChatResponse{Response: types.Response{
+		Message: types.Message{Role: types.RoleAssistant, Content: p.reply},
+	}}, nil
+}
+
+func fanOutClient(model string, p *fanOutProvider) *Client {
+	return &Client{config: &config.Config{Provider: "mock", Model: model}, provider: p}
+}
+
+func TestFanOut(t *testing.T) {
+	errDown := errors.New("provider down")
+	req := &types.ChatRequest{Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}}}
+
+	tests := []struct {
+		name        string
+		clients     []*Client
+		wantReplies []string
+		wantErr     bool
+	}{
+		{
+			name: "all succeed",
+			clients: []*Client{
+				fanOutClient("a", &fanOutProvider{reply: "from a", delay: 20 * time.Millisecond}),
+				fanOutClient("b", &fanOutProvider{reply: "from b"}),
+			},
+			wantReplies: []string{"from a", "from b"},
+		},
+		{
+			name: "one fails",
+			clients: []*Client{
+				fanOutClient("a", &fanOutProvider{err: errDown}),
+				fanOutClient("b", &fanOutProvider{reply: "from b"}),
+			},
+			wantReplies: []string{"", "from b"},
+		},
+		{
+			name: "all fail",
+			clients: []*Client{
+				fanOutClient("a", &fanOutProvider{err: errDown}),
+				fanOutClient("b", &fanOutProvider{err: errDown}),
+			},
+			wantReplies: []string{"", ""},
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := FanOut(context.Background(), req, tt.clients...)
+			if tt.wantErr {
+				var fanOutErr *FanOutError
+				if !errors.As(err, &fanOutErr) || !errors.Is(err, errDown) {
+					t.Fatalf("FanOut() error = %v, want a *FanOutError wrapping %v", err, errDown)
+				}
+			} else if err != nil {
+				t.Fatalf("FanOut() error = %v", err)
+			}
+
+			if len(results) != len(tt.wantReplies) {
+				t.Fatalf("FanOut() returned %d results, want %d", len(results), len(tt.wantReplies))
+			}
+			for i, want := range tt.wantReplies {
+				r := results[i]
+				if r.Model != tt.clients[i].config.Model {
+					t.Errorf("results[%d].Model = %q, want %q", i, r.Model, tt.clients[i].config.Model)
+				}
+				if want == "" {
+					if !errors.Is(r.Err, errDown) {
+						t.Errorf("results[%d].Err = %v, want %v", i, r.Err, errDown)
+					}
+					continue
+				}
+				if r.Err != nil || r.Response.Message.Content != want {
+					t.Errorf("results[%d] = %+v, want reply %q", i, r, want)
+				}
+			}
+		})
+	}
+
+	if _, err := FanOut(context.Background(), req); !errors.Is(err, ErrNoClients) {
+		t.Errorf("FanOut() without clients error = %v, want ErrNoClients", err)
+	}
+}
+
+func TestFanOutFirst(t *testing.T) {
+	errDown := errors.New("provider down")
+	req := &types.ChatRequest{Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}}}
+
+	slow := fanOutClient("slow", &fanOutProvider{reply: "slow", delay: time.Minute})
+	fast := fanOutClient("fast", &fanOutProvider{reply: "fast", delay: 10 * time.Millisecond})
+	failing := fanOutClient("failing", &fanOutProvider{err: errDown})
+
+	start := time.Now()
+	result, err := FanOutFirst(context.Background(), req, slow, failing, fast)
+	if err != nil {
+		t.Fatalf("FanOutFirst() error = %v", err)
+	}
+	if result.Model != "fast" || result.Response.Message.Content != "fast" {
+		t.Errorf("FanOutFirst() = %+v, want the fast client's reply", result)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("FanOutFirst() took %v, want it not to wait for the slow client", elapsed)
+	}
+
+	_, err = FanOutFirst(context.Background(), req, failing, failing)
+	var fanOutErr *FanOutError
+	if !errors.As(err, &fanOutErr) || len(fanOutErr.Results) != 2 {
+		t.Errorf("FanOutFirst() error = %v, want a *FanOutError with 2 results", err)
+	}
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"time"
+
+	"github.com/ksred/llm/pkg/resource"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// poolStatser is implemented by providers that pool their HTTP clients
+type poolStatser interface {
+	PoolStats() resource.PoolStats
+}
+
+// Gauges returns a snapshot of the client's capacity: pooled connections,
+// requests in flight, requests queued for the rate limiter, a concurrency
+// slot or a connection, and the current rate limiter wait
+func (c *Client) Gauges() types.Gauges {
+	g := types.Gauges{
+		ActiveRequests: int(c.active.Load()),
+		QueueDepth:     int(c.queued.Load()),
+	}
+
+	if p, ok := c.provider.(poolStatser); ok {
+		stats := p.PoolStats()
+		g.IdleConnections = stats.Idle
+		g.ActiveConnections = stats.Active
+		g.QueueDepth += stats.Waiting
+	}
+
+	if l := c.settings().limiter; l != nil {
+		g.LimiterWait = l.Delay()
+	}
+	if c.adaptive != nil {
+		if d := c.adaptive.Delay(); d > g.LimiterWait {
+			g.LimiterWait = d
+		}
+	}
+	return g
+}
+
+// KeyHealth returns the health of each configured API key, or nil when the
+// client has a single key
+func (c *Client) KeyHealth() []types.KeyHealth {
+	if c.keys == nil {
+		return nil
+	}
+	return c.keys.Health()
+}
+
+// reportGauges sends a snapshot to Metrics.OnGauges every interval until
+// the client is closed
+func (c *Client) reportGauges(interval time.Duration) {
+	defer close(c.gaugesDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopGauges:
+			return
+		case <-ticker.C:
+		}
+		// A tick and Close may be ready together; never report after Close
+		select {
+		case <-c.stopGauges:
+			return
+		default:
+			c.config.Metrics.OnGauges(c.config.Provider, c.Gauges())
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/internal/ratelimit"
+	"github.com/ksred/llm/pkg/resource"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// blockingProvider holds Chat requests until release is closed and reports
+// fixed pool stats
+type blockingProvider struct {
+	mockProvider
+	release chan struct{}
+	stats   resource.PoolStats
+}
+
+func (p *blockingProvider) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	<-p.release
+	return p.mockProvider.Chat(ctx, req)
+}
+
+func (p *blockingProvider) PoolStats() resource.PoolStats {
+	return p.stats
+}
+
+func TestClient_Gauges(t *testing.T) {
+	provider := &blockingProvider{
+		release: make(chan struct{}),
+		stats:   resource.PoolStats{Idle: 3, Active: 1, Waiting: 2},
+	}
+	c := &Client{
+		config:   &config.Config{Provider: "mock", Model: "test-model", RateLimit: &config.RateLimit{RequestsPerMinute: 60}},
+		provider: provider,
+		limiter:  ratelimit.New(60, 0),
+		sem:      newSlots(1),
+	}
+	req := &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	}
+
+	// The first request holds the only slot and the second queues for it
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Chat(context.Background(), req)
+		}()
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Queued: one request waiting for a slot plus two waiting on the pool.
+	// The 60 RPM limiter still has capacity, so there is no limiter wait.
+	want := types.Gauges{
+		IdleConnections:   3,
+		ActiveConnections: 1,
+		ActiveRequests:    2,
+		QueueDepth:        3,
+	}
+	if g := c.Gauges(); g != want {
+		t.Errorf("Gauges() = %+v, want %+v", g, want)
+	}
+
+	close(provider.release)
+	wg.Wait()
+
+	want.ActiveRequests, want.QueueDepth = 0, 2
+	if g := c.Gauges(); g != want {
+		t.Errorf("Gauges() after requests finished = %+v, want %+v", g, want)
+	}
+}
+
+func TestClient_GaugesLimiterWait(t *testing.T) {
+	c := &Client{
+		config:   &config.Config{Provider: "mock"},
+		provider: &mockProvider{},
+		limiter:  ratelimit.New(1, 0),
+	}
+	c.limiter.Allow(0)
+
+	if g := c.Gauges(); g.LimiterWait < 59*time.Second || g.LimiterWait > time.Minute {
+		t.Errorf("LimiterWait = %v, want ~1m", g.LimiterWait)
+	}
+}
+
+func TestClient_GaugeInterval(t *testing.T) {
+	var mu sync.Mutex
+	var reports []string
+	c, err := NewClient(&config.Config{
+		Provider:      "openai",
+		Model:         "gpt-4",
+		APIKey:        "test-key",
+		GaugeInterval: 10 * time.Millisecond,
+		Metrics: &types.MetricsCallbacks{
+			OnGauges: func(provider string, g types.Gauges) {
+				mu.Lock()
+				reports = append(reports, provider)
+				mu.Unlock()
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	time.Sleep(55 * time.Millisecond)
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	mu.Lock()
+	n := len(reports)
+	mu.Unlock()
+	if n < 2 || reports[0] != "openai" {
+		t.Fatalf("OnGauges reports = %v, want several for openai", reports)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reports) != n {
+		t.Errorf("OnGauges called %d times after Close", len(reports)-n)
+	}
+}
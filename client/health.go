@@ -0,0 +1,172 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/types"
+)
+
+const (
+	defaultHealthWindow       = 5 * time.Minute
+	defaultHealthMinRequests  = 5
+	defaultDegradedErrorRate  = 0.1
+	defaultUnhealthyErrorRate = 0.5
+
+	// maxHealthSamples bounds the memory a busy client spends on health
+	maxHealthSamples = 1000
+)
+
+// healthSample is the outcome of one request
+type healthSample struct {
+	at      time.Time
+	latency time.Duration
+	failed  bool
+}
+
+// healthTracker keeps the recent outcomes of a client's requests to judge
+// its provider's health. A nil tracker records nothing and reports healthy.
+type healthTracker struct {
+	thresholds config.HealthThresholds
+	now        func() time.Time
+
+	mu      sync.Mutex
+	samples []healthSample // oldest first
+}
+
+func newHealthTracker(t *config.HealthThresholds) *healthTracker {
+	h := &healthTracker{now: time.Now}
+	if t != nil {
+		h.thresholds = *t
+	}
+	if h.thresholds.Window <= 0 {
+		h.thresholds.Window = defaultHealthWindow
+	}
+	if h.thresholds.MinRequests <= 0 {
+		h.thresholds.MinRequests = defaultHealthMinRequests
+	}
+	if h.thresholds.DegradedErrorRate <= 0 {
+		h.thresholds.DegradedErrorRate = defaultDegradedErrorRate
+	}
+	if h.thresholds.UnhealthyErrorRate <= 0 {
+		h.thresholds.UnhealthyErrorRate = defaultUnhealthyErrorRate
+	}
+	return h
+}
+
+// requestErrors are caused by the request or the caller rather than the
+// provider, so they never count against its health
+var requestErrors = []error{
+	context.Canceled,
+	types.ErrInvalidRequest,
+	types.ErrContextTooLong,
+	types.ErrBudgetExceeded,
+	types.ErrUnknownModel,
+	types.ErrUnknownCurrency,
+	types.ErrClientClosed,
+	types.ErrEmptyMessages,
+	types.ErrEmptyPrompt,
+	types.ErrEmptyRole,
+	types.ErrInvalidRole,
+	types.ErrEmptyContent,
+	types.ErrEmptyToolID,
+	types.ErrEmptyName,
+	types.ErrEmptyToolName,
+}
+
+// record adds a request's outcome. Every failure counts against the
+// provider, including transport errors such as a refused connection, except
+// those the request or caller caused, such as invalid requests, exceeded
+// budgets or cancellation, which are not recorded.
+func (h *healthTracker) record(latency time.Duration, err error) {
+	if h == nil {
+		return
+	}
+	for _, target := range requestErrors {
+		if errors.Is(err, target) {
+			return
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.prune()
+	if len(h.samples) == maxHealthSamples {
+		h.samples = h.samples[1:]
+	}
+	h.samples = append(h.samples, healthSample{at: h.now(), latency: latency, failed: err != nil})
+}
+
+// prune drops samples older than the window. Callers must hold h.mu.
+func (h *healthTracker) prune() {
+	cutoff := h.now().Add(-h.thresholds.Window)
+	i := 0
+	for i < len(h.samples) && h.samples[i].at.Before(cutoff) {
+		i++
+	}
+	h.samples = h.samples[i:]
+}
+
+// status summarizes the samples within the window
+func (h *healthTracker) status() types.ProviderStatus {
+	var s types.ProviderStatus
+	if h == nil {
+		return s
+	}
+	h.mu.Lock()
+	h.prune()
+	latencies := make([]time.Duration, 0, len(h.samples))
+	for _, sample := range h.samples {
+		if sample.failed {
+			s.Errors++
+		} else {
+			latencies = append(latencies, sample.latency)
+		}
+	}
+	s.Requests = len(h.samples)
+	h.mu.Unlock()
+
+	if s.Requests > 0 {
+		s.ErrorRate = float64(s.Errors) / float64(s.Requests)
+	}
+	slices.Sort(latencies)
+	s.P50 = percentile(latencies, 0.50)
+	s.P95 = percentile(latencies, 0.95)
+	s.P99 = percentile(latencies, 0.99)
+
+	t := h.thresholds
+	switch {
+	case s.Requests >= t.MinRequests && s.ErrorRate >= t.UnhealthyErrorRate:
+		s.Health = types.HealthUnhealthy
+	case s.Requests >= t.MinRequests && s.ErrorRate >= t.DegradedErrorRate:
+		s.Health = types.HealthDegraded
+	case t.DegradedLatency > 0 && len(latencies) >= t.MinRequests && s.P95 > t.DegradedLatency:
+		s.Health = types.HealthDegraded
+	}
+	return s
+}
+
+// percentile returns the nearest-rank percentile p of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(0, i)]
+}
+
+// Status reports the health of the client's provider, judged from the
+// error rate and latency of its requests over the recent window set with
+// config.WithHealthThresholds. Cached responses are not counted. Routers
+// and circuit breakers can use it to move traffic away from a failing
+// provider.
+func (c *Client) Status() types.ProviderStatus {
+	s := c.health.status()
+	s.Provider = c.config.Provider
+	s.Model = c.config.Model
+	return s
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestHealthTracker(t *testing.T) {
+	errBadRequest := fmt.Errorf("%w: bad request", types.ErrInvalidRequest)
+	errRefused := &url.Error{Op: "Post", URL: "https://api.example.com", Err: errors.New("connection refused")}
+	tests := []struct {
+		name       string
+		thresholds *config.HealthThresholds
+		latencies  []time.Duration
+		errs       []error
+		want       types.Health
+		wantErrors int
+	}{
+		{
+			name:       "too few requests",
+			latencies:  []time.Duration{0, 0},
+			errs:       []error{types.ErrOverloaded, types.ErrOverloaded},
+			want:       types.HealthHealthy,
+			wantErrors: 2,
+		},
+		{
+			name:      "healthy",
+			latencies: []time.Duration{10, 20, 30, 40, 50},
+			errs:      make([]error, 5),
+			want:      types.HealthHealthy,
+		},
+		{
+			name:       "degraded by errors",
+			latencies:  []time.Duration{10, 20, 30, 40, 50, 60, 70, 80, 90, 100},
+			errs:       []error{types.ErrRateLimitExceeded, nil, nil, nil, nil, nil, nil, nil, nil, nil},
+			want:       types.HealthDegraded,
+			wantErrors: 1,
+		},
+		{
+			name:       "unhealthy",
+			latencies:  make([]time.Duration, 6),
+			errs:       []error{types.ErrOverloaded, types.ErrTimeout, types.ErrProviderError, nil, nil, nil},
+			want:       types.HealthUnhealthy,
+			wantErrors: 3,
+		},
+		{
+			name:      "caller errors ignored",
+			latencies: make([]time.Duration, 6),
+			errs:      []error{errBadRequest, types.ErrBudgetExceeded, types.ErrEmptyMessages, context.Canceled, nil, nil},
+			want:      types.HealthHealthy,
+		},
+		{
+			name:      "unreachable",
+			latencies: make([]time.Duration, 5),
+			errs: []error{
+				errRefused, errRefused, errRefused,
+				&url.Error{Op: "Post", URL: "https://api.example.com", Err: errors.New("no such host")},
+				context.DeadlineExceeded,
+			},
+			want:       types.HealthUnhealthy,
+			wantErrors: 5,
+		},
+		{
+			name:       "degraded by latency",
+			thresholds: &config.HealthThresholds{DegradedLatency: time.Second},
+			latencies:  []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond, time.Millisecond, 2 * time.Second},
+			errs:       make([]error, 5),
+			want:       types.HealthDegraded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHealthTracker(tt.thresholds)
+			for i, err := range tt.errs {
+				h.record(tt.latencies[i], err)
+			}
+			got := h.status()
+			if got.Health != tt.want || got.Errors != tt.wantErrors {
+				t.Errorf("status() = %+v, want %v with %d errors", got, tt.want, tt.wantErrors)
+			}
+		})
+	}
+}
+
+func TestHealthTracker_Window(t *testing.T) {
+	now := time.Now()
+	h := newHealthTracker(&config.HealthThresholds{Window: time.Minute, MinRequests: 1})
+	h.now = func() time.Time { return now }
+
+	h.record(time.Second, types.ErrOverloaded)
+	if got := h.status(); got.Health != types.HealthUnhealthy {
+		t.Fatalf("status() = %+v, want unhealthy", got)
+	}
+
+	now = now.Add(2 * time.Minute)
+	h.record(time.Second, nil)
+	got := h.status()
+	if got.Health != types.HealthHealthy || got.Requests != 1 || got.P50 != time.Second || got.P99 != time.Second {
+		t.Errorf("status() = %+v, want one healthy request once the failure left the window", got)
+	}
+}
+
+func TestClient_Status(t *testing.T) {
+	p := &fanOutProvider{reply: "hi", err: types.ErrOverloaded}
+	c := &Client{
+		config:   &config.Config{Provider: "mock", Model: "test-model"},
+		provider: p,
+		health:   newHealthTracker(nil),
+	}
+	req := &types.ChatRequest{Messages: []types.Message{types.UserMessage("Hello")}}
+
+	for i := 0; i < 5; i++ {
+		if _, err := c.Chat(context.Background(), req); !errors.Is(err, types.ErrOverloaded) {
+			t.Fatalf("Chat() error = %v, want ErrOverloaded", err)
+		}
+	}
+	got := c.Status()
+	if got.Health != types.HealthUnhealthy || got.Provider != "mock" || got.Model != "test-model" || got.ErrorRate != 1 {
+		t.Errorf("Status() = %+v, want an unhealthy mock provider", got)
+	}
+
+	if s := (&Client{config: &config.Config{}}).Status(); s.Health != types.HealthHealthy {
+		t.Errorf("Status() without requests = %+v, want healthy", s)
+	}
+}
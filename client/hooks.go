@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// runRequestHooks calls each OnRequest hook, stopping at the first error.
+// The hooks are given a copy of req, which is returned, so changes they
+// make never reach the caller's request.
+func (c *Client) runRequestHooks(ctx context.Context, req *types.ChatRequest) (*types.ChatRequest, error) {
+	var out *types.ChatRequest
+	for _, h := range c.config.Hooks {
+		if h.OnRequest == nil {
+			continue
+		}
+		if out == nil {
+			out = req.Clone()
+		}
+		if err := h.OnRequest(ctx, out); err != nil {
+			return nil, err
+		}
+	}
+	if out == nil {
+		return req, nil
+	}
+	return out, nil
+}
+
+// runResponseHooks calls each OnResponse hook, stopping at the first error
+func (c *Client) runResponseHooks(ctx context.Context, req *types.ChatRequest, resp *types.ChatResponse) error {
+	for _, h := range c.config.Hooks {
+		if h.OnResponse == nil {
+			continue
+		}
+		if err := h.OnResponse(ctx, req, resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasStreamHooks reports whether any stream lifecycle hook is configured
+func (c *Client) hasStreamHooks() bool {
+	for _, h := range c.config.Hooks {
+		if h.OnStreamStart != nil || h.OnChunk != nil || h.OnStreamEnd != nil || h.OnStream != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) runStreamStartHooks(ctx context.Context, req *types.ChatRequest) {
+	for _, h := range c.config.Hooks {
+		if h.OnStreamStart != nil {
+			h.OnStreamStart(ctx, req)
+		}
+	}
+}
+
+func (c *Client) runStreamEndHooks(ctx context.Context, req *types.ChatRequest, err error) {
+	for _, h := range c.config.Hooks {
+		if h.OnStreamEnd != nil {
+			h.OnStreamEnd(ctx, req, err)
+		}
+	}
+}
+
+// runChunkHooks passes a chunk through each OnChunk hook in turn. It returns
+// nil if any hook dropped the chunk.
+func (c *Client) runChunkHooks(ctx context.Context, chunk *types.ChatResponse) *types.ChatResponse {
+	for _, h := range c.config.Hooks {
+		if h.OnChunk == nil {
+			continue
+		}
+		chunk = h.OnChunk(ctx, chunk)
+		if chunk == nil {
+			return nil
+		}
+	}
+	return chunk
+}
+
+// wrapStream passes in through the stream hooks, forwards its chunks
+// through the chunk hooks and calls the stream end hooks once it is drained
+// or the context is cancelled.
+func (c *Client) wrapStream(ctx context.Context, req *types.ChatRequest, in <-chan *types.ChatResponse) <-chan *types.ChatResponse {
+	for _, h := range c.config.Hooks {
+		if h.OnStream != nil {
+			in = h.OnStream(ctx, in)
+		}
+	}
+
+	out := make(chan *types.ChatResponse)
+	go func() {
+		defer close(out)
+
+		var streamErr error
+		for chunk := range in {
+			if chunk.Error != nil && streamErr == nil {
+				streamErr = chunk.Error
+			}
+
+			chunk = c.runChunkHooks(ctx, chunk)
+			if chunk == nil {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				if streamErr == nil {
+					streamErr = ctx.Err()
+				}
+				// Drain so the provider goroutine is never left blocked
+				go func() {
+					for range in {
+					}
+				}()
+				c.runStreamEndHooks(ctx, req, streamErr)
+				return
+			case out <- chunk:
+			}
+		}
+
+		c.runStreamEndHooks(ctx, req, streamErr)
+	}()
+	return out
+}
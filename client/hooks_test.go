@@ -0,0 +1,201 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestClient_ChatHooks(t *testing.T) {
+	errBlocked := errors.New("blocked")
+
+	tests := []struct {
+		name      string
+		hooks     *types.Hooks
+		wantErr   error
+		wantReply string
+	}{
+		{
+			name: "request hook aborts",
+			hooks: &types.Hooks{
+				OnRequest: func(ctx context.Context, req *types.ChatRequest) error {
+					return errBlocked
+				},
+			},
+			wantErr: errBlocked,
+		},
+		{
+			name: "response hook modifies response",
+			hooks: &types.Hooks{
+				OnResponse: func(ctx context.Context, req *types.ChatRequest, resp *types.ChatResponse) error {
+					resp.Message.Content = strings.ToUpper(resp.Message.Content)
+					return nil
+				},
+			},
+			wantReply: "TEST RESPONSE",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{
+				config: &config.Config{
+					Provider: "mock",
+					Hooks:    []*types.Hooks{tt.hooks},
+				},
+				provider: &mockProvider{},
+			}
+
+			resp, err := client.Chat(context.Background(), &types.ChatRequest{
+				Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Chat() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && resp.Message.Content != tt.wantReply {
+				t.Errorf("Chat() content = %q, want %q", resp.Message.Content, tt.wantReply)
+			}
+		})
+	}
+}
+
+// recordingProvider keeps the last chat request it was sent
+type recordingProvider struct {
+	mockProvider
+	last *types.ChatRequest
+}
+
+func (p *recordingProvider) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	p.last = req
+	return p.mockProvider.Chat(ctx, req)
+}
+
+func TestClient_RequestHookCopy(t *testing.T) {
+	provider := &recordingProvider{}
+	client := &Client{
+		config: &config.Config{
+			Provider: "mock",
+			Hooks: []*types.Hooks{{
+				OnRequest: func(ctx context.Context, req *types.ChatRequest) error {
+					req.Messages[0].Content = "[REDACTED]"
+					return nil
+				},
+			}},
+		},
+		provider: provider,
+	}
+
+	req := &types.ChatRequest{Messages: []types.Message{{Role: types.RoleUser, Content: "my secret"}}}
+	if _, err := client.Chat(context.Background(), req); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if got := provider.last.Messages[0].Content; got != "[REDACTED]" {
+		t.Errorf("sent content = %q, want the hook's change", got)
+	}
+	if got := req.Messages[0].Content; got != "my secret" {
+		t.Errorf("caller's content = %q, want it unchanged", got)
+	}
+}
+
+func TestClient_StreamHooks(t *testing.T) {
+	var started, ended int
+	var chunks []string
+
+	client := &Client{
+		config: &config.Config{
+			Provider: "mock",
+			Hooks: []*types.Hooks{
+				{
+					OnStreamStart: func(ctx context.Context, req *types.ChatRequest) {
+						started++
+					},
+					OnChunk: func(ctx context.Context, chunk *types.ChatResponse) *types.ChatResponse {
+						if chunk.ID == "test-id-1" {
+							return nil
+						}
+						return chunk
+					},
+				},
+				{
+					OnChunk: func(ctx context.Context, chunk *types.ChatResponse) *types.ChatResponse {
+						chunks = append(chunks, chunk.Message.Content)
+						return chunk
+					},
+					OnStreamEnd: func(ctx context.Context, req *types.ChatRequest, err error) {
+						if err != nil {
+							t.Errorf("OnStreamEnd() err = %v", err)
+						}
+						ended++
+					},
+				},
+			},
+		},
+		provider: &mockProvider{},
+	}
+
+	stream, err := client.StreamChat(context.Background(), &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	})
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+
+	var got []string
+	for resp := range stream {
+		got = append(got, resp.Message.Content)
+	}
+
+	if len(got) != 1 || got[0] != " world!" {
+		t.Errorf("StreamChat() chunks = %q, want [\" world!\"]", got)
+	}
+	if len(chunks) != 1 {
+		t.Errorf("second OnChunk saw %d chunks, want 1", len(chunks))
+	}
+	if started != 1 || ended != 1 {
+		t.Errorf("stream hooks called start=%d end=%d, want 1 and 1", started, ended)
+	}
+}
+
+func TestClient_StreamWrapHook(t *testing.T) {
+	var seen []string
+	client := &Client{
+		config: &config.Config{
+			Provider: "mock",
+			Hooks: []*types.Hooks{{
+				OnStream: func(ctx context.Context, stream <-chan *types.ChatResponse) <-chan *types.ChatResponse {
+					return types.FilterStream(ctx, stream, types.BufferWords, func(s string) string {
+						return strings.ReplaceAll(s, "world", "there")
+					})
+				},
+				OnChunk: func(ctx context.Context, chunk *types.ChatResponse) *types.ChatResponse {
+					seen = append(seen, chunk.Message.Content)
+					return chunk
+				},
+			}},
+		},
+		provider: &mockProvider{},
+	}
+
+	stream, err := client.StreamChat(context.Background(), &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	})
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	resp, err := types.CollectStream(stream)
+	if err != nil {
+		t.Fatalf("stream error = %v", err)
+	}
+
+	if resp.Message.Content != "Hello there!" {
+		t.Errorf("content = %q, want %q", resp.Message.Content, "Hello there!")
+	}
+	if want := []string{"Hello ", "there!"}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("OnChunk saw %q, want %q", seen, want)
+	}
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// idempotentCall is a request in flight or recently completed under a key
+type idempotentCall struct {
+	done    chan struct{}
+	resp    any
+	err     error
+	expires time.Time
+}
+
+// expired reports whether a completed call's result may no longer be shared
+func (c *idempotentCall) expired(now time.Time) bool {
+	return !c.expires.IsZero() && now.After(c.expires)
+}
+
+// idempotencyStore de-duplicates submissions that share an idempotency key.
+// Concurrent duplicates wait for the first call; later duplicates within the
+// TTL receive its result. Failed calls are forgotten so they can be retried.
+// An expired call is dropped when its key is next used, and the rest at most
+// once per TTL.
+type idempotencyStore struct {
+	ttl   time.Duration
+	mu    sync.Mutex
+	calls map[string]*idempotentCall
+	swept time.Time
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		ttl:   ttl,
+		calls: make(map[string]*idempotentCall),
+		swept: time.Now(),
+	}
+}
+
+// lookup returns the live call under key, dropping it if it has expired.
+// Callers must hold s.mu.
+func (s *idempotencyStore) lookup(key string, now time.Time) (*idempotentCall, bool) {
+	if now.Sub(s.swept) >= s.ttl {
+		for k, call := range s.calls {
+			if call.expired(now) {
+				delete(s.calls, k)
+			}
+		}
+		s.swept = now
+	}
+
+	call, ok := s.calls[key]
+	if ok && call.expired(now) {
+		delete(s.calls, key)
+		return nil, false
+	}
+	return call, ok
+}
+
+// doIdempotent runs fn once per key, sharing its result with duplicates. Each
+// caller, the first included, receives its own deep copy of the response, so
+// none can change what the others see.
+func doIdempotent[T any, PT interface {
+	*T
+	Clone() *T
+}](ctx context.Context, s *idempotencyStore, key string, fn func() (*T, error)) (*T, error) {
+	s.mu.Lock()
+	if call, ok := s.lookup(key, time.Now()); ok {
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-call.done:
+		}
+		if call.err != nil {
+			return nil, call.err
+		}
+		return PT(call.resp.(*T)).Clone(), nil
+	}
+
+	call := &idempotentCall{done: make(chan struct{})}
+	s.calls[key] = call
+	s.mu.Unlock()
+
+	resp, err := fn()
+
+	s.mu.Lock()
+	call.resp, call.err = resp, err
+	if err != nil {
+		delete(s.calls, key)
+	} else {
+		call.expires = time.Now().Add(s.ttl)
+	}
+	s.mu.Unlock()
+	close(call.done)
+
+	if err != nil {
+		return nil, err
+	}
+	return PT(resp).Clone(), nil
+}
+
+// newIdempotencyKey returns a random RFC 4122 version 4 UUID
+func newIdempotencyKey() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// chatWithIdempotencyKey returns req, or a copy with a new idempotency key
+// if it has none. The caller's request is never written to, so it can be
+// reused, or read by other goroutines, while this one is in flight.
+func chatWithIdempotencyKey(req *types.ChatRequest) *types.ChatRequest {
+	if req.IdempotencyKey != "" {
+		return req
+	}
+	r := *req
+	r.IdempotencyKey = newIdempotencyKey()
+	return &r
+}
+
+// completionWithIdempotencyKey is chatWithIdempotencyKey for completions
+func completionWithIdempotencyKey(req *types.CompletionRequest) *types.CompletionRequest {
+	if req.IdempotencyKey != "" {
+		return req
+	}
+	r := *req
+	r.IdempotencyKey = newIdempotencyKey()
+	return &r
+}
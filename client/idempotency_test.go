@@ -0,0 +1,197 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestClient_IdempotentChat(t *testing.T) {
+	provider := &countingProvider{}
+	client := &Client{
+		config:   &config.Config{Provider: "mock", IdempotencyTTL: time.Minute},
+		provider: provider,
+		idem:     newIdempotencyStore(time.Minute),
+	}
+
+	newReq := func(key string) *types.ChatRequest {
+		return &types.ChatRequest{
+			Messages:       []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+			IdempotencyKey: key,
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.Chat(context.Background(), newReq("order-42")); err != nil {
+			t.Fatalf("Chat() error = %v", err)
+		}
+	}
+	if provider.chatCalls != 1 {
+		t.Errorf("provider called %d times for one key, want 1", provider.chatCalls)
+	}
+
+	// A request without a key gets a new one each time, set on a copy, so
+	// reusing the request sends it again
+	auto := newReq("")
+	for i := 0; i < 2; i++ {
+		if _, err := client.Chat(context.Background(), auto); err != nil {
+			t.Fatalf("Chat() error = %v", err)
+		}
+	}
+	if auto.IdempotencyKey != "" {
+		t.Errorf("caller's request was given key %q", auto.IdempotencyKey)
+	}
+	if provider.chatCalls != 3 {
+		t.Errorf("provider called %d times, want 3", provider.chatCalls)
+	}
+
+	generated := chatWithIdempotencyKey(auto).IdempotencyKey
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(generated) {
+		t.Errorf("generated key %q is not a v4 UUID", generated)
+	}
+}
+
+func TestDoIdempotent_Concurrent(t *testing.T) {
+	store := newIdempotencyStore(time.Minute)
+	var calls int32
+	release := make(chan struct{})
+
+	fn := func() (*types.ChatResponse, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &types.ChatResponse{Response: types.Response{ID: "shared"}}, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*types.ChatResponse, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := doIdempotent(context.Background(), store, "key", fn)
+			if err != nil {
+				t.Errorf("doIdempotent() error = %v", err)
+				return
+			}
+			results[i] = resp
+		}(i)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+	for i, resp := range results {
+		if resp == nil || resp.ID != "shared" {
+			t.Errorf("result %d = %+v, want shared response", i, resp)
+		}
+	}
+}
+
+func TestDoIdempotent_FailureNotCached(t *testing.T) {
+	store := newIdempotencyStore(time.Minute)
+	errTimeout := errors.New("network timeout")
+	calls := 0
+
+	fn := func() (*types.ChatResponse, error) {
+		calls++
+		if calls == 1 {
+			return nil, errTimeout
+		}
+		return &types.ChatResponse{Response: types.Response{ID: "ok"}}, nil
+	}
+
+	if _, err := doIdempotent(context.Background(), store, "key", fn); !errors.Is(err, errTimeout) {
+		t.Fatalf("first call error = %v, want %v", err, errTimeout)
+	}
+	resp, err := doIdempotent(context.Background(), store, "key", fn)
+	if err != nil || resp.ID != "ok" {
+		t.Errorf("retry = %+v, %v; want ok response", resp, err)
+	}
+}
+
+func TestDoIdempotent_CopiesResponse(t *testing.T) {
+	store := newIdempotencyStore(time.Minute)
+	fn := func() (*types.ChatResponse, error) {
+		return &types.ChatResponse{Response: types.Response{
+			ID:         "shared",
+			Message:    types.Message{Role: types.RoleAssistant, ToolCalls: []types.ToolCall{{ID: "call_1", Arguments: "{}"}}},
+			RateLimits: &types.RateLimits{RequestsRemaining: 10},
+			Metadata:   map[string]any{"user": "ann"},
+		}}, nil
+	}
+
+	first, err := doIdempotent(context.Background(), store, "key", fn)
+	if err != nil {
+		t.Fatalf("doIdempotent() error = %v", err)
+	}
+	first.Message.ToolCalls[0].Arguments = "changed"
+	first.RateLimits.RequestsRemaining = 0
+	first.Metadata["user"] = "changed"
+
+	for i := 0; i < 2; i++ {
+		dup, err := doIdempotent(context.Background(), store, "key", fn)
+		if err != nil {
+			t.Fatalf("doIdempotent() error = %v", err)
+		}
+		if dup.Message.ToolCalls[0].Arguments != "{}" || dup.RateLimits.RequestsRemaining != 10 || dup.Metadata["user"] != "ann" {
+			t.Errorf("duplicate %d = %+v, changed by another caller", i+1, dup)
+		}
+		dup.Metadata["user"] = "changed"
+	}
+}
+
+func TestDoIdempotent_Expiry(t *testing.T) {
+	tests := []struct {
+		name      string
+		age       time.Duration
+		wantCalls int
+	}{
+		{"within the TTL", 0, 0},
+		{"expired", 2 * time.Minute, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newIdempotencyStore(time.Minute)
+			calls := 0
+			fn := func() (*types.ChatResponse, error) {
+				calls++
+				return &types.ChatResponse{}, nil
+			}
+
+			doIdempotent(context.Background(), store, "key", fn)
+			doIdempotent(context.Background(), store, "other", fn)
+			store.calls["key"].expires = store.calls["key"].expires.Add(-tt.age)
+			store.calls["other"].expires = store.calls["other"].expires.Add(-tt.age)
+
+			calls = 0
+			doIdempotent(context.Background(), store, "key", fn)
+			doIdempotent(context.Background(), store, "key", fn)
+			if calls != tt.wantCalls {
+				t.Errorf("fn called %d times for the repeated key, want %d", calls, tt.wantCalls)
+			}
+			if _, ok := store.calls["other"]; !ok {
+				t.Errorf("untouched key dropped before a sweep")
+			}
+
+			// Once a TTL has passed since the last sweep, the next call
+			// drops every expired key
+			store.swept = store.swept.Add(-2 * time.Minute)
+			doIdempotent(context.Background(), store, "third", fn)
+			if _, ok := store.calls["other"]; ok != (tt.age == 0) {
+				t.Errorf("untouched key present = %v after a sweep, want %v", ok, tt.age == 0)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/oauth"
+	"github.com/ksred/llm/pkg/resource"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// countingTransport counts the requests it sends
+type countingTransport struct {
+	requests atomic.Int32
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestClient_APIKeyFailover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer sk-revoked-1111" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{"message": "invalid key"}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"model":   "gpt-4",
+			"choices": []map[string]any{{"message": map[string]any{"role": "assistant", "content": "Hello"}, "finish_reason": "stop"}},
+		})
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		transport *countingTransport
+	}{
+		{"default transport", nil},
+		{"custom pool transport", &countingTransport{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []types.KeyHealth
+			opts := []config.Option{
+				config.WithAPIKeys("sk-revoked-1111", "sk-working-2222"),
+				config.WithBaseURL(server.URL),
+				config.WithMetrics(&types.MetricsCallbacks{
+					OnKeyHealth: func(provider string, h types.KeyHealth) { events = append(events, h) },
+				}),
+			}
+			if tt.transport != nil {
+				opts = append(opts, config.WithPoolConfig(&resource.PoolConfig{
+					MaxSize:       2,
+					IdleTimeout:   time.Minute,
+					CleanupPeriod: time.Minute,
+					Transport:     tt.transport,
+				}))
+			}
+			cfg, err := config.NewConfig("", opts...)
+			if err != nil {
+				t.Fatalf("NewConfig() error = %v", err)
+			}
+			c, err := NewClient(cfg)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			defer c.Close()
+
+			for i := 0; i < 2; i++ {
+				resp, err := c.Chat(context.Background(), types.NewChatRequest([]types.Message{{Role: types.RoleUser, Content: "Hi"}}))
+				if err != nil {
+					t.Fatalf("Chat() error = %v", err)
+				}
+				if resp.Message.Content != "Hello" {
+					t.Errorf("Chat() = %q, want Hello", resp.Message.Content)
+				}
+			}
+
+			health := c.KeyHealth()
+			if len(health) != 2 || !health[0].Revoked || health[1].Requests != 2 || !health[1].Healthy {
+				t.Errorf("KeyHealth() = %+v, want the first key revoked and the second serving both requests", health)
+			}
+			if len(events) != 1 || events[0].Key != "...1111" {
+				t.Errorf("OnKeyHealth events = %+v, want one for the revoked key", events)
+			}
+			if tt.transport != nil && tt.transport.requests.Load() != 3 {
+				t.Errorf("pool transport sent %d requests, want 3", tt.transport.requests.Load())
+			}
+		})
+	}
+}
+
+func TestClient_TokenSource(t *testing.T) {
+	var gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		json.NewEncoder(w).Encode(map[string]any{
+			"model":   "gpt-4",
+			"choices": []map[string]any{{"message": map[string]any{"role": "assistant", "content": "Hello"}, "finish_reason": "stop"}},
+		})
+	}))
+	defer server.Close()
+
+	cfg, err := config.NewConfig("",
+		config.WithTokenSource(oauth.StaticToken("ad-token")),
+		config.WithBaseURL(server.URL),
+	)
+	if err != nil {
+		t.Fatalf("NewConfig() error = %v", err)
+	}
+	c, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.Chat(context.Background(), types.NewChatRequest([]types.Message{{Role: types.RoleUser, Content: "Hi"}})); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if gotAuth != "Bearer ad-token" {
+		t.Errorf("Authorization = %q, want Bearer ad-token", gotAuth)
+	}
+}
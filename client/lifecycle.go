@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"io"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// begin registers an in-flight request, failing once the client is closed.
+// Callers must call c.end when the request, or its stream, ends.
+func (c *Client) begin() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return types.ErrClientClosed
+	}
+	c.inflight.Add(1)
+	c.active.Add(1)
+	return nil
+}
+
+// end marks a request registered with begin as finished
+func (c *Client) end() {
+	c.active.Add(-1)
+	c.inflight.Done()
+}
+
+// Close stops the client from accepting new requests, waits for in-flight
+// requests and streams to finish, then releases the provider's connection
+// pool and background goroutines. Later calls return types.ErrClientClosed.
+// Close is safe to call more than once.
+func (c *Client) Close() error {
+	return c.Shutdown(context.Background())
+}
+
+// Shutdown is like Close but waits for in-flight requests and streams only
+// until ctx ends. It then cancels them with types.ErrClientClosed, waits for
+// them to return and releases the client's resources, returning ctx's error.
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		c.shutdownContext()
+		c.abort()
+		<-drained
+	}
+
+	c.closeOnce.Do(func() {
+		if c.stopGauges != nil {
+			close(c.stopGauges)
+			<-c.gaugesDone
+		}
+		if closer, ok := c.provider.(io.Closer); ok {
+			if cerr := closer.Close(); err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
+
+// shutdownContext returns the context Shutdown cancels when it stops
+// waiting for in-flight requests
+func (c *Client) shutdownContext() context.Context {
+	c.haltOnce.Do(func() {
+		c.halt, c.abort = context.WithCancel(context.Background())
+	})
+	return c.halt
+}
+
+// withShutdown derives a request context that is cancelled with
+// types.ErrClientClosed if Shutdown stops waiting for it
+func (c *Client) withShutdown(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(c.shutdownContext(), func() {
+		cancel(types.ErrClientClosed)
+	})
+	return ctx, func() {
+		stop()
+		cancel(context.Canceled)
+	}
+}
+
+// forwardStream copies in to a new channel, calling onItem for each value,
+// and calls onClose once in has been drained, so per-stream resources live
+// exactly as long as the stream. onClose runs before the new channel is
+// closed, so whatever it records is in place once the caller has drained
+// the stream. If ctx ends first the remainder of in is discarded.
+func forwardStream[T any](ctx context.Context, in <-chan T, onItem func(T), onClose func()) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		defer onClose()
+		for v := range in {
+			onItem(v)
+			select {
+			case <-ctx.Done():
+				for range in {
+				}
+				return
+			case out <- v:
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// closableProvider records whether Close was called
+type closableProvider struct {
+	mockProvider
+	closed bool
+}
+
+func (p *closableProvider) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestClient_Close(t *testing.T) {
+	provider := &closableProvider{}
+	client := &Client{
+		config:   &config.Config{Provider: "mock"},
+		provider: provider,
+	}
+
+	req := &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	}
+
+	stream, err := client.StreamChat(context.Background(), req)
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- client.Close()
+	}()
+
+	// Close must wait for the open stream to be drained
+	select {
+	case <-closed:
+		t.Fatal("Close() returned while a stream was in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if _, err := client.Chat(context.Background(), req); !errors.Is(err, types.ErrClientClosed) {
+		t.Errorf("Chat() during Close error = %v, want %v", err, types.ErrClientClosed)
+	}
+
+	for range stream {
+	}
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close() did not return after stream was drained")
+	}
+
+	if !provider.closed {
+		t.Error("Close() did not close the provider")
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+	if _, err := client.StreamChat(context.Background(), req); !errors.Is(err, types.ErrClientClosed) {
+		t.Errorf("StreamChat() after Close error = %v, want %v", err, types.ErrClientClosed)
+	}
+}
+
+// endlessProvider streams chunks until its context is cancelled
+type endlessProvider struct {
+	slowProvider
+}
+
+func (p *endlessProvider) StreamChat(ctx context.Context, req *types.ChatRequest) (<-chan *types.ChatResponse, error) {
+	ch := make(chan *types.ChatResponse)
+	go func() {
+		defer close(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- &types.ChatResponse{Response: types.Response{Message: types.Message{Role: types.RoleAssistant, Content: "."}}}:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func TestClient_Shutdown(t *testing.T) {
+	client := &Client{
+		config:   &config.Config{Provider: "mock"},
+		provider: &closableProvider{},
+	}
+	req := &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	}
+
+	if _, err := client.Chat(context.Background(), req); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	// With nothing in flight Shutdown returns straight away
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+	if !client.provider.(*closableProvider).closed {
+		t.Error("Shutdown() did not close the provider")
+	}
+	if _, err := client.Chat(context.Background(), req); !errors.Is(err, types.ErrClientClosed) {
+		t.Errorf("Chat() after Shutdown error = %v, want %v", err, types.ErrClientClosed)
+	}
+}
+
+func TestClient_ShutdownForceCancels(t *testing.T) {
+	client := &Client{
+		config:   &config.Config{Provider: "mock"},
+		provider: &endlessProvider{slowProvider{delay: time.Hour}},
+	}
+	req := &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	}
+
+	stream, err := client.StreamChat(context.Background(), req)
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	streamDone := make(chan struct{})
+	go func() {
+		defer close(streamDone)
+		for range stream {
+		}
+	}()
+
+	chatErr := make(chan error, 1)
+	go func() {
+		_, err := client.Chat(context.Background(), req)
+		chatErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := client.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown() took %v after its deadline", elapsed)
+	}
+
+	if err := <-chatErr; !errors.Is(err, types.ErrClientClosed) {
+		t.Errorf("in-flight Chat() error = %v, want %v", err, types.ErrClientClosed)
+	}
+	select {
+	case <-streamDone:
+	case <-time.After(time.Second):
+		t.Error("in-flight stream was not closed by Shutdown")
+	}
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/ksred/llm/internal/redact"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// redacted replaces secrets in log output
+const redacted = redact.Placeholder
+
+// redactingHandler wraps a slog.Handler, removing API keys from messages and
+// attribute values before they are written
+type redactingHandler struct {
+	next    slog.Handler
+	secrets *redact.Redactor
+}
+
+// newLogger wraps logger so that the given secrets, and anything that looks
+// like a credential, never appear in its output
+func newLogger(logger *slog.Logger, secrets ...string) *slog.Logger {
+	if logger == nil {
+		return nil
+	}
+	return slog.New(&redactingHandler{next: logger.Handler(), secrets: redact.New(secrets...)})
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, h.redactString(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redactedAttrs := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redactedAttrs[i] = h.redactAttr(a)
+	}
+	return &redactingHandler{next: h.next.WithAttrs(redactedAttrs), secrets: h.secrets}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name), secrets: h.secrets}
+}
+
+func (h *redactingHandler) redactAttr(a slog.Attr) slog.Attr {
+	if redact.IsSensitive(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, h.redactString(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		attrs := make([]any, len(group))
+		for i, g := range group {
+			attrs[i] = h.redactAttr(g)
+		}
+		return slog.Group(a.Key, attrs...)
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return slog.String(a.Key, h.redactString(err.Error()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+func (h *redactingHandler) redactString(s string) string {
+	return h.secrets.String(s)
+}
+
+// redactingMetrics returns metrics callbacks that redact secrets from the
+// errors they pass to next. It returns nil if next is nil.
+func redactingMetrics(secrets *redact.Redactor, next *types.MetricsCallbacks) *types.MetricsCallbacks {
+	if next == nil {
+		return nil
+	}
+	m := *next
+	if onError := next.OnError; onError != nil {
+		m.OnError = func(provider string, err error) {
+			onError(provider, secrets.Error(err))
+		}
+	}
+	if onRetry := next.OnRetry; onRetry != nil {
+		m.OnRetry = func(provider string, attempt int, err error) {
+			onRetry(provider, attempt, secrets.Error(err))
+		}
+	}
+	return &m
+}
+
+// loggingMetrics returns metrics callbacks that log retries and pool
+// exhaustion before calling next
+func loggingMetrics(logger *slog.Logger, next *types.MetricsCallbacks) *types.MetricsCallbacks {
+	m := &types.MetricsCallbacks{}
+	if next != nil {
+		*m = *next
+	}
+
+	onRetry := m.OnRetry
+	m.OnRetry = func(provider string, attempt int, err error) {
+		logger.Warn("llm request retrying", "provider", provider, "attempt", attempt, "error", err)
+		if onRetry != nil {
+			onRetry(provider, attempt, err)
+		}
+	}
+
+	onExhausted := m.OnPoolExhausted
+	m.OnPoolExhausted = func(provider string) {
+		logger.Warn("llm connection pool exhausted", "provider", provider)
+		if onExhausted != nil {
+			onExhausted(provider)
+		}
+	}
+	return m
+}
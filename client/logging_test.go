@@ -0,0 +1,147 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/resource"
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestNewLogger_Redacts(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(slog.New(slog.NewTextHandler(&buf, nil)), "sk-secret")
+
+	logger.With("header", "Bearer sk-secret").Info("using key sk-secret",
+		"api_key", "anything",
+		"error", errors.New("401 for key sk-secret"),
+		slog.Group("request", "authorization", "Bearer sk-secret"),
+	)
+
+	out := buf.String()
+	if strings.Contains(out, "sk-secret") || strings.Contains(out, "anything") {
+		t.Errorf("log output leaked a secret: %s", out)
+	}
+	if strings.Count(out, redacted) != 5 {
+		t.Errorf("log output = %s, want 5 redactions", out)
+	}
+}
+
+func TestClient_Logging(t *testing.T) {
+	var buf bytes.Buffer
+	client := &Client{
+		config:   &config.Config{Provider: "mock", Model: "test-model", APIKey: "sk-secret"},
+		provider: &mockProvider{},
+		logger:   newLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})), "sk-secret"),
+	}
+	ctx := context.Background()
+	req := &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	}
+
+	if _, err := client.Chat(ctx, req); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	stream, err := client.StreamChat(ctx, req)
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	for range stream {
+	}
+	client.Close()
+
+	out := buf.String()
+	for _, want := range []string{
+		`msg="llm request started" op=chat provider=mock model=test-model`,
+		`msg="llm request completed" op=chat`,
+		`msg="llm request started" op=stream_chat`,
+		`msg="llm stream completed" op=stream_chat`,
+		`chunks=2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestLoggingMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	var retried bool
+	m := loggingMetrics(slog.New(slog.NewTextHandler(&buf, nil)), &types.MetricsCallbacks{
+		OnRetry: func(provider string, attempt int, err error) { retried = true },
+	})
+
+	m.OnRetry("openai", 1, errors.New("server error"))
+	if !retried {
+		t.Error("wrapped OnRetry not called")
+	}
+	if !strings.Contains(buf.String(), `msg="llm request retrying" provider=openai attempt=1`) {
+		t.Errorf("log output = %s", buf.String())
+	}
+}
+
+func TestClient_RedactsErrors(t *testing.T) {
+	const key = "org-key-0123456789"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A misbehaving provider echoing the credentials it was sent
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{
+			"message": "upstream rejected key " + key + " (" + r.Header.Get("Authorization") + ")",
+		}})
+	}))
+	defer server.Close()
+
+	var reported []error
+	record := func(err error) { reported = append(reported, err) }
+	c, err := NewClient(&config.Config{
+		Provider: "openai",
+		Model:    "gpt-4",
+		APIKey:   key,
+		BaseURL:  server.URL,
+		RetryConfig: &resource.RetryConfig{
+			MaxRetries:      1,
+			InitialInterval: time.Millisecond,
+			MaxInterval:     time.Millisecond,
+			Multiplier:      2,
+		},
+		Metrics: &types.MetricsCallbacks{
+			OnError: func(provider string, err error) { record(err) },
+			OnRetry: func(provider string, attempt int, err error) { record(err) },
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer c.Close()
+
+	_, err = c.Chat(context.Background(), types.NewChatRequest([]types.Message{{Role: types.RoleUser, Content: "Hi"}}))
+	if !errors.Is(err, types.ErrProviderError) {
+		t.Fatalf("Chat() error = %v, want %v", err, types.ErrProviderError)
+	}
+	var pe *types.ProviderError
+	if !errors.As(err, &pe) {
+		t.Fatalf("Chat() error = %v, want a *types.ProviderError", err)
+	}
+	if len(reported) == 0 {
+		t.Fatal("no errors reported to metrics callbacks")
+	}
+
+	for _, err := range append(reported, err, errors.New(pe.Message)) {
+		if strings.Contains(err.Error(), key) {
+			t.Errorf("error leaked the API key: %v", err)
+		}
+		if !strings.Contains(err.Error(), redacted) {
+			t.Errorf("error = %v, want the key redacted", err)
+		}
+	}
+}
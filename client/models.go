@@ -0,0 +1,9 @@
+package client
+
+import "github.com/ksred/llm/pkg/models"
+
+// ModelInfo returns the registry's metadata for the client's configured
+// model, and false if the model is not in the registry
+func (c *Client) ModelInfo() (models.Model, bool) {
+	return models.Lookup(c.config.Model)
+}
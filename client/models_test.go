@@ -0,0 +1,114 @@
+package client
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/models"
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestClient_ModelInfo(t *testing.T) {
+	tests := []struct {
+		model        string
+		wantFound    bool
+		wantProvider string
+	}{
+		{model: "gpt-4o-2024-08-06", wantFound: true, wantProvider: "openai"},
+		{model: "claude-3-haiku-20240307", wantFound: true, wantProvider: "anthropic"},
+		{model: "my-fine-tune", wantFound: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			c := &Client{config: &config.Config{Model: tt.model}}
+			m, ok := c.ModelInfo()
+			if ok != tt.wantFound || m.Provider != tt.wantProvider {
+				t.Errorf("ModelInfo() = %+v, %v, want provider %q, %v", m, ok, tt.wantProvider, tt.wantFound)
+			}
+			if ok && !m.Accepts(models.ModalityText) {
+				t.Errorf("ModelInfo() model does not accept text")
+			}
+		})
+	}
+}
+
+func TestUpgradeModel(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        config.Config
+		wantModel  string
+		wantWarned bool
+	}{
+		{
+			name:      "active model",
+			cfg:       config.Config{Provider: "openai", Model: "gpt-4o", UpgradeModels: true},
+			wantModel: "gpt-4o",
+		},
+		{
+			name:       "retired model kept without upgrades",
+			cfg:        config.Config{Provider: "anthropic", Model: "claude-2.1"},
+			wantModel:  "claude-2.1",
+			wantWarned: true,
+		},
+		{
+			name:       "retired model upgraded",
+			cfg:        config.Config{Provider: "anthropic", Model: "claude-2.1", UpgradeModels: true},
+			wantModel:  "claude-sonnet-4-20250514",
+			wantWarned: true,
+		},
+		{
+			name: "configured successor",
+			cfg: config.Config{
+				Provider: "anthropic", Model: "claude-2.1", UpgradeModels: true,
+				ModelSuccessors: map[string]string{"claude-2.1": "claude-3-5-haiku-20241022"},
+			},
+			wantModel:  "claude-3-5-haiku-20241022",
+			wantWarned: true,
+		},
+		{
+			name: "configured successor for active model",
+			cfg: config.Config{
+				Provider: "openai", Model: "gpt-4", UpgradeModels: true,
+				ModelSuccessors: map[string]string{"gpt-4": "gpt-4o"},
+			},
+			wantModel: "gpt-4o",
+		},
+		{
+			name:      "unknown model",
+			cfg:       config.Config{Provider: "openai", Model: "my-fine-tune", UpgradeModels: true},
+			wantModel: "my-fine-tune",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			var warned string
+			cfg := tt.cfg
+			cfg.Metrics = &types.MetricsCallbacks{
+				OnModelDeprecated: func(provider, model, successor string, retiresAt time.Time) {
+					warned = model
+				},
+			}
+
+			got := upgradeModel(&cfg, slog.New(slog.NewTextHandler(&buf, nil)))
+			if got.Model != tt.wantModel {
+				t.Errorf("model = %q, want %q", got.Model, tt.wantModel)
+			}
+			if cfg.Model != tt.cfg.Model {
+				t.Errorf("original config changed to %q", cfg.Model)
+			}
+			if (warned != "") != tt.wantWarned {
+				t.Errorf("OnModelDeprecated called = %v, want %v", warned != "", tt.wantWarned)
+			}
+			if tt.wantWarned && !strings.Contains(buf.String(), "model is retired") {
+				t.Errorf("log = %q, want a retirement warning", buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ksred/llm/internal/tokenizer"
+	"github.com/ksred/llm/pkg/audit"
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/resource"
+	"github.com/ksred/llm/pkg/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies spans created by this package
+const tracerName = "github.com/ksred/llm"
+
+// observation logs and traces the lifecycle of a single request or stream
+type observation struct {
+	logger   *slog.Logger // nil when logging is disabled
+	metrics  *types.MetricsCallbacks
+	span     trace.Span
+	retries  *atomic.Int64
+	auditor  *audit.Auditor
+	record   *audit.Record // nil when auditing is disabled
+	tracker  *cost.CostTracker
+	pricing  *cost.PricingCatalog
+	catalog  *cost.PricingCatalog // prices tracked usage; nil uses the tracker's
+	health   *healthTracker
+	labels   map[string]string
+	metadata map[string]any // the request's RequestMetadata
+	provider string
+	model    string
+	start    time.Time
+
+	// Stream state
+	chunks       int
+	firstToken   time.Time
+	promptTokens int             // estimated, for streams without reported usage
+	streamTokens int             // estimated from content when usage is not reported
+	content      strings.Builder // stream content, kept only when auditing
+	streamErr    error
+	usage        types.Usage
+	finishReason types.FinishReason
+}
+
+// observe starts logging, tracing and auditing an operation on req, which
+// is a *types.ChatRequest or *types.CompletionRequest. The returned context
+// carries the span and must be used for the rest of the operation so that
+// provider HTTP requests join the trace.
+func (c *Client) observe(ctx context.Context, op string, req any) (context.Context, *observation) {
+	tp := c.config.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	ctx, span := tp.Tracer(tracerName).Start(ctx, "llm."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("llm.operation", op),
+			attribute.String("llm.provider", c.config.Provider),
+			attribute.String("llm.model", c.config.Model),
+		),
+	)
+	ctx, retries := resource.WithRetryCounter(ctx)
+
+	o := &observation{
+		metrics:  c.config.Metrics,
+		pricing:  c.pricing(),
+		health:   c.health,
+		span:     span,
+		retries:  retries,
+		provider: c.config.Provider,
+		model:    c.config.Model,
+		start:    time.Now(),
+	}
+	switch r := req.(type) {
+	case *types.ChatRequest:
+		o.metadata = r.RequestMetadata
+	case *types.CompletionRequest:
+		o.metadata = r.RequestMetadata
+	}
+	if c.logger != nil {
+		o.logger = c.logger.With("op", op, "provider", c.config.Provider, "model", c.config.Model)
+		o.logger.DebugContext(ctx, "llm request started")
+	}
+	if c.config.CostTracker != nil {
+		o.tracker = c.config.CostTracker
+		o.catalog = c.settings().pricing
+		// Labels set on the request win over its metadata, and both over
+		// labels set on the context
+		o.labels = types.MergeLabels(types.LabelsFromContext(ctx), types.MetadataLabels(o.metadata))
+		switch r := req.(type) {
+		case *types.ChatRequest:
+			o.labels = types.MergeLabels(o.labels, r.Labels)
+			o.promptTokens = tokenizer.CountChat(r)
+		case *types.CompletionRequest:
+			o.labels = types.MergeLabels(o.labels, r.Labels)
+			o.promptTokens = tokenizer.Count(r.Prompt)
+		}
+	}
+	if c.config.Audit != nil {
+		o.auditor = c.config.Audit
+		o.record = &audit.Record{
+			Time:     o.start,
+			Op:       op,
+			Provider: c.config.Provider,
+			Model:    c.config.Model,
+			Metadata: o.metadata,
+		}
+		switch r := req.(type) {
+		case *types.ChatRequest:
+			o.record.ChatRequest = r
+		case *types.CompletionRequest:
+			o.record.CompletionRequest = r
+		}
+	}
+	return context.WithValue(ctx, observationKey{}, o), o
+}
+
+type observationKey struct{}
+
+// observationFrom returns the observation started for ctx, or nil
+func observationFrom(ctx context.Context) *observation {
+	o, _ := ctx.Value(observationKey{}).(*observation)
+	return o
+}
+
+// degrade records that a degraded budget sent the request to model, so its
+// cost is tracked and reported against that model
+func (o *observation) degrade(model string) {
+	o.model = model
+	o.span.SetAttributes(
+		attribute.String("llm.model", model),
+		attribute.Bool("llm.budget.degraded", true),
+	)
+	if o.logger != nil {
+		o.logger = o.logger.With("degraded_model", model)
+	}
+	if o.record != nil {
+		o.record.Model = model
+	}
+}
+
+// downgrade records that a rate limited request was retried on model, so
+// its cost is tracked and reported against that model
+func (o *observation) downgrade(model string) {
+	o.model = model
+	o.span.SetAttributes(
+		attribute.String("llm.model", model),
+		attribute.Bool("llm.rate_limit.downgraded", true),
+	)
+	if o.logger != nil {
+		o.logger = o.logger.With("downgraded_model", model)
+	}
+	if o.record != nil {
+		o.record.Model = model
+	}
+}
+
+// failed records a request that returned an error
+func (o *observation) failed(ctx context.Context, err error) {
+	if o.logger != nil {
+		o.logger.ErrorContext(ctx, "llm request failed", "duration", time.Since(o.start), "error", err)
+	}
+	o.health.record(time.Since(o.start), err)
+	o.audit(ctx, nil, err)
+	o.endSpan(err)
+}
+
+// succeeded records a completed request
+func (o *observation) succeeded(ctx context.Context, resp *types.Response) {
+	if o.logger != nil {
+		o.logger.InfoContext(ctx, "llm request completed",
+			"duration", time.Since(o.start),
+			"prompt_tokens", resp.Usage.PromptTokens,
+			"completion_tokens", resp.Usage.CompletionTokens,
+			"finish_reason", string(resp.FinishReason),
+			"cached", resp.Cached,
+		)
+	}
+
+	o.span.SetAttributes(attribute.Bool("llm.cached", resp.Cached))
+	o.setUsage(resp.Usage, resp.FinishReason, !resp.Cached)
+	if !resp.Cached {
+		o.reportUsage(resp.Usage)
+		o.track(ctx, resp.Usage)
+		o.health.record(time.Since(o.start), nil)
+	}
+	o.audit(ctx, resp, nil)
+	o.endSpan(nil)
+}
+
+// chunk records a stream chunk
+func (o *observation) chunk(resp *types.Response) {
+	o.chunks++
+	if resp.Message.Content != "" {
+		if o.firstToken.IsZero() {
+			o.firstToken = time.Now()
+			if o.metrics != nil && o.metrics.OnFirstToken != nil {
+				o.metrics.OnFirstToken(o.provider, o.firstToken.Sub(o.start))
+			}
+		}
+		o.streamTokens += tokenizer.Count(resp.Message.Content)
+		if o.record != nil {
+			o.content.WriteString(resp.Message.Content)
+		}
+	}
+	if resp.Error != nil && o.streamErr == nil {
+		o.streamErr = resp.Error
+	}
+	if resp.Usage.TotalTokens > 0 {
+		o.usage = resp.Usage
+	}
+	if resp.FinishReason != "" {
+		o.finishReason = resp.FinishReason
+	}
+}
+
+// streamEnded records the end of a stream. It must be called before the
+// stream's context is cancelled so that early termination is reported.
+func (o *observation) streamEnded(ctx context.Context) {
+	err := o.streamErr
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	if o.logger != nil {
+		attrs := []any{
+			"duration", time.Since(o.start),
+			"chunks", o.chunks,
+			"finish_reason", string(o.finishReason),
+		}
+		if o.usage.TotalTokens > 0 {
+			attrs = append(attrs, "prompt_tokens", o.usage.PromptTokens, "completion_tokens", o.usage.CompletionTokens)
+		}
+		if err != nil {
+			o.logger.ErrorContext(ctx, "llm stream failed", append(attrs, "error", err)...)
+		} else {
+			o.logger.InfoContext(ctx, "llm stream completed", attrs...)
+		}
+	}
+
+	latency := time.Since(o.start)
+	if !o.firstToken.IsZero() {
+		latency = o.firstToken.Sub(o.start)
+	}
+	o.health.record(latency, err)
+
+	o.span.SetAttributes(attribute.Int("llm.stream.chunks", o.chunks))
+	o.setUsage(o.usage, o.finishReason, true)
+	o.reportUsage(o.usage)
+	o.reportStream()
+	if o.usage.TotalTokens > 0 {
+		o.track(ctx, o.usage)
+	} else {
+		o.track(ctx, types.Usage{
+			PromptTokens:     o.promptTokens,
+			CompletionTokens: o.streamTokens,
+			TotalTokens:      o.promptTokens + o.streamTokens,
+		})
+	}
+	if o.record != nil {
+		o.audit(ctx, &types.Response{
+			Provider:     o.provider,
+			Model:        o.model,
+			Message:      types.Message{Role: types.RoleAssistant, Content: o.content.String()},
+			FinishReason: o.finishReason,
+			Usage:        o.usage,
+			Metadata:     o.metadata,
+		}, err)
+	}
+	o.endSpan(err)
+}
+
+// audit writes the audit record for the operation. It runs even if ctx has
+// been cancelled, so that aborted streams are still recorded.
+func (o *observation) audit(ctx context.Context, resp *types.Response, err error) {
+	if o.record == nil {
+		return
+	}
+	o.record.Duration = time.Since(o.start)
+	o.record.Response = resp
+	if err != nil {
+		o.record.Error = err.Error()
+	}
+	o.auditor.Record(context.WithoutCancel(ctx), o.record)
+}
+
+// track records usage in the configured cost tracker, attributed to the
+// request's labels and priced like the client's requests and budgets
+func (o *observation) track(ctx context.Context, usage types.Usage) {
+	if o.tracker == nil || usage.TotalTokens == 0 {
+		return
+	}
+	if err := o.tracker.TrackUsageWithCatalog(o.catalog, o.provider, o.model, usage, o.labels); err != nil && o.logger != nil {
+		o.logger.WarnContext(ctx, "llm usage not tracked", "error", err)
+	}
+}
+
+// reportUsage passes reported token usage to the OnUsage and
+// OnUsageMetadata callbacks
+func (o *observation) reportUsage(usage types.Usage) {
+	if usage.TotalTokens == 0 || o.metrics == nil {
+		return
+	}
+	if o.metrics.OnUsage != nil {
+		o.metrics.OnUsage(o.provider, o.model, usage)
+	}
+	if o.metrics.OnUsageMetadata != nil {
+		o.metrics.OnUsageMetadata(o.provider, o.model, usage, o.metadata)
+	}
+}
+
+// reportStream passes stream throughput to the OnStreamComplete callback.
+// Tokens per second is measured from the first token, so it excludes the
+// time spent waiting for the model to start responding.
+func (o *observation) reportStream() {
+	if o.metrics == nil || o.metrics.OnStreamComplete == nil {
+		return
+	}
+
+	tokens := o.usage.CompletionTokens
+	if tokens == 0 {
+		tokens = o.streamTokens
+	}
+
+	var rate float64
+	if !o.firstToken.IsZero() {
+		if elapsed := time.Since(o.firstToken); elapsed > 0 {
+			rate = float64(tokens) / elapsed.Seconds()
+		}
+	}
+	o.metrics.OnStreamComplete(o.provider, o.chunks, tokens, rate)
+}
+
+// setUsage adds token usage, and optionally its cost, to the span
+func (o *observation) setUsage(usage types.Usage, finish types.FinishReason, billed bool) {
+	if !o.span.IsRecording() {
+		return
+	}
+	if finish != "" {
+		o.span.SetAttributes(attribute.String("llm.finish_reason", string(finish)))
+	}
+	if usage.TotalTokens == 0 {
+		return
+	}
+	o.span.SetAttributes(
+		attribute.Int("llm.usage.prompt_tokens", usage.PromptTokens),
+		attribute.Int("llm.usage.completion_tokens", usage.CompletionTokens),
+		attribute.Int("llm.usage.total_tokens", usage.TotalTokens),
+	)
+	if billed {
+		o.span.SetAttributes(attribute.Float64("llm.cost_usd", o.pricing.Cost(o.provider, o.model, usage)))
+	}
+}
+
+// endSpan sets the retry count and status, then ends the span
+func (o *observation) endSpan(err error) {
+	o.span.SetAttributes(attribute.Int64("llm.retry_count", o.retries.Load()))
+	if err != nil {
+		o.span.RecordError(err)
+		o.span.SetStatus(codes.Error, err.Error())
+	}
+	o.span.End()
+}
@@ -0,0 +1,538 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/audit"
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/resource"
+	"github.com/ksred/llm/pkg/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func spanAttrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestClient_Tracing(t *testing.T) {
+	prev := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(prev)
+
+	var calls int
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		traceparent = r.Header.Get("Traceparent")
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":    "test-id",
+			"model": "gpt-4",
+			"choices": []map[string]interface{}{
+				{"message": map[string]interface{}{"role": "assistant", "content": "Hello"}, "finish_reason": "stop"},
+			},
+			"usage": map[string]interface{}{"prompt_tokens": 1000, "completion_tokens": 500, "total_tokens": 1500},
+		})
+	}))
+	defer server.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	c, err := NewClient(&config.Config{
+		Provider:       "openai",
+		Model:          "gpt-4",
+		APIKey:         "test-key",
+		BaseURL:        server.URL,
+		TracerProvider: tp,
+		RetryConfig: &resource.RetryConfig{
+			MaxRetries:      2,
+			InitialInterval: time.Millisecond,
+			MaxInterval:     time.Millisecond,
+			Multiplier:      2,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.Chat(context.Background(), &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	}); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "llm.chat" {
+		t.Errorf("span name = %q, want llm.chat", span.Name())
+	}
+
+	attrs := spanAttrs(span)
+	if got := attrs["llm.provider"].AsString(); got != "openai" {
+		t.Errorf("llm.provider = %q, want openai", got)
+	}
+	if got := attrs["llm.model"].AsString(); got != "gpt-4" {
+		t.Errorf("llm.model = %q, want gpt-4", got)
+	}
+	if got := attrs["llm.usage.total_tokens"].AsInt64(); got != 1500 {
+		t.Errorf("llm.usage.total_tokens = %d, want 1500", got)
+	}
+	if got := attrs["llm.cost_usd"].AsFloat64(); got <= 0 {
+		t.Errorf("llm.cost_usd = %v, want > 0", got)
+	}
+	if got := attrs["llm.retry_count"].AsInt64(); got != 1 {
+		t.Errorf("llm.retry_count = %d, want 1", got)
+	}
+
+	want := "00-" + span.SpanContext().TraceID().String() + "-" + span.SpanContext().SpanID().String() + "-01"
+	if traceparent != want {
+		t.Errorf("traceparent header = %q, want %q", traceparent, want)
+	}
+}
+
+func TestClient_TracingStreamAndErrors(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx := context.Background()
+	req := &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	}
+
+	c := &Client{
+		config:   &config.Config{Provider: "mock", Model: "test-model", TracerProvider: tp},
+		provider: &mockProvider{},
+	}
+	stream, err := c.StreamChat(ctx, req)
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	for range stream {
+	}
+	c.Close()
+
+	failing := &Client{
+		config: &config.Config{
+			Provider:       "mock",
+			Model:          "test-model",
+			TracerProvider: tp,
+			Hooks: []*types.Hooks{{
+				OnRequest: func(ctx context.Context, req *types.ChatRequest) error {
+					return errors.New("rejected")
+				},
+			}},
+		},
+		provider: &mockProvider{},
+	}
+	if _, err := failing.Chat(ctx, req); err == nil {
+		t.Fatal("Chat() error = nil, want error")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+
+	if spans[0].Name() != "llm.stream_chat" {
+		t.Errorf("span name = %q, want llm.stream_chat", spans[0].Name())
+	}
+	if got := spanAttrs(spans[0])["llm.stream.chunks"].AsInt64(); got != 2 {
+		t.Errorf("llm.stream.chunks = %d, want 2", got)
+	}
+
+	if spans[1].Status().Code != codes.Error {
+		t.Errorf("failed span status = %v, want Error", spans[1].Status().Code)
+	}
+}
+
+func TestClient_TokenMetrics(t *testing.T) {
+	var usages []types.Usage
+	var ttft time.Duration
+	var chunks, tokens int
+	var rate float64
+	metrics := &types.MetricsCallbacks{
+		OnUsage: func(provider, model string, usage types.Usage) {
+			usages = append(usages, usage)
+		},
+		OnFirstToken: func(provider string, d time.Duration) { ttft = d },
+		OnStreamComplete: func(provider string, n, completionTokens int, tokensPerSecond float64) {
+			chunks, tokens, rate = n, completionTokens, tokensPerSecond
+		},
+	}
+
+	c := &Client{
+		config:   &config.Config{Provider: "mock", Model: "test-model", Metrics: metrics},
+		provider: &replyProvider{replies: []string{"Hello"}},
+	}
+	ctx := context.Background()
+	req := &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	}
+
+	if _, err := c.Chat(ctx, req); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if len(usages) != 1 || usages[0].TotalTokens == 0 {
+		t.Errorf("OnUsage calls = %v, want one with tokens", usages)
+	}
+
+	c.provider = &mockProvider{}
+	stream, err := c.StreamChat(ctx, req)
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	for range stream {
+	}
+	c.Close()
+
+	if ttft <= 0 {
+		t.Errorf("OnFirstToken ttft = %v, want > 0", ttft)
+	}
+	if chunks != 2 || tokens == 0 || rate <= 0 {
+		t.Errorf("OnStreamComplete(chunks=%d, tokens=%d, rate=%v), want 2 chunks and positive tokens and rate", chunks, tokens, rate)
+	}
+}
+
+func TestClient_Audit(t *testing.T) {
+	var records []*audit.Record
+	c := &Client{
+		config: &config.Config{
+			Provider: "mock",
+			Model:    "test-model",
+			Audit: audit.New(audit.SinkFunc(func(ctx context.Context, rec *audit.Record) error {
+				records = append(records, rec)
+				return nil
+			})),
+		},
+		provider: &mockProvider{},
+	}
+	ctx := context.Background()
+	req := &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	}
+
+	if _, err := c.Chat(ctx, req); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	stream, err := c.StreamChat(ctx, req)
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	for range stream {
+	}
+	if _, err := c.Complete(ctx, &types.CompletionRequest{Prompt: "Hi"}); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	c.Close()
+
+	if len(records) != 3 {
+		t.Fatalf("got %d audit records, want 3", len(records))
+	}
+	for i, op := range []string{"chat", "stream_chat", "complete"} {
+		rec := records[i]
+		if rec.Op != op || rec.Provider != "mock" || rec.Response == nil {
+			t.Errorf("record %d = %+v, want op %s with a response", i, rec, op)
+		}
+	}
+	if records[0].ChatRequest.Messages[0].Content != "Hello" {
+		t.Errorf("chat record request = %+v", records[0].ChatRequest)
+	}
+	if records[1].Response.Message.Content == "" {
+		t.Error("stream record has no content")
+	}
+	if records[2].CompletionRequest.Prompt != "Hi" {
+		t.Errorf("completion record request = %+v", records[2].CompletionRequest)
+	}
+}
+
+func TestClient_AuditStreamDrained(t *testing.T) {
+	var records []*audit.Record
+	c := &Client{
+		config: &config.Config{
+			Provider: "mock",
+			Model:    "test-model",
+			Audit: audit.New(audit.SinkFunc(func(ctx context.Context, rec *audit.Record) error {
+				records = append(records, rec)
+				return nil
+			})),
+		},
+		provider: &mockProvider{},
+	}
+	req := &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	}
+
+	stream, err := c.StreamChat(context.Background(), req)
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	for range stream {
+	}
+
+	// The record is written before the stream closes, without waiting for
+	// Close
+	if len(records) != 1 {
+		t.Fatalf("got %d audit records once the stream was drained, want 1", len(records))
+	}
+	if rec := records[0]; rec.Op != "stream_chat" || rec.Response == nil || rec.Response.Message.Content == "" {
+		t.Errorf("stream record = %+v, want its content", rec)
+	}
+}
+
+func TestClient_CostAttribution(t *testing.T) {
+	tracker := cost.NewCostTracker()
+	client := &Client{
+		config: &config.Config{
+			Provider:    "openai",
+			Model:       "gpt-4",
+			CostTracker: tracker,
+		},
+		provider: &replyProvider{replies: []string{"Hi"}},
+	}
+
+	ctx := types.WithLabels(context.Background(), map[string]string{types.LabelTenant: "acme"})
+	req := &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+		Labels:   map[string]string{types.LabelFeature: "search"},
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := client.Chat(ctx, req); err != nil {
+			t.Fatalf("Chat() error = %v", err)
+		}
+	}
+
+	// Streams without reported usage are tracked at their estimated usage
+	ctx = types.WithLabels(context.Background(), map[string]string{types.LabelTenant: "globex"})
+	stream, err := client.StreamChat(ctx, &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	})
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	for range stream {
+	}
+
+	groups, err := tracker.SpendBy(context.Background(), cost.UsageQuery{}, types.LabelTenant, types.LabelFeature)
+	if err != nil {
+		t.Fatalf("SpendBy() error = %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("SpendBy() returned %d groups, want 2: %+v", len(groups), groups)
+	}
+
+	byTenant := make(map[string]cost.UsageGroup)
+	for _, g := range groups {
+		byTenant[g.Tags[types.LabelTenant]] = g
+	}
+	if g := byTenant["acme"]; g.RequestCount != 2 || g.TotalTokens != 20 || g.Tags[types.LabelFeature] != "search" {
+		t.Errorf("acme usage = %+v, want 2 search requests and 20 tokens", g)
+	}
+	if g := byTenant["globex"]; g.RequestCount != 1 || g.TotalTokens == 0 {
+		t.Errorf("globex usage = %+v, want 1 request with estimated tokens", g)
+	}
+}
+
+// thousandTokenProvider reports 1000 prompt tokens used per chat
+type thousandTokenProvider struct {
+	mockProvider
+}
+
+func (p *thousandTokenProvider) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	return &types.ChatResponse{Response: types.Response{
+		Message: types.Message{Role: types.RoleAssistant, Content: "Hi"},
+		Usage:   types.Usage{PromptTokens: 1000, TotalTokens: 1000},
+	}}, nil
+}
+
+func TestNewClient_TrackerPricing(t *testing.T) {
+	tracker := cost.NewCostTracker()
+	newClient := func(opts ...config.Option) *Client {
+		t.Helper()
+		cfg := &config.Config{Provider: "mock", Model: "gpt-4", CostTracker: tracker}
+		for _, opt := range opts {
+			if err := opt(cfg); err != nil {
+				t.Fatalf("option error = %v", err)
+			}
+		}
+		c, err := NewClient(cfg)
+		if err != nil {
+			t.Fatalf("NewClient() error = %v", err)
+		}
+		c.provider = &thousandTokenProvider{}
+		return c
+	}
+	// Two clients share the tracker, one with its own prices
+	negotiated := newClient(config.WithPriceOverrides("mock", map[string]cost.TokenRates{"gpt-4": {PromptTokenRate: 1, CompletionTokenRate: 1}}))
+	list := newClient()
+
+	req := &types.ChatRequest{Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}}}
+	tenant := func(id string) context.Context {
+		return types.WithLabels(context.Background(), map[string]string{types.LabelTenant: id})
+	}
+	if _, err := negotiated.Chat(tenant("negotiated"), req); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if _, err := list.Chat(tenant("list"), req); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	groups, err := tracker.SpendBy(context.Background(), cost.UsageQuery{}, types.LabelTenant)
+	if err != nil {
+		t.Fatalf("SpendBy() error = %v", err)
+	}
+	spend := make(map[string]float64)
+	for _, g := range groups {
+		spend[g.Tags[types.LabelTenant]] = g.TotalCost
+	}
+	if spend["negotiated"] != 1 {
+		t.Errorf("negotiated client's cost = %v, want 1 at its overridden price", spend["negotiated"])
+	}
+	// An unpriced model costs nothing in the default catalog
+	if want := cost.DefaultCatalog().Cost("mock", "gpt-4", types.Usage{PromptTokens: 1000, TotalTokens: 1000}); spend["list"] != want {
+		t.Errorf("list client's cost = %v, want %v from the tracker's catalog", spend["list"], want)
+	}
+}
+
+func TestClient_AnthropicUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":          "msg_1",
+			"type":        "message",
+			"role":        "assistant",
+			"model":       "claude-3-5-sonnet-20241022",
+			"content":     []map[string]any{{"type": "text", "text": "Hello"}},
+			"stop_reason": "end_turn",
+			"usage":       map[string]any{"input_tokens": 10, "output_tokens": 5},
+		})
+	}))
+	defer server.Close()
+
+	var usages []types.Usage
+	tracker := cost.NewCostTracker()
+	c, err := NewClient(&config.Config{
+		Provider:    "anthropic",
+		Model:       "claude-3-5-sonnet-20241022",
+		APIKey:      "test-key",
+		BaseURL:     server.URL,
+		CostTracker: tracker,
+		Metrics: &types.MetricsCallbacks{
+			OnUsage: func(provider, model string, usage types.Usage) {
+				usages = append(usages, usage)
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer c.Close()
+
+	resp, err := c.Chat(context.Background(), &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	want := types.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}
+	if resp.Usage != want {
+		t.Errorf("Usage = %+v, want %+v", resp.Usage, want)
+	}
+	if len(usages) != 1 || usages[0] != want {
+		t.Errorf("OnUsage calls = %+v, want one with %+v", usages, want)
+	}
+	stats, err := tracker.GetUsageStats("anthropic", "claude-3-5-sonnet-20241022", time.Time{}, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("GetUsageStats() error = %v", err)
+	}
+	if stats.TotalTokens != 15 || stats.TotalCost <= 0 {
+		t.Errorf("tracked usage = %+v, want 15 tokens with a cost", stats)
+	}
+}
+
+func TestClient_RequestMetadata(t *testing.T) {
+	metadata := map[string]any{"user_id": "u1", "feature": "search", "attempt": 2, "trace": map[string]any{"id": "t1"}}
+	var usageMetadata map[string]any
+	var records []*audit.Record
+	tracker := cost.NewCostTracker()
+	c := &Client{
+		config: &config.Config{
+			Provider:    "openai",
+			Model:       "gpt-4",
+			CostTracker: tracker,
+			Metrics: &types.MetricsCallbacks{
+				OnUsageMetadata: func(provider, model string, usage types.Usage, md map[string]any) {
+					usageMetadata = md
+				},
+			},
+			Audit: audit.New(audit.SinkFunc(func(ctx context.Context, rec *audit.Record) error {
+				records = append(records, rec)
+				return nil
+			})),
+		},
+		provider: &replyProvider{replies: []string{"Hi"}},
+	}
+	ctx := context.Background()
+	req := &types.ChatRequest{
+		Messages:        []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+		RequestMetadata: metadata,
+		Labels:          map[string]string{"feature": "chat"},
+	}
+
+	resp, err := c.Chat(ctx, req)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if !reflect.DeepEqual(resp.Metadata, metadata) {
+		t.Errorf("Response.Metadata = %v, want %v", resp.Metadata, metadata)
+	}
+	if !reflect.DeepEqual(usageMetadata, metadata) {
+		t.Errorf("OnUsageMetadata metadata = %v, want %v", usageMetadata, metadata)
+	}
+	if len(records) != 1 || !reflect.DeepEqual(records[0].Metadata, metadata) || !reflect.DeepEqual(records[0].Response.Metadata, metadata) {
+		t.Errorf("audit records = %+v, want the metadata recorded", records)
+	}
+
+	groups, err := tracker.SpendBy(ctx, cost.UsageQuery{}, "user_id", "feature", "attempt")
+	if err != nil {
+		t.Fatalf("SpendBy() error = %v", err)
+	}
+	// Labels set on the request win over metadata with the same key
+	want := map[string]string{"user_id": "u1", "feature": "chat", "attempt": "2"}
+	if len(groups) != 1 || !reflect.DeepEqual(groups[0].Tags, want) {
+		t.Errorf("SpendBy() = %+v, want one group tagged %v", groups, want)
+	}
+
+	c.provider = &mockProvider{}
+	stream, err := c.StreamChat(ctx, req)
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	for chunk := range stream {
+		if !reflect.DeepEqual(chunk.Metadata, metadata) {
+			t.Errorf("chunk Metadata = %v, want %v", chunk.Metadata, metadata)
+		}
+	}
+}
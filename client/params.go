@@ -0,0 +1,73 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/ksred/llm/pkg/models"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// params are the sampling parameters chat and completion requests share
+type params struct {
+	maxTokens        int
+	temperature      *float32
+	topP             *float32
+	stop             []string
+	presencePenalty  float32
+	frequencyPenalty float32
+}
+
+func chatParams(req *types.ChatRequest) params {
+	return params{req.MaxTokens, req.Temperature, req.TopP, req.Stop, req.PresencePenalty, req.FrequencyPenalty}
+}
+
+func completionParams(req *types.CompletionRequest) params {
+	return params{req.MaxTokens, req.Temperature, req.TopP, req.Stop, req.PresencePenalty, req.FrequencyPenalty}
+}
+
+// checkParams checks a request's parameters against the ranges its provider
+// and model accept, so a request the provider would reject fails without a
+// round trip. Limits of unknown providers and models are not checked.
+func (c *Client) checkParams(p params, model string) error {
+	if c.config.SkipParameterValidation {
+		return nil
+	}
+	limits, known := models.LimitsFor(c.config.Provider)
+
+	var fields []types.FieldError
+	invalid := func(field string, value any, reason string, args ...any) {
+		fields = append(fields, types.FieldError{Field: field, Value: value, Reason: fmt.Sprintf(reason, args...)})
+	}
+	if t := p.temperature; t != nil {
+		switch {
+		case known && (*t < 0 || *t > limits.MaxTemperature):
+			invalid("temperature", *t, "must be between 0 and %g", limits.MaxTemperature)
+		case *t < 0:
+			invalid("temperature", *t, "must not be negative")
+		}
+	}
+	if t := p.topP; t != nil && (*t < 0 || *t > 1) {
+		invalid("top_p", *t, "must be between 0 and 1")
+	}
+	if p.maxTokens < 0 {
+		invalid("max_tokens", p.maxTokens, "must not be negative")
+	} else if m, ok := models.Lookup(model); ok && m.MaxOutputTokens > 0 && p.maxTokens > m.MaxOutputTokens {
+		invalid("max_tokens", p.maxTokens, "exceeds the %d output tokens of %s", m.MaxOutputTokens, model)
+	}
+	if limits.MaxStopSequences > 0 && len(p.stop) > limits.MaxStopSequences {
+		invalid("stop", len(p.stop), "sequences exceed the limit of %d", limits.MaxStopSequences)
+	}
+	if limits.Penalties {
+		if v := p.presencePenalty; v < -2 || v > 2 {
+			invalid("presence_penalty", v, "must be between -2 and 2")
+		}
+		if v := p.frequencyPenalty; v < -2 || v > 2 {
+			invalid("frequency_penalty", v, "must be between -2 and 2")
+		}
+	}
+
+	if len(fields) > 0 {
+		return &types.InvalidRequestError{Provider: c.config.Provider, Model: model, Fields: fields}
+	}
+	return nil
+}
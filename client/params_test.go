@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestClient_ParameterValidation(t *testing.T) {
+	float := func(f float32) *float32 { return &f }
+	tests := []struct {
+		name       string
+		provider   string
+		model      string
+		req        func(r *types.ChatRequest)
+		skip       bool
+		wantFields []string
+	}{
+		{
+			name:     "valid",
+			provider: "openai",
+			model:    "gpt-4o",
+			req: func(r *types.ChatRequest) {
+				r.Temperature, r.TopP, r.MaxTokens = float(2), float(1), 16384
+				r.Stop = []string{"a", "b", "c", "d"}
+				r.PresencePenalty, r.FrequencyPenalty = -2, 2
+			},
+		},
+		{
+			name:     "openai ranges",
+			provider: "openai",
+			model:    "gpt-4o",
+			req: func(r *types.ChatRequest) {
+				r.Temperature, r.TopP = float(2.5), float(1.5)
+				r.Stop = []string{"a", "b", "c", "d", "e"}
+				r.PresencePenalty, r.FrequencyPenalty = 3, -3
+			},
+			wantFields: []string{"temperature", "top_p", "stop", "presence_penalty", "frequency_penalty"},
+		},
+		{
+			name:     "anthropic temperature",
+			provider: "anthropic",
+			model:    "claude-3-5-haiku-20241022",
+			req: func(r *types.ChatRequest) {
+				r.Temperature = float(1.5)
+				r.Stop = []string{"a", "b", "c", "d", "e"}
+				r.PresencePenalty = 3
+			},
+			wantFields: []string{"temperature"},
+		},
+		{
+			name:       "max tokens over the model limit",
+			provider:   "anthropic",
+			model:      "claude-3-5-haiku-20241022",
+			req:        func(r *types.ChatRequest) { r.MaxTokens = 8193 },
+			wantFields: []string{"max_tokens"},
+		},
+		{
+			name:       "negative max tokens",
+			provider:   "openai",
+			model:      "unregistered-model",
+			req:        func(r *types.ChatRequest) { r.MaxTokens = -1 },
+			wantFields: []string{"max_tokens"},
+		},
+		{
+			name:       "unknown provider",
+			provider:   "mock",
+			model:      "test-model",
+			req:        func(r *types.ChatRequest) { r.Temperature, r.TopP = float(5), float(2) },
+			wantFields: []string{"top_p"},
+		},
+		{
+			name:     "skipped",
+			provider: "openai",
+			model:    "gpt-4o",
+			req:      func(r *types.ChatRequest) { r.Temperature = float(5) },
+			skip:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &replyProvider{replies: []string{"hi"}}
+			c := &Client{
+				config:   &config.Config{Provider: tt.provider, Model: tt.model, SkipParameterValidation: tt.skip},
+				provider: p,
+			}
+			req := &types.ChatRequest{Messages: []types.Message{types.UserMessage("Hello")}}
+			tt.req(req)
+
+			_, err := c.Chat(context.Background(), req)
+			if tt.wantFields == nil {
+				if err != nil {
+					t.Fatalf("Chat() error = %v", err)
+				}
+				return
+			}
+
+			var invalid *types.InvalidRequestError
+			if !errors.As(err, &invalid) || !errors.Is(err, types.ErrInvalidRequest) {
+				t.Fatalf("Chat() error = %v, want an InvalidRequestError", err)
+			}
+			var fields []string
+			for _, f := range invalid.Fields {
+				fields = append(fields, f.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) || invalid.Model != tt.model {
+				t.Errorf("invalid fields = %v for %s, want %v for %s", fields, invalid.Model, tt.wantFields, tt.model)
+			}
+			if len(p.requests) != 0 {
+				t.Errorf("provider received %d requests, want the request rejected before sending", len(p.requests))
+			}
+		})
+	}
+}
+
+func TestClient_ParameterValidationComplete(t *testing.T) {
+	c := &Client{
+		config:   &config.Config{Provider: "openai", Model: "gpt-4o"},
+		provider: &mockProvider{},
+	}
+	temperature := float32(3)
+	_, err := c.Complete(context.Background(), &types.CompletionRequest{Prompt: "Hi", Temperature: &temperature})
+	if !errors.Is(err, types.ErrInvalidRequest) {
+		t.Errorf("Complete() error = %v, want %v", err, types.ErrInvalidRequest)
+	}
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/internal/ratelimit"
+	"github.com/ksred/llm/pkg/cost"
+)
+
+// settings are the parts of a client's configuration that Reload replaces
+// while requests are in flight
+type settings struct {
+	pricing   *cost.PricingCatalog
+	rateLimit *config.RateLimit
+	limiter   *ratelimit.Limiter
+}
+
+// settings returns the settings of the last Reload, or those the client was
+// created with
+func (c *Client) settings() settings {
+	if s := c.reloaded.Load(); s != nil {
+		return *s
+	}
+	return settings{pricing: c.config.Pricing, rateLimit: c.config.RateLimit, limiter: c.limiter}
+}
+
+// Reload replaces the client's pricing catalog and rate limit with those of
+// cfg, such as one from a reloaded configuration file, without interrupting
+// requests in flight. Both change together for requests that start
+// afterwards, and the catalog also prices usage recorded by the client's
+// cost tracker. A rate limit whose rates are unchanged keeps its current
+// usage; one with new rates starts afresh, except a shared limit, whose
+// rates stay those of the first client to register it.
+//
+// Other settings, such as the provider, model and API keys, need a new
+// client. Turning adaptive rate limiting on or off is an error.
+func (c *Client) Reload(cfg *config.Config) error {
+	if cfg == nil {
+		return fmt.Errorf("configuration is required")
+	}
+	adaptive := cfg.RateLimit != nil && cfg.RateLimit.Adaptive
+	if adaptive != (c.adaptive != nil) {
+		return fmt.Errorf("adaptive rate limiting cannot be changed by reloading")
+	}
+
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+	cur := c.settings()
+	next := &settings{pricing: cfg.Pricing, rateLimit: cfg.RateLimit, limiter: cur.limiter}
+	if !sameRates(cur.rateLimit, cfg.RateLimit) {
+		next.limiter = newLimiter(c.config.Provider, c.config.APIKey, cfg.RateLimit)
+	}
+	c.reloaded.Store(next)
+
+	if c.logger != nil {
+		c.logger.Info("llm configuration reloaded", "provider", c.config.Provider, "model", c.config.Model)
+	}
+	return nil
+}
+
+// sameRates reports whether two rate limits need the same limiter. The mode
+// is read from the rate limit on each request, so it may differ.
+func sameRates(a, b *config.RateLimit) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.RequestsPerMinute == b.RequestsPerMinute &&
+		a.TokensPerMinute == b.TokensPerMinute &&
+		a.Shared == b.Shared &&
+		a.SlidingWindow == b.SlidingWindow
+}
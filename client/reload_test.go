@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/internal/ratelimit"
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestClient_Reload(t *testing.T) {
+	failFast := func(rpm int) *config.RateLimit {
+		return &config.RateLimit{RequestsPerMinute: rpm, Mode: config.RateLimitFailFast}
+	}
+	c := &Client{
+		config:   &config.Config{Provider: "mock", RateLimit: failFast(1)},
+		provider: &mockProvider{},
+		limiter:  ratelimit.New(1, 0),
+	}
+	req := &types.ChatRequest{Messages: []types.Message{types.UserMessage("Hello")}}
+	ctx := context.Background()
+
+	if _, err := c.Chat(ctx, req); err != nil {
+		t.Fatalf("first Chat() error = %v", err)
+	}
+	if _, err := c.Chat(ctx, req); !errors.Is(err, types.ErrRateLimitExceeded) {
+		t.Fatalf("second Chat() error = %v, want %v", err, types.ErrRateLimitExceeded)
+	}
+
+	// The same rates keep the limiter and its usage
+	limiter := c.settings().limiter
+	if err := c.Reload(&config.Config{RateLimit: failFast(1)}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if c.settings().limiter != limiter {
+		t.Errorf("Reload() with the same rates replaced the limiter")
+	}
+
+	// New rates start afresh, and the pricing changes with them
+	pricing := cost.NewPricingCatalog()
+	if err := c.Reload(&config.Config{RateLimit: failFast(2), Pricing: pricing}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if c.pricing() != pricing {
+		t.Errorf("pricing() is not the reloaded catalog")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.Chat(ctx, req); err != nil {
+			t.Fatalf("Chat() #%d after reload error = %v", i+1, err)
+		}
+	}
+
+	// Removing the rate limit removes the limiter
+	if err := c.Reload(&config.Config{}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if _, err := c.Chat(ctx, req); err != nil {
+		t.Errorf("Chat() without a rate limit error = %v", err)
+	}
+	if c.pricing() != cost.DefaultCatalog() {
+		t.Errorf("pricing() without a catalog is not the default catalog")
+	}
+
+	if err := c.Reload(&config.Config{RateLimit: &config.RateLimit{Adaptive: true}}); err == nil {
+		t.Errorf("Reload() turning on adaptive rate limiting error = nil, want an error")
+	}
+	if err := c.Reload(nil); err == nil {
+		t.Errorf("Reload(nil) error = nil, want an error")
+	}
+}
+
+func TestClient_ReloadTrackerPricing(t *testing.T) {
+	tracker := cost.NewCostTracker()
+	c := &Client{
+		config:   &config.Config{Provider: "openai", Model: "gpt-4", CostTracker: tracker},
+		provider: &thousandTokenProvider{},
+	}
+
+	pricing := cost.NewPricingCatalog()
+	if err := pricing.Set("openai", "gpt-4", cost.TokenRates{PromptTokenRate: 1, CompletionTokenRate: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reload(&config.Config{Pricing: pricing}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	req := &types.ChatRequest{Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}}}
+	if _, err := c.Chat(context.Background(), req); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if spent, _ := tracker.GetCost("openai", "gpt-4"); spent != 1 {
+		t.Errorf("tracked cost = %v, want 1 at the reloaded price", spent)
+	}
+
+	// The shared tracker keeps its own catalog for other callers
+	if err := tracker.TrackUsage("openai", "gpt-4", types.Usage{PromptTokens: 1000, TotalTokens: 1000}); err != nil {
+		t.Fatalf("TrackUsage() error = %v", err)
+	}
+	if spent, _ := tracker.GetCost("openai", "gpt-4"); spent != 1.03 {
+		t.Errorf("tracked cost = %v, want 1.03 with the default price for direct tracking", spent)
+	}
+}
+
+func TestClient_ReloadConcurrent(t *testing.T) {
+	c := &Client{
+		config:   &config.Config{Provider: "mock"},
+		provider: &mockProvider{},
+	}
+	req := &types.ChatRequest{Messages: []types.Message{types.UserMessage("Hello")}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := c.Chat(context.Background(), req); err != nil {
+					t.Errorf("Chat() error = %v", err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 20; i++ {
+		if err := c.Reload(&config.Config{RateLimit: &config.RateLimit{TokensPerMinute: 1000000 + i}}); err != nil {
+			t.Fatalf("Reload() error = %v", err)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// withTimeout derives the request context. It is bounded by the request
+// timeout, falling back to the configured client timeout, and cancelled if
+// Shutdown stops waiting for the request. A zero timeout result means no
+// deadline was added.
+func (c *Client) withTimeout(ctx context.Context, reqTimeout time.Duration) (context.Context, context.CancelFunc, time.Duration) {
+	ctx, cancelShutdown := c.withShutdown(ctx)
+
+	timeout := reqTimeout
+	if timeout <= 0 {
+		timeout = c.config.Timeout
+	}
+	if timeout <= 0 {
+		return ctx, cancelShutdown, 0
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		cancelShutdown()
+	}, timeout
+}
+
+// timeoutError wraps err with types.ErrTimeout if ctx hit its deadline, or
+// with types.ErrClientClosed if Shutdown cancelled it
+func timeoutError(ctx context.Context, err error, timeout time.Duration) error {
+	if err == nil {
+		return err
+	}
+	if errors.Is(context.Cause(ctx), types.ErrClientClosed) && !errors.Is(err, types.ErrClientClosed) {
+		return fmt.Errorf("%w: %w", types.ErrClientClosed, err)
+	}
+	if timeout <= 0 || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	if errors.Is(err, types.ErrTimeout) {
+		return err
+	}
+	return fmt.Errorf("%w after %s: %w", types.ErrTimeout, timeout, err)
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// slowProvider waits for delay or context cancellation before answering
+type slowProvider struct {
+	mockProvider
+	delay time.Duration
+}
+
+func (s *slowProvider) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(s.delay):
+		return s.mockProvider.Chat(ctx, req)
+	}
+}
+
+func TestClient_RequestTimeout(t *testing.T) {
+	tests := []struct {
+		name          string
+		configTimeout time.Duration
+		reqTimeout    time.Duration
+		wantErr       error
+	}{
+		{"request timeout shorter than provider", time.Minute, 10 * time.Millisecond, types.ErrTimeout},
+		{"request timeout overrides short config timeout", 10 * time.Millisecond, time.Second, nil},
+		{"config timeout applies by default", 10 * time.Millisecond, 0, types.ErrTimeout},
+		{"no timeout", 0, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{
+				config:   &config.Config{Provider: "mock", Timeout: tt.configTimeout},
+				provider: &slowProvider{delay: 50 * time.Millisecond},
+			}
+
+			_, err := client.Chat(context.Background(), &types.ChatRequest{
+				Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+				Timeout:  tt.reqTimeout,
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Chat() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil && !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Chat() error = %v, should wrap %v", err, context.DeadlineExceeded)
+			}
+		})
+	}
+}
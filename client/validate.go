@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// reaskPrompt tells the model why its previous reply was rejected
+const reaskPrompt = "Your previous response was invalid: %v. Please respond again, correcting this."
+
+// chatValidated runs chat and checks the response with the configured
+// validators. A failing response is sent back to the model along with the
+// validation error, up to MaxReasks times. The returned usage covers every
+// attempt, and only responses that pass are cached.
+func (c *Client) chatValidated(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	resp, err := c.chat(ctx, req)
+	if err != nil || len(c.config.Validators) == 0 {
+		return resp, err
+	}
+
+	attempt := *req
+	attempt.Messages = append([]types.Message(nil), req.Messages...)
+	// The follow-up requests differ, so they must not share the original key
+	attempt.IdempotencyKey = ""
+
+	usage := resp.Usage
+	for reasks := 0; ; reasks++ {
+		verr := c.validate(resp)
+		if verr == nil {
+			resp.Usage = usage
+			return resp, nil
+		}
+		if reasks >= c.config.MaxReasks {
+			return nil, fmt.Errorf("%w after %d attempts: %w", types.ErrValidationFailed, reasks+1, verr)
+		}
+
+		attempt.Messages = append(attempt.Messages, resp.Message, types.Message{
+			Role:    types.RoleUser,
+			Content: fmt.Sprintf(reaskPrompt, verr),
+		})
+		resp, err = c.chat(ctx, &attempt)
+		if err != nil {
+			return nil, err
+		}
+		usage.PromptTokens += resp.Usage.PromptTokens
+		usage.CompletionTokens += resp.Usage.CompletionTokens
+		usage.TotalTokens += resp.Usage.TotalTokens
+	}
+}
+
+// validate runs each validator in turn, returning the first error
+func (c *Client) validate(resp *types.ChatResponse) error {
+	for _, v := range c.config.Validators {
+		if err := v.Validate(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
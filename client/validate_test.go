@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/cache"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// replyProvider answers each Chat call with the next reply in turn
+type replyProvider struct {
+	mockProvider
+	replies  []string
+	requests []*types.ChatRequest
+}
+
+func (p *replyProvider) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	p.requests = append(p.requests, req)
+	reply := p.replies[0]
+	if len(p.replies) > 1 {
+		p.replies = p.replies[1:]
+	}
+	return &types.ChatResponse{
+		Response: types.Response{
+			Message: types.Message{Role: types.RoleAssistant, Content: reply},
+			Usage:   types.Usage{TotalTokens: 10},
+		},
+	}, nil
+}
+
+func TestClient_ChatValidators(t *testing.T) {
+	errNoCitation := errors.New("missing citation")
+	requireCitation := types.ValidatorFunc(func(resp *types.ChatResponse) error {
+		if !strings.Contains(resp.Message.Content, "[1]") {
+			return errNoCitation
+		}
+		return nil
+	})
+
+	tests := []struct {
+		name      string
+		replies   []string
+		maxReasks int
+		wantErr   error
+		wantReply string
+		wantCalls int
+	}{
+		{"valid first time", []string{"Paris [1]"}, 2, nil, "Paris [1]", 1},
+		{"valid after reask", []string{"Paris", "Paris [1]"}, 2, nil, "Paris [1]", 2},
+		{"reasks exhausted", []string{"Paris"}, 2, types.ErrValidationFailed, "", 3},
+		{"no reasks", []string{"Paris"}, 0, errNoCitation, "", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &replyProvider{replies: tt.replies}
+			client := &Client{
+				config: &config.Config{
+					Provider:   "mock",
+					Validators: []types.Validator{requireCitation},
+					MaxReasks:  tt.maxReasks,
+				},
+				provider: provider,
+			}
+
+			resp, err := client.Chat(context.Background(), &types.ChatRequest{
+				Messages: []types.Message{{Role: types.RoleUser, Content: "Capital of France?"}},
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Chat() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(provider.requests) != tt.wantCalls {
+				t.Errorf("provider called %d times, want %d", len(provider.requests), tt.wantCalls)
+			}
+			if err != nil {
+				return
+			}
+
+			if resp.Message.Content != tt.wantReply {
+				t.Errorf("Chat() content = %q, want %q", resp.Message.Content, tt.wantReply)
+			}
+			if resp.Usage.TotalTokens != 10*tt.wantCalls {
+				t.Errorf("Usage.TotalTokens = %d, want %d", resp.Usage.TotalTokens, 10*tt.wantCalls)
+			}
+			if tt.wantCalls > 1 {
+				reask := provider.requests[1].Messages
+				if len(reask) != 3 || !strings.Contains(reask[2].Content, errNoCitation.Error()) {
+					t.Errorf("reask messages = %+v", reask)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_ChatValidatorsCache(t *testing.T) {
+	requireCitation := types.ValidatorFunc(func(resp *types.ChatResponse) error {
+		if !strings.Contains(resp.Message.Content, "[1]") {
+			return errors.New("missing citation")
+		}
+		return nil
+	})
+	provider := &replyProvider{replies: []string{"Paris", "Paris [1]"}}
+	client := &Client{
+		config: &config.Config{
+			Provider:   "mock",
+			Validators: []types.Validator{requireCitation},
+			MaxReasks:  1,
+			Cache:      cache.NewMemoryCache(time.Minute),
+		},
+		provider: provider,
+	}
+	req := &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Capital of France?"}},
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Chat(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Chat() #%d error = %v", i+1, err)
+		}
+		if resp.Message.Content != "Paris [1]" {
+			t.Errorf("Chat() #%d content = %q, want %q", i+1, resp.Message.Content, "Paris [1]")
+		}
+	}
+	// The rejected first reply was not cached, so the second request went
+	// to the provider rather than being answered with it and re-asked
+	if len(provider.requests) != 3 {
+		t.Fatalf("provider called %d times, want 3", len(provider.requests))
+	}
+	if provider.requests[2].Messages[0].Content != "Capital of France?" || len(provider.requests[2].Messages) != 1 {
+		t.Errorf("third request = %+v, want the original request", provider.requests[2].Messages)
+	}
+}
@@ -0,0 +1,31 @@
+package client
+
+import "context"
+
+// warmer is implemented by providers that can open connections ahead of the
+// first request
+type warmer interface {
+	Warmup(ctx context.Context, connections int, ping bool) error
+}
+
+// Warmup opens connections to the provider so the next requests do not pay
+// for the TCP and TLS handshakes. It follows config.Warmup, opening one
+// connection without a ping when that is unset. NewClient calls it when
+// config.Warmup is set; call it directly to warm a client in the background.
+func (c *Client) Warmup(ctx context.Context) error {
+	w, ok := c.provider.(warmer)
+	if !ok {
+		return nil
+	}
+
+	if err := c.begin(); err != nil {
+		return err
+	}
+	defer c.end()
+
+	connections, ping := 1, false
+	if c.config.Warmup != nil {
+		connections, ping = c.config.Warmup.Connections, c.config.Warmup.Ping
+	}
+	return w.Warmup(ctx, connections, ping)
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/resource"
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestNewClient_Warmup(t *testing.T) {
+	tests := []struct {
+		name      string
+		apiKey    string
+		ping      bool
+		wantErr   error
+		wantPings int32
+	}{
+		{"preconnect", "bad-key", false, nil, 0},
+		{"ping", "test-key", true, nil, 1},
+		{"ping bad key", "bad-key", true, types.ErrInvalidCredentials, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var heads, pings atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					heads.Add(1)
+					return
+				}
+				pings.Add(1)
+				if r.Header.Get("Authorization") != "Bearer test-key" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Write([]byte(`{"data":[]}`))
+			}))
+			defer server.Close()
+
+			c, err := NewClient(&config.Config{
+				Provider:    "openai",
+				Model:       "gpt-4",
+				APIKey:      tt.apiKey,
+				BaseURL:     server.URL,
+				RetryConfig: &resource.RetryConfig{MaxRetries: 0},
+				Warmup:      &config.Warmup{Connections: 2, Ping: tt.ping},
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewClient() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				defer c.Close()
+			}
+
+			if n := heads.Load(); n != 2 {
+				t.Errorf("warmup sent %d HEAD requests, want 2", n)
+			}
+			if n := pings.Load(); n != tt.wantPings {
+				t.Errorf("warmup sent %d pings, want %d", n, tt.wantPings)
+			}
+		})
+	}
+}
+
+func TestClient_WarmupUnsupported(t *testing.T) {
+	c := &Client{config: &config.Config{Provider: "mock"}, provider: &mockProvider{}}
+	if err := c.Warmup(context.Background()); err != nil {
+		t.Errorf("Warmup() error = %v", err)
+	}
+}
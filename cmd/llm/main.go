@@ -0,0 +1,167 @@
+// Command llm is a command line tool for the llm library.
+//
+// Usage:
+//
+//	llm bench [flags]
+//
+// The bench subcommand streams the same prompt to each target repeatedly and
+// reports time to first token, latency, tokens per second and error rate:
+//
+//	llm bench -target openai:gpt-4o -target anthropic:claude-3-5-sonnet-20241022 -runs 20 -warmup 2
+//
+// API keys are read from OPENAI_API_KEY and ANTHROPIC_API_KEY, falling back
+// to LLM_API_KEY.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/ksred/llm/client"
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/benchmark"
+	"github.com/ksred/llm/pkg/types"
+)
+
+const usage = `Usage: llm <command> [flags]
+
+Commands:
+  bench   benchmark providers and models
+
+Run "llm <command> -h" for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	switch os.Args[1] {
+	case "bench":
+		err = bench(ctx, os.Args[2:], os.Stdout, os.Stderr)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "llm %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// targetsFlag collects repeated -target provider:model flags
+type targetsFlag []string
+
+func (t *targetsFlag) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *targetsFlag) Set(value string) error {
+	if _, _, ok := strings.Cut(value, ":"); !ok {
+		return fmt.Errorf("target %q is not provider:model", value)
+	}
+	*t = append(*t, value)
+	return nil
+}
+
+func bench(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var targets targetsFlag
+	fs.Var(&targets, "target", "provider:model to benchmark; repeat for several")
+	runs := fs.Int("runs", 10, "measured requests per target")
+	warmup := fs.Int("warmup", 1, "unmeasured requests per target before measuring")
+	prompt := fs.String("prompt", "Write a haiku about the sea.", "prompt sent on every request")
+	maxTokens := fs.Int("max-tokens", 256, "maximum tokens to generate")
+	timeout := fs.Duration("timeout", time.Minute, "timeout for each request")
+	format := fs.String("format", "markdown", "output format: markdown or json")
+	quiet := fs.Bool("quiet", false, "do not report progress")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if len(targets) == 0 {
+		return errors.New("at least one -target is required")
+	}
+	if *format != "markdown" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	var benchTargets []benchmark.Target
+	for _, t := range targets {
+		provider, model, _ := strings.Cut(t, ":")
+		c, err := newClient(provider, model, *timeout)
+		if err != nil {
+			return fmt.Errorf("%s: %w", t, err)
+		}
+		defer c.Close()
+		benchTargets = append(benchTargets, benchmark.Target{Provider: provider, Model: model, Client: c})
+	}
+
+	cfg := benchmark.Config{
+		Runs:    *runs,
+		Warmup:  *warmup,
+		Timeout: *timeout,
+		Request: types.NewChatRequest(
+			types.Messages(types.UserMessage(*prompt)),
+			types.WithMaxTokens(*maxTokens),
+		),
+	}
+	if !*quiet {
+		cfg.OnSample = func(t benchmark.Target, s benchmark.Sample) {
+			if s.Err != nil {
+				fmt.Fprintf(stderr, "%s:%s error: %v\n", t.Provider, t.Model, s.Err)
+				return
+			}
+			fmt.Fprintf(stderr, "%s:%s ttft=%v latency=%v tokens=%d\n",
+				t.Provider, t.Model, s.TTFT.Round(time.Millisecond), s.Latency.Round(time.Millisecond), s.CompletionTokens)
+		}
+	}
+
+	results, err := benchmark.Run(ctx, benchTargets, cfg)
+	if err != nil {
+		return err
+	}
+	if *format == "json" {
+		return benchmark.WriteJSON(stdout, results)
+	}
+	return benchmark.WriteMarkdown(stdout, results)
+}
+
+// newClient creates a client for provider and model, with the API key from
+// the provider's environment variable
+func newClient(provider, model string, timeout time.Duration) (*client.Client, error) {
+	apiKey := os.Getenv(strings.ToUpper(provider) + "_API_KEY")
+	if apiKey == "" {
+		apiKey = os.Getenv(config.EnvAPIKey)
+	}
+
+	cfg, err := config.NewConfig(apiKey,
+		config.WithProvider(provider),
+		config.WithModel(model),
+		config.WithTimeout(timeout),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewClient(cfg)
+}
@@ -2,12 +2,19 @@ package config
 
 import (
 	"errors"
+	"log/slog"
 	"net/http"
 	"os"
 	"time"
 
+	"github.com/ksred/llm/pkg/audit"
+	"github.com/ksred/llm/pkg/cache"
+	"github.com/ksred/llm/pkg/conversation"
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/oauth"
 	"github.com/ksred/llm/pkg/resource"
 	"github.com/ksred/llm/pkg/types"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -19,11 +26,30 @@ const (
 	EnvTimeout    = "LLM_TIMEOUT"
 	EnvMaxRetries = "LLM_MAX_RETRIES"
 
+	// Environment variables read by FromEnv only
+	EnvPoolSize             = "LLM_POOL_SIZE"
+	EnvPoolIdleTimeout      = "LLM_POOL_IDLE_TIMEOUT"
+	EnvRetryInitialInterval = "LLM_RETRY_INITIAL_INTERVAL"
+	EnvRetryMaxInterval     = "LLM_RETRY_MAX_INTERVAL"
+	EnvRequestsPerMinute    = "LLM_REQUESTS_PER_MINUTE"
+	EnvTokensPerMinute      = "LLM_MAX_TOKENS_PER_MINUTE"
+	EnvMaxConcurrentCalls   = "LLM_MAX_CONCURRENT_CALLS"
+	EnvMaxCostPerRequest    = "LLM_MAX_COST_PER_REQUEST"
+	EnvMaxBudget            = "LLM_MAX_BUDGET"
+	EnvLogLevel             = "LLM_LOG_LEVEL"
+
 	// Default values
 	DefaultProvider   = "openai"
 	DefaultModel      = "gpt-4"
 	DefaultTimeout    = 30 * time.Second
 	DefaultMaxRetries = 3
+
+	// Connection pool and retry defaults of FromEnv, the same as a client
+	// without PoolConfig or RetryConfig uses
+	DefaultPoolSize             = 10
+	DefaultPoolIdleTimeout      = time.Minute
+	DefaultRetryInitialInterval = time.Second
+	DefaultRetryMaxInterval     = 30 * time.Second
 )
 
 var (
@@ -44,6 +70,17 @@ type Config struct {
 	Model    string
 	APIKey   string
 
+	// APIKeys, when it holds more than one key, spreads requests across
+	// them in turn. A key that is rate limited rests until the provider's
+	// Retry-After passes, and a key the provider rejects is no longer used;
+	// the request is retried with another key either way.
+	APIKeys []string
+
+	// TokenSource authenticates requests with bearer tokens, such as OAuth2
+	// or Azure AD access tokens, instead of the API key, which is then not
+	// required
+	TokenSource oauth.TokenSource
+
 	// Optional fields
 	BaseURL     string
 	HTTPClient  *http.Client
@@ -54,18 +91,202 @@ type Config struct {
 	PoolConfig  *resource.PoolConfig
 	RetryConfig *resource.RetryConfig
 	Metrics     *types.MetricsCallbacks
+	Hooks       []*types.Hooks
+	Cache       cache.Cache
+
+	// Logger receives structured logs for requests, retries and streams.
+	// The API key is redacted from all output.
+	Logger *slog.Logger
+
+	// TracerProvider creates a span for each request and stream. When nil
+	// the global provider from otel.GetTracerProvider is used, which does
+	// nothing unless the application has installed one.
+	TracerProvider trace.TracerProvider
+
+	// Audit records every request and its response or error
+	Audit *audit.Auditor
+
+	// CostTracker records the usage and cost of every request, attributed to
+	// the request's labels
+	CostTracker *cost.CostTracker
+
+	// Pricing prices requests for cost controls and tracing. When nil the
+	// library's default catalog is used.
+	Pricing *cost.PricingCatalog
+
+	// MaxConcurrentRequests caps the number of requests and streams the
+	// client has in flight at once. Further requests wait for a slot or for
+	// their context to end. Zero means no limit.
+	MaxConcurrentRequests int
+
+	// Warmup opens connections to the provider when the client is created
+	Warmup *Warmup
+
+	// GaugeInterval is how often Metrics.OnGauges receives a capacity
+	// snapshot. Zero disables periodic gauges.
+	GaugeInterval time.Duration
+
+	// IdempotencyTTL enables idempotency keys. Requests without a key are
+	// assigned one, and resubmissions with the same key within the TTL
+	// return the original result instead of calling the provider again.
+	IdempotencyTTL time.Duration
+
+	// DowngradeModel is a cheaper or faster model a request is retried on,
+	// once, when the provider rate limits it or is overloaded. Responses it
+	// serves have DowngradedFrom set.
+	DowngradeModel string
+
+	// Validators check each Chat response. When one fails, the model is
+	// asked again with the validation error, up to MaxReasks times.
+	Validators []types.Validator
+	MaxReasks  int
+
+	// StreamBufferSize is the read buffer size for streamed responses.
+	// Events longer than the buffer are still read whole. Zero uses
+	// sse.DefaultBufferSize.
+	StreamBufferSize int
+
+	// MaxStreamEventSize is the largest streamed event accepted. A larger
+	// event ends the stream with sse.ErrEventTooLarge. Zero uses
+	// sse.DefaultMaxEventSize and a negative value removes the limit.
+	MaxStreamEventSize int
+
+	// StreamIdleTimeout ends a stream with types.ErrTimeout when no event
+	// arrives for this long. Anthropic's ping events count as activity.
+	// Zero disables it.
+	StreamIdleTimeout time.Duration
+
+	// ContextCheck checks each request's estimated prompt plus MaxTokens
+	// against the model's context window before it is sent
+	ContextCheck *ContextCheck
+
+	// SkipParameterValidation sends requests without checking their
+	// temperature, top_p, max tokens, stop sequences and penalties against
+	// the ranges the provider and model accept, for endpoints with other
+	// limits, such as OpenAI-compatible servers
+	SkipParameterValidation bool
+
+	// UpgradeModels replaces the model, when the client is created, if it
+	// is retired or named in ModelSuccessors. A retired model is replaced
+	// with its successor in the model registry.
+	UpgradeModels   bool
+	ModelSuccessors map[string]string
+
+	// APIVersion pins the provider's API version: the anthropic-version
+	// header for Anthropic, and the api-version query parameter for OpenAI
+	// endpoints that need one, such as Azure OpenAI. Empty uses the
+	// provider's default.
+	APIVersion string
+
+	// Health sets how the client judges its provider's health from recent
+	// requests. Nil uses the defaults of HealthThresholds.
+	Health *HealthThresholds
+
+	// AnthropicVersion pins the anthropic-version header, taking precedence
+	// over APIVersion. Other providers ignore it.
+	AnthropicVersion string
+
+	// OpenAIOrganization and OpenAIProject attribute OpenAI requests to an
+	// organization and project, with the OpenAI-Organization and
+	// OpenAI-Project headers, for accounts in several of them. Other
+	// providers ignore them.
+	OpenAIOrganization string
+	OpenAIProject      string
+
+	// AnthropicBetas opts into Anthropic beta features, such as
+	// "prompt-caching-2024-07-31", with the anthropic-beta header. Other
+	// providers ignore it.
+	AnthropicBetas []string
 }
 
+// RateLimitMode controls what happens when a request exceeds the rate limit
+type RateLimitMode int
+
+const (
+	// RateLimitBlock waits until the request fits within the limit
+	RateLimitBlock RateLimitMode = iota
+	// RateLimitFailFast rejects the request with types.ErrRateLimitExceeded
+	RateLimitFailFast
+)
+
 // RateLimit defines rate limiting configuration
 type RateLimit struct {
 	RequestsPerMinute int
 	TokensPerMinute   int
+	Mode              RateLimitMode
+
+	// Adaptive treats RequestsPerMinute as a starting point and adjusts the
+	// request rate from provider rate-limit headers and 429 responses.
+	// Adaptive pacing always waits; Mode applies to the token limit only.
+	Adaptive bool
+
+	// Shared makes every client in the process with the same provider and
+	// API key draw from one limiter, so their combined traffic stays within
+	// the account's limits. The first client to register sets the rates.
+	Shared bool
+
+	// SlidingWindow counts the limits over any sixty seconds instead of
+	// with a token bucket, which allows a full minute's burst after an idle
+	// spell. Use it for providers that count usage over a sliding window.
+	SlidingWindow bool
+}
+
+// ContextCheck defines the context window check. Models missing from the
+// model registry are not checked.
+type ContextCheck struct {
+	// Truncation trims the messages of chat requests that do not fit. When
+	// nil, or when trimming cannot make a request fit, the request fails
+	// with a *types.ContextLengthError.
+	Truncation conversation.TruncationStrategy
 }
 
+// HealthThresholds decide when a provider is degraded or unhealthy, from
+// the requests a client made to it within Window. Zero fields use their
+// defaults.
+type HealthThresholds struct {
+	Window             time.Duration // How far back requests count; defaults to 5 minutes
+	MinRequests        int           // Requests needed before the error rate counts; defaults to 5
+	DegradedErrorRate  float64       // Error rate at which the provider is degraded; defaults to 0.1
+	UnhealthyErrorRate float64       // Error rate at which the provider is unhealthy; defaults to 0.5
+	DegradedLatency    time.Duration // P95 latency above which the provider is degraded; zero ignores latency
+}
+
+// Warmup defines connection warmup at client creation
+type Warmup struct {
+	Connections int  // Connections to open; defaults to 1
+	Ping        bool // Also send a free authenticated request, checking the API key
+}
+
+// BudgetMode controls what happens when a request would exceed a cost limit
+type BudgetMode int
+
+const (
+	// BudgetHard rejects the request with types.ErrBudgetExceeded before it
+	// is sent
+	BudgetHard BudgetMode = iota
+	// BudgetSoft sends the request anyway, after calling OnExceeded
+	BudgetSoft
+	// BudgetDegrade sends the request to FallbackModel instead, if it fits
+	// within the limits there, and otherwise rejects it
+	BudgetDegrade
+)
+
 // CostControl defines cost control configuration
 type CostControl struct {
 	MaxCostPerRequest float64
 	MaxCostPerDay     float64
+	Mode              BudgetMode
+
+	// FallbackModel is the cheaper model used under BudgetDegrade
+	FallbackModel string
+
+	// OnExceeded is called whenever a request would exceed a limit, in any
+	// mode, with the error describing the breach
+	OnExceeded func(provider, model string, err error)
+
+	// Currency is the currency the limits are in. Its zero value is US
+	// dollars.
+	Currency cost.Currency
 }
 
 // WithPoolConfig sets the connection pool configuration
@@ -87,7 +308,7 @@ func WithRetryConfig(retryConfig *resource.RetryConfig) Option {
 // Validate ensures all required fields are set
 func (c *Config) Validate() error {
 	// Check API key from config or environment
-	if c.APIKey == "" {
+	if c.APIKey == "" && c.TokenSource == nil {
 		c.APIKey = os.Getenv(EnvAPIKey)
 		if c.APIKey == "" {
 			return ErrMissingAPIKey
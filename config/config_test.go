@@ -7,6 +7,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ksred/llm/pkg/conversation"
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/oauth"
 	"github.com/ksred/llm/pkg/resource"
 )
 
@@ -49,6 +52,15 @@ func TestConfig_Validation(t *testing.T) {
 			},
 			wantError: true,
 		},
+		{
+			name: "token source instead of API key",
+			config: &Config{
+				Provider:    "openai",
+				Model:       "gpt-4",
+				TokenSource: oauth.StaticToken("test-token"),
+			},
+			wantError: false,
+		},
 		{
 			name: "missing provider",
 			config: &Config{
@@ -149,6 +161,67 @@ func TestConfigOptions(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "with degraded budget",
+			options: []Option{
+				WithCostControl(0.10, 5),
+				WithBudgetMode(BudgetDegrade, "gpt-4o-mini"),
+			},
+			want: &Config{
+				CostControl: &CostControl{
+					MaxCostPerRequest: 0.10,
+					MaxCostPerDay:     5,
+					Mode:              BudgetDegrade,
+					FallbackModel:     "gpt-4o-mini",
+				},
+			},
+		},
+		{
+			name: "with several API keys",
+			options: []Option{
+				WithAPIKeys("sk-one", "sk-two"),
+			},
+			want: &Config{
+				APIKey:  "sk-one",
+				APIKeys: []string{"sk-one", "sk-two"},
+			},
+		},
+		{
+			name: "with sliding window rate limit",
+			options: []Option{
+				WithRateLimit(60, 1000),
+				WithSlidingWindowRateLimit(),
+			},
+			want: &Config{
+				RateLimit: &RateLimit{
+					RequestsPerMinute: 60,
+					TokensPerMinute:   1000,
+					SlidingWindow:     true,
+				},
+			},
+		},
+		{
+			name: "with rate limit downgrade",
+			options: []Option{
+				WithDowngradeOnRateLimit("gpt-4o-mini"),
+			},
+			want: &Config{
+				DowngradeModel: "gpt-4o-mini",
+			},
+		},
+		{
+			name: "with budget currency",
+			options: []Option{
+				WithCostControl(0, 40),
+				WithBudgetCurrency("EUR", cost.FixedRates{"EUR": 0.9}),
+			},
+			want: &Config{
+				CostControl: &CostControl{
+					MaxCostPerDay: 40,
+					Currency:      cost.Currency{Code: "EUR", Rates: cost.FixedRates{"EUR": 0.9}},
+				},
+			},
+		},
 		{
 			name: "with pool config",
 			options: []Option{
@@ -185,6 +258,112 @@ func TestConfigOptions(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "with stream buffer limits",
+			options: []Option{
+				WithStreamBufferLimits(4096, 1<<20),
+			},
+			want: &Config{
+				StreamBufferSize:   4096,
+				MaxStreamEventSize: 1 << 20,
+			},
+		},
+		{
+			name: "with context check",
+			options: []Option{
+				WithContextCheck(),
+			},
+			want: &Config{
+				ContextCheck: &ContextCheck{},
+			},
+		},
+		{
+			name: "with context trimming",
+			options: []Option{
+				WithContextTrimming(conversation.SlidingWindow{}),
+			},
+			want: &Config{
+				ContextCheck: &ContextCheck{Truncation: conversation.SlidingWindow{}},
+			},
+		},
+		{
+			name: "with model upgrades",
+			options: []Option{
+				WithModelUpgrades(map[string]string{"gpt-4": "gpt-4o"}),
+			},
+			want: &Config{
+				UpgradeModels:   true,
+				ModelSuccessors: map[string]string{"gpt-4": "gpt-4o"},
+			},
+		},
+		{
+			name: "with API version",
+			options: []Option{
+				WithAPIVersion("2023-06-01"),
+			},
+			want: &Config{
+				APIVersion: "2023-06-01",
+			},
+		},
+		{
+			name: "without parameter validation",
+			options: []Option{
+				WithoutParameterValidation(),
+			},
+			want: &Config{
+				SkipParameterValidation: true,
+			},
+		},
+		{
+			name: "with API key",
+			options: []Option{
+				WithAPIKey("sk-test"),
+			},
+			want: &Config{
+				APIKey: "sk-test",
+			},
+		},
+		{
+			name: "with provider settings",
+			options: []Option{
+				WithAnthropicVersion("2023-06-01"),
+				WithOpenAIOrganization("org-123"),
+				WithOpenAIProject("proj_456"),
+			},
+			want: &Config{
+				AnthropicVersion:   "2023-06-01",
+				OpenAIOrganization: "org-123",
+				OpenAIProject:      "proj_456",
+			},
+		},
+		{
+			name: "with health thresholds",
+			options: []Option{
+				WithHealthThresholds(HealthThresholds{Window: time.Minute, DegradedLatency: 5 * time.Second}),
+			},
+			want: &Config{
+				Health: &HealthThresholds{Window: time.Minute, DegradedLatency: 5 * time.Second},
+			},
+		},
+		{
+			name: "with anthropic betas",
+			options: []Option{
+				WithAnthropicBetas("prompt-caching-2024-07-31"),
+				WithAnthropicBetas("prompt-caching-2024-07-31", "token-efficient-tools-2025-02-19"),
+			},
+			want: &Config{
+				AnthropicBetas: []string{"prompt-caching-2024-07-31", "token-efficient-tools-2025-02-19"},
+			},
+		},
+		{
+			name: "with stream idle timeout",
+			options: []Option{
+				WithStreamIdleTimeout(30 * time.Second),
+			},
+			want: &Config{
+				StreamIdleTimeout: 30 * time.Second,
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -203,3 +382,44 @@ func TestConfigOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestWithPriceOverrides(t *testing.T) {
+	cfg, err := NewConfig("test-key",
+		WithPriceOverrides("openai", map[string]cost.TokenRates{
+			"gpt-4o*": {PromptTokenRate: 0.002, CompletionTokenRate: 0.008},
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewConfig() error = %v", err)
+	}
+
+	if rates, _ := cfg.Pricing.Lookup("openai", "gpt-4o-2024-08-06"); rates.PromptTokenRate != 0.002 {
+		t.Errorf("overridden prompt rate = %v, want 0.002", rates.PromptTokenRate)
+	}
+	if rates, _ := cfg.Pricing.Lookup("openai", "gpt-4"); rates.PromptTokenRate != 0.03 {
+		t.Errorf("catalog prompt rate = %v, want 0.03", rates.PromptTokenRate)
+	}
+	if rates, _ := cost.DefaultCatalog().Lookup("openai", "gpt-4o-2024-08-06"); rates.PromptTokenRate == 0.002 {
+		t.Error("WithPriceOverrides() changed the default catalog")
+	}
+
+	own := cost.DefaultCatalog().Clone()
+	cfg, err = NewConfig("test-key",
+		WithPricing(own),
+		WithPriceOverrides("openai", map[string]cost.TokenRates{"gpt-4": {PromptTokenRate: 0.01}}),
+	)
+	if err != nil {
+		t.Fatalf("NewConfig() error = %v", err)
+	}
+	if rates, _ := cfg.Pricing.Lookup("openai", "gpt-4"); rates.PromptTokenRate != 0.01 {
+		t.Errorf("overridden prompt rate = %v, want 0.01", rates.PromptTokenRate)
+	}
+	if rates, _ := own.Lookup("openai", "gpt-4"); rates.PromptTokenRate != 0.03 {
+		t.Error("WithPriceOverrides() changed the catalog passed to WithPricing")
+	}
+
+	_, err = NewConfig("test-key", WithPriceOverrides("openai", map[string]cost.TokenRates{"gpt-[": {}}))
+	if err == nil {
+		t.Error("NewConfig() with an invalid model pattern error = nil")
+	}
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ksred/llm/pkg/resource"
+)
+
+// FromEnv creates a Config from the LLM_* environment variables, then
+// applies opts, which take precedence:
+//
+//	LLM_API_KEY                 API key (required unless opts set a token source)
+//	LLM_PROVIDER                provider, defaults to openai
+//	LLM_MODEL                   model, defaults to gpt-4
+//	LLM_BASE_URL                API base URL
+//	LLM_TIMEOUT                 request timeout, such as 30s
+//	LLM_MAX_RETRIES             retries per request, defaults to 3
+//	LLM_RETRY_INITIAL_INTERVAL  first retry delay, defaults to 1s
+//	LLM_RETRY_MAX_INTERVAL      longest retry delay, defaults to 30s
+//	LLM_POOL_SIZE               most pooled connections, defaults to 10
+//	LLM_POOL_IDLE_TIMEOUT       how long idle connections are kept, defaults to 1m
+//	LLM_REQUESTS_PER_MINUTE     request rate limit
+//	LLM_MAX_TOKENS_PER_MINUTE   token rate limit
+//	LLM_MAX_CONCURRENT_CALLS    most requests in flight at once
+//	LLM_MAX_COST_PER_REQUEST    cost limit per request, in USD
+//	LLM_MAX_BUDGET              cost limit per day, in USD
+//	LLM_LOG_LEVEL               log to stderr at debug, info, warn or error
+//
+// Unset variables keep their defaults. A variable that cannot be parsed is
+// an error naming it.
+func FromEnv(opts ...Option) (*Config, error) {
+	e := envReader{}
+	timeout := e.duration(EnvTimeout, DefaultTimeout)
+	maxRetries := e.int(EnvMaxRetries, DefaultMaxRetries)
+	retry := &resource.RetryConfig{
+		MaxRetries:      maxRetries,
+		InitialInterval: e.duration(EnvRetryInitialInterval, DefaultRetryInitialInterval),
+		MaxInterval:     e.duration(EnvRetryMaxInterval, DefaultRetryMaxInterval),
+		Multiplier:      2,
+	}
+	pool := &resource.PoolConfig{
+		MaxSize:       e.int(EnvPoolSize, DefaultPoolSize),
+		IdleTimeout:   e.duration(EnvPoolIdleTimeout, DefaultPoolIdleTimeout),
+		CleanupPeriod: DefaultPoolIdleTimeout,
+	}
+	rpm := e.int(EnvRequestsPerMinute, 0)
+	tpm := e.int(EnvTokensPerMinute, 0)
+	concurrency := e.int(EnvMaxConcurrentCalls, 0)
+	perRequest := e.float(EnvMaxCostPerRequest, 0)
+	perDay := e.float(EnvMaxBudget, 0)
+	logLevel := e.logLevel(EnvLogLevel)
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	envOpts := []Option{
+		WithTimeout(timeout),
+		WithMaxRetries(maxRetries),
+		WithRetryConfig(retry),
+		WithPoolConfig(pool),
+	}
+	if v := os.Getenv(EnvProvider); v != "" {
+		envOpts = append(envOpts, WithProvider(v))
+	}
+	if v := os.Getenv(EnvModel); v != "" {
+		envOpts = append(envOpts, WithModel(v))
+	}
+	if v := os.Getenv(EnvBaseURL); v != "" {
+		envOpts = append(envOpts, WithBaseURL(v))
+	}
+	if rpm > 0 || tpm > 0 {
+		envOpts = append(envOpts, WithRateLimit(rpm, tpm))
+	}
+	if concurrency > 0 {
+		envOpts = append(envOpts, WithMaxConcurrentRequests(concurrency))
+	}
+	if perRequest > 0 || perDay > 0 {
+		envOpts = append(envOpts, WithCostControl(perRequest, perDay))
+	}
+	if logLevel != nil {
+		envOpts = append(envOpts, WithLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: *logLevel}))))
+	}
+	return NewConfig(os.Getenv(EnvAPIKey), append(envOpts, opts...)...)
+}
+
+// envReader parses environment variables, keeping the first error
+type envReader struct {
+	err error
+}
+
+func (e *envReader) lookup(name string) (string, bool) {
+	v, ok := os.LookupEnv(name)
+	return v, ok && v != ""
+}
+
+func (e *envReader) fail(name, value string, err error) {
+	if e.err == nil {
+		e.err = fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+}
+
+func (e *envReader) int(name string, def int) int {
+	v, ok := e.lookup(name)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err == nil && n < 0 {
+		err = fmt.Errorf("must not be negative")
+	}
+	if err != nil {
+		e.fail(name, v, err)
+		return def
+	}
+	return n
+}
+
+func (e *envReader) float(name string, def float64) float64 {
+	v, ok := e.lookup(name)
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err == nil && f < 0 {
+		err = fmt.Errorf("must not be negative")
+	}
+	if err != nil {
+		e.fail(name, v, err)
+		return def
+	}
+	return f
+}
+
+func (e *envReader) duration(name string, def time.Duration) time.Duration {
+	v, ok := e.lookup(name)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err == nil && d < 0 {
+		err = fmt.Errorf("must not be negative")
+	}
+	if err != nil {
+		e.fail(name, v, err)
+		return def
+	}
+	return d
+}
+
+func (e *envReader) logLevel(name string) *slog.Level {
+	v, ok := e.lookup(name)
+	if !ok {
+		return nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(v)); err != nil {
+		e.fail(name, v, err)
+		return nil
+	}
+	return &level
+}
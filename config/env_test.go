@@ -0,0 +1,100 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/resource"
+)
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(EnvAPIKey, "env-key")
+	t.Setenv(EnvProvider, "anthropic")
+	t.Setenv(EnvModel, "claude-3-5-haiku-20241022")
+	t.Setenv(EnvBaseURL, "https://proxy.example.com/v1")
+	t.Setenv(EnvTimeout, "45s")
+	t.Setenv(EnvMaxRetries, "5")
+	t.Setenv(EnvRetryInitialInterval, "200ms")
+	t.Setenv(EnvPoolSize, "25")
+	t.Setenv(EnvRequestsPerMinute, "60")
+	t.Setenv(EnvTokensPerMinute, "100000")
+	t.Setenv(EnvMaxConcurrentCalls, "8")
+	t.Setenv(EnvMaxBudget, "100")
+	t.Setenv(EnvLogLevel, "debug")
+
+	cfg, err := FromEnv(WithModel("claude-3-5-sonnet-20241022"))
+	if err != nil {
+		t.Fatalf("FromEnv() error = %v", err)
+	}
+	if cfg.APIKey != "env-key" || cfg.Provider != "anthropic" || cfg.BaseURL != "https://proxy.example.com/v1" {
+		t.Errorf("FromEnv() = %+v, want the key, provider and base URL from the environment", cfg)
+	}
+	if cfg.Model != "claude-3-5-sonnet-20241022" {
+		t.Errorf("Model = %q, want the option to take precedence", cfg.Model)
+	}
+	if cfg.Timeout != 45*time.Second || cfg.HTTPClient.Timeout != 45*time.Second || cfg.MaxRetries != 5 {
+		t.Errorf("Timeout = %v, MaxRetries = %d, want 45s and 5", cfg.Timeout, cfg.MaxRetries)
+	}
+	wantRetry := resource.RetryConfig{MaxRetries: 5, InitialInterval: 200 * time.Millisecond, MaxInterval: DefaultRetryMaxInterval, Multiplier: 2}
+	if !reflect.DeepEqual(*cfg.RetryConfig, wantRetry) {
+		t.Errorf("RetryConfig = %+v, want %+v", *cfg.RetryConfig, wantRetry)
+	}
+	if cfg.PoolConfig.MaxSize != 25 || cfg.PoolConfig.IdleTimeout != DefaultPoolIdleTimeout {
+		t.Errorf("PoolConfig = %+v, want 25 connections", cfg.PoolConfig)
+	}
+	if cfg.RateLimit == nil || cfg.RateLimit.RequestsPerMinute != 60 || cfg.RateLimit.TokensPerMinute != 100000 {
+		t.Errorf("RateLimit = %+v, want 60 requests and 100000 tokens", cfg.RateLimit)
+	}
+	if cfg.MaxConcurrentRequests != 8 {
+		t.Errorf("MaxConcurrentRequests = %d, want 8", cfg.MaxConcurrentRequests)
+	}
+	if cfg.CostControl == nil || cfg.CostControl.MaxCostPerDay != 100 || cfg.CostControl.MaxCostPerRequest != 0 {
+		t.Errorf("CostControl = %+v, want 100 per day", cfg.CostControl)
+	}
+	if cfg.Logger == nil {
+		t.Errorf("Logger = nil, want a logger for LLM_LOG_LEVEL")
+	}
+}
+
+func TestFromEnv_Defaults(t *testing.T) {
+	t.Setenv(EnvAPIKey, "env-key")
+	t.Setenv(EnvModel, "")
+
+	cfg, err := FromEnv()
+	if err != nil {
+		t.Fatalf("FromEnv() error = %v", err)
+	}
+	if cfg.Provider != DefaultProvider || cfg.Model != DefaultModel || cfg.Timeout != DefaultTimeout || cfg.MaxRetries != DefaultMaxRetries {
+		t.Errorf("FromEnv() = %+v, want the defaults", cfg)
+	}
+	if cfg.PoolConfig.MaxSize != DefaultPoolSize || cfg.RetryConfig.MaxRetries != DefaultMaxRetries {
+		t.Errorf("PoolConfig = %+v, RetryConfig = %+v, want the defaults", cfg.PoolConfig, cfg.RetryConfig)
+	}
+	if cfg.RateLimit != nil || cfg.CostControl != nil || cfg.Logger != nil {
+		t.Errorf("FromEnv() = %+v, want no rate limit, cost control or logger", cfg)
+	}
+}
+
+func TestFromEnv_Invalid(t *testing.T) {
+	tests := []struct {
+		name, value string
+	}{
+		{EnvTimeout, "soon"},
+		{EnvMaxRetries, "-1"},
+		{EnvPoolSize, "many"},
+		{EnvMaxBudget, "$100"},
+		{EnvLogLevel, "loud"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(EnvAPIKey, "env-key")
+			t.Setenv(tt.name, tt.value)
+			if _, err := FromEnv(); err == nil || !strings.Contains(err.Error(), tt.name) {
+				t.Errorf("FromEnv() error = %v, want one naming %s", err, tt.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,395 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/ksred/llm/pkg/resource"
+	"gopkg.in/yaml.v3"
+)
+
+// File is a configuration file describing one or more providers, with the
+// rules routing requests between them, as read by LoadFile:
+//
+//	default: primary
+//	providers:
+//	  primary:
+//	    provider: openai
+//	    model: gpt-4o
+//	    api_key: ${OPENAI_API_KEY}
+//	    timeout: 60s
+//	    rate_limit: {requests_per_minute: 500, tokens_per_minute: 90000}
+//	    budget: {max_cost_per_day: 50, mode: degrade, fallback_model: gpt-4o-mini}
+//	  claude:
+//	    provider: anthropic
+//	    model: claude-3-5-sonnet-20241022
+//	    api_key: ${ANTHROPIC_API_KEY}
+//	routes:
+//	  - labels: {tier: free}
+//	    providers: [claude, primary]
+type File struct {
+	// Default names the provider Config uses when given no name. It may be
+	// omitted when the file has only one provider.
+	Default   string                   `json:"default" yaml:"default"`
+	Providers map[string]*ProviderFile `json:"providers" yaml:"providers"`
+	Routes    []Route                  `json:"routes" yaml:"routes"`
+}
+
+// ProviderFile is one provider of a configuration file. Durations are
+// strings such as "30s", and unset fields keep their defaults. Profile is
+// "low_latency", "batch" or "cost_saving", to start from ProfileLowLatency,
+// ProfileBatch or ProfileCostSaving; the other fields override it.
+type ProviderFile struct {
+	Provider    string        `json:"provider" yaml:"provider"`
+	Profile     string        `json:"profile" yaml:"profile"`
+	Model       string        `json:"model" yaml:"model"`
+	APIKey      string        `json:"api_key" yaml:"api_key"`
+	APIKeys     []string      `json:"api_keys" yaml:"api_keys"`
+	BaseURL     string        `json:"base_url" yaml:"base_url"`
+	APIVersion  string        `json:"api_version" yaml:"api_version"`
+	Timeout     time.Duration `json:"timeout" yaml:"timeout"`
+	MaxRetries  *int          `json:"max_retries" yaml:"max_retries"`
+	PricingFile string        `json:"pricing_file" yaml:"pricing_file"`
+
+	AnthropicVersion   string   `json:"anthropic_version" yaml:"anthropic_version"`
+	AnthropicBetas     []string `json:"anthropic_betas" yaml:"anthropic_betas"`
+	OpenAIOrganization string   `json:"openai_organization" yaml:"openai_organization"`
+	OpenAIProject      string   `json:"openai_project" yaml:"openai_project"`
+
+	Pool      *PoolFile      `json:"pool" yaml:"pool"`
+	Retry     *RetryFile     `json:"retry" yaml:"retry"`
+	RateLimit *RateLimitFile `json:"rate_limit" yaml:"rate_limit"`
+	Budget    *BudgetFile    `json:"budget" yaml:"budget"`
+}
+
+// PoolFile is the connection pool of a provider, as resource.PoolConfig
+type PoolFile struct {
+	MaxSize       int           `json:"max_size" yaml:"max_size"`
+	IdleTimeout   time.Duration `json:"idle_timeout" yaml:"idle_timeout"`
+	CleanupPeriod time.Duration `json:"cleanup_period" yaml:"cleanup_period"`
+}
+
+// RetryFile is the retry policy of a provider, as resource.RetryConfig
+type RetryFile struct {
+	MaxRetries      int           `json:"max_retries" yaml:"max_retries"`
+	InitialInterval time.Duration `json:"initial_interval" yaml:"initial_interval"`
+	MaxInterval     time.Duration `json:"max_interval" yaml:"max_interval"`
+	Multiplier      float64       `json:"multiplier" yaml:"multiplier"`
+}
+
+// RateLimitFile is the rate limit of a provider, as RateLimit. Mode is
+// "block", the default, or "fail_fast".
+type RateLimitFile struct {
+	RequestsPerMinute int    `json:"requests_per_minute" yaml:"requests_per_minute"`
+	TokensPerMinute   int    `json:"tokens_per_minute" yaml:"tokens_per_minute"`
+	Mode              string `json:"mode" yaml:"mode"`
+	Adaptive          bool   `json:"adaptive" yaml:"adaptive"`
+	Shared            bool   `json:"shared" yaml:"shared"`
+	SlidingWindow     bool   `json:"sliding_window" yaml:"sliding_window"`
+}
+
+// BudgetFile is the cost control of a provider, as CostControl. Mode is
+// "hard", the default, "soft" or "degrade", which requires FallbackModel.
+type BudgetFile struct {
+	MaxCostPerRequest float64 `json:"max_cost_per_request" yaml:"max_cost_per_request"`
+	MaxCostPerDay     float64 `json:"max_cost_per_day" yaml:"max_cost_per_day"`
+	Mode              string  `json:"mode" yaml:"mode"`
+	FallbackModel     string  `json:"fallback_model" yaml:"fallback_model"`
+}
+
+// Route sends requests whose labels include all of Labels to the first
+// available of Providers, named as in the file. A route without labels
+// matches every request.
+type Route struct {
+	Labels    map[string]string `json:"labels" yaml:"labels"`
+	Providers []string          `json:"providers" yaml:"providers"`
+}
+
+// envVar matches ${VAR} and ${VAR:-default}
+var envVar = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// LoadFile reads a JSON or YAML configuration file. References to
+// environment variables, written ${VAR} or ${VAR:-default}, are replaced
+// with their values in the file's values, so secrets such as API keys can
+// stay out of it. Values are substituted as they are, whatever characters
+// they contain, and references in comments are ignored. A variable that is
+// not set and has no default is an error.
+func LoadFile(filename string) (*File, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	return ParseFile(data)
+}
+
+// ParseFile parses a configuration file's contents, as LoadFile does
+func ParseFile(data []byte) (*File, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing config file: %w", err)
+	}
+	var missing []string
+	interpolate(&doc, &missing)
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("parsing config file: environment variables not set: %v", missing)
+	}
+
+	f := &File{}
+	if len(doc.Content) > 0 {
+		// Node.Decode cannot reject unknown fields, so the interpolated
+		// document is encoded again for a strict decoder
+		out, err := yaml.Marshal(&doc)
+		if err != nil {
+			return nil, fmt.Errorf("parsing config file: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(out))
+		dec.KnownFields(true)
+		if err := dec.Decode(f); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parsing config file: %w", err)
+		}
+	}
+	if err := f.validate(); err != nil {
+		return nil, fmt.Errorf("parsing config file: %w", err)
+	}
+	return f, nil
+}
+
+// interpolate replaces environment variable references in the scalars of
+// n, adding those that are not set and have no default to missing
+func interpolate(n *yaml.Node, missing *[]string) {
+	if n.Kind == yaml.ScalarNode {
+		value := envVar.ReplaceAllStringFunc(n.Value, func(ref string) string {
+			m := envVar.FindStringSubmatch(ref)
+			if v, ok := os.LookupEnv(m[1]); ok {
+				return v
+			}
+			if m[2] == "" {
+				*missing = append(*missing, m[1])
+			}
+			return m[3]
+		})
+		if value != n.Value {
+			n.Value = value
+			if n.Style == 0 {
+				// An unquoted value is typed by what it reads as, such as
+				// a number, and quoted again if it needs to be
+				n.Tag = ""
+			}
+		}
+		return
+	}
+	for _, c := range n.Content {
+		interpolate(c, missing)
+	}
+}
+
+func (f *File) validate() error {
+	if len(f.Providers) == 0 {
+		return fmt.Errorf("no providers")
+	}
+	if f.Default != "" && f.Providers[f.Default] == nil {
+		return fmt.Errorf("default provider %q is not defined", f.Default)
+	}
+	for name, p := range f.Providers {
+		if p == nil {
+			return fmt.Errorf("provider %q is empty", name)
+		}
+		if _, err := p.options(); err != nil {
+			return fmt.Errorf("provider %q: %w", name, err)
+		}
+	}
+	for i, r := range f.Routes {
+		if len(r.Providers) == 0 {
+			return fmt.Errorf("route %d has no providers", i+1)
+		}
+		for _, name := range r.Providers {
+			if f.Providers[name] == nil {
+				return fmt.Errorf("route %d: provider %q is not defined", i+1, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Names returns the names of the file's providers, sorted
+func (f *File) Names() []string {
+	names := make([]string, 0, len(f.Providers))
+	for name := range f.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Config creates the Config of the named provider, or of the default
+// provider if name is empty. opts are applied after the file's settings,
+// for those a file cannot hold, such as metrics callbacks or a logger.
+func (f *File) Config(name string, opts ...Option) (*Config, error) {
+	if name == "" {
+		name = f.Default
+	}
+	if name == "" && len(f.Providers) == 1 {
+		name = f.Names()[0]
+	}
+	p := f.Providers[name]
+	if p == nil {
+		return nil, fmt.Errorf("provider %q is not defined in the config file", name)
+	}
+	fileOpts, err := p.options()
+	if err != nil {
+		return nil, fmt.Errorf("provider %q: %w", name, err)
+	}
+	return NewConfig(p.APIKey, append(fileOpts, opts...)...)
+}
+
+// options translates the provider's settings into options
+func (p *ProviderFile) options() ([]Option, error) {
+	var opts []Option
+	if p.Provider != "" {
+		opts = append(opts, WithProvider(p.Provider))
+	}
+	if p.Profile != "" {
+		profile, ok := profiles[p.Profile]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q", p.Profile)
+		}
+		opts = append(opts, profile)
+	}
+	if p.Model != "" {
+		opts = append(opts, WithModel(p.Model))
+	}
+	if len(p.APIKeys) > 0 {
+		opts = append(opts, WithAPIKeys(p.APIKeys...))
+	}
+	if p.BaseURL != "" {
+		opts = append(opts, WithBaseURL(p.BaseURL))
+	}
+	if p.APIVersion != "" {
+		opts = append(opts, WithAPIVersion(p.APIVersion))
+	}
+	if p.AnthropicVersion != "" {
+		opts = append(opts, WithAnthropicVersion(p.AnthropicVersion))
+	}
+	if len(p.AnthropicBetas) > 0 {
+		opts = append(opts, WithAnthropicBetas(p.AnthropicBetas...))
+	}
+	if p.OpenAIOrganization != "" {
+		opts = append(opts, WithOpenAIOrganization(p.OpenAIOrganization))
+	}
+	if p.OpenAIProject != "" {
+		opts = append(opts, WithOpenAIProject(p.OpenAIProject))
+	}
+	if p.Timeout != 0 {
+		opts = append(opts, WithTimeout(p.Timeout))
+	}
+	if p.MaxRetries != nil {
+		opts = append(opts, WithMaxRetries(*p.MaxRetries))
+	}
+	if p.PricingFile != "" {
+		opts = append(opts, WithPricingFile(p.PricingFile))
+	}
+	if pool := p.Pool; pool != nil {
+		opts = append(opts, WithPoolConfig(&resource.PoolConfig{
+			MaxSize:       pool.MaxSize,
+			IdleTimeout:   pool.IdleTimeout,
+			CleanupPeriod: pool.CleanupPeriod,
+		}))
+	}
+	if retry := p.Retry; retry != nil {
+		opts = append(opts, WithRetryConfig(&resource.RetryConfig{
+			MaxRetries:      retry.MaxRetries,
+			InitialInterval: retry.InitialInterval,
+			MaxInterval:     retry.MaxInterval,
+			Multiplier:      retry.Multiplier,
+		}))
+	}
+	if rl := p.RateLimit; rl != nil {
+		limit := &RateLimit{
+			RequestsPerMinute: rl.RequestsPerMinute,
+			TokensPerMinute:   rl.TokensPerMinute,
+			Adaptive:          rl.Adaptive,
+			Shared:            rl.Shared,
+			SlidingWindow:     rl.SlidingWindow,
+		}
+		switch rl.Mode {
+		case "", "block":
+			limit.Mode = RateLimitBlock
+		case "fail_fast":
+			limit.Mode = RateLimitFailFast
+		default:
+			return nil, fmt.Errorf("unknown rate limit mode %q", rl.Mode)
+		}
+		opts = append(opts, func(c *Config) error {
+			c.RateLimit = limit
+			return nil
+		})
+	}
+	if b := p.Budget; b != nil {
+		var mode BudgetMode
+		switch b.Mode {
+		case "", "hard":
+			mode = BudgetHard
+		case "soft":
+			mode = BudgetSoft
+		case "degrade":
+			mode = BudgetDegrade
+		default:
+			return nil, fmt.Errorf("unknown budget mode %q", b.Mode)
+		}
+		if mode == BudgetDegrade && b.FallbackModel == "" {
+			return nil, fmt.Errorf("degraded budget mode requires a fallback model")
+		}
+		opts = append(opts,
+			WithCostControl(b.MaxCostPerRequest, b.MaxCostPerDay),
+			WithBudgetMode(mode, b.FallbackModel),
+		)
+	}
+	return opts, nil
+}
+
+// WatchFile checks a configuration file every interval until ctx ends, and
+// calls onChange with the file each time its contents change. A change that
+// cannot be read or parsed is passed as an error instead, so the caller can
+// keep its current configuration; onChange is not called again until the
+// file changes once more. WatchFile blocks, so run it in a goroutine:
+//
+//	go config.WatchFile(ctx, "llm.yaml", 10*time.Second, func(f *config.File, err error) {
+//		if err != nil {
+//			log.Printf("config not reloaded: %v", err)
+//			return
+//		}
+//		err = r.Reload(f)
+//	})
+func WatchFile(ctx context.Context, filename string, interval time.Duration, onChange func(*File, error)) {
+	last, _ := os.ReadFile(filename)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			if last != nil {
+				onChange(nil, fmt.Errorf("reading config file: %w", err))
+			}
+			last = nil
+			continue
+		}
+		if last != nil && bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+		onChange(ParseFile(data))
+	}
+}
@@ -0,0 +1,261 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/resource"
+)
+
+const testConfigFile = `
+default: primary
+providers:
+  primary:
+    provider: openai
+    model: gpt-4o
+    api_key: ${TEST_LLM_FILE_KEY}
+    openai_organization: org-123
+    base_url: ${TEST_LLM_FILE_URL:-https://api.openai.com/v1}
+    timeout: 45s
+    max_retries: 0
+    pool: {max_size: 20, idle_timeout: 2m}
+    retry: {max_retries: 4, initial_interval: 200ms, max_interval: 5s, multiplier: 2}
+    rate_limit: {requests_per_minute: 500, tokens_per_minute: 90000, mode: fail_fast, shared: true}
+    budget: {max_cost_per_day: 50, mode: degrade, fallback_model: gpt-4o-mini}
+  claude:
+    provider: anthropic
+    profile: low_latency
+    api_key: anthropic-key
+    api_version: "2023-06-01"
+routes:
+  - labels: {tier: free}
+    providers: [claude, primary]
+`
+
+func TestLoadFile(t *testing.T) {
+	t.Setenv("TEST_LLM_FILE_KEY", "openai-key")
+	path := filepath.Join(t.TempDir(), "llm.yaml")
+	if err := os.WriteFile(path, []byte(testConfigFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	if names := f.Names(); len(names) != 2 || names[0] != "claude" || names[1] != "primary" {
+		t.Errorf("Names() = %v, want [claude primary]", names)
+	}
+	if len(f.Routes) != 1 || f.Routes[0].Labels["tier"] != "free" || f.Routes[0].Providers[0] != "claude" {
+		t.Errorf("Routes = %+v, want the free tier routed to claude", f.Routes)
+	}
+
+	cfg, err := f.Config("", WithModel("gpt-4o-2024-08-06"))
+	if err != nil {
+		t.Fatalf("Config() error = %v", err)
+	}
+	if cfg.APIKey != "openai-key" || cfg.BaseURL != "https://api.openai.com/v1" || cfg.Timeout != 45*time.Second || cfg.MaxRetries != 0 {
+		t.Errorf("Config() = %+v, want the interpolated key and default base URL", cfg)
+	}
+	if cfg.OpenAIOrganization != "org-123" {
+		t.Errorf("OpenAIOrganization = %q, want org-123", cfg.OpenAIOrganization)
+	}
+	if cfg.Model != "gpt-4o-2024-08-06" {
+		t.Errorf("Model = %q, want the option to override the file", cfg.Model)
+	}
+	if *cfg.PoolConfig != (resource.PoolConfig{MaxSize: 20, IdleTimeout: 2 * time.Minute}) {
+		t.Errorf("PoolConfig = %+v", cfg.PoolConfig)
+	}
+	if r := cfg.RetryConfig; r.MaxRetries != 4 || r.InitialInterval != 200*time.Millisecond || r.MaxInterval != 5*time.Second || r.Multiplier != 2 {
+		t.Errorf("RetryConfig = %+v", r)
+	}
+	if *cfg.RateLimit != (RateLimit{RequestsPerMinute: 500, TokensPerMinute: 90000, Mode: RateLimitFailFast, Shared: true}) {
+		t.Errorf("RateLimit = %+v", cfg.RateLimit)
+	}
+	if cc := cfg.CostControl; cc.MaxCostPerDay != 50 || cc.Mode != BudgetDegrade || cc.FallbackModel != "gpt-4o-mini" {
+		t.Errorf("CostControl = %+v", cc)
+	}
+
+	claude, err := f.Config("claude")
+	if err != nil {
+		t.Fatalf("Config(claude) error = %v", err)
+	}
+	if claude.Provider != "anthropic" || claude.Model != "claude-3-5-haiku-20241022" || claude.Timeout != 15*time.Second || claude.APIVersion != "2023-06-01" || claude.RateLimit != nil {
+		t.Errorf("Config(claude) = %+v", claude)
+	}
+	if _, err := f.Config("missing"); err == nil {
+		t.Errorf("Config(missing) error = nil, want an error")
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{
+			name: "json",
+			data: `{"providers": {"main": {"provider": "openai", "model": "gpt-4o", "api_key": "k", "timeout": "10s"}}}`,
+		},
+		{
+			name:    "unset variable",
+			data:    "providers:\n  main:\n    provider: openai\n    api_key: ${TEST_LLM_FILE_UNSET}",
+			wantErr: "TEST_LLM_FILE_UNSET",
+		},
+		{
+			name:    "unknown field",
+			data:    "providers:\n  main: {provider: openai, api_kye: k}",
+			wantErr: "api_kye",
+		},
+		{
+			name:    "no providers",
+			data:    "default: main",
+			wantErr: "no providers",
+		},
+		{
+			name:    "unknown default",
+			data:    "default: other\nproviders:\n  main: {provider: openai}",
+			wantErr: `default provider "other"`,
+		},
+		{
+			name:    "unknown route provider",
+			data:    "providers:\n  main: {provider: openai}\nroutes:\n  - providers: [other]",
+			wantErr: `provider "other" is not defined`,
+		},
+		{
+			name:    "unknown budget mode",
+			data:    "providers:\n  main: {provider: openai, budget: {max_cost_per_day: 1, mode: strict}}",
+			wantErr: `budget mode "strict"`,
+		},
+		{
+			name:    "degrade without fallback",
+			data:    "providers:\n  main: {provider: openai, budget: {max_cost_per_day: 1, mode: degrade}}",
+			wantErr: "fallback model",
+		},
+		{
+			name:    "unknown profile",
+			data:    "providers:\n  main: {provider: openai, profile: fast}",
+			wantErr: `profile "fast"`,
+		},
+		{
+			name:    "unknown rate limit mode",
+			data:    "providers:\n  main: {provider: openai, rate_limit: {requests_per_minute: 1, mode: drop}}",
+			wantErr: `rate limit mode "drop"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseFile([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseFile() error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFile() error = %v", err)
+			}
+			cfg, err := f.Config("")
+			if err != nil {
+				t.Fatalf("Config() error = %v", err)
+			}
+			if cfg.Model != "gpt-4o" || cfg.Timeout != 10*time.Second {
+				t.Errorf("Config() = %+v, want gpt-4o with a 10s timeout", cfg)
+			}
+		})
+	}
+}
+
+func TestParseFile_Interpolation(t *testing.T) {
+	t.Setenv("TEST_LLM_FILE_KEY", `k"ey: {with} #yaml, 'syntax'`)
+	t.Setenv("TEST_LLM_FILE_RPM", "500")
+	data := `# The key is read from ${TEST_LLM_FILE_UNSET} in production
+providers:
+  main:
+    provider: openai   # or ${TEST_LLM_FILE_UNSET}
+    model: gpt-4o
+    api_key: ${TEST_LLM_FILE_KEY}
+    api_keys: ["${TEST_LLM_FILE_KEY}"]
+    timeout: ${TEST_LLM_FILE_TIMEOUT:-10s}
+    rate_limit:
+      requests_per_minute: ${TEST_LLM_FILE_RPM}
+`
+	f, err := ParseFile([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+	p := f.Providers["main"]
+	want := `k"ey: {with} #yaml, 'syntax'`
+	if p.APIKey != want || len(p.APIKeys) != 1 || p.APIKeys[0] != want {
+		t.Errorf("api keys = %q, %q, want %q", p.APIKey, p.APIKeys, want)
+	}
+	if p.Timeout != 10*time.Second {
+		t.Errorf("timeout = %v, want the 10s default", p.Timeout)
+	}
+	if p.RateLimit == nil || p.RateLimit.RequestsPerMinute != 500 {
+		t.Errorf("rate limit = %+v, want 500 requests per minute", p.RateLimit)
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "llm.yaml")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("providers:\n  main: {provider: openai, model: gpt-4o, api_key: k}")
+
+	type change struct {
+		f   *File
+		err error
+	}
+	changes := make(chan change, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		WatchFile(ctx, path, 5*time.Millisecond, func(f *File, err error) {
+			changes <- change{f, err}
+		})
+		close(done)
+	}()
+	next := func() change {
+		t.Helper()
+		select {
+		case c := <-changes:
+			return c
+		case <-time.After(time.Second):
+			t.Fatal("no change reported")
+			return change{}
+		}
+	}
+
+	time.Sleep(20 * time.Millisecond) // let the watcher read the original file
+	write("providers:\n  main: {provider: openai, model: gpt-4o-mini, api_key: k}")
+	if c := next(); c.err != nil || c.f.Providers["main"].Model != "gpt-4o-mini" {
+		t.Errorf("change = %+v, want the new model", c)
+	}
+	write("providers: [")
+	if c := next(); c.err == nil {
+		t.Errorf("change = %+v, want a parse error", c)
+	}
+	write("providers:\n  main: {provider: anthropic, api_key: k}")
+	if c := next(); c.err != nil || c.f.Providers["main"].Provider != "anthropic" {
+		t.Errorf("change = %+v, want the fixed file", c)
+	}
+
+	cancel()
+	<-done
+	select {
+	case c := <-changes:
+		t.Errorf("unexpected change %+v for an unchanged file", c)
+	default:
+	}
+}
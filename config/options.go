@@ -1,10 +1,19 @@
 package config
 
 import (
+	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
 	"time"
 
+	"github.com/ksred/llm/pkg/audit"
+	"github.com/ksred/llm/pkg/cache"
+	"github.com/ksred/llm/pkg/conversation"
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/oauth"
 	"github.com/ksred/llm/pkg/types"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Option is a function that modifies Config
@@ -26,6 +35,51 @@ func WithModel(model string) Option {
 	}
 }
 
+// WithAPIKey sets the API key, replacing the one passed to NewConfig, so
+// a configuration can be built from options alone:
+//
+//	cfg, err := config.NewConfig("", config.WithAPIKey(key), config.WithModel("gpt-4o"))
+func WithAPIKey(key string) Option {
+	return func(c *Config) error {
+		if key == "" {
+			return fmt.Errorf("API key must not be empty")
+		}
+		c.APIKey = key
+		return nil
+	}
+}
+
+// WithAPIKeys rotates requests across several API keys for the provider,
+// failing over from keys that are rate limited or revoked. The first key
+// replaces the one passed to NewConfig.
+func WithAPIKeys(keys ...string) Option {
+	return func(c *Config) error {
+		if len(keys) == 0 {
+			return fmt.Errorf("at least one API key is required")
+		}
+		for _, k := range keys {
+			if k == "" {
+				return fmt.Errorf("API keys must not be empty")
+			}
+		}
+		c.APIKey = keys[0]
+		c.APIKeys = append([]string(nil), keys...)
+		return nil
+	}
+}
+
+// WithTokenSource authenticates requests with bearer tokens from src, such
+// as oauth.AzureAD or oauth.ClientCredentials, instead of an API key
+func WithTokenSource(src oauth.TokenSource) Option {
+	return func(c *Config) error {
+		if src == nil {
+			return fmt.Errorf("token source is required")
+		}
+		c.TokenSource = src
+		return nil
+	}
+}
+
 // WithBaseURL sets the base URL for API requests
 func WithBaseURL(url string) Option {
 	return func(c *Config) error {
@@ -75,6 +129,109 @@ func WithRateLimit(requestsPerMinute, tokensPerMinute int) Option {
 	}
 }
 
+// WithAdaptiveRateLimit paces requests at a rate learned from the
+// provider's rate-limit headers and 429 responses, starting at
+// requestsPerMinute. tokensPerMinute, if positive, is enforced as a static
+// limit.
+func WithAdaptiveRateLimit(requestsPerMinute, tokensPerMinute int) Option {
+	return func(c *Config) error {
+		c.RateLimit = &RateLimit{
+			RequestsPerMinute: requestsPerMinute,
+			TokensPerMinute:   tokensPerMinute,
+			Adaptive:          true,
+		}
+		return nil
+	}
+}
+
+// WithRateLimitMode sets whether rate-limited requests block or fail fast.
+// It must be applied after WithRateLimit.
+func WithRateLimitMode(mode RateLimitMode) Option {
+	return func(c *Config) error {
+		if c.RateLimit == nil {
+			return fmt.Errorf("rate limit mode set without a rate limit")
+		}
+		c.RateLimit.Mode = mode
+		return nil
+	}
+}
+
+// WithSharedRateLimit shares the rate limit with every other client in the
+// process using the same provider and API key. It must be applied after
+// WithRateLimit or WithAdaptiveRateLimit.
+func WithSharedRateLimit() Option {
+	return func(c *Config) error {
+		if c.RateLimit == nil {
+			return fmt.Errorf("shared rate limit set without a rate limit")
+		}
+		c.RateLimit.Shared = true
+		return nil
+	}
+}
+
+// WithSlidingWindowRateLimit enforces the rate limit over a sliding
+// sixty-second window. It must be applied after WithRateLimit or
+// WithAdaptiveRateLimit.
+func WithSlidingWindowRateLimit() Option {
+	return func(c *Config) error {
+		if c.RateLimit == nil {
+			return fmt.Errorf("sliding window rate limit set without a rate limit")
+		}
+		c.RateLimit.SlidingWindow = true
+		return nil
+	}
+}
+
+// WithDowngradeOnRateLimit retries a request once on model, typically a
+// cheaper or faster one, when the provider rate limits it or is overloaded.
+// Responses from model have DowngradedFrom set.
+func WithDowngradeOnRateLimit(model string) Option {
+	return func(c *Config) error {
+		if model == "" {
+			return fmt.Errorf("downgrade model is required")
+		}
+		c.DowngradeModel = model
+		return nil
+	}
+}
+
+// WithMaxConcurrentRequests caps the number of requests in flight at once.
+// Zero or a negative value removes the limit.
+func WithMaxConcurrentRequests(n int) Option {
+	return func(c *Config) error {
+		if n < 0 {
+			n = 0
+		}
+		c.MaxConcurrentRequests = n
+		return nil
+	}
+}
+
+// WithWarmup opens connections to the provider when the client is created,
+// so the first request does not pay for the handshakes. With ping the client
+// also sends a free authenticated request, so a bad API key fails NewClient.
+func WithWarmup(connections int, ping bool) Option {
+	return func(c *Config) error {
+		if connections < 1 {
+			connections = 1
+		}
+		c.Warmup = &Warmup{Connections: connections, Ping: ping}
+		return nil
+	}
+}
+
+// WithGaugeInterval sets how often Metrics.OnGauges receives a capacity
+// snapshot. Zero or a negative value disables periodic gauges.
+func WithGaugeInterval(interval time.Duration) Option {
+	return func(c *Config) error {
+		if interval < 0 {
+			interval = 0
+		}
+		c.GaugeInterval = interval
+		return nil
+	}
+}
+
 // WithCostControl sets cost control configuration
 func WithCostControl(maxCostPerRequest, maxCostPerDay float64) Option {
 	return func(c *Config) error {
@@ -86,6 +243,93 @@ func WithCostControl(maxCostPerRequest, maxCostPerDay float64) Option {
 	}
 }
 
+// WithBudgetMode sets what happens when a request would exceed a cost limit.
+// BudgetDegrade requires a fallbackModel. It must be applied after
+// WithCostControl.
+func WithBudgetMode(mode BudgetMode, fallbackModel string) Option {
+	return func(c *Config) error {
+		if c.CostControl == nil {
+			return fmt.Errorf("budget mode set without cost control")
+		}
+		if mode == BudgetDegrade && fallbackModel == "" {
+			return fmt.Errorf("degraded budget mode requires a fallback model")
+		}
+		c.CostControl.Mode = mode
+		c.CostControl.FallbackModel = fallbackModel
+		return nil
+	}
+}
+
+// WithBudgetAlert calls fn whenever a request would exceed a cost limit. It
+// must be applied after WithCostControl.
+func WithBudgetAlert(fn func(provider, model string, err error)) Option {
+	return func(c *Config) error {
+		if c.CostControl == nil {
+			return fmt.Errorf("budget alert set without cost control")
+		}
+		c.CostControl.OnExceeded = fn
+		return nil
+	}
+}
+
+// WithBudgetCurrency makes the cost limits amounts in the given currency,
+// such as "EUR", converted from US dollar prices with rates. It must be
+// applied after WithCostControl.
+func WithBudgetCurrency(code string, rates cost.ExchangeRates) Option {
+	return func(c *Config) error {
+		if c.CostControl == nil {
+			return fmt.Errorf("budget currency set without cost control")
+		}
+		c.CostControl.Currency = cost.Currency{Code: code, Rates: rates}
+		return nil
+	}
+}
+
+// WithPricing prices requests from catalog instead of the library's default
+// catalog
+func WithPricing(catalog *cost.PricingCatalog) Option {
+	return func(c *Config) error {
+		c.Pricing = catalog
+		return nil
+	}
+}
+
+// WithPriceOverrides replaces the catalog prices of a provider's models,
+// for example with negotiated or committed-use rates. Keys are model names
+// or patterns such as "gpt-4o*", which take precedence over every catalog
+// entry. Models without an override keep their catalog prices. The prices
+// also apply to usage recorded by the config's CostTracker.
+func WithPriceOverrides(provider string, rates map[string]cost.TokenRates) Option {
+	return func(c *Config) error {
+		pricing := c.pricing()
+		for model, r := range rates {
+			if err := pricing.Override(provider, model, r); err != nil {
+				return err
+			}
+		}
+		c.Pricing = pricing
+		return nil
+	}
+}
+
+// WithPricingFile applies the prices in a JSON or YAML pricing file, in the
+// format read by cost.LoadPricingCatalog, as overrides like
+// WithPriceOverrides
+func WithPricingFile(filename string) Option {
+	return func(c *Config) error {
+		overrides, err := cost.LoadPricingCatalog(filename)
+		if err != nil {
+			return err
+		}
+		for provider, models := range overrides.Rates() {
+			if err := WithPriceOverrides(provider, models)(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 // WithMetrics sets the metrics callbacks
 func WithMetrics(metrics *types.MetricsCallbacks) Option {
 	return func(c *Config) error {
@@ -93,3 +337,227 @@ func WithMetrics(metrics *types.MetricsCallbacks) Option {
 		return nil
 	}
 }
+
+// WithLogger enables structured logging of requests, retries and streams
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Config) error {
+		c.Logger = logger
+		return nil
+	}
+}
+
+// WithTracerProvider enables OpenTelemetry tracing using tp instead of the
+// global tracer provider
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *Config) error {
+		c.TracerProvider = tp
+		return nil
+	}
+}
+
+// WithAudit records every request and its response or error with the given
+// Auditor
+func WithAudit(a *audit.Auditor) Option {
+	return func(c *Config) error {
+		c.Audit = a
+		return nil
+	}
+}
+
+// WithCostTracker records the usage and cost of every request in t,
+// attributed to the labels set on the request or its context
+func WithCostTracker(t *cost.CostTracker) Option {
+	return func(c *Config) error {
+		c.CostTracker = t
+		return nil
+	}
+}
+
+// WithHooks registers request and stream middleware hooks. It may be
+// given several times; hooks run in registration order.
+func WithHooks(hooks ...*types.Hooks) Option {
+	return func(c *Config) error {
+		c.Hooks = append(c.Hooks, hooks...)
+		return nil
+	}
+}
+
+// WithCache enables response caching for Chat requests
+func WithCache(c cache.Cache) Option {
+	return func(cfg *Config) error {
+		cfg.Cache = c
+		return nil
+	}
+}
+
+// WithValidators checks Chat responses with validators, re-asking the model
+// with the validation error up to maxReasks times before failing
+func WithValidators(maxReasks int, validators ...types.Validator) Option {
+	return func(c *Config) error {
+		if maxReasks < 0 {
+			maxReasks = 0
+		}
+		c.Validators = append(c.Validators, validators...)
+		c.MaxReasks = maxReasks
+		return nil
+	}
+}
+
+// WithStreamBufferLimits sets the read buffer size and the largest event
+// accepted when streaming. Zero keeps a default.
+func WithStreamBufferLimits(bufferSize, maxEventSize int) Option {
+	return func(c *Config) error {
+		if bufferSize < 0 {
+			bufferSize = 0
+		}
+		c.StreamBufferSize = bufferSize
+		c.MaxStreamEventSize = maxEventSize
+		return nil
+	}
+}
+
+// WithStreamIdleTimeout ends streams that go quiet for the given duration.
+// Zero or a negative value disables the timeout.
+func WithStreamIdleTimeout(timeout time.Duration) Option {
+	return func(c *Config) error {
+		if timeout < 0 {
+			timeout = 0
+		}
+		c.StreamIdleTimeout = timeout
+		return nil
+	}
+}
+
+// WithContextCheck rejects requests whose estimated prompt plus MaxTokens
+// exceed the model's context window, before they are sent
+func WithContextCheck() Option {
+	return func(c *Config) error {
+		c.ContextCheck = &ContextCheck{}
+		return nil
+	}
+}
+
+// WithoutParameterValidation sends requests without checking their
+// parameters against the provider's ranges first, for endpoints whose
+// limits differ from the provider's
+func WithoutParameterValidation() Option {
+	return func(c *Config) error {
+		c.SkipParameterValidation = true
+		return nil
+	}
+}
+
+// WithContextTrimming trims chat requests that exceed the model's context
+// window with the given strategy, such as conversation.SlidingWindow{}
+func WithContextTrimming(strategy conversation.TruncationStrategy) Option {
+	return func(c *Config) error {
+		c.ContextCheck = &ContextCheck{Truncation: strategy}
+		return nil
+	}
+}
+
+// WithModelUpgrades replaces a retired model with its successor instead of
+// letting requests fail. successors maps models to their replacements,
+// whatever their status, and overrides the registry's successors.
+func WithModelUpgrades(successors map[string]string) Option {
+	return func(c *Config) error {
+		c.UpgradeModels = true
+		c.ModelSuccessors = successors
+		return nil
+	}
+}
+
+// WithAPIVersion pins the provider's API version, such as "2023-06-01" for
+// Anthropic, instead of the version the library defaults to
+func WithAPIVersion(version string) Option {
+	return func(c *Config) error {
+		if version == "" {
+			return fmt.Errorf("API version is required")
+		}
+		c.APIVersion = version
+		return nil
+	}
+}
+
+// WithAnthropicVersion pins the anthropic-version header, such as
+// "2023-06-01". Unlike WithAPIVersion it applies to Anthropic only, so one
+// set of options can serve several providers.
+func WithAnthropicVersion(version string) Option {
+	return func(c *Config) error {
+		if version == "" {
+			return fmt.Errorf("Anthropic version is required")
+		}
+		c.AnthropicVersion = version
+		return nil
+	}
+}
+
+// WithOpenAIOrganization bills OpenAI requests to the given organization
+// ID, for API keys belonging to several organizations
+func WithOpenAIOrganization(org string) Option {
+	return func(c *Config) error {
+		if org == "" {
+			return fmt.Errorf("OpenAI organization is required")
+		}
+		c.OpenAIOrganization = org
+		return nil
+	}
+}
+
+// WithOpenAIProject attributes OpenAI requests to the given project ID
+func WithOpenAIProject(project string) Option {
+	return func(c *Config) error {
+		if project == "" {
+			return fmt.Errorf("OpenAI project is required")
+		}
+		c.OpenAIProject = project
+		return nil
+	}
+}
+
+// WithHealthThresholds sets when the client reports its provider as
+// degraded or unhealthy
+func WithHealthThresholds(t HealthThresholds) Option {
+	return func(c *Config) error {
+		if t.Window < 0 || t.MinRequests < 0 || t.DegradedErrorRate < 0 || t.UnhealthyErrorRate < 0 || t.DegradedLatency < 0 {
+			return fmt.Errorf("health thresholds must not be negative")
+		}
+		c.Health = &t
+		return nil
+	}
+}
+
+// WithAnthropicBetas opts into Anthropic beta features by name, such as
+// "prompt-caching-2024-07-31". Betas add to those already set.
+func WithAnthropicBetas(betas ...string) Option {
+	return func(c *Config) error {
+		for _, beta := range betas {
+			if beta == "" {
+				return fmt.Errorf("beta feature names must not be empty")
+			}
+			if !slices.Contains(c.AnthropicBetas, beta) {
+				c.AnthropicBetas = append(c.AnthropicBetas, beta)
+			}
+		}
+		return nil
+	}
+}
+
+// WithIdempotency enables idempotency keys and local de-duplication of
+// resubmitted requests for the given window
+func WithIdempotency(ttl time.Duration) Option {
+	return func(c *Config) error {
+		c.IdempotencyTTL = ttl
+		return nil
+	}
+}
+
+// pricing returns a copy of the config's pricing catalog, or of the default
+// catalog, so overrides never change a catalog passed to WithPricing or the
+// default one
+func (c *Config) pricing() *cost.PricingCatalog {
+	if c.Pricing == nil {
+		return cost.DefaultCatalog().Clone()
+	}
+	return c.Pricing.Clone()
+}
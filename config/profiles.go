@@ -0,0 +1,121 @@
+package config
+
+import (
+	"time"
+
+	"github.com/ksred/llm/pkg/resource"
+)
+
+// Profiles bundle a model and pool, retry and timeout settings suited to a
+// kind of workload, as a starting point that needs no tuning. A profile
+// chooses its model by provider, so apply it after WithProvider, and before
+// any options that should override it:
+//
+//	cfg, err := config.NewConfig(apiKey,
+//		config.WithProvider("anthropic"),
+//		config.ProfileLowLatency,
+//		config.WithTimeout(5*time.Second),
+//	)
+var (
+	// ProfileLowLatency suits interactive use: a small, fast model, short
+	// timeouts, one quick retry, and idle connections kept open for reuse.
+	// Add WithWarmup to also open them before the first request.
+	ProfileLowLatency Option = func(c *Config) error {
+		return apply(c,
+			withProfileModel("gpt-4o-mini", "claude-3-5-haiku-20241022"),
+			WithTimeout(15*time.Second),
+			WithMaxRetries(1),
+			WithRetryConfig(&resource.RetryConfig{
+				MaxRetries:      1,
+				InitialInterval: 100 * time.Millisecond,
+				MaxInterval:     500 * time.Millisecond,
+				Multiplier:      2,
+				Jitter:          resource.JitterFull,
+			}),
+			WithPoolConfig(&resource.PoolConfig{
+				MaxSize:       20,
+				IdleTimeout:   90 * time.Second,
+				CleanupPeriod: 30 * time.Second,
+			}),
+			WithStreamIdleTimeout(10*time.Second),
+		)
+	}
+
+	// ProfileBatch suits offline work where throughput and completion
+	// matter more than latency: a capable model, long timeouts, many
+	// patient retries, and a large connection pool
+	ProfileBatch Option = func(c *Config) error {
+		return apply(c,
+			withProfileModel("gpt-4o", "claude-3-5-sonnet-20241022"),
+			WithTimeout(2*time.Minute),
+			WithMaxRetries(6),
+			WithRetryConfig(&resource.RetryConfig{
+				MaxRetries:      6,
+				InitialInterval: 2 * time.Second,
+				MaxInterval:     time.Minute,
+				Multiplier:      2,
+				Jitter:          resource.JitterDecorrelated,
+			}),
+			WithPoolConfig(&resource.PoolConfig{
+				MaxSize:       50,
+				IdleTimeout:   5 * time.Minute,
+				CleanupPeriod: time.Minute,
+			}),
+		)
+	}
+
+	// ProfileCostSaving keeps spending down: a small, cheap model, few
+	// retries, and a context window check, so requests that cannot fit fail
+	// before they are paid for
+	ProfileCostSaving Option = func(c *Config) error {
+		return apply(c,
+			withProfileModel("gpt-4o-mini", "claude-3-5-haiku-20241022"),
+			WithTimeout(time.Minute),
+			WithMaxRetries(2),
+			WithRetryConfig(&resource.RetryConfig{
+				MaxRetries:      2,
+				InitialInterval: time.Second,
+				MaxInterval:     10 * time.Second,
+				Multiplier:      2,
+				Jitter:          resource.JitterFull,
+			}),
+			WithPoolConfig(&resource.PoolConfig{
+				MaxSize:       DefaultPoolSize,
+				IdleTimeout:   DefaultPoolIdleTimeout,
+				CleanupPeriod: DefaultPoolIdleTimeout,
+			}),
+			WithContextCheck(),
+		)
+	}
+)
+
+// profiles are the profiles by the names configuration files use
+var profiles = map[string]Option{
+	"low_latency": ProfileLowLatency,
+	"batch":       ProfileBatch,
+	"cost_saving": ProfileCostSaving,
+}
+
+// withProfileModel sets the model for the configured provider. Other
+// providers keep their model.
+func withProfileModel(openai, anthropic string) Option {
+	return func(c *Config) error {
+		switch c.Provider {
+		case "openai", "":
+			c.Model = openai
+		case "anthropic":
+			c.Model = anthropic
+		}
+		return nil
+	}
+}
+
+// apply applies opts to c in order, stopping at the first error
+func apply(c *Config, opts ...Option) error {
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProfiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		profile     Option
+		provider    string
+		wantModel   string
+		wantTimeout time.Duration
+		wantRetries int
+	}{
+		{"low latency", ProfileLowLatency, "openai", "gpt-4o-mini", 15 * time.Second, 1},
+		{"low latency anthropic", ProfileLowLatency, "anthropic", "claude-3-5-haiku-20241022", 15 * time.Second, 1},
+		{"batch", ProfileBatch, "openai", "gpt-4o", 2 * time.Minute, 6},
+		{"batch anthropic", ProfileBatch, "anthropic", "claude-3-5-sonnet-20241022", 2 * time.Minute, 6},
+		{"cost saving", ProfileCostSaving, "openai", "gpt-4o-mini", time.Minute, 2},
+		{"cost saving anthropic", ProfileCostSaving, "anthropic", "claude-3-5-haiku-20241022", time.Minute, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := NewConfig("test-key", WithProvider(tt.provider), tt.profile)
+			if err != nil {
+				t.Fatalf("NewConfig() error = %v", err)
+			}
+			if cfg.Model != tt.wantModel {
+				t.Errorf("Model = %q, want %q", cfg.Model, tt.wantModel)
+			}
+			if cfg.Timeout != tt.wantTimeout || cfg.HTTPClient.Timeout != tt.wantTimeout {
+				t.Errorf("Timeout = %v, want %v", cfg.Timeout, tt.wantTimeout)
+			}
+			if cfg.MaxRetries != tt.wantRetries || cfg.RetryConfig == nil || cfg.RetryConfig.MaxRetries != tt.wantRetries {
+				t.Errorf("MaxRetries = %d, RetryConfig = %+v, want %d retries", cfg.MaxRetries, cfg.RetryConfig, tt.wantRetries)
+			}
+			if cfg.PoolConfig == nil || cfg.PoolConfig.MaxSize == 0 {
+				t.Errorf("PoolConfig = %+v, want a pool size", cfg.PoolConfig)
+			}
+
+			// Each use gets its own settings, and later options win
+			other, err := NewConfig("test-key", WithProvider(tt.provider), tt.profile, WithModel("custom"), WithTimeout(time.Second))
+			if err != nil {
+				t.Fatalf("NewConfig() error = %v", err)
+			}
+			if other.Model != "custom" || other.Timeout != time.Second {
+				t.Errorf("overridden Model = %q, Timeout = %v, want custom and 1s", other.Model, other.Timeout)
+			}
+			if other.RetryConfig == cfg.RetryConfig || other.PoolConfig == cfg.PoolConfig {
+				t.Errorf("configs from the same profile share retry or pool settings")
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Package auth authenticates requests to providers. Each scheme, such as an
+// API key header, a bearer token, OAuth or AWS Signature Version 4, is an
+// Authenticator, and providers install theirs in their transport rather
+// than setting credential headers themselves.
+package auth
+
+import (
+	"net/http"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/oauth"
+)
+
+// Authenticator adds credentials to a request
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// Func adapts a function to an Authenticator
+type Func func(req *http.Request) error
+
+// Authenticate calls f
+func (f Func) Authenticate(req *http.Request) error {
+	return f(req)
+}
+
+// Bearer sends token in the Authorization header, as OpenAI expects
+func Bearer(token string) Authenticator {
+	return Func(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// APIKeyHeader sends key in the named header, such as X-Api-Key for
+// Anthropic or Api-Key for Azure OpenAI
+func APIKeyHeader(name, key string) Authenticator {
+	return Func(func(req *http.Request) error {
+		req.Header.Set(name, key)
+		return nil
+	})
+}
+
+// OAuth sends bearer tokens from src, fetched with the request's context
+func OAuth(src oauth.TokenSource) Authenticator {
+	return Func(func(req *http.Request) error {
+		token, err := src.Token(req.Context())
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		return nil
+	})
+}
+
+// FromConfig returns how a provider authenticates with cfg: with bearer
+// tokens from cfg.TokenSource if one is set, and otherwise by presenting
+// cfg.APIKey with apiKey, the provider's own scheme
+func FromConfig(cfg *config.Config, apiKey func(key string) Authenticator) Authenticator {
+	if cfg.TokenSource != nil {
+		return OAuth(cfg.TokenSource)
+	}
+	return apiKey(cfg.APIKey)
+}
+
+// Transport returns a RoundTripper that authenticates each request with a,
+// then sends it with next. Every attempt, including retries, is
+// authenticated afresh, so tokens and signatures are never stale. A nil
+// next uses http.DefaultTransport.
+func Transport(a Authenticator, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{auth: a, next: next}
+}
+
+type transport struct {
+	auth Authenticator
+	next http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the caller's request
+	req = req.Clone(req.Context())
+	if err := t.auth.Authenticate(req); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/oauth"
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestAuthenticators(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       Authenticator
+		wantHeader string
+		wantValue  string
+	}{
+		{name: "bearer", auth: Bearer("sk-test"), wantHeader: "Authorization", wantValue: "Bearer sk-test"},
+		{name: "api key header", auth: APIKeyHeader("X-Api-Key", "sk-test"), wantHeader: "X-Api-Key", wantValue: "sk-test"},
+		{name: "azure api key", auth: APIKeyHeader("Api-Key", "az-test"), wantHeader: "Api-Key", wantValue: "az-test"},
+		{name: "oauth", auth: OAuth(oauth.StaticToken("at-test")), wantHeader: "Authorization", wantValue: "Bearer at-test"},
+		{
+			name:       "config with token source",
+			auth:       FromConfig(&config.Config{APIKey: "sk-test", TokenSource: oauth.StaticToken("at-test")}, Bearer),
+			wantHeader: "Authorization",
+			wantValue:  "Bearer at-test",
+		},
+		{
+			name:       "config with API key",
+			auth:       FromConfig(&config.Config{APIKey: "sk-test"}, Bearer),
+			wantHeader: "Authorization",
+			wantValue:  "Bearer sk-test",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, "https://api.example.com/v1/chat", nil)
+			if err := tt.auth.Authenticate(req); err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if got := req.Header.Get(tt.wantHeader); got != tt.wantValue {
+				t.Errorf("%s = %q, want %q", tt.wantHeader, got, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	tokens := []string{"first", "second"}
+	src := oauth.TokenSourceFunc(func(context.Context) (*oauth.Token, error) {
+		token := tokens[0]
+		tokens = tokens[1:]
+		return &oauth.Token{AccessToken: token}, nil
+	})
+	client := &http.Client{Transport: Transport(OAuth(src), nil)}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	for i := 0; i < 2; i++ {
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	if len(got) != 2 || got[0] != "Bearer first" || got[1] != "Bearer second" {
+		t.Errorf("Authorization headers = %q, want each attempt authenticated afresh", got)
+	}
+	if req.Header.Get("Authorization") != "" {
+		t.Error("Transport modified the caller's request")
+	}
+
+	failing := &http.Client{Transport: Transport(OAuth(oauth.TokenSourceFunc(func(context.Context) (*oauth.Token, error) {
+		return nil, types.ErrInvalidCredentials
+	})), nil)}
+	if _, err := failing.Get(server.URL); !errors.Is(err, types.ErrInvalidCredentials) {
+		t.Errorf("Get() error = %v, want %v", err, types.ErrInvalidCredentials)
+	}
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exampleCredentials are the credentials of the AWS Signature Version 4
+// test suite
+var exampleCredentials = AWSCredentials{
+	AccessKeyID:     "AKIDEXAMPLE",
+	SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+}
+
+func TestSigV4(t *testing.T) {
+	signer := SigV4(exampleCredentials, "us-east-1", "service").(*sigV4)
+	signer.now = func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) }
+
+	// The test suite's get-vanilla case
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err := signer.Authenticate(req); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("X-Amz-Date = %q, want 20150830T123600Z", got)
+	}
+}
+
+func TestSigV4_Body(t *testing.T) {
+	creds := exampleCredentials
+	creds.SessionToken = "session"
+	signer := SigV4(creds, "us-east-1", "bedrock").(*sigV4)
+	signer.now = func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	sign := func(body string) string {
+		req, _ := http.NewRequest(http.MethodPost, "https://bedrock-runtime.us-east-1.amazonaws.com/model/anthropic.claude-v2:1/invoke", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if err := signer.Authenticate(req); err != nil {
+			t.Fatalf("Authenticate() error = %v", err)
+		}
+		sent, _ := io.ReadAll(req.Body)
+		if string(sent) != body {
+			t.Errorf("body after signing = %q, want %q", sent, body)
+		}
+		if req.Header.Get("X-Amz-Security-Token") != "session" {
+			t.Error("session token not sent")
+		}
+		auth := req.Header.Get("Authorization")
+		if !strings.Contains(auth, "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token,") {
+			t.Errorf("Authorization = %q, want content type and session token signed", auth)
+		}
+		return auth
+	}
+
+	if sign(`{"prompt":"a"}`) == sign(`{"prompt":"b"}`) {
+		t.Error("different bodies produced the same signature")
+	}
+}
@@ -0,0 +1,225 @@
+// Package keyring spreads a provider's requests across several API keys,
+// failing over from keys that are rate limited or revoked.
+package keyring
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ksred/llm/internal/ratelimit"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// defaultCooldown is how long a rate limited key rests when the provider
+// does not say
+const defaultCooldown = 30 * time.Second
+
+// key is one API key and its health
+type key struct {
+	secret       string
+	requests     int64
+	rateLimited  int64
+	unauthorized int64
+	coolingUntil time.Time
+	revoked      bool
+}
+
+// Ring rotates requests across its keys
+type Ring struct {
+	onChange func(types.KeyHealth)
+	now      func() time.Time
+
+	mu   sync.Mutex
+	keys []*key
+	next int
+}
+
+// New creates a ring over keys. onChange, if set, is called with a key's
+// health whenever the key is rate limited or rejected.
+func New(keys []string, onChange func(types.KeyHealth)) *Ring {
+	r := &Ring{onChange: onChange, now: time.Now}
+	for _, k := range keys {
+		r.keys = append(r.keys, &key{secret: k})
+	}
+	return r
+}
+
+// Health returns a snapshot of every key's health, in configuration order
+func (r *Ring) Health() []types.KeyHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	out := make([]types.KeyHealth, len(r.keys))
+	for i, k := range r.keys {
+		out[i] = k.health(now)
+	}
+	return out
+}
+
+// Transport returns a RoundTripper that sends each request with the next
+// healthy key, retrying it with another key if the provider answers 429 or
+// 401. A nil next uses http.DefaultTransport.
+func (r *Ring) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{ring: r, next: next}
+}
+
+type transport struct {
+	ring *Ring
+	next http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tried := make(map[int]bool)
+	for {
+		i, secret := t.ring.pick(tried)
+		tried[i] = true
+
+		attempt := req.Clone(req.Context())
+		setKey(attempt.Header, secret)
+		if len(tried) > 1 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attempt.Body = body
+		}
+
+		resp, err := t.next.RoundTrip(attempt)
+		if err != nil {
+			return nil, err
+		}
+		if !t.ring.observe(i, resp) {
+			return resp, nil
+		}
+
+		// Fail over only if the request can be resent and another key might
+		// do better
+		if (req.Body != nil && req.GetBody == nil) || !t.ring.hasHealthy(tried) {
+			return resp, nil
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+// pick returns the next healthy key not yet tried. When none is left it
+// returns the rate limited key that recovers soonest, or failing that any
+// key, so the provider's error reaches the caller.
+func (r *Ring) pick(tried map[int]bool) (int, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+
+	for n := 0; n < len(r.keys); n++ {
+		i := (r.next + n) % len(r.keys)
+		if !tried[i] && r.keys[i].healthy(now) {
+			r.next = i + 1
+			r.keys[i].requests++
+			return i, r.keys[i].secret
+		}
+	}
+
+	best := -1
+	for i, k := range r.keys {
+		if !k.revoked && (best < 0 || k.coolingUntil.Before(r.keys[best].coolingUntil)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		best = 0
+	}
+	r.keys[best].requests++
+	return best, r.keys[best].secret
+}
+
+// hasHealthy reports whether a healthy key remains untried
+func (r *Ring) hasHealthy(tried map[int]bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	for i, k := range r.keys {
+		if !tried[i] && k.healthy(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// observe updates key i from its response and reports whether the key
+// failed, so another should be tried
+func (r *Ring) observe(i int, resp *http.Response) bool {
+	r.mu.Lock()
+	now := r.now()
+	k := r.keys[i]
+	failed := true
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		k.rateLimited++
+		wait, ok := ratelimit.RetryAfter(resp.Header, now)
+		if !ok || wait <= 0 {
+			wait = defaultCooldown
+		}
+		k.coolingUntil = now.Add(wait)
+	case http.StatusUnauthorized:
+		// A 403 is not counted: it usually means the key may not use
+		// this model or feature, not that the key is invalid
+		k.unauthorized++
+		k.revoked = true
+	default:
+		failed = false
+		k.coolingUntil = time.Time{}
+	}
+
+	health := k.health(now)
+	r.mu.Unlock()
+
+	if failed && r.onChange != nil {
+		r.onChange(health)
+	}
+	return failed
+}
+
+// healthy reports whether the key may be used at now
+func (k *key) healthy(now time.Time) bool {
+	return !k.revoked && !now.Before(k.coolingUntil)
+}
+
+// health returns the key's public health snapshot
+func (k *key) health(now time.Time) types.KeyHealth {
+	h := types.KeyHealth{
+		Key:          redact(k.secret),
+		Healthy:      k.healthy(now),
+		Revoked:      k.revoked,
+		Requests:     k.requests,
+		RateLimited:  k.rateLimited,
+		Unauthorized: k.unauthorized,
+	}
+	if now.Before(k.coolingUntil) {
+		h.CoolingUntil = k.coolingUntil
+	}
+	return h
+}
+
+// setKey replaces the API key in whichever auth header the provider set
+func setKey(h http.Header, secret string) {
+	if h.Get("Authorization") != "" {
+		h.Set("Authorization", "Bearer "+secret)
+	}
+	if h.Get("X-Api-Key") != "" {
+		h.Set("X-Api-Key", secret)
+	}
+}
+
+// redact identifies a key by its last four characters
+func redact(secret string) string {
+	if len(secret) <= 8 {
+		return "..."
+	}
+	return "..." + secret[len(secret)-4:]
+}
@@ -0,0 +1,145 @@
+package keyring
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// server answers each key with its scripted status and records the keys
+// it saw
+type server struct {
+	mu     sync.Mutex
+	status map[string]int
+	seen   []string
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if key == "" {
+		key = r.Header.Get("X-Api-Key")
+	}
+	s.mu.Lock()
+	s.seen = append(s.seen, key)
+	status := s.status[key]
+	s.mu.Unlock()
+
+	if status == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", "60")
+	}
+	if status != 0 {
+		w.WriteHeader(status)
+	}
+}
+
+func (s *server) reset() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := s.seen
+	s.seen = nil
+	return seen
+}
+
+func send(t *testing.T, c *http.Client, url, header string) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(`{"model":"x"}`))
+	req.Header.Set(header, "placeholder")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("request error = %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestRing(t *testing.T) {
+	srv := &server{status: map[string]int{}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	var events []types.KeyHealth
+	now := time.Now()
+	ring := New([]string{"sk-key-aaaa", "sk-key-bbbb", "sk-key-cccc"}, func(h types.KeyHealth) {
+		events = append(events, h)
+	})
+	ring.now = func() time.Time { return now }
+	c := &http.Client{Transport: ring.Transport(nil)}
+
+	// Healthy keys are used in turn
+	for i := 0; i < 3; i++ {
+		send(t, c, ts.URL, "Authorization")
+	}
+	if seen := srv.reset(); strings.Join(seen, ",") != "sk-key-aaaa,sk-key-bbbb,sk-key-cccc" {
+		t.Errorf("keys used = %v, want each in turn", seen)
+	}
+
+	// A rate limited key fails over to the next
+	srv.status["sk-key-aaaa"] = http.StatusTooManyRequests
+	if status := send(t, c, ts.URL, "X-Api-Key"); status != http.StatusOK {
+		t.Errorf("status = %d, want 200 after failover", status)
+	}
+	if seen := srv.reset(); strings.Join(seen, ",") != "sk-key-aaaa,sk-key-bbbb" {
+		t.Errorf("keys used = %v, want a then b", seen)
+	}
+
+	// A revoked key fails over too, and neither is used again
+	srv.status["sk-key-cccc"] = http.StatusUnauthorized
+	send(t, c, ts.URL, "Authorization")
+	send(t, c, ts.URL, "Authorization")
+	if seen := srv.reset(); strings.Join(seen, ",") != "sk-key-cccc,sk-key-bbbb,sk-key-bbbb" {
+		t.Errorf("keys used = %v, want c, then b twice", seen)
+	}
+
+	health := ring.Health()
+	if a := health[0]; a.Healthy || a.RateLimited != 1 || !a.CoolingUntil.Equal(now.Add(time.Minute)) || a.Key != "...aaaa" {
+		t.Errorf("rate limited key health = %+v", a)
+	}
+	if c := health[2]; c.Healthy || !c.Revoked || c.Unauthorized != 1 {
+		t.Errorf("revoked key health = %+v", c)
+	}
+	if len(events) != 2 || events[0].Key != "...aaaa" || events[1].Key != "...cccc" {
+		t.Errorf("health events = %+v, want a then c", events)
+	}
+
+	// With every key unhealthy, the provider's error reaches the caller
+	srv.status["sk-key-bbbb"] = http.StatusTooManyRequests
+	if status := send(t, c, ts.URL, "Authorization"); status != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", status)
+	}
+	srv.reset()
+
+	// Once its cooldown passes, a rate limited key is used again
+	now = now.Add(2 * time.Minute)
+	delete(srv.status, "sk-key-aaaa")
+	if status := send(t, c, ts.URL, "Authorization"); status != http.StatusOK {
+		t.Errorf("status = %d, want 200 after the cooldown", status)
+	}
+	if seen := srv.reset(); len(seen) == 0 || seen[len(seen)-1] != "sk-key-aaaa" {
+		t.Errorf("keys used = %v, want a to answer", seen)
+	}
+}
+
+func TestRing_Forbidden(t *testing.T) {
+	srv := &server{status: map[string]int{"sk-key-aaaa": http.StatusForbidden}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ring := New([]string{"sk-key-aaaa", "sk-key-bbbb"}, nil)
+	c := &http.Client{Transport: ring.Transport(nil)}
+
+	// A forbidden request reaches the caller and leaves the key in use
+	if status := send(t, c, ts.URL, "Authorization"); status != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", status)
+	}
+	if seen := srv.reset(); strings.Join(seen, ",") != "sk-key-aaaa" {
+		t.Errorf("keys used = %v, want a only", seen)
+	}
+	if a := ring.Health()[0]; !a.Healthy || a.Revoked || a.Unauthorized != 0 {
+		t.Errorf("forbidden key health = %+v, want healthy", a)
+	}
+}
@@ -0,0 +1,211 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AdaptiveConfig configures an Adaptive limiter. Rates are in requests per
+// second.
+type AdaptiveConfig struct {
+	InitialRate float64 // Starting rate
+	MinRate     float64 // Floor the rate never drops below
+	MaxRate     float64 // Ceiling the rate never rises above
+	Increase    float64 // Added to the rate after each successful response
+	Decrease    float64 // Multiplies the rate after each rate-limited response
+}
+
+// withDefaults fills unset fields relative to the initial rate
+func (c AdaptiveConfig) withDefaults() AdaptiveConfig {
+	if c.InitialRate <= 0 {
+		c.InitialRate = 1
+	}
+	if c.MinRate <= 0 {
+		c.MinRate = 1.0 / 60
+	}
+	if c.MaxRate <= 0 {
+		c.MaxRate = c.InitialRate * 10
+	}
+	if c.Increase <= 0 {
+		c.Increase = c.InitialRate / 20
+	}
+	if c.Decrease <= 0 || c.Decrease >= 1 {
+		c.Decrease = 0.5
+	}
+	return c
+}
+
+// Adaptive paces requests at a rate learned from provider responses. It
+// increases the rate additively while requests succeed and cuts it
+// multiplicatively on 429 and overload responses (AIMD). Retry-After and
+// provider rate-limit headers pause all requests until the limit resets.
+type Adaptive struct {
+	mu     sync.Mutex
+	cfg    AdaptiveConfig
+	rate   float64
+	next   time.Time // earliest time the next request may be sent
+	paused time.Time // no requests before this time
+	now    func() time.Time
+}
+
+// NewAdaptive creates an adaptive limiter
+func NewAdaptive(cfg AdaptiveConfig) *Adaptive {
+	cfg = cfg.withDefaults()
+	return &Adaptive{
+		cfg:  cfg,
+		rate: cfg.InitialRate,
+		now:  time.Now,
+	}
+}
+
+// Rate returns the current rate in requests per second
+func (a *Adaptive) Rate() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rate
+}
+
+// Delay returns how long a request made now would wait, without reserving
+// a slot
+func (a *Adaptive) Delay() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	return a.slot(now).Sub(now)
+}
+
+// slot returns the earliest time a request may be sent. Callers must hold
+// a.mu.
+func (a *Adaptive) slot(now time.Time) time.Time {
+	slot := now
+	if a.next.After(slot) {
+		slot = a.next
+	}
+	if a.paused.After(slot) {
+		slot = a.paused
+	}
+	return slot
+}
+
+// reserve takes the next send slot and returns how long to wait for it
+func (a *Adaptive) reserve() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	slot := a.slot(now)
+	a.next = slot.Add(time.Duration(float64(time.Second) / a.rate))
+	return slot.Sub(now)
+}
+
+// Wait blocks until the next request may be sent, or ctx is done
+func (a *Adaptive) Wait(ctx context.Context) error {
+	wait := a.reserve()
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Observe adjusts the rate from a provider response
+func (a *Adaptive) Observe(resp *http.Response) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable, 529:
+		a.rate *= a.cfg.Decrease
+		if a.rate < a.cfg.MinRate {
+			a.rate = a.cfg.MinRate
+		}
+		if d, ok := RetryAfter(resp.Header, now); ok {
+			a.pause(now.Add(d))
+		}
+	default:
+		if resp.StatusCode < 400 {
+			a.rate += a.cfg.Increase
+			if a.rate > a.cfg.MaxRate {
+				a.rate = a.cfg.MaxRate
+			}
+		}
+	}
+
+	if reset, ok := exhaustedUntil(resp.Header, now); ok {
+		a.pause(reset)
+	}
+}
+
+// pause holds requests until t. Callers must hold a.mu.
+func (a *Adaptive) pause(t time.Time) {
+	if t.After(a.paused) {
+		a.paused = t
+	}
+}
+
+// Transport returns a RoundTripper that paces requests through the limiter
+// and learns from their responses. A nil next uses http.DefaultTransport.
+func (a *Adaptive) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &adaptiveTransport{limiter: a, next: next}
+}
+
+type adaptiveTransport struct {
+	limiter *Adaptive
+	next    http.RoundTripper
+}
+
+func (t *adaptiveTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.limiter.Observe(resp)
+	return resp, nil
+}
+
+// RetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func RetryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t.Sub(now), true
+	}
+	return 0, false
+}
+
+// exhaustedUntil reports when the request quota resets if provider headers
+// say it is used up
+func exhaustedUntil(h http.Header, now time.Time) (time.Time, bool) {
+	if l := FromHeaders(h, now); l != nil && l.RequestsRemaining == 0 && !l.RequestsReset.IsZero() {
+		return l.RequestsReset, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func response(status int, header map[string]string) *http.Response {
+	h := make(http.Header)
+	for k, v := range header {
+		h.Set(k, v)
+	}
+	return &http.Response{StatusCode: status, Header: h}
+}
+
+func TestAdaptive_AIMD(t *testing.T) {
+	a := NewAdaptive(AdaptiveConfig{InitialRate: 10, MinRate: 1, MaxRate: 12, Increase: 1, Decrease: 0.5})
+
+	steps := []struct {
+		status int
+		want   float64
+	}{
+		{http.StatusOK, 11},
+		{http.StatusOK, 12},
+		{http.StatusOK, 12}, // capped at MaxRate
+		{http.StatusTooManyRequests, 6},
+		{http.StatusBadRequest, 6}, // client errors leave the rate alone
+		{529, 3},
+		{http.StatusServiceUnavailable, 1.5},
+		{http.StatusTooManyRequests, 1}, // floored at MinRate
+	}
+	for i, s := range steps {
+		a.Observe(response(s.status, nil))
+		if got := a.Rate(); got != s.want {
+			t.Errorf("step %d: after %d rate = %v, want %v", i, s.status, got, s.want)
+		}
+	}
+}
+
+func TestAdaptive_Pacing(t *testing.T) {
+	now := time.Unix(0, 0)
+	a := NewAdaptive(AdaptiveConfig{InitialRate: 2})
+	a.now = func() time.Time { return now }
+
+	want := []time.Duration{0, 500 * time.Millisecond, time.Second}
+	for i, w := range want {
+		if got := a.reserve(); got != w {
+			t.Errorf("reserve() #%d = %v, want %v", i, got, w)
+		}
+	}
+}
+
+func TestAdaptive_Pause(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		status int
+		header map[string]string
+		want   time.Duration
+	}{
+		{"retry-after seconds", 429, map[string]string{"Retry-After": "3"}, 3 * time.Second},
+		{"retry-after date", 429, map[string]string{"Retry-After": now.Add(5 * time.Second).Format(http.TimeFormat)}, 5 * time.Second},
+		{"openai exhausted", 200, map[string]string{
+			"X-Ratelimit-Remaining-Requests": "0",
+			"X-Ratelimit-Reset-Requests":     "6m0s",
+		}, 6 * time.Minute},
+		{"anthropic exhausted", 200, map[string]string{
+			"Anthropic-Ratelimit-Requests-Remaining": "0",
+			"Anthropic-Ratelimit-Requests-Reset":     now.Add(20 * time.Second).Format(time.RFC3339),
+		}, 20 * time.Second},
+		{"quota remaining", 200, map[string]string{
+			"X-Ratelimit-Remaining-Requests": "10",
+			"X-Ratelimit-Reset-Requests":     "6m0s",
+		}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAdaptive(AdaptiveConfig{InitialRate: 1000})
+			a.now = func() time.Time { return now }
+
+			a.Observe(response(tt.status, tt.header))
+			if got := a.Delay(); got != tt.want {
+				t.Errorf("Delay() after response = %v, want %v", got, tt.want)
+			}
+			if got := a.reserve(); got != tt.want {
+				t.Errorf("wait after response = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdaptive_Transport(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	a := NewAdaptive(AdaptiveConfig{InitialRate: 100})
+	client := &http.Client{Transport: a.Transport(nil)}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		resp.Body.Close()
+	}
+	if got := a.Rate(); got != 55 {
+		t.Errorf("rate after 429 then 200 = %v, want 55", got)
+	}
+
+	// A long pause is cut short by the request context
+	a.Observe(response(http.StatusTooManyRequests, map[string]string{"Retry-After": "60"}))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	if _, err := client.Do(req); err == nil {
+		t.Error("Do() during pause error = nil, want context error")
+	}
+	if calls != 2 {
+		t.Errorf("server called %d times, want 2", calls)
+	}
+}
@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// FromHeaders parses the rate limit state a provider reports in response
+// headers: OpenAI's x-ratelimit-* headers, whose resets are durations such
+// as "6m0s", or Anthropic's anthropic-ratelimit-* headers, whose resets are
+// RFC 3339 timestamps. It returns nil when the response reports none.
+func FromHeaders(h http.Header, now time.Time) *types.RateLimits {
+	l := types.RateLimits{RequestsLimit: -1, RequestsRemaining: -1, TokensLimit: -1, TokensRemaining: -1}
+	found := false
+	count := func(dst *int, name string) {
+		if n, err := strconv.Atoi(h.Get(name)); err == nil {
+			*dst = n
+			found = true
+		}
+	}
+	reset := func(dst *time.Time, name string) {
+		v := h.Get(name)
+		if v == "" {
+			return
+		}
+		if d, err := time.ParseDuration(v); err == nil {
+			*dst = now.Add(d)
+			found = true
+		} else if t, err := time.Parse(time.RFC3339, v); err == nil {
+			*dst = t
+			found = true
+		}
+	}
+
+	count(&l.RequestsLimit, "X-Ratelimit-Limit-Requests")
+	count(&l.RequestsRemaining, "X-Ratelimit-Remaining-Requests")
+	reset(&l.RequestsReset, "X-Ratelimit-Reset-Requests")
+	count(&l.TokensLimit, "X-Ratelimit-Limit-Tokens")
+	count(&l.TokensRemaining, "X-Ratelimit-Remaining-Tokens")
+	reset(&l.TokensReset, "X-Ratelimit-Reset-Tokens")
+	if !found {
+		count(&l.RequestsLimit, "Anthropic-Ratelimit-Requests-Limit")
+		count(&l.RequestsRemaining, "Anthropic-Ratelimit-Requests-Remaining")
+		reset(&l.RequestsReset, "Anthropic-Ratelimit-Requests-Reset")
+		count(&l.TokensLimit, "Anthropic-Ratelimit-Tokens-Limit")
+		count(&l.TokensRemaining, "Anthropic-Ratelimit-Tokens-Remaining")
+		reset(&l.TokensReset, "Anthropic-Ratelimit-Tokens-Reset")
+	}
+	if !found {
+		return nil
+	}
+	return &l
+}
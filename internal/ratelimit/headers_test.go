@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestFromHeaders(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   *types.RateLimits
+	}{
+		{
+			name: "openai",
+			header: map[string]string{
+				"x-ratelimit-limit-requests":     "500",
+				"x-ratelimit-remaining-requests": "499",
+				"x-ratelimit-reset-requests":     "120ms",
+				"x-ratelimit-limit-tokens":       "30000",
+				"x-ratelimit-remaining-tokens":   "29000",
+				"x-ratelimit-reset-tokens":       "2s",
+			},
+			want: &types.RateLimits{
+				RequestsLimit: 500, RequestsRemaining: 499, RequestsReset: now.Add(120 * time.Millisecond),
+				TokensLimit: 30000, TokensRemaining: 29000, TokensReset: now.Add(2 * time.Second),
+			},
+		},
+		{
+			name: "anthropic",
+			header: map[string]string{
+				"anthropic-ratelimit-requests-limit":     "50",
+				"anthropic-ratelimit-requests-remaining": "0",
+				"anthropic-ratelimit-requests-reset":     "2024-05-01T12:00:30Z",
+				"anthropic-ratelimit-tokens-remaining":   "8000",
+			},
+			want: &types.RateLimits{
+				RequestsLimit: 50, RequestsRemaining: 0, RequestsReset: now.Add(30 * time.Second),
+				TokensLimit: -1, TokensRemaining: 8000,
+			},
+		},
+		{
+			name:   "unparseable",
+			header: map[string]string{"x-ratelimit-remaining-requests": "many"},
+		},
+		{
+			name: "none",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FromHeaders(response(200, tt.header).Header, now)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FromHeaders() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
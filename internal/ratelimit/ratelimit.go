@@ -0,0 +1,260 @@
+// Package ratelimit paces requests to provider limits. A Limiter enforces
+// requests and tokens per minute with a token bucket or a sliding window.
+// Adaptive adjusts its rate from provider rate-limit headers. Shared
+// limiters are used by every client of one provider account.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLimited is returned when a fail-fast acquisition would have to wait
+var ErrLimited = errors.New("rate limited")
+
+// TokenBucket is a token bucket that refills continuously. Reservations may
+// drive the balance negative; later callers then wait for the debt to clear.
+type TokenBucket struct {
+	mu       sync.Mutex
+	capacity float64
+	tokens   float64
+	rate     float64 // tokens per second
+	last     time.Time
+	now      func() time.Time
+}
+
+// NewTokenBucket creates a full bucket holding capacity tokens that refills
+// at capacity tokens per period
+func NewTokenBucket(capacity int, period time.Duration) *TokenBucket {
+	return &TokenBucket{
+		capacity: float64(capacity),
+		tokens:   float64(capacity),
+		rate:     float64(capacity) / period.Seconds(),
+		last:     time.Now(),
+		now:      time.Now,
+	}
+}
+
+// refill adds tokens accrued since the last call. Callers must hold b.mu.
+func (b *TokenBucket) refill() {
+	now := b.now()
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+	b.tokens += elapsed * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+// clamp caps n at the bucket capacity so oversize requests can still proceed
+func (b *TokenBucket) clamp(n int) float64 {
+	if float64(n) > b.capacity {
+		return b.capacity
+	}
+	return float64(n)
+}
+
+// delay returns how long to wait before n tokens are available. Callers must
+// hold b.mu and have called refill.
+func (b *TokenBucket) delay(n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// lock, unlock, wait, take and give make TokenBucket a meter
+func (b *TokenBucket) lock()   { b.mu.Lock() }
+func (b *TokenBucket) unlock() { b.mu.Unlock() }
+
+func (b *TokenBucket) wait(n float64) time.Duration {
+	b.refill()
+	return b.delay(n)
+}
+
+func (b *TokenBucket) take(n float64, _ time.Duration) {
+	b.tokens -= n
+}
+
+func (b *TokenBucket) give(n float64) {
+	b.refill()
+	b.tokens += n
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+// meter is one dimension of a Limiter, requests or tokens. Callers must
+// hold the lock for every other method.
+type meter interface {
+	lock()
+	unlock()
+	// wait returns how long until n more units fit
+	wait(n float64) time.Duration
+	// take charges n units to a caller proceeding after wait
+	take(n float64, wait time.Duration)
+	// give returns n units; a negative n charges them now
+	give(n float64)
+	// clamp caps n at the meter's capacity
+	clamp(n int) float64
+}
+
+// pending returns how long a caller taking n units from m now would wait
+func pending(m meter, n float64) time.Duration {
+	m.lock()
+	defer m.unlock()
+	return m.wait(n)
+}
+
+// Algorithm selects how a Limiter counts requests and tokens
+type Algorithm int
+
+const (
+	// AlgorithmTokenBucket refills capacity continuously, allowing a burst
+	// of a full minute's capacity after an idle period
+	AlgorithmTokenBucket Algorithm = iota
+	// AlgorithmSlidingWindow allows at most a minute's capacity in any
+	// sixty seconds, matching providers that count usage that way
+	AlgorithmSlidingWindow
+)
+
+// Limiter enforces request and token rates together. A zero rate disables
+// that dimension.
+type Limiter struct {
+	requests meter
+	tokens   meter
+}
+
+// New creates a token bucket limiter allowing requestsPerMinute requests
+// and tokensPerMinute tokens per minute
+func New(requestsPerMinute, tokensPerMinute int) *Limiter {
+	return NewWithAlgorithm(AlgorithmTokenBucket, requestsPerMinute, tokensPerMinute)
+}
+
+// NewWithAlgorithm creates a limiter allowing requestsPerMinute requests and
+// tokensPerMinute tokens per minute, counted with the given algorithm
+func NewWithAlgorithm(algorithm Algorithm, requestsPerMinute, tokensPerMinute int) *Limiter {
+	newMeter := func(capacity int) meter {
+		if algorithm == AlgorithmSlidingWindow {
+			return NewSlidingWindow(capacity, time.Minute)
+		}
+		return NewTokenBucket(capacity, time.Minute)
+	}
+
+	l := &Limiter{}
+	if requestsPerMinute > 0 {
+		l.requests = newMeter(requestsPerMinute)
+	}
+	if tokensPerMinute > 0 {
+		l.tokens = newMeter(tokensPerMinute)
+	}
+	return l
+}
+
+// reserve takes one request and n tokens, returning how long the caller must
+// wait before proceeding. If failFast is set and a wait would be needed,
+// nothing is taken and ErrLimited is returned.
+func (l *Limiter) reserve(n int, failFast bool) (time.Duration, error) {
+	meters := make([]meter, 0, 2)
+	amounts := make([]float64, 0, 2)
+	if l.requests != nil {
+		meters = append(meters, l.requests)
+		amounts = append(amounts, 1)
+	}
+	if l.tokens != nil && n > 0 {
+		meters = append(meters, l.tokens)
+		amounts = append(amounts, l.tokens.clamp(n))
+	}
+
+	// Lock in a fixed order so concurrent reservations are atomic across meters
+	for _, m := range meters {
+		m.lock()
+		defer m.unlock()
+	}
+
+	var wait time.Duration
+	for i, m := range meters {
+		if d := m.wait(amounts[i]); d > wait {
+			wait = d
+		}
+	}
+
+	if failFast && wait > 0 {
+		return wait, ErrLimited
+	}
+
+	for i, m := range meters {
+		m.take(amounts[i], wait)
+	}
+	return wait, nil
+}
+
+// refund returns a reservation that was not used
+func (l *Limiter) refund(n int) {
+	if l.requests != nil {
+		l.requests.lock()
+		l.requests.give(1)
+		l.requests.unlock()
+	}
+	if l.tokens != nil && n > 0 {
+		l.tokens.lock()
+		l.tokens.give(l.tokens.clamp(n))
+		l.tokens.unlock()
+	}
+}
+
+// Delay returns how long a request made now would wait, without reserving
+// anything
+func (l *Limiter) Delay() time.Duration {
+	var wait time.Duration
+	if l.requests != nil {
+		wait = pending(l.requests, 1)
+	}
+	if l.tokens != nil {
+		// Token debt left by earlier reservations delays every request
+		if d := pending(l.tokens, 0); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// Settle corrects a completed request's token reservation to the tokens it
+// actually used. Unused tokens are returned; an overrun is charged so later
+// requests wait for it.
+func (l *Limiter) Settle(reserved, used int) {
+	if l.tokens == nil || used < 0 {
+		return
+	}
+	l.tokens.lock()
+	defer l.tokens.unlock()
+	l.tokens.give(l.tokens.clamp(reserved) - float64(used))
+}
+
+// Wait blocks until one request carrying n tokens may proceed, or ctx is done
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	wait, _ := l.reserve(n, false)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		l.refund(n)
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Allow takes one request carrying n tokens if it can proceed immediately.
+// It returns ErrLimited otherwise.
+func (l *Limiter) Allow(n int) error {
+	_, err := l.reserve(n, true)
+	return err
+}
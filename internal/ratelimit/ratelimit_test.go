@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiter_AllowRequests(t *testing.T) {
+	l := New(2, 0)
+
+	for i := 0; i < 2; i++ {
+		if err := l.Allow(0); err != nil {
+			t.Fatalf("Allow() #%d error = %v", i+1, err)
+		}
+	}
+	if err := l.Allow(0); !errors.Is(err, ErrLimited) {
+		t.Errorf("Allow() over limit error = %v, want %v", err, ErrLimited)
+	}
+}
+
+func TestLimiter_AllowTokens(t *testing.T) {
+	l := New(0, 100)
+
+	if err := l.Allow(80); err != nil {
+		t.Fatalf("Allow(80) error = %v", err)
+	}
+	if err := l.Allow(30); !errors.Is(err, ErrLimited) {
+		t.Errorf("Allow(30) error = %v, want %v", err, ErrLimited)
+	}
+	if err := l.Allow(20); err != nil {
+		t.Errorf("Allow(20) error = %v", err)
+	}
+}
+
+func TestLimiter_Settle(t *testing.T) {
+	tests := []struct {
+		name     string
+		reserved int
+		used     int
+		next     int
+		wantErr  error
+	}{
+		{"unused tokens returned", 80, 20, 80, nil},
+		{"overrun charged", 50, 90, 20, ErrLimited},
+		{"oversize reservation", 500, 40, 60, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(0, 100)
+			bucket := l.tokens.(*TokenBucket)
+			bucket.now = func() time.Time { return bucket.last }
+
+			if err := l.Allow(tt.reserved); err != nil {
+				t.Fatalf("Allow(%d) error = %v", tt.reserved, err)
+			}
+			l.Settle(tt.reserved, tt.used)
+			if err := l.Allow(tt.next); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Allow(%d) after settling error = %v, want %v", tt.next, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLimiter_Delay(t *testing.T) {
+	l := New(60, 100)
+	if d := l.Delay(); d != 0 {
+		t.Errorf("Delay() on full limiter = %v, want 0", d)
+	}
+
+	l.Allow(0)
+	// Overrunning the token budget leaves a debt of 50 tokens, half a minute
+	l.Settle(0, 150)
+	if d := l.Delay(); d < 29*time.Second || d > 30*time.Second {
+		t.Errorf("Delay() with token debt = %v, want ~30s", d)
+	}
+}
+
+func TestLimiter_FailFastTakesNothing(t *testing.T) {
+	l := New(10, 100)
+
+	if err := l.Allow(200); err != nil {
+		t.Fatalf("oversize Allow() error = %v", err)
+	}
+	// The token bucket is now empty but the request bucket should only have
+	// been charged for the successful call
+	if err := l.Allow(50); !errors.Is(err, ErrLimited) {
+		t.Fatalf("Allow(50) error = %v, want %v", err, ErrLimited)
+	}
+	bucket := l.requests.(*TokenBucket)
+	bucket.mu.Lock()
+	remaining := bucket.tokens
+	bucket.mu.Unlock()
+	if remaining < 8.9 || remaining > 9.1 {
+		t.Errorf("request bucket = %v, want ~9", remaining)
+	}
+}
+
+func TestLimiter_Wait(t *testing.T) {
+	// 600 per minute refills one request every 100ms
+	l := New(600, 0)
+	l.requests.(*TokenBucket).tokens = 0
+
+	start := time.Now()
+	if err := l.Wait(context.Background(), 0); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("Wait() returned after %v, want ~100ms", elapsed)
+	}
+}
+
+func TestLimiter_WaitCancelled(t *testing.T) {
+	l := New(1, 0)
+	l.requests.(*TokenBucket).tokens = 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := l.Wait(ctx, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+// Process-wide limiters shared by every client using the same provider
+// account. Keys are hashed so API keys are not held in the registry.
+var (
+	sharedMu       sync.Mutex
+	sharedLimiters = make(map[string]*Limiter)
+	sharedAdaptive = make(map[string]*Adaptive)
+)
+
+// SharedKey derives the registry key for a provider account
+func SharedKey(provider, apiKey string) string {
+	sum := sha256.Sum256([]byte(provider + "\x00" + apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// Shared returns the limiter registered under key, creating it with the
+// given algorithm and rates on first use. Later callers share the existing
+// limiter and its rates.
+func Shared(key string, algorithm Algorithm, requestsPerMinute, tokensPerMinute int) *Limiter {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+
+	l, ok := sharedLimiters[key]
+	if !ok {
+		l = NewWithAlgorithm(algorithm, requestsPerMinute, tokensPerMinute)
+		sharedLimiters[key] = l
+	}
+	return l
+}
+
+// SharedAdaptive returns the adaptive limiter registered under key, creating
+// it from cfg on first use
+func SharedAdaptive(key string, cfg AdaptiveConfig) *Adaptive {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+
+	a, ok := sharedAdaptive[key]
+	if !ok {
+		a = NewAdaptive(cfg)
+		sharedAdaptive[key] = a
+	}
+	return a
+}
@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShared(t *testing.T) {
+	key := SharedKey("openai", "sk-shared-test")
+	if strings.Contains(key, "sk-shared-test") {
+		t.Fatalf("SharedKey() = %q, contains the API key", key)
+	}
+
+	a := Shared(key, AlgorithmTokenBucket, 1, 0)
+	b := Shared(key, AlgorithmSlidingWindow, 100, 0)
+	if a != b {
+		t.Error("Shared() with the same key returned different limiters")
+	}
+	if err := a.Allow(0); err != nil {
+		t.Fatalf("first Allow() error = %v", err)
+	}
+	// The second client draws from the first client's budget and rates
+	if err := b.Allow(0); err != ErrLimited {
+		t.Errorf("Allow() on shared limiter error = %v, want %v", err, ErrLimited)
+	}
+
+	tests := []struct {
+		name     string
+		provider string
+		apiKey   string
+	}{
+		{"other key", "openai", "sk-other"},
+		{"other provider", "anthropic", "sk-shared-test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if Shared(SharedKey(tt.provider, tt.apiKey), AlgorithmTokenBucket, 1, 0) == a {
+				t.Error("Shared() returned the limiter of another account")
+			}
+		})
+	}
+}
+
+func TestSharedAdaptive(t *testing.T) {
+	key := SharedKey("anthropic", "sk-adaptive-test")
+	a := SharedAdaptive(key, AdaptiveConfig{InitialRate: 4})
+	b := SharedAdaptive(key, AdaptiveConfig{InitialRate: 40})
+	if a != b {
+		t.Fatal("SharedAdaptive() with the same key returned different limiters")
+	}
+	if got := b.Rate(); got != 4 {
+		t.Errorf("Rate() = %v, want the first registration's 4", got)
+	}
+}
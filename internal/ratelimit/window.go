@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SlidingWindow allows at most capacity units in any window of period. Unlike
+// a TokenBucket it never allows more than capacity in a period, even after
+// an idle spell. Units taken by a caller that must wait count from when the
+// wait ends.
+type SlidingWindow struct {
+	mu       sync.Mutex
+	capacity float64
+	period   time.Duration
+	entries  []windowEntry // ordered by at
+	now      func() time.Time
+}
+
+// windowEntry is n units counting from at
+type windowEntry struct {
+	at time.Time
+	n  float64
+}
+
+// NewSlidingWindow creates an empty window allowing capacity units per period
+func NewSlidingWindow(capacity int, period time.Duration) *SlidingWindow {
+	return &SlidingWindow{
+		capacity: float64(capacity),
+		period:   period,
+		now:      time.Now,
+	}
+}
+
+// prune drops entries that have left the window. Callers must hold w.mu.
+func (w *SlidingWindow) prune(now time.Time) {
+	i := 0
+	for i < len(w.entries) && !w.entries[i].at.Add(w.period).After(now) {
+		i++
+	}
+	w.entries = w.entries[i:]
+}
+
+func (w *SlidingWindow) lock()   { w.mu.Lock() }
+func (w *SlidingWindow) unlock() { w.mu.Unlock() }
+
+func (w *SlidingWindow) clamp(n int) float64 {
+	if float64(n) > w.capacity {
+		return w.capacity
+	}
+	return float64(n)
+}
+
+// wait returns how long until enough entries leave the window for n more
+// units to fit
+func (w *SlidingWindow) wait(n float64) time.Duration {
+	now := w.now()
+	w.prune(now)
+
+	var total float64
+	for _, e := range w.entries {
+		total += e.n
+	}
+	excess := total + n - w.capacity
+	if excess <= 0 {
+		return 0
+	}
+
+	var freed float64
+	for _, e := range w.entries {
+		freed += e.n
+		if freed >= excess {
+			return e.at.Add(w.period).Sub(now)
+		}
+	}
+	return w.entries[len(w.entries)-1].at.Add(w.period).Sub(now)
+}
+
+func (w *SlidingWindow) take(n float64, wait time.Duration) {
+	if n <= 0 {
+		return
+	}
+	w.insert(windowEntry{at: w.now().Add(wait), n: n})
+}
+
+// give returns n units from the most recent entries, or charges -n now
+func (w *SlidingWindow) give(n float64) {
+	if n < 0 {
+		w.insert(windowEntry{at: w.now(), n: -n})
+		return
+	}
+	for n > 0 && len(w.entries) > 0 {
+		last := &w.entries[len(w.entries)-1]
+		if last.n > n {
+			last.n -= n
+			return
+		}
+		n -= last.n
+		w.entries = w.entries[:len(w.entries)-1]
+	}
+}
+
+// insert adds e, keeping entries ordered. Callers must hold w.mu.
+func (w *SlidingWindow) insert(e windowEntry) {
+	i := sort.Search(len(w.entries), func(i int) bool { return w.entries[i].at.After(e.at) })
+	w.entries = append(w.entries, windowEntry{})
+	copy(w.entries[i+1:], w.entries[i:])
+	w.entries[i] = e
+}
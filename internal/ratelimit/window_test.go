@@ -0,0 +1,124 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable clock for sliding windows
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func slidingLimiter(clock *fakeClock, requestsPerMinute, tokensPerMinute int) *Limiter {
+	l := NewWithAlgorithm(AlgorithmSlidingWindow, requestsPerMinute, tokensPerMinute)
+	for _, m := range []meter{l.requests, l.tokens} {
+		if w, ok := m.(*SlidingWindow); ok {
+			w.now = clock.now
+		}
+	}
+	return l
+}
+
+func TestSlidingWindow_Allow(t *testing.T) {
+	tests := []struct {
+		name string
+		// since is the time since the first of two requests
+		since   time.Duration
+		wantErr error
+	}{
+		{name: "limit reached", since: 30 * time.Second, wantErr: ErrLimited},
+		{name: "first request still in window", since: 59 * time.Second, wantErr: ErrLimited},
+		{name: "first request left window", since: 60 * time.Second, wantErr: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			clock := &fakeClock{t: start}
+			l := slidingLimiter(clock, 2, 0)
+
+			l.Allow(0)
+			clock.t = start.Add(30 * time.Second)
+			l.Allow(0)
+
+			clock.t = start.Add(tt.since)
+			if err := l.Allow(0); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Allow() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSlidingWindow_NoIdleBurst(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	l := slidingLimiter(clock, 0, 100)
+
+	if err := l.Allow(100); err != nil {
+		t.Fatalf("Allow(100) error = %v", err)
+	}
+	// A token bucket would have refilled half its capacity by now
+	clock.t = clock.t.Add(30 * time.Second)
+	if err := l.Allow(50); !errors.Is(err, ErrLimited) {
+		t.Errorf("Allow(50) error = %v, want %v", err, ErrLimited)
+	}
+	if d := l.Delay(); d != 0 {
+		t.Errorf("Delay() = %v, want 0 with no token debt", d)
+	}
+	if d := pending(l.tokens, 50); d != 30*time.Second {
+		t.Errorf("wait for 50 tokens = %v, want 30s", d)
+	}
+}
+
+func TestSlidingWindow_Settle(t *testing.T) {
+	tests := []struct {
+		name     string
+		reserved int
+		used     int
+		next     int
+		wantErr  error
+	}{
+		{"unused tokens returned", 80, 20, 80, nil},
+		{"overrun charged", 50, 90, 20, ErrLimited},
+		{"oversize reservation", 500, 40, 60, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+			l := slidingLimiter(clock, 0, 100)
+
+			if err := l.Allow(tt.reserved); err != nil {
+				t.Fatalf("Allow(%d) error = %v", tt.reserved, err)
+			}
+			l.Settle(tt.reserved, tt.used)
+			if err := l.Allow(tt.next); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Allow(%d) after settling error = %v, want %v", tt.next, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSlidingWindow_WaitQueues(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	l := slidingLimiter(clock, 1, 0)
+
+	waits := make([]time.Duration, 3)
+	for i := range waits {
+		waits[i], _ = l.reserve(0, false)
+	}
+	// Each waiting request counts from when its wait ends, so the next
+	// queues a full window behind it
+	want := []time.Duration{0, time.Minute, 2 * time.Minute}
+	for i := range waits {
+		if waits[i] != want[i] {
+			t.Errorf("reservation %d wait = %v, want %v", i+1, waits[i], want[i])
+		}
+	}
+
+	l.refund(0)
+	if d, _ := l.reserve(0, false); d != 2*time.Minute {
+		t.Errorf("wait after refund = %v, want 2m", d)
+	}
+}
@@ -0,0 +1,148 @@
+// Package redact removes API keys and other credentials from text, headers
+// and errors before they are logged, returned to callers or reported to
+// metrics callbacks.
+package redact
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// Placeholder replaces each redacted secret
+const Placeholder = "[REDACTED]"
+
+// sensitiveKeys are header and attribute names whose values are always
+// redacted
+var sensitiveKeys = map[string]bool{
+	"api_key":             true,
+	"api-key":             true,
+	"apikey":              true,
+	"authorization":       true,
+	"proxy-authorization": true,
+	"x-api-key":           true,
+	"password":            true,
+	"secret":              true,
+	"client_secret":       true,
+	"access_token":        true,
+}
+
+// credentialPattern matches credentials that look like provider API keys or
+// bearer tokens, so they are redacted even when they are not configured,
+// such as a key echoed back in a provider's error message
+var credentialPattern = regexp.MustCompile(`(?i)\b(sk-[a-z0-9][a-z0-9_\-]{7,}|bearer\s+[a-z0-9._~+/\-]{8,}=*)`)
+
+// IsSensitive reports whether values named key, such as a header or log
+// attribute, are credentials
+func IsSensitive(key string) bool {
+	return sensitiveKeys[strings.ToLower(key)]
+}
+
+// String redacts anything in s that looks like an API key or bearer token
+func String(s string) string {
+	return credentialPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if strings.HasPrefix(strings.ToLower(match), "bearer") {
+			return "Bearer " + Placeholder
+		}
+		return Placeholder
+	})
+}
+
+// ProviderMessage returns the error message a provider sent with a response
+// of the given status, for a *types.ProviderError. Providers may echo the
+// key or token they were sent, so anything that looks like a credential is
+// redacted. An empty message becomes the status text.
+func ProviderMessage(message string, status int) string {
+	if message = String(message); message == "" {
+		return http.StatusText(status)
+	}
+	return message
+}
+
+// Redactor redacts a set of known secrets as well as anything that looks
+// like a credential. A nil *Redactor redacts only what looks like a
+// credential.
+type Redactor struct {
+	secrets []string
+}
+
+// New creates a Redactor for the given secrets. Empty secrets are ignored.
+func New(secrets ...string) *Redactor {
+	r := &Redactor{}
+	seen := make(map[string]bool)
+	for _, s := range secrets {
+		if s != "" && !seen[s] {
+			seen[s] = true
+			r.secrets = append(r.secrets, s)
+		}
+	}
+	// Longer secrets first, so a secret containing another is redacted whole
+	sort.Slice(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+	return r
+}
+
+// String returns s with every secret redacted
+func (r *Redactor) String(s string) string {
+	if r != nil {
+		for _, secret := range r.secrets {
+			s = strings.ReplaceAll(s, secret, Placeholder)
+		}
+	}
+	return String(s)
+}
+
+// Header returns a copy of h with the values of sensitive headers redacted
+func (r *Redactor) Header(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for name, values := range h {
+		redacted := make([]string, len(values))
+		for i, v := range values {
+			if IsSensitive(name) {
+				redacted[i] = Placeholder
+			} else {
+				redacted[i] = r.String(v)
+			}
+		}
+		out[name] = redacted
+	}
+	return out
+}
+
+// Error returns err with secrets removed from its message. Any
+// *types.ProviderError in the chain has its message redacted in place, and
+// the returned error still matches the same errors with errors.Is and
+// errors.As.
+func (r *Redactor) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var pe *types.ProviderError
+	if errors.As(err, &pe) {
+		pe.Message = r.String(pe.Message)
+	}
+
+	msg := err.Error()
+	if clean := r.String(msg); clean != msg {
+		return &redactedError{msg: clean, err: err}
+	}
+	return err
+}
+
+// redactedError is an error whose message has been redacted
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,64 @@
+package tokenizer
+
+import (
+	"unicode/utf8"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+const (
+	// charsPerToken approximates the BPE tokenizers used by OpenAI and
+	// Anthropic for English text
+	charsPerToken = 4
+
+	// messageOverhead covers the role and framing tokens added per message
+	messageOverhead = 4
+
+	// defaultCompletionTokens is assumed for requests that leave MaxTokens
+	// unset, since the provider may then generate a long completion
+	defaultCompletionTokens = 1024
+)
+
+// Count estimates the number of tokens in text. It is a fast heuristic, not
+// an exact BPE count, and errs on the high side for short strings.
+func Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	n := utf8.RuneCountInString(text)
+	return (n + charsPerToken - 1) / charsPerToken
+}
+
+// CountMessages estimates the prompt tokens for a list of messages
+func CountMessages(msgs []types.Message) int {
+	total := 0
+	for _, msg := range msgs {
+		total += messageOverhead + Count(msg.Content) + Count(msg.Name)
+	}
+	return total
+}
+
+// CountChat estimates the prompt tokens of a chat request, counting its
+// system prompts and messages
+func CountChat(req *types.ChatRequest) int {
+	return CountMessages(req.SystemMessages()) + CountMessages(req.Messages)
+}
+
+// EstimateChat estimates the total tokens a chat request may consume,
+// counting the prompt plus the requested completion budget
+func EstimateChat(req *types.ChatRequest) int {
+	return CountChat(req) + completionBudget(req.MaxTokens)
+}
+
+// EstimateCompletion estimates the total tokens a completion request may consume
+func EstimateCompletion(req *types.CompletionRequest) int {
+	return Count(req.Prompt) + completionBudget(req.MaxTokens)
+}
+
+// completionBudget returns the completion tokens to reserve for maxTokens
+func completionBudget(maxTokens int) int {
+	if maxTokens <= 0 {
+		return defaultCompletionTokens
+	}
+	return maxTokens
+}
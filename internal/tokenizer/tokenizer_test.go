@@ -0,0 +1,53 @@
+package tokenizer
+
+import (
+	"testing"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestCount(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hi", 1},
+		{"abcd", 1},
+		{"abcde", 2},
+		{"héllo wörld", 3},
+	}
+
+	for _, tt := range tests {
+		if got := Count(tt.text); got != tt.want {
+			t.Errorf("Count(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEstimateChat(t *testing.T) {
+	req := &types.ChatRequest{
+		Messages: []types.Message{
+			{Role: types.RoleSystem, Content: "Be brief."},
+			{Role: types.RoleUser, Content: "Hello there"},
+		},
+		MaxTokens: 100,
+	}
+
+	// (4 + 3) + (4 + 3) + 100
+	if got := EstimateChat(req); got != 114 {
+		t.Errorf("EstimateChat() = %d, want %d", got, 114)
+	}
+
+	// Without MaxTokens the default completion budget is reserved
+	req.MaxTokens = 0
+	if got := EstimateChat(req); got != 14+defaultCompletionTokens {
+		t.Errorf("EstimateChat() without MaxTokens = %d, want %d", got, 14+defaultCompletionTokens)
+	}
+
+	// System prompts count as the system messages they replace
+	req.System, req.Messages = []string{"Be brief."}, req.Messages[1:]
+	if got := EstimateChat(req); got != 14+defaultCompletionTokens {
+		t.Errorf("EstimateChat() with System = %d, want %d", got, 14+defaultCompletionTokens)
+	}
+}
@@ -1,24 +1,34 @@
 package anthropic
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/internal/auth"
+	"github.com/ksred/llm/internal/ratelimit"
+	"github.com/ksred/llm/internal/redact"
 	"github.com/ksred/llm/pkg/resource"
+	"github.com/ksred/llm/pkg/sse"
 	"github.com/ksred/llm/pkg/types"
 )
 
 const (
 	defaultBaseURL = "https://api.anthropic.com/v1/"
-	apiVersion     = "2023-06-01" // Latest stable version as of now
+	// defaultAPIVersion is sent unless the config pins another version
+	defaultAPIVersion = "2023-06-01"
+
+	// defaultMaxTokens is sent when a request leaves MaxTokens unset, as
+	// the Messages API requires it
+	defaultMaxTokens = 1024
 )
 
 // Provider implements the Provider interface for Anthropic
@@ -45,12 +55,18 @@ func NewProvider(cfg *config.Config) (*Provider, error) {
 		}
 	}
 
-	pool := resource.NewConnectionPool(cfg.PoolConfig, "anthropic", cfg.Metrics)
-	httpClient, err := pool.Get(context.Background())
-	if err != nil {
-		return nil, fmt.Errorf("getting client from pool: %w", err)
+	// Pooled clients use the transport of a configured HTTP client, so
+	// requests can be routed through proxies or recorded in tests
+	poolConfig := *cfg.PoolConfig
+	if cfg.HTTPClient != nil && cfg.HTTPClient.Transport != nil && poolConfig.Transport == nil {
+		poolConfig.Transport = cfg.HTTPClient.Transport
 	}
-	client := resource.NewRetryableClient(httpClient, cfg.RetryConfig, "anthropic", cfg.Metrics)
+	// Requests are authenticated in the transport, so each retry is too
+	poolConfig.Transport = auth.Transport(auth.FromConfig(cfg, func(key string) auth.Authenticator { return auth.APIKeyHeader("X-Api-Key", key) }), poolConfig.Transport)
+
+	pool := resource.NewConnectionPool(&poolConfig, "anthropic", cfg.Metrics)
+	client := resource.NewPooledRetryableClient(pool, cfg.RetryConfig, "anthropic", cfg.Metrics)
+	client.SetErrorDecoder(decodeError)
 
 	retryConfig := cfg.RetryConfig
 	if retryConfig == nil {
@@ -71,66 +87,33 @@ func NewProvider(cfg *config.Config) (*Provider, error) {
 	}, nil
 }
 
-// Complete generates a completion for the given prompt
+// Complete generates a completion for the given prompt. Anthropic's legacy
+// text completions endpoint does not serve current models, so the prompt is
+// sent to the Messages API as a single user message.
 func (p *Provider) Complete(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
-	body := map[string]interface{}{
-		"model":      p.config.Model,
-		"prompt":     req.Prompt,
-		"max_tokens": req.MaxTokens,
-		"stream":     false,
-	}
-
-	var resp anthropicCompletionResponse
-	if err := p.doRequest(ctx, "POST", "/complete", body, &resp); err != nil {
+	resp, err := p.Chat(ctx, toChatRequest(req))
+	if err != nil {
 		return nil, err
 	}
-
-	// Convert to CompletionResponse
-	content := ""
-	for _, c := range resp.Content {
-		if c.Type == "text" {
-			content += c.Text
-		}
-	}
-
-	return &types.CompletionResponse{
-		Response: types.Response{
-			ID:       resp.ID,
-			Provider: "anthropic",
-			Model:    resp.Model,
-			Message: types.Message{
-				Role:    types.RoleAssistant,
-				Content: content,
-			},
-			StopReason: resp.StopReason,
-			Usage: types.Usage{
-				PromptTokens:     resp.Usage.InputTokens,
-				CompletionTokens: resp.Usage.OutputTokens,
-			},
-		},
-	}, nil
+	return &types.CompletionResponse{Response: resp.Response}, nil
 }
 
-// StreamComplete streams a completion for the given prompt
+// StreamComplete streams a completion for the given prompt through the
+// Messages API
 func (p *Provider) StreamComplete(ctx context.Context, req *types.CompletionRequest) (<-chan *types.CompletionResponse, error) {
-	body := map[string]interface{}{
-		"model":      p.config.Model,
-		"prompt":     req.Prompt,
-		"max_tokens": req.MaxTokens,
-		"stream":     true,
-	}
-
-	ch := make(chan *types.CompletionResponse)
-	streamCh, err := p.streamRequest(ctx, "/complete", body)
+	streamCh, err := p.StreamChat(ctx, toChatRequest(req))
 	if err != nil {
 		return nil, err
 	}
 
+	ch := make(chan *types.CompletionResponse)
 	go func() {
 		defer close(ch)
 		for resp := range streamCh {
-			ch <- &types.CompletionResponse{
-				Response: resp.Response,
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- &types.CompletionResponse{Response: resp.Response}:
 			}
 		}
 	}()
@@ -141,33 +124,33 @@ func (p *Provider) StreamComplete(ctx context.Context, req *types.CompletionRequ
 // Chat generates a chat completion for the given messages
 func (p *Provider) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
 	// Convert messages to Anthropic format
-	var systemMessage string
-	userMessages := make([]map[string]string, 0, len(req.Messages))
-	for _, msg := range req.Messages {
-		if msg.Role == types.RoleSystem {
-			systemMessage = msg.Content
-			continue
-		}
-		userMessages = append(userMessages, map[string]string{
-			"role":    string(msg.Role),
-			"content": msg.Content,
-		})
-	}
+	system, userMessages := toAnthropicMessages(append(req.SystemMessages(), req.Messages...))
 
 	body := map[string]interface{}{
 		"model":      p.config.Model,
 		"messages":   userMessages,
-		"max_tokens": req.MaxTokens,
+		"max_tokens": maxTokens(req.MaxTokens),
 		"stream":     false,
 	}
 
-	if systemMessage != "" {
-		body["system"] = systemMessage
+	if s := toAnthropicSystem(system); s != nil {
+		body["system"] = s
+	}
+	if req.User != "" {
+		// Anthropic attributes abuse reports to metadata.user_id, as OpenAI
+		// does to user
+		body["metadata"] = map[string]interface{}{"user_id": req.User}
+	}
+	if len(req.Tools) > 0 {
+		body["tools"] = toAnthropicTools(req.Tools)
 	}
+	setSampling(body, req.Temperature, req.TopP)
+	mergeProviderParams(body, req.ProviderParams)
 
 	var resp anthropicCompletionResponse
-	if err := p.doRequest(ctx, "POST", "/messages", body, &resp); err != nil {
-		return nil, fmt.Errorf("provider error: %w", err)
+	header, err := p.doRequest(ctx, "POST", "/messages", body, &resp)
+	if err != nil {
+		return nil, err
 	}
 
 	// Convert to ChatResponse
@@ -184,14 +167,18 @@ func (p *Provider) Chat(ctx context.Context, req *types.ChatRequest) (*types.Cha
 			Provider: "anthropic",
 			Model:    resp.Model,
 			Message: types.Message{
-				Role:    types.RoleAssistant,
-				Content: content,
+				Role:      types.RoleAssistant,
+				Content:   content,
+				ToolCalls: resp.toolCalls(),
 			},
-			StopReason: resp.StopReason,
+			FinishReason:    toFinishReason(resp.StopReason),
+			RawFinishReason: resp.StopReason,
 			Usage: types.Usage{
 				PromptTokens:     resp.Usage.InputTokens,
 				CompletionTokens: resp.Usage.OutputTokens,
+				TotalTokens:      resp.Usage.InputTokens + resp.Usage.OutputTokens,
 			},
+			RateLimits: ratelimit.FromHeaders(header, time.Now()),
 		},
 	}, nil
 }
@@ -199,75 +186,318 @@ func (p *Provider) Chat(ctx context.Context, req *types.ChatRequest) (*types.Cha
 // StreamChat streams a chat completion for the given messages
 func (p *Provider) StreamChat(ctx context.Context, req *types.ChatRequest) (<-chan *types.ChatResponse, error) {
 	// Convert messages to Anthropic format
-	var systemMessage string
-	userMessages := make([]map[string]string, 0, len(req.Messages))
-	for _, msg := range req.Messages {
-		if msg.Role == types.RoleSystem {
-			systemMessage = msg.Content
-			continue
-		}
-		userMessages = append(userMessages, map[string]string{
-			"role":    string(msg.Role),
-			"content": msg.Content,
-		})
-	}
+	system, userMessages := toAnthropicMessages(append(req.SystemMessages(), req.Messages...))
 
 	body := map[string]interface{}{
 		"model":      p.config.Model,
 		"messages":   userMessages,
-		"max_tokens": req.MaxTokens,
+		"max_tokens": maxTokens(req.MaxTokens),
 		"stream":     true,
 	}
 
-	if systemMessage != "" {
-		body["system"] = systemMessage
+	if s := toAnthropicSystem(system); s != nil {
+		body["system"] = s
+	}
+	if req.User != "" {
+		// Anthropic attributes abuse reports to metadata.user_id, as OpenAI
+		// does to user
+		body["metadata"] = map[string]interface{}{"user_id": req.User}
 	}
+	if len(req.Tools) > 0 {
+		body["tools"] = toAnthropicTools(req.Tools)
+	}
+	setSampling(body, req.Temperature, req.TopP)
+	mergeProviderParams(body, req.ProviderParams)
 
 	return p.streamRequest(ctx, "/messages", body)
 }
 
-func (p *Provider) doRequest(ctx context.Context, method, path string, body interface{}, v interface{}) error {
+// toAnthropicMessages splits out the system prompts and converts the remaining
+// messages to the Anthropic format. Tool calls become tool_use content blocks,
+// and tool and function results are sent as tool_result blocks on a user turn,
+// as the Messages API requires. Consecutive results share a single turn.
+func toAnthropicMessages(msgs []types.Message) ([]string, []map[string]interface{}) {
+	var system []string
+	messages := make([]map[string]interface{}, 0, len(msgs))
+	for _, msg := range msgs {
+		switch msg.Role {
+		case types.RoleSystem:
+			if msg.Content != "" {
+				system = append(system, msg.Content)
+			}
+		case types.RoleTool, types.RoleFunction:
+			toolUseID := msg.ToolCallID
+			if toolUseID == "" {
+				toolUseID = msg.Name
+			}
+			block := map[string]interface{}{
+				"type":        "tool_result",
+				"tool_use_id": toolUseID,
+				"content":     msg.Content,
+			}
+
+			if n := len(messages); n > 0 && messages[n-1]["role"] == string(types.RoleUser) {
+				if blocks, ok := messages[n-1]["content"].([]map[string]interface{}); ok {
+					messages[n-1]["content"] = append(blocks, block)
+					continue
+				}
+			}
+			messages = append(messages, map[string]interface{}{
+				"role":    string(types.RoleUser),
+				"content": []map[string]interface{}{block},
+			})
+		case types.RoleAssistant:
+			if len(msg.ToolCalls) == 0 {
+				messages = append(messages, map[string]interface{}{
+					"role":    string(msg.Role),
+					"content": msg.Content,
+				})
+				continue
+			}
+
+			var blocks []map[string]interface{}
+			if msg.Content != "" {
+				blocks = append(blocks, map[string]interface{}{
+					"type": "text",
+					"text": msg.Content,
+				})
+			}
+			for _, call := range msg.ToolCalls {
+				input := json.RawMessage(call.Arguments)
+				if len(input) == 0 {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, map[string]interface{}{
+					"type":  "tool_use",
+					"id":    call.ID,
+					"name":  call.Name,
+					"input": input,
+				})
+			}
+			messages = append(messages, map[string]interface{}{
+				"role":    string(msg.Role),
+				"content": blocks,
+			})
+		default:
+			messages = append(messages, map[string]interface{}{
+				"role":    string(msg.Role),
+				"content": msg.Content,
+			})
+		}
+	}
+	return system, messages
+}
+
+// toAnthropicSystem returns the top-level system field for system prompts:
+// nil for none, a string for one, and text blocks for several
+func toAnthropicSystem(system []string) interface{} {
+	switch len(system) {
+	case 0:
+		return nil
+	case 1:
+		return system[0]
+	}
+	blocks := make([]map[string]interface{}, len(system))
+	for i, text := range system {
+		blocks[i] = map[string]interface{}{"type": "text", "text": text}
+	}
+	return blocks
+}
+
+// toAnthropicTools converts tool definitions to the Anthropic format
+func toAnthropicTools(tools []types.Tool) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(tools))
+	for _, tool := range tools {
+		schema := tool.Parameters
+		if schema == nil {
+			schema = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		t := map[string]interface{}{
+			"name":         tool.Name,
+			"input_schema": schema,
+		}
+		if tool.Description != "" {
+			t["description"] = tool.Description
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// Close releases the provider's connection pool and stops its background work
+func (p *Provider) Close() error {
+	return p.pool.Shutdown()
+}
+
+// Warmup opens connections to the API ahead of the first request. With
+// ping it also lists the available models, which checks the API key
+// without incurring any cost.
+func (p *Provider) Warmup(ctx context.Context, connections int, ping bool) error {
+	if err := p.pool.Warmup(ctx, p.baseURL, connections); err != nil {
+		return err
+	}
+	if !ping {
+		return nil
+	}
+	var models json.RawMessage
+	_, err := p.doRequest(ctx, "GET", "/models", nil, &models)
+	return err
+}
+
+// PoolStats reports the state of the provider's connection pool
+func (p *Provider) PoolStats() resource.PoolStats {
+	return p.pool.Stats()
+}
+
+// setSampling adds the sampling parameters the request sets to the body.
+// Unset ones are left out, so the provider's defaults apply.
+func setSampling(body map[string]interface{}, temperature, topP *float32) {
+	if temperature != nil {
+		body["temperature"] = *temperature
+	}
+	if topP != nil {
+		body["top_p"] = *topP
+	}
+}
+
+// mergeProviderParams copies caller-supplied provider parameters into the request body
+func mergeProviderParams(body map[string]interface{}, params map[string]any) {
+	for k, v := range params {
+		body[k] = v
+	}
+}
+
+// decodeError converts an Anthropic error response into a
+// *types.ProviderError wrapping the common error for its status code
+func decodeError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	var apiErr anthropicError
+	code, message := "", strings.TrimSpace(string(body))
+	if err := json.Unmarshal(body, &apiErr); err == nil {
+		code = apiErr.Err.Type
+		if code == "" {
+			code = apiErr.Type
+		}
+		if m := apiErr.Error(); m != "unknown error" {
+			message = m
+		}
+	}
+	return newProviderError(resp.StatusCode, resp.Header, code, message)
+}
+
+// streamError converts an error event, sent after the stream has started
+// with a 200 status, into a ProviderError. StatusCode is the status the API
+// gives the same error type outside a stream.
+func streamError(apiErr *anthropicError, header http.Header) error {
+	code, message := apiErr.Err.Type, apiErr.Error()
+	if message == "unknown error" {
+		message = ""
+	}
+	return newProviderError(statusForErrorType(code), header, code, message)
+}
+
+func newProviderError(status int, header http.Header, code, message string) error {
+	message = redact.ProviderMessage(message, status)
+
+	return &types.ProviderError{
+		Provider:   "anthropic",
+		Code:       code,
+		Message:    message,
+		StatusCode: status,
+		RequestID:  resource.RequestID(header),
+		Err:        types.ErrorForStatus(status, code, message),
+	}
+}
+
+// statusForErrorType returns the HTTP status Anthropic documents for an
+// error type
+func statusForErrorType(errType string) int {
+	switch errType {
+	case "invalid_request_error":
+		return http.StatusBadRequest
+	case "authentication_error":
+		return http.StatusUnauthorized
+	case "permission_error":
+		return http.StatusForbidden
+	case "not_found_error":
+		return http.StatusNotFound
+	case "request_too_large":
+		return http.StatusRequestEntityTooLarge
+	case "rate_limit_error":
+		return http.StatusTooManyRequests
+	case "overloaded_error":
+		return 529
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// requestError wraps the error of a request that got no usable response. A
+// provider error, left by exhausted retries, is returned as-is.
+func requestError(err error) error {
+	var providerErr *types.ProviderError
+	if errors.As(err, &providerErr) {
+		return err
+	}
+	return fmt.Errorf("making request: %w", err)
+}
+
+// doRequest sends a request and decodes the JSON response into v, returning
+// the response headers
+func (p *Provider) doRequest(ctx context.Context, method, path string, body interface{}, v interface{}) (http.Header, error) {
 	var bodyReader io.Reader
 	if body != nil {
 		bodyBytes, err := json.Marshal(body)
 		if err != nil {
-			return fmt.Errorf("marshaling request body: %w", err)
+			return nil, fmt.Errorf("marshaling request body: %w", err)
 		}
 		bodyReader = bytes.NewReader(bodyBytes)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, bodyReader)
 	if err != nil {
-		return fmt.Errorf("creating request: %w", err)
+		return nil, fmt.Errorf("creating request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-API-Key", p.config.APIKey)
-	req.Header.Set("anthropic-version", apiVersion)
+	p.setHeaders(req)
 
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("making request: %w", err)
+		return nil, requestError(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		var apiErr anthropicError
-		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
-			return fmt.Errorf("request failed with status %d: %w", resp.StatusCode, err)
-		}
-		return &types.ProviderError{
-			Provider: "anthropic",
-			Code:     apiErr.Type,
-			Message:  apiErr.Message,
-			Err:      nil,
-		}
+		return nil, decodeError(resp)
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
-		return fmt.Errorf("decoding response: %w", err)
+		return nil, fmt.Errorf("decoding response: %w", err)
 	}
 
-	return nil
+	return resp.Header, nil
+}
+
+// setHeaders sets the API version and beta feature headers
+func (p *Provider) setHeaders(req *http.Request) {
+	version := p.config.AnthropicVersion
+	if version == "" {
+		version = p.config.APIVersion
+	}
+	if version == "" {
+		version = defaultAPIVersion
+	}
+	req.Header.Set("anthropic-version", version)
+	if len(p.config.AnthropicBetas) > 0 {
+		req.Header.Set("anthropic-beta", strings.Join(p.config.AnthropicBetas, ","))
+	}
+}
+
+// toolBlock is a tool_use content block being streamed
+type toolBlock struct {
+	id    string
+	input bool // whether any input has been streamed
 }
 
 // streamRequest handles streaming responses from the Anthropic API
@@ -283,78 +513,195 @@ func (p *Provider) streamRequest(ctx context.Context, path string, body interfac
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-API-Key", p.config.APIKey)
-	req.Header.Set("anthropic-version", apiVersion)
 	req.Header.Set("Accept", "text/event-stream")
+	p.setHeaders(req)
 
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("making request: %w", err)
+		return nil, requestError(err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		var apiErr types.ProviderError
-		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
-			return nil, fmt.Errorf("request failed with status %d", resp.StatusCode)
-		}
-		return nil, &apiErr
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
 	}
 
+	// The first chunk carries the rate limits reported with the stream
+	limits := ratelimit.FromHeaders(resp.Header, time.Now())
 	responseChan := make(chan *types.ChatResponse)
 
 	go func() {
 		defer resp.Body.Close()
 		defer close(responseChan)
 
-		scanner := bufio.NewScanner(resp.Body)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if !strings.HasPrefix(line, "data: ") {
-				continue
+		// Closing the body on cancellation ends a read blocked waiting for
+		// the next event
+		stop := context.AfterFunc(ctx, func() { resp.Body.Close() })
+		defer stop()
+
+		send := func(resp *types.ChatResponse) bool {
+			resp.RateLimits, limits = limits, nil
+			select {
+			case <-ctx.Done():
+				return false
+			case responseChan <- resp:
+				return true
 			}
+		}
 
-			// Remove "data: " prefix
-			data := strings.TrimPrefix(line, "data: ")
+		// A stream with no event for the idle timeout is abandoned
+		var idle *time.Timer
+		var idleExpired atomic.Bool
+		timeout := p.config.StreamIdleTimeout
+		if timeout > 0 {
+			idle = time.AfterFunc(timeout, func() {
+				idleExpired.Store(true)
+				resp.Body.Close()
+			})
+			defer idle.Stop()
+		}
+
+		// The message_start event names the message every chunk belongs to
+		var msg anthropicStreamMessage
+		chatChunk := func(m types.Message) *types.ChatResponse {
+			m.Role = types.RoleAssistant
+			return &types.ChatResponse{Response: types.Response{
+				ID:       msg.ID,
+				Provider: "anthropic",
+				Model:    msg.Model,
+				Message:  m,
+			}}
+		}
+		// Tool use blocks being streamed, by content block index
+		tools := make(map[int]*toolBlock)
+
+		events := sse.NewReader(resp.Body,
+			sse.WithBufferSize(p.config.StreamBufferSize),
+			sse.WithMaxEventSize(p.config.MaxStreamEventSize))
+		for {
+			event, err := events.Next()
+			if err != nil {
+				if idleExpired.Load() {
+					err = fmt.Errorf("no event for %v: %w", timeout, types.ErrTimeout)
+				}
+				if err != io.EOF && ctx.Err() == nil {
+					send(&types.ChatResponse{
+						Response: types.Response{
+							Error: fmt.Errorf("error reading stream: %w", err),
+						},
+					})
+				}
+				return
+			}
+
+			if idle != nil {
+				idle.Reset(timeout)
+			}
+
+			data := event.Data
 			if data == "[DONE]" {
 				return
 			}
 
 			var streamResp anthropicStreamResponse
 			if err := json.Unmarshal([]byte(data), &streamResp); err != nil {
-				responseChan <- &types.ChatResponse{
+				send(&types.ChatResponse{
 					Response: types.Response{
 						Error: fmt.Errorf("error decoding stream: %w", err),
 					},
-				}
+				})
 				return
 			}
 
-			// Convert stream response to ChatResponse
-			if streamResp.Type == "message_start" || streamResp.Type == "message_delta" {
-				continue
+			// The event line names the event; the payload's type repeats it
+			eventType := event.Type
+			if eventType == "" {
+				eventType = streamResp.Type
 			}
 
-			if streamResp.Type == "content_block_delta" || streamResp.Type == "content_block_start" {
-				content := streamResp.Delta.Text
-				if content != "" {
-					responseChan <- &types.ChatResponse{
-						Response: types.Response{
-							Message: types.Message{
-								Role:    types.RoleAssistant,
-								Content: content,
-							},
-						},
+			switch eventType {
+			case "message_start":
+				msg = streamResp.Message
+			case "content_block_start":
+				block := streamResp.ContentBlock
+				var chunk types.Message
+				switch block.Type {
+				case "tool_use":
+					tools[streamResp.Index] = &toolBlock{id: block.ID}
+					chunk.ToolCalls = []types.ToolCall{{ID: block.ID, Name: block.Name}}
+				case "text":
+					chunk.Content = block.Text
+				}
+				if chunk.Content == "" && len(chunk.ToolCalls) == 0 {
+					continue
+				}
+				if !send(chatChunk(chunk)) {
+					return
+				}
+			case "content_block_delta":
+				var chunk types.Message
+				switch delta := streamResp.Delta; delta.Type {
+				case "input_json_delta":
+					tool := tools[streamResp.Index]
+					if tool == nil || delta.PartialJSON == "" {
+						continue
 					}
+					tool.input = true
+					chunk.ToolCalls = []types.ToolCall{{ID: tool.id, Arguments: delta.PartialJSON}}
+				default:
+					chunk.Content = delta.Text
 				}
-			}
-		}
-
-		if err := scanner.Err(); err != nil {
-			responseChan <- &types.ChatResponse{
-				Response: types.Response{
-					Error: fmt.Errorf("error reading stream: %w", err),
-				},
+				if chunk.Content == "" && len(chunk.ToolCalls) == 0 {
+					continue
+				}
+				if !send(chatChunk(chunk)) {
+					return
+				}
+			case "content_block_stop":
+				// A tool called without input streams none, so it is given
+				// the empty object Chat reports
+				tool := tools[streamResp.Index]
+				delete(tools, streamResp.Index)
+				if tool == nil || tool.input {
+					continue
+				}
+				if !send(chatChunk(types.Message{ToolCalls: []types.ToolCall{{ID: tool.id, Arguments: "{}"}}})) {
+					return
+				}
+			case "message_delta":
+				// Output tokens are cumulative, and input tokens are only
+				// repeated by newer API versions
+				usage := streamResp.Usage
+				if usage.InputTokens == 0 {
+					usage.InputTokens = msg.Usage.InputTokens
+				}
+				reason := streamResp.Delta.StopReason
+				chunk := chatChunk(types.Message{})
+				chunk.FinishReason = toFinishReason(reason)
+				chunk.RawFinishReason = reason
+				chunk.Usage = types.Usage{
+					PromptTokens:     usage.InputTokens,
+					CompletionTokens: usage.OutputTokens,
+					TotalTokens:      usage.InputTokens + usage.OutputTokens,
+				}
+				if !send(chunk) {
+					return
+				}
+			case "ping":
+				// Pings only keep the connection alive, which has reset the
+				// idle timer
+			case "error":
+				var apiErr anthropicError
+				_ = json.Unmarshal([]byte(data), &apiErr)
+				send(&types.ChatResponse{
+					Response: types.Response{
+						Error: streamError(&apiErr, resp.Header),
+					},
+				})
+				return
+			case "message_stop":
+				// The message is complete; nothing follows
+				return
 			}
 		}
 	}()
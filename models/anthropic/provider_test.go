@@ -3,85 +3,135 @@ package anthropic
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/ksred/llm/config"
 	"github.com/ksred/llm/pkg/resource"
+	"github.com/ksred/llm/pkg/sse"
+	"github.com/ksred/llm/pkg/testutil"
 	"github.com/ksred/llm/pkg/types"
 )
 
 func TestProvider_Complete(t *testing.T) {
+	var gotPath string
+	var gotBody map[string]interface{}
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("X-API-Key") != "test-key" {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+		gotPath = r.URL.Path
+		gotBody = nil
+		json.NewDecoder(r.Body).Decode(&gotBody)
 
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"id":          "test-id",
-			"completion":  "Hello",
+			"type":        "message",
+			"role":        "assistant",
+			"content":     []map[string]interface{}{{"type": "text", "text": "Hello"}},
 			"model":       "claude-2",
-			"stop_reason": "stop",
+			"stop_reason": "end_turn",
+			"usage":       map[string]interface{}{"input_tokens": 3, "output_tokens": 1},
 		})
 	}))
 	defer server.Close()
 
 	tests := []struct {
-		name    string
-		config  *config.Config
-		request *types.CompletionRequest
-		want    *types.CompletionResponse
-		wantErr bool
+		name          string
+		request       *types.CompletionRequest
+		wantMaxTokens float64
 	}{
 		{
-			name: "valid request",
-			config: &config.Config{
-				Provider: "anthropic",
-				Model:    "claude-2",
-				APIKey:   "test-key",
-				BaseURL:  server.URL,
-			},
-			request: &types.CompletionRequest{
-				Prompt: "Hello",
-			},
-			want: &types.CompletionResponse{
-				Response: types.Response{
-					ID:       "test-id",
-					Provider: "anthropic",
-					Model:    "claude-2",
-					Message: types.Message{
-						Role:    "assistant",
-						Content: "Hello",
-					},
-				},
-			},
-			wantErr: false,
+			name:          "default max tokens",
+			request:       &types.CompletionRequest{Prompt: "Hello"},
+			wantMaxTokens: defaultMaxTokens,
+		},
+		{
+			name:          "max tokens",
+			request:       &types.CompletionRequest{Prompt: "Hello", MaxTokens: 50},
+			wantMaxTokens: 50,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p, err := NewProvider(tt.config)
+			p, err := NewProvider(&config.Config{
+				Provider: "anthropic",
+				Model:    "claude-2",
+				APIKey:   "test-key",
+				BaseURL:  server.URL,
+			})
 			if err != nil {
 				t.Fatalf("NewProvider() error = %v", err)
 			}
 			got, err := p.Complete(context.Background(), tt.request)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Provider.Complete() error = %v, wantErr %v", err, tt.wantErr)
-				return
+			if err != nil {
+				t.Fatalf("Provider.Complete() error = %v", err)
 			}
-			if !tt.wantErr && got.Message.Role != tt.want.Message.Role {
-				t.Errorf("Provider.Complete() Role = %v, want %v", got.Message.Role, tt.want.Message.Role)
+
+			if gotPath != "/messages" {
+				t.Errorf("request path = %q, want /messages", gotPath)
+			}
+			wantMessages := []interface{}{map[string]interface{}{"role": "user", "content": tt.request.Prompt}}
+			if !reflect.DeepEqual(gotBody["messages"], wantMessages) {
+				t.Errorf("messages = %v, want %v", gotBody["messages"], wantMessages)
+			}
+			if gotBody["max_tokens"] != tt.wantMaxTokens {
+				t.Errorf("max_tokens = %v, want %v", gotBody["max_tokens"], tt.wantMaxTokens)
+			}
+			if _, ok := gotBody["prompt"]; ok {
+				t.Error("request sent the legacy prompt field")
+			}
+
+			if got.Message.Role != types.RoleAssistant || got.Message.Content != "Hello" {
+				t.Errorf("Message = %+v, want assistant %q", got.Message, "Hello")
+			}
+			if got.FinishReason != types.FinishReasonStop || got.Usage.CompletionTokens != 1 {
+				t.Errorf("FinishReason, CompletionTokens = %v, %d, want stop, 1", got.FinishReason, got.Usage.CompletionTokens)
 			}
 		})
 	}
 }
 
+func TestProvider_StreamComplete(t *testing.T) {
+	server := testutil.NewStreamServer(testutil.AnthropicStream("Hello", " world")...)
+	defer server.Close()
+
+	p, err := NewProvider(&config.Config{APIKey: "test-key", Model: "claude-3-haiku-20240307", BaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+	defer p.Close()
+
+	stream, err := p.StreamComplete(context.Background(), &types.CompletionRequest{Prompt: "Hi"})
+	if err != nil {
+		t.Fatalf("StreamComplete() error = %v", err)
+	}
+	var content string
+	for resp := range stream {
+		if resp.Error != nil {
+			t.Fatalf("stream error = %v", resp.Error)
+		}
+		content += resp.Message.Content
+	}
+	if content != "Hello world" {
+		t.Errorf("content = %q, want %q", content, "Hello world")
+	}
+
+	var body map[string]interface{}
+	json.Unmarshal(server.Requests()[0], &body)
+	if body["stream"] != true || body["messages"] == nil || body["prompt"] != nil {
+		t.Errorf("request body = %v, want a streamed Messages request", body)
+	}
+}
+
 func TestProvider_Chat(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("X-API-Key") != "test-key" {
@@ -191,24 +241,21 @@ func TestProvider_StreamChat(t *testing.T) {
 				w.Header().Set("Content-Type", "text/event-stream")
 				w.WriteHeader(http.StatusOK)
 
-				responses := []map[string]interface{}{
-					{
-						"type":    "content",
-						"content": "Hello",
-					},
-					{
-						"type":    "content",
-						"content": " world",
-					},
-					{
-						"type":    "content",
-						"content": "!",
-					},
+				events := []map[string]interface{}{
+					{"type": "message_start", "message": map[string]interface{}{"id": "msg_1", "role": "assistant"}},
+					{"type": "content_block_start", "index": 0, "content_block": map[string]interface{}{"type": "text", "text": ""}},
+					{"type": "ping"},
+					{"type": "content_block_delta", "index": 0, "delta": map[string]interface{}{"type": "text_delta", "text": "Hello"}},
+					{"type": "content_block_delta", "index": 0, "delta": map[string]interface{}{"type": "text_delta", "text": " world"}},
+					{"type": "content_block_delta", "index": 0, "delta": map[string]interface{}{"type": "text_delta", "text": "!"}},
+					{"type": "content_block_stop", "index": 0},
+					{"type": "message_delta", "delta": map[string]interface{}{"stop_reason": "end_turn"}},
+					{"type": "message_stop"},
 				}
 
-				for _, resp := range responses {
-					data, _ := json.Marshal(resp)
-					fmt.Fprintf(w, "%s\n", data)
+				for _, event := range events {
+					data, _ := json.Marshal(event)
+					fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event["type"], data)
 					w.(http.Flusher).Flush()
 					time.Sleep(10 * time.Millisecond)
 				}
@@ -231,18 +278,137 @@ func TestProvider_StreamChat(t *testing.T) {
 			}
 
 			var messages []string
+			var finish types.FinishReason
 			for resp := range stream {
 				if resp.Error != nil {
 					t.Errorf("StreamChat() error in response: %v", resp.Error)
 					continue
 				}
-				messages = append(messages, resp.Message.Content)
+				// The final chunk carries the stop reason and no content
+				if resp.FinishReason != "" {
+					finish = resp.FinishReason
+				}
+				if resp.Message.Content != "" {
+					messages = append(messages, resp.Message.Content)
+				}
 			}
 
 			want := []string{"Hello", " world", "!"}
 			if !reflect.DeepEqual(messages, want) {
 				t.Errorf("StreamChat() got messages = %v, want %v", messages, want)
 			}
+			if finish != types.FinishReasonStop {
+				t.Errorf("StreamChat() finish reason = %q, want %q", finish, types.FinishReasonStop)
+			}
+		})
+	}
+}
+
+func TestProvider_StreamChatAccumulated(t *testing.T) {
+	toolStream := []testutil.Event{
+		testutil.AnthropicEvent("message_start", map[string]any{
+			"message": map[string]any{
+				"id":    "msg_tools",
+				"model": "claude-3-5-sonnet-20241022",
+				"usage": map[string]any{"input_tokens": 25, "output_tokens": 1},
+			},
+		}),
+		testutil.AnthropicEvent("content_block_start", map[string]any{
+			"index":         0,
+			"content_block": map[string]any{"type": "text", "text": ""},
+		}),
+		testutil.AnthropicDelta("Checking."),
+		testutil.AnthropicEvent("content_block_stop", map[string]any{"index": 0}),
+		testutil.AnthropicEvent("content_block_start", map[string]any{
+			"index":         1,
+			"content_block": map[string]any{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": map[string]any{}},
+		}),
+		testutil.AnthropicEvent("content_block_delta", map[string]any{
+			"index": 1,
+			"delta": map[string]any{"type": "input_json_delta", "partial_json": `{"city":`},
+		}),
+		testutil.AnthropicEvent("content_block_delta", map[string]any{
+			"index": 1,
+			"delta": map[string]any{"type": "input_json_delta", "partial_json": ` "Paris"}`},
+		}),
+		testutil.AnthropicEvent("content_block_stop", map[string]any{"index": 1}),
+		testutil.AnthropicEvent("content_block_start", map[string]any{
+			"index":         2,
+			"content_block": map[string]any{"type": "tool_use", "id": "toolu_2", "name": "get_time", "input": map[string]any{}},
+		}),
+		testutil.AnthropicEvent("content_block_stop", map[string]any{"index": 2}),
+		testutil.AnthropicEvent("message_delta", map[string]any{
+			"delta": map[string]any{"stop_reason": "tool_use", "stop_sequence": nil},
+			"usage": map[string]any{"output_tokens": 40},
+		}),
+		testutil.AnthropicEvent("message_stop", nil),
+	}
+
+	tests := []struct {
+		name       string
+		events     []testutil.Event
+		want       types.Message
+		wantFinish types.FinishReason
+		wantUsage  types.Usage
+	}{
+		{
+			name:       "text",
+			events:     testutil.AnthropicStream("Hel", "lo"),
+			want:       types.Message{Role: types.RoleAssistant, Content: "Hello"},
+			wantFinish: types.FinishReasonStop,
+			wantUsage:  types.Usage{PromptTokens: 10, CompletionTokens: 2, TotalTokens: 12},
+		},
+		{
+			name:   "tool calls",
+			events: toolStream,
+			want: types.Message{Role: types.RoleAssistant, Content: "Checking.", ToolCalls: []types.ToolCall{
+				{ID: "toolu_1", Name: "get_weather", Arguments: `{"city": "Paris"}`},
+				{ID: "toolu_2", Name: "get_time", Arguments: "{}"},
+			}},
+			wantFinish: types.FinishReasonToolCalls,
+			wantUsage:  types.Usage{PromptTokens: 25, CompletionTokens: 40, TotalTokens: 65},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := testutil.NewStreamServer(tt.events...)
+			defer server.Close()
+
+			p, err := NewProvider(&config.Config{APIKey: "test-key", Model: "claude-3-5-sonnet-20241022", BaseURL: server.URL})
+			if err != nil {
+				t.Fatalf("NewProvider() error = %v", err)
+			}
+			defer p.Close()
+
+			stream, err := p.StreamChat(context.Background(), &types.ChatRequest{
+				Messages: []types.Message{{Role: types.RoleUser, Content: "Hi"}},
+			})
+			if err != nil {
+				t.Fatalf("StreamChat() error = %v", err)
+			}
+
+			var acc types.Accumulator
+			for chunk := range stream {
+				acc.Add(chunk)
+			}
+			if err := acc.Err(); err != nil {
+				t.Fatalf("stream error = %v", err)
+			}
+
+			resp := acc.Response()
+			if !reflect.DeepEqual(resp.Message, tt.want) {
+				t.Errorf("message = %+v, want %+v", resp.Message, tt.want)
+			}
+			if resp.FinishReason != tt.wantFinish {
+				t.Errorf("finish reason = %q, want %q", resp.FinishReason, tt.wantFinish)
+			}
+			if resp.Usage != tt.wantUsage {
+				t.Errorf("usage = %+v, want %+v", resp.Usage, tt.wantUsage)
+			}
+			if resp.ID == "" || resp.Provider != "anthropic" {
+				t.Errorf("response ID %q and provider %q, want both set", resp.ID, resp.Provider)
+			}
 		})
 	}
 }
@@ -367,3 +533,744 @@ func TestProvider_RetryableClient_Retry(t *testing.T) {
 		t.Error("Complete() expected error after max retries")
 	}
 }
+
+func TestProvider_ProviderParams(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request body: %v", err)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":          "test-id",
+			"model":       "claude-2",
+			"stop_reason": "end_turn",
+		})
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(&config.Config{
+		Provider: "anthropic",
+		Model:    "claude-2",
+		APIKey:   "test-key",
+		BaseURL:  server.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+
+	_, err = p.Chat(context.Background(), &types.ChatRequest{
+		Messages: []types.Message{
+			{
+				Role:    "user",
+				Content: "Hello",
+			},
+		},
+		ProviderParams: map[string]any{
+			"top_k": 5,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	if got["top_k"] != float64(5) {
+		t.Errorf("request body top_k = %v, want %v", got["top_k"], 5)
+	}
+}
+
+func TestToAnthropicMessages(t *testing.T) {
+	system, messages := toAnthropicMessages([]types.Message{
+		{Role: types.RoleSystem, Content: "Be brief."},
+		{Role: types.RoleUser, Content: "What's the weather?"},
+		{Role: types.RoleTool, Content: "21C", ToolCallID: "toolu_1"},
+	})
+
+	if !reflect.DeepEqual(system, []string{"Be brief."}) {
+		t.Errorf("system = %q, want %q", system, "Be brief.")
+	}
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, want 2", len(messages))
+	}
+
+	toolMsg := messages[1]
+	if toolMsg["role"] != "user" {
+		t.Errorf("tool result role = %v, want user", toolMsg["role"])
+	}
+	blocks, ok := toolMsg["content"].([]map[string]interface{})
+	if !ok || len(blocks) != 1 {
+		t.Fatalf("tool result content = %#v, want one content block", toolMsg["content"])
+	}
+	if blocks[0]["type"] != "tool_result" || blocks[0]["tool_use_id"] != "toolu_1" {
+		t.Errorf("tool result block = %v", blocks[0])
+	}
+}
+
+func TestToAnthropicMessages_ToolCalls(t *testing.T) {
+	_, messages := toAnthropicMessages([]types.Message{
+		{Role: types.RoleUser, Content: "Weather in London and Paris?"},
+		{Role: types.RoleAssistant, Content: "Checking.", ToolCalls: []types.ToolCall{
+			{ID: "toolu_1", Name: "get_weather", Arguments: `{"city":"London"}`},
+			{ID: "toolu_2", Name: "get_weather", Arguments: `{"city":"Paris"}`},
+		}},
+		{Role: types.RoleTool, Content: "12C", ToolCallID: "toolu_1"},
+		{Role: types.RoleTool, Content: "18C", ToolCallID: "toolu_2"},
+	})
+
+	if len(messages) != 3 {
+		t.Fatalf("got %d messages, want 3", len(messages))
+	}
+
+	blocks := messages[1]["content"].([]map[string]interface{})
+	if len(blocks) != 3 || blocks[0]["type"] != "text" || blocks[1]["type"] != "tool_use" || blocks[2]["id"] != "toolu_2" {
+		t.Errorf("assistant content = %v", blocks)
+	}
+
+	results := messages[2]["content"].([]map[string]interface{})
+	if len(results) != 2 || results[1]["tool_use_id"] != "toolu_2" {
+		t.Errorf("tool results = %v, want both results in one turn", results)
+	}
+}
+
+func TestProvider_RateLimits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("anthropic-ratelimit-requests-remaining", "41")
+		if r.Header.Get("Accept") == "text/event-stream" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"text\":\"Hel\"}}\n\n"+
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"text\":\"lo\"}}\n\n"+
+				"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": "test-id", "stop_reason": "end_turn"})
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(&config.Config{
+		Provider: "anthropic",
+		Model:    "claude-3-haiku-20240307",
+		APIKey:   "test-key",
+		BaseURL:  server.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+
+	ctx := context.Background()
+	req := &types.ChatRequest{Messages: []types.Message{types.UserMessage("Hello")}}
+	resp, err := p.Chat(ctx, req)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if resp.RateLimits == nil || resp.RateLimits.RequestsRemaining != 41 || resp.RateLimits.TokensRemaining != -1 {
+		t.Errorf("Chat() RateLimits = %+v, want 41 requests remaining", resp.RateLimits)
+	}
+
+	stream, err := p.StreamChat(ctx, req)
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	var chunks []*types.ChatResponse
+	for chunk := range stream {
+		chunks = append(chunks, chunk)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("StreamChat() sent %d chunks, want at least 2", len(chunks))
+	}
+	if chunks[0].RateLimits == nil || chunks[0].RateLimits.RequestsRemaining != 41 {
+		t.Errorf("first chunk RateLimits = %+v, want 41 requests remaining", chunks[0].RateLimits)
+	}
+	if chunks[1].RateLimits != nil {
+		t.Errorf("second chunk RateLimits = %+v, want nil", chunks[1].RateLimits)
+	}
+}
+
+func TestProvider_SamplingParams(t *testing.T) {
+	tests := []struct {
+		name            string
+		temperature     *float32
+		topP            *float32
+		wantTemperature any
+		wantTopP        any
+	}{
+		{name: "unset", wantTemperature: nil, wantTopP: nil},
+		{name: "zero temperature", temperature: types.Float32(0), wantTemperature: float64(0), wantTopP: nil},
+		{name: "both set", temperature: types.Float32(0.5), topP: types.Float32(0.25), wantTemperature: 0.5, wantTopP: 0.25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Errorf("decoding request body: %v", err)
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"id":          "test-id",
+					"model":       "claude-3-haiku-20240307",
+					"stop_reason": "end_turn",
+				})
+			}))
+			defer server.Close()
+
+			p, err := NewProvider(&config.Config{
+				Provider: "anthropic",
+				Model:    "claude-3-haiku-20240307",
+				APIKey:   "test-key",
+				BaseURL:  server.URL,
+			})
+			if err != nil {
+				t.Fatalf("NewProvider() error = %v", err)
+			}
+
+			_, err = p.Chat(context.Background(), &types.ChatRequest{
+				Messages:    []types.Message{types.UserMessage("Hello")},
+				Temperature: tt.temperature,
+				TopP:        tt.topP,
+			})
+			if err != nil {
+				t.Fatalf("Chat() error = %v", err)
+			}
+
+			temperature, hasTemperature := got["temperature"]
+			if hasTemperature != (tt.wantTemperature != nil) || temperature != tt.wantTemperature {
+				t.Errorf("request body temperature = %v (sent %v), want %v", temperature, hasTemperature, tt.wantTemperature)
+			}
+			topP, hasTopP := got["top_p"]
+			if hasTopP != (tt.wantTopP != nil) || topP != tt.wantTopP {
+				t.Errorf("request body top_p = %v (sent %v), want %v", topP, hasTopP, tt.wantTopP)
+			}
+		})
+	}
+}
+
+func TestProvider_System(t *testing.T) {
+	tests := []struct {
+		name     string
+		system   []string
+		messages []types.Message
+		want     any
+	}{
+		{name: "none", want: nil},
+		{name: "field", system: []string{"Be brief."}, want: "Be brief."},
+		{name: "message", messages: []types.Message{types.SystemMessage("Be brief.")}, want: "Be brief."},
+		{
+			name:     "several blocks",
+			system:   []string{"Be brief.", "Answer in French."},
+			messages: []types.Message{types.SystemMessage("Cite sources.")},
+			want: []any{
+				map[string]any{"type": "text", "text": "Be brief."},
+				map[string]any{"type": "text", "text": "Answer in French."},
+				map[string]any{"type": "text", "text": "Cite sources."},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Errorf("decoding request body: %v", err)
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"id":          "test-id",
+					"model":       "claude-3-haiku-20240307",
+					"stop_reason": "end_turn",
+				})
+			}))
+			defer server.Close()
+
+			p, err := NewProvider(&config.Config{
+				Provider: "anthropic",
+				Model:    "claude-3-haiku-20240307",
+				APIKey:   "test-key",
+				BaseURL:  server.URL,
+			})
+			if err != nil {
+				t.Fatalf("NewProvider() error = %v", err)
+			}
+
+			_, err = p.Chat(context.Background(), &types.ChatRequest{
+				System:   tt.system,
+				Messages: append(tt.messages, types.UserMessage("Hello")),
+			})
+			if err != nil {
+				t.Fatalf("Chat() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got["system"], tt.want) {
+				t.Errorf("request body system = %#v, want %#v", got["system"], tt.want)
+			}
+			if messages, _ := got["messages"].([]any); len(messages) != 1 {
+				t.Errorf("request body messages = %v, want only the user message", got["messages"])
+			}
+		})
+	}
+}
+
+func TestProvider_User(t *testing.T) {
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decoding request body: %v", err)
+		}
+		bodies = append(bodies, body)
+		if body["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":          "test-id",
+			"model":       "claude-3-haiku-20240307",
+			"stop_reason": "end_turn",
+		})
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(&config.Config{
+		Provider: "anthropic",
+		Model:    "claude-3-haiku-20240307",
+		APIKey:   "test-key",
+		BaseURL:  server.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+
+	ctx := context.Background()
+	req := &types.ChatRequest{Messages: []types.Message{types.UserMessage("Hello")}, User: "user-42"}
+	if _, err := p.Chat(ctx, req); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	stream, err := p.StreamChat(ctx, req)
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	for range stream {
+	}
+	req.User = ""
+	if _, err := p.Chat(ctx, req); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	want := map[string]interface{}{"user_id": "user-42"}
+	for i, body := range bodies[:2] {
+		if !reflect.DeepEqual(body["metadata"], want) {
+			t.Errorf("request %d metadata = %v, want %v", i, body["metadata"], want)
+		}
+	}
+	if _, ok := bodies[2]["metadata"]; ok {
+		t.Errorf("request without a user sent metadata %v", bodies[2]["metadata"])
+	}
+}
+
+func TestProvider_Headers(t *testing.T) {
+	tests := []struct {
+		name             string
+		betas            []string
+		version          string
+		anthropicVersion string
+		want             string
+		wantVersion      string
+	}{
+		{name: "defaults", wantVersion: defaultAPIVersion},
+		{name: "one beta", betas: []string{"prompt-caching-2024-07-31"}, want: "prompt-caching-2024-07-31", wantVersion: defaultAPIVersion},
+		{name: "several betas", betas: []string{"prompt-caching-2024-07-31", "token-efficient-tools-2025-02-19"}, want: "prompt-caching-2024-07-31,token-efficient-tools-2025-02-19", wantVersion: defaultAPIVersion},
+		{name: "pinned version", version: "2024-01-01", wantVersion: "2024-01-01"},
+		{name: "anthropic version first", version: "2024-01-01", anthropicVersion: "2024-02-01", wantVersion: "2024-02-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers []http.Header
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				headers = append(headers, r.Header.Clone())
+				if r.Header.Get("Accept") == "text/event-stream" {
+					w.Header().Set("Content-Type", "text/event-stream")
+					fmt.Fprint(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{"id": "test-id", "stop_reason": "end_turn"})
+			}))
+			defer server.Close()
+
+			p, err := NewProvider(&config.Config{
+				Provider:         "anthropic",
+				Model:            "claude-3-haiku-20240307",
+				APIKey:           "test-key",
+				BaseURL:          server.URL,
+				AnthropicBetas:   tt.betas,
+				APIVersion:       tt.version,
+				AnthropicVersion: tt.anthropicVersion,
+			})
+			if err != nil {
+				t.Fatalf("NewProvider() error = %v", err)
+			}
+
+			ctx := context.Background()
+			req := &types.ChatRequest{Messages: []types.Message{types.UserMessage("Hello")}}
+			if _, err := p.Chat(ctx, req); err != nil {
+				t.Fatalf("Chat() error = %v", err)
+			}
+			stream, err := p.StreamChat(ctx, req)
+			if err != nil {
+				t.Fatalf("StreamChat() error = %v", err)
+			}
+			for range stream {
+			}
+
+			for i, h := range headers {
+				if h.Get("anthropic-beta") != tt.want || h.Get("anthropic-version") != tt.wantVersion {
+					t.Errorf("request %d anthropic-beta = %q, anthropic-version = %q, want %q and %q", i, h.Get("anthropic-beta"), h.Get("anthropic-version"), tt.want, tt.wantVersion)
+				}
+			}
+		})
+	}
+}
+
+func TestProvider_ChatTools(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request body: %v", err)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":          "test-id",
+			"model":       "claude-2",
+			"stop_reason": "tool_use",
+			"content": []map[string]interface{}{
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": map[string]string{"city": "Paris"}},
+			},
+		})
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(&config.Config{
+		Provider: "anthropic",
+		Model:    "claude-2",
+		APIKey:   "test-key",
+		BaseURL:  server.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+
+	resp, err := p.Chat(context.Background(), &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Weather in Paris?"}},
+		Tools:    []types.Tool{{Name: "get_weather"}},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	tools, _ := got["tools"].([]interface{})
+	if len(tools) != 1 || tools[0].(map[string]interface{})["input_schema"] == nil {
+		t.Errorf("request tools = %v", got["tools"])
+	}
+
+	if len(resp.Message.ToolCalls) != 1 {
+		t.Fatalf("ToolCalls = %+v, want 1 call", resp.Message.ToolCalls)
+	}
+	call := resp.Message.ToolCalls[0]
+	if call.ID != "toolu_1" || call.Name != "get_weather" || call.Arguments != `{"city":"Paris"}` {
+		t.Errorf("ToolCall = %+v", call)
+	}
+	if resp.FinishReason != types.FinishReasonToolCalls {
+		t.Errorf("FinishReason = %q, want %q", resp.FinishReason, types.FinishReasonToolCalls)
+	}
+}
+
+func TestProvider_ErrorMapping(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantErr  error
+		wantCode string
+	}{
+		{"context length", http.StatusBadRequest, `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 250000 tokens > 200000 maximum"}}`, types.ErrContextTooLong, "invalid_request_error"},
+		{"invalid credentials", http.StatusUnauthorized, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`, types.ErrInvalidCredentials, "authentication_error"},
+		{"rate limited", http.StatusTooManyRequests, `{"type":"error","error":{"type":"rate_limit_error","message":"Number of request tokens has exceeded your per-minute rate limit"}}`, types.ErrRateLimitExceeded, "rate_limit_error"},
+		{"overloaded", 529, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`, types.ErrOverloaded, "overloaded_error"},
+		{"server error", http.StatusInternalServerError, `{"type":"error","error":{"type":"api_error","message":"Internal server error"}}`, types.ErrProviderError, "api_error"},
+		{"no error body", 529, ``, types.ErrOverloaded, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Request-Id", "req_123")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			p, err := NewProvider(&config.Config{
+				Provider:    "anthropic",
+				Model:       "claude-2",
+				APIKey:      "test-key",
+				BaseURL:     server.URL,
+				RetryConfig: &resource.RetryConfig{MaxRetries: 0},
+			})
+			if err != nil {
+				t.Fatalf("NewProvider() error = %v", err)
+			}
+
+			req := &types.ChatRequest{Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}}}
+			_, chatErr := p.Chat(context.Background(), req)
+			_, streamErr := p.StreamChat(context.Background(), req)
+
+			for name, err := range map[string]error{"Chat": chatErr, "StreamChat": streamErr} {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("%s() error = %v, want %v", name, err, tt.wantErr)
+				}
+				provErr, ok := err.(*types.ProviderError)
+				if !ok {
+					t.Fatalf("%s() error = %T, want *types.ProviderError", name, err)
+				}
+				if provErr.StatusCode != tt.status || provErr.RequestID != "req_123" || provErr.Code != tt.wantCode {
+					t.Errorf("%s() ProviderError = %+v", name, provErr)
+				}
+			}
+		})
+	}
+}
+
+func TestToFinishReason(t *testing.T) {
+	tests := map[string]types.FinishReason{
+		"":              "",
+		"end_turn":      types.FinishReasonStop,
+		"stop_sequence": types.FinishReasonStop,
+		"max_tokens":    types.FinishReasonMaxTokens,
+		"tool_use":      types.FinishReasonToolCalls,
+		"something_new": types.FinishReasonUnknown,
+	}
+
+	for raw, want := range tests {
+		if got := toFinishReason(raw); got != want {
+			t.Errorf("toFinishReason(%q) = %v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestProvider_StreamChatCancel(t *testing.T) {
+	tests := []struct {
+		name string
+		// readFirst reads the first chunk before cancelling, so the stream
+		// is blocked reading the next event rather than sending
+		readFirst bool
+	}{
+		{name: "waiting for event", readFirst: true},
+		{name: "waiting for reader", readFirst: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The second event never arrives while the test runs
+			server := testutil.NewStreamServer(testutil.AnthropicDelta("Hel"), testutil.Slow(time.Minute, testutil.AnthropicDelta("Hel"))[0])
+			defer server.Close()
+
+			p, err := NewProvider(&config.Config{APIKey: "test-key", Model: "claude-3-haiku-20240307", BaseURL: server.URL})
+			if err != nil {
+				t.Fatalf("NewProvider() error = %v", err)
+			}
+			defer p.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stream, err := p.StreamChat(ctx, &types.ChatRequest{
+				Messages:  []types.Message{{Role: types.RoleUser, Content: "Hi"}},
+				MaxTokens: 10,
+			})
+			if err != nil {
+				t.Fatalf("StreamChat() error = %v", err)
+			}
+			if tt.readFirst {
+				<-stream
+			} else {
+				// Give the stream time to block sending the first chunk
+				time.Sleep(20 * time.Millisecond)
+			}
+
+			start := time.Now()
+			cancel()
+			done := make(chan struct{})
+			go func() {
+				for range stream {
+				}
+				close(done)
+			}()
+			select {
+			case <-done:
+				if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+					t.Errorf("stream ended %v after cancellation, want within 100ms", elapsed)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("stream did not end after cancellation")
+			}
+		})
+	}
+}
+
+func TestProvider_StreamChatLargeEvent(t *testing.T) {
+	large := strings.Repeat("x", 200<<10)
+
+	tests := []struct {
+		name         string
+		maxEventSize int
+		wantContent  string
+		wantErr      error
+	}{
+		{name: "event over 64KB", wantContent: large},
+		{name: "event over limit", maxEventSize: 100 << 10, wantErr: sse.ErrEventTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := testutil.NewStreamServer(testutil.AnthropicStream(large)...)
+			defer server.Close()
+
+			p, err := NewProvider(&config.Config{
+				APIKey:             "test-key",
+				Model:              "claude-3-haiku-20240307",
+				BaseURL:            server.URL,
+				StreamBufferSize:   4096,
+				MaxStreamEventSize: tt.maxEventSize,
+			})
+			if err != nil {
+				t.Fatalf("NewProvider() error = %v", err)
+			}
+			defer p.Close()
+
+			stream, err := p.StreamChat(context.Background(), &types.ChatRequest{
+				Messages: []types.Message{{Role: types.RoleUser, Content: "Hi"}},
+			})
+			if err != nil {
+				t.Fatalf("StreamChat() error = %v", err)
+			}
+
+			var content strings.Builder
+			var streamErr error
+			for resp := range stream {
+				if resp.Error != nil {
+					streamErr = resp.Error
+					continue
+				}
+				content.WriteString(resp.Message.Content)
+			}
+			if !errors.Is(streamErr, tt.wantErr) {
+				t.Fatalf("stream error = %v, want %v", streamErr, tt.wantErr)
+			}
+			if content.String() != tt.wantContent {
+				t.Errorf("got %d bytes of content, want %d", content.Len(), len(tt.wantContent))
+			}
+		})
+	}
+}
+
+func TestProvider_StreamChatEvents(t *testing.T) {
+	tests := []struct {
+		name        string
+		events      []testutil.Event
+		idleTimeout time.Duration
+		wantContent string
+		wantErr     error
+		wantCode    string
+		wantStatus  int
+	}{
+		{
+			name:        "error event",
+			events:      []testutil.Event{testutil.AnthropicDelta("Hel"), testutil.AnthropicError("overloaded_error", "Overloaded")},
+			wantContent: "Hel",
+			wantErr:     types.ErrOverloaded,
+			wantCode:    "overloaded_error",
+			wantStatus:  529,
+		},
+		{
+			name:       "rate limit error event",
+			events:     []testutil.Event{testutil.AnthropicError("rate_limit_error", "Too many requests")},
+			wantErr:    types.ErrRateLimitExceeded,
+			wantCode:   "rate_limit_error",
+			wantStatus: http.StatusTooManyRequests,
+		},
+		{
+			name: "pings reset idle timeout",
+			events: append(append([]testutil.Event{testutil.AnthropicDelta("Hel")},
+				testutil.Slow(60*time.Millisecond, testutil.AnthropicPing(), testutil.AnthropicPing(), testutil.AnthropicPing())...),
+				testutil.AnthropicStream("lo")...),
+			idleTimeout: 100 * time.Millisecond,
+			wantContent: "Hello",
+		},
+		{
+			name:        "idle timeout",
+			events:      []testutil.Event{testutil.AnthropicDelta("Hel"), testutil.Slow(time.Minute, testutil.AnthropicDelta("lo"))[0]},
+			idleTimeout: 50 * time.Millisecond,
+			wantContent: "Hel",
+			wantErr:     types.ErrTimeout,
+		},
+		{
+			name:        "message_stop ends stream",
+			events:      append(testutil.AnthropicStream("Hi"), testutil.AnthropicDelta(" after stop")),
+			wantContent: "Hi",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := testutil.NewStreamServer(tt.events...)
+			defer server.Close()
+
+			p, err := NewProvider(&config.Config{
+				APIKey:            "test-key",
+				Model:             "claude-3-haiku-20240307",
+				BaseURL:           server.URL,
+				StreamIdleTimeout: tt.idleTimeout,
+			})
+			if err != nil {
+				t.Fatalf("NewProvider() error = %v", err)
+			}
+			defer p.Close()
+
+			stream, err := p.StreamChat(context.Background(), &types.ChatRequest{
+				Messages: []types.Message{{Role: types.RoleUser, Content: "Hi"}},
+			})
+			if err != nil {
+				t.Fatalf("StreamChat() error = %v", err)
+			}
+
+			var content strings.Builder
+			var errs []error
+			for resp := range stream {
+				if resp.Error != nil {
+					errs = append(errs, resp.Error)
+					continue
+				}
+				content.WriteString(resp.Message.Content)
+			}
+			if content.String() != tt.wantContent {
+				t.Errorf("content = %q, want %q", content.String(), tt.wantContent)
+			}
+			if tt.wantErr == nil {
+				if len(errs) != 0 {
+					t.Errorf("stream errors = %v, want none", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !errors.Is(errs[0], tt.wantErr) {
+				t.Fatalf("stream errors = %v, want one %v", errs, tt.wantErr)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			var provErr *types.ProviderError
+			if !errors.As(errs[0], &provErr) {
+				t.Fatalf("stream error = %T, want *types.ProviderError", errs[0])
+			}
+			if provErr.Code != tt.wantCode || provErr.StatusCode != tt.wantStatus {
+				t.Errorf("error code, status = %q, %d, want %q, %d", provErr.Code, provErr.StatusCode, tt.wantCode, tt.wantStatus)
+			}
+		})
+	}
+}
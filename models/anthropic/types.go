@@ -1,7 +1,7 @@
 package anthropic
 
 import (
-	"time"
+	"encoding/json"
 
 	"github.com/ksred/llm/pkg/types"
 )
@@ -12,8 +12,11 @@ type anthropicCompletionResponse struct {
 	Type    string `json:"type"`
 	Role    string `json:"role"`
 	Content []struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
+		Type  string          `json:"type"`
+		Text  string          `json:"text"`
+		ID    string          `json:"id"`
+		Name  string          `json:"name"`
+		Input json.RawMessage `json:"input"`
 	} `json:"content"`
 	Model        string `json:"model"`
 	StopReason   string `json:"stop_reason"`
@@ -24,32 +27,64 @@ type anthropicCompletionResponse struct {
 	} `json:"usage"`
 }
 
-// toResponse converts an Anthropic completion response to a generic CompletionResponse
-func (r *anthropicCompletionResponse) toResponse() *types.CompletionResponse {
-	content := ""
+// toolCalls returns the tool_use content blocks as tool calls
+func (r *anthropicCompletionResponse) toolCalls() []types.ToolCall {
+	var calls []types.ToolCall
 	for _, c := range r.Content {
-		if c.Type == "text" {
-			content += c.Text
+		if c.Type != "tool_use" {
+			continue
+		}
+		args := string(c.Input)
+		if args == "" {
+			args = "{}"
 		}
+		calls = append(calls, types.ToolCall{ID: c.ID, Name: c.Name, Arguments: args})
 	}
+	return calls
+}
 
-	return &types.CompletionResponse{
-		Response: types.Response{
-			ID:       r.ID,
-			Created:  time.Now(), // Anthropic doesn't provide creation time
-			Provider: "anthropic",
-			Model:    r.Model,
-			Message: types.Message{
-				Role:    types.RoleAssistant,
-				Content: content,
-			},
-			StopReason: r.StopReason,
-			Usage: types.Usage{
-				PromptTokens:     r.Usage.InputTokens,
-				CompletionTokens: r.Usage.OutputTokens,
-				TotalTokens:      r.Usage.InputTokens + r.Usage.OutputTokens,
-			},
-		},
+// toChatRequest adapts a completion request to the Messages API as a single
+// user message
+func toChatRequest(req *types.CompletionRequest) *types.ChatRequest {
+	return &types.ChatRequest{
+		Messages:         []types.Message{{Role: types.RoleUser, Content: req.Prompt}},
+		MaxTokens:        req.MaxTokens,
+		Temperature:      req.Temperature,
+		TopP:             req.TopP,
+		Stop:             req.Stop,
+		PresencePenalty:  req.PresencePenalty,
+		FrequencyPenalty: req.FrequencyPenalty,
+		User:             req.User,
+		RequestMetadata:  req.RequestMetadata,
+		ProviderParams:   req.ProviderParams,
+		IdempotencyKey:   req.IdempotencyKey,
+		Timeout:          req.Timeout,
+		Priority:         req.Priority,
+		Labels:           req.Labels,
+	}
+}
+
+// maxTokens returns the max_tokens to send for a request's MaxTokens
+func maxTokens(n int) int {
+	if n <= 0 {
+		return defaultMaxTokens
+	}
+	return n
+}
+
+// toFinishReason normalizes an Anthropic stop_reason
+func toFinishReason(reason string) types.FinishReason {
+	switch reason {
+	case "":
+		return ""
+	case "end_turn", "stop_sequence":
+		return types.FinishReasonStop
+	case "max_tokens":
+		return types.FinishReasonMaxTokens
+	case "tool_use":
+		return types.FinishReasonToolCalls
+	default:
+		return types.FinishReasonUnknown
 	}
 }
 
@@ -57,9 +92,39 @@ func (r *anthropicCompletionResponse) toResponse() *types.CompletionResponse {
 type anthropicStreamResponse struct {
 	Type  string `json:"type"`
 	Index int    `json:"index"`
-	Delta struct {
+	// Message is the message_start event's message, without content
+	Message anthropicStreamMessage `json:"message"`
+	// ContentBlock is the block a content_block_start event begins
+	ContentBlock struct {
+		Type string `json:"type"`
 		Text string `json:"text"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"content_block"`
+	// Delta is a content_block_delta's text or partial tool input, or a
+	// message_delta's stop reason
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
 	} `json:"delta"`
+	// Usage is a message_delta's cumulative usage
+	Usage anthropicUsage `json:"usage"`
+}
+
+// anthropicStreamMessage is the message a stream's message_start event
+// begins
+type anthropicStreamMessage struct {
+	ID    string         `json:"id"`
+	Model string         `json:"model"`
+	Usage anthropicUsage `json:"usage"`
+}
+
+// anthropicUsage is the token usage reported in stream events
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
 }
 
 // anthropicError represents an error response from the Anthropic API
@@ -1,18 +1,23 @@
 package openai
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/internal/auth"
+	"github.com/ksred/llm/internal/ratelimit"
+	"github.com/ksred/llm/internal/redact"
 	"github.com/ksred/llm/pkg/resource"
+	"github.com/ksred/llm/pkg/sse"
 	"github.com/ksred/llm/pkg/types"
 )
 
@@ -46,12 +51,18 @@ func NewProvider(cfg *config.Config) (*Provider, error) {
 		}
 	}
 
-	pool := resource.NewConnectionPool(cfg.PoolConfig, "openai", cfg.Metrics)
-	httpClient, err := pool.Get(context.Background())
-	if err != nil {
-		return nil, fmt.Errorf("getting client from pool: %w", err)
+	// Pooled clients use the transport of a configured HTTP client, so
+	// requests can be routed through proxies or recorded in tests
+	poolConfig := *cfg.PoolConfig
+	if cfg.HTTPClient != nil && cfg.HTTPClient.Transport != nil && poolConfig.Transport == nil {
+		poolConfig.Transport = cfg.HTTPClient.Transport
 	}
-	client := resource.NewRetryableClient(httpClient, cfg.RetryConfig, "openai", cfg.Metrics)
+	// Requests are authenticated in the transport, so each retry is too
+	poolConfig.Transport = auth.Transport(auth.FromConfig(cfg, auth.Bearer), poolConfig.Transport)
+
+	pool := resource.NewConnectionPool(&poolConfig, "openai", cfg.Metrics)
+	client := resource.NewPooledRetryableClient(pool, cfg.RetryConfig, "openai", cfg.Metrics)
+	client.SetErrorDecoder(decodeError)
 
 	retryConfig := cfg.RetryConfig
 	if retryConfig == nil {
@@ -82,20 +93,24 @@ func (p *Provider) Complete(ctx context.Context, req *types.CompletionRequest) (
 		"model":             p.config.Model,
 		"prompt":            req.Prompt,
 		"max_tokens":        req.MaxTokens,
-		"temperature":       req.Temperature,
-		"top_p":             req.TopP,
 		"stop":              req.Stop,
 		"presence_penalty":  req.PresencePenalty,
 		"frequency_penalty": req.FrequencyPenalty,
 		"user":              req.User,
 	}
 
+	setSampling(body, req.Temperature, req.TopP)
+	mergeProviderParams(body, req.ProviderParams)
+
 	var resp openAICompletionResponse
-	if err := p.doRequest(ctx, "POST", completionPath, body, &resp); err != nil {
+	header, err := p.doRequest(ctx, "POST", completionPath, req.IdempotencyKey, body, &resp)
+	if err != nil {
 		return nil, err
 	}
 
-	return resp.toResponse(), nil
+	out := resp.toResponse()
+	out.RateLimits = ratelimit.FromHeaders(header, time.Now())
+	return out, nil
 }
 
 // StreamComplete streams a completion for the given prompt
@@ -108,41 +123,43 @@ func (p *Provider) StreamComplete(ctx context.Context, req *types.CompletionRequ
 		"model":             p.config.Model,
 		"prompt":            req.Prompt,
 		"max_tokens":        req.MaxTokens,
-		"temperature":       req.Temperature,
-		"top_p":             req.TopP,
 		"stop":              req.Stop,
 		"presence_penalty":  req.PresencePenalty,
 		"frequency_penalty": req.FrequencyPenalty,
 		"user":              req.User,
 		"stream":            true,
+		// Usage is only reported on streams that ask for it
+		"stream_options": map[string]interface{}{"include_usage": true},
 	}
+	setSampling(body, req.Temperature, req.TopP)
+	mergeProviderParams(body, req.ProviderParams)
 
 	responseChan := make(chan *types.CompletionResponse)
 	go func() {
 		defer close(responseChan)
 
-		streamChan, err := p.streamRequest(ctx, completionPath, body)
+		send := func(resp *types.CompletionResponse) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case responseChan <- resp:
+				return true
+			}
+		}
+
+		streamChan, err := p.streamRequest(ctx, completionPath, req.IdempotencyKey, body)
 		if err != nil {
-			responseChan <- &types.CompletionResponse{
+			send(&types.CompletionResponse{
 				Response: types.Response{
 					Error: err,
 				},
-			}
+			})
 			return
 		}
 
 		for resp := range streamChan {
-			if resp.Error != nil {
-				responseChan <- &types.CompletionResponse{
-					Response: types.Response{
-						Error: resp.Error,
-					},
-				}
-				continue
-			}
-
-			responseChan <- &types.CompletionResponse{
-				Response: resp.Response,
+			if !send(&types.CompletionResponse{Response: resp.Response}) {
+				return
 			}
 		}
 	}()
@@ -158,22 +175,29 @@ func (p *Provider) Chat(ctx context.Context, req *types.ChatRequest) (*types.Cha
 
 	body := map[string]interface{}{
 		"model":             p.config.Model,
-		"messages":          req.Messages,
+		"messages":          toOpenAIMessages(append(req.SystemMessages(), req.Messages...)),
 		"max_tokens":        req.MaxTokens,
-		"temperature":       req.Temperature,
-		"top_p":             req.TopP,
 		"stop":              req.Stop,
 		"presence_penalty":  req.PresencePenalty,
 		"frequency_penalty": req.FrequencyPenalty,
 		"user":              req.User,
 	}
 
+	if len(req.Tools) > 0 {
+		body["tools"] = toOpenAITools(req.Tools)
+	}
+	setSampling(body, req.Temperature, req.TopP)
+	mergeProviderParams(body, req.ProviderParams)
+
 	var resp openAIChatResponse
-	if err := p.doRequest(ctx, "POST", chatPath, body, &resp); err != nil {
+	header, err := p.doRequest(ctx, "POST", chatPath, req.IdempotencyKey, body, &resp)
+	if err != nil {
 		return nil, err
 	}
 
-	return resp.toResponse(), nil
+	out := resp.toResponse()
+	out.RateLimits = ratelimit.FromHeaders(header, time.Now())
+	return out, nil
 }
 
 // StreamChat streams a chat completion for the given messages
@@ -184,74 +208,220 @@ func (p *Provider) StreamChat(ctx context.Context, req *types.ChatRequest) (<-ch
 
 	body := map[string]interface{}{
 		"model":             p.config.Model,
-		"messages":          req.Messages,
+		"messages":          toOpenAIMessages(append(req.SystemMessages(), req.Messages...)),
 		"max_tokens":        req.MaxTokens,
-		"temperature":       req.Temperature,
-		"top_p":             req.TopP,
 		"stop":              req.Stop,
 		"presence_penalty":  req.PresencePenalty,
 		"frequency_penalty": req.FrequencyPenalty,
 		"user":              req.User,
 		"stream":            true,
+		// Usage is only reported on streams that ask for it
+		"stream_options": map[string]interface{}{"include_usage": true},
+	}
+	if len(req.Tools) > 0 {
+		body["tools"] = toOpenAITools(req.Tools)
+	}
+	setSampling(body, req.Temperature, req.TopP)
+	mergeProviderParams(body, req.ProviderParams)
+
+	return p.streamRequest(ctx, chatPath, req.IdempotencyKey, body)
+}
+
+// Close releases the provider's connection pool and stops its background work
+func (p *Provider) Close() error {
+	return p.pool.Shutdown()
+}
+
+// Warmup opens connections to the API ahead of the first request. With
+// ping it also lists the available models, which checks the API key
+// without incurring any cost.
+func (p *Provider) Warmup(ctx context.Context, connections int, ping bool) error {
+	if err := p.pool.Warmup(ctx, p.baseURL, connections); err != nil {
+		return err
+	}
+	if !ping {
+		return nil
+	}
+	_, err := p.doRequest(ctx, "GET", "/models", "", nil, nil)
+	return err
+}
+
+// PoolStats reports the state of the provider's connection pool
+func (p *Provider) PoolStats() resource.PoolStats {
+	return p.pool.Stats()
+}
+
+// setSampling adds the sampling parameters the request sets to the body.
+// Unset ones are left out, so the provider's defaults apply.
+func setSampling(body map[string]interface{}, temperature, topP *float32) {
+	if temperature != nil {
+		body["temperature"] = *temperature
 	}
+	if topP != nil {
+		body["top_p"] = *topP
+	}
+}
 
-	return p.streamRequest(ctx, chatPath, body)
+// mergeProviderParams copies caller-supplied provider parameters into the request body
+func mergeProviderParams(body map[string]interface{}, params map[string]any) {
+	for k, v := range params {
+		body[k] = v
+	}
 }
 
-func (p *Provider) doRequest(ctx context.Context, method, path string, body interface{}, v interface{}) error {
+// toOpenAIMessages converts messages to the OpenAI wire format
+func toOpenAIMessages(msgs []types.Message) []map[string]interface{} {
+	messages := make([]map[string]interface{}, 0, len(msgs))
+	for _, msg := range msgs {
+		m := map[string]interface{}{
+			"role":    string(msg.Role),
+			"content": msg.Content,
+		}
+		if msg.Name != "" {
+			m["name"] = msg.Name
+		}
+		if msg.ToolCallID != "" {
+			m["tool_call_id"] = msg.ToolCallID
+		}
+		if len(msg.ToolCalls) > 0 {
+			calls := make([]map[string]interface{}, 0, len(msg.ToolCalls))
+			for _, call := range msg.ToolCalls {
+				calls = append(calls, map[string]interface{}{
+					"id":   call.ID,
+					"type": "function",
+					"function": map[string]interface{}{
+						"name":      call.Name,
+						"arguments": call.Arguments,
+					},
+				})
+			}
+			m["tool_calls"] = calls
+		}
+		messages = append(messages, m)
+	}
+	return messages
+}
+
+// toOpenAITools converts tool definitions to the OpenAI function tool format
+func toOpenAITools(tools []types.Tool) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(tools))
+	for _, tool := range tools {
+		function := map[string]interface{}{
+			"name": tool.Name,
+		}
+		if tool.Description != "" {
+			function["description"] = tool.Description
+		}
+		if tool.Parameters != nil {
+			function["parameters"] = tool.Parameters
+		}
+		out = append(out, map[string]interface{}{
+			"type":     "function",
+			"function": function,
+		})
+	}
+	return out
+}
+
+// decodeError converts an OpenAI error response into a *types.ProviderError
+// wrapping the common error for its status code
+func decodeError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	var errResp openAIError
+	code, message := "", strings.TrimSpace(string(body))
+	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error.Message != "" {
+		code = errResp.Error.Code
+		if code == "" {
+			code = errResp.Error.Type
+		}
+		message = errResp.Error.Message
+	}
+	message = redact.ProviderMessage(message, resp.StatusCode)
+
+	return &types.ProviderError{
+		Provider:   "openai",
+		Code:       code,
+		Message:    message,
+		StatusCode: resp.StatusCode,
+		RequestID:  resource.RequestID(resp.Header),
+		Err:        types.ErrorForStatus(resp.StatusCode, code, message),
+	}
+}
+
+// endpoint returns the URL for path, with the configured API version as the
+// api-version query parameter that Azure OpenAI requires
+func (p *Provider) endpoint(path string) string {
+	if p.config.APIVersion == "" {
+		return p.baseURL + path
+	}
+	return p.baseURL + path + "?api-version=" + url.QueryEscape(p.config.APIVersion)
+}
+
+// doRequest sends a request and decodes the JSON response into v, returning
+// the response headers
+func (p *Provider) doRequest(ctx context.Context, method, path, idempotencyKey string, body interface{}, v interface{}) (http.Header, error) {
 	var bodyReader io.Reader
 	if body != nil {
 		bodyBytes, err := json.Marshal(body)
 		if err != nil {
-			return fmt.Errorf("marshaling request body: %w", err)
+			return nil, fmt.Errorf("marshaling request body: %w", err)
 		}
 		bodyReader = bytes.NewReader(bodyBytes)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, method, p.endpoint(path), bodyReader)
 	if err != nil {
-		return fmt.Errorf("creating request: %w", err)
+		return nil, fmt.Errorf("creating request: %w", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+p.config.APIKey)
+	p.setHeaders(req, idempotencyKey)
 
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("making request: %w", err)
+		return nil, fmt.Errorf("making request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		var apiErr types.ProviderError
-		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
-			return fmt.Errorf("request failed with status %d", resp.StatusCode)
-		}
-		return &apiErr
+		return nil, decodeError(resp)
 	}
 
 	if v != nil {
 		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
-			return fmt.Errorf("decoding response: %w", err)
+			return nil, fmt.Errorf("decoding response: %w", err)
 		}
 	}
 
-	return nil
+	return resp.Header, nil
+}
+
+// setHeaders sets the headers every OpenAI request carries
+func (p *Provider) setHeaders(req *http.Request, idempotencyKey string) {
+	req.Header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	if p.config.OpenAIOrganization != "" {
+		req.Header.Set("OpenAI-Organization", p.config.OpenAIOrganization)
+	}
+	if p.config.OpenAIProject != "" {
+		req.Header.Set("OpenAI-Project", p.config.OpenAIProject)
+	}
 }
 
 // streamRequest handles streaming responses from the OpenAI API
-func (p *Provider) streamRequest(ctx context.Context, path string, body interface{}) (<-chan *types.ChatResponse, error) {
+func (p *Provider) streamRequest(ctx context.Context, path, idempotencyKey string, body interface{}) (<-chan *types.ChatResponse, error) {
 	jsonBody, err := json.Marshal(body)
 	if err != nil {
 		return nil, fmt.Errorf("marshaling request body: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+path, bytes.NewReader(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", p.endpoint(path), bytes.NewReader(jsonBody))
 	if err != nil {
 		return nil, fmt.Errorf("creating request: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+p.config.APIKey)
+	p.setHeaders(req, idempotencyKey)
 	req.Header.Set("Accept", "text/event-stream")
 
 	resp, err := p.client.Do(req)
@@ -260,58 +430,88 @@ func (p *Provider) streamRequest(ctx context.Context, path string, body interfac
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		var errResp openAIError
-		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return nil, fmt.Errorf("decoding error response: %w", err)
-		}
-		return nil, types.NewProviderError("openai", errResp.Error.Type, errResp.Error.Message, nil)
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
 	}
 
+	// The first chunk carries the rate limits reported with the stream
+	limits := ratelimit.FromHeaders(resp.Header, time.Now())
 	responseChan := make(chan *types.ChatResponse)
 	go func() {
 		defer resp.Body.Close()
 		defer close(responseChan)
 
-		reader := bufio.NewReader(resp.Body)
+		// Closing the body on cancellation ends a read blocked waiting for
+		// the next event
+		stop := context.AfterFunc(ctx, func() { resp.Body.Close() })
+		defer stop()
+
+		send := func(resp *types.ChatResponse) bool {
+			resp.RateLimits, limits = limits, nil
+			select {
+			case <-ctx.Done():
+				return false
+			case responseChan <- resp:
+				return true
+			}
+		}
+
+		// A stream with no event for the idle timeout is abandoned
+		var idle *time.Timer
+		var idleExpired atomic.Bool
+		timeout := p.config.StreamIdleTimeout
+		if timeout > 0 {
+			idle = time.AfterFunc(timeout, func() {
+				idleExpired.Store(true)
+				resp.Body.Close()
+			})
+			defer idle.Stop()
+		}
+
+		toolCallIDs := make(map[int]string)
+
+		events := sse.NewReader(resp.Body,
+			sse.WithBufferSize(p.config.StreamBufferSize),
+			sse.WithMaxEventSize(p.config.MaxStreamEventSize))
 		for {
-			line, err := reader.ReadString('\n')
+			event, err := events.Next()
 			if err != nil {
-				if err != io.EOF {
-					responseChan <- &types.ChatResponse{
+				if idleExpired.Load() {
+					err = fmt.Errorf("no event for %v: %w", timeout, types.ErrTimeout)
+				}
+				if err != io.EOF && ctx.Err() == nil {
+					send(&types.ChatResponse{
 						Response: types.Response{
 							Error: fmt.Errorf("reading stream: %w", err),
 						},
-					}
+					})
 				}
 				return
 			}
 
-			line = strings.TrimSpace(line)
-			if line == "" || line == "data: [DONE]" {
-				continue
+			if idle != nil {
+				idle.Reset(timeout)
 			}
 
-			if !strings.HasPrefix(line, "data: ") {
-				continue
+			data := event.Data
+			if data == "[DONE]" {
+				return
 			}
 
-			data := strings.TrimPrefix(line, "data: ")
 			var streamResp openAIStreamResponse
 			if err := json.Unmarshal([]byte(data), &streamResp); err != nil {
-				responseChan <- &types.ChatResponse{
+				if !send(&types.ChatResponse{
 					Response: types.Response{
 						Error: fmt.Errorf("decoding stream response: %w", err),
 					},
+				}) {
+					return
 				}
 				continue
 			}
 
-			response := streamResp.toResponse()
-			select {
-			case <-ctx.Done():
+			if !send(streamResp.toResponse(toolCallIDs)) {
 				return
-			case responseChan <- response:
 			}
 		}
 	}()
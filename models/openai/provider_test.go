@@ -2,6 +2,7 @@ package openai
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/ksred/llm/config"
 	"github.com/ksred/llm/pkg/resource"
+	"github.com/ksred/llm/pkg/testutil"
 	"github.com/ksred/llm/pkg/types"
 )
 
@@ -265,3 +267,811 @@ func TestProvider_RetryableClient(t *testing.T) {
 		t.Fatal("NewRetryableClient() returned nil")
 	}
 }
+
+func TestProvider_ChatRetry(t *testing.T) {
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decoding request body: %v", err)
+		}
+		bodies = append(bodies, body)
+
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":    "test-id",
+			"model": "gpt-4",
+			"choices": []map[string]interface{}{
+				{"message": map[string]interface{}{"role": "assistant", "content": "Hello"}},
+			},
+		})
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(&config.Config{
+		Provider: "openai",
+		Model:    "gpt-4",
+		APIKey:   "test-key",
+		BaseURL:  server.URL,
+		RetryConfig: &resource.RetryConfig{
+			MaxRetries:      2,
+			InitialInterval: time.Millisecond,
+			MaxInterval:     time.Millisecond,
+			Multiplier:      2,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+
+	resp, err := p.Chat(context.Background(), &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if resp.Message.Content != "Hello" {
+		t.Errorf("Chat() content = %q, want %q", resp.Message.Content, "Hello")
+	}
+
+	if len(bodies) != 2 {
+		t.Fatalf("server received %d requests, want 2", len(bodies))
+	}
+	if !reflect.DeepEqual(bodies[0], bodies[1]) {
+		t.Errorf("retried body = %v, want %v", bodies[1], bodies[0])
+	}
+}
+
+func TestProvider_ProviderParams(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request body: %v", err)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": "test-id",
+			"choices": []map[string]interface{}{
+				{
+					"message": map[string]interface{}{
+						"role":    "assistant",
+						"content": "Hello",
+					},
+				},
+			},
+			"model": "gpt-4",
+		})
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(&config.Config{
+		Provider: "openai",
+		Model:    "gpt-4",
+		APIKey:   "test-key",
+		BaseURL:  server.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+
+	_, err = p.Chat(context.Background(), &types.ChatRequest{
+		Messages: []types.Message{
+			{
+				Role:    "user",
+				Content: "Hello",
+			},
+		},
+		ProviderParams: map[string]any{
+			"seed":  42,
+			"model": "gpt-4o",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	if got["seed"] != float64(42) {
+		t.Errorf("request body seed = %v, want %v", got["seed"], 42)
+	}
+	if got["model"] != "gpt-4o" {
+		t.Errorf("request body model = %v, want %v", got["model"], "gpt-4o")
+	}
+}
+
+func TestProvider_System(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request body: %v", err)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      "test-id",
+			"choices": []map[string]interface{}{{"message": map[string]interface{}{"role": "assistant", "content": "Hello"}}},
+			"model":   "gpt-4",
+		})
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(&config.Config{
+		Provider: "openai",
+		Model:    "gpt-4",
+		APIKey:   "test-key",
+		BaseURL:  server.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+
+	_, err = p.Chat(context.Background(), &types.ChatRequest{
+		System:   []string{"Be brief.", "Answer in French."},
+		Messages: []types.Message{types.UserMessage("Hello")},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	want := []any{
+		map[string]any{"role": "system", "content": "Be brief."},
+		map[string]any{"role": "system", "content": "Answer in French."},
+		map[string]any{"role": "user", "content": "Hello"},
+	}
+	if !reflect.DeepEqual(got["messages"], want) {
+		t.Errorf("request body messages = %#v, want %#v", got["messages"], want)
+	}
+}
+
+func TestProvider_APIVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		want    string
+	}{
+		{name: "unset", want: ""},
+		{name: "pinned", version: "2024-10-21", want: "api-version=2024-10-21"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queries []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				queries = append(queries, r.URL.RawQuery)
+				if r.Header.Get("Accept") == "text/event-stream" {
+					w.Header().Set("Content-Type", "text/event-stream")
+					fmt.Fprint(w, "data: [DONE]\n\n")
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"id":      "test-id",
+					"choices": []map[string]interface{}{{"message": map[string]interface{}{"role": "assistant", "content": "Hello"}}},
+				})
+			}))
+			defer server.Close()
+
+			p, err := NewProvider(&config.Config{
+				Provider:   "openai",
+				Model:      "gpt-4",
+				APIKey:     "test-key",
+				BaseURL:    server.URL,
+				APIVersion: tt.version,
+			})
+			if err != nil {
+				t.Fatalf("NewProvider() error = %v", err)
+			}
+
+			ctx := context.Background()
+			req := &types.ChatRequest{Messages: []types.Message{types.UserMessage("Hello")}}
+			if _, err := p.Chat(ctx, req); err != nil {
+				t.Fatalf("Chat() error = %v", err)
+			}
+			stream, err := p.StreamChat(ctx, req)
+			if err != nil {
+				t.Fatalf("StreamChat() error = %v", err)
+			}
+			for range stream {
+			}
+
+			for i, q := range queries {
+				if q != tt.want {
+					t.Errorf("request %d query = %q, want %q", i, q, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestProvider_OrganizationHeaders(t *testing.T) {
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Clone())
+		if r.Header.Get("Accept") == "text/event-stream" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      "test-id",
+			"choices": []map[string]interface{}{{"message": map[string]interface{}{"role": "assistant", "content": "Hello"}}},
+		})
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(&config.Config{
+		Provider:           "openai",
+		Model:              "gpt-4",
+		APIKey:             "test-key",
+		BaseURL:            server.URL,
+		OpenAIOrganization: "org-123",
+		OpenAIProject:      "proj_456",
+	})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+
+	ctx := context.Background()
+	req := &types.ChatRequest{Messages: []types.Message{types.UserMessage("Hello")}}
+	if _, err := p.Chat(ctx, req); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	stream, err := p.StreamChat(ctx, req)
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	for range stream {
+	}
+
+	if len(headers) != 2 {
+		t.Fatalf("got %d requests, want 2", len(headers))
+	}
+	for i, h := range headers {
+		if h.Get("OpenAI-Organization") != "org-123" || h.Get("OpenAI-Project") != "proj_456" {
+			t.Errorf("request %d OpenAI-Organization = %q, OpenAI-Project = %q, want org-123 and proj_456", i, h.Get("OpenAI-Organization"), h.Get("OpenAI-Project"))
+		}
+	}
+}
+
+func TestProvider_RateLimits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ratelimit-remaining-requests", "41")
+		if r.Header.Get("Accept") == "text/event-stream" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n"+
+				"data: {\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\ndata: [DONE]\n\n")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      "test-id",
+			"choices": []map[string]interface{}{{"message": map[string]interface{}{"role": "assistant", "content": "Hello"}}},
+		})
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(&config.Config{
+		Provider: "openai",
+		Model:    "gpt-4",
+		APIKey:   "test-key",
+		BaseURL:  server.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+
+	ctx := context.Background()
+	req := &types.ChatRequest{Messages: []types.Message{types.UserMessage("Hello")}}
+	resp, err := p.Chat(ctx, req)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if resp.RateLimits == nil || resp.RateLimits.RequestsRemaining != 41 || resp.RateLimits.TokensRemaining != -1 {
+		t.Errorf("Chat() RateLimits = %+v, want 41 requests remaining", resp.RateLimits)
+	}
+
+	stream, err := p.StreamChat(ctx, req)
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	var chunks []*types.ChatResponse
+	for chunk := range stream {
+		chunks = append(chunks, chunk)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("StreamChat() sent %d chunks, want at least 2", len(chunks))
+	}
+	if chunks[0].RateLimits == nil || chunks[0].RateLimits.RequestsRemaining != 41 {
+		t.Errorf("first chunk RateLimits = %+v, want 41 requests remaining", chunks[0].RateLimits)
+	}
+	if chunks[1].RateLimits != nil {
+		t.Errorf("second chunk RateLimits = %+v, want nil", chunks[1].RateLimits)
+	}
+}
+
+func TestProvider_SamplingParams(t *testing.T) {
+	tests := []struct {
+		name            string
+		temperature     *float32
+		topP            *float32
+		wantTemperature any
+		wantTopP        any
+	}{
+		{name: "unset", wantTemperature: nil, wantTopP: nil},
+		{name: "zero temperature", temperature: types.Float32(0), wantTemperature: float64(0), wantTopP: nil},
+		{name: "both set", temperature: types.Float32(0.5), topP: types.Float32(0.25), wantTemperature: 0.5, wantTopP: 0.25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Errorf("decoding request body: %v", err)
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"id":      "test-id",
+					"choices": []map[string]interface{}{{"message": map[string]interface{}{"role": "assistant", "content": "Hello"}}},
+					"model":   "gpt-4",
+				})
+			}))
+			defer server.Close()
+
+			p, err := NewProvider(&config.Config{
+				Provider: "openai",
+				Model:    "gpt-4",
+				APIKey:   "test-key",
+				BaseURL:  server.URL,
+			})
+			if err != nil {
+				t.Fatalf("NewProvider() error = %v", err)
+			}
+
+			_, err = p.Chat(context.Background(), &types.ChatRequest{
+				Messages:    []types.Message{types.UserMessage("Hello")},
+				Temperature: tt.temperature,
+				TopP:        tt.topP,
+			})
+			if err != nil {
+				t.Fatalf("Chat() error = %v", err)
+			}
+
+			temperature, hasTemperature := got["temperature"]
+			if hasTemperature != (tt.wantTemperature != nil) || temperature != tt.wantTemperature {
+				t.Errorf("request body temperature = %v (sent %v), want %v", temperature, hasTemperature, tt.wantTemperature)
+			}
+			topP, hasTopP := got["top_p"]
+			if hasTopP != (tt.wantTopP != nil) || topP != tt.wantTopP {
+				t.Errorf("request body top_p = %v (sent %v), want %v", topP, hasTopP, tt.wantTopP)
+			}
+		})
+	}
+}
+
+func TestProvider_ChatTools(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request body: %v", err)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":    "test-id",
+			"model": "gpt-4",
+			"choices": []map[string]interface{}{
+				{
+					"message": map[string]interface{}{
+						"role":    "assistant",
+						"content": nil,
+						"tool_calls": []map[string]interface{}{
+							{
+								"id":   "call_2",
+								"type": "function",
+								"function": map[string]interface{}{
+									"name":      "get_weather",
+									"arguments": `{"city":"Paris"}`,
+								},
+							},
+						},
+					},
+					"finish_reason": "tool_calls",
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(&config.Config{
+		Provider: "openai",
+		Model:    "gpt-4",
+		APIKey:   "test-key",
+		BaseURL:  server.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+
+	resp, err := p.Chat(context.Background(), &types.ChatRequest{
+		Messages: []types.Message{
+			{Role: types.RoleUser, Content: "Weather in London?"},
+			{Role: types.RoleAssistant, ToolCalls: []types.ToolCall{{ID: "call_1", Name: "get_weather", Arguments: `{"city":"London"}`}}},
+			{Role: types.RoleTool, Content: "12C", ToolCallID: "call_1"},
+		},
+		Tools: []types.Tool{{Name: "get_weather", Description: "Current weather"}},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	tools, _ := got["tools"].([]interface{})
+	if len(tools) != 1 {
+		t.Fatalf("request tools = %v, want 1 tool", got["tools"])
+	}
+	function := tools[0].(map[string]interface{})["function"].(map[string]interface{})
+	if function["name"] != "get_weather" {
+		t.Errorf("tool function = %v", function)
+	}
+
+	messages := got["messages"].([]interface{})
+	calls := messages[1].(map[string]interface{})["tool_calls"].([]interface{})
+	if calls[0].(map[string]interface{})["id"] != "call_1" {
+		t.Errorf("assistant tool_calls = %v", calls)
+	}
+	if messages[2].(map[string]interface{})["tool_call_id"] != "call_1" {
+		t.Errorf("tool message = %v", messages[2])
+	}
+
+	want := []types.ToolCall{{ID: "call_2", Name: "get_weather", Arguments: `{"city":"Paris"}`}}
+	if !reflect.DeepEqual(resp.Message.ToolCalls, want) {
+		t.Errorf("ToolCalls = %+v, want %+v", resp.Message.ToolCalls, want)
+	}
+	if resp.FinishReason != types.FinishReasonToolCalls {
+		t.Errorf("FinishReason = %q, want %q", resp.FinishReason, types.FinishReasonToolCalls)
+	}
+}
+
+func TestProvider_ErrorMapping(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantErr  error
+		wantCode string
+	}{
+		{"context length", http.StatusBadRequest, `{"error":{"message":"This model's maximum context length is 8192 tokens","type":"invalid_request_error","code":"context_length_exceeded"}}`, types.ErrContextTooLong, "context_length_exceeded"},
+		{"invalid credentials", http.StatusUnauthorized, `{"error":{"message":"Invalid API key","type":"invalid_request_error","code":"invalid_api_key"}}`, types.ErrInvalidCredentials, "invalid_api_key"},
+		{"rate limited", http.StatusTooManyRequests, ``, types.ErrRateLimitExceeded, ""},
+		{"overloaded", 529, ``, types.ErrOverloaded, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Request-Id", "req_123")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			p, err := NewProvider(&config.Config{
+				Provider:    "openai",
+				Model:       "gpt-4",
+				APIKey:      "test-key",
+				BaseURL:     server.URL,
+				RetryConfig: &resource.RetryConfig{MaxRetries: 0},
+			})
+			if err != nil {
+				t.Fatalf("NewProvider() error = %v", err)
+			}
+
+			_, err = p.Chat(context.Background(), &types.ChatRequest{
+				Messages: []types.Message{{Role: types.RoleUser, Content: "Hello"}},
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Chat() error = %v, want %v", err, tt.wantErr)
+			}
+
+			var provErr *types.ProviderError
+			if !errors.As(err, &provErr) {
+				t.Fatalf("Chat() error = %T, want *types.ProviderError", err)
+			}
+			if provErr.StatusCode != tt.status || provErr.RequestID != "req_123" || provErr.Code != tt.wantCode {
+				t.Errorf("ProviderError = %+v", provErr)
+			}
+		})
+	}
+}
+
+func TestToFinishReason(t *testing.T) {
+	tests := map[string]types.FinishReason{
+		"":               "",
+		"stop":           types.FinishReasonStop,
+		"length":         types.FinishReasonLength,
+		"tool_calls":     types.FinishReasonToolCalls,
+		"function_call":  types.FinishReasonToolCalls,
+		"content_filter": types.FinishReasonContentFilter,
+		"something_new":  types.FinishReasonUnknown,
+	}
+
+	for raw, want := range tests {
+		if got := toFinishReason(raw); got != want {
+			t.Errorf("toFinishReason(%q) = %v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestProvider_IdempotencyKeyHeader(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Idempotency-Key")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      "test-id",
+			"choices": []map[string]interface{}{{"message": map[string]interface{}{"role": "assistant", "content": "Hi"}}},
+			"model":   "gpt-4",
+		})
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(&config.Config{
+		Provider: "openai",
+		Model:    "gpt-4",
+		APIKey:   "test-key",
+		BaseURL:  server.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+
+	_, err = p.Chat(context.Background(), &types.ChatRequest{
+		Messages:       []types.Message{{Role: "user", Content: "Hello"}},
+		IdempotencyKey: "order-42",
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if got != "order-42" {
+		t.Errorf("Idempotency-Key header = %q, want %q", got, "order-42")
+	}
+}
+
+func TestProvider_Warmup(t *testing.T) {
+	tests := []struct {
+		name      string
+		ping      bool
+		apiKey    string
+		wantPaths []string
+		wantErr   error
+	}{
+		{"preconnect", false, "test-key", []string{"HEAD /"}, nil},
+		{"ping", true, "test-key", []string{"HEAD /", "GET /models"}, nil},
+		{"ping bad key", true, "bad-key", []string{"HEAD /", "GET /models"}, types.ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var paths []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				paths = append(paths, r.Method+" "+r.URL.Path)
+				if r.Method == http.MethodGet && r.Header.Get("Authorization") != "Bearer test-key" {
+					w.WriteHeader(http.StatusUnauthorized)
+					json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"message": "invalid key"}})
+					return
+				}
+				w.Write([]byte(`{"data":[]}`))
+			}))
+			defer server.Close()
+
+			p, err := NewProvider(&config.Config{
+				Provider:    "openai",
+				Model:       "gpt-4",
+				APIKey:      tt.apiKey,
+				BaseURL:     server.URL,
+				RetryConfig: &resource.RetryConfig{MaxRetries: 0},
+			})
+			if err != nil {
+				t.Fatalf("NewProvider() error = %v", err)
+			}
+
+			err = p.Warmup(context.Background(), 1, tt.ping)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Warmup() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(paths, tt.wantPaths) {
+				t.Errorf("server got %v, want %v", paths, tt.wantPaths)
+			}
+		})
+	}
+}
+
+func TestProvider_StreamChatAccumulated(t *testing.T) {
+	// toolChunk returns a chunk carrying part of a tool call
+	toolChunk := func(call map[string]any) testutil.Event {
+		return testutil.Event{Data: fmt.Sprintf(
+			`{"id":"chatcmpl-test","model":"gpt-4","choices":[{"index":0,"delta":{"tool_calls":[%s]}}]}`, mustJSON(t, call))}
+	}
+	usage := testutil.Event{Data: `{"id":"chatcmpl-test","model":"gpt-4","choices":[],` +
+		`"usage":{"prompt_tokens":20,"completion_tokens":12,"total_tokens":32}}`}
+
+	tests := []struct {
+		name       string
+		events     []testutil.Event
+		want       types.Message
+		wantFinish types.FinishReason
+	}{
+		{
+			name:       "text",
+			events:     []testutil.Event{testutil.OpenAIChunk("Hel"), testutil.OpenAIChunk("lo"), testutil.OpenAIFinish("stop"), usage, testutil.OpenAIDone()},
+			want:       types.Message{Role: types.RoleAssistant, Content: "Hello"},
+			wantFinish: types.FinishReasonStop,
+		},
+		{
+			name: "tool calls",
+			events: []testutil.Event{
+				toolChunk(map[string]any{"index": 0, "id": "call_1", "type": "function", "function": map[string]any{"name": "get_weather", "arguments": ""}}),
+				toolChunk(map[string]any{"index": 1, "id": "call_2", "type": "function", "function": map[string]any{"name": "get_time", "arguments": "{}"}}),
+				toolChunk(map[string]any{"index": 0, "function": map[string]any{"arguments": `{"city":`}}),
+				toolChunk(map[string]any{"index": 0, "function": map[string]any{"arguments": ` "Paris"}`}}),
+				testutil.OpenAIFinish("tool_calls"),
+				usage,
+				testutil.OpenAIDone(),
+			},
+			want: types.Message{Role: types.RoleAssistant, ToolCalls: []types.ToolCall{
+				{ID: "call_1", Name: "get_weather", Arguments: `{"city": "Paris"}`},
+				{ID: "call_2", Name: "get_time", Arguments: "{}"},
+			}},
+			wantFinish: types.FinishReasonToolCalls,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := testutil.NewStreamServer(tt.events...)
+			defer server.Close()
+
+			p, err := NewProvider(&config.Config{APIKey: "test-key", Model: "gpt-4", BaseURL: server.URL})
+			if err != nil {
+				t.Fatalf("NewProvider() error = %v", err)
+			}
+			defer p.Close()
+
+			stream, err := p.StreamChat(context.Background(), &types.ChatRequest{
+				Messages: []types.Message{{Role: types.RoleUser, Content: "Hi"}},
+			})
+			if err != nil {
+				t.Fatalf("StreamChat() error = %v", err)
+			}
+
+			var acc types.Accumulator
+			for chunk := range stream {
+				acc.Add(chunk)
+			}
+			if err := acc.Err(); err != nil {
+				t.Fatalf("stream error = %v", err)
+			}
+
+			resp := acc.Response()
+			if !reflect.DeepEqual(resp.Message, tt.want) {
+				t.Errorf("message = %+v, want %+v", resp.Message, tt.want)
+			}
+			if resp.FinishReason != tt.wantFinish {
+				t.Errorf("finish reason = %q, want %q", resp.FinishReason, tt.wantFinish)
+			}
+			if want := (types.Usage{PromptTokens: 20, CompletionTokens: 12, TotalTokens: 32}); resp.Usage != want {
+				t.Errorf("usage = %+v, want %+v", resp.Usage, want)
+			}
+
+			var body struct {
+				StreamOptions struct {
+					IncludeUsage bool `json:"include_usage"`
+				} `json:"stream_options"`
+			}
+			if err := json.Unmarshal(server.Requests()[0], &body); err != nil || !body.StreamOptions.IncludeUsage {
+				t.Errorf("request %s does not ask for usage", server.Requests()[0])
+			}
+		})
+	}
+}
+
+// mustJSON returns v encoded as JSON
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("encoding %v: %v", v, err)
+	}
+	return string(data)
+}
+
+func TestProvider_StreamChatCancel(t *testing.T) {
+	tests := []struct {
+		name string
+		// readFirst reads the first chunk before cancelling, so the stream
+		// is blocked reading the next event rather than sending
+		readFirst bool
+	}{
+		{name: "waiting for event", readFirst: true},
+		{name: "waiting for reader", readFirst: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The second event never arrives while the test runs
+			server := testutil.NewStreamServer(testutil.OpenAIChunk("Hel"), testutil.Slow(time.Minute, testutil.OpenAIChunk("Hel"))[0])
+			defer server.Close()
+
+			p, err := NewProvider(&config.Config{APIKey: "test-key", Model: "gpt-4", BaseURL: server.URL})
+			if err != nil {
+				t.Fatalf("NewProvider() error = %v", err)
+			}
+			defer p.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stream, err := p.StreamChat(ctx, &types.ChatRequest{
+				Messages:  []types.Message{{Role: types.RoleUser, Content: "Hi"}},
+				MaxTokens: 10,
+			})
+			if err != nil {
+				t.Fatalf("StreamChat() error = %v", err)
+			}
+			if tt.readFirst {
+				<-stream
+			} else {
+				// Give the stream time to block sending the first chunk
+				time.Sleep(20 * time.Millisecond)
+			}
+
+			start := time.Now()
+			cancel()
+			done := make(chan struct{})
+			go func() {
+				for range stream {
+				}
+				close(done)
+			}()
+			select {
+			case <-done:
+				if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+					t.Errorf("stream ended %v after cancellation, want within 100ms", elapsed)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("stream did not end after cancellation")
+			}
+		})
+	}
+}
+
+func TestProvider_StreamChatIdleTimeout(t *testing.T) {
+	server := testutil.NewStreamServer(testutil.OpenAIChunk("Hel"), testutil.Slow(time.Minute, testutil.OpenAIChunk("lo"))[0])
+	defer server.Close()
+
+	p, err := NewProvider(&config.Config{
+		APIKey:            "test-key",
+		Model:             "gpt-4o-mini",
+		BaseURL:           server.URL,
+		StreamIdleTimeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+	defer p.Close()
+
+	stream, err := p.StreamChat(context.Background(), &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+
+	var content string
+	var errs []error
+	for resp := range stream {
+		if resp.Error != nil {
+			errs = append(errs, resp.Error)
+			continue
+		}
+		content += resp.Message.Content
+	}
+	if content != "Hel" {
+		t.Errorf("content = %q, want %q", content, "Hel")
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], types.ErrTimeout) {
+		t.Errorf("stream errors = %v, want one %v", errs, types.ErrTimeout)
+	}
+}
@@ -16,6 +16,24 @@ type openAIError struct {
 	} `json:"error"`
 }
 
+// toFinishReason normalizes an OpenAI finish_reason
+func toFinishReason(reason string) types.FinishReason {
+	switch reason {
+	case "":
+		return ""
+	case "stop":
+		return types.FinishReasonStop
+	case "length":
+		return types.FinishReasonLength
+	case "tool_calls", "function_call":
+		return types.FinishReasonToolCalls
+	case "content_filter":
+		return types.FinishReasonContentFilter
+	default:
+		return types.FinishReasonUnknown
+	}
+}
+
 // openAICompletionResponse represents a completion response from the OpenAI API
 type openAICompletionResponse struct {
 	ID      string `json:"id"`
@@ -46,12 +64,13 @@ func (r *openAICompletionResponse) toResponse() *types.CompletionResponse {
 
 	return &types.CompletionResponse{
 		Response: types.Response{
-			ID:         r.ID,
-			Created:    time.Unix(r.Created, 0),
-			Provider:   "openai",
-			Model:      r.Model,
-			Message:    types.Message{Role: types.RoleAssistant, Content: content},
-			StopReason: finishReason,
+			ID:              r.ID,
+			Created:         time.Unix(r.Created, 0),
+			Provider:        "openai",
+			Model:           r.Model,
+			Message:         types.Message{Role: types.RoleAssistant, Content: content},
+			FinishReason:    toFinishReason(finishReason),
+			RawFinishReason: finishReason,
 			Usage: types.Usage{
 				PromptTokens:     r.Usage.PromptTokens,
 				CompletionTokens: r.Usage.CompletionTokens,
@@ -61,6 +80,16 @@ func (r *openAICompletionResponse) toResponse() *types.CompletionResponse {
 	}
 }
 
+// openAIToolCall represents a tool call in an OpenAI chat message
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
 // openAIChatResponse represents a chat completion response from the OpenAI API
 type openAIChatResponse struct {
 	ID      string `json:"id"`
@@ -69,8 +98,9 @@ type openAIChatResponse struct {
 	Model   string `json:"model"`
 	Choices []struct {
 		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
+			Role      string           `json:"role"`
+			Content   string           `json:"content"`
+			ToolCalls []openAIToolCall `json:"tool_calls"`
 		} `json:"message"`
 		FinishReason string `json:"finish_reason"`
 		Index        int    `json:"index"`
@@ -91,17 +121,25 @@ func (r *openAIChatResponse) toResponse() *types.ChatResponse {
 			Role:    types.Role(r.Choices[0].Message.Role),
 			Content: r.Choices[0].Message.Content,
 		}
+		for _, call := range r.Choices[0].Message.ToolCalls {
+			message.ToolCalls = append(message.ToolCalls, types.ToolCall{
+				ID:        call.ID,
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			})
+		}
 		finishReason = r.Choices[0].FinishReason
 	}
 
 	return &types.ChatResponse{
 		Response: types.Response{
-			ID:         r.ID,
-			Created:    time.Unix(r.Created, 0),
-			Provider:   "openai",
-			Model:      r.Model,
-			Message:    message,
-			StopReason: finishReason,
+			ID:              r.ID,
+			Created:         time.Unix(r.Created, 0),
+			Provider:        "openai",
+			Model:           r.Model,
+			Message:         message,
+			FinishReason:    toFinishReason(finishReason),
+			RawFinishReason: finishReason,
 			Usage: types.Usage{
 				PromptTokens:     r.Usage.PromptTokens,
 				CompletionTokens: r.Usage.CompletionTokens,
@@ -119,34 +157,76 @@ type openAIStreamResponse struct {
 	Model   string `json:"model"`
 	Choices []struct {
 		Delta struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
+			Role      string                `json:"role"`
+			Content   string                `json:"content"`
+			ToolCalls []openAIToolCallDelta `json:"tool_calls"`
 		} `json:"delta"`
 		FinishReason string `json:"finish_reason"`
 		Index        int    `json:"index"`
 	} `json:"choices"`
+	// Usage is only sent, on a final chunk without choices, when the
+	// request sets stream_options.include_usage
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+		TotalTokens      int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// openAIToolCallDelta is part of a streamed tool call. Only the first part
+// of each call has its ID and name; later parts carry just its index and
+// more of its arguments.
+type openAIToolCallDelta struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
 }
 
-// toResponse converts an OpenAI stream response to a generic ChatResponse
-func (r *openAIStreamResponse) toResponse() *types.ChatResponse {
+// toResponse converts an OpenAI stream response to a generic ChatResponse.
+// ids holds the IDs of the stream's tool calls by index, so each part of a
+// call is sent with its ID.
+func (r *openAIStreamResponse) toResponse(ids map[int]string) *types.ChatResponse {
 	var message types.Message
 	var finishReason string
 	if len(r.Choices) > 0 {
+		delta := r.Choices[0].Delta
 		message = types.Message{
-			Role:    types.Role(r.Choices[0].Delta.Role),
-			Content: r.Choices[0].Delta.Content,
+			Role:    types.Role(delta.Role),
+			Content: delta.Content,
+		}
+		for _, call := range delta.ToolCalls {
+			if call.ID != "" {
+				ids[call.Index] = call.ID
+			}
+			message.ToolCalls = append(message.ToolCalls, types.ToolCall{
+				ID:        ids[call.Index],
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			})
 		}
 		finishReason = r.Choices[0].FinishReason
 	}
 
-	return &types.ChatResponse{
+	resp := &types.ChatResponse{
 		Response: types.Response{
-			ID:         r.ID,
-			Created:    time.Unix(r.Created, 0),
-			Provider:   "openai",
-			Model:      r.Model,
-			Message:    message,
-			StopReason: finishReason,
+			ID:              r.ID,
+			Created:         time.Unix(r.Created, 0),
+			Provider:        "openai",
+			Model:           r.Model,
+			Message:         message,
+			FinishReason:    toFinishReason(finishReason),
+			RawFinishReason: finishReason,
 		},
 	}
+	if r.Usage != nil {
+		resp.Usage = types.Usage{
+			PromptTokens:     r.Usage.PromptTokens,
+			CompletionTokens: r.Usage.CompletionTokens,
+			TotalTokens:      r.Usage.TotalTokens,
+		}
+	}
+	return resp
 }
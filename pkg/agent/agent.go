@@ -0,0 +1,218 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+var (
+	ErrMaxIterations = errors.New("agent reached maximum iterations without a final answer")
+	ErrDuplicateTool = errors.New("tool already registered")
+	ErrUnknownTool   = errors.New("unknown tool")
+	ErrToolPanic     = errors.New("tool panicked")
+)
+
+const (
+	defaultMaxIterations = 10
+	defaultToolTimeout   = 30 * time.Second
+)
+
+// Chatter sends a chat request. *client.Client satisfies this interface.
+type Chatter interface {
+	Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error)
+}
+
+// ToolFunc runs a tool with the JSON arguments chosen by the model and
+// returns the result to show the model
+type ToolFunc func(ctx context.Context, args json.RawMessage) (string, error)
+
+// Typed adapts a function taking decoded arguments to a ToolFunc
+func Typed[T any](fn func(ctx context.Context, args T) (string, error)) ToolFunc {
+	return func(ctx context.Context, raw json.RawMessage) (string, error) {
+		var args T
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return "", fmt.Errorf("decoding arguments: %w", err)
+			}
+		}
+		return fn(ctx, args)
+	}
+}
+
+// Agent runs the tool loop: it sends a chat request, runs any tool calls the
+// model makes, feeds the results back and repeats until the model answers
+// without calling a tool
+type Agent struct {
+	chatter       Chatter
+	tools         []types.Tool
+	funcs         map[string]ToolFunc
+	maxIterations int
+	toolTimeout   time.Duration
+}
+
+// Option configures an Agent
+type Option func(*Agent)
+
+// WithMaxIterations limits the number of chat round trips. Defaults to 10.
+func WithMaxIterations(n int) Option {
+	return func(a *Agent) {
+		a.maxIterations = n
+	}
+}
+
+// WithToolTimeout bounds each tool call. Defaults to 30 seconds.
+func WithToolTimeout(d time.Duration) Option {
+	return func(a *Agent) {
+		a.toolTimeout = d
+	}
+}
+
+// New creates an agent that sends requests with chatter
+func New(chatter Chatter, opts ...Option) *Agent {
+	a := &Agent{
+		chatter:       chatter,
+		funcs:         make(map[string]ToolFunc),
+		maxIterations: defaultMaxIterations,
+		toolTimeout:   defaultToolTimeout,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Register adds a tool the model may call
+func (a *Agent) Register(tool types.Tool, fn ToolFunc) error {
+	if err := tool.Validate(); err != nil {
+		return err
+	}
+	if _, ok := a.funcs[tool.Name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateTool, tool.Name)
+	}
+	a.tools = append(a.tools, tool)
+	a.funcs[tool.Name] = fn
+	return nil
+}
+
+// Result is the outcome of an agent run
+type Result struct {
+	// Response is the final chat response
+	Response *types.ChatResponse
+
+	// Messages is the full transcript, including tool calls and results
+	Messages []types.Message
+
+	// Iterations is the number of chat round trips made
+	Iterations int
+
+	// Usage sums the token usage of every round trip
+	Usage types.Usage
+}
+
+// Run sends req and runs the tool loop until the model produces a final
+// answer. The registered tools are added to req's tools. If the iteration
+// limit is reached, the partial result is returned with ErrMaxIterations.
+func (a *Agent) Run(ctx context.Context, req *types.ChatRequest) (*Result, error) {
+	result := &Result{
+		Messages: append([]types.Message(nil), req.Messages...),
+	}
+
+	for result.Iterations < a.maxIterations {
+		turn := *req
+		turn.Messages = result.Messages
+		// Each turn is a new request; sharing the caller's idempotency key
+		// would have every turn answered with the first turn's response
+		turn.IdempotencyKey = ""
+		turn.Tools = append(append([]types.Tool(nil), req.Tools...), a.tools...)
+
+		resp, err := a.chatter.Chat(ctx, &turn)
+		if err != nil {
+			return result, err
+		}
+		result.Iterations++
+		result.Response = resp
+		result.Usage.PromptTokens += resp.Usage.PromptTokens
+		result.Usage.CompletionTokens += resp.Usage.CompletionTokens
+		result.Usage.TotalTokens += resp.Usage.TotalTokens
+		result.Messages = append(result.Messages, resp.Message)
+
+		if len(resp.Message.ToolCalls) == 0 {
+			return result, nil
+		}
+		result.Messages = append(result.Messages, a.runTools(ctx, resp.Message.ToolCalls)...)
+	}
+
+	return result, fmt.Errorf("%w (%d)", ErrMaxIterations, a.maxIterations)
+}
+
+// runTools runs the tool calls concurrently and returns a tool message for
+// each, in call order. Tool errors are reported to the model rather than
+// failing the run.
+func (a *Agent) runTools(ctx context.Context, calls []types.ToolCall) []types.Message {
+	msgs := make([]types.Message, len(calls))
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		wg.Add(1)
+		go func(i int, call types.ToolCall) {
+			defer wg.Done()
+
+			content, err := a.runTool(ctx, call)
+			if err != nil {
+				content = "error: " + err.Error()
+			} else if content == "" {
+				content = "(no output)"
+			}
+			msgs[i] = types.Message{
+				Role:       types.RoleTool,
+				Content:    content,
+				Name:       call.Name,
+				ToolCallID: call.ID,
+			}
+		}(i, call)
+	}
+	wg.Wait()
+	return msgs
+}
+
+// runTool runs a single tool call with a timeout, recovering from panics. A
+// tool that ignores its context is abandoned when the timeout expires.
+func (a *Agent) runTool(ctx context.Context, call types.ToolCall) (string, error) {
+	fn, ok := a.funcs[call.Name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownTool, call.Name)
+	}
+
+	if a.toolTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.toolTimeout)
+		defer cancel()
+	}
+
+	type outcome struct {
+		content string
+		err     error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- outcome{err: fmt.Errorf("%w: %v", ErrToolPanic, r)}
+			}
+		}()
+		content, err := fn(ctx, json.RawMessage(call.Arguments))
+		done <- outcome{content: content, err: err}
+	}()
+
+	select {
+	case out := <-done:
+		return out.content, out.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,187 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// scriptedChatter replies with each response in turn and records requests
+type scriptedChatter struct {
+	mu        sync.Mutex
+	responses []types.Message
+	requests  []*types.ChatRequest
+}
+
+func (s *scriptedChatter) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, req)
+	msg := s.responses[0]
+	if len(s.responses) > 1 {
+		s.responses = s.responses[1:]
+	}
+	return &types.ChatResponse{
+		Response: types.Response{
+			Message: msg,
+			Usage:   types.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+		},
+	}, nil
+}
+
+func toolCall(id, name, args string) types.Message {
+	return types.Message{
+		Role:      types.RoleAssistant,
+		ToolCalls: []types.ToolCall{{ID: id, Name: name, Arguments: args}},
+	}
+}
+
+func TestAgent_Run(t *testing.T) {
+	chatter := &scriptedChatter{responses: []types.Message{
+		toolCall("call_1", "add", `{"a":2,"b":3}`),
+		{Role: types.RoleAssistant, Content: "2 + 3 = 5"},
+	}}
+
+	type addArgs struct {
+		A int `json:"a"`
+		B int `json:"b"`
+	}
+	a := New(chatter)
+	err := a.Register(types.Tool{Name: "add"}, Typed(func(ctx context.Context, args addArgs) (string, error) {
+		return strings.Repeat("I", args.A+args.B), nil
+	}))
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	res, err := a.Run(context.Background(), &types.ChatRequest{
+		Messages:       []types.Message{{Role: types.RoleUser, Content: "2 + 3?"}},
+		IdempotencyKey: "order-42",
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if res.Response.Message.Content != "2 + 3 = 5" || res.Iterations != 2 {
+		t.Errorf("Run() = %q after %d iterations", res.Response.Message.Content, res.Iterations)
+	}
+	if res.Usage.TotalTokens != 30 {
+		t.Errorf("Usage.TotalTokens = %d, want 30", res.Usage.TotalTokens)
+	}
+	if len(res.Messages) != 4 {
+		t.Fatalf("transcript has %d messages, want 4", len(res.Messages))
+	}
+
+	result := res.Messages[2]
+	if result.Role != types.RoleTool || result.ToolCallID != "call_1" || result.Content != "IIIII" {
+		t.Errorf("tool result = %+v", result)
+	}
+
+	second := chatter.requests[1]
+	if len(second.Tools) != 1 || len(second.Messages) != 3 {
+		t.Errorf("second request tools = %d messages = %d, want 1 and 3", len(second.Tools), len(second.Messages))
+	}
+	if second.IdempotencyKey != "" {
+		t.Errorf("second request idempotency key = %q, want none so it is not de-duplicated", second.IdempotencyKey)
+	}
+}
+
+func TestAgent_ToolFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		call    string
+		fn      ToolFunc
+		wantErr string
+	}{
+		{
+			name:    "tool error",
+			call:    "fail",
+			fn:      func(ctx context.Context, args json.RawMessage) (string, error) { return "", errors.New("boom") },
+			wantErr: "error: boom",
+		},
+		{
+			name:    "panic",
+			call:    "fail",
+			fn:      func(ctx context.Context, args json.RawMessage) (string, error) { panic("oops") },
+			wantErr: ErrToolPanic.Error(),
+		},
+		{
+			name: "timeout",
+			call: "fail",
+			fn: func(ctx context.Context, args json.RawMessage) (string, error) {
+				time.Sleep(200 * time.Millisecond)
+				return "late", nil
+			},
+			wantErr: context.DeadlineExceeded.Error(),
+		},
+		{
+			name:    "unknown tool",
+			call:    "missing",
+			fn:      func(ctx context.Context, args json.RawMessage) (string, error) { return "", nil },
+			wantErr: ErrUnknownTool.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chatter := &scriptedChatter{responses: []types.Message{
+				toolCall("call_1", tt.call, `{}`),
+				{Role: types.RoleAssistant, Content: "done"},
+			}}
+			a := New(chatter, WithToolTimeout(20*time.Millisecond))
+			if err := a.Register(types.Tool{Name: "fail"}, tt.fn); err != nil {
+				t.Fatalf("Register() error = %v", err)
+			}
+
+			res, err := a.Run(context.Background(), &types.ChatRequest{
+				Messages: []types.Message{{Role: types.RoleUser, Content: "go"}},
+			})
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if got := res.Messages[2].Content; !strings.Contains(got, tt.wantErr) {
+				t.Errorf("tool result = %q, want it to contain %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAgent_MaxIterations(t *testing.T) {
+	chatter := &scriptedChatter{responses: []types.Message{toolCall("call_1", "noop", `{}`)}}
+	a := New(chatter, WithMaxIterations(3))
+	a.Register(types.Tool{Name: "noop"}, func(ctx context.Context, args json.RawMessage) (string, error) {
+		return "", nil
+	})
+
+	res, err := a.Run(context.Background(), &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "loop"}},
+	})
+	if !errors.Is(err, ErrMaxIterations) {
+		t.Fatalf("Run() error = %v, want %v", err, ErrMaxIterations)
+	}
+	if res.Iterations != 3 {
+		t.Errorf("Iterations = %d, want 3", res.Iterations)
+	}
+}
+
+func TestAgent_Register(t *testing.T) {
+	a := New(&scriptedChatter{})
+	noop := func(ctx context.Context, args json.RawMessage) (string, error) { return "", nil }
+
+	if err := a.Register(types.Tool{}, noop); !errors.Is(err, types.ErrEmptyToolName) {
+		t.Errorf("Register() error = %v, want %v", err, types.ErrEmptyToolName)
+	}
+	if err := a.Register(types.Tool{Name: "noop"}, noop); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := a.Register(types.Tool{Name: "noop"}, noop); !errors.Is(err, ErrDuplicateTool) {
+		t.Errorf("Register() error = %v, want %v", err, ErrDuplicateTool)
+	}
+}
@@ -0,0 +1,220 @@
+// Package audit records LLM requests and responses for compliance and
+// debugging. An Auditor samples and redacts each interaction before writing
+// it to a Sink such as a JSON lines file, a database table or a webhook.
+package audit
+
+import (
+	"context"
+	"maps"
+	"math/rand"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// Record is a single audited interaction. Exactly one of ChatRequest and
+// CompletionRequest is set.
+type Record struct {
+	Time     time.Time     `json:"time"`
+	Op       string        `json:"op"`
+	Provider string        `json:"provider"`
+	Model    string        `json:"model"`
+	Duration time.Duration `json:"duration"`
+
+	// Metadata is the request's RequestMetadata, such as the user or
+	// feature it was made for
+	Metadata map[string]any `json:"metadata,omitempty"`
+
+	ChatRequest       *types.ChatRequest       `json:"chat_request,omitempty"`
+	CompletionRequest *types.CompletionRequest `json:"completion_request,omitempty"`
+
+	// Response is the final response, or for streams the concatenated
+	// content of the chunks received, even if the stream then failed. It
+	// is nil if the request failed before a response was received.
+	Response *types.Response `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// Sink stores audit records
+type Sink interface {
+	Write(ctx context.Context, rec *Record) error
+}
+
+// SinkFunc adapts a function to the Sink interface
+type SinkFunc func(ctx context.Context, rec *Record) error
+
+// Write calls f(ctx, rec)
+func (f SinkFunc) Write(ctx context.Context, rec *Record) error {
+	return f(ctx, rec)
+}
+
+// Redactor removes sensitive data from a record before it is written. It
+// receives a copy and may modify it freely.
+type Redactor func(rec *Record)
+
+// DefaultTimeout bounds each write to an Auditor's sink unless changed with
+// WithTimeout
+const DefaultTimeout = 5 * time.Second
+
+// Auditor samples, redacts and writes records to a Sink
+type Auditor struct {
+	sink       Sink
+	sampleRate float64
+	timeout    time.Duration
+	redactors  []Redactor
+	onError    func(error)
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// Option configures an Auditor
+type Option func(*Auditor)
+
+// WithSampleRate records only the given fraction of interactions, between 0
+// and 1. Failed requests are always recorded.
+func WithSampleRate(rate float64) Option {
+	return func(a *Auditor) {
+		switch {
+		case rate < 0:
+			rate = 0
+		case rate > 1:
+			rate = 1
+		}
+		a.sampleRate = rate
+	}
+}
+
+// WithRedactor adds a redactor. Redactors run in the order they were added.
+func WithRedactor(r Redactor) Option {
+	return func(a *Auditor) {
+		a.redactors = append(a.redactors, r)
+	}
+}
+
+// WithTimeout bounds each write to the sink, which runs while the audited
+// request returns. A write that takes longer has its context cancelled and
+// is reported to the error handler. Zero removes the bound.
+func WithTimeout(d time.Duration) Option {
+	return func(a *Auditor) {
+		a.timeout = d
+	}
+}
+
+// WithErrorHandler sets a function called when the sink fails. Audit
+// failures never fail the request being audited.
+func WithErrorHandler(fn func(error)) Option {
+	return func(a *Auditor) {
+		a.onError = fn
+	}
+}
+
+// New creates an Auditor that writes every interaction to sink
+func New(sink Sink, opts ...Option) *Auditor {
+	a := &Auditor{
+		sink:       sink,
+		sampleRate: 1,
+		timeout:    DefaultTimeout,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Record samples, redacts and writes rec. rec itself is not modified. The
+// write is bounded by the auditor's timeout, so a slow sink delays the
+// request being audited by at most that long.
+func (a *Auditor) Record(ctx context.Context, rec *Record) {
+	if rec.Error == "" && !a.sampled() {
+		return
+	}
+	if a.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.timeout)
+		defer cancel()
+	}
+
+	rec = clone(rec)
+	for _, redact := range a.redactors {
+		redact(rec)
+	}
+
+	if err := a.sink.Write(ctx, rec); err != nil && a.onError != nil {
+		a.onError(err)
+	}
+}
+
+func (a *Auditor) sampled() bool {
+	if a.sampleRate >= 1 {
+		return true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rand.Float64() < a.sampleRate
+}
+
+// clone copies the parts of rec a redactor may change
+func clone(rec *Record) *Record {
+	out := *rec
+	out.Metadata = maps.Clone(rec.Metadata)
+	out.ChatRequest = rec.ChatRequest.Clone()
+	out.CompletionRequest = rec.CompletionRequest.Clone()
+	if rec.Response != nil {
+		resp := *rec.Response
+		resp.Message = resp.Message.Clone()
+		out.Response = &resp
+	}
+	return &out
+}
+
+// RedactPatterns returns a Redactor that replaces matches of the patterns in
+// system prompts, prompts, messages and responses with "[REDACTED]"
+func RedactPatterns(patterns ...*regexp.Regexp) Redactor {
+	scrub := func(s string) string {
+		for _, p := range patterns {
+			s = p.ReplaceAllString(s, "[REDACTED]")
+		}
+		return s
+	}
+	return func(rec *Record) {
+		if rec.ChatRequest != nil {
+			for i := range rec.ChatRequest.System {
+				rec.ChatRequest.System[i] = scrub(rec.ChatRequest.System[i])
+			}
+			for i := range rec.ChatRequest.Messages {
+				rec.ChatRequest.Messages[i].Content = scrub(rec.ChatRequest.Messages[i].Content)
+			}
+		}
+		if rec.CompletionRequest != nil {
+			rec.CompletionRequest.Prompt = scrub(rec.CompletionRequest.Prompt)
+		}
+		if rec.Response != nil {
+			rec.Response.Message.Content = scrub(rec.Response.Message.Content)
+		}
+		rec.Error = scrub(rec.Error)
+	}
+}
+
+// RedactContent is a Redactor that removes all system prompt, prompt,
+// message and response text, keeping only metadata such as model, timing
+// and token usage
+func RedactContent(rec *Record) {
+	if rec.ChatRequest != nil {
+		for i := range rec.ChatRequest.System {
+			rec.ChatRequest.System[i] = ""
+		}
+		for i := range rec.ChatRequest.Messages {
+			rec.ChatRequest.Messages[i].Content = ""
+		}
+	}
+	if rec.CompletionRequest != nil {
+		rec.CompletionRequest.Prompt = ""
+	}
+	if rec.Response != nil {
+		rec.Response.Message.Content = ""
+	}
+}
@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+func testRecord() *Record {
+	return &Record{
+		Op:       "chat",
+		Provider: "openai",
+		Model:    "gpt-4",
+		ChatRequest: &types.ChatRequest{
+			System:   []string{"the customer's card is 4111-1111-1111-1111"},
+			Messages: []types.Message{{Role: types.RoleUser, Content: "my card is 4111-1111-1111-1111"}},
+		},
+		Response: &types.Response{
+			Message: types.Message{Role: types.RoleAssistant, Content: "noted 4111-1111-1111-1111"},
+		},
+	}
+}
+
+func TestAuditor_Redaction(t *testing.T) {
+	tests := []struct {
+		name     string
+		redactor Redactor
+		wantSys  string
+		wantReq  string
+		wantResp string
+	}{
+		{"patterns", RedactPatterns(regexp.MustCompile(`\d{4}(-\d{4}){3}`)), "the customer's card is [REDACTED]", "my card is [REDACTED]", "noted [REDACTED]"},
+		{"content", RedactContent, "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *Record
+			a := New(SinkFunc(func(ctx context.Context, rec *Record) error {
+				got = rec
+				return nil
+			}), WithRedactor(tt.redactor))
+
+			rec := testRecord()
+			a.Record(context.Background(), rec)
+
+			if got == nil {
+				t.Fatal("record not written")
+			}
+			if c := got.ChatRequest.System[0]; c != tt.wantSys {
+				t.Errorf("system prompt = %q, want %q", c, tt.wantSys)
+			}
+			if c := got.ChatRequest.Messages[0].Content; c != tt.wantReq {
+				t.Errorf("request content = %q, want %q", c, tt.wantReq)
+			}
+			if c := got.Response.Message.Content; c != tt.wantResp {
+				t.Errorf("response content = %q, want %q", c, tt.wantResp)
+			}
+			if c := rec.ChatRequest.Messages[0].Content; c != "my card is 4111-1111-1111-1111" {
+				t.Errorf("original record modified: %q", c)
+			}
+			if c := rec.ChatRequest.System[0]; c != "the customer's card is 4111-1111-1111-1111" {
+				t.Errorf("original system prompt modified: %q", c)
+			}
+		})
+	}
+}
+
+func TestAuditor_Sampling(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  float64
+		err   string
+		wantN int
+	}{
+		{"all", 1, "", 10},
+		{"none", 0, "", 0},
+		{"clamped", -1, "", 0},
+		{"errors always recorded", 0, "boom", 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var n int
+			a := New(SinkFunc(func(ctx context.Context, rec *Record) error {
+				n++
+				return nil
+			}), WithSampleRate(tt.rate))
+
+			for i := 0; i < 10; i++ {
+				rec := testRecord()
+				rec.Error = tt.err
+				a.Record(context.Background(), rec)
+			}
+			if n != tt.wantN {
+				t.Errorf("recorded %d, want %d", n, tt.wantN)
+			}
+		})
+	}
+}
+
+func TestAuditor_ErrorHandler(t *testing.T) {
+	errSink := errors.New("disk full")
+	var got error
+	a := New(SinkFunc(func(ctx context.Context, rec *Record) error {
+		return errSink
+	}), WithErrorHandler(func(err error) { got = err }))
+
+	a.Record(context.Background(), testRecord())
+	if !errors.Is(got, errSink) {
+		t.Errorf("error handler got %v, want %v", got, errSink)
+	}
+}
+
+func TestAuditor_Timeout(t *testing.T) {
+	var got error
+	a := New(SinkFunc(func(ctx context.Context, rec *Record) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}), WithTimeout(10*time.Millisecond), WithErrorHandler(func(err error) { got = err }))
+
+	done := make(chan struct{})
+	go func() {
+		a.Record(context.Background(), testRecord())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Record() did not return after the timeout")
+	}
+	if !errors.Is(got, context.DeadlineExceeded) {
+		t.Errorf("error handler got %v, want %v", got, context.DeadlineExceeded)
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewWriterSink(&buf)
+
+	for i := 0; i < 2; i++ {
+		if err := sink.Write(context.Background(), testRecord()); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	scanner := bufio.NewScanner(&buf)
+	var lines int
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("line %d is not a record: %v", lines, err)
+		}
+		if rec.Model != "gpt-4" {
+			t.Errorf("Model = %q, want gpt-4", rec.Model)
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("got %d lines, want 2", lines)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"accepted", http.StatusAccepted, false},
+		{"rejected", http.StatusInternalServerError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Record
+			var auth string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auth = r.Header.Get("Authorization")
+				json.NewDecoder(r.Body).Decode(&got)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			sink := NewWebhookSink(server.URL, nil, http.Header{"Authorization": {"Bearer token"}})
+			err := sink.Write(context.Background(), testRecord())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Op != "chat" || auth != "Bearer token" {
+				t.Errorf("webhook got op %q, auth %q", got.Op, auth)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// WriterSink writes records to an io.Writer as JSON lines
+type WriterSink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// NewWriterSink creates a sink that writes one JSON record per line to w
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w, enc: json.NewEncoder(w)}
+}
+
+// OpenFile creates a sink appending JSON lines to the file at path, creating
+// it if needed. Close the sink to close the file.
+func OpenFile(path string) (*WriterSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+	return NewWriterSink(f), nil
+}
+
+// Write encodes rec as a single line
+func (s *WriterSink) Write(ctx context.Context, rec *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(rec)
+}
+
+// Close closes the underlying writer if it is an io.Closer
+func (s *WriterSink) Close() error {
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// WebhookSink POSTs each record as JSON to a URL
+type WebhookSink struct {
+	url    string
+	client *http.Client
+	header http.Header
+}
+
+// NewWebhookSink creates a sink posting records to url. A nil client uses
+// http.DefaultClient. header, which may be nil, is added to each request.
+func NewWebhookSink(url string, client *http.Client, header http.Header) *WebhookSink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &WebhookSink{url: url, client: client, header: header}
+}
+
+// Write posts rec, failing on any non-2xx response
+func (s *WebhookSink) Write(ctx context.Context, rec *Record) error {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encoding audit record: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating audit request: %w", err)
+	}
+	for k, v := range s.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending audit record: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SQLSink inserts records into a database table. It uses "?" placeholders,
+// as SQLite and MySQL drivers expect. The driver is chosen by the caller,
+// which keeps this package free of database dependencies.
+type SQLSink struct {
+	db    *sql.DB
+	table string
+}
+
+// NewSQLSink creates a sink writing to table in db. Call CreateTable to
+// create the table if it does not exist.
+func NewSQLSink(db *sql.DB, table string) *SQLSink {
+	return &SQLSink{db: db, table: table}
+}
+
+// CreateTable creates the audit table if it does not exist
+func (s *SQLSink) CreateTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.table+` (
+		time TIMESTAMP NOT NULL,
+		op TEXT NOT NULL,
+		provider TEXT NOT NULL,
+		model TEXT NOT NULL,
+		duration_ms INTEGER NOT NULL,
+		error TEXT,
+		record TEXT NOT NULL
+	)`)
+	return err
+}
+
+// Write inserts rec, storing the full record as JSON alongside indexed
+// columns for common queries
+func (s *SQLSink) Write(ctx context.Context, rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encoding audit record: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO `+s.table+` (time, op, provider, model, duration_ms, error, record) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		rec.Time, rec.Op, rec.Provider, rec.Model, rec.Duration.Milliseconds(), rec.Error, string(data),
+	)
+	return err
+}
@@ -0,0 +1,223 @@
+// Package benchmark measures the latency and throughput of providers and
+// models by streaming the same request to each of them repeatedly.
+//
+//	results, err := benchmark.Run(ctx, []benchmark.Target{
+//		{Provider: "openai", Model: "gpt-4o", Client: openaiClient},
+//		{Provider: "anthropic", Model: "claude-3-5-sonnet-20241022", Client: anthropicClient},
+//	}, benchmark.Config{Runs: 20, Warmup: 2, Request: req})
+//	benchmark.WriteMarkdown(os.Stdout, results)
+package benchmark
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ksred/llm/internal/tokenizer"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// Streamer streams chat responses. *client.Client satisfies this interface.
+type Streamer interface {
+	StreamChat(ctx context.Context, req *types.ChatRequest) (<-chan *types.ChatResponse, error)
+}
+
+// Target is a provider and model to benchmark
+type Target struct {
+	Provider string
+	Model    string
+	Client   Streamer
+}
+
+// Config controls a benchmark run
+type Config struct {
+	// Runs is the number of measured requests per target; zero means 10
+	Runs int
+	// Warmup is the number of requests per target sent before measuring,
+	// to open connections and warm provider caches
+	Warmup int
+	// Request is sent on every run
+	Request *types.ChatRequest
+	// Timeout bounds each request; zero means no limit beyond ctx
+	Timeout time.Duration
+	// OnSample, if set, is called after each measured request
+	OnSample func(target Target, sample Sample)
+}
+
+// Sample is the measurement of one request
+type Sample struct {
+	// TTFT is the time to the first chunk with content
+	TTFT    time.Duration
+	Latency time.Duration
+	// CompletionTokens is the provider's count, or an estimate when the
+	// stream reports no usage
+	CompletionTokens int
+	Err              error
+}
+
+// tokensPerSecond is the generation rate after the first token
+func (r Sample) tokensPerSecond() float64 {
+	generating := (r.Latency - r.TTFT).Seconds()
+	if generating <= 0 || r.CompletionTokens == 0 {
+		return 0
+	}
+	return float64(r.CompletionTokens) / generating
+}
+
+// Stats summarizes a measurement over the successful runs
+type Stats struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P95  float64 `json:"p95"`
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+}
+
+// Result is the benchmark of one target
+type Result struct {
+	Provider  string  `json:"provider"`
+	Model     string  `json:"model"`
+	Runs      int     `json:"runs"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+
+	TTFTMillis      Stats `json:"ttft_ms"`
+	LatencyMillis   Stats `json:"latency_ms"`
+	TokensPerSecond Stats `json:"tokens_per_second"`
+}
+
+// Run benchmarks each target in turn. Targets are not run concurrently so
+// they do not compete for bandwidth. Failed requests count towards the
+// error rate and are left out of the timings.
+func Run(ctx context.Context, targets []Target, cfg Config) ([]Result, error) {
+	if cfg.Request == nil {
+		return nil, errors.New("benchmark request is required")
+	}
+	if cfg.Runs <= 0 {
+		cfg.Runs = 10
+	}
+
+	results := make([]Result, 0, len(targets))
+	for _, target := range targets {
+		for i := 0; i < cfg.Warmup; i++ {
+			measure(ctx, target, cfg)
+		}
+
+		runs := make([]Sample, 0, cfg.Runs)
+		for i := 0; i < cfg.Runs; i++ {
+			if err := ctx.Err(); err != nil {
+				return results, err
+			}
+			run := measure(ctx, target, cfg)
+			runs = append(runs, run)
+			if cfg.OnSample != nil {
+				cfg.OnSample(target, run)
+			}
+		}
+		results = append(results, summarize(target, runs))
+	}
+	return results, nil
+}
+
+// measure streams the request once and times it
+func measure(ctx context.Context, target Target, cfg Config) Sample {
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	// Each run gets its own copy so clients cannot carry state between runs
+	req := *cfg.Request
+	start := time.Now()
+	chunks, err := target.Client.StreamChat(ctx, &req)
+	if err != nil {
+		return Sample{Latency: time.Since(start), Err: err}
+	}
+
+	var (
+		run        Sample
+		content    strings.Builder
+		completion int
+	)
+	for chunk := range chunks {
+		if chunk.Error != nil {
+			run.Err = chunk.Error
+			continue
+		}
+		if run.TTFT == 0 && chunk.Message.Content != "" {
+			run.TTFT = time.Since(start)
+		}
+		content.WriteString(chunk.Message.Content)
+		if chunk.Usage.CompletionTokens > 0 {
+			completion = chunk.Usage.CompletionTokens
+		}
+	}
+	run.Latency = time.Since(start)
+
+	if completion == 0 {
+		completion = tokenizer.Count(content.String())
+	}
+	run.CompletionTokens = completion
+	return run
+}
+
+// summarize turns a target's runs into its result
+func summarize(target Target, runs []Sample) Result {
+	res := Result{Provider: target.Provider, Model: target.Model, Runs: len(runs)}
+
+	var ttft, latency, tps []float64
+	for _, r := range runs {
+		if r.Err != nil {
+			res.Errors++
+			continue
+		}
+		ttft = append(ttft, millis(r.TTFT))
+		latency = append(latency, millis(r.Latency))
+		tps = append(tps, r.tokensPerSecond())
+	}
+	if res.Runs > 0 {
+		res.ErrorRate = float64(res.Errors) / float64(res.Runs)
+	}
+	res.TTFTMillis = stats(ttft)
+	res.LatencyMillis = stats(latency)
+	res.TokensPerSecond = stats(tps)
+	return res
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// stats summarizes values, using nearest-rank percentiles
+func stats(values []float64) Stats {
+	if len(values) == 0 {
+		return Stats{}
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	return Stats{
+		Mean: sum / float64(len(sorted)),
+		P50:  percentile(sorted, 50),
+		P95:  percentile(sorted, 95),
+		Min:  sorted[0],
+		Max:  sorted[len(sorted)-1],
+	}
+}
+
+// percentile returns the nearest-rank pth percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,126 @@
+package benchmark
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// fakeStreamer streams two chunks a few milliseconds apart, failing every
+// failEvery-th request
+type fakeStreamer struct {
+	calls     int
+	failEvery int
+}
+
+func (f *fakeStreamer) StreamChat(ctx context.Context, _ *types.ChatRequest) (<-chan *types.ChatResponse, error) {
+	f.calls++
+	if f.failEvery > 0 && f.calls%f.failEvery == 0 {
+		return nil, types.ErrRateLimitExceeded
+	}
+
+	ch := make(chan *types.ChatResponse)
+	go func() {
+		defer close(ch)
+		time.Sleep(2 * time.Millisecond)
+		ch <- &types.ChatResponse{Response: types.Response{Message: types.Message{Content: "Hello"}}}
+		time.Sleep(5 * time.Millisecond)
+		ch <- &types.ChatResponse{Response: types.Response{
+			Message: types.Message{Content: " world"},
+			Usage:   types.Usage{CompletionTokens: 10},
+		}}
+	}()
+	return ch, nil
+}
+
+func TestRun(t *testing.T) {
+	flaky := &fakeStreamer{failEvery: 2}
+	steady := &fakeStreamer{}
+	req := types.NewChatRequest([]types.Message{{Role: types.RoleUser, Content: "Hi"}})
+
+	var measured int
+	results, err := Run(context.Background(), []Target{
+		{Provider: "openai", Model: "gpt-4o", Client: steady},
+		{Provider: "anthropic", Model: "claude", Client: flaky},
+	}, Config{
+		Runs:     4,
+		Warmup:   1,
+		Request:  req,
+		OnSample: func(Target, Sample) { measured++ },
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if steady.calls != 5 || measured != 8 {
+		t.Errorf("calls = %d, measured = %d, want 5 calls including warmup and 8 measured runs", steady.calls, measured)
+	}
+	if len(results) != 2 {
+		t.Fatalf("results = %d, want 2", len(results))
+	}
+
+	r := results[0]
+	if r.Runs != 4 || r.Errors != 0 {
+		t.Errorf("steady result = %+v, want 4 runs without errors", r)
+	}
+	if r.TTFTMillis.Min < 2 || r.LatencyMillis.Min < 7 || r.TTFTMillis.P50 >= r.LatencyMillis.P50 {
+		t.Errorf("timings = TTFT %+v, latency %+v", r.TTFTMillis, r.LatencyMillis)
+	}
+	if r.TokensPerSecond.Mean <= 0 || r.TokensPerSecond.Max > 10/0.005 {
+		t.Errorf("tokens per second = %+v, want at most 2000", r.TokensPerSecond)
+	}
+
+	// Warmup took the first call, so measured calls 2 and 4 fail
+	if results[1].Errors != 2 || results[1].ErrorRate != 0.5 {
+		t.Errorf("flaky result = %+v, want a 50%% error rate", results[1])
+	}
+
+	if _, err := Run(context.Background(), nil, Config{}); err == nil {
+		t.Error("Run() without a request error = nil")
+	}
+}
+
+func TestStats(t *testing.T) {
+	s := stats([]float64{5, 1, 4, 2, 3, 6, 7, 8, 9, 10})
+	want := Stats{Mean: 5.5, P50: 5, P95: 10, Min: 1, Max: 10}
+	if s != want {
+		t.Errorf("stats() = %+v, want %+v", s, want)
+	}
+	if s := stats(nil); s != (Stats{}) {
+		t.Errorf("stats(nil) = %+v, want zero", s)
+	}
+}
+
+func TestReports(t *testing.T) {
+	results := []Result{{
+		Provider: "openai", Model: "gpt-4o", Runs: 10, Errors: 1, ErrorRate: 0.1,
+		TTFTMillis:      Stats{P50: 250, P95: 400},
+		LatencyMillis:   Stats{P50: 1200, P95: 1800},
+		TokensPerSecond: Stats{Mean: 85.25},
+	}}
+
+	var md bytes.Buffer
+	if err := WriteMarkdown(&md, results); err != nil {
+		t.Fatalf("WriteMarkdown() error = %v", err)
+	}
+	if want := "| openai | gpt-4o | 10 | 1 (10%) | 250 | 400 | 1200 | 1800 | 85.2 |"; !strings.Contains(md.String(), want) {
+		t.Errorf("WriteMarkdown() = %q, want row %q", md.String(), want)
+	}
+
+	var js bytes.Buffer
+	if err := WriteJSON(&js, results); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	var decoded []Result
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatalf("decoding JSON: %v", err)
+	}
+	if len(decoded) != 1 || decoded[0].TTFTMillis.P95 != 400 {
+		t.Errorf("WriteJSON() round trip = %+v", decoded)
+	}
+}
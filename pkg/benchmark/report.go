@@ -0,0 +1,34 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// WriteJSON writes the results as an indented JSON array
+func WriteJSON(w io.Writer, results []Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+// WriteMarkdown writes the results as a markdown table, one row per target
+func WriteMarkdown(w io.Writer, results []Result) error {
+	if _, err := fmt.Fprintln(w, "| Provider | Model | Runs | Errors | TTFT p50 (ms) | TTFT p95 (ms) | Latency p50 (ms) | Latency p95 (ms) | Tokens/s (mean) |"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(w, "|---|---|---:|---:|---:|---:|---:|---:|---:|"); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if _, err := fmt.Fprintf(w, "| %s | %s | %d | %d (%.0f%%) | %.0f | %.0f | %.0f | %.0f | %.1f |\n",
+			r.Provider, r.Model, r.Runs, r.Errors, r.ErrorRate*100,
+			r.TTFTMillis.P50, r.TTFTMillis.P95,
+			r.LatencyMillis.P50, r.LatencyMillis.P95,
+			r.TokensPerSecond.Mean); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// ErrMiss is returned by Get when no live entry exists for a key
+var ErrMiss = errors.New("cache miss")
+
+// Cache stores chat responses keyed by request
+type Cache interface {
+	// Get returns the cached response for key, or ErrMiss
+	Get(ctx context.Context, key string) (*types.ChatResponse, error)
+
+	// Set stores a response under key
+	Set(ctx context.Context, key string, resp *types.ChatResponse) error
+}
+
+// keyFields holds the parts of a request that affect the response
+type keyFields struct {
+	Provider         string          `json:"provider"`
+	Model            string          `json:"model"`
+	System           []string        `json:"system,omitempty"`
+	Messages         []types.Message `json:"messages"`
+	MaxTokens        int             `json:"max_tokens"`
+	Temperature      *float32        `json:"temperature"`
+	TopP             *float32        `json:"top_p"`
+	Stop             []string        `json:"stop"`
+	PresencePenalty  float32         `json:"presence_penalty"`
+	FrequencyPenalty float32         `json:"frequency_penalty"`
+	Tools            []types.Tool    `json:"tools,omitempty"`
+	ProviderParams   map[string]any  `json:"provider_params"`
+}
+
+// Key derives a cache key from the provider, model, messages and sampling
+// parameters of a request. User and RequestMetadata are ignored.
+func Key(provider, model string, req *types.ChatRequest) (string, error) {
+	data, err := json.Marshal(keyFields{
+		Provider:         provider,
+		Model:            model,
+		System:           req.System,
+		Messages:         req.Messages,
+		MaxTokens:        req.MaxTokens,
+		Temperature:      req.Temperature,
+		TopP:             req.TopP,
+		Stop:             req.Stop,
+		PresencePenalty:  req.PresencePenalty,
+		FrequencyPenalty: req.FrequencyPenalty,
+		Tools:            req.Tools,
+		ProviderParams:   req.ProviderParams,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+func testRequest(content string) *types.ChatRequest {
+	return &types.ChatRequest{
+		Messages: []types.Message{
+			{Role: types.RoleUser, Content: content},
+		},
+		Temperature: types.Float32(0.5),
+	}
+}
+
+func testResponse(content string) *types.ChatResponse {
+	return &types.ChatResponse{
+		Response: types.Response{
+			ID:       "test-id",
+			Provider: "openai",
+			Model:    "gpt-4",
+			Message:  types.Message{Role: types.RoleAssistant, Content: content},
+		},
+	}
+}
+
+func TestKey(t *testing.T) {
+	base, err := Key("openai", "gpt-4", testRequest("Hello"))
+	if err != nil {
+		t.Fatalf("Key() error = %v", err)
+	}
+
+	same := testRequest("Hello")
+	same.User = "user-1"
+	same.RequestMetadata = map[string]any{"feature": "chat"}
+
+	differentParams := testRequest("Hello")
+	differentParams.Temperature = types.Float32(0.9)
+
+	differentSystem := testRequest("Hello")
+	differentSystem.System = []string{"Be brief."}
+
+	tests := []struct {
+		name     string
+		provider string
+		model    string
+		req      *types.ChatRequest
+		wantSame bool
+	}{
+		{"identical request", "openai", "gpt-4", testRequest("Hello"), true},
+		{"user and metadata ignored", "openai", "gpt-4", same, true},
+		{"different message", "openai", "gpt-4", testRequest("Goodbye"), false},
+		{"different params", "openai", "gpt-4", differentParams, false},
+		{"different system", "openai", "gpt-4", differentSystem, false},
+		{"different model", "openai", "gpt-4o", testRequest("Hello"), false},
+		{"different provider", "anthropic", "gpt-4", testRequest("Hello"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Key(tt.provider, tt.model, tt.req)
+			if err != nil {
+				t.Fatalf("Key() error = %v", err)
+			}
+			if (got == base) != tt.wantSame {
+				t.Errorf("Key() same = %v, want %v", got == base, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(50 * time.Millisecond)
+
+	if _, err := c.Get(ctx, "key"); !errors.Is(err, ErrMiss) {
+		t.Fatalf("Get() on empty cache error = %v, want %v", err, ErrMiss)
+	}
+
+	if err := c.Set(ctx, "key", testResponse("Hi")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	got, err := c.Get(ctx, "key")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Message.Content != "Hi" {
+		t.Errorf("Get() content = %q, want %q", got.Message.Content, "Hi")
+	}
+
+	// Mutating the returned value must not affect the cached entry
+	got.Message.Content = "changed"
+	again, _ := c.Get(ctx, "key")
+	if again.Message.Content != "Hi" {
+		t.Errorf("cached entry was mutated: %q", again.Message.Content)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := c.Get(ctx, "key"); !errors.Is(err, ErrMiss) {
+		t.Errorf("Get() after TTL error = %v, want %v", err, ErrMiss)
+	}
+}
+
+type fakeRedis struct {
+	data map[string][]byte
+	ttl  time.Duration
+}
+
+func (f *fakeRedis) Get(ctx context.Context, key string) ([]byte, error) {
+	v, ok := f.data[key]
+	if !ok {
+		return nil, ErrMiss
+	}
+	return v, nil
+}
+
+func (f *fakeRedis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	f.data[key] = value
+	f.ttl = ttl
+	return nil
+}
+
+func TestRedisCache(t *testing.T) {
+	ctx := context.Background()
+	backend := &fakeRedis{data: make(map[string][]byte)}
+	c := NewRedisCache(backend, "llm:", time.Minute)
+
+	if err := c.Set(ctx, "key", testResponse("Hi")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, ok := backend.data["llm:key"]; !ok {
+		t.Error("Set() did not apply key prefix")
+	}
+	if backend.ttl != time.Minute {
+		t.Errorf("Set() ttl = %v, want %v", backend.ttl, time.Minute)
+	}
+
+	got, err := c.Get(ctx, "key")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Message.Content != "Hi" || got.Model != "gpt-4" {
+		t.Errorf("Get() = %+v", got.Response)
+	}
+
+	if _, err := c.Get(ctx, "missing"); !errors.Is(err, ErrMiss) {
+		t.Errorf("Get() missing error = %v, want %v", err, ErrMiss)
+	}
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+type memoryEntry struct {
+	resp    types.ChatResponse
+	expires time.Time
+}
+
+// MemoryCache is an in-process Cache with a fixed TTL
+type MemoryCache struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastPurge time.Time
+}
+
+// NewMemoryCache creates an in-memory cache whose entries expire after ttl
+func NewMemoryCache(ttl time.Duration) *MemoryCache {
+	return &MemoryCache{
+		ttl:       ttl,
+		entries:   make(map[string]memoryEntry),
+		lastPurge: time.Now(),
+	}
+}
+
+// Get returns a copy of the cached response for key
+func (c *MemoryCache) Get(ctx context.Context, key string) (*types.ChatResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, ErrMiss
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, ErrMiss
+	}
+
+	resp := entry.resp
+	return &resp, nil
+}
+
+// Set stores a copy of resp under key
+func (c *MemoryCache) Set(ctx context.Context, key string, resp *types.ChatResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastPurge) > c.ttl {
+		c.purge(now)
+	}
+
+	c.entries[key] = memoryEntry{
+		resp:    *resp,
+		expires: now.Add(c.ttl),
+	}
+	return nil
+}
+
+// Len returns the number of entries currently held, including expired ones
+// that have not yet been purged
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// purge removes expired entries. Callers must hold c.mu.
+func (c *MemoryCache) purge(now time.Time) {
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.lastPurge = now
+}
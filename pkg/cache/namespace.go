@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// Namespace returns a view of c whose entries are kept apart from those of
+// every other namespace, so clients sharing one cache, such as one client
+// per tenant, never see each other's responses. A RequestCache stays a
+// RequestCache, matching only requests of the same namespace.
+func Namespace(c Cache, ns string) Cache {
+	n := namespaced{cache: c, prefix: ns + "\x00"}
+	if rc, ok := c.(RequestCache); ok {
+		return namespacedRequests{namespaced: n, requests: rc}
+	}
+	return n
+}
+
+type namespaced struct {
+	cache  Cache
+	prefix string
+}
+
+func (n namespaced) Get(ctx context.Context, key string) (*types.ChatResponse, error) {
+	return n.cache.Get(ctx, n.prefix+key)
+}
+
+func (n namespaced) Set(ctx context.Context, key string, resp *types.ChatResponse) error {
+	return n.cache.Set(ctx, n.prefix+key, resp)
+}
+
+// namespacedRequests scopes a RequestCache by folding the namespace into the
+// provider, which request caches match on
+type namespacedRequests struct {
+	namespaced
+	requests RequestCache
+}
+
+func (n namespacedRequests) Lookup(ctx context.Context, provider, model string, req *types.ChatRequest) (*types.ChatResponse, error) {
+	return n.requests.Lookup(ctx, n.prefix+provider, model, req)
+}
+
+func (n namespacedRequests) Store(ctx context.Context, provider, model string, req *types.ChatRequest, resp *types.ChatResponse) error {
+	return n.requests.Store(ctx, n.prefix+provider, model, req, resp)
+}
@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNamespace(t *testing.T) {
+	ctx := context.Background()
+	shared := NewMemoryCache(time.Minute)
+	a, b := Namespace(shared, "a"), Namespace(shared, "b")
+
+	if err := a.Set(ctx, "key", testResponse("A")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, err := b.Get(ctx, "key"); !errors.Is(err, ErrMiss) {
+		t.Errorf("other namespace Get() error = %v, want ErrMiss", err)
+	}
+	if _, err := shared.Get(ctx, "key"); !errors.Is(err, ErrMiss) {
+		t.Errorf("unscoped Get() error = %v, want ErrMiss", err)
+	}
+	if resp, err := a.Get(ctx, "key"); err != nil || resp.Message.Content != "A" {
+		t.Errorf("Get() = %v, %v, want A", resp, err)
+	}
+
+	semantic := NewSemanticCache(&wordEmbedder{vocab: []string{"reset", "password"}}, 0.9, time.Minute)
+	sa, ok := Namespace(semantic, "a").(RequestCache)
+	if !ok {
+		t.Fatal("namespaced SemanticCache is not a RequestCache")
+	}
+	sb := Namespace(semantic, "b").(RequestCache)
+	if err := sa.Store(ctx, "openai", "gpt-4", testRequest("reset my password"), testResponse("A")); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if _, err := sb.Lookup(ctx, "openai", "gpt-4", testRequest("reset password")); !errors.Is(err, ErrMiss) {
+		t.Errorf("other namespace Lookup() error = %v, want ErrMiss", err)
+	}
+	if resp, err := sa.Lookup(ctx, "openai", "gpt-4", testRequest("reset password")); err != nil || resp.Message.Content != "A" {
+		t.Errorf("Lookup() = %v, %v, want A", resp, err)
+	}
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// RedisClient is the subset of a Redis client used by RedisCache. It is
+// satisfied by a thin adapter over go-redis or any other driver, which keeps
+// this package free of a Redis dependency:
+//
+//	type goRedis struct{ *redis.Client }
+//
+//	func (r goRedis) Get(ctx context.Context, key string) ([]byte, error) {
+//		b, err := r.Client.Get(ctx, key).Bytes()
+//		if errors.Is(err, redis.Nil) {
+//			return nil, cache.ErrMiss
+//		}
+//		return b, err
+//	}
+//
+//	func (r goRedis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+//		return r.Client.Set(ctx, key, value, ttl).Err()
+//	}
+type RedisClient interface {
+	// Get returns the value for key, or ErrMiss if it does not exist
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Set stores value under key with the given expiry
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// RedisCache is a Cache backed by Redis, suitable for sharing between replicas
+type RedisCache struct {
+	client RedisClient
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedisCache creates a Redis-backed cache. Keys are namespaced with prefix
+// and expire after ttl.
+func NewRedisCache(client RedisClient, prefix string, ttl time.Duration) *RedisCache {
+	return &RedisCache{
+		client: client,
+		prefix: prefix,
+		ttl:    ttl,
+	}
+}
+
+// Get returns the cached response for key
+func (c *RedisCache) Get(ctx context.Context, key string) (*types.ChatResponse, error) {
+	data, err := c.client.Get(ctx, c.prefix+key)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp types.ChatResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("decoding cached response: %w", err)
+	}
+	return &resp, nil
+}
+
+// Set stores resp under key
+func (c *RedisCache) Set(ctx context.Context, key string, resp *types.ChatResponse) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("encoding response: %w", err)
+	}
+	return c.client.Set(ctx, c.prefix+key, data, c.ttl)
+}
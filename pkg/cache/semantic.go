@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// Embedder converts text into an embedding vector
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// EmbedderFunc adapts a function to the Embedder interface
+type EmbedderFunc func(ctx context.Context, text string) ([]float32, error)
+
+// Embed calls f(ctx, text)
+func (f EmbedderFunc) Embed(ctx context.Context, text string) ([]float32, error) {
+	return f(ctx, text)
+}
+
+// RequestCache is implemented by caches that match on request content rather
+// than on the exact key alone. The client prefers these methods when present.
+type RequestCache interface {
+	Cache
+
+	// Lookup returns a cached response for req, or ErrMiss
+	Lookup(ctx context.Context, provider, model string, req *types.ChatRequest) (*types.ChatResponse, error)
+
+	// Store caches resp as the answer to req
+	Store(ctx context.Context, provider, model string, req *types.ChatRequest, resp *types.ChatResponse) error
+}
+
+// DefaultSemanticMaxEntries bounds the number of embeddings a SemanticCache keeps
+const DefaultSemanticMaxEntries = 1000
+
+type semanticEntry struct {
+	scope     string
+	embedding []float32
+	norm      float64
+	resp      types.ChatResponse
+	expires   time.Time
+}
+
+// SemanticCache returns cached answers for prompts that are similar, not
+// just identical, to earlier ones. Exact matches are served without calling
+// the embedder. Similarity is only compared between requests for the same
+// provider, model and sampling parameters.
+type SemanticCache struct {
+	embedder   Embedder
+	threshold  float64
+	ttl        time.Duration
+	maxEntries int
+	exact      *MemoryCache
+
+	mu      sync.Mutex
+	entries []semanticEntry
+}
+
+// NewSemanticCache creates a semantic cache that returns a cached response
+// when the cosine similarity between prompts is at least threshold
+func NewSemanticCache(embedder Embedder, threshold float64, ttl time.Duration) *SemanticCache {
+	return &SemanticCache{
+		embedder:   embedder,
+		threshold:  threshold,
+		ttl:        ttl,
+		maxEntries: DefaultSemanticMaxEntries,
+		exact:      NewMemoryCache(ttl),
+	}
+}
+
+// WithMaxEntries sets the maximum number of embeddings retained. The oldest
+// entries are evicted first.
+func (c *SemanticCache) WithMaxEntries(n int) *SemanticCache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxEntries = n
+	return c
+}
+
+// Get performs an exact-key lookup
+func (c *SemanticCache) Get(ctx context.Context, key string) (*types.ChatResponse, error) {
+	return c.exact.Get(ctx, key)
+}
+
+// Set stores resp for exact-key lookups only
+func (c *SemanticCache) Set(ctx context.Context, key string, resp *types.ChatResponse) error {
+	return c.exact.Set(ctx, key, resp)
+}
+
+// Lookup returns the cached response for the most similar earlier prompt
+func (c *SemanticCache) Lookup(ctx context.Context, provider, model string, req *types.ChatRequest) (*types.ChatResponse, error) {
+	key, err := Key(provider, model, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp, err := c.exact.Get(ctx, key); err == nil {
+		return resp, nil
+	}
+
+	scope, err := scopeKey(provider, model, req)
+	if err != nil {
+		return nil, err
+	}
+
+	embedding, err := c.embedder.Embed(ctx, promptText(req))
+	if err != nil {
+		return nil, err
+	}
+	norm := vectorNorm(embedding)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	best := -1
+	bestScore := c.threshold
+	for i, entry := range c.entries {
+		if entry.scope != scope || now.After(entry.expires) {
+			continue
+		}
+		score := cosineSimilarity(embedding, norm, entry.embedding, entry.norm)
+		if score >= bestScore {
+			best = i
+			bestScore = score
+		}
+	}
+	if best < 0 {
+		return nil, ErrMiss
+	}
+
+	resp := c.entries[best].resp
+	return &resp, nil
+}
+
+// Store caches resp for both exact and semantic lookups
+func (c *SemanticCache) Store(ctx context.Context, provider, model string, req *types.ChatRequest, resp *types.ChatResponse) error {
+	key, err := Key(provider, model, req)
+	if err != nil {
+		return err
+	}
+	if err := c.exact.Set(ctx, key, resp); err != nil {
+		return err
+	}
+
+	scope, err := scopeKey(provider, model, req)
+	if err != nil {
+		return err
+	}
+
+	embedding, err := c.embedder.Embed(ctx, promptText(req))
+	if err != nil {
+		return err
+	}
+	if len(embedding) == 0 {
+		return errors.New("embedder returned an empty vector")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	live := c.entries[:0]
+	for _, entry := range c.entries {
+		if now.Before(entry.expires) {
+			live = append(live, entry)
+		}
+	}
+	c.entries = live
+
+	if c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		c.entries = c.entries[len(c.entries)-c.maxEntries+1:]
+	}
+
+	c.entries = append(c.entries, semanticEntry{
+		scope:     scope,
+		embedding: embedding,
+		norm:      vectorNorm(embedding),
+		resp:      *resp,
+		expires:   now.Add(c.ttl),
+	})
+	return nil
+}
+
+// scopeKey identifies the provider, model and parameters of a request,
+// ignoring its messages
+func scopeKey(provider, model string, req *types.ChatRequest) (string, error) {
+	scoped := *req
+	scoped.Messages = nil
+	return Key(provider, model, &scoped)
+}
+
+// promptText flattens the conversation into the text that is embedded
+func promptText(req *types.ChatRequest) string {
+	var b strings.Builder
+	for _, msg := range req.Messages {
+		b.WriteString(string(msg.Role))
+		b.WriteString(": ")
+		b.WriteString(msg.Content)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func vectorNorm(v []float32) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	return math.Sqrt(sum)
+}
+
+func cosineSimilarity(a []float32, normA float64, b []float32, normB float64) float64 {
+	if len(a) != len(b) || normA == 0 || normB == 0 {
+		return 0
+	}
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot / (normA * normB)
+}
@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wordEmbedder embeds text as counts over a fixed vocabulary
+type wordEmbedder struct {
+	vocab []string
+	calls int
+}
+
+func (e *wordEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	e.calls++
+	text = strings.ToLower(text)
+	v := make([]float32, len(e.vocab))
+	for i, word := range e.vocab {
+		v[i] = float32(strings.Count(text, word))
+	}
+	return v, nil
+}
+
+func TestSemanticCache(t *testing.T) {
+	ctx := context.Background()
+	embedder := &wordEmbedder{vocab: []string{"reset", "password", "refund", "order", "how"}}
+	c := NewSemanticCache(embedder, 0.9, time.Minute)
+
+	if err := c.Store(ctx, "openai", "gpt-4", testRequest("How do I reset my password?"), testResponse("Use the reset link.")); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		model   string
+		prompt  string
+		wantErr error
+	}{
+		{"similar prompt", "gpt-4", "how to reset password", nil},
+		{"unrelated prompt", "gpt-4", "Where is my refund for order 42?", ErrMiss},
+		{"different model", "gpt-4o", "how to reset password", ErrMiss},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.Lookup(ctx, "openai", tt.model, testRequest(tt.prompt))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Lookup() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got.Message.Content != "Use the reset link." {
+				t.Errorf("Lookup() content = %q", got.Message.Content)
+			}
+		})
+	}
+}
+
+func TestSemanticCache_ExactHitSkipsEmbedding(t *testing.T) {
+	ctx := context.Background()
+	embedder := &wordEmbedder{vocab: []string{"hello"}}
+	c := NewSemanticCache(embedder, 0.9, time.Minute)
+
+	req := testRequest("Hello")
+	if err := c.Store(ctx, "openai", "gpt-4", req, testResponse("Hi")); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	calls := embedder.calls
+
+	if _, err := c.Lookup(ctx, "openai", "gpt-4", req); err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if embedder.calls != calls {
+		t.Errorf("exact hit called embedder %d times", embedder.calls-calls)
+	}
+}
@@ -0,0 +1,168 @@
+package conversation
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ksred/llm/pkg/models"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// defaultReservedTokens is held back for the completion when the model's
+// maximum output is unknown
+const defaultReservedTokens = 1024
+
+// Chatter sends a chat request. *client.Client satisfies this interface.
+type Chatter interface {
+	Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error)
+}
+
+// Conversation keeps a message history and trims it to the context window of
+// its model before each request. The model should match the one the Chatter
+// is configured with.
+type Conversation struct {
+	mu       sync.Mutex
+	model    string
+	messages []types.Message
+	strategy TruncationStrategy
+	budget   int
+	reserved int
+
+	summarizer *Summarizer
+}
+
+// Option configures a Conversation
+type Option func(*Conversation)
+
+// WithStrategy sets the truncation strategy. The default is SlidingWindow.
+func WithStrategy(s TruncationStrategy) Option {
+	return func(c *Conversation) {
+		c.strategy = s
+	}
+}
+
+// WithTokenBudget sets the prompt token budget explicitly, overriding the
+// model's context window
+func WithTokenBudget(tokens int) Option {
+	return func(c *Conversation) {
+		c.budget = tokens
+	}
+}
+
+// WithReservedTokens sets how many tokens of the context window are held back
+// for the completion
+func WithReservedTokens(tokens int) Option {
+	return func(c *Conversation) {
+		c.reserved = tokens
+	}
+}
+
+// New creates an empty conversation for model
+func New(model string, opts ...Option) *Conversation {
+	c := &Conversation{
+		model:    model,
+		strategy: SlidingWindow{},
+		reserved: -1,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Budget returns the prompt token budget, or 0 if it is unknown and the
+// history is never truncated
+func (c *Conversation) Budget() int {
+	if c.budget > 0 {
+		return c.budget
+	}
+
+	m, ok := models.Lookup(c.model)
+	if !ok {
+		return 0
+	}
+
+	reserved := c.reserved
+	if reserved < 0 {
+		reserved = defaultReservedTokens
+		if m.MaxOutputTokens > 0 && m.MaxOutputTokens < reserved {
+			reserved = m.MaxOutputTokens
+		}
+	}
+	if budget := m.ContextWindow - reserved; budget > 0 {
+		return budget
+	}
+	return m.ContextWindow
+}
+
+// Add appends messages to the history
+func (c *Conversation) Add(msgs ...types.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, msgs...)
+}
+
+// History returns a copy of the full, untruncated history
+func (c *Conversation) History() []types.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]types.Message(nil), c.messages...)
+}
+
+// Messages returns the history truncated to fit the token budget
+func (c *Conversation) Messages() []types.Message {
+	msgs := c.History()
+	budget := c.Budget()
+	if budget <= 0 || c.strategy == nil {
+		return msgs
+	}
+	return c.strategy.Truncate(msgs, budget)
+}
+
+// Reset clears the history
+func (c *Conversation) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = nil
+}
+
+// Compact summarizes older turns if a Summarizer is configured and the
+// history has grown past its threshold
+func (c *Conversation) Compact(ctx context.Context) error {
+	if c.summarizer == nil {
+		return nil
+	}
+
+	snapshot := c.History()
+	msgs, changed, err := c.summarizer.Summarize(ctx, snapshot, c.Budget())
+	if err != nil || !changed {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Keep anything added while the summary was being generated
+	if len(c.messages) >= len(snapshot) {
+		c.messages = append(msgs, c.messages[len(snapshot):]...)
+	}
+	return nil
+}
+
+// Send adds a user message, sends the truncated history and records the
+// assistant's reply. The user message is kept even if the request fails.
+func (c *Conversation) Send(ctx context.Context, chatter Chatter, content string) (*types.ChatResponse, error) {
+	c.Add(types.Message{Role: types.RoleUser, Content: content})
+	if err := c.Compact(ctx); err != nil {
+		return nil, err
+	}
+
+	resp, err := chatter.Chat(ctx, &types.ChatRequest{
+		Messages: c.Messages(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.Add(resp.Message)
+	return resp, nil
+}
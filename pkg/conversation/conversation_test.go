@@ -0,0 +1,116 @@
+package conversation
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+func msg(role types.Role, content string) types.Message {
+	return types.Message{Role: role, Content: content}
+}
+
+// contents returns the message contents for easy comparison
+func contents(msgs []types.Message) string {
+	parts := make([]string, len(msgs))
+	for i, m := range msgs {
+		parts[i] = m.Content
+	}
+	return strings.Join(parts, ",")
+}
+
+func TestTruncationStrategies(t *testing.T) {
+	// Each message costs 4 overhead + 1 content token = 5 tokens
+	history := []types.Message{
+		msg(types.RoleSystem, "s"),
+		msg(types.RoleUser, "a"),
+		msg(types.RoleAssistant, "b"),
+		msg(types.RoleUser, "c"),
+		msg(types.RoleAssistant, "d"),
+		msg(types.RoleUser, "e"),
+	}
+	important := append([]types.Message(nil), history...)
+	important[1].Metadata = map[string]any{"importance": 10.0}
+
+	tests := []struct {
+		name     string
+		strategy TruncationStrategy
+		msgs     []types.Message
+		budget   int
+		want     string
+	}{
+		{"sliding window fits", SlidingWindow{}, history, 100, "s,a,b,c,d,e"},
+		{"sliding window trims oldest", SlidingWindow{}, history, 15, "s,d,e"},
+		{"sliding window keeps system", SlidingWindow{}, history, 5, "s"},
+		{"last n", KeepSystemAndLastN{N: 2}, history, 0, "s,d,e"},
+		{"last zero", KeepSystemAndLastN{}, history, 0, "s"},
+		{"importance by recency", ImportanceWeighted{}, history, 15, "s,d,e"},
+		{"importance by metadata", ImportanceWeighted{}, important, 15, "s,a,e"},
+		{"importance keeps newest", ImportanceWeighted{}, history, 1, "s,e"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.strategy.Truncate(tt.msgs, tt.budget)
+			if contents(got) != tt.want {
+				t.Errorf("Truncate() = %q, want %q", contents(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestConversation_Budget(t *testing.T) {
+	tests := []struct {
+		name  string
+		model string
+		opts  []Option
+		want  int
+	}{
+		{"known model", "gpt-4", nil, 8192 - defaultReservedTokens},
+		{"reserved tokens", "gpt-4", []Option{WithReservedTokens(192)}, 8000},
+		{"explicit budget", "unknown", []Option{WithTokenBudget(50)}, 50},
+		{"unknown model", "unknown", nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := New(tt.model, tt.opts...).Budget(); got != tt.want {
+				t.Errorf("Budget() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+type echoChatter struct {
+	got []types.Message
+}
+
+func (e *echoChatter) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	e.got = req.Messages
+	return &types.ChatResponse{
+		Response: types.Response{
+			Message: msg(types.RoleAssistant, "re:"+req.Messages[len(req.Messages)-1].Content),
+		},
+	}, nil
+}
+
+func TestConversation_Send(t *testing.T) {
+	conv := New("unknown", WithTokenBudget(15))
+	conv.Add(msg(types.RoleSystem, "s"))
+
+	chatter := &echoChatter{}
+	for _, content := range []string{"a", "b"} {
+		if _, err := conv.Send(context.Background(), chatter, content); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	if got := contents(conv.History()); got != "s,a,re:a,b,re:b" {
+		t.Errorf("History() = %q", got)
+	}
+	if got := contents(chatter.got); got != "s,re:a,b" {
+		t.Errorf("sent messages = %q, want %q", got, "s,re:a,b")
+	}
+}
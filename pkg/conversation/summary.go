@@ -0,0 +1,130 @@
+package conversation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ksred/llm/internal/tokenizer"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// DefaultSummaryPrompt instructs the model how to summarize older turns
+const DefaultSummaryPrompt = "Summarize the following conversation in a few sentences. " +
+	"Keep names, facts, decisions and open questions; omit pleasantries."
+
+// summaryPrefix introduces the summary note added to the history
+const summaryPrefix = "Summary of the earlier conversation:\n"
+
+// summaryKey marks summary notes in message metadata
+const summaryKey = "summary"
+
+// Summarizer compacts older turns into a single system note once the history
+// grows past a token threshold, so long-running sessions stay within the
+// model's context window
+type Summarizer struct {
+	// Chatter produces the summaries. A cheaper model than the one used for
+	// the conversation is usually sufficient.
+	Chatter Chatter
+
+	// Threshold is the history size in tokens that triggers summarization.
+	// If zero, three quarters of the conversation's budget is used.
+	Threshold int
+
+	// KeepLast is the number of recent messages left verbatim. Defaults to 4.
+	KeepLast int
+
+	// Prompt overrides DefaultSummaryPrompt
+	Prompt string
+}
+
+// WithSummarizer enables summarizing memory for a conversation
+func WithSummarizer(s *Summarizer) Option {
+	return func(c *Conversation) {
+		c.summarizer = s
+	}
+}
+
+// IsSummary reports whether msg is a summary note added by a Summarizer
+func IsSummary(msg types.Message) bool {
+	v, _ := msg.Metadata[summaryKey].(bool)
+	return v
+}
+
+// Summarize replaces older messages with a summary note if the history
+// exceeds the threshold. System messages other than earlier summaries are
+// kept as-is. It reports whether the history was changed.
+func (s *Summarizer) Summarize(ctx context.Context, msgs []types.Message, budget int) ([]types.Message, bool, error) {
+	threshold := s.Threshold
+	if threshold <= 0 {
+		threshold = budget * 3 / 4
+	}
+	if threshold <= 0 || tokenizer.CountMessages(msgs) <= threshold {
+		return msgs, false, nil
+	}
+
+	keepLast := s.KeepLast
+	if keepLast <= 0 {
+		keepLast = 4
+	}
+	split := len(msgs) - keepLast
+	if split <= 0 {
+		return msgs, false, nil
+	}
+
+	var kept, older []types.Message
+	for _, msg := range msgs[:split] {
+		if msg.Role == types.RoleSystem && !IsSummary(msg) {
+			kept = append(kept, msg)
+			continue
+		}
+		older = append(older, msg)
+	}
+	if len(older) == 0 {
+		return msgs, false, nil
+	}
+
+	summary, err := s.summarize(ctx, older)
+	if err != nil {
+		return msgs, false, fmt.Errorf("summarizing history: %w", err)
+	}
+
+	out := make([]types.Message, 0, len(kept)+1+keepLast)
+	out = append(out, kept...)
+	out = append(out, types.Message{
+		Role:     types.RoleSystem,
+		Content:  summaryPrefix + summary,
+		Metadata: map[string]any{summaryKey: true},
+	})
+	out = append(out, msgs[split:]...)
+	return out, true, nil
+}
+
+// summarize asks the model for a summary of msgs
+func (s *Summarizer) summarize(ctx context.Context, msgs []types.Message) (string, error) {
+	prompt := s.Prompt
+	if prompt == "" {
+		prompt = DefaultSummaryPrompt
+	}
+
+	var transcript strings.Builder
+	for _, msg := range msgs {
+		if IsSummary(msg) {
+			transcript.WriteString(strings.TrimPrefix(msg.Content, summaryPrefix))
+		} else {
+			fmt.Fprintf(&transcript, "%s: %s", msg.Role, msg.Content)
+		}
+		transcript.WriteString("\n")
+	}
+
+	resp, err := s.Chatter.Chat(ctx, &types.ChatRequest{
+		Messages: []types.Message{
+			{Role: types.RoleSystem, Content: prompt},
+			{Role: types.RoleUser, Content: transcript.String()},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(resp.Message.Content), nil
+}
@@ -0,0 +1,108 @@
+package conversation
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+type summaryChatter struct {
+	calls      int
+	transcript string
+	err        error
+}
+
+func (s *summaryChatter) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.transcript = req.Messages[len(req.Messages)-1].Content
+	return &types.ChatResponse{
+		Response: types.Response{Message: msg(types.RoleAssistant, "sum")},
+	}, nil
+}
+
+func TestSummarizer_Summarize(t *testing.T) {
+	// Each message costs 5 tokens
+	history := []types.Message{
+		msg(types.RoleSystem, "s"),
+		msg(types.RoleUser, "a"),
+		msg(types.RoleAssistant, "b"),
+		msg(types.RoleUser, "c"),
+		msg(types.RoleAssistant, "d"),
+	}
+
+	tests := []struct {
+		name        string
+		summarizer  *Summarizer
+		wantChanged bool
+		wantLen     int
+	}{
+		{"under threshold", &Summarizer{Threshold: 100}, false, 5},
+		{"over threshold", &Summarizer{Threshold: 10, KeepLast: 2}, true, 4},
+		{"nothing old enough", &Summarizer{Threshold: 10, KeepLast: 10}, false, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chatter := &summaryChatter{}
+			tt.summarizer.Chatter = chatter
+
+			got, changed, err := tt.summarizer.Summarize(context.Background(), history, 0)
+			if err != nil {
+				t.Fatalf("Summarize() error = %v", err)
+			}
+			if changed != tt.wantChanged || len(got) != tt.wantLen {
+				t.Fatalf("Summarize() changed = %v len = %d, want %v and %d", changed, len(got), tt.wantChanged, tt.wantLen)
+			}
+			if !changed {
+				return
+			}
+
+			if got[0].Content != "s" || !IsSummary(got[1]) || got[2].Content != "c" {
+				t.Errorf("Summarize() = %+v", got)
+			}
+			if !strings.Contains(chatter.transcript, "user: a\nassistant: b") {
+				t.Errorf("transcript = %q", chatter.transcript)
+			}
+		})
+	}
+}
+
+func TestSummarizer_Error(t *testing.T) {
+	errDown := errors.New("down")
+	s := &Summarizer{Chatter: &summaryChatter{err: errDown}, Threshold: 1, KeepLast: 1}
+
+	msgs := []types.Message{msg(types.RoleUser, "a"), msg(types.RoleUser, "b")}
+	if _, _, err := s.Summarize(context.Background(), msgs, 0); !errors.Is(err, errDown) {
+		t.Errorf("Summarize() error = %v, want %v", err, errDown)
+	}
+}
+
+func TestConversation_SendSummarizes(t *testing.T) {
+	summaries := &summaryChatter{}
+	conv := New("unknown", WithSummarizer(&Summarizer{
+		Chatter:   summaries,
+		Threshold: 20,
+		KeepLast:  2,
+	}))
+
+	chatter := &echoChatter{}
+	for _, content := range []string{"a", "b", "c"} {
+		if _, err := conv.Send(context.Background(), chatter, content); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	history := conv.History()
+	if summaries.calls != 1 {
+		t.Errorf("summarizer called %d times, want 1", summaries.calls)
+	}
+	if !IsSummary(history[0]) || contents(history[1:]) != "re:b,c,re:c" {
+		t.Errorf("History() = %+v", history)
+	}
+}
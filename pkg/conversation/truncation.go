@@ -0,0 +1,134 @@
+package conversation
+
+import (
+	"sort"
+
+	"github.com/ksred/llm/internal/tokenizer"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// TruncationStrategy trims a message history to fit within a token budget.
+// Implementations must not modify msgs and should preserve message order.
+type TruncationStrategy interface {
+	Truncate(msgs []types.Message, budget int) []types.Message
+}
+
+// SlidingWindow keeps system messages and drops the oldest remaining
+// messages until the history fits the token budget
+type SlidingWindow struct{}
+
+// Truncate implements TruncationStrategy
+func (SlidingWindow) Truncate(msgs []types.Message, budget int) []types.Message {
+	keep := make([]bool, len(msgs))
+	total := 0
+	for i, msg := range msgs {
+		if msg.Role == types.RoleSystem {
+			keep[i] = true
+			total += tokenizer.CountMessages(msgs[i : i+1])
+		}
+	}
+
+	// Walk back from the newest message, keeping whatever still fits
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if keep[i] {
+			continue
+		}
+		n := tokenizer.CountMessages(msgs[i : i+1])
+		if total+n > budget {
+			break
+		}
+		keep[i] = true
+		total += n
+	}
+
+	return filter(msgs, keep)
+}
+
+// KeepSystemAndLastN keeps every system message plus the last N other
+// messages, regardless of token count
+type KeepSystemAndLastN struct {
+	N int
+}
+
+// Truncate implements TruncationStrategy. The budget is ignored.
+func (s KeepSystemAndLastN) Truncate(msgs []types.Message, budget int) []types.Message {
+	keep := make([]bool, len(msgs))
+	remaining := s.N
+	for i := len(msgs) - 1; i >= 0; i-- {
+		switch {
+		case msgs[i].Role == types.RoleSystem:
+			keep[i] = true
+		case remaining > 0:
+			keep[i] = true
+			remaining--
+		}
+	}
+	return filter(msgs, keep)
+}
+
+// ImportanceWeighted drops the lowest scoring messages first until the
+// history fits the token budget. System messages and the newest message are
+// always kept.
+type ImportanceWeighted struct {
+	// Score rates a message at index i of n. Higher scores are kept longer.
+	// If nil, DefaultImportance is used.
+	Score func(msg types.Message, i, n int) float64
+}
+
+// DefaultImportance scores messages by recency, boosted by a numeric
+// "importance" entry in the message metadata if present
+func DefaultImportance(msg types.Message, i, n int) float64 {
+	score := float64(i+1) / float64(n)
+	switch v := msg.Metadata["importance"].(type) {
+	case float64:
+		score += v
+	case int:
+		score += float64(v)
+	}
+	return score
+}
+
+// Truncate implements TruncationStrategy
+func (s ImportanceWeighted) Truncate(msgs []types.Message, budget int) []types.Message {
+	score := s.Score
+	if score == nil {
+		score = DefaultImportance
+	}
+
+	keep := make([]bool, len(msgs))
+	total := 0
+	var candidates []int
+	for i, msg := range msgs {
+		keep[i] = true
+		total += tokenizer.CountMessages(msgs[i : i+1])
+		if msg.Role != types.RoleSystem && i != len(msgs)-1 {
+			candidates = append(candidates, i)
+		}
+	}
+
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return score(msgs[candidates[a]], candidates[a], len(msgs)) <
+			score(msgs[candidates[b]], candidates[b], len(msgs))
+	})
+
+	for _, i := range candidates {
+		if total <= budget {
+			break
+		}
+		keep[i] = false
+		total -= tokenizer.CountMessages(msgs[i : i+1])
+	}
+
+	return filter(msgs, keep)
+}
+
+// filter returns the messages whose keep flag is set, in order
+func filter(msgs []types.Message, keep []bool) []types.Message {
+	out := make([]types.Message, 0, len(msgs))
+	for i, msg := range msgs {
+		if keep[i] {
+			out = append(out, msg)
+		}
+	}
+	return out
+}
@@ -0,0 +1,211 @@
+package cost
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// spendRecord is a single charge against a BudgetGuard
+type spendRecord struct {
+	at   time.Time
+	cost float64
+}
+
+// BudgetGuard enforces a per-request cost ceiling and a rolling 24 hour
+// spend limit. A zero limit disables that check.
+type BudgetGuard struct {
+	mu            sync.Mutex
+	maxPerRequest float64
+	maxPerDay     float64
+	currency      Currency
+	records       []*spendRecord
+	now           func() time.Time
+}
+
+// NewBudgetGuard creates a guard with the given limits in USD, or in the
+// currency later set with SetCurrency
+func NewBudgetGuard(maxPerRequest, maxPerDay float64) *BudgetGuard {
+	return &BudgetGuard{
+		maxPerRequest: maxPerRequest,
+		maxPerDay:     maxPerDay,
+		now:           time.Now,
+	}
+}
+
+// SetCurrency makes the guard's limits amounts in currency rather than US
+// dollars. Costs are converted at the current exchange rate on each check.
+func (g *BudgetGuard) SetCurrency(currency Currency) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.currency = currency
+}
+
+// Check returns an error wrapping types.ErrBudgetExceeded if a request with
+// the estimated cost in US dollars would breach either limit. Concurrent
+// requests may all pass Check before any records its cost; use Reserve to
+// admit them atomically.
+func (g *BudgetGuard) Check(estimate float64) error {
+	_, err := g.admit(estimate, false)
+	return err
+}
+
+// Reserve checks a request with the estimated cost in US dollars like Check
+// and, if it is within the limits, charges the estimate to the daily total
+// in the same step. The caller settles the reservation to the actual cost
+// once known, or releases it if the request failed.
+func (g *BudgetGuard) Reserve(estimate float64) (*Reservation, error) {
+	return g.admit(estimate, true)
+}
+
+// Hold charges the estimated cost in US dollars to the daily total without
+// checking the limits, for a request sent regardless of the budget. It is
+// settled or released like a reservation.
+func (g *BudgetGuard) Hold(estimate float64) *Reservation {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.hold(estimate)
+}
+
+// admit checks estimate against the limits and, when reserve is set,
+// charges it while still holding the lock
+func (g *BudgetGuard) admit(estimate float64, reserve bool) (*Reservation, error) {
+	g.mu.Lock()
+	currency := g.currency
+	g.mu.Unlock()
+
+	// Limits are compared in the guard's currency. The rate is resolved
+	// without the lock, since it may be fetched.
+	rate, err := currency.FromUSD(context.Background(), 1)
+	if err != nil {
+		return nil, err
+	}
+	converted := estimate * rate
+
+	if g.maxPerRequest > 0 && converted > g.maxPerRequest {
+		return nil, fmt.Errorf("%w: estimated request cost %s exceeds per-request limit %s",
+			types.ErrBudgetExceeded, currency.Format(converted), currency.Format(g.maxPerRequest))
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.maxPerDay > 0 {
+		spent := g.spent() * rate
+		if spent+converted > g.maxPerDay {
+			return nil, fmt.Errorf("%w: daily spend %s + estimated %s exceeds daily limit %s",
+				types.ErrBudgetExceeded, currency.Format(spent), currency.Format(converted), currency.Format(g.maxPerDay))
+		}
+	}
+
+	if !reserve {
+		return nil, nil
+	}
+	return g.hold(estimate), nil
+}
+
+// hold appends a charge of cost that can later be corrected. Callers must
+// hold g.mu.
+func (g *BudgetGuard) hold(cost float64) *Reservation {
+	g.prune()
+	rec := &spendRecord{at: g.now(), cost: max(cost, 0)}
+	g.records = append(g.records, rec)
+	return &Reservation{guard: g, record: rec}
+}
+
+// Record adds a completed charge to the rolling daily total
+func (g *BudgetGuard) Record(cost float64) {
+	if cost <= 0 {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.hold(cost)
+}
+
+// Reservation is an estimated charge against a BudgetGuard, counted in its
+// daily total until settled or released. A nil Reservation does nothing.
+type Reservation struct {
+	guard  *BudgetGuard
+	record *spendRecord
+	done   bool
+}
+
+// Settle replaces the reserved estimate with the actual cost in US dollars
+func (r *Reservation) Settle(cost float64) {
+	if r == nil {
+		return
+	}
+	r.guard.mu.Lock()
+	defer r.guard.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.record.cost = max(cost, 0)
+	r.done = true
+}
+
+// Keep charges the reservation at its estimate, for a request whose actual
+// cost is not reported
+func (r *Reservation) Keep() {
+	if r == nil {
+		return
+	}
+	r.guard.mu.Lock()
+	defer r.guard.mu.Unlock()
+	r.done = true
+}
+
+// Release returns the reserved estimate, for a request that was not sent or
+// failed. A settled or kept reservation is left as it is, so Release may be
+// deferred.
+func (r *Reservation) Release() {
+	if r == nil {
+		return
+	}
+	r.guard.mu.Lock()
+	defer r.guard.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.record.cost = 0
+	r.done = true
+}
+
+// DailySpend returns the total in US dollars recorded over the last 24 hours
+func (g *BudgetGuard) DailySpend() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.spent()
+}
+
+// spent returns the total of the last 24 hours. Callers must hold g.mu.
+func (g *BudgetGuard) spent() float64 {
+	g.prune()
+	var total float64
+	for _, r := range g.records {
+		total += r.cost
+	}
+	return total
+}
+
+// prune drops records older than 24 hours. Callers must hold g.mu.
+func (g *BudgetGuard) prune() {
+	cutoff := g.now().Add(-24 * time.Hour)
+	i := 0
+	for i < len(g.records) && g.records[i].at.Before(cutoff) {
+		i++
+	}
+	g.records = g.records[i:]
+}
+
+// EstimateCost returns the worst-case cost of a request with the given prompt
+// size and completion budget, priced from the default catalog
+func EstimateCost(provider, model string, promptTokens, maxTokens int) float64 {
+	return defaultCatalog.Estimate(provider, model, promptTokens, maxTokens)
+}
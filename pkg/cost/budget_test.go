@@ -0,0 +1,129 @@
+package cost
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestBudgetGuard_Check(t *testing.T) {
+	tests := []struct {
+		name     string
+		perReq   float64
+		perDay   float64
+		spent    float64
+		estimate float64
+		wantErr  bool
+	}{
+		{"no limits", 0, 0, 100, 100, false},
+		{"within per-request limit", 1, 0, 0, 0.5, false},
+		{"over per-request limit", 1, 0, 0, 1.5, true},
+		{"within daily limit", 0, 10, 5, 4, false},
+		{"over daily limit", 0, 10, 8, 4, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewBudgetGuard(tt.perReq, tt.perDay)
+			g.Record(tt.spent)
+
+			err := g.Check(tt.estimate)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, types.ErrBudgetExceeded) {
+				t.Errorf("Check() error = %v, want %v", err, types.ErrBudgetExceeded)
+			}
+		})
+	}
+}
+
+func TestBudgetGuard_RollingWindow(t *testing.T) {
+	now := time.Now()
+	g := NewBudgetGuard(0, 10)
+	g.now = func() time.Time { return now }
+
+	g.Record(6)
+	now = now.Add(23 * time.Hour)
+	g.Record(3)
+
+	if got := g.DailySpend(); got != 9 {
+		t.Errorf("DailySpend() = %v, want 9", got)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if got := g.DailySpend(); got != 3 {
+		t.Errorf("DailySpend() after window = %v, want 3", got)
+	}
+}
+
+func TestBudgetGuard_Reservation(t *testing.T) {
+	tests := []struct {
+		name   string
+		finish func(*Reservation)
+		want   float64
+	}{
+		{"pending", func(r *Reservation) {}, 4},
+		{"settled", func(r *Reservation) { r.Settle(1.5) }, 1.5},
+		{"kept", func(r *Reservation) { r.Keep() }, 4},
+		{"released", func(r *Reservation) { r.Release() }, 0},
+		{"released after settling", func(r *Reservation) { r.Settle(1.5); r.Release() }, 1.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewBudgetGuard(0, 10)
+			r, err := g.Reserve(4)
+			if err != nil {
+				t.Fatalf("Reserve() error = %v", err)
+			}
+			tt.finish(r)
+			if got := g.DailySpend(); got != tt.want {
+				t.Errorf("DailySpend() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	g := NewBudgetGuard(0, 10)
+	g.Record(8)
+	if _, err := g.Reserve(4); !errors.Is(err, types.ErrBudgetExceeded) {
+		t.Errorf("Reserve() over the daily limit error = %v, want %v", err, types.ErrBudgetExceeded)
+	}
+	g.Hold(4)
+	if got := g.DailySpend(); got != 12 {
+		t.Errorf("DailySpend() after Hold() = %v, want 12", got)
+	}
+}
+
+func TestBudgetGuard_ReserveConcurrent(t *testing.T) {
+	g := NewBudgetGuard(0, 10)
+
+	var admitted atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := g.Reserve(1); err == nil {
+				admitted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := admitted.Load(); got != 10 {
+		t.Errorf("admitted %d requests, want 10 within the daily limit", got)
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	// 1000 prompt tokens at $0.03/1K + 500 completion tokens at $0.06/1K
+	got := EstimateCost("openai", "gpt-4", 1000, 500)
+	if got != 0.06 {
+		t.Errorf("EstimateCost() = %v, want %v", got, 0.06)
+	}
+}
@@ -0,0 +1,326 @@
+package cost
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed pricing.json
+var defaultPricing []byte
+
+// defaultCatalog backs CalculateCost and EstimateCost
+var defaultCatalog = mustParseCatalog(defaultPricing)
+
+// UnknownModelPolicy decides how a catalog prices models it has no rates for
+type UnknownModelPolicy int
+
+const (
+	// UnknownModelFallback prices unknown models at the provider's fallback
+	// rates set with SetFallback, or at zero if there are none
+	UnknownModelFallback UnknownModelPolicy = iota
+	// UnknownModelError makes Price fail with types.ErrUnknownModel
+	UnknownModelError
+)
+
+// PricingCatalog holds token rates by provider and model. Model keys may be
+// exact names or path.Match patterns such as "gpt-4o-*"; an exact name wins,
+// then the longest matching pattern. A catalog is safe for concurrent use
+// and can be changed at runtime.
+type PricingCatalog struct {
+	mu        sync.RWMutex
+	rates     map[string]map[string]TokenRates // provider -> model or pattern -> rates
+	overrides map[string]map[string]TokenRates // looked up before rates
+	fallback  map[string]TokenRates            // provider -> rates for unknown models
+	policy    UnknownModelPolicy
+	onUnknown func(provider, model string)
+}
+
+// NewPricingCatalog creates an empty catalog
+func NewPricingCatalog() *PricingCatalog {
+	return &PricingCatalog{
+		rates:     make(map[string]map[string]TokenRates),
+		overrides: make(map[string]map[string]TokenRates),
+		fallback:  make(map[string]TokenRates),
+	}
+}
+
+// DefaultCatalog returns the catalog used by CalculateCost and EstimateCost.
+// It starts with the library's built-in prices; use Set or Load to override
+// them.
+func DefaultCatalog() *PricingCatalog {
+	return defaultCatalog
+}
+
+// ParsePricingCatalog parses a catalog from JSON or YAML of the form
+//
+//	openai:
+//	  gpt-4o-*: {prompt: 0.0025, completion: 0.01}
+//
+// with rates in USD per 1K tokens
+func ParsePricingCatalog(data []byte) (*PricingCatalog, error) {
+	var rates map[string]map[string]TokenRates
+	if err := yaml.Unmarshal(data, &rates); err != nil {
+		return nil, fmt.Errorf("parsing pricing catalog: %w", err)
+	}
+
+	c := NewPricingCatalog()
+	for provider, models := range rates {
+		for pattern, r := range models {
+			if err := c.Set(provider, pattern, r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c, nil
+}
+
+// LoadPricingCatalog reads a JSON or YAML catalog file
+func LoadPricingCatalog(filename string) (*PricingCatalog, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading pricing catalog: %w", err)
+	}
+	return ParsePricingCatalog(data)
+}
+
+func mustParseCatalog(data []byte) *PricingCatalog {
+	c, err := ParsePricingCatalog(data)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Clone returns an independent copy of the catalog, including its unknown
+// model policy, fallback rates and callback
+func (c *PricingCatalog) Clone() *PricingCatalog {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	out := NewPricingCatalog()
+	for provider, models := range c.rates {
+		out.rates[provider] = make(map[string]TokenRates, len(models))
+		for model, r := range models {
+			out.rates[provider][model] = r.clone()
+		}
+	}
+	for provider, models := range c.overrides {
+		out.overrides[provider] = make(map[string]TokenRates, len(models))
+		for model, r := range models {
+			out.overrides[provider][model] = r.clone()
+		}
+	}
+	for provider, r := range c.fallback {
+		out.fallback[provider] = r.clone()
+	}
+	out.policy, out.onUnknown = c.policy, c.onUnknown
+	return out
+}
+
+// Set adds or replaces the rates for a model name or pattern
+func (c *PricingCatalog) Set(provider, model string, rates TokenRates) error {
+	if _, err := path.Match(model, ""); err != nil {
+		return fmt.Errorf("invalid model pattern %q: %w", model, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.rates[provider]; !ok {
+		c.rates[provider] = make(map[string]TokenRates)
+	}
+	c.rates[provider][model] = rates.clone()
+	return nil
+}
+
+// Override sets negotiated rates for a model name or pattern. Overrides are
+// matched before any other rates, so an override pattern such as "gpt-4o*"
+// applies even where the catalog has a longer matching pattern.
+func (c *PricingCatalog) Override(provider, model string, rates TokenRates) error {
+	if _, err := path.Match(model, ""); err != nil {
+		return fmt.Errorf("invalid model pattern %q: %w", model, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.overrides[provider]; !ok {
+		c.overrides[provider] = make(map[string]TokenRates)
+	}
+	c.overrides[provider][model] = rates.clone()
+	return nil
+}
+
+// Load merges the model rates from other into c, replacing entries with the
+// same provider and model key
+func (c *PricingCatalog) Load(other *PricingCatalog) {
+	for provider, models := range other.Rates() {
+		for model, r := range models {
+			c.Set(provider, model, r)
+		}
+	}
+}
+
+// Lookup returns the rates for a model: an override if one matches, else an
+// exact name, else the longest matching pattern
+func (c *PricingCatalog) Lookup(provider, model string) (TokenRates, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if r, ok := match(c.overrides[provider], model); ok {
+		return r, true
+	}
+	return match(c.rates[provider], model)
+}
+
+// match returns the rates for an exact model name, or else for the longest
+// pattern matching it
+func match(models map[string]TokenRates, model string) (TokenRates, bool) {
+	if r, ok := models[model]; ok {
+		return r, true
+	}
+
+	var best string
+	found := false
+	for pattern := range models {
+		if ok, _ := path.Match(pattern, model); ok && (!found || len(pattern) > len(best)) {
+			best, found = pattern, true
+		}
+	}
+	return models[best], found
+}
+
+// Rates returns a copy of every entry in the catalog, not including
+// overrides
+func (c *PricingCatalog) Rates() map[string]map[string]TokenRates {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	out := make(map[string]map[string]TokenRates, len(c.rates))
+	for provider, models := range c.rates {
+		out[provider] = make(map[string]TokenRates, len(models))
+		for model, r := range models {
+			out[provider][model] = r.clone()
+		}
+	}
+	return out
+}
+
+// SetUnknownModelPolicy sets how models without rates are priced
+func (c *PricingCatalog) SetUnknownModelPolicy(policy UnknownModelPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = policy
+}
+
+// SetFallback sets the rates used for a provider's unknown models under
+// UnknownModelFallback
+func (c *PricingCatalog) SetFallback(provider string, rates TokenRates) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fallback[provider] = rates.clone()
+}
+
+// OnUnknownModel registers fn to be called whenever a model without rates
+// is priced, whatever the policy, so missing prices can be logged or
+// alerted on
+func (c *PricingCatalog) OnUnknownModel(fn func(provider, model string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onUnknown = fn
+}
+
+// Price returns the cost in USD of the given token usage. Models without
+// rates are handled according to the catalog's UnknownModelPolicy.
+func (c *PricingCatalog) Price(provider, model string, usage types.Usage) (float64, error) {
+	cost, _, err := c.priceTokens(provider, model, usage)
+	return cost, err
+}
+
+// PriceEmbedding returns the cost in USD of embedding the given number of
+// input tokens, at the model's prompt rate
+func (c *PricingCatalog) PriceEmbedding(provider, model string, tokens int) (float64, error) {
+	return c.Price(provider, model, types.Usage{PromptTokens: tokens, TotalTokens: tokens})
+}
+
+// PriceImages returns the cost in USD of generating n images of the given
+// size, such as "1024x1024". A model without a rate for the size is handled
+// like an unknown model.
+func (c *PricingCatalog) PriceImages(provider, model, size string, n int) (float64, error) {
+	cost, _, err := c.priceImages(provider, model, size, n)
+	return cost, err
+}
+
+// PriceAudio returns the cost in USD of processing the given duration of
+// audio. A model without a per-minute audio rate is handled like an unknown
+// model.
+func (c *PricingCatalog) PriceAudio(provider, model string, d time.Duration) (float64, error) {
+	cost, _, err := c.priceAudio(provider, model, d)
+	return cost, err
+}
+
+func (c *PricingCatalog) priceTokens(provider, model string, usage types.Usage) (float64, bool, error) {
+	rates, priced, err := c.resolve(provider, model, "", func(TokenRates) bool { return true })
+	return rates.cost(usage), priced, err
+}
+
+func (c *PricingCatalog) priceImages(provider, model, size string, n int) (float64, bool, error) {
+	rates, priced, err := c.resolve(provider, model, size+" image", func(r TokenRates) bool {
+		_, ok := r.ImageRates[size]
+		return ok
+	})
+	return rates.ImageRates[size] * float64(n), priced, err
+}
+
+func (c *PricingCatalog) priceAudio(provider, model string, d time.Duration) (float64, bool, error) {
+	rates, priced, err := c.resolve(provider, model, "audio", func(r TokenRates) bool {
+		return r.AudioMinuteRate > 0
+	})
+	return rates.AudioMinuteRate * d.Minutes(), priced, err
+}
+
+// Estimate returns the worst-case cost in USD of a request with the given
+// prompt size and completion budget, or zero where Price would fail
+func (c *PricingCatalog) Estimate(provider, model string, promptTokens, maxTokens int) float64 {
+	return c.Cost(provider, model, types.Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: maxTokens,
+	})
+}
+
+// Cost is like Price but returns zero when Price would fail
+func (c *PricingCatalog) Cost(provider, model string, usage types.Usage) float64 {
+	cost, _ := c.Price(provider, model, usage)
+	return cost
+}
+
+// resolve returns the rates to price a model with. The model's own rates
+// are used if has reports they cover the operation, which kind describes
+// in errors. Otherwise the UnknownModelPolicy applies and priced is false.
+func (c *PricingCatalog) resolve(provider, model, kind string, has func(TokenRates) bool) (rates TokenRates, priced bool, err error) {
+	if rates, ok := c.Lookup(provider, model); ok && has(rates) {
+		return rates, true, nil
+	}
+
+	c.mu.RLock()
+	policy, fallback, onUnknown := c.policy, c.fallback[provider], c.onUnknown
+	c.mu.RUnlock()
+
+	if onUnknown != nil {
+		onUnknown(provider, model)
+	}
+	if policy == UnknownModelError {
+		if kind == "" {
+			return TokenRates{}, false, fmt.Errorf("%w: no rates for %s model %s", types.ErrUnknownModel, provider, model)
+		}
+		return TokenRates{}, false, fmt.Errorf("%w: no %s rate for %s model %s", types.ErrUnknownModel, kind, provider, model)
+	}
+	return fallback, false, nil
+}
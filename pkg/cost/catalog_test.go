@@ -0,0 +1,303 @@
+package cost
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestPricingCatalog_Lookup(t *testing.T) {
+	c := DefaultCatalog()
+
+	tests := []struct {
+		provider   string
+		model      string
+		wantPrompt float64
+		wantFound  bool
+	}{
+		{"openai", "gpt-4", 0.03, true},
+		{"openai", "gpt-4o", 0.0025, true},
+		{"openai", "gpt-4o-2024-08-06", 0.0025, true},
+		{"openai", "gpt-4o-2024-05-13", 0.005, true},
+		{"openai", "gpt-4o-mini-2024-07-18", 0.00015, true},
+		{"openai", "gpt-4.1-nano-2025-04-14", 0.0001, true},
+		{"openai", "o1-mini", 0.0011, true},
+		{"anthropic", "claude-3-5-sonnet-20241022", 0.003, true},
+		{"anthropic", "claude-3-haiku-20240307", 0.00025, true},
+		{"anthropic", "claude-instant-1.2", 0.0008, true},
+		{"anthropic", "gpt-4", 0, false},
+		{"openai", "unknown-model", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider+"/"+tt.model, func(t *testing.T) {
+			rates, ok := c.Lookup(tt.provider, tt.model)
+			if ok != tt.wantFound {
+				t.Fatalf("Lookup() found = %v, want %v", ok, tt.wantFound)
+			}
+			if rates.PromptTokenRate != tt.wantPrompt {
+				t.Errorf("Lookup() prompt rate = %v, want %v", rates.PromptTokenRate, tt.wantPrompt)
+			}
+		})
+	}
+}
+
+func TestParsePricingCatalog(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"json", `{"openai": {"ft:gpt-4o-*": {"prompt": 0.00375, "completion": 0.015}}}`},
+		{"yaml", "openai:\n  \"ft:gpt-4o-*\":\n    prompt: 0.00375\n    completion: 0.015\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParsePricingCatalog([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("ParsePricingCatalog() error = %v", err)
+			}
+			got := c.Cost("openai", "ft:gpt-4o-2024-08-06:acme", types.Usage{PromptTokens: 1000, CompletionTokens: 1000})
+			if want := 0.00375 + 0.015; got != want {
+				t.Errorf("Cost() = %v, want %v", got, want)
+			}
+		})
+	}
+
+	if _, err := ParsePricingCatalog([]byte(`{"openai": {"gpt-[": {}}}`)); err == nil {
+		t.Error("ParsePricingCatalog() with a bad pattern error = nil")
+	}
+	if _, err := ParsePricingCatalog([]byte(`not: [valid`)); err == nil {
+		t.Error("ParsePricingCatalog() with bad syntax error = nil")
+	}
+}
+
+func TestPricingCatalog_Override(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pricing.yaml")
+	if err := os.WriteFile(file, []byte("openai:\n  gpt-4o:\n    prompt: 0.002\n    completion: 0.008\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	overrides, err := LoadPricingCatalog(file)
+	if err != nil {
+		t.Fatalf("LoadPricingCatalog() error = %v", err)
+	}
+
+	c := NewPricingCatalog()
+	c.Set("openai", "gpt-4o*", TokenRates{PromptTokenRate: 0.0025, CompletionTokenRate: 0.01})
+	c.Load(overrides)
+
+	if r, _ := c.Lookup("openai", "gpt-4o"); r.PromptTokenRate != 0.002 {
+		t.Errorf("overridden gpt-4o prompt rate = %v, want 0.002", r.PromptTokenRate)
+	}
+	if r, _ := c.Lookup("openai", "gpt-4o-mini"); r.PromptTokenRate != 0.0025 {
+		t.Errorf("gpt-4o-mini prompt rate = %v, want the pattern's 0.0025", r.PromptTokenRate)
+	}
+
+	// Rates returns a copy
+	c.Rates()["openai"]["gpt-4o"] = TokenRates{}
+	if r, _ := c.Lookup("openai", "gpt-4o"); r.PromptTokenRate != 0.002 {
+		t.Error("modifying Rates() changed the catalog")
+	}
+}
+
+func TestPricingCatalog_UnknownModel(t *testing.T) {
+	usage := types.Usage{PromptTokens: 1000, CompletionTokens: 1000}
+
+	tests := []struct {
+		name     string
+		policy   UnknownModelPolicy
+		fallback *TokenRates
+		wantCost float64
+		wantErr  error
+	}{
+		{"zero", UnknownModelFallback, nil, 0, nil},
+		{"fallback", UnknownModelFallback, &TokenRates{PromptTokenRate: 0.01, CompletionTokenRate: 0.03}, 0.04, nil},
+		{"error", UnknownModelError, &TokenRates{PromptTokenRate: 0.01}, 0, types.ErrUnknownModel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewPricingCatalog()
+			c.Set("openai", "gpt-4", TokenRates{PromptTokenRate: 0.03, CompletionTokenRate: 0.06})
+			c.SetUnknownModelPolicy(tt.policy)
+			if tt.fallback != nil {
+				c.SetFallback("openai", *tt.fallback)
+			}
+			var warned []string
+			c.OnUnknownModel(func(provider, model string) {
+				warned = append(warned, provider+"/"+model)
+			})
+
+			got, err := c.Price("openai", "gpt-5-preview", usage)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Price() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.wantCost {
+				t.Errorf("Price() = %v, want %v", got, tt.wantCost)
+			}
+			if len(warned) != 1 || warned[0] != "openai/gpt-5-preview" {
+				t.Errorf("OnUnknownModel calls = %v, want one for openai/gpt-5-preview", warned)
+			}
+
+			// Known models are priced normally and raise no warning
+			if got, err := c.Price("openai", "gpt-4", usage); err != nil || got != 0.09 {
+				t.Errorf("Price(gpt-4) = %v, %v, want 0.09", got, err)
+			}
+			if len(warned) != 1 {
+				t.Errorf("OnUnknownModel called for a known model")
+			}
+		})
+	}
+}
+
+func TestCostTracker_UnknownModel(t *testing.T) {
+	catalog := NewPricingCatalog()
+	catalog.SetFallback("openai", TokenRates{PromptTokenRate: 0.01})
+
+	tracker := NewCostTracker()
+	tracker.SetCatalog(catalog)
+
+	usage := types.Usage{PromptTokens: 1000, TotalTokens: 1000}
+	if err := tracker.TrackUsage("openai", "gpt-5", usage); err != nil {
+		t.Fatalf("TrackUsage() error = %v", err)
+	}
+	stats, err := tracker.GetUsageStats("openai", "gpt-5", time.Now().Add(-time.Minute), time.Now())
+	if err != nil {
+		t.Fatalf("GetUsageStats() error = %v", err)
+	}
+	if stats.UnpricedRequests != 1 || stats.TotalCost != 0.01 {
+		t.Errorf("stats = %+v, want 1 unpriced request at the fallback rate", stats)
+	}
+
+	catalog.SetUnknownModelPolicy(UnknownModelError)
+	if err := tracker.TrackUsage("openai", "gpt-5", usage); !errors.Is(err, types.ErrUnknownModel) {
+		t.Errorf("TrackUsage() error = %v, want %v", err, types.ErrUnknownModel)
+	}
+	stats, _ = tracker.GetUsageStats("openai", "gpt-5", time.Now().Add(-time.Minute), time.Now())
+	if stats.RequestCount != 1 {
+		t.Errorf("rejected usage was recorded, RequestCount = %d", stats.RequestCount)
+	}
+}
+
+func TestPricingCatalog_Operations(t *testing.T) {
+	// Missing rates fail, rather than costing zero
+	c := NewPricingCatalog()
+	c.Load(DefaultCatalog())
+	c.SetUnknownModelPolicy(UnknownModelError)
+
+	tests := []struct {
+		name    string
+		price   func(c *PricingCatalog) (float64, error)
+		want    float64
+		wantErr bool
+	}{
+		{
+			name: "embedding",
+			price: func(c *PricingCatalog) (float64, error) {
+				return c.PriceEmbedding("openai", "text-embedding-3-small", 50000)
+			},
+			want: 0.001,
+		},
+		{
+			name:  "images",
+			price: func(c *PricingCatalog) (float64, error) { return c.PriceImages("openai", "dall-e-3", "1792x1024", 3) },
+			want:  0.24,
+		},
+		{
+			name:    "image size without a rate",
+			price:   func(c *PricingCatalog) (float64, error) { return c.PriceImages("openai", "dall-e-3", "256x256", 1) },
+			wantErr: true,
+		},
+		{
+			name:  "audio",
+			price: func(c *PricingCatalog) (float64, error) { return c.PriceAudio("openai", "whisper-1", 90*time.Second) },
+			want:  0.009,
+		},
+		{
+			name:    "audio on a chat model",
+			price:   func(c *PricingCatalog) (float64, error) { return c.PriceAudio("openai", "gpt-4", time.Minute) },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.price(c)
+			if tt.wantErr {
+				if !errors.Is(err, types.ErrUnknownModel) {
+					t.Errorf("price error = %v, want %v", err, types.ErrUnknownModel)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("price error = %v", err)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("price = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCostTracker_Operations(t *testing.T) {
+	tracker := NewCostTracker()
+	tags := map[string]string{"tenant": "acme"}
+
+	if err := tracker.TrackUsage("openai", "gpt-4", types.Usage{PromptTokens: 1000, TotalTokens: 1000}); err != nil {
+		t.Fatalf("TrackUsage() error = %v", err)
+	}
+	if err := tracker.TrackEmbedding("openai", "text-embedding-3-small", 50000, tags); err != nil {
+		t.Fatalf("TrackEmbedding() error = %v", err)
+	}
+	if err := tracker.TrackImages("openai", "dall-e-3", "1024x1024", 2, tags); err != nil {
+		t.Fatalf("TrackImages() error = %v", err)
+	}
+	if err := tracker.TrackAudio("openai", "whisper-1", 2*time.Minute, tags); err != nil {
+		t.Fatalf("TrackAudio() error = %v", err)
+	}
+
+	records, err := tracker.Query(context.Background(), UsageQuery{Provider: "openai"})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	total := Summarize(records)
+	if math.Abs(total.TotalCost-(0.03+0.001+0.08+0.012)) > 1e-9 {
+		t.Errorf("total cost = %v, want 0.123", total.TotalCost)
+	}
+	if total.Images != 2 || total.Audio != 2*time.Minute || total.UnpricedRequests != 0 {
+		t.Errorf("totals = %+v, want 2 images and 2m of audio, all priced", total)
+	}
+
+	images, _ := tracker.Query(context.Background(), UsageQuery{Op: OpImage})
+	if len(images) != 1 || images[0].Model != "dall-e-3" {
+		t.Errorf("Query(OpImage) = %+v, want the dall-e-3 record", images)
+	}
+}
+
+func TestPricingCatalog_OverridePrecedence(t *testing.T) {
+	c := DefaultCatalog().Clone()
+	if err := c.Override("openai", "gpt-4o*", TokenRates{PromptTokenRate: 0.002}); err != nil {
+		t.Fatalf("Override() error = %v", err)
+	}
+
+	// The override wins over the longer catalog patterns gpt-4o-* and gpt-4o-mini*
+	for _, model := range []string{"gpt-4o", "gpt-4o-2024-08-06", "gpt-4o-mini"} {
+		if rates, _ := c.Lookup("openai", model); rates.PromptTokenRate != 0.002 {
+			t.Errorf("Lookup(%s) prompt rate = %v, want 0.002", model, rates.PromptTokenRate)
+		}
+	}
+	if rates, _ := c.Lookup("openai", "gpt-4"); rates.PromptTokenRate != 0.03 {
+		t.Errorf("Lookup(gpt-4) prompt rate = %v, want the catalog's 0.03", rates.PromptTokenRate)
+	}
+
+	// Clones are independent of each other
+	if rates, _ := DefaultCatalog().Lookup("openai", "gpt-4o"); rates.PromptTokenRate != 0.0025 {
+		t.Errorf("default catalog prompt rate = %v, want 0.0025", rates.PromptTokenRate)
+	}
+}
@@ -1,116 +1,454 @@
 package cost
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/ksred/llm/pkg/types"
 )
 
-// TokenRates holds the cost per 1K tokens for a model
+// TokenRates holds the prices for a model in USD: per 1K tokens for chat,
+// completions and embeddings, per image for image generation and per minute
+// for audio
 type TokenRates struct {
-	PromptTokenRate     float64
-	CompletionTokenRate float64
+	PromptTokenRate     float64 `json:"prompt" yaml:"prompt"`
+	CompletionTokenRate float64 `json:"completion" yaml:"completion"`
+
+	// ImageRates holds the price of one image by size, such as "1024x1024"
+	ImageRates map[string]float64 `json:"image,omitempty" yaml:"image,omitempty"`
+	// AudioMinuteRate is the price of one minute of audio
+	AudioMinuteRate float64 `json:"audio_minute,omitempty" yaml:"audio_minute,omitempty"`
+}
+
+// clone returns a copy of r that shares no maps with it
+func (r TokenRates) clone() TokenRates {
+	if r.ImageRates != nil {
+		images := make(map[string]float64, len(r.ImageRates))
+		for size, rate := range r.ImageRates {
+			images[size] = rate
+		}
+		r.ImageRates = images
+	}
+	return r
+}
+
+// cost returns the cost in USD of usage at these rates
+func (r TokenRates) cost(usage types.Usage) float64 {
+	return (float64(usage.PromptTokens) * r.PromptTokenRate / 1000) +
+		(float64(usage.CompletionTokens) * r.CompletionTokenRate / 1000)
 }
 
 // UsageStats holds usage statistics for a model
 type UsageStats struct {
 	TotalTokens     int
+	Images          int
+	Audio           time.Duration
 	TotalCost       float64
 	RequestCount    int
 	AverageLatency  time.Duration
 	LastRequestTime time.Time
+
+	// Currency is the ISO 4217 code of TotalCost; empty means US dollars
+	Currency string
+
+	// UnpricedRequests counts requests for a model missing from the pricing
+	// catalog, whose cost came from a fallback rate or was zero
+	UnpricedRequests int
+}
+
+// add adds a request's usage to the totals
+func (s *UsageStats) add(rec UsageRecord) {
+	s.TotalTokens += rec.Usage.TotalTokens
+	s.Images += rec.Images
+	s.Audio += rec.Audio
+	s.TotalCost += rec.Cost
+	s.RequestCount++
+	if rec.Time.After(s.LastRequestTime) {
+		s.LastRequestTime = rec.Time
+	}
+	if rec.Unpriced {
+		s.UnpricedRequests++
+	}
+}
+
+// convert expresses the total cost in currency, at rate units per US dollar
+func (s *UsageStats) convert(currency Currency, rate float64) {
+	if currency.isUSD() {
+		return
+	}
+	s.TotalCost *= rate
+	s.Currency = strings.ToUpper(currency.Code)
+}
+
+// Bucket widths for UsageSeries
+const (
+	Hourly = time.Hour
+	Daily  = 24 * time.Hour
+)
+
+// UsageBucket is the usage within one interval of a series
+type UsageBucket struct {
+	Start time.Time
+	UsageStats
+}
+
+// UsageGroup is the usage of the records sharing a set of tag values
+type UsageGroup struct {
+	Tags map[string]string
+	UsageStats
+}
+
+// Summarize adds up usage records
+func Summarize(records []UsageRecord) UsageStats {
+	var stats UsageStats
+	for _, rec := range records {
+		stats.add(rec)
+	}
+	return stats
 }
 
 // CostTracker tracks usage and costs across providers and models
 type CostTracker struct {
 	mu      sync.RWMutex
-	usage   map[string]map[string]*UsageStats // provider -> model -> stats
-	budgets map[string]map[string]float64     // provider -> model -> budget
+	store   Store
+	budgets map[string]map[string]float64 // provider -> model -> budget
+	// spent holds the US dollar cost of each budgeted provider and model,
+	// read from the store once and kept up to date as usage is recorded
+	spent map[[2]string]float64
+	// generation counts the stores set, so a failed record only corrects
+	// the totals it was counted in
+	generation int
+	catalog    *PricingCatalog
+	currency   Currency
+	now        func() time.Time
+
+	alertMu sync.Mutex
+	alerts  []*forecastAlert
 }
 
-// NewCostTracker creates a new cost tracker
+// NewCostTracker creates a new cost tracker priced from the default catalog
+// and keeping usage in memory
 func NewCostTracker() *CostTracker {
 	return &CostTracker{
-		usage:   make(map[string]map[string]*UsageStats),
+		store:   NewMemoryStore(),
 		budgets: make(map[string]map[string]float64),
+		spent:   make(map[[2]string]float64),
+		catalog: defaultCatalog,
+		now:     time.Now,
 	}
 }
 
-// TrackUsage records usage for a provider and model
-func (c *CostTracker) TrackUsage(provider, model string, usage types.Usage) error {
+// SetCatalog prices later usage from catalog instead of the default catalog
+func (c *CostTracker) SetCatalog(catalog *PricingCatalog) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.catalog = catalog
+}
+
+// SetStore keeps usage in store instead of in memory. Usage already tracked
+// is not copied over.
+func (c *CostTracker) SetStore(store Store) {
+	c.mu.Lock()
+	c.store = store
+	c.spent = make(map[[2]string]float64)
+	c.generation++
+	c.mu.Unlock()
 
-	// Initialize maps if they don't exist
-	if _, ok := c.usage[provider]; !ok {
-		c.usage[provider] = make(map[string]*UsageStats)
+	c.alertMu.Lock()
+	defer c.alertMu.Unlock()
+	for _, a := range c.alerts {
+		a.start = time.Time{}
 	}
-	if _, ok := c.usage[provider][model]; !ok {
-		c.usage[provider][model] = &UsageStats{}
+}
+
+// SetCurrency makes budgets and reports amounts in currency rather than US
+// dollars. Usage is still stored in US dollars and converted at the current
+// exchange rate when budgets are checked and reports are made.
+func (c *CostTracker) SetCurrency(currency Currency) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.currency = currency
+}
+
+// rate returns the tracker's currency and its units per US dollar
+func (c *CostTracker) rate(ctx context.Context) (Currency, float64, error) {
+	c.mu.RLock()
+	currency := c.currency
+	c.mu.RUnlock()
+
+	rate, err := currency.FromUSD(ctx, 1)
+	return currency, rate, err
+}
+
+// TrackUsage records usage for a provider and model. If the model is not in
+// the pricing catalog and the catalog's policy is UnknownModelError, nothing
+// is recorded and an error wrapping types.ErrUnknownModel is returned.
+//
+// Budgets are checked against running totals, read from the store the first
+// time a provider and model is checked and then kept up to date by this
+// tracker. With a store shared by several replicas, usage the others record
+// afterwards is not counted against this tracker's budgets.
+func (c *CostTracker) TrackUsage(provider, model string, usage types.Usage) error {
+	return c.TrackTaggedUsage(provider, model, usage, nil)
+}
+
+// TrackTaggedUsage is like TrackUsage but attributes the usage to tags,
+// such as a tenant, user or feature, for querying with SpendBy
+func (c *CostTracker) TrackTaggedUsage(provider, model string, usage types.Usage, tags map[string]string) error {
+	return c.TrackUsageWithCatalog(nil, provider, model, usage, tags)
+}
+
+// TrackUsageWithCatalog is like TrackTaggedUsage but prices the usage from
+// catalog, so that clients with their own prices can share a tracker. A nil
+// catalog uses the tracker's.
+func (c *CostTracker) TrackUsageWithCatalog(catalog *PricingCatalog, provider, model string, usage types.Usage, tags map[string]string) error {
+	rec := UsageRecord{Op: OpText, Provider: provider, Model: model, Usage: usage, Tags: tags}
+	return c.track(rec, func(p *PricingCatalog) (float64, bool, error) {
+		if catalog != nil {
+			p = catalog
+		}
+		return p.priceTokens(provider, model, usage)
+	})
+}
+
+// TrackEmbedding records an embedding request for the given number of input
+// tokens
+func (c *CostTracker) TrackEmbedding(provider, model string, tokens int, tags map[string]string) error {
+	usage := types.Usage{PromptTokens: tokens, TotalTokens: tokens}
+	rec := UsageRecord{Op: OpEmbedding, Provider: provider, Model: model, Usage: usage, Tags: tags}
+	return c.track(rec, func(p *PricingCatalog) (float64, bool, error) {
+		return p.priceTokens(provider, model, usage)
+	})
+}
+
+// TrackImages records the generation of n images of the given size
+func (c *CostTracker) TrackImages(provider, model, size string, n int, tags map[string]string) error {
+	rec := UsageRecord{Op: OpImage, Provider: provider, Model: model, Images: n, Tags: tags}
+	return c.track(rec, func(p *PricingCatalog) (float64, bool, error) {
+		return p.priceImages(provider, model, size, n)
+	})
+}
+
+// TrackAudio records a request processing the given duration of audio
+func (c *CostTracker) TrackAudio(provider, model string, d time.Duration, tags map[string]string) error {
+	rec := UsageRecord{Op: OpAudio, Provider: provider, Model: model, Audio: d, Tags: tags}
+	return c.track(rec, func(p *PricingCatalog) (float64, bool, error) {
+		return p.priceAudio(provider, model, d)
+	})
+}
+
+// track records rec, then runs any forecast alerts
+func (c *CostTracker) track(rec UsageRecord, price func(*PricingCatalog) (float64, bool, error)) error {
+	rec, err := c.record(rec, price)
+	if err != nil {
+		return err
 	}
+	c.checkForecasts(rec)
+	return nil
+}
+
+// record prices rec, checks it against the model's budget and stores it,
+// returning the stored record. The exchange rate is resolved and the record
+// stored without holding c.mu, since either may wait on the network.
+func (c *CostTracker) record(rec UsageRecord, price func(*PricingCatalog) (float64, bool, error)) (UsageRecord, error) {
+	ctx := context.Background()
+
+	c.mu.RLock()
+	catalog, currency, store := c.catalog, c.currency, c.store
+	budget, budgeted := c.budgets[rec.Provider][rec.Model]
+	c.mu.RUnlock()
 
 	// Calculate cost
-	rates := GetProviderRates()[provider][model]
-	cost := (float64(usage.PromptTokens) * rates.PromptTokenRate / 1000) +
-		(float64(usage.CompletionTokens) * rates.CompletionTokenRate / 1000)
-
-	// Check budget if set
-	if budget, ok := c.budgets[provider][model]; ok {
-		currentCost := c.usage[provider][model].TotalCost
-		if currentCost+cost > budget {
-			return fmt.Errorf("budget exceeded for %s %s: current cost %.2f + new cost %.2f > budget %.2f",
-				provider, model, currentCost, cost, budget)
+	cost, priced, err := price(catalog)
+	if err != nil {
+		return rec, err
+	}
+	rec.Time, rec.Cost, rec.Unpriced = c.now(), cost, !priced
+
+	// Budgets are in the tracker's currency
+	rate := 1.0
+	if budgeted {
+		if rate, err = currency.FromUSD(ctx, 1); err != nil {
+			return rec, err
 		}
 	}
 
-	// Update stats
-	stats := c.usage[provider][model]
-	stats.TotalTokens += usage.TotalTokens
-	stats.TotalCost += cost
-	stats.RequestCount++
-	stats.LastRequestTime = time.Now()
+	// The cost joins the running total before the record is stored, so
+	// concurrent records are checked against each other's costs
+	k := [2]string{rec.Provider, rec.Model}
+	c.mu.Lock()
+	if budgeted {
+		spent, err := c.spentOn(ctx, rec.Provider, rec.Model)
+		if err != nil {
+			c.mu.Unlock()
+			return rec, err
+		}
+		currentCost, newCost := spent*rate, cost*rate
+		if currentCost+newCost > budget {
+			c.mu.Unlock()
+			return rec, fmt.Errorf("%w for %s %s: current cost %.2f + new cost %.2f > budget %.2f",
+				types.ErrBudgetExceeded, rec.Provider, rec.Model, currentCost, newCost, budget)
+		}
+	}
+	_, counted := c.spent[k]
+	if counted {
+		c.spent[k] += rec.Cost
+	}
+	generation := c.generation
+	c.mu.Unlock()
 
-	return nil
+	if err := store.Add(ctx, rec); err != nil {
+		c.mu.Lock()
+		// Totals read from a store set since then never counted the cost
+		if counted && c.generation == generation {
+			c.spent[k] -= rec.Cost
+		}
+		c.mu.Unlock()
+		return rec, err
+	}
+	return rec, nil
 }
 
-// GetCost returns the total cost for a provider and model
-func (c *CostTracker) GetCost(provider, model string) (float64, error) {
+// spentOn returns the US dollar cost recorded for a provider and model,
+// reading it from the store only the first time. Callers must hold c.mu.
+func (c *CostTracker) spentOn(ctx context.Context, provider, model string) (float64, error) {
+	k := [2]string{provider, model}
+	if spent, ok := c.spent[k]; ok {
+		return spent, nil
+	}
+	records, err := c.store.Query(ctx, UsageQuery{Provider: provider, Model: model})
+	if err != nil {
+		return 0, err
+	}
+	spent := Summarize(records).TotalCost
+	c.spent[k] = spent
+	return spent, nil
+}
+
+// Query returns the usage records matching q, oldest first. Record costs
+// are in US dollars, whatever the tracker's currency.
+func (c *CostTracker) Query(ctx context.Context, q UsageQuery) ([]UsageRecord, error) {
 	c.mu.RLock()
-	defer c.mu.RUnlock()
+	store := c.store
+	c.mu.RUnlock()
+
+	return store.Query(ctx, q)
+}
 
-	if _, ok := c.usage[provider]; !ok {
-		return 0, fmt.Errorf("no usage tracked for provider %s", provider)
+// UsageSeries returns the usage matching q in buckets of the given width,
+// such as Hourly or Daily, oldest first. Buckets are aligned to UTC and
+// those without usage are left out.
+func (c *CostTracker) UsageSeries(ctx context.Context, q UsageQuery, width time.Duration) ([]UsageBucket, error) {
+	if width <= 0 {
+		return nil, fmt.Errorf("invalid bucket width %v", width)
 	}
-	if _, ok := c.usage[provider][model]; !ok {
-		return 0, fmt.Errorf("no usage tracked for model %s", model)
+	records, err := c.Query(ctx, q)
+	if err != nil {
+		return nil, err
 	}
 
-	return c.usage[provider][model].TotalCost, nil
+	currency, rate, err := c.rate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var buckets []UsageBucket
+	for _, rec := range records {
+		start := rec.Time.UTC().Truncate(width)
+		if n := len(buckets); n == 0 || !buckets[n-1].Start.Equal(start) {
+			buckets = append(buckets, UsageBucket{Start: start})
+		}
+		buckets[len(buckets)-1].add(rec)
+	}
+	for i := range buckets {
+		buckets[i].convert(currency, rate)
+	}
+	return buckets, nil
 }
 
-// GetUsageStats returns usage statistics for a provider and model within a time range
-func (c *CostTracker) GetUsageStats(provider, model string, start, end time.Time) (*UsageStats, error) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+// SpendBy returns the usage matching q grouped by the values of the given
+// tag keys, most expensive first. Records without a key are grouped under
+// an empty value for it.
+func (c *CostTracker) SpendBy(ctx context.Context, q UsageQuery, keys ...string) ([]UsageGroup, error) {
+	records, err := c.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	currency, rate, err := c.rate(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	if _, ok := c.usage[provider]; !ok {
-		return nil, fmt.Errorf("no usage tracked for provider %s", provider)
+	index := make(map[string]int)
+	var groups []UsageGroup
+	for _, rec := range records {
+		tags := make(map[string]string, len(keys))
+		id := make([]string, len(keys))
+		for i, k := range keys {
+			tags[k] = rec.Tags[k]
+			id[i] = rec.Tags[k]
+		}
+		key := strings.Join(id, "\x00")
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, UsageGroup{Tags: tags})
+		}
+		groups[i].add(rec)
 	}
-	if _, ok := c.usage[provider][model]; !ok {
-		return nil, fmt.Errorf("no usage tracked for model %s", model)
+	for i := range groups {
+		groups[i].convert(currency, rate)
 	}
 
-	stats := c.usage[provider][model]
-	if stats.LastRequestTime.Before(start) || stats.LastRequestTime.After(end) {
-		return nil, fmt.Errorf("no usage data in specified time range")
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].TotalCost > groups[j].TotalCost
+	})
+	return groups, nil
+}
+
+// GetCost returns the total cost for a provider and model, in the tracker's
+// currency
+func (c *CostTracker) GetCost(provider, model string) (float64, error) {
+	stats, err := c.GetUsageStats(provider, model, time.Time{}, time.Time{})
+	if err != nil {
+		return 0, err
+	}
+	return stats.TotalCost, nil
+}
+
+// GetUsageStats returns usage statistics for a provider and model within a
+// time range. A zero start or end leaves that side of the range open.
+func (c *CostTracker) GetUsageStats(provider, model string, start, end time.Time) (*UsageStats, error) {
+	// Include requests made at exactly end
+	if !end.IsZero() {
+		end = end.Add(time.Nanosecond)
+	}
+	ctx := context.Background()
+	records, err := c.Query(ctx, UsageQuery{Provider: provider, Model: model, Start: start, End: end})
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no usage tracked for %s model %s in the specified time range", provider, model)
 	}
 
-	return stats, nil
+	currency, rate, err := c.rate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats := Summarize(records)
+	stats.convert(currency, rate)
+	return &stats, nil
 }
 
-// SetBudget sets a budget for a provider and model
+// SetBudget sets a budget for a provider and model, in the tracker's
+// currency
 func (c *CostTracker) SetBudget(provider, model string, budget float64) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -123,32 +461,15 @@ func (c *CostTracker) SetBudget(provider, model string, budget float64) error {
 	return nil
 }
 
-// GetProviderRates returns the token rates for all providers and models
+// CalculateCost returns the cost in USD of the given token usage, priced
+// from the default catalog. Models without rates follow the catalog's
+// UnknownModelPolicy, costing zero where it would fail.
+func CalculateCost(provider, model string, usage types.Usage) float64 {
+	return defaultCatalog.Cost(provider, model, usage)
+}
+
+// GetProviderRates returns the token rates in the default catalog. Keys may
+// be model patterns; use DefaultCatalog().Lookup to resolve a model name.
 func GetProviderRates() map[string]map[string]TokenRates {
-	return map[string]map[string]TokenRates{
-		"openai": {
-			"gpt-4": {
-				PromptTokenRate:     0.03, // $0.03 per 1K tokens
-				CompletionTokenRate: 0.06, // $0.06 per 1K tokens
-			},
-			"gpt-3.5-turbo": {
-				PromptTokenRate:     0.002, // $0.002 per 1K tokens
-				CompletionTokenRate: 0.002, // $0.002 per 1K tokens
-			},
-		},
-		"anthropic": {
-			"claude-2.1": {
-				PromptTokenRate:     0.008, // $0.008 per 1K tokens
-				CompletionTokenRate: 0.024, // $0.024 per 1K tokens
-			},
-			"claude-2": {
-				PromptTokenRate:     0.008, // $0.008 per 1K tokens
-				CompletionTokenRate: 0.024, // $0.024 per 1K tokens
-			},
-			"claude-instant": {
-				PromptTokenRate:     0.0008, // $0.0008 per 1K tokens
-				CompletionTokenRate: 0.0024, // $0.0024 per 1K tokens
-			},
-		},
-	}
+	return defaultCatalog.Rates()
 }
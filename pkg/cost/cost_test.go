@@ -1,6 +1,8 @@
 package cost
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -107,6 +109,71 @@ func TestCostTracker_CheckBudget(t *testing.T) {
 	}
 }
 
+// blockingStore holds each Add until released
+type blockingStore struct {
+	*MemoryStore
+	added   chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStore) Add(ctx context.Context, rec UsageRecord) error {
+	s.added <- struct{}{}
+	<-s.release
+	return s.MemoryStore.Add(ctx, rec)
+}
+
+func TestCostTracker_ConcurrentRecords(t *testing.T) {
+	store := &blockingStore{MemoryStore: NewMemoryStore(), added: make(chan struct{}), release: make(chan struct{})}
+	tracker := NewCostTracker()
+	tracker.SetStore(store)
+	tracker.SetBudget("openai", "gpt-4", 1.00)
+
+	// Each record costs $0.30, so three fit the budget
+	usage := types.Usage{PromptTokens: 10000, CompletionTokens: 0}
+	const records = 6
+	errs := make(chan error, records)
+	for i := 0; i < records; i++ {
+		go func() { errs <- tracker.TrackUsage("openai", "gpt-4", usage) }()
+	}
+
+	stored, rejected := 0, 0
+	for stored+rejected < records {
+		select {
+		case <-store.added:
+			stored++
+		case err := <-errs:
+			if !errors.Is(err, types.ErrBudgetExceeded) {
+				t.Errorf("TrackUsage() error = %v, want %v", err, types.ErrBudgetExceeded)
+			}
+			rejected++
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out with %d records stored and %d rejected", stored, rejected)
+		}
+	}
+
+	// The tracker is not locked while records are being stored
+	done := make(chan struct{})
+	go func() {
+		tracker.SetCurrency(Currency{})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("SetCurrency() blocked while records were being stored")
+	}
+
+	close(store.release)
+	for i := 0; i < stored; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("TrackUsage() error = %v", err)
+		}
+	}
+	if stored != 3 {
+		t.Errorf("stored %d concurrent records, want 3 within the budget", stored)
+	}
+}
+
 func TestCostTracker_GetProviderRates(t *testing.T) {
 	rates := GetProviderRates()
 
@@ -126,3 +193,48 @@ func TestCostTracker_GetProviderRates(t *testing.T) {
 		t.Errorf("GPT-4 completion rate = %v, want %v", gpt4Rates.CompletionTokenRate, expectedCompletionRate)
 	}
 }
+
+func TestCostTracker_SpendBy(t *testing.T) {
+	tracker := NewCostTracker()
+	usage := types.Usage{PromptTokens: 1000, TotalTokens: 1000} // $0.03 on gpt-4
+
+	tracked := []map[string]string{
+		{"tenant": "acme", "user": "u1"},
+		{"tenant": "globex", "user": "u2"},
+		{"tenant": "globex", "user": "u3"},
+		nil,
+	}
+	for _, tags := range tracked {
+		if err := tracker.TrackTaggedUsage("openai", "gpt-4", usage, tags); err != nil {
+			t.Fatalf("TrackTaggedUsage() error = %v", err)
+		}
+	}
+
+	groups, err := tracker.SpendBy(context.Background(), UsageQuery{}, "tenant")
+	if err != nil {
+		t.Fatalf("SpendBy() error = %v", err)
+	}
+
+	want := []struct {
+		tenant   string
+		requests int
+	}{
+		{"globex", 2},
+		{"acme", 1},
+		{"", 1},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("SpendBy() returned %d groups, want %d", len(groups), len(want))
+	}
+	for i, w := range want {
+		if groups[i].Tags["tenant"] != w.tenant || groups[i].RequestCount != w.requests {
+			t.Errorf("group %d = %v with %d requests, want %q with %d", i, groups[i].Tags, groups[i].RequestCount, w.tenant, w.requests)
+		}
+	}
+
+	// Filtering by tag narrows the records grouped
+	groups, _ = tracker.SpendBy(context.Background(), UsageQuery{Tags: map[string]string{"tenant": "globex"}}, "user")
+	if len(groups) != 2 {
+		t.Errorf("SpendBy() for globex returned %d groups, want 2", len(groups))
+	}
+}
@@ -0,0 +1,135 @@
+package cost
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// USD is the currency prices are quoted and usage is stored in
+const USD = "USD"
+
+// ExchangeRates converts US dollars into other currencies
+type ExchangeRates interface {
+	// USDRate returns the units of currency, an ISO 4217 code such as
+	// "EUR", that one US dollar buys
+	USDRate(ctx context.Context, currency string) (float64, error)
+}
+
+// ExchangeRatesFunc adapts a function to the ExchangeRates interface
+type ExchangeRatesFunc func(ctx context.Context, currency string) (float64, error)
+
+// USDRate implements ExchangeRates
+func (f ExchangeRatesFunc) USDRate(ctx context.Context, currency string) (float64, error) {
+	return f(ctx, currency)
+}
+
+// FixedRates holds exchange rates by currency code, in units per US dollar
+type FixedRates map[string]float64
+
+// USDRate implements ExchangeRates
+func (r FixedRates) USDRate(_ context.Context, currency string) (float64, error) {
+	currency = strings.ToUpper(currency)
+	if currency == USD {
+		return 1, nil
+	}
+	rate, ok := r[currency]
+	if !ok || rate <= 0 {
+		return 0, fmt.Errorf("%w: %s", types.ErrUnknownCurrency, currency)
+	}
+	return rate, nil
+}
+
+// CachedRates caches the rates of a slower source, such as an HTTP API, for
+// a fixed time
+type CachedRates struct {
+	src ExchangeRates
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cachedRate
+}
+
+type cachedRate struct {
+	rate    float64
+	fetched time.Time
+}
+
+// NewCachedRates caches each rate from src for ttl
+func NewCachedRates(src ExchangeRates, ttl time.Duration) *CachedRates {
+	return &CachedRates{
+		src:     src,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]cachedRate),
+	}
+}
+
+// USDRate implements ExchangeRates. If a refresh fails, the last rate
+// fetched is returned until the source recovers.
+func (c *CachedRates) USDRate(ctx context.Context, currency string) (float64, error) {
+	currency = strings.ToUpper(currency)
+	if currency == USD {
+		return 1, nil
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[currency]
+	c.mu.Unlock()
+	if ok && c.now().Sub(entry.fetched) < c.ttl {
+		return entry.rate, nil
+	}
+
+	rate, err := c.src.USDRate(ctx, currency)
+	if err != nil {
+		if ok {
+			return entry.rate, nil
+		}
+		return 0, err
+	}
+
+	c.mu.Lock()
+	c.entries[currency] = cachedRate{rate: rate, fetched: c.now()}
+	c.mu.Unlock()
+	return rate, nil
+}
+
+// Currency converts US dollar amounts into a display currency. The zero
+// value is US dollars.
+type Currency struct {
+	Code  string
+	Rates ExchangeRates
+}
+
+// isUSD reports whether c leaves amounts in US dollars
+func (c Currency) isUSD() bool {
+	return c.Code == "" || strings.EqualFold(c.Code, USD)
+}
+
+// FromUSD converts an amount in US dollars into c
+func (c Currency) FromUSD(ctx context.Context, usd float64) (float64, error) {
+	if c.isUSD() {
+		return usd, nil
+	}
+	if c.Rates == nil {
+		return 0, fmt.Errorf("%w: no exchange rates for %s", types.ErrUnknownCurrency, c.Code)
+	}
+	rate, err := c.Rates.USDRate(ctx, c.Code)
+	if err != nil {
+		return 0, fmt.Errorf("converting to %s: %w", c.Code, err)
+	}
+	return usd * rate, nil
+}
+
+// Format formats an amount in c for messages
+func (c Currency) Format(amount float64) string {
+	if c.isUSD() {
+		return fmt.Sprintf("$%.4f", amount)
+	}
+	return fmt.Sprintf("%.4f %s", amount, strings.ToUpper(c.Code))
+}
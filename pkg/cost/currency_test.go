@@ -0,0 +1,135 @@
+package cost
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestCurrency_FromUSD(t *testing.T) {
+	rates := FixedRates{"EUR": 0.9, "GBP": 0.8}
+
+	tests := []struct {
+		name     string
+		currency Currency
+		want     float64
+		wantErr  error
+	}{
+		{"zero value is USD", Currency{}, 10, nil},
+		{"USD", Currency{Code: "usd"}, 10, nil},
+		{"EUR", Currency{Code: "EUR", Rates: rates}, 9, nil},
+		{"lower case code", Currency{Code: "gbp", Rates: rates}, 8, nil},
+		{"missing rate", Currency{Code: "JPY", Rates: rates}, 0, types.ErrUnknownCurrency},
+		{"no rates", Currency{Code: "EUR"}, 0, types.ErrUnknownCurrency},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.currency.FromUSD(context.Background(), 10)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FromUSD() error = %v, want %v", err, tt.wantErr)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("FromUSD() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachedRates(t *testing.T) {
+	var calls int
+	var fail bool
+	src := ExchangeRatesFunc(func(ctx context.Context, currency string) (float64, error) {
+		calls++
+		if fail {
+			return 0, errors.New("rates unavailable")
+		}
+		return float64(calls), nil
+	})
+
+	now := time.Now()
+	rates := NewCachedRates(src, time.Hour)
+	rates.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	if rate, _ := rates.USDRate(ctx, "EUR"); rate != 1 {
+		t.Errorf("USDRate() = %v, want 1", rate)
+	}
+	if rate, _ := rates.USDRate(ctx, "EUR"); rate != 1 || calls != 1 {
+		t.Errorf("USDRate() = %v after %d calls, want the cached 1", rate, calls)
+	}
+
+	// An expired rate is kept while the source is failing
+	now = now.Add(2 * time.Hour)
+	fail = true
+	if rate, err := rates.USDRate(ctx, "EUR"); err != nil || rate != 1 {
+		t.Errorf("USDRate() with failing source = %v, %v, want 1", rate, err)
+	}
+	if _, err := rates.USDRate(ctx, "GBP"); err == nil {
+		t.Error("USDRate() for an uncached currency with failing source error = nil")
+	}
+
+	fail = false
+	if rate, _ := rates.USDRate(ctx, "EUR"); rate != 4 {
+		t.Errorf("USDRate() after recovery = %v, want 4", rate)
+	}
+}
+
+func TestBudgetGuard_Currency(t *testing.T) {
+	g := NewBudgetGuard(1, 10) // EUR
+	g.SetCurrency(Currency{Code: "EUR", Rates: FixedRates{"EUR": 0.5}})
+
+	// $1.80 is 0.90 EUR
+	if err := g.Check(1.8); err != nil {
+		t.Errorf("Check() error = %v", err)
+	}
+	// $2.20 is 1.10 EUR
+	err := g.Check(2.2)
+	if !errors.Is(err, types.ErrBudgetExceeded) {
+		t.Fatalf("Check() error = %v, want %v", err, types.ErrBudgetExceeded)
+	}
+	if want := "estimated request cost 1.1000 EUR exceeds per-request limit 1.0000 EUR"; !strings.Contains(err.Error(), want) {
+		t.Errorf("Check() error = %q, want it to contain %q", err, want)
+	}
+
+	g.SetCurrency(Currency{Code: "CHF", Rates: FixedRates{}})
+	if err := g.Check(0.1); !errors.Is(err, types.ErrUnknownCurrency) {
+		t.Errorf("Check() without a rate error = %v, want %v", err, types.ErrUnknownCurrency)
+	}
+}
+
+func TestCostTracker_Currency(t *testing.T) {
+	tracker := NewCostTracker()
+	tracker.SetCurrency(Currency{Code: "GBP", Rates: FixedRates{"GBP": 0.8}})
+	tracker.SetBudget("openai", "gpt-4", 0.05) // GBP
+
+	usage := types.Usage{PromptTokens: 1000, TotalTokens: 1000} // $0.03, 0.024 GBP
+	if err := tracker.TrackUsage("openai", "gpt-4", usage); err != nil {
+		t.Fatalf("TrackUsage() error = %v", err)
+	}
+	if err := tracker.TrackUsage("openai", "gpt-4", usage); err != nil {
+		t.Fatalf("TrackUsage() within the GBP budget error = %v", err)
+	}
+	if err := tracker.TrackUsage("openai", "gpt-4", usage); !errors.Is(err, types.ErrBudgetExceeded) {
+		t.Errorf("TrackUsage() over the GBP budget error = %v, want %v", err, types.ErrBudgetExceeded)
+	}
+
+	stats, err := tracker.GetUsageStats("openai", "gpt-4", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("GetUsageStats() error = %v", err)
+	}
+	if stats.Currency != "GBP" || math.Abs(stats.TotalCost-0.048) > 1e-9 {
+		t.Errorf("GetUsageStats() = %v %s, want 0.048 GBP", stats.TotalCost, stats.Currency)
+	}
+
+	// Raw records stay in US dollars
+	records, _ := tracker.Query(context.Background(), UsageQuery{})
+	if math.Abs(records[0].Cost-0.03) > 1e-9 {
+		t.Errorf("record cost = %v, want 0.03 USD", records[0].Cost)
+	}
+}
@@ -0,0 +1,163 @@
+package cost
+
+import (
+	"context"
+	"time"
+)
+
+// Period returns the start and end of the budget period containing now
+type Period func(now time.Time) (start, end time.Time)
+
+// CalendarDay is the UTC day containing now
+func CalendarDay(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// CalendarMonth is the UTC month containing now
+func CalendarMonth(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// Forecast projects the spend at the end of a period from the spend so far
+type Forecast struct {
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+
+	// Spent is the spend from the start of the period until now
+	Spent float64
+	// RunRate is the average spend per day since the start of the period
+	RunRate float64
+	// Projected is the spend expected by the end of the period if the run
+	// rate holds
+	Projected float64
+	// Currency is the ISO 4217 code of the amounts; empty means US dollars
+	Currency string
+}
+
+// Forecast projects the spend of the usage matching q to the end of the
+// current period, in the tracker's currency. The start and end of q are
+// replaced by the period's. Early in a period the run rate rests on little
+// usage, so projections are noisy.
+func (c *CostTracker) Forecast(ctx context.Context, q UsageQuery, period Period) (*Forecast, error) {
+	now := c.now()
+	start, end := period(now)
+	q.Start, q.End = start, end
+
+	records, err := c.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	currency, rate, err := c.rate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return project(now, start, end, Summarize(records).TotalCost, currency, rate), nil
+}
+
+// project forecasts a period's spend from the US dollar spend until now
+func project(now, start, end time.Time, spent float64, currency Currency, rate float64) *Forecast {
+	stats := UsageStats{TotalCost: spent}
+	stats.convert(currency, rate)
+
+	f := &Forecast{
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Spent:       stats.TotalCost,
+		Projected:   stats.TotalCost,
+		Currency:    stats.Currency,
+	}
+	if elapsed := now.Sub(start); elapsed > 0 {
+		f.RunRate = f.Spent / elapsed.Hours() * 24
+		f.Projected = f.Spent / elapsed.Hours() * end.Sub(start).Hours()
+	}
+	return f
+}
+
+// ForecastAlert is notified when the projected spend of some usage exceeds
+// a limit
+type ForecastAlert struct {
+	// Query selects the usage counted, such as one provider or tenant
+	Query  UsageQuery
+	Period Period
+	// Limit is the spend allowed in a period, in the tracker's currency
+	Limit float64
+	// Warmup is how much of each period must pass before alerting, since
+	// early projections are noisy
+	Warmup time.Duration
+	// Notify is called at most once per period, from the goroutine that
+	// tracked the usage pushing the projection over Limit
+	Notify func(Forecast)
+}
+
+// forecastAlert is a registered alert, the period it last fired in, and
+// the running US dollar spend of the period being counted
+type forecastAlert struct {
+	ForecastAlert
+	fired time.Time
+	start time.Time
+	spent float64
+}
+
+// AddForecastAlert checks the projected spend after each tracked request
+// and notifies alert when it exceeds the limit
+func (c *CostTracker) AddForecastAlert(alert ForecastAlert) {
+	c.alertMu.Lock()
+	defer c.alertMu.Unlock()
+	c.alerts = append(c.alerts, &forecastAlert{ForecastAlert: alert})
+}
+
+// checkForecasts adds rec to the running spend of each alert's period, and
+// notifies the alerts whose projection is over their limit and that have
+// not fired this period. A period's spend is read from the store when it
+// begins, so the store is not queried on every request.
+func (c *CostTracker) checkForecasts(rec UsageRecord) {
+	type notification struct {
+		notify   func(Forecast)
+		forecast Forecast
+	}
+	var due []notification
+
+	ctx := context.Background()
+	c.alertMu.Lock()
+	now := c.now()
+	for _, a := range c.alerts {
+		start, end := a.Period(now)
+		if a.fired.Equal(start) {
+			continue
+		}
+		q := a.Query
+		q.Start, q.End = start, end
+		if !a.start.Equal(start) {
+			records, err := c.Query(ctx, q)
+			if err != nil {
+				continue
+			}
+			a.start, a.spent = start, Summarize(records).TotalCost
+		} else if q.matches(rec) {
+			a.spent += rec.Cost
+		}
+
+		if now.Sub(start) < a.Warmup {
+			continue
+		}
+		currency, rate, err := c.rate(ctx)
+		if err != nil {
+			continue
+		}
+		f := project(now, start, end, a.spent, currency, rate)
+		if f.Projected <= a.Limit {
+			continue
+		}
+		a.fired = start
+		due = append(due, notification{a.Notify, *f})
+	}
+	c.alertMu.Unlock()
+
+	for _, n := range due {
+		n.notify(n.forecast)
+	}
+}
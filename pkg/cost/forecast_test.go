@@ -0,0 +1,95 @@
+package cost
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestPeriods(t *testing.T) {
+	now := time.Date(2024, 2, 10, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		period    Period
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{"day", CalendarDay, time.Date(2024, 2, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 11, 0, 0, 0, 0, time.UTC)},
+		{"month", CalendarMonth, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := tt.period(now)
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Errorf("period = %v to %v, want %v to %v", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestCostTracker_Forecast(t *testing.T) {
+	// Ten days into a 30 day month
+	now := time.Date(2024, 4, 11, 0, 0, 0, 0, time.UTC)
+	tracker := NewCostTracker()
+	tracker.now = func() time.Time { return now }
+	store := &countingStore{MemoryStore: NewMemoryStore()}
+	tracker.SetStore(store)
+
+	var alerts []Forecast
+	tracker.AddForecastAlert(ForecastAlert{
+		Query:  UsageQuery{Provider: "openai"},
+		Period: CalendarMonth,
+		Limit:  1,
+		Warmup: 24 * time.Hour,
+		Notify: func(f Forecast) { alerts = append(alerts, f) },
+	})
+
+	// $0.03 per request
+	usage := types.Usage{PromptTokens: 1000, TotalTokens: 1000}
+	for i := 0; i < 10; i++ {
+		if err := tracker.TrackUsage("openai", "gpt-4", usage); err != nil {
+			t.Fatalf("TrackUsage() error = %v", err)
+		}
+	}
+
+	// The period's spend is read from the store once, then kept running
+	if store.queries != 1 {
+		t.Errorf("store queried %d times for the alert, want 1", store.queries)
+	}
+
+	f, err := tracker.Forecast(context.Background(), UsageQuery{Provider: "openai"}, CalendarMonth)
+	if err != nil {
+		t.Fatalf("Forecast() error = %v", err)
+	}
+	if math.Abs(f.Spent-0.3) > 1e-9 || math.Abs(f.RunRate-0.03) > 1e-9 || math.Abs(f.Projected-0.9) > 1e-9 {
+		t.Errorf("Forecast() = %+v, want $0.30 spent, $0.03/day, $0.90 projected", f)
+	}
+	if len(alerts) != 0 {
+		t.Fatalf("alert fired with projection under the limit: %+v", alerts)
+	}
+
+	// The twelfth request projects $1.08, over the limit, alerting once
+	for i := 0; i < 4; i++ {
+		tracker.TrackUsage("openai", "gpt-4", usage)
+	}
+	if len(alerts) != 1 || math.Abs(alerts[0].Projected-1.08) > 1e-9 {
+		t.Errorf("alerts = %+v, want one projecting $1.08", alerts)
+	}
+
+	// The alert fires again in the next period, once past the warmup
+	now = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tracker.TrackUsage("openai", "gpt-4", types.Usage{PromptTokens: 100000, TotalTokens: 100000})
+	if len(alerts) != 1 {
+		t.Errorf("alert fired during the warmup")
+	}
+	now = now.Add(24 * time.Hour)
+	tracker.TrackUsage("openai", "gpt-4", usage)
+	if len(alerts) != 2 {
+		t.Errorf("alerts = %d, want a second alert in the new period", len(alerts))
+	}
+}
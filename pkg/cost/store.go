@@ -0,0 +1,319 @@
+package cost
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// Store persists the usage records of a CostTracker. The default is an
+// in-memory store; SQLStore keeps records in a database so they survive
+// restarts and are shared by every replica writing to the same table.
+type Store interface {
+	// Add stores the usage of a single request
+	Add(ctx context.Context, rec UsageRecord) error
+	// Query returns the records matching q, oldest first
+	Query(ctx context.Context, q UsageQuery) ([]UsageRecord, error)
+}
+
+// Operations recorded in UsageRecord.Op
+const (
+	OpText      = "text" // chat and text completions
+	OpEmbedding = "embedding"
+	OpImage     = "image"
+	OpAudio     = "audio"
+)
+
+// UsageRecord is the usage of a single request
+type UsageRecord struct {
+	Time     time.Time
+	Op       string
+	Provider string
+	Model    string
+	Usage    types.Usage
+	Images   int
+	Audio    time.Duration
+	Cost     float64
+	// Unpriced is set when the model was missing from the pricing catalog
+	Unpriced bool
+	Tags     map[string]string
+}
+
+// UsageQuery selects usage records. Empty fields match everything.
+type UsageQuery struct {
+	Op       string
+	Provider string
+	Model    string
+	// Start and End bound the record time; Start is inclusive, End exclusive
+	Start time.Time
+	End   time.Time
+	// Tags must all be present on a record with the given values
+	Tags map[string]string
+}
+
+// matches reports whether rec is selected by q
+func (q UsageQuery) matches(rec UsageRecord) bool {
+	if q.Op != "" && rec.Op != q.Op {
+		return false
+	}
+	if q.Provider != "" && rec.Provider != q.Provider {
+		return false
+	}
+	if q.Model != "" && rec.Model != q.Model {
+		return false
+	}
+	if !q.Start.IsZero() && rec.Time.Before(q.Start) {
+		return false
+	}
+	if !q.End.IsZero() && !rec.Time.Before(q.End) {
+		return false
+	}
+	return hasTags(rec.Tags, q.Tags)
+}
+
+// hasTags reports whether tags contains every entry of want
+func hasTags(tags, want map[string]string) bool {
+	for k, v := range want {
+		if got, ok := tags[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// DefaultRetention is how long a MemoryStore keeps records, long enough for
+// calendar-month reports and forecasts
+const DefaultRetention = 32 * 24 * time.Hour
+
+// MemoryStore keeps usage records in memory. Records older than its
+// retention, measured back from the newest record, are dropped as new ones
+// are added, which bounds its size in long-running processes.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	records   []UsageRecord
+	retention time.Duration
+}
+
+// NewMemoryStore creates an empty in-memory store keeping records for
+// DefaultRetention
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{retention: DefaultRetention}
+}
+
+// SetRetention sets how long records are kept. Zero keeps them until they
+// are deleted with Prune.
+func (s *MemoryStore) SetRetention(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retention = d
+}
+
+// Add implements Store
+func (s *MemoryStore) Add(_ context.Context, rec UsageRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Keep records ordered by time even if they arrive out of order
+	i := len(s.records)
+	for i > 0 && s.records[i-1].Time.After(rec.Time) {
+		i--
+	}
+	s.records = append(s.records, UsageRecord{})
+	copy(s.records[i+1:], s.records[i:])
+	s.records[i] = rec
+
+	if s.retention > 0 {
+		cutoff := s.records[len(s.records)-1].Time.Add(-s.retention)
+		i = 0
+		for i < len(s.records) && s.records[i].Time.Before(cutoff) {
+			i++
+		}
+		// Reslicing is cheap; the dropped records are freed when append
+		// next grows the slice
+		s.records = s.records[i:]
+	}
+	return nil
+}
+
+// Query implements Store
+func (s *MemoryStore) Query(_ context.Context, q UsageQuery) ([]UsageRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []UsageRecord
+	for _, rec := range s.records {
+		if q.matches(rec) {
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
+
+// Prune removes records older than before
+func (s *MemoryStore) Prune(before time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := 0
+	for i < len(s.records) && s.records[i].Time.Before(before) {
+		i++
+	}
+	s.records = append(s.records[:0], s.records[i:]...)
+}
+
+// SQLStore keeps usage records in a database table, one row per request.
+// The driver is chosen by the caller, which keeps this package free of
+// database dependencies.
+type SQLStore struct {
+	db       *sql.DB
+	table    string
+	postgres bool
+}
+
+// NewSQLiteStore creates a store writing to table in a SQLite database.
+// Call CreateTable to create the table if it does not exist.
+func NewSQLiteStore(db *sql.DB, table string) *SQLStore {
+	return &SQLStore{db: db, table: table}
+}
+
+// NewPostgresStore creates a store writing to table in a Postgres database.
+// Call CreateTable to create the table if it does not exist.
+func NewPostgresStore(db *sql.DB, table string) *SQLStore {
+	return &SQLStore{db: db, table: table, postgres: true}
+}
+
+// CreateTable creates the usage table and its index if they do not exist
+func (s *SQLStore) CreateTable(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.table+` (
+		time BIGINT NOT NULL,
+		op TEXT NOT NULL,
+		provider TEXT NOT NULL,
+		model TEXT NOT NULL,
+		prompt_tokens BIGINT NOT NULL,
+		completion_tokens BIGINT NOT NULL,
+		total_tokens BIGINT NOT NULL,
+		images BIGINT NOT NULL,
+		audio_ms BIGINT NOT NULL,
+		cost DOUBLE PRECISION NOT NULL,
+		unpriced BOOLEAN NOT NULL,
+		tags TEXT NOT NULL
+	)`); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS `+s.table+`_time_idx ON `+s.table+` (provider, model, time)`)
+	return err
+}
+
+// Add implements Store
+func (s *SQLStore) Add(ctx context.Context, rec UsageRecord) error {
+	tags, err := json.Marshal(rec.Tags)
+	if err != nil {
+		return fmt.Errorf("encoding usage tags: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, s.query(`INSERT INTO `+s.table+`
+		(time, op, provider, model, prompt_tokens, completion_tokens, total_tokens, images, audio_ms, cost, unpriced, tags)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		rec.Time.UnixNano(), rec.Op, rec.Provider, rec.Model,
+		rec.Usage.PromptTokens, rec.Usage.CompletionTokens, rec.Usage.TotalTokens,
+		rec.Images, rec.Audio.Milliseconds(), rec.Cost, rec.Unpriced, string(tags),
+	)
+	if err != nil {
+		return fmt.Errorf("storing usage: %w", err)
+	}
+	return nil
+}
+
+// Query implements Store. Operation, provider, model and time are filtered in the
+// database; tags are filtered as rows are read.
+func (s *SQLStore) Query(ctx context.Context, q UsageQuery) ([]UsageRecord, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if q.Op != "" {
+		where, args = append(where, "op = ?"), append(args, q.Op)
+	}
+	if q.Provider != "" {
+		where, args = append(where, "provider = ?"), append(args, q.Provider)
+	}
+	if q.Model != "" {
+		where, args = append(where, "model = ?"), append(args, q.Model)
+	}
+	if !q.Start.IsZero() {
+		where, args = append(where, "time >= ?"), append(args, q.Start.UnixNano())
+	}
+	if !q.End.IsZero() {
+		where, args = append(where, "time < ?"), append(args, q.End.UnixNano())
+	}
+
+	stmt := `SELECT time, op, provider, model, prompt_tokens, completion_tokens, total_tokens, images, audio_ms, cost, unpriced, tags FROM ` + s.table
+	if len(where) > 0 {
+		stmt += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	stmt += ` ORDER BY time`
+
+	rows, err := s.db.QueryContext(ctx, s.query(stmt), args...)
+	if err != nil {
+		return nil, fmt.Errorf("reading usage: %w", err)
+	}
+	defer rows.Close()
+
+	var out []UsageRecord
+	for rows.Next() {
+		var (
+			rec     UsageRecord
+			at      int64
+			audioMS int64
+			tags    string
+		)
+		if err := rows.Scan(&at, &rec.Op, &rec.Provider, &rec.Model,
+			&rec.Usage.PromptTokens, &rec.Usage.CompletionTokens, &rec.Usage.TotalTokens,
+			&rec.Images, &audioMS, &rec.Cost, &rec.Unpriced, &tags); err != nil {
+			return nil, fmt.Errorf("reading usage: %w", err)
+		}
+		if err := json.Unmarshal([]byte(tags), &rec.Tags); err != nil {
+			return nil, fmt.Errorf("decoding usage tags: %w", err)
+		}
+		rec.Time = time.Unix(0, at)
+		rec.Audio = time.Duration(audioMS) * time.Millisecond
+		if hasTags(rec.Tags, q.Tags) {
+			out = append(out, rec)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading usage: %w", err)
+	}
+	return out, nil
+}
+
+// Prune deletes records older than before
+func (s *SQLStore) Prune(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.query(`DELETE FROM `+s.table+` WHERE time < ?`), before.UnixNano())
+	return err
+}
+
+// query rewrites "?" placeholders to "$1", "$2", ... for Postgres
+func (s *SQLStore) query(q string) string {
+	if !s.postgres {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,215 @@
+package cost
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestMemoryStore_Query(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	records := []UsageRecord{
+		{Time: base, Provider: "openai", Model: "gpt-4", Cost: 1, Tags: map[string]string{"team": "search"}},
+		{Time: base.Add(2 * time.Hour), Provider: "openai", Model: "gpt-4o", Cost: 2},
+		{Time: base.Add(time.Hour), Provider: "anthropic", Model: "claude-2", Cost: 4, Tags: map[string]string{"team": "ads"}},
+	}
+	for _, rec := range records {
+		if err := store.Add(ctx, rec); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query UsageQuery
+		want  []float64 // costs, in the order returned
+	}{
+		{"all", UsageQuery{}, []float64{1, 4, 2}},
+		{"provider", UsageQuery{Provider: "openai"}, []float64{1, 2}},
+		{"model", UsageQuery{Provider: "openai", Model: "gpt-4o"}, []float64{2}},
+		{"start inclusive", UsageQuery{Start: base.Add(time.Hour)}, []float64{4, 2}},
+		{"end exclusive", UsageQuery{End: base.Add(time.Hour)}, []float64{1}},
+		{"tags", UsageQuery{Tags: map[string]string{"team": "ads"}}, []float64{4}},
+		{"no match", UsageQuery{Provider: "mistral"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.Query(ctx, tt.query)
+			if err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+			var costs []float64
+			for _, rec := range got {
+				costs = append(costs, rec.Cost)
+			}
+			if !reflect.DeepEqual(costs, tt.want) {
+				t.Errorf("Query() costs = %v, want %v", costs, tt.want)
+			}
+		})
+	}
+
+	store.Prune(base.Add(time.Hour))
+	if got, _ := store.Query(ctx, UsageQuery{}); len(got) != 2 {
+		t.Errorf("Query() after Prune returned %d records, want 2", len(got))
+	}
+}
+
+func TestMemoryStore_Retention(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	store.SetRetention(24 * time.Hour)
+
+	base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	for _, at := range []time.Time{base, base.Add(12 * time.Hour), base.Add(30 * time.Hour)} {
+		if err := store.Add(ctx, UsageRecord{Time: at, Cost: 1}); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	got, _ := store.Query(ctx, UsageQuery{})
+	if len(got) != 2 || !got[0].Time.Equal(base.Add(12*time.Hour)) {
+		t.Errorf("Query() = %+v, want the two records within a day of the newest", got)
+	}
+
+	store.SetRetention(0)
+	store.Add(ctx, UsageRecord{Time: base.Add(100 * 24 * time.Hour), Cost: 1})
+	if got, _ := store.Query(ctx, UsageQuery{}); len(got) != 3 {
+		t.Errorf("Query() without retention returned %d records, want 3", len(got))
+	}
+}
+
+func TestCostTracker_UsageSeries(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	tracker := NewCostTracker()
+	tracker.SetStore(store)
+
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, rec := range []UsageRecord{
+		{Time: day.Add(10*time.Hour + 5*time.Minute), Usage: types.Usage{TotalTokens: 10}, Cost: 1},
+		{Time: day.Add(10*time.Hour + 55*time.Minute), Usage: types.Usage{TotalTokens: 20}, Cost: 2},
+		{Time: day.Add(13 * time.Hour), Usage: types.Usage{TotalTokens: 40}, Cost: 4},
+		{Time: day.Add(30 * time.Hour), Usage: types.Usage{TotalTokens: 80}, Cost: 8},
+	} {
+		rec.Provider, rec.Model = "openai", "gpt-4"
+		store.Add(ctx, rec)
+	}
+
+	tests := []struct {
+		name  string
+		query UsageQuery
+		width time.Duration
+		want  []UsageBucket
+	}{
+		{
+			name:  "hourly",
+			query: UsageQuery{End: day.Add(24 * time.Hour)},
+			width: Hourly,
+			want: []UsageBucket{
+				{Start: day.Add(10 * time.Hour), UsageStats: UsageStats{TotalTokens: 30, TotalCost: 3, RequestCount: 2, LastRequestTime: day.Add(10*time.Hour + 55*time.Minute)}},
+				{Start: day.Add(13 * time.Hour), UsageStats: UsageStats{TotalTokens: 40, TotalCost: 4, RequestCount: 1, LastRequestTime: day.Add(13 * time.Hour)}},
+			},
+		},
+		{
+			name:  "daily",
+			width: Daily,
+			want: []UsageBucket{
+				{Start: day, UsageStats: UsageStats{TotalTokens: 70, TotalCost: 7, RequestCount: 3, LastRequestTime: day.Add(13 * time.Hour)}},
+				{Start: day.Add(24 * time.Hour), UsageStats: UsageStats{TotalTokens: 80, TotalCost: 8, RequestCount: 1, LastRequestTime: day.Add(30 * time.Hour)}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tracker.UsageSeries(ctx, tt.query, tt.width)
+			if err != nil {
+				t.Fatalf("UsageSeries() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UsageSeries() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := tracker.GetUsageStats("openai", "gpt-4", day.Add(48*time.Hour), day.Add(72*time.Hour)); err == nil {
+		t.Error("GetUsageStats() for a range without usage error = nil")
+	}
+}
+
+func TestSQLStore_Query(t *testing.T) {
+	const q = `SELECT total_cost FROM usage WHERE provider = ? AND model = ?`
+
+	tests := []struct {
+		name  string
+		store *SQLStore
+		want  string
+	}{
+		{"sqlite", NewSQLiteStore(nil, "usage"), q},
+		{"postgres", NewPostgresStore(nil, "usage"), `SELECT total_cost FROM usage WHERE provider = $1 AND model = $2`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.store.query(q); got != tt.want {
+				t.Errorf("query() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// countingStore counts the usage added to it
+type countingStore struct {
+	*MemoryStore
+	adds    int
+	queries int
+}
+
+func (s *countingStore) Add(ctx context.Context, rec UsageRecord) error {
+	s.adds++
+	return s.MemoryStore.Add(ctx, rec)
+}
+
+func (s *countingStore) Query(ctx context.Context, q UsageQuery) ([]UsageRecord, error) {
+	s.queries++
+	return s.MemoryStore.Query(ctx, q)
+}
+
+func TestCostTracker_SetStore(t *testing.T) {
+	store := &countingStore{MemoryStore: NewMemoryStore()}
+	tracker := NewCostTracker()
+	tracker.SetStore(store)
+	tracker.SetBudget("openai", "gpt-4", 0.01)
+
+	usage := types.Usage{PromptTokens: 100, CompletionTokens: 50, TotalTokens: 150}
+	if err := tracker.TrackUsage("openai", "gpt-4", usage); err != nil {
+		t.Fatalf("TrackUsage() error = %v", err)
+	}
+	if err := tracker.TrackUsage("openai", "gpt-4", usage); err == nil {
+		t.Error("TrackUsage() over budget error = nil")
+	}
+	if store.adds != 1 {
+		t.Errorf("store received %d records, want 1", store.adds)
+	}
+
+	// The budget total is read from the store once, then kept running
+	for i := 0; i < 3; i++ {
+		tracker.TrackUsage("openai", "gpt-4", usage)
+	}
+	if store.queries != 1 {
+		t.Errorf("store queried %d times for budget checks, want 1", store.queries)
+	}
+
+	// A second tracker on the same store sees the same totals
+	other := NewCostTracker()
+	other.SetStore(store)
+	if cost, err := other.GetCost("openai", "gpt-4"); err != nil || cost != 0.006 {
+		t.Errorf("GetCost() = %v, %v, want 0.006", cost, err)
+	}
+}
@@ -0,0 +1,159 @@
+package ensemble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// ErrNoScorer is returned by BestOfN.Run when neither a scorer nor a judge
+// is configured
+var ErrNoScorer = errors.New("best-of-n has no scorer")
+
+const scorePrompt = "Rate how well the answer below responds to the conversation, from 0 (useless) to 10 (ideal). " +
+	"Reply with the number only."
+
+var scorePattern = regexp.MustCompile(`-?\d+(\.\d+)?`)
+
+// Scorer rates a candidate response to req. Higher scores are better.
+type Scorer func(ctx context.Context, req *types.ChatRequest, resp *types.ChatResponse) (float64, error)
+
+// BestOfN samples several responses to one request and returns the one that
+// scores best
+type BestOfN struct {
+	client  Chatter
+	n       int
+	score   Scorer
+	judge   Chatter
+	catalog *cost.PricingCatalog
+}
+
+// BestOfNOption configures a BestOfN
+type BestOfNOption func(*BestOfN)
+
+// WithScorer scores candidates with fn
+func WithScorer(fn Scorer) BestOfNOption {
+	return func(b *BestOfN) {
+		b.score = fn
+	}
+}
+
+// WithScoreJudge has judge rate each candidate from 0 to 10. Its usage
+// counts toward the result's cost. A scorer set with WithScorer takes
+// precedence.
+func WithScoreJudge(judge Chatter) BestOfNOption {
+	return func(b *BestOfN) {
+		b.judge = judge
+	}
+}
+
+// WithPricing sets the catalog the result's cost is priced with. Defaults to
+// cost.DefaultCatalog().
+func WithPricing(catalog *cost.PricingCatalog) BestOfNOption {
+	return func(b *BestOfN) {
+		b.catalog = catalog
+	}
+}
+
+// NewBestOfN samples n responses from client per request. Use a non-zero
+// temperature, or the samples may all be the same.
+func NewBestOfN(client Chatter, n int, opts ...BestOfNOption) *BestOfN {
+	b := &BestOfN{client: client, n: n, catalog: cost.DefaultCatalog()}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Run sends req n times at once, scores each response and returns the best,
+// the first on a tie. Every candidate keeps its score. Candidates that fail
+// or cannot be scored are left out; if none remain, the error wraps
+// ErrNoCandidates. The result's cost covers every sample and judge call.
+func (b *BestOfN) Run(ctx context.Context, req *types.ChatRequest) (*Result, error) {
+	if b.n < 1 {
+		return nil, ErrNoMembers
+	}
+	if b.score == nil && b.judge == nil {
+		return nil, ErrNoScorer
+	}
+
+	members := make([]Member, b.n)
+	for i := range members {
+		members[i] = Member{Name: fmt.Sprintf("sample %d", i+1), Client: b.client}
+	}
+	result := &Result{Candidates: ask(ctx, req, members)}
+	for _, cand := range result.Candidates {
+		result.account(b.catalog, cand.Response)
+	}
+
+	// Score concurrently, as judge calls may be slow
+	judged := make([]*types.ChatResponse, len(result.Candidates))
+	done := make(chan struct{})
+	for i := range result.Candidates {
+		go func(i int) {
+			defer func() { done <- struct{}{} }()
+			cand := &result.Candidates[i]
+			if cand.Err != nil {
+				return
+			}
+			s, judgement, err := b.rate(ctx, req, cand.Response)
+			judged[i] = judgement
+			if err != nil {
+				cand.Err = fmt.Errorf("score: %w", err)
+				return
+			}
+			cand.Score = s
+		}(i)
+	}
+	for range result.Candidates {
+		<-done
+	}
+	for _, resp := range judged {
+		result.account(b.catalog, resp)
+	}
+
+	if _, err := answered(result.Candidates); err != nil {
+		return result, err
+	}
+	var best *Candidate
+	for i := range result.Candidates {
+		cand := &result.Candidates[i]
+		if cand.Err == nil && (best == nil || cand.Score > best.Score) {
+			best = cand
+		}
+	}
+	result.Response = best.Response
+	return result, nil
+}
+
+// rate scores resp. With a judge, it returns the judge's response, whose
+// first number is the score.
+func (b *BestOfN) rate(ctx context.Context, req *types.ChatRequest, resp *types.ChatResponse) (float64, *types.ChatResponse, error) {
+	if b.score != nil {
+		score, err := b.score(ctx, req, resp)
+		return score, nil, err
+	}
+
+	judgement, err := b.judge.Chat(ctx, &types.ChatRequest{
+		Messages: types.Messages(
+			types.SystemMessage(scorePrompt),
+			types.UserMessage(fmt.Sprintf("Conversation:\n%s\n\nAnswer:\n%s", transcript(req.Messages), resp.Message.Content)),
+		),
+		MaxTokens: 10,
+	})
+	if err != nil {
+		return 0, nil, fmt.Errorf("judge: %w", err)
+	}
+	match := scorePattern.FindString(judgement.Message.Content)
+	if match == "" {
+		return 0, judgement, fmt.Errorf("judge gave no score: %q", strings.TrimSpace(judgement.Message.Content))
+	}
+	score, err := strconv.ParseFloat(match, 64)
+	return score, judgement, err
+}
@@ -0,0 +1,156 @@
+package ensemble
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// chatFunc adapts a function to a Chatter
+type chatFunc func(req *types.ChatRequest) (*types.ChatResponse, error)
+
+func (f chatFunc) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	return f(req)
+}
+
+func TestBestOfN(t *testing.T) {
+	errUnscorable := errors.New("unscorable")
+	byLength := func(ctx context.Context, req *types.ChatRequest, resp *types.ChatResponse) (float64, error) {
+		if resp.Message.Content == "?" {
+			return 0, errUnscorable
+		}
+		return float64(len(resp.Message.Content)), nil
+	}
+	// The judge scores an answer by the digit it ends with
+	var judgeCalls atomic.Int32
+	judge := chatFunc(func(req *types.ChatRequest) (*types.ChatResponse, error) {
+		judgeCalls.Add(1)
+		prompt := req.Messages[1].Content
+		return &types.ChatResponse{Response: types.Response{
+			Provider: "openai",
+			Model:    "gpt-4o-mini",
+			Message:  types.Message{Content: "Score: " + prompt[len(prompt)-1:]},
+			Usage:    types.Usage{PromptTokens: 100, CompletionTokens: 2, TotalTokens: 102},
+		}}, nil
+	})
+
+	tests := []struct {
+		name       string
+		replies    []string
+		err        error
+		opts       []BestOfNOption
+		wantReply  string
+		wantTokens int
+		wantErr    error
+	}{
+		{
+			name:       "scorer",
+			replies:    []string{"ok", "longest", "long"},
+			opts:       []BestOfNOption{WithScorer(byLength)},
+			wantReply:  "longest",
+			wantTokens: 45,
+		},
+		{
+			name:       "unscorable left out",
+			replies:    []string{"?", "a", "?"},
+			opts:       []BestOfNOption{WithScorer(byLength)},
+			wantReply:  "a",
+			wantTokens: 45,
+		},
+		{
+			name:       "judge",
+			replies:    []string{"answer 3", "answer 9", "answer 5"},
+			opts:       []BestOfNOption{WithScoreJudge(judge)},
+			wantReply:  "answer 9",
+			wantTokens: 45 + 3*102,
+		},
+		{
+			name:    "all fail",
+			err:     errors.New("provider down"),
+			opts:    []BestOfNOption{WithScorer(byLength)},
+			wantErr: ErrNoCandidates,
+		},
+		{
+			name:    "no scorer",
+			replies: []string{"a"},
+			wantErr: ErrNoScorer,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &replier{replies: tt.replies, err: tt.err}
+			result, err := NewBestOfN(client, 3, tt.opts...).Run(context.Background(), request("Answer"))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if result.Response.Message.Content != tt.wantReply {
+				t.Errorf("Run() = %q, want %q", result.Response.Message.Content, tt.wantReply)
+			}
+			if len(result.Candidates) != 3 {
+				t.Errorf("Run() returned %d candidates, want 3", len(result.Candidates))
+			}
+			if result.Usage.TotalTokens != tt.wantTokens {
+				t.Errorf("Usage.TotalTokens = %d, want %d", result.Usage.TotalTokens, tt.wantTokens)
+			}
+			if result.Cost <= 0 {
+				t.Errorf("Cost = %v, want the samples priced", result.Cost)
+			}
+		})
+	}
+	if judgeCalls.Load() != 3 {
+		t.Errorf("judge called %d times, want 3", judgeCalls.Load())
+	}
+}
+
+func TestBestOfN_Pricing(t *testing.T) {
+	catalog := cost.NewPricingCatalog()
+	if err := catalog.Set("openai", "gpt-4o", cost.TokenRates{PromptTokenRate: 1, CompletionTokenRate: 2}); err != nil {
+		t.Fatal(err)
+	}
+	first := func(ctx context.Context, req *types.ChatRequest, resp *types.ChatResponse) (float64, error) {
+		return 1, nil
+	}
+
+	result, err := NewBestOfN(&replier{reply: "a"}, 2, WithScorer(first), WithPricing(catalog)).Run(context.Background(), request("hi"))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	// Each sample uses 10 prompt and 5 completion tokens, at $1 and $2 per 1K
+	want := 2 * (0.010 + 0.010)
+	if diff := result.Cost - want; diff > 1e-9 || diff < -1e-9 {
+		t.Errorf("Cost = %v, want %v", result.Cost, want)
+	}
+	if !strings.HasPrefix(result.Candidates[1].Member, "sample") {
+		t.Errorf("Candidates[1].Member = %q, want a sample name", result.Candidates[1].Member)
+	}
+}
+
+func TestBestOfN_IdempotencyKey(t *testing.T) {
+	first := func(ctx context.Context, req *types.ChatRequest, resp *types.ChatResponse) (float64, error) {
+		return 1, nil
+	}
+	client := &replier{reply: "a"}
+	req := request("hi")
+	req.IdempotencyKey = "order-42"
+
+	if _, err := NewBestOfN(client, 3, WithScorer(first)).Run(context.Background(), req); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	for i, sent := range client.requests {
+		if sent == req || sent.IdempotencyKey != "" {
+			t.Errorf("sample %d was sent %p with key %q, want a copy without a key", i, sent, sent.IdempotencyKey)
+		}
+	}
+	if req.IdempotencyKey != "order-42" {
+		t.Errorf("caller's key = %q, want it unchanged", req.IdempotencyKey)
+	}
+}
@@ -0,0 +1,157 @@
+package ensemble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// ErrNoConsensus is returned when no answer has more votes than every other
+var ErrNoConsensus = errors.New("no answer has a majority")
+
+const judgePrompt = "You reconcile the answers several assistants gave to the same conversation. " +
+	"Reply with the single best final answer, correcting any mistakes, and nothing else."
+
+// Consensus sends the same request to several members and settles on one
+// answer, by majority vote or by asking a judge model to reconcile them
+type Consensus struct {
+	members []Member
+	key     func(content string) (string, error)
+	judge   Chatter
+	catalog *cost.PricingCatalog
+}
+
+// ConsensusOption configures a Consensus
+type ConsensusOption func(*Consensus)
+
+// WithVoteKey sets how an answer is reduced to the value that is voted on,
+// for example by parsing a structured answer and returning one field.
+// Answers whose key fails are left out of the vote. By default answers are
+// compared ignoring case and surrounding whitespace.
+func WithVoteKey(fn func(content string) (string, error)) ConsensusOption {
+	return func(c *Consensus) {
+		c.key = fn
+	}
+}
+
+// WithJudge has judge reconcile the answers into one instead of voting
+func WithJudge(judge Chatter) ConsensusOption {
+	return func(c *Consensus) {
+		c.judge = judge
+	}
+}
+
+// WithConsensusPricing sets the catalog the result's cost is priced with.
+// Defaults to cost.DefaultCatalog().
+func WithConsensusPricing(catalog *cost.PricingCatalog) ConsensusOption {
+	return func(c *Consensus) {
+		c.catalog = catalog
+	}
+}
+
+// NewConsensus creates a consensus of members
+func NewConsensus(members []Member, opts ...ConsensusOption) *Consensus {
+	c := &Consensus{
+		members: members,
+		key: func(content string) (string, error) {
+			return strings.ToLower(strings.TrimSpace(content)), nil
+		},
+		catalog: cost.DefaultCatalog(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Run sends req to every member at once and returns the answer they settle
+// on. By vote, the answer with more votes than any other wins, and the
+// result holds the first member's response giving it. A tie returns
+// ErrNoConsensus along with the candidates. Members that fail are left out;
+// if all fail, the error wraps ErrNoCandidates.
+func (c *Consensus) Run(ctx context.Context, req *types.ChatRequest) (*Result, error) {
+	if len(c.members) == 0 {
+		return nil, ErrNoMembers
+	}
+
+	result := &Result{Candidates: ask(ctx, req, c.members)}
+	for _, cand := range result.Candidates {
+		result.account(c.catalog, cand.Response)
+	}
+	if c.judge != nil {
+		return c.reconcile(ctx, req, result)
+	}
+	return c.vote(result)
+}
+
+func (c *Consensus) vote(result *Result) (*Result, error) {
+	votes := make(map[string]int)
+	first := make(map[string]*types.ChatResponse)
+	var order []string
+	for i := range result.Candidates {
+		cand := &result.Candidates[i]
+		if cand.Err != nil {
+			continue
+		}
+		key, err := c.key(cand.Response.Message.Content)
+		if err != nil {
+			cand.Err = fmt.Errorf("vote key: %w", err)
+			continue
+		}
+		if votes[key] == 0 {
+			first[key] = cand.Response
+			order = append(order, key)
+		}
+		votes[key]++
+	}
+	if _, err := answered(result.Candidates); err != nil {
+		return result, err
+	}
+
+	var winner string
+	best, tied := 0, false
+	for _, key := range order {
+		switch {
+		case votes[key] > best:
+			winner, best, tied = key, votes[key], false
+		case votes[key] == best:
+			tied = true
+		}
+	}
+	if tied {
+		return result, ErrNoConsensus
+	}
+	result.Response = first[winner]
+	result.Votes = best
+	return result, nil
+}
+
+func (c *Consensus) reconcile(ctx context.Context, req *types.ChatRequest, result *Result) (*Result, error) {
+	candidates, err := answered(result.Candidates)
+	if err != nil {
+		return result, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Conversation:\n%s", transcript(req.Messages))
+	for i, cand := range candidates {
+		fmt.Fprintf(&b, "\n\nAnswer %d:\n%s", i+1, cand.Response.Message.Content)
+	}
+	resp, err := c.judge.Chat(ctx, &types.ChatRequest{
+		Messages: types.Messages(
+			types.SystemMessage(judgePrompt),
+			types.UserMessage(b.String()),
+		),
+		MaxTokens: req.MaxTokens,
+	})
+	if err != nil {
+		return result, fmt.Errorf("judge: %w", err)
+	}
+	result.account(c.catalog, resp)
+	result.Response = resp
+	return result, nil
+}
@@ -0,0 +1,173 @@
+package ensemble
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// replier answers requests with its replies in turn, then repeats the last,
+// or fails. It records the requests it receives.
+type replier struct {
+	mu       sync.Mutex
+	reply    string
+	replies  []string
+	err      error
+	requests []*types.ChatRequest
+}
+
+func (r *replier) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.replies) > 0 {
+		r.reply, r.replies = r.replies[0], r.replies[1:]
+	}
+	return &types.ChatResponse{Response: types.Response{
+		Provider: "openai",
+		Model:    "gpt-4o",
+		Message:  types.Message{Role: types.RoleAssistant, Content: r.reply},
+		Usage:    types.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+	}}, nil
+}
+
+func members(replies ...*replier) []Member {
+	out := make([]Member, len(replies))
+	for i, r := range replies {
+		out[i] = Member{Name: string(rune('a' + i)), Client: r}
+	}
+	return out
+}
+
+func request(content string) *types.ChatRequest {
+	return &types.ChatRequest{Messages: []types.Message{{Role: types.RoleUser, Content: content}}}
+}
+
+func TestConsensus_Vote(t *testing.T) {
+	errDown := errors.New("provider down")
+	answerKey := func(content string) (string, error) {
+		var v struct{ Answer string }
+		err := json.Unmarshal([]byte(content), &v)
+		return v.Answer, err
+	}
+
+	tests := []struct {
+		name      string
+		replies   []*replier
+		opts      []ConsensusOption
+		wantReply string
+		wantVotes int
+		wantErr   error
+	}{
+		{
+			name:      "majority",
+			replies:   []*replier{{reply: "Paris"}, {reply: "Lyon"}, {reply: " paris "}},
+			wantReply: "Paris",
+			wantVotes: 2,
+		},
+		{
+			name:    "tie",
+			replies: []*replier{{reply: "Paris"}, {reply: "Lyon"}},
+			wantErr: ErrNoConsensus,
+		},
+		{
+			name:      "failed member left out",
+			replies:   []*replier{{err: errDown}, {reply: "Lyon"}, {reply: "Paris"}, {reply: "Paris"}},
+			wantReply: "Paris",
+			wantVotes: 2,
+		},
+		{
+			name:    "all fail",
+			replies: []*replier{{err: errDown}, {err: errDown}},
+			wantErr: ErrNoCandidates,
+		},
+		{
+			name: "structured answers",
+			replies: []*replier{
+				{reply: `{"answer": "B", "reason": "x"}`},
+				{reply: `{"answer": "B", "reason": "y"}`},
+				{reply: `{"answer": "A"}`},
+				{reply: `not json`},
+			},
+			opts:      []ConsensusOption{WithVoteKey(answerKey)},
+			wantReply: `{"answer": "B", "reason": "x"}`,
+			wantVotes: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewConsensus(members(tt.replies...), tt.opts...).Run(context.Background(), request("Capital of France?"))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(result.Candidates) != len(tt.replies) {
+				t.Errorf("Run() returned %d candidates, want %d", len(result.Candidates), len(tt.replies))
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if result.Response.Message.Content != tt.wantReply || result.Votes != tt.wantVotes {
+				t.Errorf("Run() = %q with %d votes, want %q with %d", result.Response.Message.Content, result.Votes, tt.wantReply, tt.wantVotes)
+			}
+		})
+	}
+
+	if _, err := NewConsensus(nil).Run(context.Background(), request("hi")); !errors.Is(err, ErrNoMembers) {
+		t.Errorf("Run() without members error = %v, want ErrNoMembers", err)
+	}
+}
+
+func TestConsensus_Judge(t *testing.T) {
+	judge := &replier{reply: "Paris"}
+	c := NewConsensus(members(&replier{reply: "Paris"}, &replier{reply: "Lyon"}, &replier{err: errors.New("down")}), WithJudge(judge))
+
+	result, err := c.Run(context.Background(), request("Capital of France?"))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Response.Message.Content != "Paris" || result.Votes != 0 {
+		t.Errorf("Run() = %q with %d votes, want the judge's answer", result.Response.Message.Content, result.Votes)
+	}
+	if len(judge.requests) != 1 {
+		t.Fatalf("judge called %d times, want 1", len(judge.requests))
+	}
+	prompt := judge.requests[0].Messages[1].Content
+	for _, want := range []string{"Capital of France?", "Answer 1:\nParis", "Answer 2:\nLyon"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("judge prompt %q does not contain %q", prompt, want)
+		}
+	}
+	if strings.Contains(prompt, "Answer 3") {
+		t.Errorf("judge prompt %q includes the failed member", prompt)
+	}
+}
+
+func TestConsensus_Pricing(t *testing.T) {
+	catalog := cost.NewPricingCatalog()
+	if err := catalog.Set("openai", "gpt-4o", cost.TokenRates{PromptTokenRate: 1, CompletionTokenRate: 2}); err != nil {
+		t.Fatal(err)
+	}
+	judge := &replier{reply: "Paris"}
+	c := NewConsensus(members(&replier{reply: "Paris"}, &replier{reply: "Lyon"}), WithJudge(judge), WithConsensusPricing(catalog))
+
+	result, err := c.Run(context.Background(), request("Capital of France?"))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	// Two members and the judge, each using 10 prompt and 5 completion
+	// tokens, at $1 and $2 per 1K
+	want := 3 * (0.010 + 0.010)
+	if diff := result.Cost - want; diff > 1e-9 || diff < -1e-9 {
+		t.Errorf("Cost = %v, want %v", result.Cost, want)
+	}
+}
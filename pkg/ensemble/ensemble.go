@@ -0,0 +1,131 @@
+// Package ensemble gets better answers by using several model calls per
+// request: voting across models, best-of-N sampling, and critique-and-revise
+// loops.
+//
+//	c := ensemble.NewConsensus([]ensemble.Member{
+//		{Name: "gpt-4o", Client: gpt4o},
+//		{Name: "claude", Client: claude},
+//		{Name: "gpt-4o-mini", Client: mini},
+//	})
+//	result, err := c.Run(ctx, req)
+//	fmt.Println(result.Response.Message.Content, result.Votes)
+package ensemble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/types"
+)
+
+var (
+	ErrNoMembers    = errors.New("ensemble has no members")
+	ErrNoCandidates = errors.New("no member answered")
+)
+
+// Chatter sends a chat request. *client.Client satisfies this interface.
+type Chatter interface {
+	Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error)
+}
+
+// Member is a model taking part in an ensemble
+type Member struct {
+	// Name identifies the member in candidates, for example its model
+	Name   string
+	Client Chatter
+}
+
+// Candidate is one member's answer
+type Candidate struct {
+	Member   string
+	Response *types.ChatResponse
+	Err      error
+	// Score is the candidate's score under best-of-N sampling
+	Score float64
+}
+
+// Result is the response an ensemble settled on, with every candidate it
+// chose from
+type Result struct {
+	Response   *types.ChatResponse
+	Candidates []Candidate
+	// Votes is the number of candidates that agree with Response, or zero
+	// when a judge wrote it
+	Votes int
+	// Usage and Cost add up every response the ensemble received, including
+	// those of judges. Cost is in USD, zero for models without prices.
+	Usage types.Usage
+	Cost  float64
+}
+
+func (r *Result) account(catalog *cost.PricingCatalog, resp *types.ChatResponse) {
+	addUsage(&r.Usage, &r.Cost, catalog, resp)
+}
+
+// addUsage adds a response's usage and cost to running totals
+func addUsage(usage *types.Usage, total *float64, catalog *cost.PricingCatalog, resp *types.ChatResponse) {
+	if resp == nil {
+		return
+	}
+	usage.PromptTokens += resp.Usage.PromptTokens
+	usage.CompletionTokens += resp.Usage.CompletionTokens
+	usage.TotalTokens += resp.Usage.TotalTokens
+	*total += catalog.Cost(resp.Provider, resp.Model, resp.Usage)
+}
+
+// ask sends req to every member at once and returns their answers in the
+// order of members
+func ask(ctx context.Context, req *types.ChatRequest, members []Member) []Candidate {
+	candidates := make([]Candidate, len(members))
+	done := make(chan struct{})
+	for i, m := range members {
+		go func(i int, m Member) {
+			defer func() { done <- struct{}{} }()
+			resp, err := m.Client.Chat(ctx, fresh(req))
+			candidates[i] = Candidate{Member: m.Name, Response: resp, Err: err}
+		}(i, m)
+	}
+	for range members {
+		<-done
+	}
+	return candidates
+}
+
+// fresh returns a copy of req to send as a request of its own. Its
+// idempotency key is cleared, so members sharing a client are not all
+// answered with the first one's response.
+func fresh(req *types.ChatRequest) *types.ChatRequest {
+	r := req.Clone()
+	r.IdempotencyKey = ""
+	return r
+}
+
+// answered returns the candidates that have a response, or an error
+// wrapping ErrNoCandidates and every member's error if none do
+func answered(candidates []Candidate) ([]Candidate, error) {
+	var ok []Candidate
+	var errs []error
+	for _, c := range candidates {
+		if c.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.Member, c.Err))
+			continue
+		}
+		ok = append(ok, c)
+	}
+	if len(ok) == 0 {
+		return nil, fmt.Errorf("%w: %w", ErrNoCandidates, errors.Join(errs...))
+	}
+	return ok, nil
+}
+
+// transcript renders a conversation as text for a judge or critic
+func transcript(messages []types.Message) string {
+	var b strings.Builder
+	for _, m := range messages {
+		fmt.Fprintf(&b, "%s: %s\n", m.Role, m.Content)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
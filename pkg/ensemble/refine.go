@@ -0,0 +1,167 @@
+package ensemble
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/types"
+)
+
+const (
+	defaultIterations = 2
+
+	// DefaultCriticPrompt asks the critic for actionable feedback, or for
+	// "APPROVED" when the answer needs no changes
+	DefaultCriticPrompt = "You review an assistant's answer to the conversation below. " +
+		"List its mistakes, omissions and unclear parts as concrete, actionable feedback. " +
+		"If it needs no changes, reply with APPROVED and nothing else."
+
+	revisePrompt = "Revise your answer to address the feedback below. Reply with the revised answer only.\n\nFeedback:\n"
+)
+
+// Round is one critique of the current answer and the revision it led to
+type Round struct {
+	Critique *types.ChatResponse
+	// Revision is nil when the critic approved the answer
+	Revision *types.ChatResponse
+}
+
+// Refinement is the outcome of a critique-and-revise loop
+type Refinement struct {
+	// Response is the final answer: the last revision, or the first draft
+	// if there was none
+	Response *types.ChatResponse
+	Draft    *types.ChatResponse
+	Rounds   []Round
+	// Usage and Cost add up the writer's and the critic's responses. Cost is
+	// in USD, zero for models without prices.
+	Usage types.Usage
+	Cost  float64
+}
+
+// Refiner drafts an answer, has a critic review it, and revises the answer
+// to address the critique, for a number of rounds or until the critic
+// approves
+type Refiner struct {
+	writer       Chatter
+	critic       Chatter
+	iterations   int
+	criticPrompt string
+	approved     func(critique string) bool
+	catalog      *cost.PricingCatalog
+}
+
+// RefinerOption configures a Refiner
+type RefinerOption func(*Refiner)
+
+// WithCritic sets the model that reviews the answers. Defaults to the
+// writer.
+func WithCritic(critic Chatter) RefinerOption {
+	return func(r *Refiner) {
+		r.critic = critic
+	}
+}
+
+// WithIterations sets the most critique-and-revise rounds. Defaults to 2.
+func WithIterations(n int) RefinerOption {
+	return func(r *Refiner) {
+		r.iterations = n
+	}
+}
+
+// WithCriticPrompt sets the critic's system prompt, for example to review
+// against a style guide. Defaults to DefaultCriticPrompt. Pair a prompt
+// that changes how the critic approves with WithApproval.
+func WithCriticPrompt(prompt string) RefinerOption {
+	return func(r *Refiner) {
+		r.criticPrompt = prompt
+	}
+}
+
+// WithApproval decides from a critique whether the answer needs no more
+// revisions. By default a critique starting with "APPROVED" does.
+func WithApproval(fn func(critique string) bool) RefinerOption {
+	return func(r *Refiner) {
+		r.approved = fn
+	}
+}
+
+// WithRefinerPricing sets the catalog the refinement's cost is priced
+// with. Defaults to cost.DefaultCatalog().
+func WithRefinerPricing(catalog *cost.PricingCatalog) RefinerOption {
+	return func(r *Refiner) {
+		r.catalog = catalog
+	}
+}
+
+// NewRefiner creates a refiner whose answers come from writer
+func NewRefiner(writer Chatter, opts ...RefinerOption) *Refiner {
+	r := &Refiner{
+		writer:       writer,
+		critic:       writer,
+		iterations:   defaultIterations,
+		criticPrompt: DefaultCriticPrompt,
+		approved: func(critique string) bool {
+			return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(critique)), "APPROVED")
+		},
+		catalog: cost.DefaultCatalog(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run answers req, then critiques and revises the answer. If a request
+// fails, the refinement so far is returned with the error, and its Response
+// is the latest answer.
+func (r *Refiner) Run(ctx context.Context, req *types.ChatRequest) (*Refinement, error) {
+	out := &Refinement{}
+	draft, err := r.writer.Chat(ctx, fresh(req))
+	if err != nil {
+		return out, fmt.Errorf("draft: %w", err)
+	}
+	out.account(r.catalog, draft)
+	out.Draft, out.Response = draft, draft
+
+	for i := 1; i <= r.iterations; i++ {
+		critique, err := r.critic.Chat(ctx, &types.ChatRequest{
+			Messages: types.Messages(
+				types.SystemMessage(r.criticPrompt),
+				types.UserMessage(fmt.Sprintf("Conversation:\n%s\n\nAnswer:\n%s", transcript(req.Messages), out.Response.Message.Content)),
+			),
+			MaxTokens: req.MaxTokens,
+		})
+		if err != nil {
+			return out, fmt.Errorf("critique %d: %w", i, err)
+		}
+		out.account(r.catalog, critique)
+		round := Round{Critique: critique}
+		if r.approved(critique.Message.Content) {
+			out.Rounds = append(out.Rounds, round)
+			return out, nil
+		}
+
+		revise := fresh(req)
+		revise.Messages = append(revise.Messages,
+			types.AssistantMessage(out.Response.Message.Content),
+			types.UserMessage(revisePrompt+critique.Message.Content),
+		)
+		revision, err := r.writer.Chat(ctx, revise)
+		if err != nil {
+			out.Rounds = append(out.Rounds, round)
+			return out, fmt.Errorf("revision %d: %w", i, err)
+		}
+		out.account(r.catalog, revision)
+		round.Revision = revision
+		out.Rounds = append(out.Rounds, round)
+		out.Response = revision
+	}
+	return out, nil
+}
+
+func (f *Refinement) account(catalog *cost.PricingCatalog, resp *types.ChatResponse) {
+	addUsage(&f.Usage, &f.Cost, catalog, resp)
+}
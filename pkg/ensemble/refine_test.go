@@ -0,0 +1,150 @@
+package ensemble
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestRefiner(t *testing.T) {
+	errDown := errors.New("provider down")
+
+	tests := []struct {
+		name         string
+		writer       []string
+		critic       []string
+		criticErr    error
+		opts         []RefinerOption
+		wantReply    string
+		wantRounds   int
+		wantRevision int
+		wantErr      error
+	}{
+		{
+			name:         "approved after one revision",
+			writer:       []string{"draft", "better"},
+			critic:       []string{"Too vague.", "APPROVED"},
+			wantReply:    "better",
+			wantRounds:   2,
+			wantRevision: 1,
+		},
+		{
+			name:         "iterations exhausted",
+			writer:       []string{"draft", "v2", "v3", "v4"},
+			critic:       []string{"Fix it."},
+			opts:         []RefinerOption{WithIterations(3)},
+			wantReply:    "v4",
+			wantRounds:   3,
+			wantRevision: 3,
+		},
+		{
+			name:       "draft approved",
+			writer:     []string{"draft"},
+			critic:     []string{" approved."},
+			wantReply:  "draft",
+			wantRounds: 1,
+		},
+		{
+			name:   "custom approval",
+			writer: []string{"draft", "v2"},
+			critic: []string{"Score: 4/10", "Score: 9/10"},
+			opts: []RefinerOption{WithApproval(func(critique string) bool {
+				return strings.Contains(critique, "9/10")
+			})},
+			wantReply:    "v2",
+			wantRounds:   2,
+			wantRevision: 1,
+		},
+		{
+			name:      "critic fails",
+			writer:    []string{"draft"},
+			criticErr: errDown,
+			wantReply: "draft",
+			wantErr:   errDown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer := &replier{replies: tt.writer}
+			critic := &replier{replies: tt.critic, err: tt.criticErr}
+			opts := append([]RefinerOption{WithCritic(critic)}, tt.opts...)
+
+			req := request("Explain DNS")
+			req.IdempotencyKey = "order-42"
+			out, err := NewRefiner(writer, opts...).Run(context.Background(), req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+			if out.Response.Message.Content != tt.wantReply {
+				t.Errorf("Response = %q, want %q", out.Response.Message.Content, tt.wantReply)
+			}
+			if out.Draft.Message.Content != "draft" {
+				t.Errorf("Draft = %q, want %q", out.Draft.Message.Content, "draft")
+			}
+			if len(out.Rounds) != tt.wantRounds {
+				t.Errorf("got %d rounds, want %d", len(out.Rounds), tt.wantRounds)
+			}
+			if calls := len(writer.requests); calls != 1+tt.wantRevision {
+				t.Errorf("writer called %d times, want %d", calls, 1+tt.wantRevision)
+			}
+			for i, sent := range writer.requests {
+				if sent.IdempotencyKey != "" {
+					t.Errorf("writer request %d has idempotency key %q, want none", i, sent.IdempotencyKey)
+				}
+			}
+			if want := 15 * (len(writer.requests) + len(out.Rounds)); out.Usage.TotalTokens != want {
+				t.Errorf("Usage.TotalTokens = %d, want %d", out.Usage.TotalTokens, want)
+			}
+		})
+	}
+}
+
+func TestRefiner_Prompts(t *testing.T) {
+	writer := &replier{replies: []string{"draft", "revised"}}
+	critic := &replier{replies: []string{"Mention caching.", "APPROVED"}}
+
+	if _, err := NewRefiner(writer, WithCritic(critic), WithCriticPrompt("Review for accuracy.")).Run(context.Background(), request("Explain DNS")); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	review := critic.requests[0].Messages
+	if review[0].Role != types.RoleSystem || review[0].Content != "Review for accuracy." {
+		t.Errorf("critic system prompt = %+v, want the configured prompt", review[0])
+	}
+	if !strings.Contains(review[1].Content, "Explain DNS") || !strings.HasSuffix(review[1].Content, "Answer:\ndraft") {
+		t.Errorf("critic prompt = %q, want the conversation and the draft", review[1].Content)
+	}
+
+	revise := writer.requests[1].Messages
+	if len(revise) != 3 || revise[1].Content != "draft" || !strings.Contains(revise[2].Content, "Mention caching.") {
+		t.Errorf("revision messages = %+v, want the draft and the critique appended", revise)
+	}
+	if len(writer.requests[0].Messages) != 1 {
+		t.Errorf("first request has %d messages, want the original request unchanged", len(writer.requests[0].Messages))
+	}
+}
+
+func TestRefiner_Pricing(t *testing.T) {
+	catalog := cost.NewPricingCatalog()
+	if err := catalog.Set("openai", "gpt-4o", cost.TokenRates{PromptTokenRate: 1, CompletionTokenRate: 2}); err != nil {
+		t.Fatal(err)
+	}
+	writer := &replier{reply: "draft"}
+	r := NewRefiner(writer, WithCritic(&replier{reply: "APPROVED"}), WithRefinerPricing(catalog))
+
+	out, err := r.Run(context.Background(), request("Write a haiku"))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	// The draft and one critique, each using 10 prompt and 5 completion
+	// tokens, at $1 and $2 per 1K
+	want := 2 * (0.010 + 0.010)
+	if diff := out.Cost - want; diff > 1e-9 || diff < -1e-9 {
+		t.Errorf("Cost = %v, want %v", out.Cost, want)
+	}
+}
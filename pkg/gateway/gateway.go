@@ -0,0 +1,438 @@
+// Package gateway serves an HTTP API in front of one or more clients,
+// authenticating callers with virtual API keys and enforcing per-key rate
+// and budget limits.
+//
+//	gw := gateway.New(tracker)
+//	gw.AddRoute("gpt-4o", gateway.Route{Provider: "openai", Model: "gpt-4o", Upstream: openaiClient})
+//	gw.AddKey("sk-team-a", gateway.Key{Name: "team-a", RequestsPerMinute: 60, MaxCostPerDay: 20})
+//	http.ListenAndServe(":8080", gw)
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ksred/llm/internal/ratelimit"
+	"github.com/ksred/llm/internal/tokenizer"
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// Upstream sends chat requests to a provider. *client.Client satisfies this
+// interface.
+type Upstream interface {
+	Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error)
+	StreamChat(ctx context.Context, req *types.ChatRequest) (<-chan *types.ChatResponse, error)
+}
+
+// Route is a model callers may request and the upstream serving it
+type Route struct {
+	// Provider and Model price the route's requests
+	Provider string
+	Model    string
+	Upstream Upstream
+}
+
+// Key holds the limits of a virtual API key. A zero limit disables that
+// check.
+type Key struct {
+	// Name identifies the key in usage records, as the tenant label
+	Name string
+
+	RequestsPerMinute int
+	TokensPerMinute   int
+	// SlidingWindow counts the rate limits over any sixty seconds instead
+	// of with a token bucket, so a key never exceeds them in bursts
+	SlidingWindow bool
+
+	// MaxCostPerRequest and MaxCostPerDay are in US dollars
+	MaxCostPerRequest float64
+	MaxCostPerDay     float64
+
+	// Models lists the routes the key may use; empty allows all
+	Models []string
+}
+
+// allows reports whether the key may use the route
+func (k Key) allows(route string) bool {
+	if len(k.Models) == 0 {
+		return true
+	}
+	for _, m := range k.Models {
+		if m == route {
+			return true
+		}
+	}
+	return false
+}
+
+// keyState is a registered key and its limiters
+type keyState struct {
+	Key
+	// hash is the key's lookup form, which also namespaces its
+	// idempotency keys
+	hash    string
+	limiter *ratelimit.Limiter
+	budget  *cost.BudgetGuard
+}
+
+// Gateway is an http.Handler serving chat requests from virtual keys. It
+// answers POST /v1/chat/completions and GET /v1/usage.
+type Gateway struct {
+	mu     sync.RWMutex
+	routes map[string]Route
+	keys   map[string]*keyState
+
+	tracker *cost.CostTracker
+	pricing *cost.PricingCatalog
+	mux     *http.ServeMux
+}
+
+// New creates a gateway with no routes or keys. Usage is recorded to
+// tracker, tagged with the key name, if it is not nil.
+func New(tracker *cost.CostTracker) *Gateway {
+	g := &Gateway{
+		routes:  make(map[string]Route),
+		keys:    make(map[string]*keyState),
+		tracker: tracker,
+		pricing: cost.DefaultCatalog(),
+		mux:     http.NewServeMux(),
+	}
+	g.mux.HandleFunc("/v1/chat/completions", g.handleChat)
+	g.mux.HandleFunc("/v1/usage", g.handleUsage)
+	return g
+}
+
+// SetPricing replaces the catalog used to estimate and price requests
+func (g *Gateway) SetPricing(catalog *cost.PricingCatalog) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pricing = catalog
+}
+
+// AddRoute serves requests for model from route, replacing any route of the
+// same name
+func (g *Gateway) AddRoute(model string, route Route) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.routes[model] = route
+}
+
+// AddKey registers a virtual API key. Keys are held hashed. Adding a key
+// again replaces its limits and resets its usage.
+func (g *Gateway) AddKey(apiKey string, key Key) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	algorithm := ratelimit.AlgorithmTokenBucket
+	if key.SlidingWindow {
+		algorithm = ratelimit.AlgorithmSlidingWindow
+	}
+	hash := hashKey(apiKey)
+	g.keys[hash] = &keyState{
+		Key:     key,
+		hash:    hash,
+		limiter: ratelimit.NewWithAlgorithm(algorithm, key.RequestsPerMinute, key.TokensPerMinute),
+		budget:  cost.NewBudgetGuard(key.MaxCostPerRequest, key.MaxCostPerDay),
+	}
+}
+
+// RemoveKey revokes a virtual API key
+func (g *Gateway) RemoveKey(apiKey string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.keys, hashKey(apiKey))
+}
+
+// hashKey returns the lookup form of an API key, so keys are not kept in
+// memory in the clear
+func hashKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// ServeHTTP implements http.Handler
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+// authenticate returns the key of the request's bearer token
+func (g *Gateway) authenticate(r *http.Request) (*keyState, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, types.ErrInvalidCredentials
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	key, ok := g.keys[hashKey(token)]
+	if !ok {
+		return nil, types.ErrInvalidCredentials
+	}
+	return key, nil
+}
+
+// MaxBodyBytes caps the size of a chat request body
+const MaxBodyBytes = 4 << 20
+
+// chatRequest is the body of a chat request: the route, whether to stream,
+// and the request fields callers may set. Fields that would let a caller
+// step around its key's limits, such as provider parameters overriding the
+// model or token limit, are not accepted.
+type chatRequest struct {
+	Model  string `json:"model"`
+	Stream bool   `json:"stream,omitempty"`
+
+	System           []string        `json:"system,omitempty"`
+	Messages         []types.Message `json:"messages"`
+	MaxTokens        int             `json:"max_tokens,omitempty"`
+	Temperature      *float32        `json:"temperature,omitempty"`
+	TopP             *float32        `json:"top_p,omitempty"`
+	Stop             []string        `json:"stop,omitempty"`
+	PresencePenalty  float32         `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float32         `json:"frequency_penalty,omitempty"`
+	User             string          `json:"user,omitempty"`
+	Tools            []types.Tool    `json:"tools,omitempty"`
+	IdempotencyKey   string          `json:"idempotency_key,omitempty"`
+}
+
+// request builds the upstream request. A caller's idempotency key is
+// namespaced by its virtual key and route, so one key can never be served
+// another's response.
+func (b *chatRequest) request(key *keyState) *types.ChatRequest {
+	req := &types.ChatRequest{
+		System:           b.System,
+		Messages:         b.Messages,
+		MaxTokens:        b.MaxTokens,
+		Temperature:      b.Temperature,
+		TopP:             b.TopP,
+		Stop:             b.Stop,
+		PresencePenalty:  b.PresencePenalty,
+		FrequencyPenalty: b.FrequencyPenalty,
+		User:             b.User,
+		Tools:            b.Tools,
+	}
+	if b.IdempotencyKey != "" {
+		req.IdempotencyKey = hashKey(key.hash + "\x00" + b.Model + "\x00" + b.IdempotencyKey)
+	}
+	return req
+}
+
+func (g *Gateway) handleChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request", "method not allowed")
+		return
+	}
+
+	key, err := g.authenticate(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "invalid_api_key", "invalid API key")
+		return
+	}
+
+	var body chatRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodyBytes)).Decode(&body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "invalid_request", "request body too large")
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	req := body.request(key)
+	if err := req.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	g.mu.RLock()
+	route, ok := g.routes[body.Model]
+	pricing := g.pricing
+	g.mu.RUnlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "model_not_found", fmt.Sprintf("unknown model %q", body.Model))
+		return
+	}
+	if !key.allows(body.Model) {
+		writeError(w, http.StatusForbidden, "model_not_allowed", fmt.Sprintf("model %q is not allowed for this key", body.Model))
+		return
+	}
+
+	// Reserve the budget first so a rejected request takes no rate limit.
+	// The reservation counts toward the key's daily spend until settled, so
+	// concurrent requests cannot overspend it.
+	promptTokens := tokenizer.CountChat(req)
+	estimate := pricing.Estimate(route.Provider, route.Model, promptTokens, req.MaxTokens)
+	budget, err := key.budget.Reserve(estimate)
+	if err != nil {
+		writeError(w, http.StatusPaymentRequired, "budget_exceeded", err.Error())
+		return
+	}
+	defer budget.Release()
+
+	reserved := tokenizer.EstimateChat(req)
+	if err := key.limiter.Allow(reserved); err != nil {
+		retry := int(math.Ceil(key.limiter.Delay().Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		writeError(w, http.StatusTooManyRequests, "rate_limit_exceeded", "rate limit exceeded for this key")
+		return
+	}
+
+	req.Labels = types.MergeLabels(req.Labels, map[string]string{types.LabelTenant: key.Name})
+	if body.Stream {
+		g.stream(w, r, key, route, req, reserved, budget)
+		return
+	}
+
+	resp, err := route.Upstream.Chat(r.Context(), req)
+	if err != nil {
+		key.limiter.Settle(reserved, 0)
+		writeUpstreamError(w, err)
+		return
+	}
+	g.settle(key, route, req, reserved, budget, resp.Usage)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(public(resp))
+}
+
+// stream relays a streamed response as server-sent events, ending with a
+// "[DONE]" event
+func (g *Gateway) stream(w http.ResponseWriter, r *http.Request, key *keyState, route Route, req *types.ChatRequest, reserved int, budget *cost.Reservation) {
+	chunks, err := route.Upstream.StreamChat(r.Context(), req)
+	if err != nil {
+		key.limiter.Settle(reserved, 0)
+		writeUpstreamError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+
+	var usage types.Usage
+	var completion strings.Builder
+	for chunk := range chunks {
+		if chunk.Error != nil {
+			data, _ := json.Marshal(errorBody("upstream_error", chunk.Error.Error()))
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+			break
+		}
+		if chunk.Usage.TotalTokens > 0 {
+			usage = chunk.Usage
+		}
+		completion.WriteString(chunk.Message.Content)
+
+		data, err := json.Marshal(public(chunk))
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	// Not every provider reports usage on streams
+	if usage.TotalTokens == 0 {
+		usage.PromptTokens = tokenizer.CountChat(req)
+		usage.CompletionTokens = tokenizer.Count(completion.String())
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	g.settle(key, route, req, reserved, budget, usage)
+}
+
+// settle charges a completed request's usage to its key, settling its rate
+// limit and budget reservations
+func (g *Gateway) settle(key *keyState, route Route, req *types.ChatRequest, reserved int, budget *cost.Reservation, usage types.Usage) {
+	key.limiter.Settle(reserved, usage.TotalTokens)
+
+	g.mu.RLock()
+	pricing := g.pricing
+	g.mu.RUnlock()
+	if c, err := pricing.Price(route.Provider, route.Model, usage); err == nil {
+		budget.Settle(c)
+	} else {
+		budget.Keep()
+	}
+	if g.tracker != nil {
+		g.tracker.TrackTaggedUsage(route.Provider, route.Model, usage, req.Labels)
+	}
+}
+
+// public returns a copy of resp without the upstream's rate limit state and
+// request metadata, which are not the virtual key holder's to see
+func public(resp *types.ChatResponse) *types.ChatResponse {
+	out := *resp
+	out.RateLimits = nil
+	out.Metadata = nil
+	return &out
+}
+
+// Usage is a key's spend, as reported by GET /v1/usage
+type Usage struct {
+	Name string `json:"name"`
+	// DailySpend is the US dollar spend over the last 24 hours
+	DailySpend    float64 `json:"daily_spend"`
+	MaxCostPerDay float64 `json:"max_cost_per_day,omitempty"`
+}
+
+func (g *Gateway) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request", "method not allowed")
+		return
+	}
+
+	key, err := g.authenticate(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "invalid_api_key", "invalid API key")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Usage{
+		Name:          key.Name,
+		DailySpend:    key.budget.DailySpend(),
+		MaxCostPerDay: key.MaxCostPerDay,
+	})
+}
+
+// errorBody is the JSON body of an error response
+func errorBody(code, message string) map[string]any {
+	return map[string]any{"error": map[string]string{"type": code, "message": message}}
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorBody(code, message))
+}
+
+// writeUpstreamError maps an upstream error onto a gateway response
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, types.ErrRateLimitExceeded):
+		writeError(w, http.StatusTooManyRequests, "upstream_rate_limited", err.Error())
+	case errors.Is(err, types.ErrBudgetExceeded):
+		writeError(w, http.StatusPaymentRequired, "budget_exceeded", err.Error())
+	case errors.Is(err, types.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, "upstream_timeout", err.Error())
+	case errors.Is(err, types.ErrInvalidRequest), errors.Is(err, types.ErrContextTooLong):
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+	default:
+		writeError(w, http.StatusBadGateway, "upstream_error", err.Error())
+	}
+}
@@ -0,0 +1,334 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/client"
+	"github.com/ksred/llm/internal/tokenizer"
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/types"
+)
+
+var _ Upstream = (*client.Client)(nil)
+
+// fakeUpstream answers every request with fixed content and usage
+type fakeUpstream struct {
+	requests []*types.ChatRequest
+	err      error
+}
+
+func (f *fakeUpstream) response() *types.ChatResponse {
+	return &types.ChatResponse{Response: types.Response{
+		ID:       "resp-1",
+		Provider: "openai",
+		Model:    "gpt-4",
+		Message:  types.Message{Role: types.RoleAssistant, Content: "Hello"},
+		Usage:    types.Usage{PromptTokens: 1000, CompletionTokens: 0, TotalTokens: 1000},
+		// Upstream details that are not passed on to key holders
+		RateLimits: &types.RateLimits{RequestsRemaining: 99},
+		Metadata:   map[string]any{"user": "upstream"},
+	}}
+}
+
+func (f *fakeUpstream) Chat(_ context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	f.requests = append(f.requests, req)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.response(), nil
+}
+
+func (f *fakeUpstream) StreamChat(_ context.Context, req *types.ChatRequest) (<-chan *types.ChatResponse, error) {
+	f.requests = append(f.requests, req)
+	if f.err != nil {
+		return nil, f.err
+	}
+	ch := make(chan *types.ChatResponse, 2)
+	first := f.response()
+	first.Message.Content = "Hel"
+	first.Usage = types.Usage{}
+	last := f.response()
+	last.Message.Content = "lo"
+	ch <- first
+	ch <- last
+	close(ch)
+	return ch, nil
+}
+
+const chatBody = `{"model":"gpt-4","messages":[{"role":"user","content":"Hi"}],"max_tokens":10}`
+
+func do(t *testing.T, h http.Handler, method, path, apiKey, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if apiKey != "" {
+		r.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func newGateway(upstream Upstream) *Gateway {
+	gw := New(cost.NewCostTracker())
+	gw.AddRoute("gpt-4", Route{Provider: "openai", Model: "gpt-4", Upstream: upstream})
+	gw.AddRoute("claude", Route{Provider: "anthropic", Model: "claude-3-opus", Upstream: upstream})
+	return gw
+}
+
+func TestGateway_Chat(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        Key
+		apiKey     string
+		body       string
+		upstream   error
+		wantStatus int
+	}{
+		{name: "valid request", apiKey: "sk-a", body: chatBody, wantStatus: http.StatusOK},
+		{name: "missing key", body: chatBody, wantStatus: http.StatusUnauthorized},
+		{name: "unknown key", apiKey: "sk-b", body: chatBody, wantStatus: http.StatusUnauthorized},
+		{name: "invalid body", apiKey: "sk-a", body: `{"model":`, wantStatus: http.StatusBadRequest},
+		{name: "no messages", apiKey: "sk-a", body: `{"model":"gpt-4"}`, wantStatus: http.StatusBadRequest},
+		{
+			name:       "unknown model",
+			apiKey:     "sk-a",
+			body:       `{"model":"gpt-5","messages":[{"role":"user","content":"Hi"}]}`,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "model not allowed",
+			key:        Key{Models: []string{"claude"}},
+			apiKey:     "sk-a",
+			body:       chatBody,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "over request budget",
+			key:        Key{MaxCostPerRequest: 0.0001},
+			apiKey:     "sk-a",
+			body:       chatBody,
+			wantStatus: http.StatusPaymentRequired,
+		},
+		{
+			name:       "upstream rate limited",
+			apiKey:     "sk-a",
+			body:       chatBody,
+			upstream:   types.ErrRateLimitExceeded,
+			wantStatus: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := &fakeUpstream{err: tt.upstream}
+			gw := newGateway(upstream)
+			key := tt.key
+			key.Name = "team-a"
+			gw.AddKey("sk-a", key)
+
+			w := do(t, gw, http.MethodPost, "/v1/chat/completions", tt.apiKey, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var resp types.ChatResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if resp.Message.Content != "Hello" {
+				t.Errorf("content = %q, want %q", resp.Message.Content, "Hello")
+			}
+			if resp.RateLimits != nil || resp.Metadata != nil {
+				t.Errorf("response passed on rate limits %+v and metadata %v", resp.RateLimits, resp.Metadata)
+			}
+			if got := upstream.requests[0].Labels[types.LabelTenant]; got != "team-a" {
+				t.Errorf("tenant label = %q, want %q", got, "team-a")
+			}
+		})
+	}
+}
+
+func TestGateway_Limits(t *testing.T) {
+	upstream := &fakeUpstream{}
+	gw := newGateway(upstream)
+	gw.AddKey("sk-a", Key{Name: "team-a", RequestsPerMinute: 2})
+	// Each request costs $0.03, which uses up the daily budget after two
+	gw.AddKey("sk-b", Key{Name: "team-b", MaxCostPerDay: 0.06})
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		w := do(t, gw, http.MethodPost, "/v1/chat/completions", "sk-a", chatBody)
+		if w.Code != want {
+			t.Errorf("rate limited request %d status = %d, want %d", i, w.Code, want)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Error("rate limited response has no Retry-After header")
+		}
+	}
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusPaymentRequired} {
+		w := do(t, gw, http.MethodPost, "/v1/chat/completions", "sk-b", chatBody)
+		if w.Code != want {
+			t.Errorf("budgeted request %d status = %d, want %d", i, w.Code, want)
+		}
+	}
+
+	w := do(t, gw, http.MethodGet, "/v1/usage", "sk-b", "")
+	var usage Usage
+	if err := json.NewDecoder(w.Body).Decode(&usage); err != nil {
+		t.Fatalf("decoding usage: %v", err)
+	}
+	if usage.Name != "team-b" || usage.DailySpend < 0.059 || usage.DailySpend > 0.061 {
+		t.Errorf("usage = %+v, want team-b spending $0.06", usage)
+	}
+
+	groups, err := gw.tracker.SpendBy(context.Background(), cost.UsageQuery{}, types.LabelTenant)
+	if err != nil {
+		t.Fatalf("SpendBy() error = %v", err)
+	}
+	if len(groups) != 2 || groups[0].RequestCount != 2 || groups[1].RequestCount != 2 {
+		t.Errorf("tracked spend = %+v, want two requests for each key", groups)
+	}
+
+	gw.RemoveKey("sk-b")
+	if w := do(t, gw, http.MethodGet, "/v1/usage", "sk-b", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+// blockingUpstream holds each request until released
+type blockingUpstream struct {
+	fakeUpstream
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingUpstream) Chat(_ context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return b.response(), nil
+}
+
+func TestGateway_ConcurrentBudget(t *testing.T) {
+	upstream := &blockingUpstream{started: make(chan struct{}), release: make(chan struct{})}
+	gw := newGateway(upstream)
+	// Room for three requests' estimates while they are in flight
+	req := &types.ChatRequest{Messages: []types.Message{{Role: types.RoleUser, Content: "Hi"}}}
+	estimate := cost.DefaultCatalog().Estimate("openai", "gpt-4", tokenizer.CountChat(req), 10)
+	gw.AddKey("sk-a", Key{Name: "team-a", MaxCostPerDay: 3.5 * estimate})
+
+	const requests = 10
+	codes := make(chan int, requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			codes <- do(t, gw, http.MethodPost, "/v1/chat/completions", "sk-a", chatBody).Code
+		}()
+	}
+
+	admitted, rejected := 0, 0
+	for admitted+rejected < requests {
+		select {
+		case <-upstream.started:
+			admitted++
+		case code := <-codes:
+			if code != http.StatusPaymentRequired {
+				t.Errorf("rejected request status = %d, want %d", code, http.StatusPaymentRequired)
+			}
+			rejected++
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out with %d requests admitted and %d rejected", admitted, rejected)
+		}
+	}
+	close(upstream.release)
+	for i := 0; i < admitted; i++ {
+		<-codes
+	}
+
+	if admitted != 3 {
+		t.Errorf("admitted %d concurrent requests, want 3 within the daily budget", admitted)
+	}
+}
+
+func TestGateway_Stream(t *testing.T) {
+	gw := newGateway(&fakeUpstream{})
+	gw.AddKey("sk-a", Key{Name: "team-a", MaxCostPerDay: 1})
+
+	body := `{"model":"gpt-4","stream":true,"messages":[{"role":"user","content":"Hi"}]}`
+	w := do(t, gw, http.MethodPost, "/v1/chat/completions", "sk-a", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	var content strings.Builder
+	var done bool
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		var chunk types.ChatResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("decoding chunk %q: %v", data, err)
+		}
+		content.WriteString(chunk.Message.Content)
+		if chunk.RateLimits != nil || chunk.Metadata != nil {
+			t.Errorf("chunk passed on rate limits %+v and metadata %v", chunk.RateLimits, chunk.Metadata)
+		}
+	}
+	if content.String() != "Hello" || !done {
+		t.Errorf("streamed %q (done %v), want %q then [DONE]", content.String(), done, "Hello")
+	}
+
+	// The final chunk's usage is charged to the key
+	if spend := gw.keys[hashKey("sk-a")].budget.DailySpend(); spend < 0.029 || spend > 0.031 {
+		t.Errorf("daily spend = %v, want 0.03", spend)
+	}
+}
+
+func TestGateway_RequestFields(t *testing.T) {
+	upstream := &fakeUpstream{}
+	gw := newGateway(upstream)
+	gw.AddKey("sk-a", Key{Name: "team-a", Models: []string{"gpt-4"}})
+	gw.AddKey("sk-b", Key{Name: "team-b"})
+
+	body := `{"model":"gpt-4","messages":[{"role":"user","content":"Hi"}],"max_tokens":10,` +
+		`"provider_params":{"model":"gpt-4-32k","max_tokens":100000},` +
+		`"request_metadata":{"tenant":"team-b"},"idempotency_key":"k1"}`
+	if w := do(t, gw, http.MethodPost, "/v1/chat/completions", "sk-a", body); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if w := do(t, gw, http.MethodPost, "/v1/chat/completions", "sk-b", body); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	a, b := upstream.requests[0], upstream.requests[1]
+	if a.ProviderParams != nil || a.RequestMetadata != nil {
+		t.Errorf("upstream request kept provider params %v and metadata %v", a.ProviderParams, a.RequestMetadata)
+	}
+	if a.MaxTokens != 10 {
+		t.Errorf("max tokens = %d, want 10", a.MaxTokens)
+	}
+	if a.IdempotencyKey == "" || a.IdempotencyKey == "k1" || a.IdempotencyKey == b.IdempotencyKey {
+		t.Errorf("idempotency keys = %q and %q, want distinct keys namespaced per virtual key", a.IdempotencyKey, b.IdempotencyKey)
+	}
+
+	large := `{"model":"gpt-4","messages":[{"role":"user","content":"` + strings.Repeat("a", MaxBodyBytes) + `"}]}`
+	if w := do(t, gw, http.MethodPost, "/v1/chat/completions", "sk-a", large); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,137 @@
+package guardrails
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// Finding describes content that failed a check
+type Finding struct {
+	Check  string `json:"check"`
+	Reason string `json:"reason"`
+
+	// Spans are the byte ranges of the offending text. Nil means the whole
+	// text is affected.
+	Spans [][2]int `json:"-"`
+}
+
+// Check inspects text, returning nil if it passes
+type Check interface {
+	Check(ctx context.Context, text string) (*Finding, error)
+}
+
+// CheckFunc adapts a function to a Check
+type CheckFunc func(ctx context.Context, text string) (*Finding, error)
+
+// Check implements Check
+func (f CheckFunc) Check(ctx context.Context, text string) (*Finding, error) {
+	return f(ctx, text)
+}
+
+// Denylist flags text matching any of a set of regular expressions
+type Denylist struct {
+	patterns []*regexp.Regexp
+}
+
+// NewDenylist compiles patterns into a Denylist
+func NewDenylist(patterns ...string) (*Denylist, error) {
+	d := &Denylist{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("compiling pattern %q: %w", p, err)
+		}
+		d.patterns = append(d.patterns, re)
+	}
+	return d, nil
+}
+
+// Check implements Check
+func (d *Denylist) Check(ctx context.Context, text string) (*Finding, error) {
+	var spans [][2]int
+	var matched []string
+	for _, re := range d.patterns {
+		locs := re.FindAllStringIndex(text, -1)
+		if len(locs) == 0 {
+			continue
+		}
+		matched = append(matched, re.String())
+		for _, loc := range locs {
+			spans = append(spans, [2]int{loc[0], loc[1]})
+		}
+	}
+	if len(spans) == 0 {
+		return nil, nil
+	}
+	return &Finding{
+		Check:  "denylist",
+		Reason: "matched " + strings.Join(matched, ", "),
+		Spans:  spans,
+	}, nil
+}
+
+// MaxLength flags text longer than a number of characters. Redacting
+// truncates the text to the limit.
+type MaxLength int
+
+// Check implements Check
+func (m MaxLength) Check(ctx context.Context, text string) (*Finding, error) {
+	n := utf8.RuneCountInString(text)
+	if n <= int(m) {
+		return nil, nil
+	}
+
+	// Find the byte offset of the first rune past the limit
+	offset := 0
+	for i := 0; i < int(m); i++ {
+		_, size := utf8.DecodeRuneInString(text[offset:])
+		offset += size
+	}
+	return &Finding{
+		Check:  "max_length",
+		Reason: fmt.Sprintf("%d characters exceeds limit of %d", n, int(m)),
+		Spans:  [][2]int{{offset, len(text)}},
+	}, nil
+}
+
+// Chatter sends a chat request. *client.Client satisfies this interface.
+type Chatter interface {
+	Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error)
+}
+
+// TopicClassifier asks a model whether text is about any of a set of
+// topics. A small, cheap model is usually sufficient.
+type TopicClassifier struct {
+	Chatter Chatter
+	Topics  []string
+}
+
+// Check implements Check
+func (c *TopicClassifier) Check(ctx context.Context, text string) (*Finding, error) {
+	prompt := "You are a content classifier. Reply with only the matching topic, " +
+		"or NONE if the text is not about any of these topics: " + strings.Join(c.Topics, ", ")
+
+	resp, err := c.Chatter.Chat(ctx, &types.ChatRequest{
+		Messages: []types.Message{
+			{Role: types.RoleSystem, Content: prompt},
+			{Role: types.RoleUser, Content: text},
+		},
+		MaxTokens: 16,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("classifying topic: %w", err)
+	}
+
+	answer := strings.TrimSpace(resp.Message.Content)
+	for _, topic := range c.Topics {
+		if strings.EqualFold(strings.Trim(answer, ".\"'"), topic) {
+			return &Finding{Check: "topic", Reason: "about " + topic}, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,235 @@
+package guardrails
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+var ErrBlocked = errors.New("blocked by guardrail")
+
+// DefaultReplacement replaces redacted text
+const DefaultReplacement = "[REDACTED]"
+
+// metadataKey holds annotated findings in message metadata
+const metadataKey = "guardrails"
+
+// Action is what a rule does when its check fails
+type Action int
+
+const (
+	// Block fails the request or response
+	Block Action = iota
+	// Redact replaces the offending text
+	Redact
+	// Annotate records the finding in the message metadata and lets the
+	// content through unchanged
+	Annotate
+)
+
+// BlockedError reports the finding that blocked content
+type BlockedError struct {
+	Finding *Finding
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ErrBlocked, e.Finding.Check, e.Finding.Reason)
+}
+
+func (e *BlockedError) Unwrap() error {
+	return ErrBlocked
+}
+
+// Rule pairs a check with the action to take when it fails
+type Rule struct {
+	Check       Check
+	Action      Action
+	Replacement string // Used by Redact; defaults to DefaultReplacement
+}
+
+// maxChecked bounds the user messages a Guard remembers having checked
+const maxChecked = 1000
+
+// Guard applies rules to user input and model output
+type Guard struct {
+	Input  []Rule
+	Output []Rule
+
+	mu sync.Mutex
+	// checked holds the outcome of user messages that passed the input
+	// rules, by content hash, so a conversation's history is not checked
+	// again on every turn
+	checked map[[sha256.Size]byte]checkedInput
+}
+
+// checkedInput is the outcome of the input rules for one message
+type checkedInput struct {
+	text        string
+	annotations []Finding
+}
+
+// Hooks returns client hooks that enforce the guard. Input rules apply to
+// user messages; output rules apply to replies. Streamed replies are checked
+// chunk by chunk, so patterns spanning chunks are not detected. User
+// messages seen recently, such as the history of a conversation, are not
+// checked again; their earlier outcome is applied.
+//
+//	cfg, err := config.NewConfig(apiKey, config.WithHooks(guard.Hooks()))
+func (g *Guard) Hooks() *types.Hooks {
+	return &types.Hooks{
+		OnRequest: func(ctx context.Context, req *types.ChatRequest) error {
+			for i := range req.Messages {
+				if req.Messages[i].Role != types.RoleUser {
+					continue
+				}
+				if err := g.checkInput(ctx, &req.Messages[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		OnResponse: func(ctx context.Context, req *types.ChatRequest, resp *types.ChatResponse) error {
+			return apply(ctx, g.Output, &resp.Message)
+		},
+		OnChunk: func(ctx context.Context, chunk *types.ChatResponse) *types.ChatResponse {
+			if chunk.Error != nil || chunk.Message.Content == "" {
+				return chunk
+			}
+			if err := apply(ctx, g.Output, &chunk.Message); err != nil {
+				chunk.Message.Content = ""
+				chunk.Error = err
+			}
+			return chunk
+		},
+	}
+}
+
+// CheckText runs rules against text, returning the possibly redacted text,
+// the findings of Annotate rules, and a *BlockedError if a Block rule failed
+func CheckText(ctx context.Context, rules []Rule, text string) (string, []Finding, error) {
+	var annotations []Finding
+	for _, rule := range rules {
+		finding, err := rule.Check.Check(ctx, text)
+		if err != nil {
+			return text, annotations, err
+		}
+		if finding == nil {
+			continue
+		}
+
+		switch rule.Action {
+		case Block:
+			return text, annotations, &BlockedError{Finding: finding}
+		case Redact:
+			replacement := rule.Replacement
+			if replacement == "" {
+				replacement = DefaultReplacement
+			}
+			text = redact(text, finding.Spans, replacement)
+		case Annotate:
+			annotations = append(annotations, *finding)
+		}
+	}
+	return text, annotations, nil
+}
+
+// checkInput runs the input rules against a user message, or applies their
+// outcome from when the same content was last checked
+func (g *Guard) checkInput(ctx context.Context, msg *types.Message) error {
+	if len(g.Input) == 0 {
+		return nil
+	}
+
+	key := sha256.Sum256([]byte(msg.Content))
+	g.mu.Lock()
+	in, ok := g.checked[key]
+	g.mu.Unlock()
+	if !ok {
+		text, annotations, err := CheckText(ctx, g.Input, msg.Content)
+		if err != nil {
+			return err
+		}
+		in = checkedInput{text: text, annotations: annotations}
+
+		g.mu.Lock()
+		if g.checked == nil || len(g.checked) >= maxChecked {
+			g.checked = make(map[[sha256.Size]byte]checkedInput)
+		}
+		g.checked[key] = in
+		g.mu.Unlock()
+	}
+	annotate(msg, in.text, in.annotations)
+	return nil
+}
+
+// apply runs rules against a message, updating its content and metadata
+func apply(ctx context.Context, rules []Rule, msg *types.Message) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	text, annotations, err := CheckText(ctx, rules, msg.Content)
+	if err != nil {
+		return err
+	}
+	annotate(msg, text, annotations)
+	return nil
+}
+
+// annotate sets a message's checked content and records its findings
+func annotate(msg *types.Message, text string, annotations []Finding) {
+	msg.Content = text
+
+	if len(annotations) > 0 {
+		if msg.Metadata == nil {
+			msg.Metadata = make(map[string]any)
+		}
+		existing, _ := msg.Metadata[metadataKey].([]Finding)
+		msg.Metadata[metadataKey] = append(existing, annotations...)
+	}
+}
+
+// Findings returns the annotations recorded on a message by Annotate rules
+func Findings(msg types.Message) []Finding {
+	findings, _ := msg.Metadata[metadataKey].([]Finding)
+	return findings
+}
+
+// redact replaces each span of text, merging overlapping spans. Nil spans
+// replace the whole text.
+func redact(text string, spans [][2]int, replacement string) string {
+	if len(spans) == 0 {
+		return replacement
+	}
+
+	sorted := append([][2]int(nil), spans...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i][0] < sorted[j][0] })
+
+	merged := sorted[:1]
+	for _, span := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if span[0] <= last[1] {
+			if span[1] > last[1] {
+				last[1] = span[1]
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+
+	var out strings.Builder
+	pos := 0
+	for _, span := range merged {
+		out.WriteString(text[pos:span[0]])
+		out.WriteString(replacement)
+		pos = span[1]
+	}
+	out.WriteString(text[pos:])
+	return out.String()
+}
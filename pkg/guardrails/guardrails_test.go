@@ -0,0 +1,193 @@
+package guardrails
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+type stubChatter struct {
+	reply string
+}
+
+func (s *stubChatter) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	return &types.ChatResponse{
+		Response: types.Response{Message: types.Message{Content: s.reply}},
+	}, nil
+}
+
+func mustDenylist(t *testing.T, patterns ...string) *Denylist {
+	t.Helper()
+	d, err := NewDenylist(patterns...)
+	if err != nil {
+		t.Fatalf("NewDenylist() error = %v", err)
+	}
+	return d
+}
+
+func TestCheckText(t *testing.T) {
+	ssn := mustDenylist(t, `\d{3}-\d{2}-\d{4}`)
+	secret := mustDenylist(t, `(?i)password`, `(?i)pass`)
+
+	tests := []struct {
+		name        string
+		rules       []Rule
+		text        string
+		want        string
+		wantBlocked bool
+		wantNotes   int
+	}{
+		{
+			name:  "clean text passes",
+			rules: []Rule{{Check: ssn, Action: Block}},
+			text:  "hello",
+			want:  "hello",
+		},
+		{
+			name:        "denylist blocks",
+			rules:       []Rule{{Check: ssn, Action: Block}},
+			text:        "my ssn is 123-45-6789",
+			wantBlocked: true,
+		},
+		{
+			name:  "denylist redacts every match",
+			rules: []Rule{{Check: ssn, Action: Redact}},
+			text:  "123-45-6789 and 987-65-4321",
+			want:  "[REDACTED] and [REDACTED]",
+		},
+		{
+			name:  "overlapping matches redact once",
+			rules: []Rule{{Check: secret, Action: Redact, Replacement: "***"}},
+			text:  "my Password is",
+			want:  "my *** is",
+		},
+		{
+			name:  "max length truncates",
+			rules: []Rule{{Check: MaxLength(5), Action: Redact, Replacement: "…"}},
+			text:  "héllo world",
+			want:  "héllo…",
+		},
+		{
+			name:      "annotate keeps text",
+			rules:     []Rule{{Check: ssn, Action: Annotate}},
+			text:      "123-45-6789",
+			want:      "123-45-6789",
+			wantNotes: 1,
+		},
+		{
+			name:        "topic classifier blocks",
+			rules:       []Rule{{Check: &TopicClassifier{Chatter: &stubChatter{reply: "Medical."}, Topics: []string{"medical", "legal"}}, Action: Block}},
+			text:        "what dose should I take?",
+			wantBlocked: true,
+		},
+		{
+			name:  "topic classifier passes",
+			rules: []Rule{{Check: &TopicClassifier{Chatter: &stubChatter{reply: "NONE"}, Topics: []string{"medical"}}, Action: Block}},
+			text:  "hello",
+			want:  "hello",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, notes, err := CheckText(context.Background(), tt.rules, tt.text)
+			if tt.wantBlocked {
+				var blocked *BlockedError
+				if !errors.As(err, &blocked) || !errors.Is(err, ErrBlocked) {
+					t.Fatalf("CheckText() error = %v, want BlockedError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CheckText() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("CheckText() = %q, want %q", got, tt.want)
+			}
+			if len(notes) != tt.wantNotes {
+				t.Errorf("CheckText() findings = %d, want %d", len(notes), tt.wantNotes)
+			}
+		})
+	}
+}
+
+func TestGuard_Hooks(t *testing.T) {
+	guard := &Guard{
+		Input:  []Rule{{Check: mustDenylist(t, `secret`), Action: Redact}},
+		Output: []Rule{{Check: mustDenylist(t, `forbidden`), Action: Block}, {Check: MaxLength(3), Action: Annotate}},
+	}
+	hooks := guard.Hooks()
+	ctx := context.Background()
+
+	req := &types.ChatRequest{Messages: []types.Message{
+		{Role: types.RoleSystem, Content: "the secret word"},
+		{Role: types.RoleUser, Content: "tell me the secret"},
+	}}
+	if err := hooks.OnRequest(ctx, req); err != nil {
+		t.Fatalf("OnRequest() error = %v", err)
+	}
+	if req.Messages[0].Content != "the secret word" {
+		t.Errorf("system message changed to %q", req.Messages[0].Content)
+	}
+	if req.Messages[1].Content != "tell me the [REDACTED]" {
+		t.Errorf("user message = %q", req.Messages[1].Content)
+	}
+
+	resp := &types.ChatResponse{Response: types.Response{Message: types.Message{Content: "fine"}}}
+	if err := hooks.OnResponse(ctx, req, resp); err != nil {
+		t.Fatalf("OnResponse() error = %v", err)
+	}
+	if findings := Findings(resp.Message); len(findings) != 1 || findings[0].Check != "max_length" {
+		t.Errorf("Findings() = %+v", findings)
+	}
+
+	resp.Message.Content = "forbidden"
+	if err := hooks.OnResponse(ctx, req, resp); !errors.Is(err, ErrBlocked) {
+		t.Errorf("OnResponse() error = %v, want %v", err, ErrBlocked)
+	}
+
+	chunk := hooks.OnChunk(ctx, &types.ChatResponse{Response: types.Response{Message: types.Message{Content: "forbidden"}}})
+	if !errors.Is(chunk.Error, ErrBlocked) || chunk.Message.Content != "" {
+		t.Errorf("OnChunk() = %+v, want blocked chunk", chunk)
+	}
+}
+
+func TestGuard_InputHistory(t *testing.T) {
+	var checked []string
+	guard := &Guard{Input: []Rule{{
+		Check: CheckFunc(func(ctx context.Context, text string) (*Finding, error) {
+			checked = append(checked, text)
+			if i := strings.Index(text, "secret"); i >= 0 {
+				return &Finding{Check: "secret", Spans: [][2]int{{i, i + len("secret")}}}, nil
+			}
+			return nil, nil
+		}),
+		Action: Redact,
+	}}}
+	hooks := guard.Hooks()
+	ctx := context.Background()
+
+	history := []types.Message{{Role: types.RoleUser, Content: "my secret"}}
+	if err := hooks.OnRequest(ctx, &types.ChatRequest{Messages: append([]types.Message(nil), history...)}); err != nil {
+		t.Fatalf("OnRequest() error = %v", err)
+	}
+
+	history = append(history,
+		types.Message{Role: types.RoleAssistant, Content: "noted"},
+		types.Message{Role: types.RoleUser, Content: "what was it?"},
+	)
+	req := &types.ChatRequest{Messages: append([]types.Message(nil), history...)}
+	if err := hooks.OnRequest(ctx, req); err != nil {
+		t.Fatalf("OnRequest() error = %v", err)
+	}
+	if want := []string{"my secret", "what was it?"}; !reflect.DeepEqual(checked, want) {
+		t.Errorf("checked %q, want each user message checked once: %q", checked, want)
+	}
+	if got := req.Messages[0].Content; got != "my [REDACTED]" {
+		t.Errorf("history content = %q, want it still redacted", got)
+	}
+}
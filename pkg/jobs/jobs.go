@@ -0,0 +1,385 @@
+// Package jobs runs chat requests in the background. A request is submitted
+// and gets a job ID straight away; the result is fetched later by ID. This
+// suits web handlers that must answer before a long generation finishes.
+//
+//	q := jobs.NewQueue(c, jobs.WithWorkers(4))
+//	defer q.Close()
+//
+//	id, err := q.Submit(ctx, "", req)
+//	// later, possibly from another request
+//	job, err := q.Get(ctx, id)
+//	if job.Status == jobs.StatusSucceeded {
+//		fmt.Println(job.Response.Message.Content)
+//	}
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+	"github.com/ksred/llm/pkg/webhook"
+)
+
+var (
+	ErrJobNotFound  = errors.New("job not found")
+	ErrDuplicateJob = errors.New("job ID already in use")
+	ErrQueueFull    = errors.New("job queue is full")
+	ErrQueueClosed  = errors.New("job queue is closed")
+	ErrJobStopped   = errors.New("job stopped before finishing")
+)
+
+const (
+	defaultWorkers   = 4
+	defaultQueueSize = 1000
+)
+
+// Chatter sends a chat request. *client.Client satisfies this interface.
+type Chatter interface {
+	Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error)
+}
+
+// Status is the state of a job
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// Done reports whether the job has finished, successfully or not
+func (s Status) Done() bool {
+	return s == StatusSucceeded || s == StatusFailed
+}
+
+// Job is a submitted request and, once finished, its outcome
+type Job struct {
+	ID       string              `json:"id"`
+	Status   Status              `json:"status"`
+	Request  *types.ChatRequest  `json:"request"`
+	Response *types.ChatResponse `json:"response,omitempty"`
+	// Error is the failure message of a failed job
+	Error string `json:"error,omitempty"`
+
+	CreatedAt  time.Time `json:"created_at"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// clone returns a copy of the job that shares its request and response
+func (j *Job) clone() *Job {
+	c := *j
+	return &c
+}
+
+// Queue runs submitted jobs on a pool of workers
+type Queue struct {
+	chatter    Chatter
+	store      Store
+	workers    int
+	queueSize  int
+	jobTimeout time.Duration
+	onDone     []func(*Job)
+
+	pending chan string
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool
+	active  map[string]bool // jobs queued or running here
+	waiters map[string][]chan error
+}
+
+// Option configures a Queue
+type Option func(*Queue)
+
+// WithStore keeps jobs in store instead of in memory, so they survive
+// restarts when store is persistent
+func WithStore(store Store) Option {
+	return func(q *Queue) {
+		q.store = store
+	}
+}
+
+// WithWorkers sets the number of jobs run at once. Defaults to 4.
+func WithWorkers(n int) Option {
+	return func(q *Queue) {
+		q.workers = n
+	}
+}
+
+// WithQueueSize sets the number of jobs that may wait to run before Submit
+// returns ErrQueueFull. Defaults to 1000.
+func WithQueueSize(n int) Option {
+	return func(q *Queue) {
+		q.queueSize = n
+	}
+}
+
+// WithJobTimeout bounds each job's run. Zero, the default, means no limit.
+func WithJobTimeout(d time.Duration) Option {
+	return func(q *Queue) {
+		q.jobTimeout = d
+	}
+}
+
+// WithOnDone calls fn with each job when it finishes. Calls are made from
+// the worker that ran the job, before waiters are released.
+func WithOnDone(fn func(*Job)) Option {
+	return func(q *Queue) {
+		q.onDone = append(q.onDone, fn)
+	}
+}
+
+// WithWebhook delivers a "job.succeeded" or "job.failed" event, whose data
+// is the finished Job, to sender when each job finishes
+func WithWebhook(sender *webhook.Sender) Option {
+	return WithOnDone(func(j *Job) {
+		sender.Deliver(webhook.NewEvent("job."+string(j.Status), j))
+	})
+}
+
+// NewQueue creates a queue sending requests with chatter and starts its
+// workers. Close it when done.
+func NewQueue(chatter Chatter, opts ...Option) *Queue {
+	q := &Queue{
+		chatter:   chatter,
+		workers:   defaultWorkers,
+		queueSize: defaultQueueSize,
+		active:    make(map[string]bool),
+		waiters:   make(map[string][]chan error),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	if q.store == nil {
+		q.store = NewMemoryStore()
+	}
+	if q.workers < 1 {
+		q.workers = 1
+	}
+
+	q.pending = make(chan string, q.queueSize)
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Submit queues req and returns its job ID. An empty id is replaced by a
+// random one.
+func (q *Queue) Submit(ctx context.Context, id string, req *types.ChatRequest) (string, error) {
+	if err := req.Validate(); err != nil {
+		return "", err
+	}
+	if id == "" {
+		id = newID()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return "", ErrQueueClosed
+	}
+	if len(q.pending) == cap(q.pending) {
+		return "", ErrQueueFull
+	}
+
+	job := &Job{ID: id, Status: StatusQueued, Request: req, CreatedAt: time.Now()}
+	if err := q.store.Create(ctx, job); err != nil {
+		return "", err
+	}
+	q.active[id] = true
+	q.pending <- id
+	return id, nil
+}
+
+// Get returns the job with the given ID
+func (q *Queue) Get(ctx context.Context, id string) (*Job, error) {
+	return q.store.Get(ctx, id)
+}
+
+// Wait blocks until the job has finished, or ctx is done, and returns it.
+// If the queue is closed first Wait returns ErrQueueClosed, and if the job
+// is not queued here, such as an unfinished job before Resume, or cannot be
+// run because the store failed, an error wrapping ErrJobStopped.
+func (q *Queue) Wait(ctx context.Context, id string) (*Job, error) {
+	q.mu.Lock()
+	job, err := q.store.Get(ctx, id)
+	if err != nil || job.Status.Done() {
+		q.mu.Unlock()
+		return job, err
+	}
+	if q.closed {
+		q.mu.Unlock()
+		return nil, ErrQueueClosed
+	}
+	if !q.active[id] {
+		q.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s is not queued", ErrJobStopped, id)
+	}
+	done := make(chan error, 1)
+	q.waiters[id] = append(q.waiters[id], done)
+	q.mu.Unlock()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+		return q.store.Get(ctx, id)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Resume queues the jobs a persistent store holds as queued or running,
+// such as those interrupted by a restart. Call it once after NewQueue.
+func (q *Queue) Resume(ctx context.Context) error {
+	unfinished, err := q.store.Unfinished(ctx)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range unfinished {
+		if q.closed {
+			return ErrQueueClosed
+		}
+		select {
+		case q.pending <- job.ID:
+			q.active[job.ID] = true
+		default:
+			return ErrQueueFull
+		}
+	}
+	return nil
+}
+
+// Close stops accepting jobs, cancels those running and waits for the
+// workers to stop. Jobs not yet run stay queued in the store.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	close(q.pending)
+	q.mu.Unlock()
+
+	q.cancel()
+	q.wg.Wait()
+
+	// Jobs still waited on will not finish
+	q.mu.Lock()
+	for id := range q.waiters {
+		q.releaseLocked(id, ErrQueueClosed)
+	}
+	q.mu.Unlock()
+	return nil
+}
+
+// work runs queued jobs until the queue is closed
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for id := range q.pending {
+		if q.ctx.Err() != nil {
+			return
+		}
+		q.run(id)
+	}
+}
+
+// run runs one job and records its outcome
+func (q *Queue) run(id string) {
+	job, err := q.store.Get(q.ctx, id)
+	if err != nil {
+		q.release(id, fmt.Errorf("%w: loading job: %w", ErrJobStopped, err))
+		return
+	}
+	if job.Status.Done() {
+		q.release(id, nil)
+		return
+	}
+
+	job.Status = StatusRunning
+	job.StartedAt = time.Now()
+	if err := q.store.Update(q.ctx, job); err != nil {
+		q.release(id, fmt.Errorf("%w: starting job: %w", ErrJobStopped, err))
+		return
+	}
+
+	ctx := q.ctx
+	if q.jobTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.jobTimeout)
+		defer cancel()
+	}
+	resp, err := q.chatter.Chat(ctx, job.Request)
+
+	// A job cut short by Close is left to be resumed, and Close releases
+	// its waiters
+	if q.ctx.Err() != nil {
+		return
+	}
+
+	job = job.clone()
+	job.FinishedAt = time.Now()
+	if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = StatusSucceeded
+		job.Response = resp
+	}
+	// The outcome is stored even if the queue is closing
+	var stored error
+	if err := q.store.Update(context.Background(), job); err != nil {
+		stored = fmt.Errorf("storing result: %w", err)
+		job.Status = StatusFailed
+		job.Error = stored.Error()
+	}
+
+	for _, fn := range q.onDone {
+		fn(job.clone())
+	}
+	q.release(id, stored)
+}
+
+// release wakes the job's waiters, passing them err, once the job has
+// finished or stopped
+func (q *Queue) release(id string, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked(id, err)
+}
+
+func (q *Queue) releaseLocked(id string, err error) {
+	for _, done := range q.waiters[id] {
+		done <- err
+	}
+	delete(q.waiters, id)
+	delete(q.active, id)
+}
+
+// newID returns a random job ID
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("job-%d", time.Now().UnixNano())
+	}
+	return "job_" + hex.EncodeToString(b)
+}
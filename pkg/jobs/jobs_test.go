@@ -0,0 +1,299 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+	"github.com/ksred/llm/pkg/webhook"
+)
+
+// fakeChatter echoes the prompt, failing prompts equal to "fail" and
+// blocking until release is closed, if set
+type fakeChatter struct {
+	release chan struct{}
+}
+
+func (f *fakeChatter) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	if f.release != nil {
+		select {
+		case <-f.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	content := req.Messages[0].Content
+	if content == "fail" {
+		return nil, types.ErrInvalidRequest
+	}
+	return &types.ChatResponse{Response: types.Response{
+		Message: types.Message{Role: types.RoleAssistant, Content: "re: " + content},
+	}}, nil
+}
+
+func request(content string) *types.ChatRequest {
+	return types.NewChatRequest([]types.Message{{Role: types.RoleUser, Content: content}})
+}
+
+func TestQueue_SubmitAndWait(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	var finished []string
+	q := NewQueue(&fakeChatter{}, WithWorkers(2), WithOnDone(func(j *Job) {
+		mu.Lock()
+		finished = append(finished, j.ID)
+		mu.Unlock()
+	}))
+	defer q.Close()
+
+	tests := []struct {
+		name       string
+		id         string
+		prompt     string
+		wantStatus Status
+		wantReply  string
+	}{
+		{"generated ID", "", "hello", StatusSucceeded, "re: hello"},
+		{"given ID", "report-42", "summarize", StatusSucceeded, "re: summarize"},
+		{"failure", "", "fail", StatusFailed, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := q.Submit(ctx, tt.id, request(tt.prompt))
+			if err != nil {
+				t.Fatalf("Submit() error = %v", err)
+			}
+			if tt.id != "" && id != tt.id {
+				t.Errorf("Submit() id = %q, want %q", id, tt.id)
+			}
+
+			job, err := q.Wait(ctx, id)
+			if err != nil {
+				t.Fatalf("Wait() error = %v", err)
+			}
+			if job.Status != tt.wantStatus {
+				t.Errorf("Status = %q, want %q (error %q)", job.Status, tt.wantStatus, job.Error)
+			}
+			if tt.wantReply != "" && job.Response.Message.Content != tt.wantReply {
+				t.Errorf("reply = %q, want %q", job.Response.Message.Content, tt.wantReply)
+			}
+			if tt.wantStatus == StatusFailed && job.Error == "" {
+				t.Error("failed job has no error")
+			}
+			if job.FinishedAt.Before(job.StartedAt) || job.StartedAt.Before(job.CreatedAt) {
+				t.Errorf("times out of order: %+v", job)
+			}
+		})
+	}
+
+	mu.Lock()
+	if len(finished) != 3 {
+		t.Errorf("OnDone called for %d jobs, want 3", len(finished))
+	}
+	mu.Unlock()
+
+	if _, err := q.Submit(ctx, "report-42", request("again")); !errors.Is(err, ErrDuplicateJob) {
+		t.Errorf("Submit() with a used ID error = %v, want ErrDuplicateJob", err)
+	}
+	if _, err := q.Get(ctx, "missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Get() of an unknown ID error = %v, want ErrJobNotFound", err)
+	}
+	if _, err := q.Submit(ctx, "", &types.ChatRequest{}); !errors.Is(err, types.ErrEmptyMessages) {
+		t.Errorf("Submit() of an invalid request error = %v, want ErrEmptyMessages", err)
+	}
+}
+
+func TestQueue_Full(t *testing.T) {
+	ctx := context.Background()
+	chatter := &fakeChatter{release: make(chan struct{})}
+	q := NewQueue(chatter, WithWorkers(1), WithQueueSize(1))
+	defer q.Close()
+
+	first, _ := q.Submit(ctx, "", request("a"))
+	// Wait for the worker to take the first job, emptying the queue
+	for {
+		job, _ := q.Get(ctx, first)
+		if job.Status == StatusRunning {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := q.Submit(ctx, "", request("b")); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if _, err := q.Submit(ctx, "", request("c")); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Submit() to a full queue error = %v, want ErrQueueFull", err)
+	}
+	close(chatter.release)
+}
+
+func TestQueue_Resume(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	// The first queue closes with one job running and one queued
+	chatter := &fakeChatter{release: make(chan struct{})}
+	q := NewQueue(chatter, WithStore(store), WithWorkers(1))
+	running, _ := q.Submit(ctx, "", request("a"))
+	queued, _ := q.Submit(ctx, "", request("b"))
+	for {
+		job, _ := store.Get(ctx, running)
+		if job.Status == StatusRunning {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	q.Close()
+
+	if _, err := q.Submit(ctx, "", request("c")); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("Submit() after Close() error = %v, want ErrQueueClosed", err)
+	}
+	unfinished, _ := store.Unfinished(ctx)
+	if len(unfinished) != 2 {
+		t.Fatalf("unfinished jobs = %d, want 2", len(unfinished))
+	}
+
+	// A new queue on the same store finishes them
+	q = NewQueue(&fakeChatter{}, WithStore(store))
+	defer q.Close()
+	if err := q.Resume(ctx); err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	for _, id := range []string{running, queued} {
+		job, err := q.Wait(ctx, id)
+		if err != nil || job.Status != StatusSucceeded {
+			t.Errorf("resumed job %s = %+v, %v, want succeeded", id, job, err)
+		}
+	}
+
+	if err := store.Prune(ctx, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if _, err := store.Get(ctx, running); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Get() after Prune() error = %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestQueue_WaitClosed(t *testing.T) {
+	ctx := context.Background()
+	q := NewQueue(&fakeChatter{release: make(chan struct{})}, WithWorkers(1))
+	running, _ := q.Submit(ctx, "", request("a"))
+	queued, _ := q.Submit(ctx, "", request("b"))
+
+	errs := make(chan error, 2)
+	for _, id := range []string{running, queued} {
+		go func(id string) {
+			_, err := q.Wait(ctx, id)
+			errs <- err
+		}(id)
+	}
+	// Let the waiters register before closing
+	time.Sleep(10 * time.Millisecond)
+	q.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrQueueClosed) {
+				t.Errorf("Wait() error = %v, want ErrQueueClosed", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Wait() not released by Close()")
+		}
+	}
+	if _, err := q.Wait(ctx, queued); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("Wait() after Close() error = %v, want ErrQueueClosed", err)
+	}
+}
+
+// failingStore fails to update jobs
+type failingStore struct {
+	*MemoryStore
+	err error
+}
+
+func (s *failingStore) Update(ctx context.Context, job *Job) error {
+	return s.err
+}
+
+func TestQueue_WaitStoreError(t *testing.T) {
+	ctx := context.Background()
+	store := &failingStore{MemoryStore: NewMemoryStore(), err: errors.New("database unavailable")}
+	q := NewQueue(&fakeChatter{}, WithStore(store))
+	defer q.Close()
+
+	// Whether Wait registers before or after the job fails to start, it
+	// is released
+	id, err := q.Submit(ctx, "", request("a"))
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := q.Wait(ctx, id)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrJobStopped) {
+			t.Errorf("Wait() error = %v, want %v", err, ErrJobStopped)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait() not released when the job could not start")
+	}
+}
+
+func TestQueue_JobTimeout(t *testing.T) {
+	ctx := context.Background()
+	q := NewQueue(&fakeChatter{release: make(chan struct{})}, WithJobTimeout(10*time.Millisecond))
+	defer q.Close()
+
+	id, _ := q.Submit(ctx, "", request("slow"))
+	job, err := q.Wait(ctx, id)
+	if err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if job.Status != StatusFailed {
+		t.Errorf("Status = %q, want %q", job.Status, StatusFailed)
+	}
+}
+
+func TestQueue_Webhook(t *testing.T) {
+	events := make(chan webhook.Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify([]byte("s3cret"), r.Header.Get(webhook.SignatureHeader), body, time.Minute); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var ev webhook.Event
+		json.Unmarshal(body, &ev)
+		events <- ev
+	}))
+	defer srv.Close()
+
+	sender := webhook.NewSender(srv.URL, "s3cret")
+	q := NewQueue(&fakeChatter{}, WithWebhook(sender))
+	defer q.Close()
+
+	id, _ := q.Submit(context.Background(), "", request("hi"))
+	select {
+	case ev := <-events:
+		data, _ := ev.Data.(map[string]any)
+		if ev.Type != "job.succeeded" || data["id"] != id {
+			t.Errorf("event = %+v, want job.succeeded for %s", ev, id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no webhook delivered")
+	}
+}
@@ -0,0 +1,292 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// Store keeps jobs. Implementations must be safe for concurrent use.
+// Request fields that are not serialized, such as Timeout and Labels, are
+// lost by persistent stores.
+type Store interface {
+	// Create adds a new job, returning ErrDuplicateJob if its ID is taken
+	Create(ctx context.Context, job *Job) error
+	// Update replaces a stored job
+	Update(ctx context.Context, job *Job) error
+	// Get returns a job by ID, or ErrJobNotFound
+	Get(ctx context.Context, id string) (*Job, error)
+	// Unfinished returns the queued and running jobs, oldest first
+	Unfinished(ctx context.Context) ([]*Job, error)
+	// Prune deletes finished jobs that finished before the given time
+	Prune(ctx context.Context, before time.Time) error
+}
+
+// MemoryStore keeps jobs in memory
+type MemoryStore struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: make(map[string]*Job)}
+}
+
+// Create implements Store
+func (s *MemoryStore) Create(_ context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[job.ID]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateJob, job.ID)
+	}
+	s.jobs[job.ID] = job.clone()
+	return nil
+}
+
+// Update implements Store
+func (s *MemoryStore) Update(_ context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[job.ID]; !ok {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, job.ID)
+	}
+	s.jobs[job.ID] = job.clone()
+	return nil
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(_ context.Context, id string) (*Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return job.clone(), nil
+}
+
+// Unfinished implements Store
+func (s *MemoryStore) Unfinished(_ context.Context) ([]*Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []*Job
+	for _, job := range s.jobs {
+		if !job.Status.Done() {
+			out = append(out, job.clone())
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+// Prune implements Store
+func (s *MemoryStore) Prune(_ context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, job := range s.jobs {
+		if job.Status.Done() && job.FinishedAt.Before(before) {
+			delete(s.jobs, id)
+		}
+	}
+	return nil
+}
+
+// SQLStore keeps jobs in a database table, one row per job. The driver is
+// chosen by the caller, which keeps this package free of database
+// dependencies.
+type SQLStore struct {
+	db       *sql.DB
+	table    string
+	postgres bool
+}
+
+// NewSQLiteStore creates a store writing to table in a SQLite database.
+// Call CreateTable to create the table if it does not exist.
+func NewSQLiteStore(db *sql.DB, table string) *SQLStore {
+	return &SQLStore{db: db, table: table}
+}
+
+// NewPostgresStore creates a store writing to table in a Postgres database.
+// Call CreateTable to create the table if it does not exist.
+func NewPostgresStore(db *sql.DB, table string) *SQLStore {
+	return &SQLStore{db: db, table: table, postgres: true}
+}
+
+// CreateTable creates the jobs table and its index if they do not exist
+func (s *SQLStore) CreateTable(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.table+` (
+		id TEXT PRIMARY KEY,
+		status TEXT NOT NULL,
+		request TEXT NOT NULL,
+		response TEXT NOT NULL,
+		error TEXT NOT NULL,
+		created_at BIGINT NOT NULL,
+		started_at BIGINT NOT NULL,
+		finished_at BIGINT NOT NULL
+	)`); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS `+s.table+`_status_idx ON `+s.table+` (status, created_at)`)
+	return err
+}
+
+// Create implements Store. The insert is skipped if the ID is taken, so
+// concurrent submissions with the same ID cannot both succeed.
+func (s *SQLStore) Create(ctx context.Context, job *Job) error {
+	args, err := jobArgs(job)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, s.query(`INSERT INTO `+s.table+`
+		(status, request, response, error, created_at, started_at, finished_at, id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO NOTHING`), args...)
+	if err != nil {
+		return fmt.Errorf("storing job: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %s", ErrDuplicateJob, job.ID)
+	}
+	return nil
+}
+
+// Update implements Store
+func (s *SQLStore) Update(ctx context.Context, job *Job) error {
+	args, err := jobArgs(job)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, s.query(`UPDATE `+s.table+` SET
+		status = ?, request = ?, response = ?, error = ?, created_at = ?, started_at = ?, finished_at = ?
+		WHERE id = ?`), args...)
+	if err != nil {
+		return fmt.Errorf("storing job: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, job.ID)
+	}
+	return nil
+}
+
+// Get implements Store
+func (s *SQLStore) Get(ctx context.Context, id string) (*Job, error) {
+	jobs, err := s.list(ctx, `WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return jobs[0], nil
+}
+
+// Unfinished implements Store
+func (s *SQLStore) Unfinished(ctx context.Context) ([]*Job, error) {
+	return s.list(ctx, `WHERE status IN (?, ?) ORDER BY created_at`, StatusQueued, StatusRunning)
+}
+
+// Prune implements Store
+func (s *SQLStore) Prune(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.query(`DELETE FROM `+s.table+` WHERE status IN (?, ?) AND finished_at < ?`),
+		StatusSucceeded, StatusFailed, before.UnixNano())
+	return err
+}
+
+// list reads the jobs matching a WHERE clause
+func (s *SQLStore) list(ctx context.Context, where string, args ...any) ([]*Job, error) {
+	rows, err := s.db.QueryContext(ctx, s.query(`SELECT id, status, request, response, error, created_at, started_at, finished_at FROM `+s.table+` `+where), args...)
+	if err != nil {
+		return nil, fmt.Errorf("reading jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var out []*Job
+	for rows.Next() {
+		var (
+			job                        Job
+			req, resp                  string
+			created, started, finished int64
+		)
+		if err := rows.Scan(&job.ID, &job.Status, &req, &resp, &job.Error, &created, &started, &finished); err != nil {
+			return nil, fmt.Errorf("reading jobs: %w", err)
+		}
+		if err := json.Unmarshal([]byte(req), &job.Request); err != nil {
+			return nil, fmt.Errorf("decoding request of job %s: %w", job.ID, err)
+		}
+		if resp != "" {
+			job.Response = new(types.ChatResponse)
+			if err := json.Unmarshal([]byte(resp), job.Response); err != nil {
+				return nil, fmt.Errorf("decoding response of job %s: %w", job.ID, err)
+			}
+		}
+		job.CreatedAt = unixTime(created)
+		job.StartedAt = unixTime(started)
+		job.FinishedAt = unixTime(finished)
+		out = append(out, &job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading jobs: %w", err)
+	}
+	return out, nil
+}
+
+// jobArgs returns a job's column values, ending with its ID
+func jobArgs(job *Job) ([]any, error) {
+	req, err := json.Marshal(job.Request)
+	if err != nil {
+		return nil, fmt.Errorf("encoding request: %w", err)
+	}
+	var resp []byte
+	if job.Response != nil {
+		if resp, err = json.Marshal(job.Response); err != nil {
+			return nil, fmt.Errorf("encoding response: %w", err)
+		}
+	}
+	return []any{
+		string(job.Status), string(req), string(resp), job.Error,
+		unixNano(job.CreatedAt), unixNano(job.StartedAt), unixNano(job.FinishedAt),
+		job.ID,
+	}, nil
+}
+
+// unixNano stores the zero time as 0
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func unixTime(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// query rewrites "?" placeholders to "$1", "$2", ... for Postgres
+func (s *SQLStore) query(q string) string {
+	if !s.postgres {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
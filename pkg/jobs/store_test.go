@@ -0,0 +1,45 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSQLStore_Query(t *testing.T) {
+	const q = `UPDATE jobs SET status = ? WHERE id = ?`
+
+	tests := []struct {
+		name  string
+		store *SQLStore
+		want  string
+	}{
+		{"sqlite", NewSQLiteStore(nil, "jobs"), q},
+		{"postgres", NewPostgresStore(nil, "jobs"), `UPDATE jobs SET status = $1 WHERE id = $2`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.store.query(q); got != tt.want {
+				t.Errorf("query() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJobArgs(t *testing.T) {
+	created := time.Unix(0, 1700000000000000000)
+	args, err := jobArgs(&Job{ID: "job-1", Status: StatusQueued, Request: request("hi"), CreatedAt: created})
+	if err != nil {
+		t.Fatalf("jobArgs() error = %v", err)
+	}
+
+	if args[0] != "queued" || args[2] != "" || args[4] != created.UnixNano() || args[5] != int64(0) || args[7] != "job-1" {
+		t.Errorf("jobArgs() = %v", args)
+	}
+	if got := unixTime(args[4].(int64)); !got.Equal(created) {
+		t.Errorf("unixTime() = %v, want %v", got, created)
+	}
+	if !unixTime(0).IsZero() {
+		t.Error("unixTime(0) is not the zero time")
+	}
+}
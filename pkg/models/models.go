@@ -0,0 +1,282 @@
+// Package models is a registry of known models and their limits: context
+// window, output tokens, capabilities, input modalities, knowledge cutoff
+// and deprecation status. Conversation truncation and routing read it, and
+// applications can register their own models.
+//
+//	m, ok := models.Lookup("gpt-4o-2024-08-06")
+//	if ok && m.Status() == models.StatusDeprecated {
+//		log.Printf("%s retires on %s, use %s", m.Name, m.RetiresAt, m.Successor)
+//	}
+package models
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Capability is a feature a model may support
+type Capability string
+
+const (
+	CapabilityStreaming Capability = "streaming"
+	CapabilityTools     Capability = "tools"
+	CapabilityVision    Capability = "vision"
+)
+
+// Modality is a kind of input a model accepts
+type Modality string
+
+const (
+	ModalityText  Modality = "text"
+	ModalityImage Modality = "image"
+	ModalityAudio Modality = "audio"
+)
+
+// Status is a model's place in its provider's lifecycle
+type Status int
+
+const (
+	// StatusActive models are served with no retirement announced
+	StatusActive Status = iota
+	// StatusDeprecated models are still served but will be retired
+	StatusDeprecated
+	// StatusRetired models are no longer served
+	StatusRetired
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusActive:
+		return "active"
+	case StatusDeprecated:
+		return "deprecated"
+	case StatusRetired:
+		return "retired"
+	default:
+		return "unknown"
+	}
+}
+
+// Model describes a known model's limits
+type Model struct {
+	Name            string
+	Provider        string
+	ContextWindow   int // Maximum prompt plus completion tokens
+	MaxOutputTokens int // Maximum completion tokens per request
+	Capabilities    []Capability
+	Modalities      []Modality // Kinds of input accepted; text if empty
+
+	// KnowledgeCutoff is the month the training data ends, zero if unknown
+	KnowledgeCutoff time.Time
+
+	// RetiresAt is when the provider stops serving the model, zero if no
+	// retirement has been announced
+	RetiresAt time.Time
+
+	// Successor is the model the provider recommends instead, if any
+	Successor string
+}
+
+// Supports reports whether the model has every given capability
+func (m Model) Supports(caps ...Capability) bool {
+	for _, want := range caps {
+		found := false
+		for _, c := range m.Capabilities {
+			if c == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Accepts reports whether the model takes input of the given modality
+func (m Model) Accepts(modality Modality) bool {
+	if len(m.Modalities) == 0 {
+		return modality == ModalityText
+	}
+	for _, have := range m.Modalities {
+		if have == modality {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusAt returns the model's lifecycle status at time t
+func (m Model) StatusAt(t time.Time) Status {
+	switch {
+	case m.RetiresAt.IsZero():
+		return StatusActive
+	case t.Before(m.RetiresAt):
+		return StatusDeprecated
+	default:
+		return StatusRetired
+	}
+}
+
+// Status returns the model's lifecycle status now
+func (m Model) Status() Status {
+	return m.StatusAt(time.Now())
+}
+
+func month(year int, m time.Month) time.Time {
+	return time.Date(year, m, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func day(year int, m time.Month, d int) time.Time {
+	return time.Date(year, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+var (
+	textOnly  = []Capability{CapabilityStreaming}
+	withTools = []Capability{CapabilityStreaming, CapabilityTools}
+	full      = []Capability{CapabilityStreaming, CapabilityTools, CapabilityVision}
+
+	text      = []Modality{ModalityText}
+	textImage = []Modality{ModalityText, ModalityImage}
+)
+
+var (
+	mu sync.RWMutex
+
+	// catalog lists the models the library knows about, keyed by name
+	catalog = map[string]Model{
+		"gpt-4": {
+			Name: "gpt-4", Provider: "openai", ContextWindow: 8192, MaxOutputTokens: 8192,
+			Capabilities: withTools, Modalities: text, KnowledgeCutoff: month(2021, time.September),
+		},
+		"gpt-4-32k": {
+			Name: "gpt-4-32k", Provider: "openai", ContextWindow: 32768, MaxOutputTokens: 32768,
+			Capabilities: withTools, Modalities: text, KnowledgeCutoff: month(2021, time.September),
+			RetiresAt: day(2025, time.June, 6), Successor: "gpt-4o",
+		},
+		"gpt-4-turbo": {
+			Name: "gpt-4-turbo", Provider: "openai", ContextWindow: 128000, MaxOutputTokens: 4096,
+			Capabilities: full, Modalities: textImage, KnowledgeCutoff: month(2023, time.December),
+		},
+		"gpt-4o": {
+			Name: "gpt-4o", Provider: "openai", ContextWindow: 128000, MaxOutputTokens: 16384,
+			Capabilities: full, Modalities: textImage, KnowledgeCutoff: month(2023, time.October),
+		},
+		"gpt-4o-mini": {
+			Name: "gpt-4o-mini", Provider: "openai", ContextWindow: 128000, MaxOutputTokens: 16384,
+			Capabilities: full, Modalities: textImage, KnowledgeCutoff: month(2023, time.October),
+		},
+		"gpt-3.5-turbo": {
+			Name: "gpt-3.5-turbo", Provider: "openai", ContextWindow: 16385, MaxOutputTokens: 4096,
+			Capabilities: withTools, Modalities: text, KnowledgeCutoff: month(2021, time.September),
+		},
+
+		"claude-2": {
+			Name: "claude-2", Provider: "anthropic", ContextWindow: 100000, MaxOutputTokens: 4096,
+			Capabilities: textOnly, Modalities: text, KnowledgeCutoff: month(2023, time.January),
+			RetiresAt: day(2025, time.July, 21), Successor: "claude-sonnet-4-20250514",
+		},
+		"claude-2.1": {
+			Name: "claude-2.1", Provider: "anthropic", ContextWindow: 200000, MaxOutputTokens: 4096,
+			Capabilities: textOnly, Modalities: text, KnowledgeCutoff: month(2023, time.January),
+			RetiresAt: day(2025, time.July, 21), Successor: "claude-sonnet-4-20250514",
+		},
+		"claude-instant": {
+			Name: "claude-instant", Provider: "anthropic", ContextWindow: 100000, MaxOutputTokens: 4096,
+			Capabilities: textOnly, Modalities: text, KnowledgeCutoff: month(2023, time.January),
+			RetiresAt: day(2024, time.November, 6), Successor: "claude-3-5-haiku-20241022",
+		},
+		"claude-3-opus-20240229": {
+			Name: "claude-3-opus-20240229", Provider: "anthropic", ContextWindow: 200000, MaxOutputTokens: 4096,
+			Capabilities: full, Modalities: textImage, KnowledgeCutoff: month(2023, time.August),
+			RetiresAt: day(2026, time.January, 5), Successor: "claude-opus-4-20250514",
+		},
+		"claude-3-sonnet-20240229": {
+			Name: "claude-3-sonnet-20240229", Provider: "anthropic", ContextWindow: 200000, MaxOutputTokens: 4096,
+			Capabilities: full, Modalities: textImage, KnowledgeCutoff: month(2023, time.August),
+			RetiresAt: day(2025, time.July, 21), Successor: "claude-sonnet-4-20250514",
+		},
+		"claude-3-haiku-20240307": {
+			Name: "claude-3-haiku-20240307", Provider: "anthropic", ContextWindow: 200000, MaxOutputTokens: 4096,
+			Capabilities: full, Modalities: textImage, KnowledgeCutoff: month(2023, time.August),
+		},
+		"claude-3-5-sonnet-20240620": {
+			Name: "claude-3-5-sonnet-20240620", Provider: "anthropic", ContextWindow: 200000, MaxOutputTokens: 8192,
+			Capabilities: full, Modalities: textImage, KnowledgeCutoff: month(2024, time.April),
+			RetiresAt: day(2025, time.October, 22), Successor: "claude-sonnet-4-20250514",
+		},
+		"claude-3-5-sonnet-20241022": {
+			Name: "claude-3-5-sonnet-20241022", Provider: "anthropic", ContextWindow: 200000, MaxOutputTokens: 8192,
+			Capabilities: full, Modalities: textImage, KnowledgeCutoff: month(2024, time.April),
+			RetiresAt: day(2025, time.October, 22), Successor: "claude-sonnet-4-20250514",
+		},
+		"claude-3-5-haiku-20241022": {
+			Name: "claude-3-5-haiku-20241022", Provider: "anthropic", ContextWindow: 200000, MaxOutputTokens: 8192,
+			Capabilities: withTools, Modalities: text, KnowledgeCutoff: month(2024, time.July),
+		},
+		"claude-sonnet-4-20250514": {
+			Name: "claude-sonnet-4-20250514", Provider: "anthropic", ContextWindow: 200000, MaxOutputTokens: 64000,
+			Capabilities: full, Modalities: textImage, KnowledgeCutoff: month(2025, time.March),
+		},
+		"claude-opus-4-20250514": {
+			Name: "claude-opus-4-20250514", Provider: "anthropic", ContextWindow: 200000, MaxOutputTokens: 32000,
+			Capabilities: full, Modalities: textImage, KnowledgeCutoff: month(2025, time.March),
+		},
+	}
+)
+
+// Lookup returns metadata for a model. Dated or suffixed variants such as
+// "gpt-4o-2024-08-06" resolve to the longest matching known prefix.
+func Lookup(name string) (Model, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if m, ok := catalog[name]; ok {
+		return m, true
+	}
+
+	var best Model
+	found := false
+	for prefix, m := range catalog {
+		if strings.HasPrefix(name, prefix+"-") && len(prefix) > len(best.Name) {
+			best = m
+			found = true
+		}
+	}
+	if found {
+		best.Name = name
+	}
+	return best, found
+}
+
+// Register adds a model to the registry, or replaces the known model of the
+// same name, for example to describe a fine-tuned or self-hosted model
+func Register(m Model) {
+	mu.Lock()
+	defer mu.Unlock()
+	catalog[m.Name] = m
+}
+
+// All returns every registered model, sorted by provider and name. An empty
+// provider returns the models of all providers.
+func All(provider string) []Model {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var out []Model
+	for _, m := range catalog {
+		if provider == "" || m.Provider == provider {
+			out = append(out, m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Provider != out[j].Provider {
+			return out[i].Provider < out[j].Provider
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
@@ -0,0 +1,149 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name         string
+		wantFound    bool
+		wantProvider string
+		wantContext  int
+	}{
+		{"gpt-4", true, "openai", 8192},
+		{"gpt-4o-2024-08-06", true, "openai", 128000},
+		{"gpt-4o-mini-2024-07-18", true, "openai", 128000},
+		{"claude-2.1", true, "anthropic", 200000},
+		{"unknown-model", false, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, ok := Lookup(tt.name)
+			if ok != tt.wantFound {
+				t.Fatalf("Lookup() found = %v, want %v", ok, tt.wantFound)
+			}
+			if m.Provider != tt.wantProvider || m.ContextWindow != tt.wantContext {
+				t.Errorf("Lookup() = %+v", m)
+			}
+			if ok && m.Name != tt.name {
+				t.Errorf("Lookup() name = %q, want %q", m.Name, tt.name)
+			}
+		})
+	}
+}
+
+func TestModel_Supports(t *testing.T) {
+	tests := []struct {
+		name string
+		caps []Capability
+		want bool
+	}{
+		{"gpt-4o", []Capability{CapabilityTools, CapabilityVision}, true},
+		{"gpt-3.5-turbo", []Capability{CapabilityTools}, true},
+		{"gpt-3.5-turbo", []Capability{CapabilityTools, CapabilityVision}, false},
+		{"claude-2.1", []Capability{CapabilityTools}, false},
+		{"claude-2.1", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := Lookup(tt.name)
+			if got := m.Supports(tt.caps...); got != tt.want {
+				t.Errorf("Supports(%v) = %v, want %v", tt.caps, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModel_Accepts(t *testing.T) {
+	tests := []struct {
+		name     string
+		model    Model
+		modality Modality
+		want     bool
+	}{
+		{"image model", mustLookup(t, "gpt-4o"), ModalityImage, true},
+		{"text model image", mustLookup(t, "gpt-3.5-turbo"), ModalityImage, false},
+		{"text model text", mustLookup(t, "gpt-3.5-turbo"), ModalityText, true},
+		{"unset modalities text", Model{Name: "custom"}, ModalityText, true},
+		{"unset modalities audio", Model{Name: "custom"}, ModalityAudio, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.model.Accepts(tt.modality); got != tt.want {
+				t.Errorf("Accepts(%s) = %v, want %v", tt.modality, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModel_StatusAt(t *testing.T) {
+	retires := time.Date(2025, time.July, 21, 0, 0, 0, 0, time.UTC)
+	m := Model{Name: "old", RetiresAt: retires}
+
+	tests := []struct {
+		name  string
+		model Model
+		at    time.Time
+		want  Status
+	}{
+		{"no retirement", Model{Name: "new"}, retires, StatusActive},
+		{"before retirement", m, retires.Add(-time.Hour), StatusDeprecated},
+		{"at retirement", m, retires, StatusRetired},
+		{"after retirement", m, retires.AddDate(1, 0, 0), StatusRetired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.model.StatusAt(tt.at); got != tt.want {
+				t.Errorf("StatusAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCatalog_Consistent(t *testing.T) {
+	for _, m := range All("") {
+		if m.KnowledgeCutoff.IsZero() {
+			t.Errorf("%s has no knowledge cutoff", m.Name)
+		}
+		if m.Successor == "" {
+			continue
+		}
+		if m.RetiresAt.IsZero() {
+			t.Errorf("%s has a successor but no retirement date", m.Name)
+		}
+		if s, ok := Lookup(m.Successor); !ok || s.Provider != m.Provider || !s.RetiresAt.IsZero() {
+			t.Errorf("%s successor %q is not a current %s model", m.Name, m.Successor, m.Provider)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	custom := Model{Name: "acme-large", Provider: "acme", ContextWindow: 32000, MaxOutputTokens: 4000}
+	Register(custom)
+
+	if got, ok := Lookup("acme-large-v2"); !ok || got.ContextWindow != 32000 {
+		t.Errorf("Lookup() of registered model variant = %+v, %v", got, ok)
+	}
+	all := All("acme")
+	if len(all) != 1 || all[0].Name != "acme-large" {
+		t.Errorf("All(acme) = %+v, want the registered model", all)
+	}
+	if len(All("")) <= len(All("openai")) {
+		t.Error("All() did not return every provider's models")
+	}
+}
+
+func mustLookup(t *testing.T, name string) Model {
+	t.Helper()
+	m, ok := Lookup(name)
+	if !ok {
+		t.Fatalf("Lookup(%q) not found", name)
+	}
+	return m
+}
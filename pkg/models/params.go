@@ -0,0 +1,20 @@
+package models
+
+// ParameterLimits are the ranges of sampling parameters a provider accepts
+type ParameterLimits struct {
+	MaxTemperature   float32 // Temperature ranges from zero to this
+	MaxStopSequences int     // Most stop sequences per request; zero means no limit
+	Penalties        bool    // Presence and frequency penalties, from -2 to 2, are accepted
+}
+
+var providerLimits = map[string]ParameterLimits{
+	"openai":    {MaxTemperature: 2, MaxStopSequences: 4, Penalties: true},
+	"anthropic": {MaxTemperature: 1},
+}
+
+// LimitsFor returns the parameter limits of a provider, and whether they
+// are known
+func LimitsFor(provider string) (ParameterLimits, bool) {
+	l, ok := providerLimits[provider]
+	return l, ok
+}
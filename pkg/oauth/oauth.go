@@ -0,0 +1,215 @@
+// Package oauth supplies bearer tokens for providers and gateways that
+// authenticate with OAuth2 or Azure AD instead of static API keys. Tokens
+// are cached and refreshed shortly before they expire.
+//
+//	src := oauth.AzureAD(tenantID, clientID, clientSecret)
+//	cfg, err := config.NewConfig("", config.WithTokenSource(src))
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+const (
+	// defaultEarlyExpiry is how long before expiry a cached token is
+	// refreshed, so requests never carry a token about to lapse
+	defaultEarlyExpiry = time.Minute
+
+	// AzureCognitiveServicesScope is the scope of Azure OpenAI tokens
+	AzureCognitiveServicesScope = "https://cognitiveservices.azure.com/.default"
+)
+
+// Token is a bearer token
+type Token struct {
+	AccessToken string
+	// Expiry is when the token lapses. Zero means it never does.
+	Expiry time.Time
+}
+
+// valid reports whether the token can be used until at least t
+func (t *Token) valid(at time.Time) bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || at.Before(t.Expiry))
+}
+
+// TokenSource supplies bearer tokens. Implementations must be safe for
+// concurrent use.
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// TokenSourceFunc adapts a function to a TokenSource
+type TokenSourceFunc func(ctx context.Context) (*Token, error)
+
+// Token calls f
+func (f TokenSourceFunc) Token(ctx context.Context) (*Token, error) {
+	return f(ctx)
+}
+
+// StaticToken returns a source that always supplies token
+func StaticToken(token string) TokenSource {
+	return TokenSourceFunc(func(context.Context) (*Token, error) {
+		return &Token{AccessToken: token}, nil
+	})
+}
+
+// reuseSource caches its source's token until shortly before expiry
+type reuseSource struct {
+	src         TokenSource
+	earlyExpiry time.Duration
+	now         func() time.Time
+
+	mu    sync.Mutex
+	token *Token
+}
+
+// ReuseTokenSource caches src's tokens, fetching a new one when the cached
+// token is within earlyExpiry of expiring. Concurrent callers share one
+// fetch. A zero earlyExpiry defaults to one minute.
+func ReuseTokenSource(src TokenSource, earlyExpiry time.Duration) TokenSource {
+	if earlyExpiry <= 0 {
+		earlyExpiry = defaultEarlyExpiry
+	}
+	return &reuseSource{src: src, earlyExpiry: earlyExpiry, now: time.Now}
+}
+
+func (s *reuseSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.valid(s.now().Add(s.earlyExpiry)) {
+		return s.token, nil
+	}
+
+	token, err := s.src.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.token = token
+	return token, nil
+}
+
+// clientCredentials fetches tokens with the OAuth2 client credentials grant
+type clientCredentials struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	client       *http.Client
+	now          func() time.Time
+}
+
+// ClientCredentials returns a cached source of tokens from the OAuth2
+// client credentials grant at tokenURL
+func ClientCredentials(tokenURL, clientID, clientSecret string, scopes ...string) TokenSource {
+	return ReuseTokenSource(&clientCredentials{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+		client:       &http.Client{Timeout: 30 * time.Second},
+		now:          time.Now,
+	}, 0)
+}
+
+// AzureAD returns a cached source of Azure AD tokens for Azure OpenAI,
+// using a service principal's client credentials
+func AzureAD(tenantID, clientID, clientSecret string) TokenSource {
+	tokenURL := "https://login.microsoftonline.com/" + url.PathEscape(tenantID) + "/oauth2/v2.0/token"
+	return ClientCredentials(tokenURL, clientID, clientSecret, AzureCognitiveServicesScope)
+}
+
+// tokenResponse is the JSON body of a token endpoint's response
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (c *clientCredentials) Token(ctx context.Context) (*Token, error) {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.clientID},
+		"client_secret": {c.clientSecret},
+	}
+	if len(c.scopes) > 0 {
+		form.Set("scope", strings.Join(c.scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	start := c.now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil && resp.StatusCode < 400 {
+		return nil, fmt.Errorf("decoding token response: %w", err)
+	}
+	if resp.StatusCode >= 400 || body.AccessToken == "" {
+		reason := body.Error
+		if body.ErrorDescription != "" {
+			reason += ": " + body.ErrorDescription
+		}
+		if reason == "" {
+			reason = resp.Status
+		}
+		// The response never echoes the client secret, so the reason is
+		// safe to return
+		return nil, fmt.Errorf("%w: token endpoint: %s", types.ErrInvalidCredentials, reason)
+	}
+
+	token := &Token{AccessToken: body.AccessToken}
+	if body.ExpiresIn > 0 {
+		token.Expiry = start.Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// Transport returns a RoundTripper that authenticates each request with a
+// bearer token from src, replacing any API key header. A nil next uses
+// http.DefaultTransport.
+func Transport(src TokenSource, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{src: src, next: next}
+}
+
+type transport struct {
+	src  TokenSource
+	next http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.src.Token(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Del("X-Api-Key")
+	req.Header.Del("Api-Key")
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return t.next.RoundTrip(req)
+}
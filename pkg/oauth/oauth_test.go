@@ -0,0 +1,145 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestReuseTokenSource(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var fetches int
+	src := ReuseTokenSource(TokenSourceFunc(func(context.Context) (*Token, error) {
+		fetches++
+		return &Token{AccessToken: "token", Expiry: now.Add(10 * time.Minute)}, nil
+	}), time.Minute).(*reuseSource)
+	src.now = func() time.Time { return now }
+
+	tests := []struct {
+		name        string
+		advance     time.Duration
+		wantFetches int
+	}{
+		{name: "first fetch", advance: 0, wantFetches: 1},
+		{name: "cached", advance: 5 * time.Minute, wantFetches: 1},
+		{name: "refreshed before expiry", advance: 4*time.Minute + 30*time.Second, wantFetches: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+			token, err := src.Token(context.Background())
+			if err != nil {
+				t.Fatalf("Token() error = %v", err)
+			}
+			if token.AccessToken != "token" {
+				t.Errorf("Token() = %q, want token", token.AccessToken)
+			}
+			if fetches != tt.wantFetches {
+				t.Errorf("fetches = %d, want %d", fetches, tt.wantFetches)
+			}
+		})
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      map[string]any
+		wantToken string
+		wantErr   error
+	}{
+		{
+			name:      "token issued",
+			status:    http.StatusOK,
+			body:      map[string]any{"access_token": "at-123", "token_type": "Bearer", "expires_in": 3600},
+			wantToken: "at-123",
+		},
+		{
+			name:    "invalid client",
+			status:  http.StatusUnauthorized,
+			body:    map[string]any{"error": "invalid_client", "error_description": "bad secret"},
+			wantErr: types.ErrInvalidCredentials,
+		},
+		{
+			name:    "no token in response",
+			status:  http.StatusOK,
+			body:    map[string]any{"token_type": "Bearer"},
+			wantErr: types.ErrInvalidCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				if r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("client_id") != "id" ||
+					r.Form.Get("client_secret") != "secret" || r.Form.Get("scope") != "a b" {
+					t.Errorf("token request form = %v", r.Form)
+				}
+				w.WriteHeader(tt.status)
+				json.NewEncoder(w).Encode(tt.body)
+			}))
+			defer server.Close()
+
+			token, err := ClientCredentials(server.URL, "id", "secret", "a", "b").Token(context.Background())
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Token() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Token() error = %v", err)
+			}
+			if token.AccessToken != tt.wantToken || token.Expiry.IsZero() {
+				t.Errorf("Token() = %+v, want %s with an expiry", token, tt.wantToken)
+			}
+		})
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var gotAuth, gotKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth, gotKey = r.Header.Get("Authorization"), r.Header.Get("X-Api-Key")
+	}))
+	defer server.Close()
+
+	var fetches atomic.Int32
+	src := TokenSourceFunc(func(context.Context) (*Token, error) {
+		fetches.Add(1)
+		return &Token{AccessToken: "at-123"}, nil
+	})
+	client := &http.Client{Transport: Transport(src, nil)}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("X-Api-Key", "sk-static")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	resp.Body.Close()
+
+	if gotAuth != "Bearer at-123" || gotKey != "" {
+		t.Errorf("headers = Authorization %q, X-Api-Key %q, want only the bearer token", gotAuth, gotKey)
+	}
+	if req.Header.Get("X-Api-Key") != "sk-static" {
+		t.Error("Transport modified the caller's request")
+	}
+
+	failing := &http.Client{Transport: Transport(TokenSourceFunc(func(context.Context) (*Token, error) {
+		return nil, types.ErrInvalidCredentials
+	}), nil)}
+	if _, err := failing.Get(server.URL); !errors.Is(err, types.ErrInvalidCredentials) {
+		t.Errorf("Get() error = %v, want %v", err, types.ErrInvalidCredentials)
+	}
+}
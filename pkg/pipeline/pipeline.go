@@ -0,0 +1,385 @@
+// Package pipeline processes large batches of chat requests with a bounded
+// pool of workers, retrying failed items and backing off together when the
+// provider rate limits.
+//
+//	p := pipeline.New(c, pipeline.WithConcurrency(8), pipeline.WithRateLimit(500, 0))
+//	results := p.Run(ctx, requests)
+//	for _, r := range results {
+//		if r.Err != nil {
+//			log.Printf("request %d failed after %d attempts: %v", r.Index, r.Attempts, r.Err)
+//		}
+//	}
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ksred/llm/internal/ratelimit"
+	"github.com/ksred/llm/internal/tokenizer"
+	"github.com/ksred/llm/pkg/types"
+	"github.com/ksred/llm/pkg/webhook"
+)
+
+const (
+	defaultConcurrency = 4
+	defaultMaxAttempts = 3
+	defaultBackoff     = time.Second
+	defaultMaxBackoff  = 30 * time.Second
+)
+
+// Chatter sends a chat request. *client.Client satisfies this interface.
+type Chatter interface {
+	Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error)
+}
+
+// Result is the outcome of one request
+type Result struct {
+	// Index is the request's position in the input, counting from zero
+	Index    int
+	Request  *types.ChatRequest
+	Response *types.ChatResponse
+	Err      error
+	// Attempts is the number of times the request was sent
+	Attempts int
+	// Duration covers all attempts, including backoff
+	Duration time.Duration
+}
+
+// Progress is a snapshot of a batch, reported after each request finishes
+type Progress struct {
+	// Total is the number of requests in the batch, or the number received
+	// so far when streaming
+	Total     int
+	Done      int
+	Failed    int
+	Elapsed   time.Duration
+	LastError error
+}
+
+// Pipeline processes chat requests concurrently
+type Pipeline struct {
+	chatter     Chatter
+	concurrency int
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	retryIf     func(error) bool
+	limiter     *ratelimit.Limiter
+	onProgress  func(Progress)
+	webhook     *webhook.Sender
+}
+
+// Option configures a Pipeline
+type Option func(*Pipeline)
+
+// WithConcurrency sets the number of requests in flight at once. Defaults
+// to 4.
+func WithConcurrency(n int) Option {
+	return func(p *Pipeline) {
+		p.concurrency = n
+	}
+}
+
+// WithRetry sets the attempts per request and the exponential backoff
+// between them. Defaults to 3 attempts starting at one second, capped at 30
+// seconds.
+func WithRetry(maxAttempts int, backoff, maxBackoff time.Duration) Option {
+	return func(p *Pipeline) {
+		p.maxAttempts = maxAttempts
+		p.backoff = backoff
+		p.maxBackoff = maxBackoff
+	}
+}
+
+// WithRetryIf decides which errors are retried. By default rate limits,
+// overloads, timeouts and provider errors are.
+func WithRetryIf(fn func(error) bool) Option {
+	return func(p *Pipeline) {
+		p.retryIf = fn
+	}
+}
+
+// WithRateLimit paces the batch to requestsPerMinute requests and
+// tokensPerMinute estimated tokens. A zero rate disables that limit.
+func WithRateLimit(requestsPerMinute, tokensPerMinute int) Option {
+	return func(p *Pipeline) {
+		p.limiter = ratelimit.New(requestsPerMinute, tokensPerMinute)
+	}
+}
+
+// WithProgress calls fn after each request finishes. Calls are serialized.
+func WithProgress(fn func(Progress)) Option {
+	return func(p *Pipeline) {
+		p.onProgress = fn
+	}
+}
+
+// WithWebhook delivers a "batch.completed" event, whose data is a
+// BatchSummary, to sender when each Run or Stream finishes
+func WithWebhook(sender *webhook.Sender) Option {
+	return func(p *Pipeline) {
+		p.webhook = sender
+	}
+}
+
+// BatchSummary is the data of a "batch.completed" webhook event
+type BatchSummary struct {
+	Total         int   `json:"total"`
+	Succeeded     int   `json:"succeeded"`
+	Failed        int   `json:"failed"`
+	ElapsedMillis int64 `json:"elapsed_ms"`
+}
+
+// New creates a pipeline that sends requests with chatter
+func New(chatter Chatter, opts ...Option) *Pipeline {
+	p := &Pipeline{
+		chatter:     chatter,
+		concurrency: defaultConcurrency,
+		maxAttempts: defaultMaxAttempts,
+		backoff:     defaultBackoff,
+		maxBackoff:  defaultMaxBackoff,
+		retryIf:     Retryable,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.concurrency < 1 {
+		p.concurrency = 1
+	}
+	if p.maxAttempts < 1 {
+		p.maxAttempts = 1
+	}
+	return p
+}
+
+// Retryable reports whether err is a transient failure worth retrying, as
+// types.IsRetryable does
+func Retryable(err error) bool {
+	return types.IsRetryable(err)
+}
+
+// job is a request and its position in the input
+type job struct {
+	index int
+	req   *types.ChatRequest
+}
+
+// Run processes reqs and returns their results in input order. Requests
+// not started before ctx is done fail with its error.
+func (p *Pipeline) Run(ctx context.Context, reqs []*types.ChatRequest) []Result {
+	jobs := make(chan job)
+	go func() {
+		defer close(jobs)
+		for i, req := range reqs {
+			select {
+			case jobs <- job{index: i, req: req}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	results := make([]Result, len(reqs))
+	seen := make([]bool, len(reqs))
+	for r := range p.process(ctx, jobs, len(reqs)) {
+		results[r.Index] = r
+		seen[r.Index] = true
+	}
+	for i := range results {
+		if !seen[i] {
+			results[i] = Result{Index: i, Request: reqs[i], Err: ctx.Err()}
+		}
+	}
+	return results
+}
+
+// Stream processes requests as they arrive on reqs and sends each result as
+// soon as it is ready, in completion order. The result channel is closed
+// once reqs is closed and drained, or ctx is done.
+func (p *Pipeline) Stream(ctx context.Context, reqs <-chan *types.ChatRequest) <-chan Result {
+	jobs := make(chan job)
+	go func() {
+		defer close(jobs)
+		for i := 0; ; i++ {
+			select {
+			case req, ok := <-reqs:
+				if !ok {
+					return
+				}
+				select {
+				case jobs <- job{index: i, req: req}:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return p.process(ctx, jobs, 0)
+}
+
+// process runs the workers over jobs. total is the batch size, or zero to
+// count jobs as they arrive.
+func (p *Pipeline) process(ctx context.Context, jobs <-chan job, total int) <-chan Result {
+	out := make(chan Result)
+	b := &batch{pipeline: p, start: time.Now(), progress: Progress{Total: total}, counting: total == 0}
+
+	var wg sync.WaitGroup
+	for i := 0; i < p.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				b.received()
+				r := p.do(ctx, b, j)
+				b.finished(r)
+				select {
+				case out <- r:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+		if p.webhook != nil {
+			p.webhook.Deliver(webhook.NewEvent("batch.completed", b.summary()))
+		}
+	}()
+	return out
+}
+
+// do sends one request, retrying transient failures
+func (p *Pipeline) do(ctx context.Context, b *batch, j job) Result {
+	r := Result{Index: j.index, Request: j.req}
+	start := time.Now()
+	defer func() { r.Duration = time.Since(start) }()
+
+	delay := p.backoff
+	for {
+		if err := b.waitPause(ctx); err != nil {
+			r.Err = err
+			return r
+		}
+
+		reserved := tokenizer.EstimateChat(j.req)
+		if p.limiter != nil {
+			if err := p.limiter.Wait(ctx, reserved); err != nil {
+				r.Err = err
+				return r
+			}
+		}
+
+		r.Attempts++
+		r.Response, r.Err = p.chatter.Chat(ctx, j.req)
+		// Without reported usage, such as after a failed attempt that may
+		// still have used tokens, the reservation stands
+		if p.limiter != nil && r.Response != nil && r.Response.Usage.Total() > 0 {
+			p.limiter.Settle(reserved, r.Response.Usage.Total())
+		}
+
+		if r.Err == nil || r.Attempts >= p.maxAttempts || !p.retryIf(r.Err) || ctx.Err() != nil {
+			return r
+		}
+
+		// A rate limit holds back every worker, not just this one
+		if errors.Is(r.Err, types.ErrRateLimitExceeded) {
+			b.pause(delay)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return r
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if p.maxBackoff > 0 && delay > p.maxBackoff {
+			delay = p.maxBackoff
+		}
+	}
+}
+
+// batch is the state shared by the workers of one Run or Stream
+type batch struct {
+	pipeline *Pipeline
+	start    time.Time
+
+	mu         sync.Mutex
+	progress   Progress
+	counting   bool
+	pauseUntil time.Time
+}
+
+// received counts a job towards the total when streaming
+func (b *batch) received() {
+	if !b.counting {
+		return
+	}
+	b.mu.Lock()
+	b.progress.Total++
+	b.mu.Unlock()
+}
+
+// finished records a result and reports progress
+func (b *batch) finished(r Result) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.progress.Done++
+	if r.Err != nil {
+		b.progress.Failed++
+		b.progress.LastError = r.Err
+	}
+	b.progress.Elapsed = time.Since(b.start)
+	if b.pipeline.onProgress != nil {
+		b.pipeline.onProgress(b.progress)
+	}
+}
+
+// summary returns the batch's outcome for webhooks
+func (b *batch) summary() BatchSummary {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BatchSummary{
+		Total:         b.progress.Total,
+		Succeeded:     b.progress.Done - b.progress.Failed,
+		Failed:        b.progress.Failed,
+		ElapsedMillis: time.Since(b.start).Milliseconds(),
+	}
+}
+
+// pause holds back new attempts from every worker for d
+func (b *batch) pause(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if until := time.Now().Add(d); until.After(b.pauseUntil) {
+		b.pauseUntil = until
+	}
+}
+
+// waitPause blocks until any pause has passed, or ctx is done
+func (b *batch) waitPause(ctx context.Context) error {
+	b.mu.Lock()
+	wait := time.Until(b.pauseUntil)
+	b.mu.Unlock()
+	if wait <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
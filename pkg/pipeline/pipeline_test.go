@@ -0,0 +1,202 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+// fakeChatter echoes the request content, failing each request's first
+// failures attempts with err
+type fakeChatter struct {
+	failures int
+	err      error
+	delay    time.Duration
+	usage    types.Usage // defaults to 10 tokens
+
+	mu       sync.Mutex
+	attempts map[string]int
+	inFlight int32
+	peak     int32
+}
+
+func (f *fakeChatter) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	n := atomic.AddInt32(&f.inFlight, 1)
+	defer atomic.AddInt32(&f.inFlight, -1)
+	for {
+		peak := atomic.LoadInt32(&f.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(&f.peak, peak, n) {
+			break
+		}
+	}
+	time.Sleep(f.delay)
+
+	content := req.Messages[0].Content
+	f.mu.Lock()
+	if f.attempts == nil {
+		f.attempts = make(map[string]int)
+	}
+	f.attempts[content]++
+	attempt := f.attempts[content]
+	f.mu.Unlock()
+
+	if attempt <= f.failures {
+		return nil, f.err
+	}
+	usage := f.usage
+	if usage == (types.Usage{}) {
+		usage.TotalTokens = 10
+	}
+	return &types.ChatResponse{Response: types.Response{
+		Message: types.Message{Role: types.RoleAssistant, Content: "re: " + content},
+		Usage:   usage,
+	}}, nil
+}
+
+func requests(n int) []*types.ChatRequest {
+	reqs := make([]*types.ChatRequest, n)
+	for i := range reqs {
+		reqs[i] = types.NewChatRequest([]types.Message{{Role: types.RoleUser, Content: fmt.Sprintf("item %d", i)}})
+	}
+	return reqs
+}
+
+func TestPipeline_Run(t *testing.T) {
+	chatter := &fakeChatter{delay: time.Millisecond}
+	var last Progress
+	p := New(chatter, WithConcurrency(3), WithProgress(func(pr Progress) { last = pr }))
+
+	results := p.Run(context.Background(), requests(20))
+	if len(results) != 20 {
+		t.Fatalf("results = %d, want 20", len(results))
+	}
+	for i, r := range results {
+		if r.Err != nil || r.Index != i || r.Response.Message.Content != fmt.Sprintf("re: item %d", i) {
+			t.Errorf("result %d = %+v, want the response to item %d", i, r, i)
+		}
+	}
+	if peak := atomic.LoadInt32(&chatter.peak); peak > 3 {
+		t.Errorf("peak concurrency = %d, want at most 3", peak)
+	}
+	if last.Total != 20 || last.Done != 20 || last.Failed != 0 {
+		t.Errorf("final progress = %+v, want 20 of 20 done", last)
+	}
+}
+
+func TestPipeline_Retry(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		err          error
+		wantErr      error
+		wantAttempts int
+	}{
+		{"recovers", 2, types.ErrRateLimitExceeded, nil, 3},
+		{"exhausted", 5, types.ErrOverloaded, types.ErrOverloaded, 3},
+		{"not retried", 5, types.ErrInvalidRequest, types.ErrInvalidRequest, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chatter := &fakeChatter{failures: tt.failures, err: tt.err}
+			p := New(chatter, WithRetry(3, time.Millisecond, 5*time.Millisecond))
+
+			results := p.Run(context.Background(), requests(2))
+			for _, r := range results {
+				if !errors.Is(r.Err, tt.wantErr) || (tt.wantErr == nil && r.Err != nil) {
+					t.Errorf("result %d error = %v, want %v", r.Index, r.Err, tt.wantErr)
+				}
+				if r.Attempts != tt.wantAttempts {
+					t.Errorf("result %d attempts = %d, want %d", r.Index, r.Attempts, tt.wantAttempts)
+				}
+			}
+		})
+	}
+}
+
+func TestPipeline_RateLimit(t *testing.T) {
+	// The limiter allows a burst of 6000, then 100 requests a second
+	p := New(&fakeChatter{}, WithConcurrency(10), WithRateLimit(6000, 0))
+
+	start := time.Now()
+	results := p.Run(context.Background(), requests(6003))
+	if len(results) != 6003 {
+		t.Fatalf("results = %d, want 6003", len(results))
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("requests past the burst took %v in all, want them paced", elapsed)
+	}
+}
+
+func TestPipeline_RateLimitSettle(t *testing.T) {
+	tests := []struct {
+		name    string
+		chatter *fakeChatter
+	}{
+		// 100 tokens used, reported without a total as Anthropic does
+		{"usage without a total", &fakeChatter{usage: types.Usage{PromptTokens: 50, CompletionTokens: 50}}},
+		// A failed attempt keeps its reservation
+		{"failed attempt", &fakeChatter{failures: 1, err: types.ErrInvalidRequest}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(tt.chatter, WithRateLimit(0, 100))
+			p.Run(context.Background(), requests(1))
+
+			if err := p.limiter.Allow(100); err == nil {
+				t.Error("Allow(100) after the request = nil, want the tokens still charged")
+			}
+		})
+	}
+}
+
+func TestPipeline_Stream(t *testing.T) {
+	p := New(&fakeChatter{}, WithConcurrency(2))
+
+	in := make(chan *types.ChatRequest)
+	go func() {
+		defer close(in)
+		for _, req := range requests(5) {
+			in <- req
+		}
+	}()
+
+	seen := make(map[int]bool)
+	for r := range p.Stream(context.Background(), in) {
+		if r.Err != nil {
+			t.Errorf("result %d error = %v", r.Index, r.Err)
+		}
+		seen[r.Index] = true
+	}
+	if len(seen) != 5 {
+		t.Errorf("streamed %d distinct results, want 5", len(seen))
+	}
+}
+
+func TestPipeline_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var done int32
+	p := New(&fakeChatter{delay: 5 * time.Millisecond}, WithConcurrency(1), WithProgress(func(Progress) {
+		if atomic.AddInt32(&done, 1) == 2 {
+			cancel()
+		}
+	}))
+
+	results := p.Run(ctx, requests(10))
+	var cancelled int
+	for _, r := range results {
+		if errors.Is(r.Err, context.Canceled) {
+			cancelled++
+		}
+	}
+	if cancelled < 7 {
+		t.Errorf("cancelled results = %d, want the unstarted requests to fail", cancelled)
+	}
+}
@@ -0,0 +1,220 @@
+// Package rag answers questions from a document collection: it retrieves
+// the chunks nearest the question, puts them in the prompt and returns the
+// answer with the sources it cites.
+//
+//	chat := rag.New(c, rag.IndexRetriever{Index: idx, TopK: 4})
+//	answer, err := chat.Ask(ctx, "How long do refunds take?")
+//	for _, src := range answer.Cited {
+//		fmt.Println(src.N, src.ID)
+//	}
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/ksred/llm/pkg/types"
+	"github.com/ksred/llm/pkg/vectorstore"
+)
+
+// ErrNoSources is returned by Ask when nothing is retrieved and
+// WithRequireSources is set
+var ErrNoSources = errors.New("no sources found for question")
+
+// Chatter sends a chat request. *client.Client satisfies this interface.
+type Chatter interface {
+	Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error)
+}
+
+// Retriever finds the chunks relevant to a question, most relevant first
+type Retriever interface {
+	Retrieve(ctx context.Context, question string) ([]vectorstore.Match, error)
+}
+
+// IndexRetriever retrieves from a vector store index
+type IndexRetriever struct {
+	Index *vectorstore.Index
+	// TopK is the most chunks retrieved; zero means 4
+	TopK int
+	// Filter keeps only chunks whose metadata has all these values
+	Filter map[string]string
+	// MinScore drops chunks less similar to the question than this
+	MinScore float64
+}
+
+// Retrieve implements Retriever
+func (r IndexRetriever) Retrieve(ctx context.Context, question string) ([]vectorstore.Match, error) {
+	topK := r.TopK
+	if topK <= 0 {
+		topK = 4
+	}
+	matches, err := r.Index.Search(ctx, question, topK, r.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := matches[:0]
+	for _, m := range matches {
+		if m.Score >= r.MinScore {
+			kept = append(kept, m)
+		}
+	}
+	return kept, nil
+}
+
+// Source is a retrieved chunk, numbered as it appears in the prompt
+type Source struct {
+	// N is the number the model cites the source by, starting at 1
+	N        int
+	ID       string
+	Content  string
+	Metadata map[string]string
+	Score    float64
+}
+
+// Answer is the model's reply and the sources it was given
+type Answer struct {
+	Text string
+	// Sources are all the chunks put in the prompt
+	Sources []Source
+	// Cited are the sources the answer refers to by number
+	Cited    []Source
+	Response *types.ChatResponse
+}
+
+// PromptData is the data the prompt template is executed with
+type PromptData struct {
+	Question string
+	Sources  []Source
+}
+
+// DefaultTemplate numbers the sources and asks for an answer citing them
+var DefaultTemplate = template.Must(template.New("rag").Parse(`Answer the question using only the sources below. Cite each source you use by its number in square brackets, like [1]. If the sources do not contain the answer, say that you don't know.
+
+Sources:
+{{range .Sources}}[{{.N}}] {{.Content}}
+{{end}}
+Question: {{.Question}}`))
+
+// AugmentedChat answers questions with retrieved context
+type AugmentedChat struct {
+	chatter        Chatter
+	retriever      Retriever
+	template       *template.Template
+	system         string
+	requireSources bool
+}
+
+// Option configures an AugmentedChat
+type Option func(*AugmentedChat)
+
+// WithTemplate replaces the prompt template. It is executed with a
+// PromptData.
+func WithTemplate(tmpl *template.Template) Option {
+	return func(a *AugmentedChat) {
+		a.template = tmpl
+	}
+}
+
+// WithSystemPrompt sends a system message before the prompt
+func WithSystemPrompt(content string) Option {
+	return func(a *AugmentedChat) {
+		a.system = content
+	}
+}
+
+// WithRequireSources makes Ask return ErrNoSources instead of asking the
+// model when nothing is retrieved
+func WithRequireSources() Option {
+	return func(a *AugmentedChat) {
+		a.requireSources = true
+	}
+}
+
+// New creates an AugmentedChat that retrieves with retriever and answers
+// with chatter
+func New(chatter Chatter, retriever Retriever, opts ...Option) *AugmentedChat {
+	a := &AugmentedChat{
+		chatter:   chatter,
+		retriever: retriever,
+		template:  DefaultTemplate,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Ask retrieves sources for question, asks the model and returns its answer.
+// Request options such as types.WithMaxTokens apply to the chat request.
+func (a *AugmentedChat) Ask(ctx context.Context, question string, opts ...types.RequestOption) (*Answer, error) {
+	matches, err := a.retriever.Retrieve(ctx, question)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving sources: %w", err)
+	}
+	if len(matches) == 0 && a.requireSources {
+		return nil, ErrNoSources
+	}
+
+	sources := make([]Source, len(matches))
+	for i, m := range matches {
+		sources[i] = Source{
+			N:        i + 1,
+			ID:       m.ID,
+			Content:  m.Content,
+			Metadata: m.Metadata,
+			Score:    m.Score,
+		}
+	}
+
+	var prompt strings.Builder
+	if err := a.template.Execute(&prompt, PromptData{Question: question, Sources: sources}); err != nil {
+		return nil, fmt.Errorf("rendering prompt: %w", err)
+	}
+
+	messages := []types.Message{{Role: types.RoleUser, Content: prompt.String()}}
+	if a.system != "" {
+		messages = append([]types.Message{{Role: types.RoleSystem, Content: a.system}}, messages...)
+	}
+
+	resp, err := a.chatter.Chat(ctx, types.NewChatRequest(messages, opts...))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Answer{
+		Text:     resp.Message.Content,
+		Sources:  sources,
+		Cited:    cited(resp.Message.Content, sources),
+		Response: resp,
+	}, nil
+}
+
+// citation matches source references such as [2] and [1, 3]
+var citation = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// cited returns the sources referenced in text, in source order
+func cited(text string, sources []Source) []Source {
+	seen := make(map[int]bool)
+	for _, m := range citation.FindAllStringSubmatch(text, -1) {
+		for _, part := range strings.Split(m[1], ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(part))
+			if err == nil && n >= 1 && n <= len(sources) {
+				seen[n] = true
+			}
+		}
+	}
+
+	var out []Source
+	for _, src := range sources {
+		if seen[src.N] {
+			out = append(out, src)
+		}
+	}
+	return out
+}
@@ -0,0 +1,118 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/ksred/llm/pkg/types"
+	"github.com/ksred/llm/pkg/vectorstore"
+)
+
+// fakeChatter records the request and replies with a fixed answer
+type fakeChatter struct {
+	reply string
+	req   *types.ChatRequest
+}
+
+func (f *fakeChatter) Chat(_ context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	f.req = req
+	return &types.ChatResponse{Response: types.Response{
+		Message: types.Message{Role: types.RoleAssistant, Content: f.reply},
+	}}, nil
+}
+
+// wordEmbedder embeds a text as the counts of a few fixed words
+var wordEmbedder = vectorstore.EmbedderFunc(func(_ context.Context, texts []string) ([][]float32, error) {
+	words := []string{"refund", "ship", "password"}
+	out := make([][]float32, len(texts))
+	for i, text := range texts {
+		out[i] = make([]float32, len(words))
+		for j, w := range words {
+			out[i][j] = float32(strings.Count(strings.ToLower(text), w))
+		}
+	}
+	return out, nil
+})
+
+func newIndex(t *testing.T) *vectorstore.Index {
+	t.Helper()
+	idx := vectorstore.NewIndex(vectorstore.NewMemoryStore(), wordEmbedder)
+	err := idx.Add(context.Background(),
+		vectorstore.Document{ID: "refunds", Content: "A refund takes 5 days."},
+		vectorstore.Document{ID: "refund-fees", Content: "A refund is free of charge; refund requests go to billing."},
+		vectorstore.Document{ID: "shipping", Content: "Orders ship in 24 hours."},
+	)
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	return idx
+}
+
+func TestAugmentedChat_Ask(t *testing.T) {
+	chatter := &fakeChatter{reply: "Refunds take 5 days [1] and are free [2, 9]."}
+	chat := New(chatter, IndexRetriever{Index: newIndex(t), TopK: 2}, WithSystemPrompt("Be brief."))
+
+	answer, err := chat.Ask(context.Background(), "How long does a refund take?", types.WithMaxTokens(100))
+	if err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+
+	if answer.Text != chatter.reply {
+		t.Errorf("Text = %q, want %q", answer.Text, chatter.reply)
+	}
+	if len(answer.Sources) != 2 || answer.Sources[0].N != 1 || answer.Sources[1].N != 2 {
+		t.Fatalf("Sources = %+v, want two numbered sources", answer.Sources)
+	}
+	if len(answer.Cited) != 2 {
+		t.Errorf("Cited = %+v, want sources 1 and 2, ignoring [9]", answer.Cited)
+	}
+
+	req := chatter.req
+	if req.MaxTokens != 100 {
+		t.Errorf("MaxTokens = %d, want 100", req.MaxTokens)
+	}
+	if len(req.Messages) != 2 || req.Messages[0].Role != types.RoleSystem {
+		t.Fatalf("Messages = %+v, want a system message and the prompt", req.Messages)
+	}
+	prompt := req.Messages[1].Content
+	for _, want := range []string{"[1] A refund", "[2] A refund", "Question: How long does a refund take?"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt %q does not contain %q", prompt, want)
+		}
+	}
+	if strings.Contains(prompt, "Orders ship") {
+		t.Errorf("prompt %q contains a source beyond TopK", prompt)
+	}
+}
+
+func TestAugmentedChat_Options(t *testing.T) {
+	idx := newIndex(t)
+
+	t.Run("template", func(t *testing.T) {
+		chatter := &fakeChatter{reply: "ok"}
+		tmpl := template.Must(template.New("t").Parse(`{{len .Sources}} sources for {{.Question}}`))
+		chat := New(chatter, IndexRetriever{Index: idx, TopK: 1}, WithTemplate(tmpl))
+
+		if _, err := chat.Ask(context.Background(), "shipping?"); err != nil {
+			t.Fatalf("Ask() error = %v", err)
+		}
+		if got := chatter.req.Messages[0].Content; got != "1 sources for shipping?" {
+			t.Errorf("prompt = %q", got)
+		}
+	})
+
+	t.Run("require sources", func(t *testing.T) {
+		chatter := &fakeChatter{reply: "ok"}
+		chat := New(chatter, IndexRetriever{Index: idx, MinScore: 0.5}, WithRequireSources())
+
+		if _, err := chat.Ask(context.Background(), "reset my password"); !errors.Is(err, ErrNoSources) {
+			t.Errorf("Ask() error = %v, want ErrNoSources", err)
+		}
+		if chatter.req != nil {
+			t.Error("Ask() sent a request without sources")
+		}
+	})
+}
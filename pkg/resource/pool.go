@@ -1,20 +1,28 @@
 package resource
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/ksred/llm/internal/ratelimit"
+	"github.com/ksred/llm/internal/redact"
 	"github.com/ksred/llm/pkg/types" // Assuming types package is in your-project/types
 )
 
 // PoolConfig holds configuration for the connection pool
 type PoolConfig struct {
-	MaxSize       int           // Maximum number of connections
-	IdleTimeout   time.Duration // How long to keep idle connections
-	CleanupPeriod time.Duration // How often to clean up idle connections
+	MaxSize       int               // Maximum number of connections
+	IdleTimeout   time.Duration     // How long to keep idle connections
+	CleanupPeriod time.Duration     // How often to clean up idle connections
+	Transport     http.RoundTripper // Transport for pooled clients; nil uses http.DefaultTransport
 }
 
 // ConnectionPool manages a pool of http.Client connections
@@ -22,10 +30,25 @@ type ConnectionPool struct {
 	config   *PoolConfig
 	provider string
 	metrics  *types.MetricsCallbacks
-	idle     []*http.Client
-	active   map[*http.Client]time.Time
+	idle     []idleClient
+	active   map[*http.Client]time.Time // checked-out clients and when they were checked out
 	mu       sync.Mutex
+	waiting  map[types.Priority]int // Get calls waiting for a client, by priority
 	shutdown bool
+	done     chan struct{}
+}
+
+// PoolStats is a snapshot of a pool's clients
+type PoolStats struct {
+	Idle    int // Clients waiting in the pool
+	Active  int // Clients checked out
+	Waiting int // Callers waiting for a client
+}
+
+// idleClient is a client waiting in the pool, with the time it was returned
+type idleClient struct {
+	client *http.Client
+	since  time.Time
 }
 
 // NewConnectionPool creates a new connection pool
@@ -41,16 +64,28 @@ func NewConnectionPool(config *PoolConfig, provider string, metrics *types.Metri
 		config:   config,
 		provider: provider,
 		metrics:  metrics,
-		idle:     make([]*http.Client, 0),
+		idle:     make([]idleClient, 0),
 		active:   make(map[*http.Client]time.Time),
+		waiting:  make(map[types.Priority]int),
+		done:     make(chan struct{}),
 	}
 	go pool.cleanup()
 	return pool
 }
 
-// Get retrieves a client from the pool or creates a new one
+// Get retrieves a client from the pool or creates a new one. When the pool
+// is exhausted, callers wait and freed clients go to the highest priority
+// waiter, as set on ctx with types.WithPriority.
 func (p *ConnectionPool) Get(ctx context.Context) (*http.Client, error) {
 	start := time.Now()
+	priority := types.PriorityFromContext(ctx)
+	waiting := false
+	defer func() {
+		if waiting {
+			p.doneWaiting(priority)
+		}
+	}()
+
 	for {
 		p.mu.Lock()
 		if p.shutdown {
@@ -58,32 +93,16 @@ func (p *ConnectionPool) Get(ctx context.Context) (*http.Client, error) {
 			return nil, fmt.Errorf("pool is shut down")
 		}
 
-		// Try to get an idle client
-		if len(p.idle) > 0 {
-			client := p.idle[len(p.idle)-1]
-			p.idle = p.idle[:len(p.idle)-1]
-			p.active[client] = time.Now()
-			p.mu.Unlock()
-
-			if p.metrics != nil && p.metrics.OnPoolGet != nil {
-				p.metrics.OnPoolGet(p.provider, time.Since(start))
-			}
-			return client, nil
-		}
-
-		// Check if we can create a new client
-		if len(p.active) < p.config.MaxSize {
-			// Create new client
-			client := &http.Client{
-				Timeout: 30 * time.Second,
-			}
-			p.active[client] = time.Now()
-			p.mu.Unlock()
+		// Leave freed clients to any higher priority caller that is waiting
+		if !p.outranked(priority) {
+			if client := p.checkout(); client != nil {
+				p.mu.Unlock()
 
-			if p.metrics != nil && p.metrics.OnPoolGet != nil {
-				p.metrics.OnPoolGet(p.provider, time.Since(start))
+				if p.metrics != nil && p.metrics.OnPoolGet != nil {
+					p.metrics.OnPoolGet(p.provider, time.Since(start))
+				}
+				return client, nil
 			}
-			return client, nil
 		}
 
 		// Pool is exhausted
@@ -91,6 +110,10 @@ func (p *ConnectionPool) Get(ctx context.Context) (*http.Client, error) {
 			p.metrics.OnPoolExhausted(p.provider)
 		}
 
+		if !waiting {
+			p.waiting[priority]++
+			waiting = true
+		}
 		p.mu.Unlock()
 		select {
 		case <-ctx.Done():
@@ -101,7 +124,59 @@ func (p *ConnectionPool) Get(ctx context.Context) (*http.Client, error) {
 	}
 }
 
-// Put returns a client to the pool
+// checkout takes an idle client, or creates one if the pool has room, and
+// marks it active. It returns nil if the pool is exhausted. Callers must
+// hold p.mu.
+func (p *ConnectionPool) checkout() *http.Client {
+	var client *http.Client
+	switch {
+	case len(p.idle) > 0:
+		client = p.idle[len(p.idle)-1].client
+		p.idle = p.idle[:len(p.idle)-1]
+	case len(p.active) < p.config.MaxSize:
+		// Deadlines are applied per request through the request context,
+		// so the client itself has no timeout
+		client = &http.Client{Transport: p.config.Transport}
+	default:
+		return nil
+	}
+	p.active[client] = time.Now()
+	return client
+}
+
+func (p *ConnectionPool) doneWaiting(priority types.Priority) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.waiting[priority]--; p.waiting[priority] == 0 {
+		delete(p.waiting, priority)
+	}
+}
+
+// outranked reports whether a caller with a higher priority is waiting.
+// Callers must hold p.mu.
+func (p *ConnectionPool) outranked(priority types.Priority) bool {
+	for waiting := range p.waiting {
+		if waiting > priority {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats returns the number of idle and checked-out clients and of callers
+// waiting for one
+func (p *ConnectionPool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := PoolStats{Idle: len(p.idle), Active: len(p.active)}
+	for _, n := range p.waiting {
+		stats.Waiting += n
+	}
+	return stats
+}
+
+// Put returns a client to the pool. Clients that are not checked out, or
+// that are returned after Shutdown, are ignored.
 func (p *ConnectionPool) Put(client *http.Client) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -109,21 +184,73 @@ func (p *ConnectionPool) Put(client *http.Client) {
 	if p.shutdown {
 		return
 	}
+	if _, ok := p.active[client]; !ok {
+		return
+	}
 
 	delete(p.active, client)
-	p.idle = append(p.idle, client)
+	p.idle = append(p.idle, idleClient{client: client, since: time.Now()})
 
 	if p.metrics != nil && p.metrics.OnPoolRelease != nil {
 		p.metrics.OnPoolRelease(p.provider)
 	}
 }
 
+// Warmup opens up to n connections to url ahead of the first requests, so
+// they do not pay for the TCP and TLS handshakes. Any HTTP response counts
+// as success. Connections beyond the transport's idle limit per host are
+// not kept.
+func (p *ConnectionPool) Warmup(ctx context.Context, url string, n int) error {
+	if n < 1 {
+		n = 1
+	}
+
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = p.preconnect(ctx, url)
+		}(i)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// preconnect sends a HEAD request to url on a pooled client
+func (p *ConnectionPool) preconnect(ctx context.Context, url string) error {
+	client, err := p.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer p.Put(client)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return fmt.Errorf("creating warmup request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("warming up connection: %w", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
+
 // cleanup periodically removes idle connections
 func (p *ConnectionPool) cleanup() {
 	ticker := time.NewTicker(p.config.CleanupPeriod)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
 		p.mu.Lock()
 		if p.shutdown {
 			p.mu.Unlock()
@@ -131,14 +258,12 @@ func (p *ConnectionPool) cleanup() {
 		}
 
 		now := time.Now()
-		remaining := make([]*http.Client, 0, len(p.idle))
+		remaining := make([]idleClient, 0, len(p.idle))
 
 		// Remove idle clients that have timed out
-		for _, client := range p.idle {
-			if lastUsed, ok := p.active[client]; ok {
-				if now.Sub(lastUsed) < p.config.IdleTimeout {
-					remaining = append(remaining, client)
-				}
+		for _, idle := range p.idle {
+			if now.Sub(idle.since) < p.config.IdleTimeout {
+				remaining = append(remaining, idle)
 			}
 		}
 
@@ -147,14 +272,27 @@ func (p *ConnectionPool) cleanup() {
 	}
 }
 
-// Shutdown closes the pool and all connections
+// Shutdown closes the pool, stops the cleanup goroutine and closes idle
+// connections. It is safe to call more than once.
 func (p *ConnectionPool) Shutdown() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.shutdown {
+		return nil
+	}
+
+	for _, idle := range p.idle {
+		idle.client.CloseIdleConnections()
+	}
+	for client := range p.active {
+		client.CloseIdleConnections()
+	}
+
 	p.shutdown = true
 	p.idle = nil
 	p.active = nil
+	close(p.done)
 	return nil
 }
 
@@ -164,6 +302,119 @@ type RetryConfig struct {
 	InitialInterval time.Duration
 	MaxInterval     time.Duration
 	Multiplier      float64
+
+	// Policy decides which failures are retried. If nil, DefaultRetryPolicy
+	// is used.
+	Policy RetryPolicy
+
+	// Jitter randomizes backoff so that many clients failing at once do not
+	// retry in lockstep
+	Jitter JitterMode
+}
+
+// JitterMode selects how retry delays are randomized
+type JitterMode int
+
+const (
+	// JitterNone uses the exponential backoff delay as-is
+	JitterNone JitterMode = iota
+	// JitterFull sleeps a random duration between zero and the backoff delay
+	JitterFull
+	// JitterDecorrelated sleeps a random duration between InitialInterval and
+	// three times the previous delay, capped at MaxInterval
+	JitterDecorrelated
+)
+
+// backoff computes successive retry delays
+type backoff struct {
+	config   *RetryConfig
+	interval time.Duration // Exponential delay before jitter
+	prev     time.Duration // Previous decorrelated delay
+	rand     func() float64
+}
+
+func newBackoff(config *RetryConfig) *backoff {
+	return &backoff{
+		config:   config,
+		interval: config.InitialInterval,
+		prev:     config.InitialInterval,
+		rand:     rand.Float64,
+	}
+}
+
+// next returns the delay before the next retry
+func (b *backoff) next() time.Duration {
+	if b.config.Jitter == JitterDecorrelated {
+		lo := b.config.InitialInterval
+		hi := 3 * b.prev
+		if hi < lo {
+			hi = lo
+		}
+		delay := lo + time.Duration(b.rand()*float64(hi-lo))
+		if b.config.MaxInterval > 0 && delay > b.config.MaxInterval {
+			delay = b.config.MaxInterval
+		}
+		b.prev = delay
+		return delay
+	}
+
+	delay := b.interval
+	b.interval = time.Duration(float64(b.interval) * b.config.Multiplier)
+	if b.interval > b.config.MaxInterval {
+		b.interval = b.config.MaxInterval
+	}
+
+	if b.config.Jitter == JitterFull {
+		delay = time.Duration(b.rand() * float64(delay))
+	}
+	return delay
+}
+
+// RetryPolicy reports whether a failed attempt should be retried. resp is
+// nil when err is set.
+type RetryPolicy func(resp *http.Response, err error) bool
+
+// DefaultRetryableStatuses are the status codes retried by DefaultRetryPolicy:
+// rate limiting, transient server errors, and Anthropic's 529 overloaded
+var DefaultRetryableStatuses = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	529,
+}
+
+// DefaultRetryPolicy retries transport errors and DefaultRetryableStatuses
+var DefaultRetryPolicy = RetryOnStatus(DefaultRetryableStatuses...)
+
+// RetryOnStatus returns a policy that retries transport errors and the given
+// status codes. Requests whose context is done are never retried, nor are
+// transport errors implementing types.RetryableError that report false.
+func RetryOnStatus(codes ...int) RetryPolicy {
+	retryable := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		retryable[code] = true
+	}
+	return func(resp *http.Response, err error) bool {
+		if err != nil {
+			var r types.RetryableError
+			if errors.As(err, &r) {
+				return r.Retryable()
+			}
+			return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+		}
+		return retryable[resp.StatusCode]
+	}
+}
+
+// NewPooledRetryableClient creates a retryable client that checks a client
+// out of pool for each request. The client is returned to the pool when the
+// response body is closed, or immediately if the request fails, so the pool's
+// MaxSize bounds the number of requests and streams in flight.
+func NewPooledRetryableClient(pool *ConnectionPool, config *RetryConfig, provider string, metrics *types.MetricsCallbacks) *RetryableClient {
+	c := NewRetryableClient(nil, config, provider, metrics)
+	c.pool = pool
+	return c
 }
 
 // NewRetryableClient creates a new retryable client
@@ -187,16 +438,96 @@ func NewRetryableClient(client *http.Client, config *RetryConfig, provider strin
 // RetryableClient wraps an http.Client with retry logic
 type RetryableClient struct {
 	client   *http.Client
+	pool     *ConnectionPool // when set, client is checked out per request
 	config   *RetryConfig
 	provider string
 	metrics  *types.MetricsCallbacks
+
+	// decodeError turns a failed response into the error returned once
+	// retries are exhausted
+	decodeError func(*http.Response) error
+}
+
+// SetErrorDecoder sets how a failed response becomes the error returned once
+// retries are exhausted, so that providers keep their structured error
+// details. fn reads the response body, which is closed afterwards. By
+// default the status and body become a *types.ProviderError.
+func (c *RetryableClient) SetErrorDecoder(fn func(*http.Response) error) {
+	c.decodeError = fn
 }
 
-// Do executes an HTTP request with retries
+// DefaultRequestTimeout bounds requests, retries included, whose context
+// has no deadline. Pooled clients have no timeout of their own, so that a
+// deadline set on the context may be longer.
+const DefaultRequestTimeout = 30 * time.Second
+
+// Do executes an HTTP request with retries. Responses the retry policy does
+// not retry, including client errors, are returned as-is for the caller to
+// decode. The request body is rebuilt for each attempt using req.GetBody;
+// bodies without GetBody are buffered in memory first. The trace context of
+// the request's context is propagated in its headers. A request whose
+// context has no deadline, including reading its response body, is given
+// DefaultRequestTimeout.
 func (c *RetryableClient) Do(req *http.Request) (*http.Response, error) {
+	cancel := func() {}
+	if _, ok := req.Context().Deadline(); !ok {
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(req.Context(), DefaultRequestTimeout)
+		req = req.WithContext(ctx)
+	}
+
+	client := c.client
+	release := cancel
+	if c.pool != nil {
+		var err error
+		client, err = c.pool.Get(req.Context())
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("getting client from pool: %w", err)
+		}
+		release = func() {
+			c.pool.Put(client)
+			cancel()
+		}
+	}
+
+	resp, err := c.do(client, req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &pooledBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// pooledBody returns its pooled client and ends its request's default
+// deadline when the response body is closed
+type pooledBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *pooledBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+func (c *RetryableClient) do(client *http.Client, req *http.Request) (*http.Response, error) {
 	var resp *http.Response
-	var err error
-	interval := c.config.InitialInterval
+	var err, lastErr error
+	delays := newBackoff(c.config)
+
+	if err := rewindable(req); err != nil {
+		return nil, err
+	}
+	injectTrace(req)
+
+	policy := c.config.Policy
+	if policy == nil {
+		policy = DefaultRetryPolicy
+	}
 
 	start := time.Now()
 	if c.metrics != nil && c.metrics.OnRequest != nil {
@@ -205,20 +536,37 @@ func (c *RetryableClient) Do(req *http.Request) (*http.Response, error) {
 
 	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
 		if attempt > 0 {
-			// Sleep before retry with exponential backoff
-			time.Sleep(interval)
-			interval = time.Duration(float64(interval) * c.config.Multiplier)
-			if interval > c.config.MaxInterval {
-				interval = c.config.MaxInterval
+			// Sleep before retry with exponential backoff, giving up early
+			// if the backoff would outlast the request's deadline
+			if err := sleepBeforeRetry(req.Context(), delays.next(), lastErr); err != nil {
+				return nil, err
 			}
 
+			recordRetry(req.Context(), c.provider, attempt, lastErr)
 			if c.metrics != nil && c.metrics.OnRetry != nil {
-				c.metrics.OnRetry(c.provider, attempt, err)
+				c.metrics.OnRetry(c.provider, attempt, lastErr)
+			}
+		}
+
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, fmt.Errorf("rewinding request body: %w", bodyErr)
 			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
 		}
 
-		resp, err = c.client.Do(req)
-		if err == nil && resp.StatusCode < 500 {
+		resp, err = client.Do(attemptReq)
+		c.reportRateLimits(resp)
+		if !policy(resp, err) {
+			if err != nil {
+				if c.metrics != nil && c.metrics.OnError != nil {
+					c.metrics.OnError(c.provider, err)
+				}
+				return nil, err
+			}
 			if c.metrics != nil && c.metrics.OnResponse != nil {
 				c.metrics.OnResponse(c.provider, time.Since(start))
 			}
@@ -229,18 +577,103 @@ func (c *RetryableClient) Do(req *http.Request) (*http.Response, error) {
 			c.metrics.OnError(c.provider, err)
 		}
 
-		// Close the response body if we're going to retry
+		// Record why the attempt failed, then close the response body
+		// since we're going to retry
+		lastErr = c.failure(resp, err)
 		if resp != nil && resp.Body != nil {
 			resp.Body.Close()
 		}
 	}
 
 	// If we've exhausted all retries, return an error
-	if resp != nil && resp.StatusCode >= 500 {
-		err = fmt.Errorf("server error: %d", resp.StatusCode)
-	} else if err == nil {
-		err = fmt.Errorf("max retries exceeded")
+	return nil, lastErr
+}
+
+// reportRateLimits passes the rate limit state in resp's headers to the
+// OnRateLimits callback
+func (c *RetryableClient) reportRateLimits(resp *http.Response) {
+	if resp == nil || c.metrics == nil || c.metrics.OnRateLimits == nil {
+		return
+	}
+	if limits := ratelimit.FromHeaders(resp.Header, time.Now()); limits != nil {
+		c.metrics.OnRateLimits(c.provider, *limits)
+	}
+}
+
+// failure describes a failed attempt as an error. Failed responses become a
+// *types.ProviderError wrapping the common error for their status code.
+func (c *RetryableClient) failure(resp *http.Response, err error) error {
+	if err != nil {
+		return err
 	}
+	if c.decodeError != nil {
+		return c.decodeError(resp)
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	message := redact.ProviderMessage(strings.TrimSpace(string(body)), resp.StatusCode)
+	return &types.ProviderError{
+		Provider:   c.provider,
+		Message:    fmt.Sprintf("status %d: %s", resp.StatusCode, message),
+		StatusCode: resp.StatusCode,
+		RequestID:  RequestID(resp.Header),
+		Err:        types.ErrorForStatus(resp.StatusCode, "", message),
+	}
+}
 
-	return nil, err
+// maxErrorBody limits how much of an error response is kept
+const maxErrorBody = 4 << 10
+
+// RequestID returns the provider request ID from response headers
+func RequestID(h http.Header) string {
+	if id := h.Get("X-Request-Id"); id != "" {
+		return id
+	}
+	return h.Get("Request-Id")
+}
+
+// sleepBeforeRetry waits for delay unless the context ends first. If the
+// context's deadline is closer than delay it returns immediately with a
+// types.ErrTimeout wrapping the last failure, rather than sleeping through
+// the deadline.
+func sleepBeforeRetry(ctx context.Context, delay time.Duration, last error) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining < delay {
+			return fmt.Errorf("%w: %s left before deadline is less than retry backoff of %s: %w",
+				types.ErrTimeout, remaining.Round(time.Millisecond), delay, last)
+		}
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %w", types.ErrTimeout, last)
+		}
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// rewindable makes sure the request body can be replayed by setting GetBody,
+// buffering the body if necessary
+func rewindable(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return nil
+	}
+
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return fmt.Errorf("reading request body: %w", err)
+	}
+
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	req.Body, _ = req.GetBody()
+	req.ContentLength = int64(len(data))
+	return nil
 }
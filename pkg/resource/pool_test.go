@@ -2,10 +2,18 @@ package resource
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/ksred/llm/pkg/types"
 )
 
 func TestConnectionPool_Get(t *testing.T) {
@@ -177,6 +185,305 @@ func TestRetryableClient_Do(t *testing.T) {
 	}
 }
 
+func TestRetryableClient_Policy(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     RetryPolicy
+		responses  []int
+		wantStatus int
+		wantErr    error
+		wantCalls  int
+	}{
+		{"429 retried", nil, []int{http.StatusTooManyRequests, http.StatusOK}, http.StatusOK, nil, 2},
+		{"529 retried", nil, []int{529, http.StatusOK}, http.StatusOK, nil, 2},
+		{"400 not retried", nil, []int{http.StatusBadRequest, http.StatusOK}, http.StatusBadRequest, nil, 1},
+		{"401 not retried", nil, []int{http.StatusUnauthorized, http.StatusOK}, http.StatusUnauthorized, nil, 1},
+		{"501 not retried", nil, []int{http.StatusNotImplemented, http.StatusOK}, http.StatusNotImplemented, nil, 1},
+		{"429 exhausted", nil, []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests}, 0, types.ErrRateLimitExceeded, 3},
+		{"custom policy", RetryOnStatus(http.StatusConflict), []int{http.StatusConflict, http.StatusTooManyRequests}, http.StatusTooManyRequests, nil, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.responses[calls])
+				calls++
+			}))
+			defer server.Close()
+
+			retryClient := NewRetryableClient(&http.Client{}, &RetryConfig{
+				MaxRetries:      2,
+				InitialInterval: time.Millisecond,
+				MaxInterval:     time.Millisecond,
+				Multiplier:      2,
+				Policy:          tt.policy,
+			}, "test", nil)
+
+			req, _ := http.NewRequest("GET", server.URL, nil)
+			resp, err := retryClient.Do(req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Do() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && resp.StatusCode != tt.wantStatus {
+				t.Errorf("Do() status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("server called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryableClient_ErrorDecoder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(529)
+		fmt.Fprint(w, "overloaded_error")
+	}))
+	defer server.Close()
+
+	retryClient := NewRetryableClient(&http.Client{}, &RetryConfig{
+		MaxRetries:      1,
+		InitialInterval: time.Millisecond,
+		MaxInterval:     time.Millisecond,
+		Multiplier:      2,
+	}, "test", nil)
+	retryClient.SetErrorDecoder(func(resp *http.Response) error {
+		body, _ := io.ReadAll(resp.Body)
+		return &types.ProviderError{Provider: "test", StatusCode: resp.StatusCode, Code: string(body), Err: types.ErrOverloaded}
+	})
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	_, err := retryClient.Do(req)
+	provErr, ok := err.(*types.ProviderError)
+	if !ok {
+		t.Fatalf("Do() error = %T, want *types.ProviderError", err)
+	}
+	if provErr.Code != "overloaded_error" || provErr.StatusCode != 529 {
+		t.Errorf("Do() error = %+v, want the decoded error", provErr)
+	}
+}
+
+func TestRetryableClient_RateLimits(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("x-ratelimit-remaining-requests", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("x-ratelimit-remaining-requests", "9")
+	}))
+	defer server.Close()
+
+	var remaining []int
+	retryClient := NewRetryableClient(&http.Client{}, &RetryConfig{
+		MaxRetries:      1,
+		InitialInterval: time.Millisecond,
+		MaxInterval:     time.Millisecond,
+		Multiplier:      2,
+	}, "test", &types.MetricsCallbacks{
+		OnRateLimits: func(provider string, limits types.RateLimits) {
+			remaining = append(remaining, limits.RequestsRemaining)
+		},
+	})
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := retryClient.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	resp.Body.Close()
+	if len(remaining) != 2 || remaining[0] != 0 || remaining[1] != 9 {
+		t.Errorf("OnRateLimits remaining = %v, want [0 9]", remaining)
+	}
+}
+
+func TestRetryableClient_RetriesBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body func() io.Reader
+	}{
+		{"with GetBody", func() io.Reader { return strings.NewReader(`{"messages":[]}`) }},
+		{"without GetBody", func() io.Reader { return io.MultiReader(strings.NewReader(`{"messages":[]}`)) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(data))
+				if len(bodies) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer server.Close()
+
+			retryClient := NewRetryableClient(&http.Client{}, &RetryConfig{
+				MaxRetries:      2,
+				InitialInterval: time.Millisecond,
+				MaxInterval:     time.Millisecond,
+				Multiplier:      2,
+			}, "test", nil)
+
+			req, _ := http.NewRequest("POST", server.URL, tt.body())
+			resp, err := retryClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			resp.Body.Close()
+
+			want := []string{`{"messages":[]}`, `{"messages":[]}`}
+			if len(bodies) != 2 || bodies[0] != want[0] || bodies[1] != want[1] {
+				t.Errorf("server received bodies %q, want %q", bodies, want)
+			}
+		})
+	}
+}
+
+func TestRetryableClient_Deadline(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	retryClient := NewRetryableClient(&http.Client{}, &RetryConfig{
+		MaxRetries:      3,
+		InitialInterval: time.Second,
+		MaxInterval:     time.Second,
+		Multiplier:      2,
+	}, "test", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	_, err := retryClient.Do(req)
+
+	if !errors.Is(err, types.ErrTimeout) {
+		t.Errorf("Do() error = %v, want %v", err, types.ErrTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Do() took %v, want it to give up without sleeping", elapsed)
+	}
+	if calls != 1 {
+		t.Errorf("server called %d times, want 1", calls)
+	}
+}
+
+func TestRetryableClient_DefaultDeadline(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration // of the request's context; 0 sets no deadline
+		want    time.Duration
+	}{
+		{"no deadline", 0, DefaultRequestTimeout},
+		{"longer deadline", 5 * time.Minute, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deadline time.Time
+			var hasDeadline bool
+			pool := NewConnectionPool(&PoolConfig{
+				MaxSize:       1,
+				IdleTimeout:   time.Minute,
+				CleanupPeriod: time.Hour,
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					deadline, hasDeadline = req.Context().Deadline()
+					return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+				}),
+			}, "test", nil)
+			defer pool.Shutdown()
+			client := NewPooledRetryableClient(pool, &RetryConfig{MaxRetries: 0}, "test", nil)
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			start := time.Now()
+			req, _ := http.NewRequestWithContext(ctx, "GET", "http://example.com", nil)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			resp.Body.Close()
+
+			if !hasDeadline {
+				t.Fatal("request sent without a deadline")
+			}
+			if got := deadline.Sub(start); got < tt.want-time.Second || got > tt.want+time.Second {
+				t.Errorf("request deadline in %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRetryOnStatus_Errors(t *testing.T) {
+	policy := DefaultRetryPolicy
+	if !policy(nil, errors.New("connection reset")) {
+		t.Error("transport error not retried")
+	}
+	if policy(nil, context.Canceled) {
+		t.Error("cancelled request retried")
+	}
+	if policy(nil, fmt.Errorf("wrapped: %w", context.DeadlineExceeded)) {
+		t.Error("timed out request retried")
+	}
+	if policy(nil, fmt.Errorf("fetching token: %w", &types.ProviderError{StatusCode: 401, Err: types.ErrInvalidCredentials})) {
+		t.Error("error reporting it is not retryable was retried")
+	}
+	if !policy(nil, &types.ProviderError{StatusCode: 503, Err: types.ErrProviderError}) {
+		t.Error("error reporting it is retryable not retried")
+	}
+}
+
+func TestBackoff_Next(t *testing.T) {
+	tests := []struct {
+		name   string
+		jitter JitterMode
+		rand   float64
+		want   []time.Duration
+	}{
+		{"no jitter", JitterNone, 0.5, []time.Duration{100, 200, 400, 500, 500}},
+		{"full jitter", JitterFull, 0.5, []time.Duration{50, 100, 200, 250, 250}},
+		{"full jitter minimum", JitterFull, 0, []time.Duration{0, 0, 0, 0, 0}},
+		{"decorrelated", JitterDecorrelated, 0.5, []time.Duration{200, 350, 500, 500, 500}},
+		{"decorrelated minimum", JitterDecorrelated, 0, []time.Duration{100, 100, 100, 100, 100}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBackoff(&RetryConfig{
+				InitialInterval: 100,
+				MaxInterval:     500,
+				Multiplier:      2,
+				Jitter:          tt.jitter,
+			})
+			b.rand = func() float64 { return tt.rand }
+
+			for i, want := range tt.want {
+				if got := b.next(); got != want {
+					t.Errorf("next() #%d = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
 // mockHTTPClient implements http.RoundTripper for testing
 type mockHTTPClient struct {
 	responses []int
@@ -200,3 +507,256 @@ func (m *mockHTTPClient) RoundTrip(req *http.Request) (*http.Response, error) {
 		Body:       http.NoBody,
 	}, nil
 }
+
+func TestConnectionPool_Shutdown(t *testing.T) {
+	pool := NewConnectionPool(&PoolConfig{
+		MaxSize:       2,
+		IdleTimeout:   time.Second,
+		CleanupPeriod: time.Hour,
+	}, "test", nil)
+
+	client, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	pool.Put(client)
+
+	if err := pool.Shutdown(); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if err := pool.Shutdown(); err != nil {
+		t.Errorf("second Shutdown() error = %v", err)
+	}
+
+	select {
+	case <-pool.done:
+	default:
+		t.Error("Shutdown() did not signal the cleanup goroutine")
+	}
+
+	if _, err := pool.Get(context.Background()); err == nil {
+		t.Error("Get() after Shutdown() expected error")
+	}
+}
+
+func TestRetryableClient_PooledCheckout(t *testing.T) {
+	var status = http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	var released int
+	pool := NewConnectionPool(&PoolConfig{
+		MaxSize:       1,
+		IdleTimeout:   time.Minute,
+		CleanupPeriod: time.Hour,
+	}, "test", &types.MetricsCallbacks{
+		OnPoolRelease: func(provider string) { released++ },
+	})
+	defer pool.Shutdown()
+	client := NewPooledRetryableClient(pool, &RetryConfig{MaxRetries: 0}, "test", nil)
+
+	get := func(ctx context.Context) (*http.Response, error) {
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+		return client.Do(req)
+	}
+
+	resp, err := get(context.Background())
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+
+	// The only client is checked out until the body is closed
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	if _, err := get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Do() with exhausted pool error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	resp.Body.Close()
+	resp.Body.Close()
+	if released != 1 {
+		t.Errorf("client released %d times, want 1", released)
+	}
+
+	resp, err = get(context.Background())
+	if err != nil {
+		t.Fatalf("Do() after release error = %v", err)
+	}
+	resp.Body.Close()
+
+	// Failed requests release their client straight away
+	status = http.StatusServiceUnavailable
+	if _, err := get(context.Background()); err == nil {
+		t.Fatal("Do() error = nil, want error")
+	}
+	status = http.StatusOK
+	resp, err = get(context.Background())
+	if err != nil {
+		t.Fatalf("Do() after failure error = %v", err)
+	}
+	resp.Body.Close()
+	if released != 4 {
+		t.Errorf("client released %d times, want 4", released)
+	}
+}
+
+func TestConnectionPool_PutUnknown(t *testing.T) {
+	pool := NewConnectionPool(&PoolConfig{
+		MaxSize:       1,
+		IdleTimeout:   time.Minute,
+		CleanupPeriod: time.Hour,
+	}, "test", nil)
+	defer pool.Shutdown()
+
+	client, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	pool.Put(client)
+	pool.Put(client)
+	pool.Put(&http.Client{})
+
+	if len(pool.idle) != 1 {
+		t.Errorf("pool has %d idle clients, want 1", len(pool.idle))
+	}
+}
+
+func TestConnectionPool_CleanupKeepsFreshClients(t *testing.T) {
+	pool := NewConnectionPool(&PoolConfig{
+		MaxSize:       1,
+		IdleTimeout:   time.Hour,
+		CleanupPeriod: 20 * time.Millisecond,
+	}, "test", nil)
+	defer pool.Shutdown()
+
+	client, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	pool.Put(client)
+
+	time.Sleep(100 * time.Millisecond)
+
+	client2, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if client2 != client {
+		t.Error("cleanup removed a client before its idle timeout")
+	}
+}
+
+func TestConnectionPool_Stats(t *testing.T) {
+	pool := NewConnectionPool(&PoolConfig{
+		MaxSize:       2,
+		IdleTimeout:   time.Minute,
+		CleanupPeriod: time.Hour,
+	}, "test", nil)
+	defer pool.Shutdown()
+
+	a, _ := pool.Get(context.Background())
+	b, _ := pool.Get(context.Background())
+	pool.Put(a)
+	if got, want := pool.Stats(), (PoolStats{Idle: 1, Active: 1}); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+
+	pool.Get(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	go pool.Get(ctx)
+	time.Sleep(50 * time.Millisecond)
+	if got, want := pool.Stats(), (PoolStats{Active: 2, Waiting: 1}); got != want {
+		t.Errorf("Stats() while exhausted = %+v, want %+v", got, want)
+	}
+
+	<-ctx.Done()
+	time.Sleep(20 * time.Millisecond)
+	pool.Put(b)
+	if got, want := pool.Stats(), (PoolStats{Idle: 1, Active: 1}); got != want {
+		t.Errorf("Stats() after waiter gave up = %+v, want %+v", got, want)
+	}
+}
+
+func TestConnectionPool_Priority(t *testing.T) {
+	pool := NewConnectionPool(&PoolConfig{
+		MaxSize:       1,
+		IdleTimeout:   time.Minute,
+		CleanupPeriod: time.Hour,
+	}, "test", nil)
+	defer pool.Shutdown()
+
+	held, _ := pool.Get(context.Background())
+
+	got := make(chan types.Priority, 2)
+	for _, p := range []types.Priority{types.PriorityBackground, types.PriorityInteractive} {
+		go func(p types.Priority) {
+			client, err := pool.Get(types.WithPriority(context.Background(), p))
+			if err != nil {
+				t.Errorf("Get() error = %v", err)
+				return
+			}
+			got <- p
+			time.Sleep(20 * time.Millisecond)
+			pool.Put(client)
+		}(p)
+		// Let the background caller start waiting first
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	pool.Put(held)
+	if first := <-got; first != types.PriorityInteractive {
+		t.Errorf("first waiter served has priority %d, want %d", first, types.PriorityInteractive)
+	}
+	<-got
+}
+
+func TestConnectionPool_Warmup(t *testing.T) {
+	var conns atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	pool := NewConnectionPool(&PoolConfig{
+		MaxSize:       4,
+		IdleTimeout:   time.Minute,
+		CleanupPeriod: time.Hour,
+		Transport:     &http.Transport{MaxIdleConnsPerHost: 4},
+	}, "test", nil)
+	defer pool.Shutdown()
+
+	if err := pool.Warmup(context.Background(), server.URL, 3); err != nil {
+		t.Fatalf("Warmup() error = %v", err)
+	}
+	if n := conns.Load(); n != 3 {
+		t.Errorf("Warmup() opened %d connections, want 3", n)
+	}
+
+	// Requests after warmup reuse the open connections
+	client, _ := pool.Get(context.Background())
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+	pool.Put(client)
+	if n := conns.Load(); n != 3 {
+		t.Errorf("request after warmup opened a connection, have %d", n)
+	}
+
+	server.Close()
+	if err := pool.Warmup(context.Background(), server.URL, 1); err == nil {
+		t.Error("Warmup() against a closed server error = nil")
+	}
+}
@@ -0,0 +1,48 @@
+package resource
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type retryCounterKey struct{}
+
+// WithRetryCounter returns a context that counts the retries made by every
+// RetryableClient request sent with it, and the counter itself
+func WithRetryCounter(ctx context.Context) (context.Context, *atomic.Int64) {
+	counter := new(atomic.Int64)
+	return context.WithValue(ctx, retryCounterKey{}, counter), counter
+}
+
+// injectTrace adds the trace context of ctx to the request headers using the
+// global propagator, so provider calls join the caller's trace
+func injectTrace(req *http.Request) {
+	otel.GetTextMapPropagator().Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+}
+
+// recordRetry counts a retry against the request's context and adds it as an
+// event to the active span
+func recordRetry(ctx context.Context, provider string, attempt int, err error) {
+	if counter, ok := ctx.Value(retryCounterKey{}).(*atomic.Int64); ok {
+		counter.Add(1)
+	}
+
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("llm.provider", provider),
+		attribute.Int("llm.retry.attempt", attempt),
+	}
+	if err != nil {
+		attrs = append(attrs, attribute.String("error.message", err.Error()))
+	}
+	span.AddEvent("llm.retry", trace.WithAttributes(attrs...))
+}
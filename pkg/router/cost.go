@@ -0,0 +1,79 @@
+package router
+
+import (
+	"time"
+
+	"github.com/ksred/llm/internal/tokenizer"
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/models"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// Constraints limit the backends a cost-aware strategy may choose
+type Constraints struct {
+	// MaxLatency excludes backends with a higher typical Latency. Backends
+	// whose Latency is unknown are not excluded.
+	MaxLatency time.Duration
+	// Capabilities are required of every request's model. Tool calling
+	// and streaming are also required of requests that use them.
+	Capabilities []models.Capability
+	// MinContextWindow excludes models with a smaller context window.
+	// Each request's estimated prompt and completion must fit regardless.
+	MinContextWindow int
+}
+
+// costStrategy chooses the cheapest backend meeting its constraints
+type costStrategy struct {
+	catalog     *cost.PricingCatalog
+	constraints Constraints
+}
+
+// Cheapest chooses, for each request, the backend with the lowest estimated
+// cost under catalog that meets the constraints. Backends whose model is
+// missing from the model registry or the catalog are never chosen, as
+// their limits or prices are unknown. Ties go to the earlier backend.
+func Cheapest(catalog *cost.PricingCatalog, c Constraints) Strategy {
+	if catalog == nil {
+		catalog = cost.DefaultCatalog()
+	}
+	return &costStrategy{catalog: catalog, constraints: c}
+}
+
+// Select implements Strategy
+func (s *costStrategy) Select(req *types.ChatRequest, stream bool, backends []Backend) (Backend, error) {
+	caps := append([]models.Capability(nil), s.constraints.Capabilities...)
+	if stream {
+		caps = append(caps, models.CapabilityStreaming)
+	}
+	if len(req.Tools) > 0 {
+		caps = append(caps, models.CapabilityTools)
+	}
+	prompt := tokenizer.CountChat(req)
+	completion := tokenizer.EstimateChat(req) - prompt
+
+	var best Backend
+	bestCost := -1.0
+	for _, b := range backends {
+		if s.constraints.MaxLatency > 0 && b.Latency > s.constraints.MaxLatency {
+			continue
+		}
+		m, ok := models.Lookup(b.Model)
+		if !ok || !m.Supports(caps...) || m.ContextWindow < s.constraints.MinContextWindow {
+			continue
+		}
+		if prompt+completion > m.ContextWindow || req.MaxTokens > m.MaxOutputTokens {
+			continue
+		}
+		if _, ok := s.catalog.Lookup(b.Provider, b.Model); !ok {
+			continue
+		}
+
+		if c := s.catalog.Estimate(b.Provider, b.Model, prompt, completion); bestCost < 0 || c < bestCost {
+			best, bestCost = b, c
+		}
+	}
+	if bestCost < 0 {
+		return Backend{}, ErrNoBackend
+	}
+	return best, nil
+}
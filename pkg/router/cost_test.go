@@ -0,0 +1,55 @@
+package router
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/models"
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestCheapest(t *testing.T) {
+	backends := []Backend{
+		{Provider: "openai", Model: "gpt-4o", Latency: 3 * time.Second},
+		{Provider: "openai", Model: "gpt-4o-mini", Latency: 4 * time.Second},
+		{Provider: "openai", Model: "gpt-3.5-turbo", Latency: 2 * time.Second},
+		{Provider: "anthropic", Model: "claude-2", Latency: time.Second},
+		{Provider: "anthropic", Model: "claude-3-haiku-20240307", Latency: 5 * time.Second},
+		{Provider: "custom", Model: "my-model"},
+	}
+	vision := []models.Capability{models.CapabilityVision}
+	long := strings.Repeat("word ", 120000)
+
+	tests := []struct {
+		name        string
+		constraints Constraints
+		req         *types.ChatRequest
+		stream      bool
+		want        string
+		wantErr     error
+	}{
+		{"cheapest overall", Constraints{}, request("hi"), false, "gpt-4o-mini", nil},
+		{"latency limit", Constraints{MaxLatency: 2 * time.Second}, request("hi"), false, "gpt-3.5-turbo", nil},
+		{"required capability", Constraints{MaxLatency: 3 * time.Second, Capabilities: vision}, request("hi"), false, "gpt-4o", nil},
+		{"context window limit", Constraints{MinContextWindow: 150000}, request("hi"), false, "claude-3-haiku-20240307", nil},
+		{"prompt must fit", Constraints{}, request(long), false, "claude-3-haiku-20240307", nil},
+		{"output limit", Constraints{MaxLatency: 3 * time.Second}, request("hi", types.WithMaxTokens(5000)), false, "gpt-4o", nil},
+		{"streaming", Constraints{MaxLatency: time.Second}, request("hi"), true, "claude-2", nil},
+		{"tools required by request", Constraints{MaxLatency: time.Second}, request("hi", types.WithTools(types.Tool{Name: "lookup"})), false, "", ErrNoBackend},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := Cheapest(cost.DefaultCatalog(), tt.constraints).Select(tt.req, tt.stream, backends)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Select() error = %v, want %v", err, tt.wantErr)
+			}
+			if b.Model != tt.want {
+				t.Errorf("Select() = %q, want %q", b.Model, tt.want)
+			}
+		})
+	}
+}
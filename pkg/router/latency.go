@@ -0,0 +1,164 @@
+package router
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+const (
+	defaultSmoothing     = 0.3
+	defaultErrorPenalty  = 30 * time.Second
+	defaultProbeInterval = 30 * time.Second
+)
+
+// LatencyStrategy chooses the backend with the lowest exponentially
+// weighted moving average latency. Backends not yet measured are tried
+// first. Failures caused by the backend, such as rate limits, overloads and
+// timeouts, count as a penalty latency, so traffic moves away from degraded
+// providers. Every backend not chosen for a while is retried once, so a
+// recovered provider wins its traffic back.
+type LatencyStrategy struct {
+	smoothing     float64
+	errorPenalty  time.Duration
+	probeInterval time.Duration
+	now           func() time.Time
+
+	mu    sync.Mutex
+	stats map[string]*latencyStats
+}
+
+// latencyStats is what a LatencyStrategy knows about one backend
+type latencyStats struct {
+	average   time.Duration
+	samples   int
+	lastTried time.Time
+}
+
+// LatencyOption configures a LatencyStrategy
+type LatencyOption func(*LatencyStrategy)
+
+// WithSmoothing sets the weight, between 0 and 1, given to each new sample
+// in the moving average. Higher values react faster. Defaults to 0.3.
+func WithSmoothing(alpha float64) LatencyOption {
+	return func(s *LatencyStrategy) {
+		s.smoothing = alpha
+	}
+}
+
+// WithErrorPenalty sets the latency recorded for a failed request.
+// Defaults to 30 seconds.
+func WithErrorPenalty(d time.Duration) LatencyOption {
+	return func(s *LatencyStrategy) {
+		s.errorPenalty = d
+	}
+}
+
+// WithProbeInterval sets how long a backend may go unchosen before it is
+// retried to refresh its latency. Defaults to 30 seconds.
+func WithProbeInterval(d time.Duration) LatencyOption {
+	return func(s *LatencyStrategy) {
+		s.probeInterval = d
+	}
+}
+
+// Fastest creates a strategy preferring the currently fastest backend
+func Fastest(opts ...LatencyOption) *LatencyStrategy {
+	s := &LatencyStrategy{
+		smoothing:     defaultSmoothing,
+		errorPenalty:  defaultErrorPenalty,
+		probeInterval: defaultProbeInterval,
+		now:           time.Now,
+		stats:         make(map[string]*latencyStats),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.smoothing <= 0 || s.smoothing > 1 {
+		s.smoothing = defaultSmoothing
+	}
+	return s
+}
+
+// Select implements Strategy
+func (s *LatencyStrategy) Select(_ *types.ChatRequest, _ bool, backends []Backend) (Backend, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+
+	best := -1
+	for i, b := range backends {
+		st := s.stat(b)
+		// Untried and long-unchosen backends are measured before the rest
+		if now.Sub(st.lastTried) > s.probeInterval {
+			best = i
+			break
+		}
+		if st.samples > 0 && (best < 0 || st.average < s.stat(backends[best]).average) {
+			best = i
+		}
+	}
+	// Every backend is awaiting its first measurement
+	if best < 0 {
+		best = 0
+	}
+
+	s.stat(backends[best]).lastTried = now
+	return backends[best], nil
+}
+
+// Observe implements Observer
+func (s *LatencyStrategy) Observe(b Backend, latency time.Duration, err error) {
+	if err != nil {
+		if !degraded(err) {
+			return
+		}
+		latency = s.errorPenalty
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stat(b)
+	if st.samples == 0 {
+		st.average = latency
+	} else {
+		st.average = time.Duration(s.smoothing*float64(latency) + (1-s.smoothing)*float64(st.average))
+	}
+	st.samples++
+}
+
+// Latency returns the moving average latency of a backend, and whether it
+// has been measured
+func (s *LatencyStrategy) Latency(provider, model string) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.stats[key(provider, model)]
+	if !ok || st.samples == 0 {
+		return 0, false
+	}
+	return st.average, true
+}
+
+// stat returns the stats of a backend, creating them if needed. The caller
+// must hold s.mu.
+func (s *LatencyStrategy) stat(b Backend) *latencyStats {
+	k := key(b.Provider, b.Model)
+	st, ok := s.stats[k]
+	if !ok {
+		st = &latencyStats{}
+		s.stats[k] = st
+	}
+	return st
+}
+
+// key identifies a backend by provider and model
+func key(provider, model string) string {
+	return provider + "/" + model
+}
+
+// degraded reports whether err is a failure of the backend rather than of
+// the request
+func degraded(err error) bool {
+	return types.IsTemporary(err)
+}
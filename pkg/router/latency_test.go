@@ -0,0 +1,98 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestFastest(t *testing.T) {
+	backends := []Backend{backend("openai", "gpt-4o"), backend("anthropic", "claude-3-haiku-20240307")}
+	now := time.Now()
+	s := Fastest(WithSmoothing(0.5), WithErrorPenalty(10*time.Second), WithProbeInterval(time.Minute))
+	s.now = func() time.Time { return now }
+
+	selectModel := func() string {
+		b, err := s.Select(request("hi"), false, backends)
+		if err != nil {
+			t.Fatalf("Select() error = %v", err)
+		}
+		return b.Model
+	}
+
+	// Each backend is measured first, in order
+	if got := selectModel(); got != "gpt-4o" {
+		t.Errorf("first Select() = %q, want gpt-4o", got)
+	}
+	s.Observe(backends[0], 2*time.Second, nil)
+	if got := selectModel(); got != "claude-3-haiku-20240307" {
+		t.Errorf("second Select() = %q, want the unmeasured backend", got)
+	}
+	s.Observe(backends[1], time.Second, nil)
+
+	tests := []struct {
+		name    string
+		observe func()
+		want    string
+		wantAvg time.Duration
+	}{
+		{"fastest wins", func() {}, "claude-3-haiku-20240307", time.Second},
+		{"slowdown shifts traffic", func() { s.Observe(backends[1], 5*time.Second, nil) }, "gpt-4o", 3 * time.Second},
+		{"request errors ignored", func() { s.Observe(backends[0], 0, types.ErrInvalidRequest) }, "gpt-4o", 3 * time.Second},
+		{"failures penalized", func() { s.Observe(backends[0], 0, types.ErrOverloaded) }, "claude-3-haiku-20240307", 3 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.observe()
+			if got := selectModel(); got != tt.want {
+				t.Errorf("Select() = %q, want %q", got, tt.want)
+			}
+			if avg, _ := s.Latency("anthropic", "claude-3-haiku-20240307"); avg != tt.wantAvg {
+				t.Errorf("Latency() = %v, want %v", avg, tt.wantAvg)
+			}
+		})
+	}
+
+	// gpt-4o, now at 6s, is retried once it has gone unchosen for a while
+	now = now.Add(2 * time.Minute)
+	if got := selectModel(); got != "gpt-4o" {
+		t.Errorf("Select() after the probe interval = %q, want gpt-4o", got)
+	}
+	if got := selectModel(); got != "claude-3-haiku-20240307" {
+		t.Errorf("Select() after probing = %q, want claude-3-haiku-20240307", got)
+	}
+}
+
+func TestRouter_Observes(t *testing.T) {
+	ctx := context.Background()
+	slow := &fakeUpstream{model: "gpt-4o", err: types.ErrTimeout}
+	fast := &fakeUpstream{model: "gpt-4o-mini"}
+	s := Fastest()
+	r := New([]Backend{
+		{Provider: "openai", Model: "gpt-4o", Client: slow},
+		{Provider: "openai", Model: "gpt-4o-mini", Client: fast},
+	}, WithStrategy(s))
+
+	r.Chat(ctx, request("hi"))
+	stream, err := r.StreamChat(ctx, request("hi"))
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	for range stream {
+	}
+
+	if avg, ok := s.Latency("openai", "gpt-4o"); !ok || avg != defaultErrorPenalty {
+		t.Errorf("Latency() of the failing backend = %v, %v, want the error penalty", avg, ok)
+	}
+	if _, ok := s.Latency("openai", "gpt-4o-mini"); !ok {
+		t.Error("streamed backend was not measured")
+	}
+	for i := 0; i < 3; i++ {
+		if resp, err := r.Chat(ctx, request("hi")); err != nil || resp.Model != "gpt-4o-mini" {
+			t.Errorf("Chat() = %v, %v, want gpt-4o-mini", resp, err)
+		}
+	}
+}
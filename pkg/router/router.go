@@ -0,0 +1,211 @@
+// Package router sends each chat request to one of several backends, chosen
+// per request by a pluggable strategy.
+//
+//	r := router.New([]router.Backend{
+//		{Provider: "openai", Model: "gpt-4o", Client: gpt4o, Latency: 3 * time.Second},
+//		{Provider: "openai", Model: "gpt-4o-mini", Client: mini, Latency: time.Second},
+//		{Provider: "anthropic", Model: "claude-3-5-haiku-20241022", Client: haiku},
+//	}, router.WithStrategy(router.Cheapest(cost.DefaultCatalog(), router.Constraints{
+//		MaxLatency: 2 * time.Second,
+//	})))
+//	resp, err := r.Chat(ctx, req)
+package router
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ksred/llm/pkg/types"
+)
+
+var (
+	ErrNoBackends = errors.New("router has no backends")
+	ErrNoBackend  = errors.New("no backend satisfies the request")
+)
+
+// Upstream sends chat requests to a provider. *client.Client satisfies this
+// interface.
+type Upstream interface {
+	Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error)
+	StreamChat(ctx context.Context, req *types.ChatRequest) (<-chan *types.ChatResponse, error)
+}
+
+// HealthReporter is implemented by upstreams that judge their provider's
+// health. *client.Client satisfies this interface.
+type HealthReporter interface {
+	Status() types.ProviderStatus
+}
+
+// Backend is a model the router may send requests to
+type Backend struct {
+	// Name identifies the backend in routing rules. Empty uses Model.
+	Name string
+	// Provider and Model identify the backend for pricing and model
+	// metadata
+	Provider string
+	Model    string
+	Client   Upstream
+	// Latency is the backend's typical response time, used by strategies
+	// with a latency limit. Zero means unknown.
+	Latency time.Duration
+}
+
+// Strategy chooses the backend for a request. stream reports whether the
+// request will be streamed.
+type Strategy interface {
+	Select(req *types.ChatRequest, stream bool, backends []Backend) (Backend, error)
+}
+
+// Observer is implemented by strategies that learn from the outcome of the
+// requests they route. latency is the time to the response, or to the first
+// chunk of a stream.
+type Observer interface {
+	Observe(b Backend, latency time.Duration, err error)
+}
+
+// StrategyFunc adapts a function to a Strategy
+type StrategyFunc func(req *types.ChatRequest, stream bool, backends []Backend) (Backend, error)
+
+// Select calls f
+func (f StrategyFunc) Select(req *types.ChatRequest, stream bool, backends []Backend) (Backend, error) {
+	return f(req, stream, backends)
+}
+
+// First always chooses the first backend. It is the default strategy.
+func First() Strategy {
+	return StrategyFunc(func(_ *types.ChatRequest, _ bool, backends []Backend) (Backend, error) {
+		return backends[0], nil
+	})
+}
+
+// name returns the name routing rules know the backend by
+func (b Backend) name() string {
+	if b.Name != "" {
+		return b.Name
+	}
+	return b.Model
+}
+
+// Router sends requests to backends chosen by its strategy
+type Router struct {
+	backends    []Backend
+	strategy    Strategy
+	healthCheck bool
+}
+
+// Option configures a Router
+type Option func(*Router)
+
+// WithStrategy sets how backends are chosen. Defaults to First.
+func WithStrategy(s Strategy) Option {
+	return func(r *Router) {
+		r.strategy = s
+	}
+}
+
+// WithHealthCheck keeps requests away from backends whose client reports
+// its provider as unhealthy, as long as another backend is not. Backends
+// whose client is not a HealthReporter are always considered.
+func WithHealthCheck() Option {
+	return func(r *Router) {
+		r.healthCheck = true
+	}
+}
+
+// New creates a router over backends
+func New(backends []Backend, opts ...Option) *Router {
+	r := &Router{
+		backends: append([]Backend(nil), backends...),
+		strategy: First(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Chat sends req to the backend chosen for it
+func (r *Router) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	b, err := r.Select(req, false)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := b.Client.Chat(ctx, req)
+	r.observe(ctx, b, time.Since(start), err)
+	return resp, err
+}
+
+// StreamChat streams req from the backend chosen for it
+func (r *Router) StreamChat(ctx context.Context, req *types.ChatRequest) (<-chan *types.ChatResponse, error) {
+	b, err := r.Select(req, true)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	stream, err := b.Client.StreamChat(ctx, req)
+	if err != nil {
+		r.observe(ctx, b, time.Since(start), err)
+		return nil, err
+	}
+	if _, ok := r.strategy.(Observer); !ok {
+		return stream, nil
+	}
+
+	out := make(chan *types.ChatResponse)
+	go func() {
+		defer close(out)
+		first := true
+		for resp := range stream {
+			if first {
+				r.observe(ctx, b, time.Since(start), resp.Error)
+				first = false
+			}
+			select {
+			case out <- resp:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Select returns the backend req would be sent to, without sending it
+func (r *Router) Select(req *types.ChatRequest, stream bool) (Backend, error) {
+	if len(r.backends) == 0 {
+		return Backend{}, ErrNoBackends
+	}
+	if err := req.Validate(); err != nil {
+		return Backend{}, err
+	}
+	return r.strategy.Select(req, stream, r.available())
+}
+
+// available returns the backends requests may be sent to: with health
+// checks on, those not reported unhealthy, or all of them if every one is
+func (r *Router) available() []Backend {
+	if !r.healthCheck {
+		return r.backends
+	}
+	healthy := make([]Backend, 0, len(r.backends))
+	for _, b := range r.backends {
+		if h, ok := b.Client.(HealthReporter); ok && h.Status().Health == types.HealthUnhealthy {
+			continue
+		}
+		healthy = append(healthy, b)
+	}
+	if len(healthy) == 0 {
+		return r.backends
+	}
+	return healthy
+}
+
+// observe reports a request's outcome to the strategy, unless the caller
+// gave up on it
+func (r *Router) observe(ctx context.Context, b Backend, latency time.Duration, err error) {
+	if obs, ok := r.strategy.(Observer); ok && ctx.Err() == nil {
+		obs.Observe(b, latency, err)
+	}
+}
@@ -0,0 +1,117 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ksred/llm/client"
+	"github.com/ksred/llm/pkg/types"
+)
+
+var _ Upstream = (*client.Client)(nil)
+
+// fakeUpstream answers with its model name, or err if set
+type fakeUpstream struct {
+	model string
+	err   error
+	calls int
+}
+
+func (f *fakeUpstream) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &types.ChatResponse{Response: types.Response{Model: f.model}}, nil
+}
+
+func (f *fakeUpstream) StreamChat(ctx context.Context, req *types.ChatRequest) (<-chan *types.ChatResponse, error) {
+	resp, err := f.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan *types.ChatResponse, 1)
+	ch <- resp
+	close(ch)
+	return ch, nil
+}
+
+func backend(provider, model string) Backend {
+	return Backend{Provider: provider, Model: model, Client: &fakeUpstream{model: model}}
+}
+
+func request(content string, opts ...types.RequestOption) *types.ChatRequest {
+	return types.NewChatRequest([]types.Message{{Role: types.RoleUser, Content: content}}, opts...)
+}
+
+func TestRouter(t *testing.T) {
+	ctx := context.Background()
+	r := New([]Backend{backend("openai", "gpt-4o"), backend("openai", "gpt-4o-mini")})
+
+	resp, err := r.Chat(ctx, request("hi"))
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if resp.Model != "gpt-4o" {
+		t.Errorf("Chat() went to %q, want the first backend", resp.Model)
+	}
+
+	stream, err := r.StreamChat(ctx, request("hi"))
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	if resp := <-stream; resp.Model != "gpt-4o" {
+		t.Errorf("StreamChat() went to %q, want the first backend", resp.Model)
+	}
+
+	if _, err := r.Chat(ctx, &types.ChatRequest{}); !errors.Is(err, types.ErrEmptyMessages) {
+		t.Errorf("Chat() of an invalid request error = %v, want ErrEmptyMessages", err)
+	}
+	if _, err := New(nil).Chat(ctx, request("hi")); !errors.Is(err, ErrNoBackends) {
+		t.Errorf("Chat() without backends error = %v, want ErrNoBackends", err)
+	}
+}
+
+// healthUpstream is a fakeUpstream that reports a fixed health
+type healthUpstream struct {
+	fakeUpstream
+	health types.Health
+}
+
+func (h *healthUpstream) Status() types.ProviderStatus {
+	return types.ProviderStatus{Health: h.health}
+}
+
+var _ HealthReporter = (*client.Client)(nil)
+
+func TestRouter_HealthCheck(t *testing.T) {
+	unhealthy := func(model string) Backend {
+		return Backend{Provider: "openai", Model: model, Client: &healthUpstream{fakeUpstream{model: model}, types.HealthUnhealthy}}
+	}
+	degraded := Backend{Provider: "openai", Model: "gpt-4o-mini", Client: &healthUpstream{fakeUpstream{model: "gpt-4o-mini"}, types.HealthDegraded}}
+
+	tests := []struct {
+		name     string
+		backends []Backend
+		opts     []Option
+		want     string
+	}{
+		{"unhealthy skipped", []Backend{unhealthy("gpt-4o"), degraded}, []Option{WithHealthCheck()}, "gpt-4o-mini"},
+		{"without health check", []Backend{unhealthy("gpt-4o"), degraded}, nil, "gpt-4o"},
+		{"all unhealthy", []Backend{unhealthy("gpt-4o"), unhealthy("gpt-4o-mini")}, []Option{WithHealthCheck()}, "gpt-4o"},
+		{"no reporter", []Backend{backend("openai", "gpt-4o"), degraded}, []Option{WithHealthCheck()}, "gpt-4o"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := New(tt.backends, tt.opts...).Chat(context.Background(), request("hi"))
+			if err != nil {
+				t.Fatalf("Chat() error = %v", err)
+			}
+			if resp.Model != tt.want {
+				t.Errorf("Chat() went to %q, want %q", resp.Model, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/ksred/llm/client"
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// Rule sends requests whose Labels include all of the rule's to the first of
+// Backends, by name, that is available. A rule without labels matches every
+// request.
+type Rule struct {
+	Labels   map[string]string
+	Backends []string
+}
+
+// matches reports whether the request carries all of the rule's labels
+func (r Rule) matches(req *types.ChatRequest) bool {
+	for k, v := range r.Labels {
+		if req.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// RuleStrategy chooses backends by the first matching rule. Its rules can
+// be replaced while it routes requests.
+type RuleStrategy struct {
+	rules    atomic.Pointer[[]Rule]
+	fallback Strategy
+}
+
+// Rules chooses each request's backend by the first rule that matches it.
+// Requests no rule matches, or whose rule names no available backend, are
+// left to fallback, which defaults to First.
+func Rules(rules []Rule, fallback Strategy) *RuleStrategy {
+	if fallback == nil {
+		fallback = First()
+	}
+	s := &RuleStrategy{fallback: fallback}
+	s.SetRules(rules)
+	return s
+}
+
+// SetRules replaces the rules for requests routed afterwards
+func (s *RuleStrategy) SetRules(rules []Rule) {
+	rules = append([]Rule(nil), rules...)
+	s.rules.Store(&rules)
+}
+
+// Select implements Strategy
+func (s *RuleStrategy) Select(req *types.ChatRequest, stream bool, backends []Backend) (Backend, error) {
+	for _, rule := range *s.rules.Load() {
+		if !rule.matches(req) {
+			continue
+		}
+		for _, name := range rule.Backends {
+			for _, b := range backends {
+				if b.name() == name {
+					return b, nil
+				}
+			}
+		}
+		break
+	}
+	return s.fallback.Select(req, stream, backends)
+}
+
+// Observe passes the outcome on to the fallback strategy if it learns from
+// outcomes
+func (s *RuleStrategy) Observe(b Backend, latency time.Duration, err error) {
+	if obs, ok := s.fallback.(Observer); ok {
+		obs.Observe(b, latency, err)
+	}
+}
+
+// Reloader is implemented by upstreams whose settings can be changed while
+// they run. *client.Client satisfies this interface.
+type Reloader interface {
+	Reload(cfg *config.Config) error
+}
+
+// Reload applies a changed configuration file, such as one passed by
+// config.WatchFile, to a running router. Backends named as providers in the
+// file reload their settings, such as pricing and rate limits, if their
+// client is a Reloader, and a RuleStrategy takes the file's routes. Errors
+// are joined, and do not stop the other backends reloading. Providers added
+// to or removed from the file take effect with a new router.
+func (r *Router) Reload(f *config.File) error {
+	var errs []error
+	for _, b := range r.backends {
+		rl, ok := b.Client.(Reloader)
+		if !ok || b.Name == "" || f.Providers[b.Name] == nil {
+			continue
+		}
+		cfg, err := f.Config(b.Name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := rl.Reload(cfg); err != nil {
+			errs = append(errs, fmt.Errorf("provider %q: %w", b.Name, err))
+		}
+	}
+	if rs, ok := r.strategy.(*RuleStrategy); ok {
+		rs.SetRules(fileRules(f))
+	}
+	return errors.Join(errs...)
+}
+
+// FromFile creates a router with a client for each provider in a
+// configuration file, named as in the file, that routes requests by the
+// file's routes. Requests no route matches go to the file's default
+// provider. opts are applied afterwards, so WithStrategy replaces the
+// file's routes.
+func FromFile(f *config.File, opts ...Option) (*Router, error) {
+	var backends []Backend
+	for _, name := range f.Names() {
+		b, err := fileBackend(f, name)
+		if err != nil {
+			for _, b := range backends {
+				b.Client.(*client.Client).Close()
+			}
+			return nil, err
+		}
+		if name == f.Default {
+			backends = append([]Backend{b}, backends...)
+		} else {
+			backends = append(backends, b)
+		}
+	}
+
+	return New(backends, append([]Option{WithStrategy(Rules(fileRules(f), First()))}, opts...)...), nil
+}
+
+// fileRules returns the routes of a configuration file as rules
+func fileRules(f *config.File) []Rule {
+	rules := make([]Rule, len(f.Routes))
+	for i, r := range f.Routes {
+		rules[i] = Rule{Labels: r.Labels, Backends: r.Providers}
+	}
+	return rules
+}
+
+// fileBackend creates the backend for a provider of a configuration file
+func fileBackend(f *config.File, name string) (Backend, error) {
+	cfg, err := f.Config(name)
+	if err != nil {
+		return Backend{}, err
+	}
+	c, err := client.NewClient(cfg)
+	if err != nil {
+		return Backend{}, fmt.Errorf("provider %q: %w", name, err)
+	}
+	return Backend{Name: name, Provider: cfg.Provider, Model: cfg.Model, Client: c}, nil
+}
@@ -0,0 +1,125 @@
+package router
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/types"
+)
+
+func TestRules(t *testing.T) {
+	backends := []Backend{
+		backend("openai", "gpt-4o"),
+		{Name: "cheap", Provider: "openai", Model: "gpt-4o-mini", Client: &fakeUpstream{model: "gpt-4o-mini"}},
+		backend("anthropic", "claude-3-5-haiku-20241022"),
+	}
+	strategy := Rules([]Rule{
+		{Labels: map[string]string{"tier": "free"}, Backends: []string{"cheap"}},
+		{Labels: map[string]string{"tier": "batch"}, Backends: []string{"missing", "claude-3-5-haiku-20241022"}},
+		{Labels: map[string]string{"tier": "other"}, Backends: []string{"missing"}},
+	}, nil)
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{"by name", map[string]string{"tier": "free", "team": "a"}, "gpt-4o-mini"},
+		{"by model, skipping missing", map[string]string{"tier": "batch"}, "claude-3-5-haiku-20241022"},
+		{"no backend falls back", map[string]string{"tier": "other"}, "gpt-4o"},
+		{"no match falls back", nil, "gpt-4o"},
+	}
+
+	r := New(backends, WithStrategy(strategy))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := request("hi")
+			req.Labels = tt.labels
+			resp, err := r.Chat(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Chat() error = %v", err)
+			}
+			if resp.Model != tt.want {
+				t.Errorf("Chat() went to %q, want %q", resp.Model, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromFile(t *testing.T) {
+	f, err := config.ParseFile([]byte(`
+default: primary
+providers:
+  cheap: {provider: openai, model: gpt-4o-mini, api_key: k}
+  primary: {provider: openai, model: gpt-4o, api_key: k}
+routes:
+  - labels: {tier: free}
+    providers: [cheap]
+`))
+	if err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+	r, err := FromFile(f)
+	if err != nil {
+		t.Fatalf("FromFile() error = %v", err)
+	}
+
+	free := request("hi")
+	free.Labels = map[string]string{"tier": "free"}
+	for req, want := range map[*types.ChatRequest]string{free: "cheap", request("hi"): "primary"} {
+		b, err := r.Select(req, false)
+		if err != nil {
+			t.Fatalf("Select() error = %v", err)
+		}
+		if b.Name != want {
+			t.Errorf("Select(%v) = %q, want %q", req.Labels, b.Name, want)
+		}
+	}
+}
+
+// reloadUpstream records the configurations it is reloaded with
+type reloadUpstream struct {
+	fakeUpstream
+	reloaded []*config.Config
+}
+
+func (u *reloadUpstream) Reload(cfg *config.Config) error {
+	u.reloaded = append(u.reloaded, cfg)
+	return nil
+}
+
+func TestRouter_Reload(t *testing.T) {
+	main := &reloadUpstream{fakeUpstream: fakeUpstream{model: "gpt-4o"}}
+	cheap := &reloadUpstream{fakeUpstream: fakeUpstream{model: "gpt-4o-mini"}}
+	r := New([]Backend{
+		{Name: "main", Provider: "openai", Model: "gpt-4o", Client: main},
+		{Name: "cheap", Provider: "openai", Model: "gpt-4o-mini", Client: cheap},
+		backend("openai", "gpt-3.5-turbo"),
+	}, WithStrategy(Rules(nil, nil)))
+
+	f, err := config.ParseFile([]byte(`
+providers:
+  main: {provider: openai, model: gpt-4o, api_key: k, rate_limit: {requests_per_minute: 100}}
+  cheap: {provider: openai, model: gpt-4o-mini, api_key: k}
+  added: {provider: openai, model: gpt-4.1, api_key: k}
+routes:
+  - labels: {tier: free}
+    providers: [cheap]
+`))
+	if err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+	if err := r.Reload(f); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	if len(main.reloaded) != 1 || main.reloaded[0].RateLimit.RequestsPerMinute != 100 || len(cheap.reloaded) != 1 {
+		t.Errorf("reloaded main %d times and cheap %d times, want once each with the file's settings", len(main.reloaded), len(cheap.reloaded))
+	}
+	free := request("hi")
+	free.Labels = map[string]string{"tier": "free"}
+	if b, err := r.Select(free, false); err != nil || b.Name != "cheap" {
+		t.Errorf("Select() = %q, %v, want the reloaded route to cheap", b.Name, err)
+	}
+}
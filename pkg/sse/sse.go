@@ -0,0 +1,182 @@
+// Package sse reads server-sent event streams, as sent by streaming LLM
+// APIs. It follows the WHATWG event stream format. It handles named events,
+// data split across lines, comments, LF and CRLF line endings, and lines of
+// any length.
+//
+//	r := sse.NewReader(resp.Body)
+//	for {
+//		event, err := r.Next()
+//		if err == io.EOF {
+//			break
+//		}
+//		if err != nil {
+//			return err
+//		}
+//		handle(event.Type, event.Data)
+//	}
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultBufferSize is the size of a Reader's read buffer. Longer lines
+	// are read in several pieces.
+	DefaultBufferSize = 64 << 10
+	// DefaultMaxEventSize is the largest event a Reader accepts by default
+	DefaultMaxEventSize = 16 << 20
+)
+
+// ErrEventTooLarge is returned when an event exceeds the maximum size. The
+// stream cannot be read further.
+var ErrEventTooLarge = errors.New("sse: event too large")
+
+// Event is one server-sent event
+type Event struct {
+	// Type is the "event:" field, empty if the stream did not name the
+	// event
+	Type string
+	// Data is the event's "data:" fields joined by newlines
+	Data string
+	// ID is the "id:" field
+	ID string
+	// Retry is the reconnection time from the "retry:" field
+	Retry time.Duration
+}
+
+// Reader reads events from a stream
+type Reader struct {
+	r            *bufio.Reader
+	bufferSize   int
+	maxEventSize int
+}
+
+// Option configures a Reader
+type Option func(*Reader)
+
+// WithBufferSize sets the size of the read buffer. Defaults to
+// DefaultBufferSize.
+func WithBufferSize(n int) Option {
+	return func(r *Reader) {
+		if n > 0 {
+			r.bufferSize = n
+		}
+	}
+}
+
+// WithMaxEventSize sets the largest event, counting every line of it, that
+// the reader accepts. Defaults to DefaultMaxEventSize. A negative size
+// removes the limit.
+func WithMaxEventSize(n int) Option {
+	return func(r *Reader) {
+		if n != 0 {
+			r.maxEventSize = n
+		}
+	}
+}
+
+// NewReader creates a reader of the events in r
+func NewReader(r io.Reader, opts ...Option) *Reader {
+	reader := &Reader{bufferSize: DefaultBufferSize, maxEventSize: DefaultMaxEventSize}
+	for _, opt := range opts {
+		opt(reader)
+	}
+	reader.r = bufio.NewReaderSize(r, reader.bufferSize)
+	return reader
+}
+
+// Next returns the next event. Comments and events without data are
+// skipped. It returns io.EOF when the stream ends. A final event not
+// followed by a blank line is still returned.
+func (r *Reader) Next() (*Event, error) {
+	var event Event
+	var data []string
+	hasData := false
+	size := 0
+
+	for {
+		line, err := r.readLine(size)
+		size += len(line)
+		if err == io.EOF && len(line) > 0 {
+			// Treat the unterminated last line as complete
+			err = nil
+		}
+		if err != nil {
+			if err == io.EOF && hasData {
+				event.Data = strings.Join(data, "\n")
+				return &event, nil
+			}
+			return nil, err
+		}
+
+		if len(line) == 0 {
+			if hasData {
+				event.Data = strings.Join(data, "\n")
+				return &event, nil
+			}
+			// A blank line ends an event with no data, which is not
+			// dispatched
+			event, size = Event{}, 0
+			continue
+		}
+		if line[0] == ':' {
+			size -= len(line)
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			event.Type = value
+		case "data":
+			data = append(data, value)
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				event.ID = value
+			}
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+				event.Retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// readLine returns the next line without its line ending. Lines may be of
+// any length that keeps the event, of which used bytes have been read,
+// within the maximum size.
+func (r *Reader) readLine(used int) (string, error) {
+	var buf []byte
+	for {
+		chunk, err := r.r.ReadSlice('\n')
+		buf = append(buf, chunk...)
+		if r.maxEventSize > 0 && used+len(buf) > r.maxEventSize+2 {
+			// The allowance covers the line ending
+			return "", fmt.Errorf("%w: over %d bytes", ErrEventTooLarge, r.maxEventSize)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		line := bytes.TrimSuffix(buf, []byte("\n"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if err == io.EOF {
+			return string(line), io.EOF
+		}
+		return string(line), nil
+	}
+}
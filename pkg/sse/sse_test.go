@@ -0,0 +1,192 @@
+package sse
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func readAll(t *testing.T, r *Reader) ([]Event, error) {
+	t.Helper()
+	var events []Event
+	for {
+		event, err := r.Next()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, *event)
+	}
+}
+
+func TestReader(t *testing.T) {
+	long := strings.Repeat("x", 200<<10)
+
+	tests := []struct {
+		name   string
+		stream string
+		want   []Event
+	}{
+		{
+			name:   "unnamed events",
+			stream: "data: {\"a\":1}\n\ndata: [DONE]\n\n",
+			want:   []Event{{Data: `{"a":1}`}, {Data: "[DONE]"}},
+		},
+		{
+			name:   "named events",
+			stream: "event: ping\ndata: {\"type\":\"ping\"}\n\nevent: message_stop\ndata: {}\n\n",
+			want:   []Event{{Type: "ping", Data: `{"type":"ping"}`}, {Type: "message_stop", Data: "{}"}},
+		},
+		{
+			name:   "multi-line data",
+			stream: "data: line one\ndata: line two\n\n",
+			want:   []Event{{Data: "line one\nline two"}},
+		},
+		{
+			name:   "comments skipped",
+			stream: ": keep-alive\n\ndata: hi\n: mid-event comment\n\n",
+			want:   []Event{{Data: "hi"}},
+		},
+		{
+			name:   "CRLF line endings",
+			stream: "event: delta\r\ndata: hi\r\n\r\n",
+			want:   []Event{{Type: "delta", Data: "hi"}},
+		},
+		{
+			name:   "no space after colon",
+			stream: "event:delta\ndata:hi\n\n",
+			want:   []Event{{Type: "delta", Data: "hi"}},
+		},
+		{
+			name:   "id and retry",
+			stream: "id: 7\nretry: 1500\ndata: hi\n\n",
+			want:   []Event{{ID: "7", Retry: 1500 * time.Millisecond, Data: "hi"}},
+		},
+		{
+			name:   "event without data not dispatched",
+			stream: "event: empty\n\ndata: hi\n\n",
+			want:   []Event{{Data: "hi"}},
+		},
+		{
+			name:   "unterminated final event",
+			stream: "data: partial",
+			want:   []Event{{Data: "partial"}},
+		},
+		{
+			name:   "long line",
+			stream: "data: " + long + "\n\n",
+			want:   []Event{{Data: long}},
+		},
+		{
+			name:   "empty stream",
+			stream: "",
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Reading a byte at a time exercises lines split across reads
+			got, err := readAll(t, NewReader(iotest.OneByteReader(strings.NewReader(tt.stream))))
+			if err != nil {
+				t.Fatalf("Next() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				if len(tt.stream) > 100 {
+					t.Errorf("got %d events, want %d", len(got), len(tt.want))
+				} else {
+					t.Errorf("events = %+v, want %+v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReader_Error(t *testing.T) {
+	boom := errors.New("connection reset")
+	r := NewReader(io.MultiReader(strings.NewReader("data: one\n\ndata: tw"), iotest.ErrReader(boom)))
+
+	events, err := readAll(t, r)
+	if !errors.Is(err, boom) {
+		t.Errorf("Next() error = %v, want %v", err, boom)
+	}
+	if len(events) != 1 || events[0].Data != "one" {
+		t.Errorf("events = %+v, want the one before the error", events)
+	}
+}
+
+func TestReader_MaxEventSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  string
+		opts    []Option
+		want    int
+		wantErr error
+	}{
+		{
+			name:   "event within limit",
+			stream: "data: " + strings.Repeat("x", 100) + "\n\n",
+			opts:   []Option{WithMaxEventSize(200)},
+			want:   1,
+		},
+		{
+			name:    "long line over limit",
+			stream:  "data: " + strings.Repeat("x", 300) + "\n\n",
+			opts:    []Option{WithMaxEventSize(200)},
+			wantErr: ErrEventTooLarge,
+		},
+		{
+			name:    "many lines over limit",
+			stream:  strings.Repeat("data: "+strings.Repeat("x", 50)+"\n", 5) + "\n",
+			opts:    []Option{WithMaxEventSize(200)},
+			wantErr: ErrEventTooLarge,
+		},
+		{
+			name:   "limit applies per event",
+			stream: strings.Repeat("data: "+strings.Repeat("x", 150)+"\n\n", 3),
+			opts:   []Option{WithMaxEventSize(200)},
+			want:   3,
+		},
+		{
+			name:   "comments not counted",
+			stream: strings.Repeat(": "+strings.Repeat("x", 150)+"\n", 3) + "data: hi\n\n",
+			opts:   []Option{WithMaxEventSize(200)},
+			want:   1,
+		},
+		{
+			name:   "small buffer",
+			stream: "data: " + strings.Repeat("x", 1000) + "\n\n",
+			opts:   []Option{WithBufferSize(16)},
+			want:   1,
+		},
+		{
+			name:   "no limit",
+			stream: "data: " + strings.Repeat("x", DefaultMaxEventSize+1) + "\n\n",
+			opts:   []Option{WithMaxEventSize(-1)},
+			want:   1,
+		},
+		{
+			name:    "default limit",
+			stream:  "data: " + strings.Repeat("x", DefaultMaxEventSize+1) + "\n\n",
+			wantErr: ErrEventTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := readAll(t, NewReader(strings.NewReader(tt.stream), tt.opts...))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Next() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(events) != tt.want {
+				t.Errorf("got %d events, want %d", len(events), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package tenant
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ksred/llm/pkg/cost"
+)
+
+// Quota caps a tenant's requests and tokens in each period. A zero limit
+// disables that check.
+//
+// Requests count when they are admitted, whether or not they succeed.
+// Tokens count when a request finishes, so a request admitted just under
+// the token limit may take the tenant over it.
+type Quota struct {
+	// Period defaults to cost.CalendarDay
+	Period   cost.Period
+	Requests int
+	Tokens   int
+}
+
+// QuotaError reports the quota a request would exceed
+type QuotaError struct {
+	Tenant string
+	// Resource is "requests" or "tokens"
+	Resource string
+	Used     int
+	Limit    int
+	// ResetAt is when the quota's period ends
+	ResetAt time.Time
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s: tenant %s has used %d of %d %s until %s",
+		ErrQuotaExceeded, e.Tenant, e.Used, e.Limit, e.Resource, e.ResetAt.Format(time.RFC3339))
+}
+
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// QuotaUsage is a tenant's use of one quota in the current period
+type QuotaUsage struct {
+	PeriodStart  time.Time `json:"period_start"`
+	PeriodEnd    time.Time `json:"period_end"`
+	Requests     int       `json:"requests"`
+	RequestLimit int       `json:"request_limit,omitempty"`
+	Tokens       int       `json:"tokens"`
+	TokenLimit   int       `json:"token_limit,omitempty"`
+}
+
+// quotaState is the usage of one quota in its current period
+type quotaState struct {
+	quota    Quota
+	start    time.Time
+	end      time.Time
+	requests int
+	tokens   int
+}
+
+// roll starts a new period if now is past the current one
+func (q *quotaState) roll(now time.Time) {
+	if now.Before(q.end) {
+		return
+	}
+	q.start, q.end = q.quota.Period(now)
+	q.requests, q.tokens = 0, 0
+}
+
+// admit counts a request against the tenant's quotas, or returns a
+// *QuotaError if any quota is used up
+func (m *Manager) admit(e *entry) error {
+	now := m.now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, q := range e.quotas {
+		q.roll(now)
+		if q.quota.Requests > 0 && q.requests >= q.quota.Requests {
+			return &QuotaError{Tenant: e.tenant.ID, Resource: "requests", Used: q.requests, Limit: q.quota.Requests, ResetAt: q.end}
+		}
+		if q.quota.Tokens > 0 && q.tokens >= q.quota.Tokens {
+			return &QuotaError{Tenant: e.tenant.ID, Resource: "tokens", Used: q.tokens, Limit: q.quota.Tokens, ResetAt: q.end}
+		}
+	}
+	for _, q := range e.quotas {
+		q.requests++
+	}
+	return nil
+}
+
+// charge counts tokens against the tenant's quotas
+func (m *Manager) charge(e *entry, tokens int) {
+	now := m.now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, q := range e.quotas {
+		q.roll(now)
+		q.tokens += tokens
+	}
+}
+
+// QuotaUsage returns the tenant's usage of each of its quotas, in the order
+// they were configured
+func (m *Manager) QuotaUsage(id string) ([]QuotaUsage, error) {
+	e, err := m.entry(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	usage := make([]QuotaUsage, len(e.quotas))
+	for i, q := range e.quotas {
+		q.roll(now)
+		usage[i] = QuotaUsage{
+			PeriodStart:  q.start,
+			PeriodEnd:    q.end,
+			Requests:     q.requests,
+			RequestLimit: q.quota.Requests,
+			Tokens:       q.tokens,
+			TokenLimit:   q.quota.Tokens,
+		}
+	}
+	return usage, nil
+}
+
+// ResetQuota sets the tenant's usage of every quota back to zero for the
+// rest of the current period
+func (m *Manager) ResetQuota(id string) error {
+	e, err := m.entry(id)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, q := range e.quotas {
+		q.requests, q.tokens = 0, 0
+	}
+	return nil
+}
+
+// entry returns the registered tenant with the given ID
+func (m *Manager) entry(id string) (*entry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.tenants[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTenant, id)
+	}
+	return e, nil
+}
+
+// AdminHandler returns an HTTP API for inspecting and resetting quotas:
+//
+//	GET  /tenants/{id}/quotas        the tenant's QuotaUsage list
+//	POST /tenants/{id}/quotas/reset  ResetQuota
+//
+// It does no authentication of its own, so mount it behind the
+// application's admin authentication.
+func (m *Manager) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, "/tenants/")
+		if !ok {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		id, action, _ := strings.Cut(rest, "/")
+
+		var err error
+		switch {
+		case action == "quotas" && r.Method == http.MethodGet:
+			var usage []QuotaUsage
+			if usage, err = m.QuotaUsage(id); err == nil {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(usage)
+				return
+			}
+		case action == "quotas/reset" && r.Method == http.MethodPost:
+			if err = m.ResetQuota(id); err == nil {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		case action == "quotas" || action == "quotas/reset":
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		default:
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+
+		if errors.Is(err, ErrUnknownTenant) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{"message": message}})
+}
@@ -0,0 +1,164 @@
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// usageClient reports 40 tokens used per request
+type usageClient struct {
+	fakeClient
+}
+
+func (u *usageClient) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	return &types.ChatResponse{Response: types.Response{Usage: types.Usage{TotalTokens: 40}}}, nil
+}
+
+func TestManager_Quotas(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	m := NewManager(&config.Config{Model: "gpt-4o"}, WithClientFactory(func(cfg *config.Config) (Upstream, error) {
+		return &usageClient{}, nil
+	}))
+	m.now = func() time.Time { return now }
+	m.Add(Tenant{ID: "acme", Quotas: []Quota{{Period: cost.CalendarMonth, Tokens: 100}}})
+	m.Add(Tenant{ID: "globex", Quotas: []Quota{{Requests: 2}}})
+
+	tests := []struct {
+		name         string
+		tenant       string
+		advance      time.Duration
+		wantErr      error
+		wantResource string
+	}{
+		{"first tokens", "acme", 0, nil, ""},
+		{"more tokens", "acme", 0, nil, ""},
+		{"tokens reach the quota", "acme", 0, nil, ""},
+		{"tokens used up", "acme", 0, ErrQuotaExceeded, "tokens"},
+		{"tokens still used up the next day", "acme", 24 * time.Hour, ErrQuotaExceeded, "tokens"},
+		{"tokens in a new month", "acme", 30 * 24 * time.Hour, nil, ""},
+		{"requests", "globex", 0, nil, ""},
+		{"requests reach the quota", "globex", 0, nil, ""},
+		{"requests used up", "globex", 0, ErrQuotaExceeded, "requests"},
+		{"requests on a new day", "globex", 24 * time.Hour, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+			_, err := m.Chat(WithTenant(context.Background(), tt.tenant), request())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Chat() error = %v, want %v", err, tt.wantErr)
+			}
+			var qe *QuotaError
+			if tt.wantErr != nil && (!errors.As(err, &qe) || qe.Resource != tt.wantResource) {
+				t.Errorf("Chat() error = %#v, want a %s QuotaError", err, tt.wantResource)
+			}
+		})
+	}
+
+	usage, err := m.QuotaUsage("acme")
+	if err != nil {
+		t.Fatalf("QuotaUsage() error = %v", err)
+	}
+	if len(usage) != 1 || usage[0].Requests != 1 || usage[0].Tokens != 40 || usage[0].TokenLimit != 100 {
+		t.Errorf("QuotaUsage() = %+v", usage)
+	}
+	if !usage[0].PeriodStart.Equal(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("monthly period starts %v, want April 1st", usage[0].PeriodStart)
+	}
+
+	ctx := WithTenant(context.Background(), "globex")
+	m.Chat(ctx, request())
+	if _, err := m.Chat(ctx, request()); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Chat() error = %v, want ErrQuotaExceeded", err)
+	}
+	if err := m.ResetQuota("globex"); err != nil {
+		t.Fatalf("ResetQuota() error = %v", err)
+	}
+	if _, err := m.Chat(ctx, request()); err != nil {
+		t.Errorf("Chat() after ResetQuota() error = %v", err)
+	}
+	if _, err := m.QuotaUsage("initech"); !errors.Is(err, ErrUnknownTenant) {
+		t.Errorf("QuotaUsage() of an unknown tenant error = %v, want ErrUnknownTenant", err)
+	}
+}
+
+// splitUsageClient reports usage without a total, as Anthropic does
+type splitUsageClient struct {
+	fakeClient
+}
+
+func (u *splitUsageClient) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	return &types.ChatResponse{Response: types.Response{Usage: types.Usage{PromptTokens: 30, CompletionTokens: 10}}}, nil
+}
+
+func TestManager_QuotasWithoutTotal(t *testing.T) {
+	m := NewManager(&config.Config{Model: "claude-3-5-sonnet-20241022"}, WithClientFactory(func(cfg *config.Config) (Upstream, error) {
+		return &splitUsageClient{}, nil
+	}))
+	m.Add(Tenant{ID: "acme", Quotas: []Quota{{Tokens: 100}}})
+
+	ctx := WithTenant(context.Background(), "acme")
+	for i := 0; i < 3; i++ {
+		if _, err := m.Chat(ctx, request()); err != nil {
+			t.Fatalf("Chat() #%d error = %v", i+1, err)
+		}
+	}
+	if _, err := m.Chat(ctx, request()); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Chat() after 120 tokens error = %v, want ErrQuotaExceeded", err)
+	}
+	if usage, _ := m.QuotaUsage("acme"); len(usage) != 1 || usage[0].Tokens != 120 {
+		t.Errorf("QuotaUsage() = %+v, want 120 tokens", usage)
+	}
+}
+
+func TestManager_AdminHandler(t *testing.T) {
+	m, _ := newTestManager(t, &config.Config{Model: "gpt-4o"})
+	m.Add(Tenant{ID: "acme", APIKey: "sk-acme", Quotas: []Quota{{Requests: 10}}})
+	m.Chat(WithTenant(context.Background(), "acme"), request())
+	h := m.AdminHandler()
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		wantStatus   int
+		wantRequests int
+	}{
+		{"inspect", http.MethodGet, "/tenants/acme/quotas", http.StatusOK, 1},
+		{"reset", http.MethodPost, "/tenants/acme/quotas/reset", http.StatusNoContent, 0},
+		{"inspect after reset", http.MethodGet, "/tenants/acme/quotas", http.StatusOK, 0},
+		{"unknown tenant", http.MethodGet, "/tenants/initech/quotas", http.StatusNotFound, 0},
+		{"wrong method", http.MethodDelete, "/tenants/acme/quotas", http.StatusMethodNotAllowed, 0},
+		{"unknown path", http.MethodGet, "/tenants/acme", http.StatusNotFound, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var usage []QuotaUsage
+			if err := json.NewDecoder(rec.Body).Decode(&usage); err != nil {
+				t.Fatalf("decoding usage: %v", err)
+			}
+			if len(usage) != 1 || usage[0].Requests != tt.wantRequests {
+				t.Errorf("usage = %+v, want %d requests", usage, tt.wantRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+// Package tenant lets one service instance safely serve many customers.
+// Each tenant has its own provider API key, rate limits, budget and allowed
+// models, and the tenant of a request is resolved from its context.
+//
+//	m := tenant.NewManager(baseConfig)
+//	defer m.Close()
+//	m.Add(tenant.Tenant{ID: "acme", APIKey: acmeKey, RequestsPerMinute: 60, MaxCostPerDay: 20})
+//
+//	ctx = tenant.WithTenant(ctx, "acme")
+//	resp, err := m.Chat(ctx, req)
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ksred/llm/client"
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/internal/tokenizer"
+	"github.com/ksred/llm/pkg/audit"
+	"github.com/ksred/llm/pkg/cache"
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/types"
+)
+
+var (
+	ErrNoTenant        = errors.New("no tenant in context")
+	ErrUnknownTenant   = errors.New("unknown tenant")
+	ErrModelNotAllowed = errors.New("model not allowed for tenant")
+	ErrQuotaExceeded   = errors.New("tenant quota exceeded")
+)
+
+// Upstream sends chat requests to a provider. *client.Client satisfies this
+// interface.
+type Upstream interface {
+	Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error)
+	StreamChat(ctx context.Context, req *types.ChatRequest) (<-chan *types.ChatResponse, error)
+}
+
+// Tenant is one customer's settings. Zero limits fall back to those of the
+// manager's base configuration.
+type Tenant struct {
+	ID string
+	// APIKey is the tenant's own provider API key. It replaces the base
+	// configuration's keys and token source.
+	APIKey string
+
+	RequestsPerMinute int
+	TokensPerMinute   int
+
+	// MaxCostPerRequest and MaxCostPerDay are in the base configuration's
+	// budget currency, US dollars by default
+	MaxCostPerRequest float64
+	MaxCostPerDay     float64
+
+	// Models lists the models the tenant may use, as exact names or
+	// path.Match patterns such as "gpt-4o*". Empty allows all.
+	Models []string
+
+	// Quotas cap the tenant's requests and tokens per period
+	Quotas []Quota
+
+	// CostTracker and Audit record the tenant's usage and requests. The
+	// base configuration's are not shared between tenants, so nil leaves
+	// the tenant untracked or unaudited.
+	CostTracker *cost.CostTracker
+	Audit       *audit.Auditor
+}
+
+// allows reports whether the tenant may use model
+func (t Tenant) allows(model string) bool {
+	if len(t.Models) == 0 {
+		return true
+	}
+	for _, pattern := range t.Models {
+		if ok, _ := path.Match(pattern, model); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a context whose requests belong to the tenant with
+// the given ID. The ID is also set as the LabelTenant label, so usage and
+// cost are attributed to the tenant.
+func WithTenant(ctx context.Context, id string) context.Context {
+	ctx = types.WithLabels(ctx, map[string]string{types.LabelTenant: id})
+	return context.WithValue(ctx, tenantKey{}, id)
+}
+
+// FromContext returns the tenant ID set with WithTenant, or else the
+// context's LabelTenant label
+func FromContext(ctx context.Context) (string, bool) {
+	if id, ok := ctx.Value(tenantKey{}).(string); ok && id != "" {
+		return id, true
+	}
+	id := types.LabelsFromContext(ctx)[types.LabelTenant]
+	return id, id != ""
+}
+
+// entry is a registered tenant, its client and its quota usage
+type entry struct {
+	tenant Tenant
+	client Upstream
+
+	mu     sync.Mutex
+	quotas []*quotaState
+}
+
+// Manager sends each request through the client of the tenant it belongs to
+type Manager struct {
+	base      *config.Config
+	newClient func(*config.Config) (Upstream, error)
+	now       func() time.Time
+
+	mu      sync.RWMutex
+	tenants map[string]*entry
+}
+
+// Option configures a Manager
+type Option func(*Manager)
+
+// WithClientFactory sets how tenant clients are created from their
+// configuration. Defaults to client.NewClient.
+func WithClientFactory(fn func(*config.Config) (Upstream, error)) Option {
+	return func(m *Manager) {
+		m.newClient = fn
+	}
+}
+
+// NewManager creates a manager whose tenants' clients are built from base
+func NewManager(base *config.Config, opts ...Option) *Manager {
+	m := &Manager{
+		base: base,
+		newClient: func(cfg *config.Config) (Upstream, error) {
+			return client.NewClient(cfg)
+		},
+		now:     time.Now,
+		tenants: make(map[string]*entry),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Add registers a tenant, replacing any with the same ID. The replaced
+// tenant's client is closed and its quota usage starts again from zero.
+func (m *Manager) Add(t Tenant) error {
+	if t.ID == "" {
+		return fmt.Errorf("tenant ID is required")
+	}
+	t.Models = append([]string(nil), t.Models...)
+	t.Quotas = append([]Quota(nil), t.Quotas...)
+	quotas := make([]*quotaState, len(t.Quotas))
+	for i, q := range t.Quotas {
+		if q.Period == nil {
+			t.Quotas[i].Period = cost.CalendarDay
+		}
+		quotas[i] = &quotaState{quota: t.Quotas[i]}
+	}
+
+	c, err := m.newClient(m.configFor(t))
+	if err != nil {
+		return fmt.Errorf("creating client for tenant %s: %w", t.ID, err)
+	}
+
+	m.mu.Lock()
+	old := m.tenants[t.ID]
+	m.tenants[t.ID] = &entry{tenant: t, client: c, quotas: quotas}
+	m.mu.Unlock()
+
+	if old != nil {
+		closeClient(old.client)
+	}
+	return nil
+}
+
+// Remove unregisters a tenant and closes its client
+func (m *Manager) Remove(id string) {
+	m.mu.Lock()
+	old := m.tenants[id]
+	delete(m.tenants, id)
+	m.mu.Unlock()
+
+	if old != nil {
+		closeClient(old.client)
+	}
+}
+
+// Get returns the tenant with the given ID
+func (m *Manager) Get(id string) (Tenant, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.tenants[id]
+	if !ok {
+		return Tenant{}, false
+	}
+	return e.tenant, true
+}
+
+// Chat sends req with the client of the context's tenant
+func (m *Manager) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	ctx, e, err := m.resolve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.admit(e); err != nil {
+		return nil, err
+	}
+	resp, err := e.client.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	m.charge(e, resp.Usage.Total())
+	return resp, nil
+}
+
+// StreamChat streams req with the client of the context's tenant. Since
+// providers do not reliably report usage on streams, the request's
+// estimated tokens count against the tenant's quotas.
+func (m *Manager) StreamChat(ctx context.Context, req *types.ChatRequest) (<-chan *types.ChatResponse, error) {
+	ctx, e, err := m.resolve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.admit(e); err != nil {
+		return nil, err
+	}
+	stream, err := e.client.StreamChat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	m.charge(e, tokenizer.EstimateChat(req))
+	return stream, nil
+}
+
+// Close closes every tenant's client
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	tenants := m.tenants
+	m.tenants = make(map[string]*entry)
+	m.mu.Unlock()
+
+	for _, e := range tenants {
+		closeClient(e.client)
+	}
+	return nil
+}
+
+// resolve finds the context's tenant and checks it may use req's model. The
+// returned context carries the tenant's label.
+func (m *Manager) resolve(ctx context.Context, req *types.ChatRequest) (context.Context, *entry, error) {
+	id, ok := FromContext(ctx)
+	if !ok {
+		return ctx, nil, ErrNoTenant
+	}
+
+	e, err := m.entry(id)
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	model := m.base.Model
+	if override, ok := req.ProviderParams["model"].(string); ok {
+		model = override
+	}
+	if !e.tenant.allows(model) {
+		return ctx, nil, fmt.Errorf("%w: %s may not use %s", ErrModelNotAllowed, id, model)
+	}
+	return WithTenant(ctx, id), e, nil
+}
+
+// configFor returns the base configuration with the tenant's key, limits,
+// tracker and auditor. The base cache is namespaced by tenant, so no tenant
+// is served another's responses.
+func (m *Manager) configFor(t Tenant) *config.Config {
+	cfg := *m.base
+	if m.base.Cache != nil {
+		cfg.Cache = cache.Namespace(m.base.Cache, t.ID)
+	}
+	cfg.CostTracker = t.CostTracker
+	cfg.Audit = t.Audit
+	if t.APIKey != "" {
+		// The tenant's key replaces every base credential, or the base
+		// keys or tokens would authenticate the tenant's requests
+		cfg.APIKey = t.APIKey
+		cfg.APIKeys = nil
+		cfg.TokenSource = nil
+	}
+
+	if t.RequestsPerMinute > 0 || t.TokensPerMinute > 0 {
+		rl := config.RateLimit{}
+		if m.base.RateLimit != nil {
+			rl = *m.base.RateLimit
+		}
+		// A tenant's limits are its own, never shared with other tenants
+		rl.Shared = false
+		if t.RequestsPerMinute > 0 {
+			rl.RequestsPerMinute = t.RequestsPerMinute
+		}
+		if t.TokensPerMinute > 0 {
+			rl.TokensPerMinute = t.TokensPerMinute
+		}
+		cfg.RateLimit = &rl
+	}
+
+	if t.MaxCostPerRequest > 0 || t.MaxCostPerDay > 0 {
+		cc := config.CostControl{}
+		if m.base.CostControl != nil {
+			cc = *m.base.CostControl
+		}
+		if t.MaxCostPerRequest > 0 {
+			cc.MaxCostPerRequest = t.MaxCostPerRequest
+		}
+		if t.MaxCostPerDay > 0 {
+			cc.MaxCostPerDay = t.MaxCostPerDay
+		}
+		cfg.CostControl = &cc
+	}
+	return &cfg
+}
+
+// closeClient closes c if it can be closed
+func closeClient(c Upstream) {
+	if closer, ok := c.(interface{ Close() error }); ok {
+		closer.Close()
+	}
+}
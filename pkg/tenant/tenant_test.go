@@ -0,0 +1,229 @@
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/pkg/cache"
+	"github.com/ksred/llm/pkg/cost"
+	"github.com/ksred/llm/pkg/types"
+)
+
+// fakeClient records the configuration it was created with and the
+// context labels of its requests
+type fakeClient struct {
+	cfg    *config.Config
+	labels []map[string]string
+	closed bool
+}
+
+func (f *fakeClient) Chat(ctx context.Context, req *types.ChatRequest) (*types.ChatResponse, error) {
+	f.labels = append(f.labels, types.LabelsFromContext(ctx))
+	return &types.ChatResponse{Response: types.Response{Message: types.Message{Content: f.cfg.APIKey}}}, nil
+}
+
+func (f *fakeClient) StreamChat(ctx context.Context, req *types.ChatRequest) (<-chan *types.ChatResponse, error) {
+	resp, _ := f.Chat(ctx, req)
+	ch := make(chan *types.ChatResponse, 1)
+	ch <- resp
+	close(ch)
+	return ch, nil
+}
+
+func (f *fakeClient) Close() error {
+	f.closed = true
+	return nil
+}
+
+func newTestManager(t *testing.T, base *config.Config) (*Manager, map[string]*fakeClient) {
+	t.Helper()
+	clients := make(map[string]*fakeClient)
+	m := NewManager(base, WithClientFactory(func(cfg *config.Config) (Upstream, error) {
+		c := &fakeClient{cfg: cfg}
+		clients[cfg.APIKey] = c
+		return c, nil
+	}))
+	return m, clients
+}
+
+func request(opts ...types.RequestOption) *types.ChatRequest {
+	return types.NewChatRequest([]types.Message{{Role: types.RoleUser, Content: "Hello"}}, opts...)
+}
+
+func TestManager_Chat(t *testing.T) {
+	base := &config.Config{Provider: "openai", Model: "gpt-4o-mini", APIKey: "sk-base"}
+	m, clients := newTestManager(t, base)
+	defer m.Close()
+
+	if err := m.Add(Tenant{ID: "acme", APIKey: "sk-acme", Models: []string{"gpt-4o*"}}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := m.Add(Tenant{ID: "globex"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		req     *types.ChatRequest
+		wantKey string
+		wantErr error
+	}{
+		{"tenant key", WithTenant(context.Background(), "acme"), request(), "sk-acme", nil},
+		{"base key", WithTenant(context.Background(), "globex"), request(), "sk-base", nil},
+		{"tenant from label", types.WithLabels(context.Background(), map[string]string{types.LabelTenant: "acme"}), request(), "sk-acme", nil},
+		{"allowed model override", WithTenant(context.Background(), "acme"), request(types.WithProviderParam("model", "gpt-4o")), "sk-acme", nil},
+		{"model not allowed", WithTenant(context.Background(), "acme"), request(types.WithProviderParam("model", "gpt-4")), "", ErrModelNotAllowed},
+		{"no tenant", context.Background(), request(), "", ErrNoTenant},
+		{"unknown tenant", WithTenant(context.Background(), "initech"), request(), "", ErrUnknownTenant},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := m.Chat(tt.ctx, tt.req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Chat() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && resp.Message.Content != tt.wantKey {
+				t.Errorf("Chat() used key %q, want %q", resp.Message.Content, tt.wantKey)
+			}
+		})
+	}
+
+	labels := clients["sk-acme"].labels
+	if len(labels) == 0 || labels[0][types.LabelTenant] != "acme" {
+		t.Errorf("request labels = %v, want tenant acme", labels)
+	}
+
+	// Replacing a tenant closes its old client; removing one closes the new
+	old := clients["sk-acme"]
+	m.Add(Tenant{ID: "acme", APIKey: "sk-acme-2"})
+	if !old.closed {
+		t.Error("replaced client was not closed")
+	}
+	m.Remove("acme")
+	if !clients["sk-acme-2"].closed {
+		t.Error("removed client was not closed")
+	}
+	if _, ok := m.Get("acme"); ok {
+		t.Error("Get() found a removed tenant")
+	}
+}
+
+func TestManager_ConfigFor(t *testing.T) {
+	base := &config.Config{
+		Provider:    "openai",
+		Model:       "gpt-4o-mini",
+		APIKey:      "sk-base",
+		RateLimit:   &config.RateLimit{RequestsPerMinute: 500, TokensPerMinute: 100000, Shared: true},
+		CostControl: &config.CostControl{MaxCostPerDay: 100, Mode: config.BudgetSoft},
+	}
+	m := NewManager(base)
+
+	cfg := m.configFor(Tenant{ID: "acme", APIKey: "sk-acme", RequestsPerMinute: 60, MaxCostPerDay: 5})
+	if cfg.APIKey != "sk-acme" {
+		t.Errorf("APIKey = %q, want sk-acme", cfg.APIKey)
+	}
+	if rl := cfg.RateLimit; rl.RequestsPerMinute != 60 || rl.TokensPerMinute != 100000 || rl.Shared {
+		t.Errorf("RateLimit = %+v, want 60 rpm, the base token limit, not shared", rl)
+	}
+	if cc := cfg.CostControl; cc.MaxCostPerDay != 5 || cc.Mode != config.BudgetSoft {
+		t.Errorf("CostControl = %+v, want $5 a day in the base mode", cc)
+	}
+	if base.RateLimit.RequestsPerMinute != 500 || base.CostControl.MaxCostPerDay != 100 || base.APIKey != "sk-base" {
+		t.Error("base configuration was modified")
+	}
+
+	if cfg := m.configFor(Tenant{ID: "globex"}); cfg.RateLimit != base.RateLimit || cfg.CostControl != base.CostControl {
+		t.Error("tenant without limits should use the base limits")
+	}
+}
+
+func TestManager_TenantKey(t *testing.T) {
+	var auth []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":      "chatcmpl-1",
+			"model":   "gpt-4o-mini",
+			"choices": []map[string]any{{"message": map[string]any{"role": "assistant", "content": "Hi"}, "finish_reason": "stop"}},
+			"usage":   map[string]any{"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15},
+		})
+	}))
+	defer server.Close()
+
+	base, err := config.NewConfig("", config.WithAPIKeys("sk-base-key-1111", "sk-base-key-2222"),
+		config.WithProvider("openai"), config.WithModel("gpt-4o-mini"), config.WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("NewConfig() error = %v", err)
+	}
+	m := NewManager(base)
+	defer m.Close()
+	if err := m.Add(Tenant{ID: "acme", APIKey: "sk-acme-key-3333"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := m.Chat(WithTenant(context.Background(), "acme"), request()); err != nil {
+			t.Fatalf("Chat() error = %v", err)
+		}
+	}
+	for _, got := range auth {
+		if got != "Bearer sk-acme-key-3333" {
+			t.Errorf("Authorization = %q, want the tenant's key", got)
+		}
+	}
+}
+
+func TestManager_ConfigForIsolation(t *testing.T) {
+	ctx := context.Background()
+	base := &config.Config{
+		Provider:    "openai",
+		Model:       "gpt-4o-mini",
+		Cache:       cache.NewMemoryCache(time.Minute),
+		CostTracker: cost.NewCostTracker(),
+	}
+	m := NewManager(base)
+
+	own := cost.NewCostTracker()
+	acme := m.configFor(Tenant{ID: "acme", CostTracker: own})
+	globex := m.configFor(Tenant{ID: "globex"})
+	if acme.CostTracker != own || globex.CostTracker != nil {
+		t.Errorf("trackers = %p and %p, want the tenant's own and none", acme.CostTracker, globex.CostTracker)
+	}
+
+	resp := &types.ChatResponse{Response: types.Response{ID: "acme-1"}}
+	if err := acme.Cache.Set(ctx, "key", resp); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, err := globex.Cache.Get(ctx, "key"); !errors.Is(err, cache.ErrMiss) {
+		t.Errorf("other tenant's Get() error = %v, want a miss", err)
+	}
+	if got, err := acme.Cache.Get(ctx, "key"); err != nil || got.ID != "acme-1" {
+		t.Errorf("own Get() = %v, %v, want acme-1", got, err)
+	}
+}
+
+func TestManager_Budget(t *testing.T) {
+	base, err := config.NewConfig("sk-base", config.WithProvider("openai"), config.WithModel("gpt-4"))
+	if err != nil {
+		t.Fatalf("NewConfig() error = %v", err)
+	}
+	m := NewManager(base)
+	defer m.Close()
+	if err := m.Add(Tenant{ID: "acme", MaxCostPerRequest: 0.0001}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	// The tenant's budget rejects the request before it is sent
+	_, err = m.Chat(WithTenant(context.Background(), "acme"), request(types.WithMaxTokens(1000)))
+	if !errors.Is(err, types.ErrBudgetExceeded) {
+		t.Errorf("Chat() error = %v, want ErrBudgetExceeded", err)
+	}
+}
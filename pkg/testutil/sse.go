@@ -0,0 +1,274 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is one step of a scripted server-sent event stream
+type Event struct {
+	// Name is written as the "event:" field. Empty omits it, as OpenAI does.
+	Name string
+	// Data is written as one "data:" field per line
+	Data string
+	// Raw, when set, is written verbatim instead of an SSE frame. Use it for
+	// comments and malformed lines.
+	Raw string
+	// Delay is how long to wait before writing the event
+	Delay time.Duration
+	// Disconnect aborts the connection instead of writing anything
+	Disconnect bool
+}
+
+// frame renders the event in SSE wire format
+func (e Event) frame() string {
+	if e.Raw != "" {
+		return e.Raw
+	}
+	var b strings.Builder
+	if e.Name != "" {
+		fmt.Fprintf(&b, "event: %s\n", e.Name)
+	}
+	for _, line := range strings.Split(e.Data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// StreamServer is a test server that answers every request with a scripted
+// SSE stream, or with an error status
+type StreamServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	events   []Event
+	status   int
+	body     string
+	requests [][]byte
+}
+
+// NewStreamServer starts a server that replies to each request with events.
+// Close it when done.
+func NewStreamServer(events ...Event) *StreamServer {
+	s := &StreamServer{events: events, status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// SetEvents replaces the script for later requests
+func (s *StreamServer) SetEvents(events ...Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = events
+}
+
+// SetStatus makes later requests fail with the given status and body instead
+// of streaming. http.StatusOK restores streaming.
+func (s *StreamServer) SetStatus(status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+	s.body = body
+}
+
+// Requests returns the bodies of the requests received so far
+func (s *StreamServer) Requests() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.requests...)
+}
+
+func (s *StreamServer) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, body)
+	events := s.events
+	status, errBody := s.status, s.body
+	s.mu.Unlock()
+
+	if status != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, errBody)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	for _, e := range events {
+		if e.Delay > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(e.Delay):
+			}
+		}
+		if e.Disconnect {
+			panic(http.ErrAbortHandler)
+		}
+		if _, err := io.WriteString(w, e.frame()); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// Slow returns events with delay added before each one
+func Slow(delay time.Duration, events ...Event) []Event {
+	out := make([]Event, len(events))
+	for i, e := range events {
+		e.Delay += delay
+		out[i] = e
+	}
+	return out
+}
+
+// Comment returns an SSE comment line, which clients must ignore. OpenAI
+// sends these as keep-alives.
+func Comment(text string) Event {
+	return Event{Raw: ": " + text + "\n\n"}
+}
+
+// Malformed returns a raw line that is not valid SSE or not valid JSON
+func Malformed(line string) Event {
+	return Event{Raw: line + "\n\n"}
+}
+
+// Disconnect returns an event that drops the connection mid-stream
+func Disconnect() Event {
+	return Event{Disconnect: true}
+}
+
+func jsonData(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
+// OpenAIChunk returns a chat.completion.chunk event carrying content
+func OpenAIChunk(content string) Event {
+	return Event{Data: jsonData(map[string]any{
+		"id":     "chatcmpl-test",
+		"object": "chat.completion.chunk",
+		"model":  "gpt-4",
+		"choices": []map[string]any{
+			{"index": 0, "delta": map[string]any{"content": content}},
+		},
+	})}
+}
+
+// OpenAIFinish returns the final chunk of an OpenAI stream with the given
+// finish reason
+func OpenAIFinish(reason string) Event {
+	return Event{Data: jsonData(map[string]any{
+		"id":     "chatcmpl-test",
+		"object": "chat.completion.chunk",
+		"model":  "gpt-4",
+		"choices": []map[string]any{
+			{"index": 0, "delta": map[string]any{}, "finish_reason": reason},
+		},
+	})}
+}
+
+// OpenAIDone returns the [DONE] sentinel that ends an OpenAI stream
+func OpenAIDone() Event {
+	return Event{Data: "[DONE]"}
+}
+
+// OpenAIError returns an in-stream OpenAI error event
+func OpenAIError(errType, message string) Event {
+	return Event{Data: jsonData(map[string]any{
+		"error": map[string]any{"type": errType, "message": message},
+	})}
+}
+
+// OpenAIStream returns a complete OpenAI chat stream delivering chunks
+func OpenAIStream(chunks ...string) []Event {
+	events := make([]Event, 0, len(chunks)+2)
+	for _, c := range chunks {
+		events = append(events, OpenAIChunk(c))
+	}
+	return append(events, OpenAIFinish("stop"), OpenAIDone())
+}
+
+// AnthropicEvent returns a named Anthropic Messages API event. The payload's
+// "type" field is set to name.
+func AnthropicEvent(name string, payload map[string]any) Event {
+	data := map[string]any{"type": name}
+	for k, v := range payload {
+		data[k] = v
+	}
+	return Event{Name: name, Data: jsonData(data)}
+}
+
+// AnthropicDelta returns a content_block_delta event carrying text
+func AnthropicDelta(text string) Event {
+	return AnthropicEvent("content_block_delta", map[string]any{
+		"index": 0,
+		"delta": map[string]any{"type": "text_delta", "text": text},
+	})
+}
+
+// AnthropicPing returns the ping event Anthropic interleaves in streams
+func AnthropicPing() Event {
+	return AnthropicEvent("ping", nil)
+}
+
+// AnthropicError returns an in-stream Anthropic error event, such as
+// ("overloaded_error", "Overloaded")
+func AnthropicError(errType, message string) Event {
+	return AnthropicEvent("error", map[string]any{
+		"error": map[string]any{"type": errType, "message": message},
+	})
+}
+
+// AnthropicStream returns a complete Anthropic Messages stream delivering
+// chunks, with the event sequence the API sends
+func AnthropicStream(chunks ...string) []Event {
+	events := []Event{
+		AnthropicEvent("message_start", map[string]any{
+			"message": map[string]any{
+				"id":      "msg_test",
+				"type":    "message",
+				"role":    "assistant",
+				"model":   "claude-3-opus-20240229",
+				"content": []any{},
+				"usage":   map[string]any{"input_tokens": 10, "output_tokens": 1},
+			},
+		}),
+		AnthropicEvent("content_block_start", map[string]any{
+			"index":         0,
+			"content_block": map[string]any{"type": "text", "text": ""},
+		}),
+		AnthropicPing(),
+	}
+	for _, c := range chunks {
+		events = append(events, AnthropicDelta(c))
+	}
+	return append(events,
+		AnthropicEvent("content_block_stop", map[string]any{"index": 0}),
+		AnthropicEvent("message_delta", map[string]any{
+			"delta": map[string]any{"stop_reason": "end_turn", "stop_sequence": nil},
+			"usage": map[string]any{"output_tokens": len(chunks)},
+		}),
+		AnthropicEvent("message_stop", nil),
+	)
+}
@@ -0,0 +1,190 @@
+package testutil
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/models/anthropic"
+	"github.com/ksred/llm/models/openai"
+	"github.com/ksred/llm/pkg/resource"
+	"github.com/ksred/llm/pkg/types"
+)
+
+type streamer interface {
+	StreamChat(ctx context.Context, req *types.ChatRequest) (<-chan *types.ChatResponse, error)
+}
+
+func newStreamer(t *testing.T, provider, baseURL string) streamer {
+	t.Helper()
+	cfg := &config.Config{
+		Provider:    provider,
+		Model:       "test-model",
+		APIKey:      "test-key",
+		BaseURL:     baseURL,
+		RetryConfig: &resource.RetryConfig{MaxRetries: 0},
+	}
+	var p streamer
+	var err error
+	switch provider {
+	case "openai":
+		p, err = openai.NewProvider(cfg)
+	case "anthropic":
+		p, err = anthropic.NewProvider(cfg)
+	}
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+	return p
+}
+
+// collect drains a stream, returning its content and the errors it carried
+func collect(stream <-chan *types.ChatResponse) (string, []error) {
+	var content strings.Builder
+	var errs []error
+	for resp := range stream {
+		if resp.Error != nil {
+			errs = append(errs, resp.Error)
+			continue
+		}
+		content.WriteString(resp.Message.Content)
+	}
+	return content.String(), errs
+}
+
+func TestStreamServer_Providers(t *testing.T) {
+	tests := []struct {
+		name        string
+		provider    string
+		events      []Event
+		wantContent string
+		wantErrs    int
+	}{
+		{
+			name:        "openai",
+			provider:    "openai",
+			events:      OpenAIStream("Hello", " world"),
+			wantContent: "Hello world",
+		},
+		{
+			name:        "openai keep-alive comments",
+			provider:    "openai",
+			events:      append([]Event{Comment("ping")}, OpenAIStream("Hi")...),
+			wantContent: "Hi",
+		},
+		{
+			name:        "openai malformed line",
+			provider:    "openai",
+			events:      append([]Event{Malformed("data: {not json")}, OpenAIStream("Hi")...),
+			wantContent: "Hi",
+			wantErrs:    1,
+		},
+		{
+			name:        "openai disconnect",
+			provider:    "openai",
+			events:      []Event{OpenAIChunk("Hel"), Disconnect()},
+			wantContent: "Hel",
+			wantErrs:    1,
+		},
+		{
+			name:        "anthropic",
+			provider:    "anthropic",
+			events:      AnthropicStream("Hello", " world"),
+			wantContent: "Hello world",
+		},
+		{
+			name:        "slow chunks",
+			provider:    "openai",
+			events:      Slow(5*time.Millisecond, OpenAIStream("a", "b", "c")...),
+			wantContent: "abc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewStreamServer(tt.events...)
+			defer server.Close()
+
+			stream, err := newStreamer(t, tt.provider, server.URL).StreamChat(context.Background(), &types.ChatRequest{
+				Messages:  []types.Message{{Role: types.RoleUser, Content: "Hi"}},
+				MaxTokens: 10,
+			})
+			if err != nil {
+				t.Fatalf("StreamChat() error = %v", err)
+			}
+
+			content, errs := collect(stream)
+			if content != tt.wantContent {
+				t.Errorf("content = %q, want %q", content, tt.wantContent)
+			}
+			if len(errs) != tt.wantErrs {
+				t.Errorf("got %d errors (%v), want %d", len(errs), errs, tt.wantErrs)
+			}
+			if len(server.Requests()) != 1 {
+				t.Errorf("server got %d requests, want 1", len(server.Requests()))
+			}
+		})
+	}
+}
+
+func TestStreamServer_Status(t *testing.T) {
+	server := NewStreamServer(OpenAIStream("unused")...)
+	defer server.Close()
+	server.SetStatus(http.StatusBadRequest, `{"error":{"message":"bad input","type":"invalid_request_error"}}`)
+
+	_, err := newStreamer(t, "openai", server.URL).StreamChat(context.Background(), &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hi"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "bad input") {
+		t.Errorf("StreamChat() error = %v, want bad input", err)
+	}
+}
+
+func TestStreamServer_Cancel(t *testing.T) {
+	server := NewStreamServer(Slow(time.Second, OpenAIStream("late")...)...)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	stream, err := newStreamer(t, "openai", server.URL).StreamChat(ctx, &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	if content, _ := collect(stream); content != "" {
+		t.Errorf("content = %q, want none", content)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("stream took %v after cancellation", elapsed)
+	}
+}
+
+func TestEvent_Frame(t *testing.T) {
+	server := NewStreamServer(
+		Event{Name: "message", Data: "line one\nline two"},
+		Comment("keep-alive"),
+	)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	want := "event: message\ndata: line one\ndata: line two\n\n: keep-alive\n\n"
+	if string(body) != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+}
@@ -0,0 +1,350 @@
+// Package testutil provides helpers for testing code that calls LLM
+// providers without live API keys.
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// RecordEnv is the environment variable that switches recorders created
+// without WithMode to record mode, e.g. LLM_RECORD=1 go test ./...
+const RecordEnv = "LLM_RECORD"
+
+// ErrNoInteraction is returned in replay mode when a request matches no
+// unused interaction in the cassette
+var ErrNoInteraction = errors.New("no recorded interaction matches request")
+
+// scrubbed replaces secrets in recorded interactions
+const scrubbed = "[SCRUBBED]"
+
+// sensitiveHeaders are removed from recorded requests and responses
+var sensitiveHeaders = []string{
+	"Authorization",
+	"X-Api-Key",
+	"Api-Key",
+	"Openai-Organization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// sensitiveParams are scrubbed from recorded request URLs
+var sensitiveParams = []string{"key", "api_key", "apikey"}
+
+// Mode controls whether a Recorder calls the real provider
+type Mode int
+
+const (
+	// ModeReplay serves responses from the cassette and never touches the
+	// network
+	ModeReplay Mode = iota
+	// ModeRecord sends requests to the real transport and saves them to the
+	// cassette when the recorder is stopped
+	ModeRecord
+)
+
+// Cassette is the golden file format: the interactions in the order they
+// were recorded
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one recorded request and its response
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is the part of a request used to match replays
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// RecordedResponse is a saved response. Streamed responses are saved whole
+// and replayed at once.
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body"`
+}
+
+// Matcher reports whether a live request matches a recorded one
+type Matcher func(req *http.Request, body []byte, rec RecordedRequest) bool
+
+// DefaultMatcher matches on method, URL and body. JSON bodies are compared
+// semantically, so field order does not matter.
+func DefaultMatcher(req *http.Request, body []byte, rec RecordedRequest) bool {
+	if req.Method != rec.Method || scrubURL(req.URL) != rec.URL {
+		return false
+	}
+	return equalBodies(body, []byte(rec.Body))
+}
+
+// Recorder is an http.RoundTripper that records interactions to a cassette
+// file, or replays them from it
+type Recorder struct {
+	path      string
+	mode      Mode
+	transport http.RoundTripper
+	matcher   Matcher
+	secrets   []string
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool
+}
+
+// RecorderOption configures a Recorder
+type RecorderOption func(*Recorder)
+
+// WithMode sets the mode, overriding RecordEnv
+func WithMode(mode Mode) RecorderOption {
+	return func(r *Recorder) {
+		r.mode = mode
+	}
+}
+
+// WithTransport sets the transport used in record mode. The default is
+// http.DefaultTransport.
+func WithTransport(rt http.RoundTripper) RecorderOption {
+	return func(r *Recorder) {
+		r.transport = rt
+	}
+}
+
+// WithMatcher replaces DefaultMatcher
+func WithMatcher(m Matcher) RecorderOption {
+	return func(r *Recorder) {
+		r.matcher = m
+	}
+}
+
+// WithSecrets scrubs the given strings, such as API keys, from every part
+// of recorded interactions
+func WithSecrets(secrets ...string) RecorderOption {
+	return func(r *Recorder) {
+		for _, s := range secrets {
+			if s != "" {
+				r.secrets = append(r.secrets, s)
+			}
+		}
+	}
+}
+
+// NewRecorder creates a recorder for the cassette at path. In replay mode
+// the cassette must exist.
+func NewRecorder(path string, opts ...RecorderOption) (*Recorder, error) {
+	r := &Recorder{
+		path:      path,
+		transport: http.DefaultTransport,
+		matcher:   DefaultMatcher,
+	}
+	if os.Getenv(RecordEnv) != "" {
+		r.mode = ModeRecord
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.mode == ModeReplay {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading cassette: %w", err)
+		}
+		if err := json.Unmarshal(data, &r.cassette); err != nil {
+			return nil, fmt.Errorf("decoding cassette %s: %w", path, err)
+		}
+		r.used = make([]bool, len(r.cassette.Interactions))
+	}
+	return r, nil
+}
+
+// Start creates a recorder for testdata/<name>.json that is stopped when the
+// test ends, failing the test on any error
+func Start(t testing.TB, name string, opts ...RecorderOption) *Recorder {
+	t.Helper()
+
+	r, err := NewRecorder(filepath.Join("testdata", name+".json"), opts...)
+	if err != nil {
+		t.Fatalf("starting recorder: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := r.Stop(); err != nil {
+			t.Errorf("stopping recorder: %v", err)
+		}
+	})
+	return r
+}
+
+// Client returns an HTTP client that uses the recorder as its transport
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// Mode returns the recorder's mode
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// RoundTrip records or replays a single request
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading request body: %w", err)
+		}
+	}
+
+	if r.mode == ModeReplay {
+		return r.replay(req, body)
+	}
+	return r.record(req, body)
+}
+
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	body = []byte(r.scrub(string(body)))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, in := range r.cassette.Interactions {
+		if r.used[i] || !r.matcher(req, body, in.Request) {
+			continue
+		}
+		r.used[i] = true
+		return &http.Response{
+			StatusCode:    in.Response.StatusCode,
+			Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        in.Response.Header.Clone(),
+			Body:          io.NopCloser(strings.NewReader(in.Response.Body)),
+			ContentLength: int64(len(in.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, req.Method, scrubURL(req.URL))
+}
+
+func (r *Recorder) record(req *http.Request, body []byte) (*http.Response, error) {
+	out := req.Clone(req.Context())
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	out.ContentLength = int64(len(body))
+
+	resp, err := r.transport.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	in := Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    scrubURL(req.URL),
+			Header: r.scrubHeader(req.Header),
+			Body:   r.scrub(string(body)),
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     r.scrubHeader(resp.Header),
+			Body:       r.scrub(string(respBody)),
+		},
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, in)
+	r.mu.Unlock()
+	return resp, nil
+}
+
+// Stop saves the cassette in record mode. In replay mode it does nothing.
+func (r *Recorder) Stop() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("encoding cassette: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return fmt.Errorf("creating cassette directory: %w", err)
+	}
+	return os.WriteFile(r.path, append(data, '\n'), 0o644)
+}
+
+func (r *Recorder) scrub(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, scrubbed)
+	}
+	return s
+}
+
+// scrubHeader copies h without sensitive headers, scrubbing secrets from
+// the rest
+func (r *Recorder) scrubHeader(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, vs := range h {
+		for _, v := range vs {
+			out.Add(k, r.scrub(v))
+		}
+	}
+	for _, k := range sensitiveHeaders {
+		out.Del(k)
+	}
+	return out
+}
+
+// scrubURL returns u as a string with credential query parameters scrubbed
+func scrubURL(u *url.URL) string {
+	q := u.Query()
+	changed := false
+	for _, p := range sensitiveParams {
+		if q.Has(p) {
+			q.Set(p, scrubbed)
+			changed = true
+		}
+	}
+	if !changed {
+		return u.String()
+	}
+	out := *u
+	out.RawQuery = q.Encode()
+	return out.String()
+}
+
+// equalBodies compares request bodies, as JSON when both are valid JSON
+func equalBodies(a, b []byte) bool {
+	var av, bv any
+	if json.Unmarshal(a, &av) == nil && json.Unmarshal(b, &bv) == nil {
+		ja, _ := json.Marshal(av)
+		jb, _ := json.Marshal(bv)
+		return bytes.Equal(ja, jb)
+	}
+	return bytes.Equal(a, b)
+}
@@ -0,0 +1,167 @@
+package testutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ksred/llm/config"
+	"github.com/ksred/llm/models/openai"
+	"github.com/ksred/llm/pkg/types"
+)
+
+func chatServer(t *testing.T, calls *int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=abc")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":    "chatcmpl-1",
+			"model": "gpt-4",
+			"choices": []map[string]interface{}{
+				{"message": map[string]interface{}{"role": "assistant", "content": "Hello there"}, "finish_reason": "stop"},
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newChatProvider(t *testing.T, baseURL string, rec *Recorder) *openai.Provider {
+	t.Helper()
+	p, err := openai.NewProvider(&config.Config{
+		Provider:   "openai",
+		Model:      "gpt-4",
+		APIKey:     "sk-live-secret",
+		BaseURL:    baseURL,
+		HTTPClient: rec.Client(),
+	})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+	return p
+}
+
+func TestRecorder_RecordAndReplay(t *testing.T) {
+	var calls int
+	server := chatServer(t, &calls)
+	path := filepath.Join(t.TempDir(), "cassettes", "chat.json")
+	req := &types.ChatRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "Hi"}},
+	}
+
+	rec, err := NewRecorder(path, WithMode(ModeRecord), WithSecrets("sk-live-secret"))
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+	if _, err := newChatProvider(t, server.URL, rec).Chat(context.Background(), req); err != nil {
+		t.Fatalf("recording Chat() error = %v", err)
+	}
+	if err := rec.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading cassette: %v", err)
+	}
+	for _, leaked := range []string{"sk-live-secret", "Authorization", "session=abc"} {
+		if strings.Contains(string(data), leaked) {
+			t.Errorf("cassette contains %q:\n%s", leaked, data)
+		}
+	}
+
+	rec, err = NewRecorder(path, WithMode(ModeReplay), WithSecrets("sk-live-secret"))
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+	resp, err := newChatProvider(t, server.URL, rec).Chat(context.Background(), req)
+	if err != nil {
+		t.Fatalf("replayed Chat() error = %v", err)
+	}
+	if resp.Message.Content != "Hello there" {
+		t.Errorf("replayed content = %q, want %q", resp.Message.Content, "Hello there")
+	}
+	if calls != 1 {
+		t.Errorf("server called %d times, want 1", calls)
+	}
+}
+
+func TestRecorder_Replay(t *testing.T) {
+	cassette := Cassette{Interactions: []Interaction{
+		{
+			Request:  RecordedRequest{Method: "POST", URL: "https://api.example.com/v1/chat", Body: `{"a":1,"b":2}`},
+			Response: RecordedResponse{StatusCode: 200, Body: "first"},
+		},
+		{
+			Request:  RecordedRequest{Method: "POST", URL: "https://api.example.com/v1/chat", Body: `{"a":1,"b":2}`},
+			Response: RecordedResponse{StatusCode: 429, Body: "second"},
+		},
+		{
+			Request:  RecordedRequest{Method: "GET", URL: "https://api.example.com/v1/models?key=%5BSCRUBBED%5D"},
+			Response: RecordedResponse{StatusCode: 200, Body: "models"},
+		},
+	}}
+	data, _ := json.Marshal(cassette)
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := NewRecorder(path, WithMode(ModeReplay))
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+	client := rec.Client()
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		body       string
+		wantStatus int
+		wantBody   string
+		wantErr    error
+	}{
+		{"json body in any order", "POST", "https://api.example.com/v1/chat", `{"b":2,"a":1}`, 200, "first", nil},
+		{"repeated request gets next interaction", "POST", "https://api.example.com/v1/chat", `{"a":1,"b":2}`, 429, "second", nil},
+		{"interactions are used once", "POST", "https://api.example.com/v1/chat", `{"a":1,"b":2}`, 0, "", ErrNoInteraction},
+		{"credential params scrubbed", "GET", "https://api.example.com/v1/models?key=real-key", "", 200, "models", nil},
+		{"unknown request", "GET", "https://api.example.com/v1/other", "", 0, "", ErrNoInteraction},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			resp, err := client.Do(req)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Do() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus || string(body) != tt.wantBody {
+				t.Errorf("got %d %q, want %d %q", resp.StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestNewRecorder_MissingCassette(t *testing.T) {
+	if _, err := NewRecorder(filepath.Join(t.TempDir(), "missing.json"), WithMode(ModeReplay)); err == nil {
+		t.Error("NewRecorder() error = nil, want error for missing cassette")
+	}
+}
@@ -0,0 +1,240 @@
+package types
+
+import (
+	"maps"
+	"strings"
+)
+
+// redacted replaces redacted values, as it does secrets in the client's
+// logs and errors
+const redacted = "[REDACTED]"
+
+// Clone returns a deep copy of the request that shares no slices or maps
+// with it, so it can be modified while the original is in flight. Values in
+// RequestMetadata and ProviderParams are copied when they are maps or
+// slices decoded from JSON; other reference types are shared.
+func (r *ChatRequest) Clone() *ChatRequest {
+	if r == nil {
+		return nil
+	}
+	out := *r
+	out.System = cloneSlice(r.System)
+	if r.Messages != nil {
+		out.Messages = make([]Message, len(r.Messages))
+		for i, m := range r.Messages {
+			out.Messages[i] = m.Clone()
+		}
+	}
+	if r.Tools != nil {
+		out.Tools = make([]Tool, len(r.Tools))
+		for i, t := range r.Tools {
+			t.Parameters = cloneMap(t.Parameters)
+			out.Tools[i] = t
+		}
+	}
+	out.Temperature = clonePointer(r.Temperature)
+	out.TopP = clonePointer(r.TopP)
+	out.Stop = cloneSlice(r.Stop)
+	out.RequestMetadata = cloneMap(r.RequestMetadata)
+	out.ProviderParams = cloneMap(r.ProviderParams)
+	out.Labels = maps.Clone(r.Labels)
+	return &out
+}
+
+// Clone returns a deep copy of the request, as ChatRequest.Clone does
+func (r *CompletionRequest) Clone() *CompletionRequest {
+	if r == nil {
+		return nil
+	}
+	out := *r
+	out.Temperature = clonePointer(r.Temperature)
+	out.TopP = clonePointer(r.TopP)
+	out.Stop = cloneSlice(r.Stop)
+	out.RequestMetadata = cloneMap(r.RequestMetadata)
+	out.ProviderParams = cloneMap(r.ProviderParams)
+	out.Labels = maps.Clone(r.Labels)
+	return &out
+}
+
+// Clone returns a deep copy of the response, so a response handed to
+// several callers can be modified by each without affecting the others
+func (r Response) Clone() Response {
+	r.Message = r.Message.Clone()
+	r.RateLimits = clonePointer(r.RateLimits)
+	r.Metadata = cloneMap(r.Metadata)
+	return r
+}
+
+// Clone returns a deep copy of the response
+func (r *ChatResponse) Clone() *ChatResponse {
+	if r == nil {
+		return nil
+	}
+	return &ChatResponse{Response: r.Response.Clone()}
+}
+
+// Clone returns a deep copy of the response
+func (r *CompletionResponse) Clone() *CompletionResponse {
+	if r == nil {
+		return nil
+	}
+	return &CompletionResponse{Response: r.Response.Clone()}
+}
+
+// Clone returns a deep copy of the message
+func (m Message) Clone() Message {
+	m.Metadata = cloneMap(m.Metadata)
+	m.ToolCalls = cloneSlice(m.ToolCalls)
+	return m
+}
+
+// Redact returns a copy of the request with the named fields replaced by
+// "[REDACTED]", for logging or auditing it without exposing user data. Fields
+// are named as in the request's JSON:
+//
+//   - "user", "idempotency_key": the string
+//   - "system": each system prompt
+//   - "request_metadata", "provider_params", "labels": every value of the
+//     map, or with a key such as "request_metadata.email", that value only
+//   - "messages": each message's content, tool call arguments and metadata
+//   - "messages.content", "messages.tool_calls", "messages.metadata" or
+//     "messages.metadata.<key>": that part of each message
+//
+// Empty values stay empty, and unknown fields are ignored. The request
+// itself is not modified.
+func (r *ChatRequest) Redact(fields ...string) *ChatRequest {
+	out := r.Clone()
+	if out == nil {
+		return nil
+	}
+	for _, field := range fields {
+		name, key, _ := strings.Cut(field, ".")
+		switch name {
+		case "system":
+			for i := range out.System {
+				out.System[i] = redactString(out.System[i])
+			}
+		case "messages":
+			for i := range out.Messages {
+				out.Messages[i].redact(key)
+			}
+		default:
+			redactCommon(name, key, &out.User, &out.IdempotencyKey, out.RequestMetadata, out.ProviderParams, out.Labels)
+		}
+	}
+	return out
+}
+
+// Redact returns a copy of the request with the named fields redacted, as
+// ChatRequest.Redact does. "prompt" names the prompt.
+func (r *CompletionRequest) Redact(fields ...string) *CompletionRequest {
+	out := r.Clone()
+	if out == nil {
+		return nil
+	}
+	for _, field := range fields {
+		name, key, _ := strings.Cut(field, ".")
+		switch name {
+		case "prompt":
+			out.Prompt = redactString(out.Prompt)
+		default:
+			redactCommon(name, key, &out.User, &out.IdempotencyKey, out.RequestMetadata, out.ProviderParams, out.Labels)
+		}
+	}
+	return out
+}
+
+// redact redacts the named part of the message, or all of it if part is
+// empty
+func (m *Message) redact(part string) {
+	name, key, _ := strings.Cut(part, ".")
+	if name == "" || name == "content" {
+		m.Content = redactString(m.Content)
+	}
+	if name == "" || name == "tool_calls" {
+		for i := range m.ToolCalls {
+			m.ToolCalls[i].Arguments = redactString(m.ToolCalls[i].Arguments)
+		}
+	}
+	if name == "" || name == "metadata" {
+		redactMap(m.Metadata, key, any(redacted))
+	}
+}
+
+// redactCommon redacts a field that chat and completion requests share
+func redactCommon(name, key string, user, idempotencyKey *string, metadata, params map[string]any, labels map[string]string) {
+	switch name {
+	case "user":
+		*user = redactString(*user)
+	case "idempotency_key":
+		*idempotencyKey = redactString(*idempotencyKey)
+	case "request_metadata":
+		redactMap(metadata, key, any(redacted))
+	case "provider_params":
+		redactMap(params, key, any(redacted))
+	case "labels":
+		redactMap(labels, key, redacted)
+	}
+}
+
+func redactString(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// redactMap replaces the value of key in m, or every value if key is empty
+func redactMap[V any](m map[string]V, key string, placeholder V) {
+	if key != "" {
+		if _, ok := m[key]; ok {
+			m[key] = placeholder
+		}
+		return
+	}
+	for k := range m {
+		m[k] = placeholder
+	}
+}
+
+func clonePointer[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func cloneSlice[T any](s []T) []T {
+	if s == nil {
+		return nil
+	}
+	return append(make([]T, 0, len(s)), s...)
+}
+
+// cloneMap deep copies a map decoded from JSON
+func cloneMap(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = cloneValue(v)
+	}
+	return out
+}
+
+func cloneValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		return cloneMap(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = cloneValue(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
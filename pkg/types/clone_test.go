@@ -0,0 +1,166 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func chatRequest() *ChatRequest {
+	return &ChatRequest{
+		System: []string{"You support ann@example.com"},
+		Messages: []Message{
+			{Role: RoleUser, Content: "My email is ann@example.com", Metadata: map[string]any{"ip": "10.0.0.1", "tags": []any{"a"}}},
+			{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "call_1", Name: "lookup", Arguments: `{"email":"ann@example.com"}`}}},
+			{Role: RoleTool, ToolCallID: "call_1", Content: "found"},
+		},
+		Stop:            []string{"END"},
+		User:            "user-42",
+		RequestMetadata: map[string]any{"email": "ann@example.com", "plan": "pro", "nested": map[string]any{"k": "v"}},
+		Tools:           []Tool{{Name: "lookup", Parameters: map[string]any{"type": "object"}}},
+		ProviderParams:  map[string]any{"seed": 1},
+		Labels:          map[string]string{"tenant": "acme"},
+	}
+}
+
+func TestChatRequest_Clone(t *testing.T) {
+	orig := chatRequest()
+	want := chatRequest()
+
+	clone := orig.Clone()
+	if !reflect.DeepEqual(clone, orig) {
+		t.Fatalf("Clone() = %+v, want %+v", clone, orig)
+	}
+
+	clone.Messages[0].Content = "changed"
+	clone.Messages[0].Metadata["ip"] = "changed"
+	clone.Messages[0].Metadata["tags"].([]any)[0] = "changed"
+	clone.Messages[1].ToolCalls[0].Arguments = "changed"
+	clone.Messages = append(clone.Messages, Message{Role: RoleUser, Content: "more"})
+	clone.Stop[0] = "changed"
+	clone.System[0] = "changed"
+	clone.RequestMetadata["nested"].(map[string]any)["k"] = "changed"
+	clone.Tools[0].Parameters["type"] = "changed"
+	clone.ProviderParams["seed"] = 2
+	clone.Labels["tenant"] = "changed"
+
+	if !reflect.DeepEqual(orig, want) {
+		t.Errorf("modifying the clone changed the original: %+v", orig)
+	}
+	if (*ChatRequest)(nil).Clone() != nil {
+		t.Errorf("Clone() of nil request is not nil")
+	}
+}
+
+func TestCompletionRequest_Clone(t *testing.T) {
+	orig := &CompletionRequest{Prompt: "hi", Stop: []string{"END"}, RequestMetadata: map[string]any{"k": "v"}, Labels: map[string]string{"a": "b"}}
+	clone := orig.Clone()
+	clone.Stop[0] = "changed"
+	clone.RequestMetadata["k"] = "changed"
+	clone.Labels["a"] = "changed"
+
+	if orig.Stop[0] != "END" || orig.RequestMetadata["k"] != "v" || orig.Labels["a"] != "b" {
+		t.Errorf("modifying the clone changed the original: %+v", orig)
+	}
+}
+
+func TestChatResponse_Clone(t *testing.T) {
+	orig := &ChatResponse{Response: Response{
+		ID:         "resp_1",
+		Message:    Message{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "call_1", Arguments: "{}"}}},
+		RateLimits: &RateLimits{RequestsRemaining: 10},
+		Metadata:   map[string]any{"user": "ann"},
+	}}
+	clone := orig.Clone()
+	if !reflect.DeepEqual(clone, orig) {
+		t.Fatalf("Clone() = %+v, want %+v", clone, orig)
+	}
+
+	clone.Message.ToolCalls[0].Arguments = "changed"
+	clone.RateLimits.RequestsRemaining = 0
+	clone.Metadata["user"] = "changed"
+
+	if orig.Message.ToolCalls[0].Arguments != "{}" || orig.RateLimits.RequestsRemaining != 10 || orig.Metadata["user"] != "ann" {
+		t.Errorf("modifying the clone changed the original: %+v", orig)
+	}
+	if (*ChatResponse)(nil).Clone() != nil {
+		t.Errorf("Clone() of nil response is not nil")
+	}
+}
+
+func TestChatRequest_Redact(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		check  func(t *testing.T, r *ChatRequest)
+	}{
+		{
+			name:   "messages",
+			fields: []string{"messages"},
+			check: func(t *testing.T, r *ChatRequest) {
+				if r.Messages[0].Content != redacted || r.Messages[2].Content != redacted {
+					t.Errorf("contents = %q, %q, want redacted", r.Messages[0].Content, r.Messages[2].Content)
+				}
+				if r.Messages[1].Content != "" {
+					t.Errorf("empty content = %q, want it left empty", r.Messages[1].Content)
+				}
+				if r.Messages[1].ToolCalls[0].Arguments != redacted || r.Messages[1].ToolCalls[0].Name != "lookup" {
+					t.Errorf("tool call = %+v, want only the arguments redacted", r.Messages[1].ToolCalls[0])
+				}
+				if r.Messages[0].Metadata["ip"] != redacted {
+					t.Errorf("metadata = %v, want redacted", r.Messages[0].Metadata)
+				}
+			},
+		},
+		{
+			name:   "message part",
+			fields: []string{"messages.metadata.ip"},
+			check: func(t *testing.T, r *ChatRequest) {
+				if r.Messages[0].Metadata["ip"] != redacted || r.Messages[0].Metadata["tags"] == redacted {
+					t.Errorf("metadata = %v, want only ip redacted", r.Messages[0].Metadata)
+				}
+				if r.Messages[0].Content == redacted {
+					t.Errorf("content redacted, want it kept")
+				}
+			},
+		},
+		{
+			name:   "map key",
+			fields: []string{"request_metadata.email", "request_metadata.missing"},
+			check: func(t *testing.T, r *ChatRequest) {
+				want := map[string]any{"email": redacted, "plan": "pro", "nested": map[string]any{"k": "v"}}
+				if !reflect.DeepEqual(r.RequestMetadata, want) {
+					t.Errorf("RequestMetadata = %v, want %v", r.RequestMetadata, want)
+				}
+			},
+		},
+		{
+			name:   "scalars and whole maps",
+			fields: []string{"user", "system", "labels", "provider_params", "unknown"},
+			check: func(t *testing.T, r *ChatRequest) {
+				if r.User != redacted || r.System[0] != redacted || r.Labels["tenant"] != redacted || r.ProviderParams["seed"] != redacted {
+					t.Errorf("got user %q, system %q, labels %v, params %v, want redacted", r.User, r.System, r.Labels, r.ProviderParams)
+				}
+				if r.Messages[0].Content == redacted {
+					t.Errorf("content redacted, want it kept")
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := chatRequest()
+			tt.check(t, orig.Redact(tt.fields...))
+			if !reflect.DeepEqual(orig, chatRequest()) {
+				t.Errorf("Redact() modified the request: %+v", orig)
+			}
+		})
+	}
+}
+
+func TestCompletionRequest_Redact(t *testing.T) {
+	r := (&CompletionRequest{Prompt: "secret", User: "u1", RequestMetadata: map[string]any{"k": "v"}}).Redact("prompt", "request_metadata")
+	if r.Prompt != redacted || r.User != "u1" || r.RequestMetadata["k"] != redacted {
+		t.Errorf("Redact() = %+v, want the prompt and metadata redacted", r)
+	}
+}
@@ -3,6 +3,7 @@ package types
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 // Common errors that may be returned by the LLM package
@@ -13,14 +14,140 @@ var (
 	ErrContextTooLong     = errors.New("context length exceeded")
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrTimeout            = errors.New("request timeout")
+	ErrBudgetExceeded     = errors.New("budget exceeded")
+	ErrClientClosed       = errors.New("client is closed")
+	ErrValidationFailed   = errors.New("response failed validation")
+	ErrOverloaded         = errors.New("provider overloaded")
+	ErrUnknownModel       = errors.New("unknown model")
+	ErrUnknownCurrency    = errors.New("unknown currency")
 )
 
+// RetryableError is implemented by errors that know whether sending the
+// same request again may succeed
+type RetryableError interface {
+	error
+	Retryable() bool
+}
+
+// TemporaryError is implemented by errors that know whether they are caused
+// by a transient condition, such as rate limiting or an overloaded
+// provider, rather than by the request itself
+type TemporaryError interface {
+	error
+	Temporary() bool
+}
+
+// IsRetryable reports whether sending the request that failed with err again
+// may succeed. The first error in err's chain implementing RetryableError
+// decides. Otherwise rate limits, overloads, timeouts and provider errors
+// are retryable.
+func IsRetryable(err error) bool {
+	var r RetryableError
+	if errors.As(err, &r) {
+		return r.Retryable()
+	}
+	return transient(err)
+}
+
+// IsTemporary reports whether err is caused by a transient condition. The
+// first error in err's chain implementing TemporaryError decides, with the
+// same fallback as IsRetryable.
+func IsTemporary(err error) bool {
+	var t TemporaryError
+	if errors.As(err, &t) {
+		return t.Temporary()
+	}
+	return transient(err)
+}
+
+// transient reports whether err is one of the common errors for transient
+// failures
+func transient(err error) bool {
+	return errors.Is(err, ErrRateLimitExceeded) ||
+		errors.Is(err, ErrOverloaded) ||
+		errors.Is(err, ErrTimeout) ||
+		errors.Is(err, ErrProviderError)
+}
+
+// ContextLengthError reports a request whose estimated prompt plus
+// MaxTokens exceeds its model's context window. It matches
+// ErrContextTooLong.
+type ContextLengthError struct {
+	Model         string
+	PromptTokens  int
+	MaxTokens     int
+	ContextWindow int
+}
+
+func (e *ContextLengthError) Error() string {
+	return fmt.Sprintf("%s: %d prompt tokens plus %d max tokens exceed the %d token window of %s",
+		ErrContextTooLong, e.PromptTokens, e.MaxTokens, e.ContextWindow, e.Model)
+}
+
+func (e *ContextLengthError) Unwrap() error {
+	return ErrContextTooLong
+}
+
+// Retryable reports false, as the same request would be too long again
+func (e *ContextLengthError) Retryable() bool {
+	return false
+}
+
+// Temporary reports false, as the request itself is too long
+func (e *ContextLengthError) Temporary() bool {
+	return false
+}
+
+// FieldError is one invalid parameter of a request
+type FieldError struct {
+	Field  string // The parameter's JSON name, such as "temperature"
+	Value  any
+	Reason string
+}
+
+func (e FieldError) String() string {
+	return fmt.Sprintf("%s %v %s", e.Field, e.Value, e.Reason)
+}
+
+// InvalidRequestError reports request parameters outside the ranges the
+// provider or model accepts, found before the request was sent. It matches
+// ErrInvalidRequest.
+type InvalidRequestError struct {
+	Provider string
+	Model    string
+	Fields   []FieldError
+}
+
+func (e *InvalidRequestError) Error() string {
+	fields := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		fields[i] = f.String()
+	}
+	return fmt.Sprintf("%s for %s %s: %s", ErrInvalidRequest, e.Provider, e.Model, strings.Join(fields, "; "))
+}
+
+func (e *InvalidRequestError) Unwrap() error {
+	return ErrInvalidRequest
+}
+
+// Retryable reports false, as the same request would be invalid again
+func (e *InvalidRequestError) Retryable() bool {
+	return false
+}
+
+// Temporary reports false, as the request itself is invalid
+func (e *InvalidRequestError) Temporary() bool {
+	return false
+}
+
 // ProviderError wraps an error from an LLM provider with additional context
 type ProviderError struct {
-	Provider string
-	Code     string
-	Message  string
-	Err      error
+	Provider   string
+	Code       string
+	Message    string
+	StatusCode int    // HTTP status code, if the error came from an HTTP response
+	RequestID  string // Provider request ID, useful when contacting support
+	Err        error
 }
 
 func (e *ProviderError) Error() string {
@@ -34,6 +161,23 @@ func (e *ProviderError) Unwrap() error {
 	return e.Err
 }
 
+// Retryable reports whether retrying may succeed: for rate limits,
+// overloads, timeouts and server errors. Without a wrapped common error, the
+// status code decides.
+func (e *ProviderError) Retryable() bool {
+	return e.Temporary()
+}
+
+// Temporary reports whether the error is caused by the provider's transient
+// state, as Retryable does
+func (e *ProviderError) Temporary() bool {
+	err := e.Err
+	if err == nil && e.StatusCode != 0 {
+		err = ErrorForStatus(e.StatusCode, e.Code, e.Message)
+	}
+	return transient(err)
+}
+
 // NewProviderError creates a new ProviderError
 func NewProviderError(provider, code, message string, err error) error {
 	return &ProviderError{
@@ -43,3 +187,41 @@ func NewProviderError(provider, code, message string, err error) error {
 		Err:      err,
 	}
 }
+
+// ErrorForStatus maps an HTTP error status, along with the provider's error
+// code and message, onto one of the common errors. It returns nil for
+// non-error statuses.
+func ErrorForStatus(status int, code, message string) error {
+	switch {
+	case status == 429:
+		return ErrRateLimitExceeded
+	case status == 401 || status == 403:
+		return ErrInvalidCredentials
+	case status == 529:
+		return ErrOverloaded
+	case status == 408 || status == 504:
+		return ErrTimeout
+	case (status == 400 || status == 413) && isContextLength(code, message):
+		return ErrContextTooLong
+	case status >= 500:
+		return ErrProviderError
+	case status >= 400:
+		return ErrInvalidRequest
+	}
+	return nil
+}
+
+// isContextLength reports whether a provider error describes a prompt that
+// is too long for the model's context window
+func isContextLength(code, message string) bool {
+	if strings.Contains(code, "context_length") {
+		return true
+	}
+	message = strings.ToLower(message)
+	for _, phrase := range []string{"context length", "context window", "prompt is too long", "maximum context"} {
+		if strings.Contains(message, phrase) {
+			return true
+		}
+	}
+	return false
+}
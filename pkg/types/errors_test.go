@@ -2,6 +2,7 @@ package types
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 	"testing"
 )
@@ -100,6 +101,12 @@ func TestCommonErrors(t *testing.T) {
 		ErrContextTooLong,
 		ErrInvalidCredentials,
 		ErrTimeout,
+		ErrBudgetExceeded,
+		ErrClientClosed,
+		ErrValidationFailed,
+		ErrOverloaded,
+		ErrUnknownModel,
+		ErrUnknownCurrency,
 	}
 
 	for _, err := range commonErrors {
@@ -156,3 +163,84 @@ func TestErrorWrapping(t *testing.T) {
 		})
 	}
 }
+
+func TestErrorForStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		code    string
+		message string
+		want    error
+	}{
+		{"ok", 200, "", "", nil},
+		{"rate limited", 429, "rate_limit_exceeded", "", ErrRateLimitExceeded},
+		{"unauthorized", 401, "", "", ErrInvalidCredentials},
+		{"forbidden", 403, "", "", ErrInvalidCredentials},
+		{"overloaded", 529, "overloaded_error", "", ErrOverloaded},
+		{"gateway timeout", 504, "", "", ErrTimeout},
+		{"openai context length", 400, "context_length_exceeded", "", ErrContextTooLong},
+		{"anthropic context length", 400, "invalid_request_error", "prompt is too long: 250000 tokens > 200000 maximum", ErrContextTooLong},
+		{"bad request", 400, "invalid_request_error", "temperature out of range", ErrInvalidRequest},
+		{"server error", 500, "", "", ErrProviderError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorForStatus(tt.status, tt.code, tt.message); got != tt.want {
+				t.Errorf("ErrorForStatus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantRetryable bool
+		wantTemporary bool
+	}{
+		{"rate limited", &ProviderError{StatusCode: 429, Err: ErrRateLimitExceeded}, true, true},
+		{"overloaded", &ProviderError{StatusCode: 529, Err: ErrOverloaded}, true, true},
+		{"server error status only", &ProviderError{StatusCode: 502}, true, true},
+		{"bad request", &ProviderError{StatusCode: 400, Err: ErrInvalidRequest}, false, false},
+		{"invalid credentials", &ProviderError{StatusCode: 401, Err: ErrInvalidCredentials}, false, false},
+		{"stream error without status", &ProviderError{Err: ErrOverloaded}, true, true},
+		{"wrapped provider error", fmt.Errorf("chat: %w", &ProviderError{StatusCode: 503, Err: ErrProviderError}), true, true},
+		{"context too long", &ContextLengthError{Model: "gpt-4"}, false, false},
+		{"invalid parameters", &InvalidRequestError{Model: "gpt-4"}, false, false},
+		{"timeout sentinel", fmt.Errorf("no event for 30s: %w", ErrTimeout), true, true},
+		{"budget exceeded", ErrBudgetExceeded, false, false},
+		{"plain error", errors.New("boom"), false, false},
+		{"nil", nil, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.wantRetryable {
+				t.Errorf("IsRetryable() = %v, want %v", got, tt.wantRetryable)
+			}
+			if got := IsTemporary(tt.err); got != tt.wantTemporary {
+				t.Errorf("IsTemporary() = %v, want %v", got, tt.wantTemporary)
+			}
+		})
+	}
+}
+
+func TestInvalidRequestError(t *testing.T) {
+	err := error(&InvalidRequestError{
+		Provider: "openai",
+		Model:    "gpt-4o",
+		Fields: []FieldError{
+			{Field: "temperature", Value: float32(3), Reason: "must be between 0 and 2"},
+			{Field: "top_p", Value: float32(-1), Reason: "must be between 0 and 1"},
+		},
+	})
+	want := "invalid request for openai gpt-4o: temperature 3 must be between 0 and 2; top_p -1 must be between 0 and 1"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+	if !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("errors.Is(err, ErrInvalidRequest) = false, want true")
+	}
+}
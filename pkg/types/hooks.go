@@ -0,0 +1,22 @@
+package types
+
+import "context"
+
+// Hooks defines middleware callbacks that run around chat requests.
+// Any field may be nil. When several Hooks are configured they run in the
+// order they were registered.
+type Hooks struct {
+	// Request hooks, called for both Chat and StreamChat
+	OnRequest  func(ctx context.Context, req *ChatRequest) error                     // Called before dispatch with a copy of the request it may change; returning an error aborts the request
+	OnResponse func(ctx context.Context, req *ChatRequest, resp *ChatResponse) error // Called after a successful Chat; returning an error fails the call
+
+	// Stream hooks, called only for StreamChat
+	OnStreamStart func(ctx context.Context, req *ChatRequest)                  // Called before the stream is opened
+	OnChunk       func(ctx context.Context, chunk *ChatResponse) *ChatResponse // Called for each chunk; return a modified chunk, or nil to drop it
+	OnStreamEnd   func(ctx context.Context, req *ChatRequest, err error)       // Called once when the stream finishes, with the first error seen
+
+	// OnStream wraps the provider's stream before the chunk hooks see it,
+	// for transforms that span chunks such as FilterStream. The returned
+	// channel must close when the wrapped one does.
+	OnStream func(ctx context.Context, stream <-chan *ChatResponse) <-chan *ChatResponse
+}
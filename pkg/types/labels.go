@@ -0,0 +1,62 @@
+package types
+
+import (
+	"context"
+	"fmt"
+)
+
+// Common label keys for attributing usage and cost
+const (
+	LabelTenant  = "tenant"
+	LabelUser    = "user"
+	LabelFeature = "feature"
+)
+
+type labelsKey struct{}
+
+// WithLabels returns a context whose requests are attributed to labels,
+// such as {LabelTenant: "acme"}. Labels already on ctx are kept unless
+// overridden.
+func WithLabels(ctx context.Context, labels map[string]string) context.Context {
+	return context.WithValue(ctx, labelsKey{}, MergeLabels(LabelsFromContext(ctx), labels))
+}
+
+// LabelsFromContext returns the labels set with WithLabels, or nil
+func LabelsFromContext(ctx context.Context) map[string]string {
+	labels, _ := ctx.Value(labelsKey{}).(map[string]string)
+	return labels
+}
+
+// MergeLabels returns a new map with the labels of each argument in turn,
+// later ones overriding earlier ones. It returns nil if there are none.
+func MergeLabels(sets ...map[string]string) map[string]string {
+	var out map[string]string
+	for _, labels := range sets {
+		for k, v := range labels {
+			if out == nil {
+				out = make(map[string]string)
+			}
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// MetadataLabels returns the string, number and boolean values of a
+// request's RequestMetadata as labels, so they can attribute its usage and
+// cost. Other values are left out. It returns nil if there are none.
+func MetadataLabels(metadata map[string]any) map[string]string {
+	var out map[string]string
+	for k, v := range metadata {
+		switch v.(type) {
+		case string, bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+		default:
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string)
+		}
+		out[k] = fmt.Sprint(v)
+	}
+	return out
+}
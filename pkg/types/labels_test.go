@@ -0,0 +1,33 @@
+package types
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestWithLabels(t *testing.T) {
+	ctx := context.Background()
+	if got := LabelsFromContext(ctx); got != nil {
+		t.Errorf("LabelsFromContext() = %v, want nil", got)
+	}
+
+	ctx = WithLabels(ctx, map[string]string{LabelTenant: "acme", LabelFeature: "search"})
+	ctx = WithLabels(ctx, map[string]string{LabelUser: "u1", LabelFeature: "chat"})
+
+	want := map[string]string{LabelTenant: "acme", LabelUser: "u1", LabelFeature: "chat"}
+	if got := LabelsFromContext(ctx); !reflect.DeepEqual(got, want) {
+		t.Errorf("LabelsFromContext() = %v, want %v", got, want)
+	}
+}
+
+func TestMetadataLabels(t *testing.T) {
+	got := MetadataLabels(map[string]any{"user": "u1", "attempt": 2, "score": 0.5, "beta": true, "tags": []any{"a"}, "nested": map[string]any{"k": "v"}})
+	want := map[string]string{"user": "u1", "attempt": "2", "score": "0.5", "beta": "true"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MetadataLabels() = %v, want %v", got, want)
+	}
+	if got := MetadataLabels(map[string]any{"tags": []any{"a"}}); got != nil {
+		t.Errorf("MetadataLabels() = %v, want nil", got)
+	}
+}
@@ -12,12 +12,19 @@ const (
 	RoleSystem    Role = "system"
 	RoleUser      Role = "user"
 	RoleAssistant Role = "assistant"
+	RoleTool      Role = "tool"
+
+	// RoleFunction is the legacy OpenAI role for function call results.
+	// New code should use RoleTool.
+	RoleFunction Role = "function"
 )
 
 var (
 	ErrEmptyRole    = errors.New("message role cannot be empty")
 	ErrInvalidRole  = errors.New("invalid message role")
 	ErrEmptyContent = errors.New("message content cannot be empty")
+	ErrEmptyToolID  = errors.New("tool message requires a tool call ID")
+	ErrEmptyName    = errors.New("function message requires a name")
 )
 
 // Message represents a single message in a conversation
@@ -25,6 +32,48 @@ type Message struct {
 	Role     Role           `json:"role"`
 	Content  string         `json:"content"`
 	Metadata map[string]any `json:"metadata,omitempty"`
+
+	// Name identifies the function for RoleFunction messages, or optionally
+	// the participant for other roles
+	Name string `json:"name,omitempty"`
+
+	// ToolCallID links a RoleTool message to the tool call it answers
+	ToolCallID string `json:"tool_call_id,omitempty"`
+
+	// ToolCalls lists the tools an assistant message asks to run
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+}
+
+// SystemMessage returns a system message
+func SystemMessage(content string) Message {
+	return Message{Role: RoleSystem, Content: content}
+}
+
+// UserMessage returns a user message
+func UserMessage(content string) Message {
+	return Message{Role: RoleUser, Content: content}
+}
+
+// AssistantMessage returns an assistant message, for example a previous
+// reply or a few-shot example
+func AssistantMessage(content string) Message {
+	return Message{Role: RoleAssistant, Content: content}
+}
+
+// ToolResult returns a tool message answering the tool call with the given
+// ID
+func ToolResult(id, content string) Message {
+	return Message{Role: RoleTool, ToolCallID: id, Content: content}
+}
+
+// Messages collects messages into a conversation
+//
+//	req := types.NewChatRequest(types.Messages(
+//		types.SystemMessage("You are a terse assistant."),
+//		types.UserMessage("What is the capital of France?"),
+//	))
+func Messages(msgs ...Message) []Message {
+	return msgs
 }
 
 // Validate ensures the message meets all requirements
@@ -33,11 +82,22 @@ func (m *Message) Validate() error {
 		return ErrEmptyRole
 	}
 
-	if m.Role != RoleSystem && m.Role != RoleUser && m.Role != RoleAssistant {
+	switch m.Role {
+	case RoleSystem, RoleUser, RoleAssistant:
+	case RoleTool:
+		if m.ToolCallID == "" {
+			return ErrEmptyToolID
+		}
+	case RoleFunction:
+		if m.Name == "" {
+			return ErrEmptyName
+		}
+	default:
 		return fmt.Errorf("%w: %s", ErrInvalidRole, m.Role)
 	}
 
-	if m.Content == "" {
+	// An assistant message may consist solely of tool calls
+	if m.Content == "" && len(m.ToolCalls) == 0 {
 		return ErrEmptyContent
 	}
 
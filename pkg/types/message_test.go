@@ -2,6 +2,7 @@ package types
 
 import (
 	"encoding/json"
+	"reflect"
 	"testing"
 )
 
@@ -93,3 +94,81 @@ func TestMessage_JSON(t *testing.T) {
 		t.Errorf("Content mismatch: got %v, want %v", decoded.Content, msg.Content)
 	}
 }
+
+func TestMessage_ToolValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		message Message
+		wantErr error
+	}{
+		{
+			name: "valid tool message",
+			message: Message{
+				Role:       RoleTool,
+				Content:    `{"temperature": 21}`,
+				ToolCallID: "call_123",
+			},
+		},
+		{
+			name: "tool message without call ID",
+			message: Message{
+				Role:    RoleTool,
+				Content: `{"temperature": 21}`,
+			},
+			wantErr: ErrEmptyToolID,
+		},
+		{
+			name: "valid function message",
+			message: Message{
+				Role:    RoleFunction,
+				Content: `{"temperature": 21}`,
+				Name:    "get_weather",
+			},
+		},
+		{
+			name: "function message without name",
+			message: Message{
+				Role:    RoleFunction,
+				Content: `{"temperature": 21}`,
+			},
+			wantErr: ErrEmptyName,
+		},
+		{
+			name: "assistant message with only tool calls",
+			message: Message{
+				Role:      RoleAssistant,
+				ToolCalls: []ToolCall{{ID: "call_123", Name: "get_weather", Arguments: `{}`}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.message.Validate()
+			if err != tt.wantErr {
+				t.Errorf("Message.Validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMessageConstructors(t *testing.T) {
+	got := Messages(
+		SystemMessage("Be terse."),
+		UserMessage("Weather in Paris?"),
+		AssistantMessage(""),
+		ToolResult("call_123", `{"temperature": 21}`),
+	)
+	want := []Message{
+		{Role: RoleSystem, Content: "Be terse."},
+		{Role: RoleUser, Content: "Weather in Paris?"},
+		{Role: RoleAssistant},
+		{Role: RoleTool, ToolCallID: "call_123", Content: `{"temperature": 21}`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Messages() = %+v, want %+v", got, want)
+	}
+	if err := got[3].Validate(); err != nil {
+		t.Errorf("ToolResult().Validate() error = %v", err)
+	}
+}
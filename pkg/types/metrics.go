@@ -10,8 +10,84 @@ type MetricsCallbacks struct {
 	OnError    func(provider string, err error)              // Called when a request fails
 	OnRetry    func(provider string, attempt int, err error) // Called before each retry attempt
 
+	// Rate limit metrics
+	OnRateLimits func(provider string, limits RateLimits) // Called with the rate limit state reported by each provider response, including rate limited ones
+
+	// Token and streaming metrics
+	OnUsage          func(provider, model string, usage Usage)                                    // Called with the token usage of each billed request or stream
+	OnUsageMetadata  func(provider, model string, usage Usage, metadata map[string]any)           // Called alongside OnUsage with the request's RequestMetadata
+	OnFirstToken     func(provider string, ttft time.Duration)                                    // Called when a stream delivers its first content
+	OnStreamComplete func(provider string, chunks, completionTokens int, tokensPerSecond float64) // Called when a stream ends with its generated tokens and throughput
+
 	// Pool metrics
 	OnPoolGet       func(provider string, waitTime time.Duration) // Called when a connection is retrieved from the pool
 	OnPoolRelease   func(provider string)                         // Called when a connection is released back to the pool
 	OnPoolExhausted func(provider string)                         // Called when pool is exhausted (all connections in use)
+
+	// Capacity metrics
+	OnGauges func(provider string, gauges Gauges) // Called periodically with a capacity snapshot
+
+	// API key metrics
+	OnKeyHealth func(provider string, key KeyHealth) // Called when one of several API keys is rate limited or rejected
+
+	// Model lifecycle metrics
+	OnModelDeprecated func(provider, model, successor string, retiresAt time.Time) // Called at client creation when the configured model is deprecated or retired
+}
+
+// Gauges is a point-in-time snapshot of a client's capacity
+type Gauges struct {
+	IdleConnections   int           // Pooled connections waiting for reuse
+	ActiveConnections int           // Pooled connections checked out by requests
+	ActiveRequests    int           // Requests and streams in flight
+	QueueDepth        int           // Requests waiting for the rate limiter, a concurrency slot or a connection
+	LimiterWait       time.Duration // How long a request made now would wait for the rate limiter
+}
+
+// KeyHealth is the state of one of a client's API keys
+type KeyHealth struct {
+	Key          string    // The key's last four characters, for identification
+	Healthy      bool      // Whether the key is currently used for requests
+	Revoked      bool      // Whether the provider rejected the key as invalid
+	CoolingUntil time.Time // When a rate limited key is used again; zero if not rate limited
+	Requests     int64     // Requests sent with the key
+	RateLimited  int64     // 429 responses to the key
+	Unauthorized int64     // 401 responses to the key
+}
+
+// Health is a provider's condition judged from a client's recent requests
+type Health int
+
+const (
+	HealthHealthy   Health = iota // Requests succeed at normal latency
+	HealthDegraded                // Some requests fail, or responses are slow
+	HealthUnhealthy               // Most requests fail
+)
+
+func (h Health) String() string {
+	switch h {
+	case HealthHealthy:
+		return "healthy"
+	case HealthDegraded:
+		return "degraded"
+	case HealthUnhealthy:
+		return "unhealthy"
+	default:
+		return "unknown"
+	}
+}
+
+// ProviderStatus is a client's view of its provider over a recent window
+type ProviderStatus struct {
+	Provider  string
+	Model     string
+	Health    Health
+	Requests  int     // Requests that reached the provider or failed because of it
+	Errors    int     // Requests that failed because of the provider, such as rate limits, overloads and timeouts
+	ErrorRate float64 // Errors as a fraction of Requests
+
+	// Latency percentiles of the successful requests: time to the response,
+	// or to the first token of a stream
+	P50 time.Duration
+	P95 time.Duration
+	P99 time.Duration
 }
@@ -0,0 +1,107 @@
+package types
+
+import "time"
+
+// RequestOption configures a ChatRequest
+type RequestOption func(*ChatRequest)
+
+// NewChatRequest creates a chat request from messages and options
+func NewChatRequest(messages []Message, opts ...RequestOption) *ChatRequest {
+	req := &ChatRequest{Messages: messages}
+	req.Apply(opts...)
+	return req
+}
+
+// Apply applies options to the request in order
+func (r *ChatRequest) Apply(opts ...RequestOption) {
+	for _, opt := range opts {
+		opt(r)
+	}
+}
+
+// WithSystem prepends a system message to the request
+func WithSystem(content string) RequestOption {
+	return func(r *ChatRequest) {
+		r.Messages = append([]Message{SystemMessage(content)}, r.Messages...)
+	}
+}
+
+// WithMaxTokens sets the maximum number of tokens to generate
+func WithMaxTokens(n int) RequestOption {
+	return func(r *ChatRequest) {
+		r.MaxTokens = n
+	}
+}
+
+// WithTemperature sets the sampling temperature. Zero is sent as zero, for
+// the most deterministic output.
+func WithTemperature(t float32) RequestOption {
+	return func(r *ChatRequest) {
+		r.Temperature = &t
+	}
+}
+
+// Float32 returns a pointer to v, for setting Temperature or TopP in a
+// request literal
+func Float32(v float32) *float32 {
+	return &v
+}
+
+// WithTopP sets nucleus sampling
+func WithTopP(p float32) RequestOption {
+	return func(r *ChatRequest) {
+		r.TopP = &p
+	}
+}
+
+// WithStop sets the stop sequences
+func WithStop(stop ...string) RequestOption {
+	return func(r *ChatRequest) {
+		r.Stop = stop
+	}
+}
+
+// WithPresencePenalty sets the presence penalty
+func WithPresencePenalty(p float32) RequestOption {
+	return func(r *ChatRequest) {
+		r.PresencePenalty = p
+	}
+}
+
+// WithFrequencyPenalty sets the frequency penalty
+func WithFrequencyPenalty(p float32) RequestOption {
+	return func(r *ChatRequest) {
+		r.FrequencyPenalty = p
+	}
+}
+
+// WithUser sets the end-user identifier sent to the provider
+func WithUser(user string) RequestOption {
+	return func(r *ChatRequest) {
+		r.User = user
+	}
+}
+
+// WithTools adds tools the model may call
+func WithTools(tools ...Tool) RequestOption {
+	return func(r *ChatRequest) {
+		r.Tools = append(r.Tools, tools...)
+	}
+}
+
+// WithRequestTimeout overrides the client timeout for the request
+func WithRequestTimeout(d time.Duration) RequestOption {
+	return func(r *ChatRequest) {
+		r.Timeout = d
+	}
+}
+
+// WithProviderParam sets a provider-specific request parameter
+func WithProviderParam(key string, value any) RequestOption {
+	return func(r *ChatRequest) {
+		if r.ProviderParams == nil {
+			r.ProviderParams = make(map[string]any)
+		}
+		r.ProviderParams[key] = value
+	}
+}
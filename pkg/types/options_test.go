@@ -0,0 +1,43 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewChatRequest(t *testing.T) {
+	req := NewChatRequest(
+		[]Message{{Role: RoleUser, Content: "Hello"}},
+		WithSystem("Be brief"),
+		WithMaxTokens(500),
+		WithTemperature(0.2),
+		WithTopP(0.9),
+		WithStop("\n", "END"),
+		WithPresencePenalty(0.1),
+		WithFrequencyPenalty(0.3),
+		WithUser("user-1"),
+		WithRequestTimeout(time.Second),
+		WithProviderParam("seed", 42),
+	)
+
+	want := &ChatRequest{
+		Messages: []Message{
+			{Role: RoleSystem, Content: "Be brief"},
+			{Role: RoleUser, Content: "Hello"},
+		},
+		MaxTokens:        500,
+		Temperature:      Float32(0.2),
+		TopP:             Float32(0.9),
+		Stop:             []string{"\n", "END"},
+		PresencePenalty:  0.1,
+		FrequencyPenalty: 0.3,
+		User:             "user-1",
+		Timeout:          time.Second,
+		ProviderParams:   map[string]any{"seed": 42},
+	}
+
+	if !reflect.DeepEqual(req, want) {
+		t.Errorf("NewChatRequest() = %+v, want %+v", req, want)
+	}
+}
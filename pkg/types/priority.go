@@ -0,0 +1,32 @@
+package types
+
+import "context"
+
+// Priority orders requests waiting for capacity when the client's
+// concurrency limit or connection pool is saturated. Higher priorities are
+// served first; requests of equal priority are served in arrival order.
+type Priority int
+
+const (
+	// PriorityBackground is for batch and other latency-insensitive work
+	PriorityBackground Priority = -1
+	// PriorityNormal is the default priority
+	PriorityNormal Priority = 0
+	// PriorityInteractive is for requests a user is waiting on
+	PriorityInteractive Priority = 1
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose requests wait for capacity at
+// priority p
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext returns the priority set with WithPriority, or
+// PriorityNormal
+func PriorityFromContext(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
@@ -1,6 +1,9 @@
 package types
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var (
 	ErrEmptyPrompt   = errors.New("prompt cannot be empty")
@@ -11,13 +14,37 @@ var (
 type CompletionRequest struct {
 	Prompt           string         `json:"prompt"`
 	MaxTokens        int            `json:"max_tokens,omitempty"`
-	Temperature      float32        `json:"temperature,omitempty"`
-	TopP             float32        `json:"top_p,omitempty"`
+	Temperature      *float32       `json:"temperature,omitempty"` // nil leaves the provider's default
+	TopP             *float32       `json:"top_p,omitempty"`       // nil leaves the provider's default
 	Stop             []string       `json:"stop,omitempty"`
 	PresencePenalty  float32        `json:"presence_penalty,omitempty"`
 	FrequencyPenalty float32        `json:"frequency_penalty,omitempty"`
 	User             string         `json:"user,omitempty"`
 	RequestMetadata  map[string]any `json:"request_metadata,omitempty"`
+
+	// ProviderParams are merged into the outgoing provider request body as-is,
+	// overriding any field the provider would otherwise set. This allows new
+	// provider parameters to be used before the library supports them.
+	ProviderParams map[string]any `json:"provider_params,omitempty"`
+
+	// IdempotencyKey identifies a logical request across resubmissions. It is
+	// sent to providers that support idempotent requests and used by the
+	// client to de-duplicate retries.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// Timeout overrides the client's configured timeout for this request.
+	// For streams it bounds the whole stream, not just the first chunk.
+	Timeout time.Duration `json:"-"`
+
+	// Priority orders this request against others waiting for capacity
+	// when the client is saturated. It overrides any priority set on the
+	// context with WithPriority.
+	Priority Priority `json:"-"`
+
+	// Labels attribute this request's usage and cost, for example to a
+	// tenant or feature. They are merged over any labels set on the context
+	// with WithLabels.
+	Labels map[string]string `json:"-"`
 }
 
 // Validate ensures the completion request is valid
@@ -30,15 +57,56 @@ func (r *CompletionRequest) Validate() error {
 
 // ChatRequest represents a request for chat completion
 type ChatRequest struct {
+	// System holds system prompts that come before Messages, in order.
+	// Providers send them where their API expects: as leading system
+	// messages for OpenAI, and as the top-level system blocks for Anthropic.
+	System []string `json:"system,omitempty"`
+
 	Messages         []Message      `json:"messages"`
 	MaxTokens        int            `json:"max_tokens,omitempty"`
-	Temperature      float32        `json:"temperature,omitempty"`
-	TopP             float32        `json:"top_p,omitempty"`
+	Temperature      *float32       `json:"temperature,omitempty"` // nil leaves the provider's default
+	TopP             *float32       `json:"top_p,omitempty"`       // nil leaves the provider's default
 	Stop             []string       `json:"stop,omitempty"`
 	PresencePenalty  float32        `json:"presence_penalty,omitempty"`
 	FrequencyPenalty float32        `json:"frequency_penalty,omitempty"`
 	User             string         `json:"user,omitempty"`
 	RequestMetadata  map[string]any `json:"request_metadata,omitempty"`
+
+	// Tools lists the tools the model may call
+	Tools []Tool `json:"tools,omitempty"`
+
+	// ProviderParams are merged into the outgoing provider request body as-is,
+	// overriding any field the provider would otherwise set. This allows new
+	// provider parameters to be used before the library supports them.
+	ProviderParams map[string]any `json:"provider_params,omitempty"`
+
+	// IdempotencyKey identifies a logical request across resubmissions. It is
+	// sent to providers that support idempotent requests and used by the
+	// client to de-duplicate retries.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// Timeout overrides the client's configured timeout for this request.
+	// For streams it bounds the whole stream, not just the first chunk.
+	Timeout time.Duration `json:"-"`
+
+	// Priority orders this request against others waiting for capacity
+	// when the client is saturated. It overrides any priority set on the
+	// context with WithPriority.
+	Priority Priority `json:"-"`
+
+	// Labels attribute this request's usage and cost, for example to a
+	// tenant or feature. They are merged over any labels set on the context
+	// with WithLabels.
+	Labels map[string]string `json:"-"`
+}
+
+// SystemMessages returns the request's System prompts as system messages
+func (r *ChatRequest) SystemMessages() []Message {
+	msgs := make([]Message, 0, len(r.System))
+	for _, s := range r.System {
+		msgs = append(msgs, SystemMessage(s))
+	}
+	return msgs
 }
 
 // Validate ensures the chat request is valid
@@ -53,6 +121,12 @@ func (r *ChatRequest) Validate() error {
 		}
 	}
 
+	for _, tool := range r.Tools {
+		if err := tool.Validate(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -11,16 +11,53 @@ var (
 	ErrMissingModel    = errors.New("model is required")
 )
 
+// FinishReason describes why the model stopped generating, normalized across providers
+type FinishReason string
+
+const (
+	FinishReasonStop          FinishReason = "stop"
+	FinishReasonLength        FinishReason = "length"
+	FinishReasonToolCalls     FinishReason = "tool_calls"
+	FinishReasonContentFilter FinishReason = "content_filter"
+	FinishReasonMaxTokens     FinishReason = "max_tokens"
+	FinishReasonUnknown       FinishReason = "unknown"
+)
+
+// Truncated reports whether generation was cut off by a token limit
+func (f FinishReason) Truncated() bool {
+	return f == FinishReasonLength || f == FinishReasonMaxTokens
+}
+
 // Response represents a common response structure
 type Response struct {
-	ID         string    `json:"id"`
-	Created    time.Time `json:"created"`
-	Provider   string    `json:"provider"`
-	Model      string    `json:"model"`
-	Message    Message   `json:"message"`
-	StopReason string    `json:"stop_reason"`
-	Usage      Usage     `json:"usage"`
-	Error      error     `json:"-"`
+	ID           string       `json:"id"`
+	Created      time.Time    `json:"created"`
+	Provider     string       `json:"provider"`
+	Model        string       `json:"model"`
+	Message      Message      `json:"message"`
+	FinishReason FinishReason `json:"finish_reason,omitempty"`
+	Usage        Usage        `json:"usage"`
+	Error        error        `json:"-"`
+
+	// RawFinishReason is the stop reason exactly as reported by the provider
+	RawFinishReason string `json:"raw_finish_reason,omitempty"`
+
+	// Cached is true when the response was served from a response cache
+	Cached bool `json:"cached,omitempty"`
+
+	// DowngradedFrom is the model the request was meant for when the
+	// provider rate limited it and it was retried on the client's downgrade
+	// model, which Model then names
+	DowngradedFrom string `json:"downgraded_from,omitempty"`
+
+	// RateLimits is the rate limit state the provider reported with the
+	// response, for pacing requests. For streams only the first chunk has
+	// it. It is nil when the provider reported none.
+	RateLimits *RateLimits `json:"rate_limits,omitempty"`
+
+	// Metadata is the RequestMetadata of the request this responds to, so
+	// per-request details such as a user ID survive the round trip
+	Metadata map[string]any `json:"metadata,omitempty"`
 }
 
 // CompletionResponse represents a completion response
@@ -67,8 +104,12 @@ func (r *Response) Validate() error {
 	return nil
 }
 
-// Total returns the total number of tokens used
+// Total returns the total number of tokens used, adding the prompt and
+// completion tokens if the provider did not report a total
 func (u Usage) Total() int {
+	if u.TotalTokens == 0 {
+		return u.PromptTokens + u.CompletionTokens
+	}
 	return u.TotalTokens
 }
 
@@ -79,3 +120,15 @@ func (e *APIError) Error() string {
 	}
 	return e.Message
 }
+
+// RateLimits is a provider's rate limit state as of a response. Limits and
+// remaining counts are -1 when the provider did not report them, and reset
+// times are zero.
+type RateLimits struct {
+	RequestsLimit     int       `json:"requests_limit"`
+	RequestsRemaining int       `json:"requests_remaining"`
+	RequestsReset     time.Time `json:"requests_reset,omitempty"`
+	TokensLimit       int       `json:"tokens_limit"`
+	TokensRemaining   int       `json:"tokens_remaining"`
+	TokensReset       time.Time `json:"tokens_reset,omitempty"`
+}
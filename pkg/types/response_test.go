@@ -111,6 +111,11 @@ func TestUsage_Total(t *testing.T) {
 			},
 			expected: 30,
 		},
+		{
+			name:     "no total reported",
+			usage:    Usage{PromptTokens: 10, CompletionTokens: 5},
+			expected: 15,
+		},
 	}
 
 	for _, tt := range tests {
@@ -121,3 +126,22 @@ func TestUsage_Total(t *testing.T) {
 		})
 	}
 }
+
+func TestFinishReason_Truncated(t *testing.T) {
+	tests := []struct {
+		reason FinishReason
+		want   bool
+	}{
+		{FinishReasonStop, false},
+		{FinishReasonLength, true},
+		{FinishReasonMaxTokens, true},
+		{FinishReasonToolCalls, false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.reason.Truncated(); got != tt.want {
+			t.Errorf("FinishReason(%q).Truncated() = %v, want %v", tt.reason, got, tt.want)
+		}
+	}
+}
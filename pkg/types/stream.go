@@ -0,0 +1,450 @@
+package types
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Accumulator folds the chunks of a streamed chat response into one
+// ChatResponse. Content is concatenated. Identifying fields are taken from
+// the first chunk that has them. The finish reason and usage are taken from
+// the last chunk that reports them, as providers send running totals.
+//
+// Tool calls may arrive in pieces. A chunk's tool call with an ID not seen
+// before starts a new call. One without an ID continues the last call, and
+// its name and arguments are appended.
+type Accumulator struct {
+	resp    ChatResponse
+	content strings.Builder
+	err     error
+}
+
+// Add folds a chunk into the response. The first chunk error is kept and
+// returned by Err.
+func (a *Accumulator) Add(chunk *ChatResponse) {
+	if chunk == nil {
+		return
+	}
+	if chunk.Error != nil {
+		if a.err == nil {
+			a.err = chunk.Error
+		}
+		return
+	}
+
+	r := &a.resp
+	if r.ID == "" {
+		r.ID = chunk.ID
+	}
+	if r.Created.IsZero() {
+		r.Created = chunk.Created
+	}
+	if r.Provider == "" {
+		r.Provider = chunk.Provider
+	}
+	if r.Model == "" {
+		r.Model = chunk.Model
+	}
+	if r.Message.Role == "" {
+		r.Message.Role = chunk.Message.Role
+	}
+	if chunk.FinishReason != "" {
+		r.FinishReason = chunk.FinishReason
+		r.RawFinishReason = chunk.RawFinishReason
+	}
+	if chunk.Usage.PromptTokens != 0 {
+		r.Usage.PromptTokens = chunk.Usage.PromptTokens
+	}
+	if chunk.Usage.CompletionTokens != 0 {
+		r.Usage.CompletionTokens = chunk.Usage.CompletionTokens
+	}
+	if chunk.Usage.TotalTokens != 0 {
+		r.Usage.TotalTokens = chunk.Usage.TotalTokens
+	}
+	r.Cached = r.Cached || chunk.Cached
+	if r.DowngradedFrom == "" {
+		r.DowngradedFrom = chunk.DowngradedFrom
+	}
+
+	a.content.WriteString(chunk.Message.Content)
+	for _, call := range chunk.Message.ToolCalls {
+		a.addToolCall(call)
+	}
+}
+
+func (a *Accumulator) addToolCall(call ToolCall) {
+	calls := a.resp.Message.ToolCalls
+	if call.ID != "" {
+		for i := range calls {
+			if calls[i].ID == call.ID {
+				calls[i].Name += call.Name
+				calls[i].Arguments += call.Arguments
+				return
+			}
+		}
+	}
+	if call.ID == "" && len(calls) > 0 {
+		last := &calls[len(calls)-1]
+		last.Name += call.Name
+		last.Arguments += call.Arguments
+		return
+	}
+	a.resp.Message.ToolCalls = append(calls, call)
+}
+
+// Response returns the response accumulated so far. The role defaults to
+// assistant and the total tokens to the sum of prompt and completion tokens.
+func (a *Accumulator) Response() *ChatResponse {
+	resp := a.resp
+	resp.Message.Content = a.content.String()
+	resp.Message.ToolCalls = append([]ToolCall(nil), a.resp.Message.ToolCalls...)
+	if resp.Message.Role == "" {
+		resp.Message.Role = RoleAssistant
+	}
+	if resp.Usage.TotalTokens == 0 {
+		resp.Usage.TotalTokens = resp.Usage.PromptTokens + resp.Usage.CompletionTokens
+	}
+	return &resp
+}
+
+// Err returns the first error a chunk carried, if any
+func (a *Accumulator) Err() error {
+	return a.err
+}
+
+// CollectStream reads a stream to its end and returns the accumulated
+// response. If a chunk carried an error, the first error is returned along
+// with whatever was received.
+func CollectStream(ch <-chan *ChatResponse) (*ChatResponse, error) {
+	var acc Accumulator
+	for chunk := range ch {
+		acc.Add(chunk)
+	}
+	return acc.Response(), acc.Err()
+}
+
+// WriteStream writes each chunk's content to w as it arrives and returns the
+// accumulated response, as CollectStream does. With flush, w is flushed
+// after every chunk when it has a Flush method, as http.ResponseWriter and
+// bufio.Writer do.
+//
+// If a write fails the rest of the stream is drained without writing and
+// the write error is returned. Otherwise the first chunk error, if any, is
+// returned.
+func WriteStream(w io.Writer, ch <-chan *ChatResponse, flush bool) (*ChatResponse, error) {
+	var acc Accumulator
+	var writeErr error
+	for chunk := range ch {
+		acc.Add(chunk)
+		if writeErr != nil || chunk == nil || chunk.Error != nil || chunk.Message.Content == "" {
+			continue
+		}
+		if _, writeErr = io.WriteString(w, chunk.Message.Content); writeErr != nil {
+			continue
+		}
+		if flush {
+			writeErr = flushWriter(w)
+		}
+	}
+	if writeErr != nil {
+		return acc.Response(), writeErr
+	}
+	return acc.Response(), acc.Err()
+}
+
+// flushWriter flushes w if it supports flushing
+func flushWriter(w io.Writer) error {
+	switch f := w.(type) {
+	case interface{ Flush() error }:
+		return f.Flush()
+	case interface{ Flush() }:
+		f.Flush()
+	}
+	return nil
+}
+
+// TeeStream duplicates a stream to n consumers. Each consumer receives
+// every chunk in order through its own queue, so a slow consumer holds back
+// neither the others nor the source, which is read as fast as it produces.
+// The chunks are shared between consumers and must not be modified.
+//
+// Each returned channel closes after the source does and its queue is
+// drained, or when ctx is done. Consumers that stop reading early should
+// cancel ctx, which releases their queued chunks.
+func TeeStream(ctx context.Context, ch <-chan *ChatResponse, n int) []<-chan *ChatResponse {
+	queues := make([]*streamQueue, n)
+	outs := make([]<-chan *ChatResponse, n)
+	for i := range queues {
+		q := &streamQueue{ready: make(chan struct{}, 1)}
+		out := make(chan *ChatResponse)
+		queues[i], outs[i] = q, out
+		go q.run(ctx, out)
+	}
+
+	go func() {
+		for chunk := range ch {
+			if ctx.Err() != nil {
+				// Keep draining so the producer is not left blocked
+				continue
+			}
+			for _, q := range queues {
+				q.push(chunk)
+			}
+		}
+		for _, q := range queues {
+			q.close()
+		}
+	}()
+
+	return outs
+}
+
+// streamQueue is an unbounded queue feeding one TeeStream consumer
+type streamQueue struct {
+	mu     sync.Mutex
+	items  []*ChatResponse
+	closed bool
+	ready  chan struct{}
+}
+
+func (q *streamQueue) push(chunk *ChatResponse) {
+	q.mu.Lock()
+	q.items = append(q.items, chunk)
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *streamQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *streamQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// run sends queued chunks to out until the queue is closed and empty, or
+// ctx is done
+func (q *streamQueue) run(ctx context.Context, out chan<- *ChatResponse) {
+	defer close(out)
+	for {
+		q.mu.Lock()
+		items, closed := q.items, q.closed
+		q.items = nil
+		q.mu.Unlock()
+
+		for _, chunk := range items {
+			select {
+			case <-ctx.Done():
+				return
+			case out <- chunk:
+			}
+		}
+		if closed && len(items) == 0 {
+			return
+		}
+		if len(items) > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.ready:
+		}
+	}
+}
+
+// BufferMode sets the size of the chunks BufferStream emits
+type BufferMode int
+
+const (
+	// BufferWords emits whole words, ending at whitespace
+	BufferWords BufferMode = iota
+	// BufferSentences emits whole sentences, ending at a newline or at
+	// '.', '!' or '?' followed by whitespace
+	BufferSentences
+)
+
+// BufferStream coalesces a stream's content deltas into word or sentence
+// sized chunks, for smoother rendering or text-to-speech. Text is held
+// until it reaches a boundary. With a positive flushInterval, text held that
+// long is emitted anyway, so a slow stream does not stall.
+//
+// A chunk with a finish reason, tool calls, usage or an error first
+// releases all held text, so nothing is reordered. Held text is emitted when
+// the source closes. The returned channel closes after the source does, or
+// when ctx is done.
+func BufferStream(ctx context.Context, ch <-chan *ChatResponse, mode BufferMode, flushInterval time.Duration) <-chan *ChatResponse {
+	out := make(chan *ChatResponse)
+
+	go func() {
+		defer close(out)
+
+		var held strings.Builder
+		var last Response
+		var timer *time.Timer
+		var timeout <-chan time.Time
+
+		send := func(resp *ChatResponse) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case out <- resp:
+				return true
+			}
+		}
+		stopTimer := func() {
+			if timeout != nil && !timer.Stop() {
+				<-timer.C
+			}
+			timeout = nil
+		}
+		// emit sends the first n bytes of held text with the last chunk's
+		// identifying fields
+		emit := func(n int) bool {
+			if n == 0 {
+				return true
+			}
+			text := held.String()
+			held.Reset()
+			held.WriteString(text[n:])
+			if held.Len() == 0 {
+				stopTimer()
+			}
+			resp := &ChatResponse{Response: Response{
+				ID:       last.ID,
+				Created:  last.Created,
+				Provider: last.Provider,
+				Model:    last.Model,
+				Message:  Message{Role: last.Message.Role, Content: text[:n]},
+			}}
+			return send(resp)
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timeout:
+				timeout = nil
+				if !emit(held.Len()) {
+					return
+				}
+			case chunk, ok := <-ch:
+				if !ok {
+					emit(held.Len())
+					return
+				}
+				if chunk == nil {
+					continue
+				}
+				if hasMetadata(chunk) {
+					// Release held text ahead of the chunk's own content
+					if held.Len() > 0 && chunk.Error == nil {
+						merged := *chunk
+						merged.Message.Content = held.String() + chunk.Message.Content
+						held.Reset()
+						stopTimer()
+						chunk = &merged
+					} else if !emit(held.Len()) {
+						return
+					}
+					if !send(chunk) {
+						return
+					}
+					continue
+				}
+
+				last = chunk.Response
+				if held.Len() == 0 && chunk.Message.Content != "" && flushInterval > 0 {
+					if timer == nil {
+						timer = time.NewTimer(flushInterval)
+					} else {
+						timer.Reset(flushInterval)
+					}
+					timeout = timer.C
+				}
+				held.WriteString(chunk.Message.Content)
+				if !emit(bufferBoundary(held.String(), mode)) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// bufferBoundary returns the length of the leading part of text that ends
+// at the last boundary for mode, or 0 if there is none
+func bufferBoundary(text string, mode BufferMode) int {
+	for i := len(text); i > 0; {
+		r, size := utf8.DecodeLastRuneInString(text[:i])
+		i -= size
+		switch mode {
+		case BufferWords:
+			if unicode.IsSpace(r) {
+				return i + size
+			}
+		case BufferSentences:
+			if r == '\n' {
+				return i + size
+			}
+			if unicode.IsSpace(r) && i > 0 {
+				prev, _ := utf8.DecodeLastRuneInString(text[:i])
+				if prev == '.' || prev == '!' || prev == '?' {
+					return i + size
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// FilterStream applies transform to a stream's content before it reaches
+// the consumer, for example to mask words or sanitize markdown. The content
+// is first regrouped as BufferStream does, so transform sees whole words or
+// sentences and matches patterns that were split across deltas. Chunks
+// whose content transform empties are dropped unless they carry anything
+// else, such as a finish reason or an error.
+func FilterStream(ctx context.Context, ch <-chan *ChatResponse, mode BufferMode, transform func(string) string) <-chan *ChatResponse {
+	buffered := BufferStream(ctx, ch, mode, 0)
+	out := make(chan *ChatResponse)
+
+	go func() {
+		defer close(out)
+		for chunk := range buffered {
+			if chunk.Message.Content != "" {
+				filtered := *chunk
+				filtered.Message.Content = transform(chunk.Message.Content)
+				if filtered.Message.Content == "" && !hasMetadata(&filtered) {
+					continue
+				}
+				chunk = &filtered
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- chunk:
+			}
+		}
+	}()
+
+	return out
+}
+
+// hasMetadata reports whether a chunk carries anything besides content
+func hasMetadata(chunk *ChatResponse) bool {
+	return chunk.Error != nil || chunk.FinishReason != "" || len(chunk.Message.ToolCalls) > 0 || chunk.Usage != (Usage{})
+}
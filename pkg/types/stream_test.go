@@ -0,0 +1,442 @@
+package types
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func chunk(content string) *ChatResponse {
+	return &ChatResponse{Response: Response{Message: Message{Content: content}}}
+}
+
+func TestCollectStream(t *testing.T) {
+	boom := errors.New("connection reset")
+
+	tests := []struct {
+		name    string
+		chunks  []*ChatResponse
+		want    *ChatResponse
+		wantErr error
+	}{
+		{
+			name: "content and metadata",
+			chunks: []*ChatResponse{
+				{Response: Response{ID: "msg_1", Provider: "openai", Model: "gpt-4", Message: Message{Role: RoleAssistant, Content: "Hel"}}},
+				chunk("lo"),
+				{Response: Response{FinishReason: FinishReasonStop, RawFinishReason: "stop"}},
+				{Response: Response{Usage: Usage{PromptTokens: 5, CompletionTokens: 2}}},
+			},
+			want: &ChatResponse{Response: Response{
+				ID:              "msg_1",
+				Provider:        "openai",
+				Model:           "gpt-4",
+				Message:         Message{Role: RoleAssistant, Content: "Hello"},
+				FinishReason:    FinishReasonStop,
+				RawFinishReason: "stop",
+				Usage:           Usage{PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7},
+			}},
+		},
+		{
+			name: "usage running totals",
+			chunks: []*ChatResponse{
+				{Response: Response{Usage: Usage{PromptTokens: 10, CompletionTokens: 1}}},
+				chunk("Hi"),
+				{Response: Response{Usage: Usage{CompletionTokens: 4}}},
+			},
+			want: &ChatResponse{Response: Response{
+				Message: Message{Role: RoleAssistant, Content: "Hi"},
+				Usage:   Usage{PromptTokens: 10, CompletionTokens: 4, TotalTokens: 14},
+			}},
+		},
+		{
+			name: "tool call fragments",
+			chunks: []*ChatResponse{
+				{Response: Response{Message: Message{ToolCalls: []ToolCall{{ID: "call_1", Name: "get_weather"}}}}},
+				{Response: Response{Message: Message{ToolCalls: []ToolCall{{Arguments: `{"city":`}}}}},
+				{Response: Response{Message: Message{ToolCalls: []ToolCall{{Arguments: `"Paris"}`}}}}},
+				{Response: Response{Message: Message{ToolCalls: []ToolCall{{ID: "call_2", Name: "get_time", Arguments: "{}"}}}}},
+				{Response: Response{FinishReason: FinishReasonToolCalls}},
+			},
+			want: &ChatResponse{Response: Response{
+				Message: Message{Role: RoleAssistant, ToolCalls: []ToolCall{
+					{ID: "call_1", Name: "get_weather", Arguments: `{"city":"Paris"}`},
+					{ID: "call_2", Name: "get_time", Arguments: "{}"},
+				}},
+				FinishReason: FinishReasonToolCalls,
+			}},
+		},
+		{
+			name: "error keeps partial response",
+			chunks: []*ChatResponse{
+				chunk("Hel"),
+				{Response: Response{Error: boom}},
+				{Response: Response{Error: errors.New("later")}},
+			},
+			want:    &ChatResponse{Response: Response{Message: Message{Role: RoleAssistant, Content: "Hel"}}},
+			wantErr: boom,
+		},
+		{
+			name:   "empty stream",
+			chunks: nil,
+			want:   &ChatResponse{Response: Response{Message: Message{Role: RoleAssistant}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := make(chan *ChatResponse, len(tt.chunks))
+			for _, c := range tt.chunks {
+				ch <- c
+			}
+			close(ch)
+
+			got, err := CollectStream(ch)
+			if err != tt.wantErr {
+				t.Errorf("CollectStream() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CollectStream() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccumulator_ResponseIsSnapshot(t *testing.T) {
+	var acc Accumulator
+	acc.Add(&ChatResponse{Response: Response{Message: Message{Content: "a", ToolCalls: []ToolCall{{ID: "1", Arguments: "{"}}}}})
+	first := acc.Response()
+
+	acc.Add(&ChatResponse{Response: Response{Message: Message{Content: "b", ToolCalls: []ToolCall{{Arguments: "}"}}}}})
+	if first.Message.Content != "a" || first.Message.ToolCalls[0].Arguments != "{" {
+		t.Errorf("earlier Response() changed to %+v", first.Message)
+	}
+	if got := acc.Response(); got.Message.Content != "ab" || got.Message.ToolCalls[0].Arguments != "{}" {
+		t.Errorf("Response() = %+v, want content ab and arguments {}", got.Message)
+	}
+}
+
+func streamOf(chunks ...*ChatResponse) <-chan *ChatResponse {
+	ch := make(chan *ChatResponse, len(chunks))
+	for _, c := range chunks {
+		ch <- c
+	}
+	close(ch)
+	return ch
+}
+
+func TestWriteStream(t *testing.T) {
+	var buf strings.Builder
+	resp, err := WriteStream(&buf, streamOf(chunk("Hel"), chunk("lo"), &ChatResponse{Response: Response{FinishReason: FinishReasonStop}}), false)
+	if err != nil {
+		t.Fatalf("WriteStream() error = %v", err)
+	}
+	if buf.String() != "Hello" {
+		t.Errorf("written = %q, want %q", buf.String(), "Hello")
+	}
+	if resp.Message.Content != "Hello" || resp.FinishReason != FinishReasonStop {
+		t.Errorf("WriteStream() = %+v, want content Hello and finish reason stop", resp.Response)
+	}
+}
+
+func TestWriteStream_Flush(t *testing.T) {
+	// An http.ResponseWriter flushes with Flush()
+	rec := httptest.NewRecorder()
+	if _, err := WriteStream(rec, streamOf(chunk("Hi")), true); err != nil {
+		t.Fatalf("WriteStream() error = %v", err)
+	}
+	if !rec.Flushed || rec.Body.String() != "Hi" {
+		t.Errorf("Flushed, body = %v, %q, want true, Hi", rec.Flushed, rec.Body.String())
+	}
+
+	// A bufio.Writer flushes with Flush() error
+	var out strings.Builder
+	w := bufio.NewWriter(&out)
+	if _, err := WriteStream(w, streamOf(chunk("Hi")), true); err != nil {
+		t.Fatalf("WriteStream() error = %v", err)
+	}
+	if out.String() != "Hi" {
+		t.Errorf("flushed output = %q, want %q", out.String(), "Hi")
+	}
+
+	// Without flush nothing reaches the underlying writer
+	out.Reset()
+	w = bufio.NewWriter(&out)
+	WriteStream(w, streamOf(chunk("Hi")), false)
+	if out.Len() != 0 {
+		t.Errorf("unflushed output = %q, want none", out.String())
+	}
+}
+
+func TestWriteStream_Errors(t *testing.T) {
+	boom := errors.New("connection reset")
+	var buf strings.Builder
+	resp, err := WriteStream(&buf, streamOf(chunk("Hel"), &ChatResponse{Response: Response{Error: boom}}), false)
+	if err != boom || buf.String() != "Hel" || resp.Message.Content != "Hel" {
+		t.Errorf("WriteStream() = %q, %v, wrote %q, want Hel, %v", resp.Message.Content, err, buf.String(), boom)
+	}
+
+	writeErr := errors.New("broken pipe")
+	ch := make(chan *ChatResponse)
+	go func() {
+		defer close(ch)
+		for _, c := range []string{"a", "b", "c"} {
+			ch <- chunk(c)
+		}
+	}()
+	resp, err = WriteStream(errWriter{writeErr}, ch, false)
+	if err != writeErr {
+		t.Errorf("WriteStream() error = %v, want %v", err, writeErr)
+	}
+	// The stream is drained, so the producer is not left blocked
+	if resp.Message.Content != "abc" {
+		t.Errorf("accumulated content = %q, want abc", resp.Message.Content)
+	}
+}
+
+type errWriter struct{ err error }
+
+func (w errWriter) Write([]byte) (int, error) { return 0, w.err }
+
+func TestTeeStream(t *testing.T) {
+	src := make(chan *ChatResponse)
+	go func() {
+		defer close(src)
+		for _, c := range []string{"a", "b", "c"} {
+			src <- chunk(c)
+		}
+	}()
+
+	outs := TeeStream(context.Background(), src, 3)
+	if len(outs) != 3 {
+		t.Fatalf("TeeStream() returned %d channels, want 3", len(outs))
+	}
+
+	// The first two consumers finish while the third has not started
+	for i, out := range outs[:2] {
+		done := make(chan string)
+		go func(out <-chan *ChatResponse) {
+			resp, _ := CollectStream(out)
+			done <- resp.Message.Content
+		}(out)
+		select {
+		case got := <-done:
+			if got != "abc" {
+				t.Errorf("consumer %d got %q, want abc", i, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("consumer %d blocked by an idle consumer", i)
+		}
+	}
+
+	resp, _ := CollectStream(outs[2])
+	if resp.Message.Content != "abc" {
+		t.Errorf("slow consumer got %q, want abc", resp.Message.Content)
+	}
+}
+
+func TestTeeStream_Cancel(t *testing.T) {
+	src := make(chan *ChatResponse)
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		defer close(src)
+		for i := 0; i < 100; i++ {
+			src <- chunk("x")
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	outs := TeeStream(ctx, src, 2)
+	<-outs[0]
+	cancel()
+
+	for i, out := range outs {
+		select {
+		case <-drain(out):
+		case <-time.After(time.Second):
+			t.Fatalf("consumer %d not closed after cancellation", i)
+		}
+	}
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("source left blocked after cancellation")
+	}
+}
+
+// drain reads out to its end and reports when it closes
+func drain(out <-chan *ChatResponse) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for range out {
+		}
+		close(done)
+	}()
+	return done
+}
+
+func TestBufferStream(t *testing.T) {
+	boom := errors.New("connection reset")
+	deltas := []*ChatResponse{chunk("Hel"), chunk("lo wo"), chunk("rld! How"), chunk(" are")}
+
+	tests := []struct {
+		name   string
+		mode   BufferMode
+		chunks []*ChatResponse
+		want   []string
+	}{
+		{
+			name:   "words",
+			mode:   BufferWords,
+			chunks: deltas,
+			want:   []string{"Hello ", "world! ", "How ", "are"},
+		},
+		{
+			name:   "sentences",
+			mode:   BufferSentences,
+			chunks: deltas,
+			want:   []string{"Hello world! ", "How are"},
+		},
+		{
+			name:   "newline ends sentence",
+			mode:   BufferSentences,
+			chunks: []*ChatResponse{chunk("- one\n- t"), chunk("wo")},
+			want:   []string{"- one\n", "- two"},
+		},
+		{
+			name:   "decimal point is not a sentence end",
+			mode:   BufferSentences,
+			chunks: []*ChatResponse{chunk("Pi is 3."), chunk("14. Next")},
+			want:   []string{"Pi is 3.14. ", "Next"},
+		},
+		{
+			name: "finish reason releases held text",
+			mode: BufferSentences,
+			chunks: []*ChatResponse{
+				chunk("Done"),
+				{Response: Response{FinishReason: FinishReasonStop}},
+			},
+			want: []string{"Done"},
+		},
+		{
+			name:   "error releases held text first",
+			mode:   BufferWords,
+			chunks: []*ChatResponse{chunk("Hel"), {Response: Response{Error: boom}}},
+			want:   []string{"Hel", "error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for resp := range BufferStream(context.Background(), streamOf(tt.chunks...), tt.mode, 0) {
+				if resp.Error != nil {
+					got = append(got, "error")
+					continue
+				}
+				got = append(got, resp.Message.Content)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunks = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBufferStream_KeepsMetadata(t *testing.T) {
+	src := streamOf(
+		&ChatResponse{Response: Response{ID: "msg_1", Model: "gpt-4", Message: Message{Role: RoleAssistant, Content: "Hi "}}},
+		&ChatResponse{Response: Response{ID: "msg_1", Model: "gpt-4", Message: Message{Content: "there"}}},
+		&ChatResponse{Response: Response{FinishReason: FinishReasonStop, Usage: Usage{CompletionTokens: 2}}},
+	)
+	resp, err := CollectStream(BufferStream(context.Background(), src, BufferWords, 0))
+	if err != nil {
+		t.Fatalf("CollectStream() error = %v", err)
+	}
+	if resp.ID != "msg_1" || resp.Model != "gpt-4" || resp.Message.Content != "Hi there" ||
+		resp.FinishReason != FinishReasonStop || resp.Usage.CompletionTokens != 2 {
+		t.Errorf("buffered stream collected to %+v", resp.Response)
+	}
+}
+
+func TestBufferStream_FlushInterval(t *testing.T) {
+	src := make(chan *ChatResponse)
+	go func() {
+		defer close(src)
+		src <- chunk("Hel")
+		time.Sleep(200 * time.Millisecond)
+		src <- chunk("lo")
+	}()
+
+	out := BufferStream(context.Background(), src, BufferWords, 20*time.Millisecond)
+	select {
+	case resp := <-out:
+		if resp.Message.Content != "Hel" {
+			t.Errorf("first chunk = %q, want Hel", resp.Message.Content)
+		}
+	case <-time.After(150 * time.Millisecond):
+		t.Fatal("held text not flushed after the interval")
+	}
+	if resp := <-out; resp == nil || resp.Message.Content != "lo" {
+		t.Errorf("second chunk = %v, want lo", resp)
+	}
+}
+
+func TestFilterStream(t *testing.T) {
+	mask := func(s string) string { return strings.ReplaceAll(s, "darn", "****") }
+
+	tests := []struct {
+		name      string
+		chunks    []*ChatResponse
+		mode      BufferMode
+		transform func(string) string
+		want      []string
+	}{
+		{
+			name:      "pattern split across deltas",
+			chunks:    []*ChatResponse{chunk("Oh da"), chunk("rn it")},
+			mode:      BufferWords,
+			transform: mask,
+			want:      []string{"Oh ", "**** ", "it"},
+		},
+		{
+			name:      "sentence-level transform",
+			chunks:    []*ChatResponse{chunk("See [li"), chunk("nk](x). Done")},
+			mode:      BufferSentences,
+			transform: func(s string) string { return strings.NewReplacer("[", "", "](x)", "").Replace(s) },
+			want:      []string{"See link. ", "Done"},
+		},
+		{
+			name:      "emptied chunks dropped",
+			chunks:    []*ChatResponse{chunk("drop keep")},
+			mode:      BufferWords,
+			transform: func(s string) string { return strings.ReplaceAll(s, "drop ", "") },
+			want:      []string{"keep"},
+		},
+		{
+			name:      "emptied chunk with finish reason kept",
+			chunks:    []*ChatResponse{chunk("drop"), {Response: Response{FinishReason: FinishReasonStop}}},
+			mode:      BufferWords,
+			transform: func(s string) string { return strings.ReplaceAll(s, "drop", "") },
+			want:      []string{""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for resp := range FilterStream(context.Background(), streamOf(tt.chunks...), tt.mode, tt.transform) {
+				got = append(got, resp.Message.Content)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunks = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
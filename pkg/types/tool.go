@@ -0,0 +1,31 @@
+package types
+
+import "errors"
+
+var ErrEmptyToolName = errors.New("tool name cannot be empty")
+
+// Tool describes a function the model may ask the caller to run
+type Tool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+
+	// Parameters is a JSON Schema object describing the tool's arguments
+	Parameters map[string]any `json:"parameters,omitempty"`
+}
+
+// Validate ensures the tool definition is valid
+func (t *Tool) Validate() error {
+	if t.Name == "" {
+		return ErrEmptyToolName
+	}
+	return nil
+}
+
+// ToolCall is a request from the model to run a tool
+type ToolCall struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	// Arguments holds the JSON-encoded arguments chosen by the model
+	Arguments string `json:"arguments"`
+}
@@ -0,0 +1,15 @@
+package types
+
+// Validator checks a chat response. The returned error should say what is
+// wrong clearly enough for the model to correct it.
+type Validator interface {
+	Validate(resp *ChatResponse) error
+}
+
+// ValidatorFunc adapts a function to a Validator
+type ValidatorFunc func(resp *ChatResponse) error
+
+// Validate implements Validator
+func (f ValidatorFunc) Validate(resp *ChatResponse) error {
+	return f(resp)
+}
@@ -0,0 +1,112 @@
+package vectorstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps documents in memory and searches them exhaustively.
+// It suits tests and corpora of up to tens of thousands of documents.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	docs map[string]Document
+	dims int
+}
+
+// NewMemoryStore creates an empty store. Its dimension is set by the first
+// document added.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{docs: make(map[string]Document)}
+}
+
+// Upsert implements Store
+func (s *MemoryStore) Upsert(_ context.Context, docs ...Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dims := s.dims
+	for _, doc := range docs {
+		if len(doc.Embedding) == 0 {
+			return fmt.Errorf("%w: %s", ErrNoEmbedding, doc.ID)
+		}
+		if dims == 0 {
+			dims = len(doc.Embedding)
+		}
+		if len(doc.Embedding) != dims {
+			return fmt.Errorf("%w: document %s has %d dimensions, want %d", ErrDimensionMismatch, doc.ID, len(doc.Embedding), dims)
+		}
+	}
+
+	s.dims = dims
+	for _, doc := range docs {
+		s.docs[doc.ID] = copyDocument(doc)
+	}
+	return nil
+}
+
+// Query implements Store
+func (s *MemoryStore) Query(_ context.Context, q Query) ([]Match, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.dims != 0 && len(q.Embedding) != s.dims {
+		return nil, fmt.Errorf("%w: query has %d dimensions, want %d", ErrDimensionMismatch, len(q.Embedding), s.dims)
+	}
+
+	var matches []Match
+	for _, doc := range s.docs {
+		if !hasMetadata(doc.Metadata, q.Filter) {
+			continue
+		}
+		score := Cosine(q.Embedding, doc.Embedding)
+		if score < q.MinScore {
+			continue
+		}
+		matches = append(matches, Match{Document: copyDocument(doc), Score: score})
+	}
+
+	// Ties are broken by ID so results are stable
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	if len(matches) > q.topK() {
+		matches = matches[:q.topK()]
+	}
+	return matches, nil
+}
+
+// Delete implements Store
+func (s *MemoryStore) Delete(_ context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		delete(s.docs, id)
+	}
+	return nil
+}
+
+// Len returns the number of documents stored
+func (s *MemoryStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.docs)
+}
+
+// copyDocument copies doc's embedding and metadata so callers cannot
+// change the stored document
+func copyDocument(doc Document) Document {
+	doc.Embedding = append([]float32(nil), doc.Embedding...)
+	if doc.Metadata != nil {
+		metadata := make(map[string]string, len(doc.Metadata))
+		for k, v := range doc.Metadata {
+			metadata[k] = v
+		}
+		doc.Metadata = metadata
+	}
+	return doc
+}
@@ -0,0 +1,182 @@
+package vectorstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PgVectorStore keeps documents in a Postgres table using the pgvector
+// extension. The driver is chosen by the caller, which keeps this package
+// free of database dependencies.
+type PgVectorStore struct {
+	db    *sql.DB
+	table string
+}
+
+// NewPgVectorStore creates a store writing to table. Call CreateTable to
+// create the table if it does not exist.
+func NewPgVectorStore(db *sql.DB, table string) *PgVectorStore {
+	return &PgVectorStore{db: db, table: table}
+}
+
+// CreateTable creates the pgvector extension, the table for embeddings of
+// dims dimensions, and an HNSW index for cosine distance, if they do not
+// exist
+func (s *PgVectorStore) CreateTable(ctx context.Context, dims int) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE EXTENSION IF NOT EXISTS vector`); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.table+` (
+		id TEXT PRIMARY KEY,
+		content TEXT NOT NULL,
+		metadata JSONB NOT NULL,
+		embedding vector(`+strconv.Itoa(dims)+`) NOT NULL
+	)`); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS `+s.table+`_embedding_idx ON `+s.table+` USING hnsw (embedding vector_cosine_ops)`)
+	return err
+}
+
+// Upsert implements Store. The documents are written in one transaction.
+func (s *PgVectorStore) Upsert(ctx context.Context, docs ...Document) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("storing documents: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, doc := range docs {
+		if len(doc.Embedding) == 0 {
+			return fmt.Errorf("%w: %s", ErrNoEmbedding, doc.ID)
+		}
+		metadata, err := encodeMetadata(doc.Metadata)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO `+s.table+` (id, content, metadata, embedding)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (id) DO UPDATE SET content = EXCLUDED.content, metadata = EXCLUDED.metadata, embedding = EXCLUDED.embedding`,
+			doc.ID, doc.Content, metadata, encodeVector(doc.Embedding)); err != nil {
+			return fmt.Errorf("storing document %s: %w", doc.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("storing documents: %w", err)
+	}
+	return nil
+}
+
+// Query implements Store. Similarity, filtering and ordering happen in the
+// database.
+func (s *PgVectorStore) Query(ctx context.Context, q Query) ([]Match, error) {
+	filter, err := encodeMetadata(q.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, content, metadata, embedding::text, 1 - (embedding <=> $1) AS score
+		FROM `+s.table+`
+		WHERE metadata @> $2 AND 1 - (embedding <=> $1) >= $3
+		ORDER BY embedding <=> $1
+		LIMIT $4`,
+		encodeVector(q.Embedding), filter, q.MinScore, q.topK())
+	if err != nil {
+		return nil, fmt.Errorf("querying documents: %w", err)
+	}
+	defer rows.Close()
+
+	var matches []Match
+	for rows.Next() {
+		var (
+			m                   Match
+			metadata, embedding string
+		)
+		if err := rows.Scan(&m.ID, &m.Content, &metadata, &embedding, &m.Score); err != nil {
+			return nil, fmt.Errorf("querying documents: %w", err)
+		}
+		if err := json.Unmarshal([]byte(metadata), &m.Metadata); err != nil {
+			return nil, fmt.Errorf("decoding metadata of %s: %w", m.ID, err)
+		}
+		if m.Embedding, err = decodeVector(embedding); err != nil {
+			return nil, fmt.Errorf("decoding embedding of %s: %w", m.ID, err)
+		}
+		matches = append(matches, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("querying documents: %w", err)
+	}
+	return matches, nil
+}
+
+// Delete implements Store
+func (s *PgVectorStore) Delete(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "$" + strconv.Itoa(i+1)
+		args[i] = id
+	}
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE id IN (`+strings.Join(placeholders, ", ")+`)`, args...)
+	if err != nil {
+		return fmt.Errorf("deleting documents: %w", err)
+	}
+	return nil
+}
+
+// encodeMetadata renders metadata as a JSON object, never null
+func encodeMetadata(metadata map[string]string) (string, error) {
+	if metadata == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return "", fmt.Errorf("encoding metadata: %w", err)
+	}
+	return string(b), nil
+}
+
+// encodeVector renders an embedding in pgvector's text format, "[1,2,3]"
+func encodeVector(v []float32) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, x := range v {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(x), 'g', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// decodeVector parses an embedding in pgvector's text format
+func decodeVector(s string) ([]float32, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '[' || s[len(s)-1] != ']' {
+		return nil, fmt.Errorf("invalid vector %q", s)
+	}
+	s = s[1 : len(s)-1]
+	if s == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(s, ",")
+	v := make([]float32, len(parts))
+	for i, p := range parts {
+		x, err := strconv.ParseFloat(strings.TrimSpace(p), 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vector element %q: %w", p, err)
+		}
+		v[i] = float32(x)
+	}
+	return v, nil
+}
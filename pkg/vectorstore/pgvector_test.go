@@ -0,0 +1,40 @@
+package vectorstore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVectorEncoding(t *testing.T) {
+	tests := []struct {
+		name string
+		v    []float32
+		want string
+	}{
+		{"empty", nil, "[]"},
+		{"values", []float32{1, -0.5, 0.125}, "[1,-0.5,0.125]"},
+		{"small", []float32{1e-7}, "[1e-07]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := encodeVector(tt.v)
+			if got != tt.want {
+				t.Errorf("encodeVector() = %q, want %q", got, tt.want)
+			}
+			back, err := decodeVector(got)
+			if err != nil {
+				t.Fatalf("decodeVector() error = %v", err)
+			}
+			if !reflect.DeepEqual(back, tt.v) {
+				t.Errorf("decodeVector() = %v, want %v", back, tt.v)
+			}
+		})
+	}
+
+	for _, bad := range []string{"", "1,2", "[1,x]"} {
+		if _, err := decodeVector(bad); err == nil {
+			t.Errorf("decodeVector(%q) error = nil", bad)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Package vectorstore stores embedded documents and finds those nearest a
+// query, as the retrieval step of RAG workflows.
+//
+//	idx := vectorstore.NewIndex(vectorstore.NewMemoryStore(), embedder)
+//	err := idx.Add(ctx, vectorstore.Document{ID: "faq-1", Content: "Refunds take 5 days."})
+//	matches, err := idx.Search(ctx, "how long do refunds take?", 3, nil)
+package vectorstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	// ErrDimensionMismatch is returned when an embedding's length differs
+	// from the store's
+	ErrDimensionMismatch = errors.New("embedding dimension mismatch")
+	// ErrNoEmbedding is returned when a document is stored without one
+	ErrNoEmbedding = errors.New("document has no embedding")
+)
+
+// Document is a piece of text and its embedding
+type Document struct {
+	ID        string
+	Content   string
+	Metadata  map[string]string
+	Embedding []float32
+}
+
+// Match is a document found by a query and its cosine similarity to the
+// query, from -1 to 1
+type Match struct {
+	Document
+	Score float64
+}
+
+// Query finds the documents nearest an embedding
+type Query struct {
+	Embedding []float32
+	// TopK is the most matches returned; zero means 10
+	TopK int
+	// Filter keeps only documents whose metadata has all these values
+	Filter map[string]string
+	// MinScore drops matches less similar than this
+	MinScore float64
+}
+
+// topK returns the match limit, applying the default
+func (q Query) topK() int {
+	if q.TopK <= 0 {
+		return 10
+	}
+	return q.TopK
+}
+
+// Store holds embedded documents
+type Store interface {
+	// Upsert adds documents, replacing any with the same ID
+	Upsert(ctx context.Context, docs ...Document) error
+	// Query returns the documents nearest q.Embedding, most similar first
+	Query(ctx context.Context, q Query) ([]Match, error)
+	// Delete removes documents by ID. Unknown IDs are ignored.
+	Delete(ctx context.Context, ids ...string) error
+}
+
+// Embedder turns texts into embeddings, one per text in order
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// EmbedderFunc adapts a function to the Embedder interface
+type EmbedderFunc func(ctx context.Context, texts []string) ([][]float32, error)
+
+// Embed implements Embedder
+func (f EmbedderFunc) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return f(ctx, texts)
+}
+
+// Index embeds documents and queries with an Embedder and keeps them in a
+// Store
+type Index struct {
+	store    Store
+	embedder Embedder
+}
+
+// NewIndex creates an index over store using embedder
+func NewIndex(store Store, embedder Embedder) *Index {
+	return &Index{store: store, embedder: embedder}
+}
+
+// Add embeds the documents that have no embedding, in one batch, and
+// upserts them all
+func (i *Index) Add(ctx context.Context, docs ...Document) error {
+	var (
+		texts   []string
+		pending []int
+	)
+	for n, doc := range docs {
+		if len(doc.Embedding) == 0 {
+			texts = append(texts, doc.Content)
+			pending = append(pending, n)
+		}
+	}
+
+	if len(texts) > 0 {
+		embeddings, err := i.embedder.Embed(ctx, texts)
+		if err != nil {
+			return fmt.Errorf("embedding documents: %w", err)
+		}
+		if len(embeddings) != len(texts) {
+			return fmt.Errorf("embedding documents: got %d embeddings for %d texts", len(embeddings), len(texts))
+		}
+		docs = append([]Document(nil), docs...)
+		for n, at := range pending {
+			docs[at].Embedding = embeddings[n]
+		}
+	}
+
+	return i.store.Upsert(ctx, docs...)
+}
+
+// Search returns the topK documents nearest text that match filter
+func (i *Index) Search(ctx context.Context, text string, topK int, filter map[string]string) ([]Match, error) {
+	embeddings, err := i.embedder.Embed(ctx, []string{text})
+	if err != nil {
+		return nil, fmt.Errorf("embedding query: %w", err)
+	}
+	if len(embeddings) != 1 {
+		return nil, fmt.Errorf("embedding query: got %d embeddings for 1 text", len(embeddings))
+	}
+	return i.store.Query(ctx, Query{Embedding: embeddings[0], TopK: topK, Filter: filter})
+}
+
+// Delete removes documents by ID
+func (i *Index) Delete(ctx context.Context, ids ...string) error {
+	return i.store.Delete(ctx, ids...)
+}
+
+// Cosine returns the cosine similarity of two embeddings of equal length,
+// or 0 if either is all zeros
+func Cosine(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// hasMetadata reports whether metadata holds every key and value in want
+func hasMetadata(metadata, want map[string]string) bool {
+	for k, v := range want {
+		if metadata[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,142 @@
+package vectorstore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// keywordEmbedder embeds texts by counting the words "cat", "dog" and
+// "fish", so similarity follows the animals mentioned
+func keywordEmbedder(calls *int) Embedder {
+	return EmbedderFunc(func(_ context.Context, texts []string) ([][]float32, error) {
+		*calls++
+		out := make([][]float32, len(texts))
+		for i, text := range texts {
+			text = strings.ToLower(text)
+			out[i] = []float32{
+				float32(strings.Count(text, "cat")),
+				float32(strings.Count(text, "dog")),
+				float32(strings.Count(text, "fish")),
+			}
+		}
+		return out, nil
+	})
+}
+
+func TestIndex_Search(t *testing.T) {
+	ctx := context.Background()
+	var calls int
+	idx := NewIndex(NewMemoryStore(), keywordEmbedder(&calls))
+
+	err := idx.Add(ctx,
+		Document{ID: "cats", Content: "cats and more cats", Metadata: map[string]string{"kind": "pet"}},
+		Document{ID: "dogs", Content: "dogs are loyal", Metadata: map[string]string{"kind": "pet"}},
+		Document{ID: "fish", Content: "fish swim", Metadata: map[string]string{"kind": "food"}},
+		Document{ID: "given", Content: "ignored", Embedding: []float32{1, 1, 0}},
+	)
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Add() made %d embedding calls, want 1 batch", calls)
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		topK   int
+		filter map[string]string
+		want   []string
+	}{
+		{"nearest first", "a cat", 2, nil, []string{"cats", "given"}},
+		{"top k", "dog", 1, nil, []string{"dogs"}},
+		{"filter", "fish", 3, map[string]string{"kind": "pet"}, []string{"cats", "dogs"}},
+		{"no match", "fish", 3, map[string]string{"kind": "toy"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := idx.Search(ctx, tt.query, tt.topK, tt.filter)
+			if err != nil {
+				t.Fatalf("Search() error = %v", err)
+			}
+			var got []string
+			for _, m := range matches {
+				got = append(got, m.ID)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Search() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if err := idx.Delete(ctx, "cats", "missing"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	matches, _ := idx.Search(ctx, "cat", 1, nil)
+	if len(matches) != 1 || matches[0].ID != "given" {
+		t.Errorf("Search() after Delete() = %+v, want given", matches)
+	}
+}
+
+func TestMemoryStore_Upsert(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	if err := store.Upsert(ctx, Document{ID: "a", Content: "v1", Embedding: []float32{1, 0}}); err != nil {
+		t.Fatalf("Upsert() error = %v", err)
+	}
+	if err := store.Upsert(ctx, Document{ID: "a", Content: "v2", Embedding: []float32{0, 1}}); err != nil {
+		t.Fatalf("Upsert() error = %v", err)
+	}
+	if store.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", store.Len())
+	}
+
+	matches, err := store.Query(ctx, Query{Embedding: []float32{0, 1}, MinScore: 0.5})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(matches) != 1 || matches[0].Content != "v2" || matches[0].Score < 0.999 {
+		t.Errorf("Query() = %+v, want the replaced document", matches)
+	}
+
+	matches[0].Embedding[0] = 9
+	again, _ := store.Query(ctx, Query{Embedding: []float32{0, 1}})
+	if again[0].Embedding[0] != 0 {
+		t.Error("changing a match changed the stored document")
+	}
+
+	if err := store.Upsert(ctx, Document{ID: "b", Embedding: []float32{1, 2, 3}}); !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("Upsert() with wrong dimensions error = %v, want ErrDimensionMismatch", err)
+	}
+	if err := store.Upsert(ctx, Document{ID: "c"}); !errors.Is(err, ErrNoEmbedding) {
+		t.Errorf("Upsert() without embedding error = %v, want ErrNoEmbedding", err)
+	}
+	if _, err := store.Query(ctx, Query{Embedding: []float32{1}}); !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("Query() with wrong dimensions error = %v, want ErrDimensionMismatch", err)
+	}
+}
+
+func TestCosine(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float64
+	}{
+		{"identical", []float32{1, 2}, []float32{2, 4}, 1},
+		{"orthogonal", []float32{1, 0}, []float32{0, 1}, 0},
+		{"opposite", []float32{1, 0}, []float32{-1, 0}, -1},
+		{"zero", []float32{0, 0}, []float32{1, 0}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Cosine(tt.a, tt.b); got < tt.want-1e-9 || got > tt.want+1e-9 {
+				t.Errorf("Cosine() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+// Package webhook delivers signed event notifications to other services,
+// retrying failed deliveries with backoff.
+//
+// Each request carries an X-Webhook-Signature header of the form
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">", which receivers
+// check with Verify.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SignatureHeader carries the delivery's timestamp and signature
+	SignatureHeader = "X-Webhook-Signature"
+	// IDHeader carries the event ID, so receivers can drop redeliveries
+	IDHeader = "X-Webhook-ID"
+
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Second
+	defaultMaxBackoff  = time.Minute
+	defaultTimeout     = 10 * time.Second
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrSignatureExpired = errors.New("webhook signature expired")
+	ErrSenderClosed     = errors.New("webhook sender is closed")
+)
+
+// Event is the JSON body of a delivery
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// NewEvent creates an event of the given type with a random ID
+func NewEvent(eventType string, data any) Event {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return Event{
+		ID:        "evt_" + hex.EncodeToString(b),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+}
+
+// Sender posts events to one URL
+type Sender struct {
+	url         string
+	secret      []byte
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	onError     func(Event, error)
+	now         func() time.Time
+
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Option configures a Sender
+type Option func(*Sender)
+
+// WithHTTPClient sets the client used for deliveries. Defaults to a client
+// with a 10 second timeout.
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *Sender) {
+		s.client = client
+	}
+}
+
+// WithRetry sets the attempts per delivery and the exponential backoff
+// between them. Defaults to 5 attempts starting at one second, capped at a
+// minute.
+func WithRetry(maxAttempts int, backoff, maxBackoff time.Duration) Option {
+	return func(s *Sender) {
+		s.maxAttempts = maxAttempts
+		s.backoff = backoff
+		s.maxBackoff = maxBackoff
+	}
+}
+
+// WithErrorHandler calls fn when a background delivery fails for good
+func WithErrorHandler(fn func(Event, error)) Option {
+	return func(s *Sender) {
+		s.onError = fn
+	}
+}
+
+// NewSender creates a sender posting to url, signing with secret
+func NewSender(url, secret string, opts ...Option) *Sender {
+	s := &Sender{
+		url:         url,
+		secret:      []byte(secret),
+		client:      &http.Client{Timeout: defaultTimeout},
+		maxAttempts: defaultMaxAttempts,
+		backoff:     defaultBackoff,
+		maxBackoff:  defaultMaxBackoff,
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.maxAttempts < 1 {
+		s.maxAttempts = 1
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// Send delivers ev, retrying server errors, rate limits and network
+// failures. It returns the last error once the attempts are used up.
+func (s *Sender) Send(ctx context.Context, ev Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("encoding webhook event: %w", err)
+	}
+
+	delay := s.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(ctx, ev.ID, body)
+		if err == nil || !retry || attempt >= s.maxAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+		if s.maxBackoff > 0 && delay > s.maxBackoff {
+			delay = s.maxBackoff
+		}
+	}
+}
+
+// Deliver sends ev in the background. Failures go to the error handler.
+func (s *Sender) Deliver(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		if s.onError != nil {
+			go s.onError(ev, ErrSenderClosed)
+		}
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.Send(s.ctx, ev); err != nil && s.onError != nil {
+			s.onError(ev, err)
+		}
+	}()
+}
+
+// Close waits for background deliveries to finish, or gives up on their
+// remaining retries when ctx is done
+func (s *Sender) Close(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure may be
+// retried
+func (s *Sender) post(ctx context.Context, id string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("creating webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IDHeader, id)
+	req.Header.Set(SignatureHeader, Sign(s.secret, s.now(), body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("sending webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+}
+
+// Sign returns the signature header value for body sent at t
+func Sign(secret []byte, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac(secret, ts, body))
+}
+
+// Verify checks a signature header against body. Signatures older than
+// tolerance are rejected to stop replays; a zero tolerance accepts any age.
+func Verify(secret []byte, header string, body []byte, tolerance time.Duration) error {
+	var ts string
+	var sigs [][]byte
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			if sig, err := hex.DecodeString(v); err == nil {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return ErrInvalidSignature
+	}
+
+	want := mac(secret, ts, body)
+	valid := false
+	for _, sig := range sigs {
+		if hmac.Equal(sig, want) {
+			valid = true
+		}
+	}
+	if !valid {
+		return ErrInvalidSignature
+	}
+
+	if tolerance > 0 {
+		if age := time.Since(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+			return ErrSignatureExpired
+		}
+	}
+	return nil
+}
+
+// mac is the HMAC-SHA256 of "<ts>.<body>"
+func mac(secret []byte, ts string, body []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(ts))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
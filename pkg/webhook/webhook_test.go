@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	secret := []byte("s3cret")
+	body := []byte(`{"id":"evt_1"}`)
+	now := time.Now()
+	header := Sign(secret, now, body)
+
+	tests := []struct {
+		name      string
+		secret    []byte
+		header    string
+		body      []byte
+		tolerance time.Duration
+		want      error
+	}{
+		{"valid", secret, header, body, time.Minute, nil},
+		{"wrong secret", []byte("other"), header, body, time.Minute, ErrInvalidSignature},
+		{"tampered body", secret, header, []byte(`{"id":"evt_2"}`), time.Minute, ErrInvalidSignature},
+		{"malformed header", secret, "v1=abc", body, time.Minute, ErrInvalidSignature},
+		{"expired", secret, Sign(secret, now.Add(-time.Hour), body), body, time.Minute, ErrSignatureExpired},
+		{"any age", secret, Sign(secret, now.Add(-time.Hour), body), body, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Verify(tt.secret, tt.header, tt.body, tt.tolerance); !errors.Is(err, tt.want) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// receiver is a webhook endpoint answering with scripted statuses, then 200
+type receiver struct {
+	statuses []int
+	calls    int32
+
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	n := int(atomic.AddInt32(&r.calls, 1))
+	body, _ := io.ReadAll(req.Body)
+	if err := Verify([]byte("s3cret"), req.Header.Get(SignatureHeader), body, time.Minute); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if n <= len(r.statuses) {
+		w.WriteHeader(r.statuses[n-1])
+		return
+	}
+
+	var ev Event
+	json.Unmarshal(body, &ev)
+	if ev.ID != req.Header.Get(IDHeader) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.mu.Lock()
+	r.events = append(r.events, ev)
+	r.mu.Unlock()
+}
+
+func TestSender_Send(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		wantErr   bool
+		wantCalls int32
+	}{
+		{"delivered", nil, false, 1},
+		{"retries server errors", []int{500, 503}, false, 3},
+		{"retries rate limits", []int{429}, false, 2},
+		{"gives up", []int{500, 500, 500}, true, 3},
+		{"client error not retried", []int{400}, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &receiver{statuses: tt.statuses}
+			srv := httptest.NewServer(r)
+			defer srv.Close()
+
+			s := NewSender(srv.URL, "s3cret", WithRetry(3, time.Millisecond, time.Millisecond))
+			err := s.Send(context.Background(), NewEvent("job.succeeded", map[string]string{"id": "job_1"}))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if r.calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", r.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestSender_Deliver(t *testing.T) {
+	r := &receiver{statuses: []int{500}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	var failed []Event
+	var mu sync.Mutex
+	s := NewSender(srv.URL, "s3cret",
+		WithRetry(2, time.Millisecond, time.Millisecond),
+		WithErrorHandler(func(ev Event, err error) {
+			mu.Lock()
+			failed = append(failed, ev)
+			mu.Unlock()
+		}),
+	)
+
+	s.Deliver(NewEvent("batch.completed", nil))
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if len(r.events) != 1 || r.events[0].Type != "batch.completed" {
+		t.Errorf("received = %+v, want one batch.completed event", r.events)
+	}
+
+	s.Deliver(NewEvent("late", nil))
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failed) != 1 || failed[0].Type != "late" {
+		t.Errorf("failed = %+v, want the event sent after Close to fail", failed)
+	}
+}